@@ -0,0 +1,135 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Copyright 2018 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows gnet_net
+
+package gnet
+
+import (
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/panlibin/gnet/pool/bytebuffer"
+)
+
+func (svr *server) listenerRun() {
+	var err error
+	defer func() {
+		if err != ErrServerShutdown && atomic.LoadInt32(&svr.acceptStopped) == 1 {
+			// The listener has been closed by Drain.
+			return
+		}
+		svr.fail(err)
+		svr.logger.Printf("%v", err)
+		svr.signalShutdown()
+	}()
+	var packet [0x10000]byte
+	for {
+		if svr.ln.pconn != nil {
+			// Read data from UDP socket.
+			n, addr, e := svr.ln.pconn.ReadFrom(packet[:])
+			if e != nil {
+				err = e
+				return
+			}
+			if fw := svr.opts.Firewall; fw != nil {
+				if ip := addrIP(addr); ip != nil && !fw.Allowed(ip) {
+					atomic.AddInt64(&svr.stats.firewallDenied, 1)
+					continue
+				}
+			}
+			buf := bytebuffer.Get()
+			_, _ = buf.Write(packet[:n])
+
+			el := svr.subLoopGroup.next()
+			el.handOff(&udpIn{newUDPConn(el, svr.ln.lnaddr, normalizeAddr(addr), buf)})
+		} else {
+			// Accept TCP socket.
+			conn, e := svr.ln.ln.Accept()
+			if e != nil {
+				err = e
+				return
+			}
+			if fw := svr.opts.Firewall; fw != nil {
+				if ip := addrIP(conn.RemoteAddr()); ip != nil && !fw.Allowed(ip) {
+					sniffError(conn.Close())
+					atomic.AddInt64(&svr.stats.firewallDenied, 1)
+					continue
+				}
+			}
+			el := svr.subLoopGroup.next()
+			if svr.shedder != nil {
+				if reason, overloaded := svr.shedder.overloaded(len(el.ch), nil); overloaded {
+					svr.rejectConn(conn, reason)
+					if svr.shedder.opts.DropBatch > 0 {
+						el.ch <- func() error {
+							return el.shedConnections(reason)
+						}
+					}
+					continue
+				}
+			}
+			fd := connFd(conn)
+			if setsockopt := svr.opts.ConnSocketOptions; setsockopt != nil && fd >= 0 {
+				if e := setsockopt(fd); e != nil {
+					svr.logger.Printf("failed to set up the socket options of %s, error:%v\n", conn.RemoteAddr(), e)
+					sniffError(conn.Close())
+					continue
+				}
+			}
+			switch svr.eventHandler.OnAccept(normalizeAddr(conn.RemoteAddr()), fd) {
+			case Close:
+				sniffError(conn.Close())
+				continue
+			case Shutdown:
+				sniffError(conn.Close())
+				err = ErrServerShutdown
+				return
+			}
+			c := newTCPConn(conn, el)
+			el.handOff(c)
+			go func() {
+				var packet [0x10000]byte
+				for {
+					if atomic.LoadInt32(&c.paused) == 1 {
+						<-c.readGate
+					}
+					n, err := c.conn.Read(packet[:])
+					if err != nil {
+						_ = c.conn.SetReadDeadline(time.Time{})
+						if atomic.LoadInt32(&c.done) == 2 {
+							// Detached, the data read so far has been queued ahead of this job.
+							el.ch <- func() error {
+								close(c.detached.ready)
+								return nil
+							}
+							return
+						}
+						el.ch <- &stderr{c, err}
+						return
+					}
+					buf := bytebuffer.Get()
+					_, _ = buf.Write(packet[:n])
+					el.ch <- &tcpIn{c, buf}
+				}
+			}()
+		}
+	}
+}
+
+// connFd returns the socket handle of the connection, -1 if there is none.
+func connFd(conn net.Conn) (fd int) {
+	fd = -1
+	if sc, ok := conn.(syscall.Conn); ok {
+		if rc, err := sc.SyscallConn(); err == nil {
+			_ = rc.Control(func(s uintptr) {
+				fd = int(s)
+			})
+		}
+	}
+	return
+}
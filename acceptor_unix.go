@@ -3,31 +3,90 @@
 // license that can be found in the LICENSE file.
 
 // +build linux darwin netbsd freebsd openbsd dragonfly
+// +build !gnet_net
 
 package gnet
 
-import "golang.org/x/sys/unix"
+import (
+	"sync/atomic"
 
-func (svr *server) acceptNewConnection(fd int) error {
-	nfd, sa, err := unix.Accept(fd)
-	if err != nil {
-		if err == unix.EAGAIN {
-			return nil
+	"github.com/panlibin/gnet/internal/netpoll"
+	"golang.org/x/sys/unix"
+)
+
+// acceptNewConnection accepts the new connections on the acceptor and hands them over to the event-loops.
+func (svr *server) acceptNewConnection(acc *eventloop) error {
+	var n int
+	defer func() { svr.stats.recordAccepts(n, svr.opts.AcceptBatch) }()
+	for ; n < svr.opts.AcceptBatch; n++ {
+		nfd, sa, err := acc.ln.accept()
+		if err != nil {
+			if err == unix.EAGAIN {
+				return nil
+			}
+			return acc.acceptFailed(err)
+		}
+		acc.acceptFailures = 0
+		el := svr.subLoopGroup.next()
+		if !svr.admit(nfd, sa, el) {
+			continue
 		}
-		return err
+		if err := unix.SetNonblock(nfd, true); err != nil {
+			return err
+		}
+		c := newTCPConn(nfd, el, sa)
+		_ = el.poller.Trigger(func() (err error) {
+			if err = el.poller.AddRead(nfd); err != nil {
+				return
+			}
+			el.connections[nfd] = c
+			err = el.loopOpen(c)
+			return
+		})
 	}
-	if err := unix.SetNonblock(nfd, true); err != nil {
-		return err
+	return nil
+}
+
+// admit decides whether a newly accepted connection is going to be served by the given event-loop,
+// the connection is closed if it is rejected.
+func (svr *server) admit(fd int, sa unix.Sockaddr, el *eventloop) bool {
+	if fw := svr.opts.Firewall; fw != nil {
+		if ip := netpoll.SockaddrIP(sa); ip != nil && !fw.Allowed(ip) {
+			sniffError(unix.Close(fd))
+			atomic.AddInt64(&svr.stats.firewallDenied, 1)
+			return false
+		}
 	}
-	el := svr.subLoopGroup.next()
-	c := newTCPConn(nfd, el, sa)
-	_ = el.poller.Trigger(func() (err error) {
-		if err = el.poller.AddRead(nfd); err != nil {
-			return
+	if svr.shedder != nil {
+		if reason, overloaded := svr.shedder.overloaded(el.poller.QueueDepth(), svr.acceptBacklog); overloaded {
+			svr.rejectConn(fd, sa, reason)
+			if svr.shedder.opts.DropBatch > 0 {
+				_ = el.poller.TriggerUrgent(func() error {
+					return el.shedConnections(reason)
+				})
+			}
+			return false
 		}
-		el.connections[nfd] = c
-		err = el.loopOpen(c)
-		return
-	})
-	return nil
+	}
+	if mark := svr.opts.Mark; mark != 0 {
+		// The accepted sockets do not inherit the mark of the listener.
+		_ = netpoll.SetMark(fd, mark)
+	}
+	if setsockopt := svr.opts.ConnSocketOptions; setsockopt != nil {
+		if err := setsockopt(fd); err != nil {
+			svr.logger.Printf("failed to set up the socket options of fd:%d, error:%v\n", fd, err)
+			sniffError(unix.Close(fd))
+			return false
+		}
+	}
+	switch svr.eventHandler.OnAccept(sockaddrToAddr(sa), fd) {
+	case Close:
+		sniffError(unix.Close(fd))
+		return false
+	case Shutdown:
+		sniffError(unix.Close(fd))
+		svr.signalShutdown()
+		return false
+	}
+	return true
 }
@@ -0,0 +1,63 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "net"
+
+// AddrTupleLen is the length of the 4-tuple returned by AddrTuple.
+const AddrTupleLen = 2 * (net.IPv6len + 2)
+
+// AddrTuple returns the raw 4-tuple of the connection for handlers that hash addresses: the 16-byte local IP
+// address and the 2-byte big-endian local port followed by the remote ones, IPv4 addresses are in their
+// IPv4-mapped form. It returns nil unless both addresses are IP addresses.
+//
+// The 4-tuple of a connection of a server on unix is laid out once when the connection is opened, it is
+// returned without allocating and must not be modified. The local address of a TCP connection is that of
+// the listener unless the server is transparent.
+func AddrTuple(c Conn) []byte {
+	if c, ok := c.(interface {
+		addrTuple() []byte
+	}); ok {
+		return c.addrTuple()
+	}
+	var tuple [AddrTupleLen]byte
+	if !putAddrTuple(&tuple, c.LocalAddr(), c.RemoteAddr()) {
+		return nil
+	}
+	return tuple[:]
+}
+
+// putAddrTuple lays the 4-tuple of the addresses out in tuple, it reports whether both are IP addresses.
+func putAddrTuple(tuple *[AddrTupleLen]byte, local, remote net.Addr) bool {
+	return putAddr(tuple[:AddrTupleLen/2], local) && putAddr(tuple[AddrTupleLen/2:], remote)
+}
+
+func putAddr(b []byte, addr net.Addr) bool {
+	var port int
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		port = addr.Port
+	case *net.UDPAddr:
+		port = addr.Port
+	default:
+		return false
+	}
+	ip := addrIP(addr)
+	switch len(ip) {
+	case net.IPv4len:
+		// The IPv4-mapped form is spelled out, ip.To16 would allocate.
+		copy(b, v4InV6Prefix)
+		copy(b[12:], ip)
+	case net.IPv6len:
+		copy(b, ip)
+	default:
+		return false
+	}
+	b[net.IPv6len] = byte(port >> 8)
+	b[net.IPv6len+1] = byte(port)
+	return true
+}
+
+var v4InV6Prefix = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff}
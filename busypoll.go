@@ -0,0 +1,21 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "time"
+
+// BusyPoll sets up busy polling for the deployments where a few microseconds of latency matter more than
+// the CPU usage, e.g. trading systems.
+type BusyPoll struct {
+	// Budget is how long an event-loop spins on non-blocking polls before it blocks in waiting for network-events,
+	// which costs a busy CPU core per event-loop while it is idle, zero disables spinning. The longer one of it
+	// and IdleStrategy.Spin wins.
+	Budget time.Duration
+
+	// Socket sets up SO_BUSY_POLL of the connections and UDP listeners, so that the kernel busy polls the device
+	// queue for the duration on receives, Linux only. Raising it requires CAP_NET_ADMIN, zero leaves it to
+	// net.core.busy_read.
+	Socket time.Duration
+}
@@ -0,0 +1,421 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 30 * time.Second
+	clientReadBufferSize  = 64 * 1024
+)
+
+// ReconnectPolicy decides how a Client reconnects, the delay before each attempt grows exponentially from
+// InitialBackoff up to MaxBackoff and is randomized by Jitter so that clients do not reconnect in lockstep.
+type ReconnectPolicy struct {
+	// InitialBackoff is the delay before the first attempt, defaults to 100ms.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between attempts, defaults to 30s.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor the delay grows by after each failed attempt, defaults to 2.
+	Multiplier float64
+
+	// Jitter is the fraction of each delay which is randomized away, within [0, 1].
+	Jitter float64
+
+	// MaxAttempts is the number of failed attempts in a row after which the client gives up, zero means no limit.
+	MaxAttempts int
+}
+
+// backoff returns the delay before the given attempt, starting from 1.
+func (p ReconnectPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 1; i < attempt && d < float64(p.MaxBackoff); i++ {
+		d *= p.Multiplier
+	}
+	if d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d -= d * p.Jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// ClientConfig sets up a Client.
+type ClientConfig struct {
+	// Addr is the address to connect to in the same format as the addr passed to Serve, e.g. "tcp://10.0.0.1:9000".
+	// The "srv" network looks up the SRV records of a name, e.g. "srv://_echo._tcp.example.com", and dials the
+	// targets in the order of their priorities and weights, over UDP if the name has a "_udp" label.
+	Addr string
+
+	// Dialer dials the connections, e.g. the one returned by NewDeviceDialer or NewMarkDialer, a zero net.Dialer is used if nil.
+	Dialer *net.Dialer
+
+	// Targets are the upstream addresses to fail over between instead of Addr, each dial goes to a target drawn
+	// by the weights among the healthy targets, the other targets are dialed in turn if it fails.
+	Targets []ClientTarget
+
+	// TargetCooldown is how long a target is unhealthy after a failed dial, defaults to 5s.
+	TargetCooldown time.Duration
+
+	// Rebalance makes the client draw a target again whenever a target has recovered and move the connection
+	// over to the target drawn, so that the connections of many clients follow the weights of the targets.
+	Rebalance bool
+
+	// Resolver resolves the host names, e.g. a ResolverCache, the addresses of a host are raced Happy Eyeballs
+	// style as the Dialer does, staggered by its FallbackDelay. The host names are resolved by the Dialer if it
+	// is nil, except for the SRV records which are looked up by net.DefaultResolver then.
+	Resolver Resolver
+
+	// Codec encodes the frames written by Write and decodes the inbound frames, BuiltInFrameCodec is used if nil.
+	Codec ICodec
+
+	// Reconnect decides how the client reconnects after the connection has been lost.
+	Reconnect ReconnectPolicy
+
+	// WriteBuffer is the maximum number of encoded bytes buffered by Write while the client is disconnected,
+	// they are flushed once the client has reconnected. Write fails with ErrClientDisconnected beyond it,
+	// zero fails every write while disconnected.
+	WriteBuffer int
+
+	// OnFrame is invoked with every inbound frame on the reading goroutine, the frame is only valid during the call.
+	OnFrame func(c *Client, frame []byte)
+
+	// OnDisconnect is invoked with the error the connection has been lost with, before reconnecting.
+	OnDisconnect func(c *Client, err error)
+
+	// OnReconnect is invoked once the client has reconnected, with the number of attempts it took,
+	// or with zero once it has moved over to another target by rebalancing.
+	OnReconnect func(c *Client, attempts int)
+}
+
+// Client is a managed client connection which reconnects automatically after the connection has been lost,
+// its methods are safe for concurrent use.
+type Client struct {
+	config    ClientConfig
+	mu        sync.Mutex
+	conn      net.Conn        // nil while disconnected
+	codec     *memConn        // the Conn handed to Codec.Encode
+	pending   []byte          // the encoded frames buffered while disconnected
+	targets   []*clientTarget // the upstream addresses to fail over between
+	target    *clientTarget   // the target connected to
+	recovered chan struct{}   // signals that a target has been marked healthy
+	closed    bool
+	err       error
+	closing   chan struct{}
+	done      chan struct{}
+}
+
+// DialClient connects to config.Addr or config.Targets and returns a client managing the connection,
+// the first connection is dialed once without retrying.
+func DialClient(config ClientConfig) (*Client, error) {
+	if config.Dialer == nil {
+		config.Dialer = new(net.Dialer)
+	}
+	if config.Codec == nil {
+		config.Codec = new(BuiltInFrameCodec)
+	}
+	p := &config.Reconnect
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = defaultInitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultMaxBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+	if config.TargetCooldown <= 0 {
+		config.TargetCooldown = defaultTargetCooldown
+	}
+	c := &Client{
+		config:    config,
+		codec:     &memConn{codec: config.Codec},
+		recovered: make(chan struct{}, 1),
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, t := range config.Targets {
+		c.targets = append(c.targets, &clientTarget{ClientTarget: t})
+	}
+	conn, target, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	c.conn, c.target = conn, target
+	go c.run(conn)
+	if len(c.targets) > 1 && config.Rebalance {
+		go c.watchTargets()
+	}
+	return c, nil
+}
+
+// Write encodes a frame and writes it to the connection, the frame is buffered while the client is
+// disconnected, see ClientConfig.WriteBuffer. It fails with the error the client has given up with
+// after the reconnect attempts have been exhausted.
+func (c *Client) Write(frame []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.err != nil:
+		return c.err
+	case c.closed:
+		return ErrConnectionClosed
+	}
+	out, err := c.config.Codec.Encode(c.codec, frame)
+	if err != nil {
+		return err
+	}
+	if c.conn != nil {
+		// A failed write breaks the connection, which the reading goroutine reconnects.
+		_, err = c.conn.Write(out)
+		return err
+	}
+	if len(c.pending)+len(out) > c.config.WriteBuffer {
+		return ErrClientDisconnected
+	}
+	c.pending = append(c.pending, out...)
+	return nil
+}
+
+// Connected reports whether the client is connected.
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+// Err returns the error the client has given up reconnecting with, nil otherwise.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Done returns a channel which is closed once the client has been closed or has given up reconnecting.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close closes the client and its connection, the buffered frames are discarded.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.pending = nil
+	close(c.closing)
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+// dial dials the targets if there are, config.Addr otherwise.
+func (c *Client) dial() (net.Conn, *clientTarget, error) {
+	if len(c.targets) == 0 {
+		conn, err := c.dialAddr(c.config.Addr)
+		return conn, nil, err
+	}
+	c.mu.Lock()
+	order := c.dialOrder()
+	c.mu.Unlock()
+	return c.dialTargets(order)
+}
+
+func (c *Client) dialAddr(addr string) (net.Conn, error) {
+	network, addr := parseAddr(addr)
+	switch {
+	case network == "pipe":
+		return DialPipe(addr)
+	case network == "winpipe":
+		return DialWinPipe(addr, c.config.Dialer.Timeout)
+	case network == "vsock":
+		a, err := parseVsockAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		return DialVsock(a.CID, a.Port)
+	case network == "srv":
+		return c.dialSRV(addr)
+	case c.config.Resolver != nil:
+		return c.dialResolved(network, addr)
+	}
+	return c.config.Dialer.Dial(network, addr)
+}
+
+// lookupContext returns the context of the lookups of a dial.
+func (c *Client) lookupContext() (context.Context, context.CancelFunc) {
+	if timeout := c.config.Dialer.Timeout; timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// dialSRV dials the targets of the SRV records of name until one succeeds.
+func (c *Client) dialSRV(name string) (net.Conn, error) {
+	resolver := c.config.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ctx, cancel := c.lookupContext()
+	_, srvs, err := resolver.LookupSRV(ctx, "", "", name)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	network := "tcp"
+	if strings.Contains(name, "._udp.") {
+		network = "udp"
+	}
+	err = &net.DNSError{Err: "no SRV records", Name: name}
+	for _, srv := range srvs {
+		var conn net.Conn
+		addr := net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		if c.config.Resolver != nil {
+			conn, err = c.dialResolved(network, addr)
+		} else {
+			conn, err = c.config.Dialer.Dial(network, addr)
+		}
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// dialResolved resolves the host of addr by the Resolver and races its addresses, see dialAddrs.
+func (c *Client) dialResolved(network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return c.config.Dialer.Dial(network, addr)
+	}
+	ctx, cancel := c.lookupContext()
+	hosts, err := c.config.Resolver.LookupHost(ctx, host)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	}
+	return dialAddrs(c.config.Dialer, network, hosts, port)
+}
+
+// run reads the connections until the client is closed or gives up reconnecting.
+func (c *Client) run(conn net.Conn) {
+	defer close(c.done)
+	for conn != nil {
+		err := c.read(conn)
+		_ = conn.Close()
+		c.mu.Lock()
+		if c.conn != nil && c.conn != conn {
+			// The connection has been moved over to another target by rebalancing.
+			conn = c.conn
+			c.mu.Unlock()
+			continue
+		}
+		c.conn = nil
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return
+		}
+		if c.config.OnDisconnect != nil {
+			c.config.OnDisconnect(c, err)
+		}
+		conn = c.reconnect()
+	}
+}
+
+// read decodes the inbound frames of a connection until it fails.
+func (c *Client) read(conn net.Conn) error {
+	mc := &memConn{codec: c.config.Codec, localAddr: conn.LocalAddr(), remoteAddr: conn.RemoteAddr()}
+	buf := make([]byte, clientReadBufferSize)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			mc.buffer = append(mc.buffer, buf[:n]...)
+			for {
+				size := mc.BufferLength()
+				frame, e := mc.codec.Decode(mc)
+				if frame == nil {
+					if isFatalDecodeError(e) {
+						return e
+					}
+					break
+				}
+				if mc.BufferLength() >= size {
+					return ErrDecoderStalled
+				}
+				if c.config.OnFrame != nil {
+					c.config.OnFrame(c, frame)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// reconnect dials until it succeeds, the client is closed or the attempts are exhausted.
+func (c *Client) reconnect() net.Conn {
+	var err error
+	p := c.config.Reconnect
+	for attempt := 1; p.MaxAttempts <= 0 || attempt <= p.MaxAttempts; attempt++ {
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-c.closing:
+			timer.Stop()
+			return nil
+		}
+		conn, target, e := c.dial()
+		if err = e; err != nil {
+			continue
+		}
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		if len(c.pending) > 0 {
+			if _, err = conn.Write(c.pending); err != nil {
+				c.mu.Unlock()
+				_ = conn.Close()
+				continue
+			}
+			c.pending = nil
+		}
+		c.conn, c.target = conn, target
+		c.mu.Unlock()
+		if c.config.OnReconnect != nil {
+			c.config.OnReconnect(c, attempt)
+		}
+		return conn
+	}
+	c.mu.Lock()
+	c.err = fmt.Errorf("%w after %d attempts: %v", ErrReconnectFailed, p.MaxAttempts, err)
+	c.closed = true
+	c.pending = nil
+	c.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"errors"
+	"io"
+	"syscall"
+)
+
+// CloseReason tells why a connection has been closed, see Conn.CloseReason.
+type CloseReason int
+
+const (
+	// CloseNone indicates that the connection has not been closed.
+	CloseNone CloseReason = iota
+
+	// CloseServer indicates that the server has closed the connection, by the Close or Shutdown action, by
+	// Conn.Close or by a policy of the server such as Options.ConnMaxLifetime.
+	CloseServer
+
+	// ClosePeer indicates that the peer has closed the connection gracefully.
+	ClosePeer
+
+	// CloseReset indicates that the connection has been reset by the peer, i.e. ECONNRESET or EPIPE.
+	CloseReset
+
+	// CloseWriteTimeout indicates that the outbound data has stalled for too long, see Options.SlowConsumer.
+	CloseWriteTimeout
+
+	// CloseIdle indicates that the connection has been idle for too long, see Options.IdleReaper,
+	// ConnSettings.IdleTimeout, Options.HandshakeTimeout and Options.FirstByteTimeout.
+	CloseIdle
+
+	// CloseError indicates that the connection has failed with any other error.
+	CloseError
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseNone:
+		return "none"
+	case CloseServer:
+		return "server-close"
+	case ClosePeer:
+		return "peer-close"
+	case CloseReset:
+		return "reset-by-peer"
+	case CloseWriteTimeout:
+		return "write-timeout"
+	case CloseIdle:
+		return "idle"
+	case CloseError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// CloseReasonOf classifies the error which a connection has been closed with, a nil error means that the server
+// has closed it.
+func CloseReasonOf(err error) CloseReason {
+	switch {
+	case err == nil, errors.Is(err, ErrServerShutdown), errors.Is(err, ErrConnMaxLifetime),
+		errors.Is(err, ErrServerOverloaded), errors.Is(err, ErrQuotaExceeded), errors.Is(err, ErrInboundTooLarge):
+		return CloseServer
+	case errors.Is(err, io.EOF):
+		return ClosePeer
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return CloseReset
+	case errors.Is(err, ErrSlowConsumer):
+		return CloseWriteTimeout
+	case errors.Is(err, ErrIdleTimeout), errors.Is(err, ErrHandshakeTimeout), errors.Is(err, ErrFirstByteTimeout):
+		return CloseIdle
+	default:
+		return CloseError
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Command gnetbench generates load against a request/response server and reports the throughput and
+// latency percentiles, it can also serve a built-in gnet echo server to benchmark the reactor itself.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/panlibin/gnet"
+	"github.com/panlibin/gnet/gnetbench"
+)
+
+type echoServer struct {
+	*gnet.EventServer
+}
+
+func (es *echoServer) React(frame []byte, c gnet.Conn) (out []byte, action gnet.Action) {
+	out = frame
+	return
+}
+
+func main() {
+	var (
+		cfg       gnetbench.Config
+		pattern   string
+		serve     bool
+		multicore bool
+	)
+
+	// Example command: go run main.go --addr tcp://127.0.0.1:9000 --serve --conns 100 --duration 10s
+	flag.StringVar(&cfg.Addr, "addr", "tcp://127.0.0.1:9000", "--addr tcp://127.0.0.1:9000")
+	flag.IntVar(&cfg.Conns, "conns", 50, "--conns 50")
+	flag.DurationVar(&cfg.Duration, "duration", 10*time.Second, "--duration 10s")
+	flag.IntVar(&cfg.Requests, "requests", 0, "--requests 10000 (per connection, overrides duration)")
+	flag.IntVar(&cfg.Pipeline, "pipeline", 1, "--pipeline 1")
+	flag.IntVar(&cfg.PayloadSize, "size", 64, "--size 64")
+	flag.StringVar(&pattern, "pattern", "fixed", "--pattern fixed|random|sequence")
+	flag.BoolVar(&serve, "serve", false, "--serve true (serve a built-in echo server on addr)")
+	flag.BoolVar(&multicore, "multicore", true, "--multicore true (for the built-in echo server)")
+	flag.Parse()
+
+	switch pattern {
+	case "fixed":
+	case "random":
+		cfg.Pattern = gnetbench.Random(time.Now().UnixNano())
+	case "sequence":
+		cfg.Pattern = gnetbench.Sequence()
+	default:
+		log.Fatalf("unknown pattern: %s", pattern)
+	}
+
+	if serve {
+		gs := new(gnet.GServer)
+		if err := gs.Serve(new(echoServer), cfg.Addr, gnet.WithMulticore(multicore)); err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			gs.SignalShutdown()
+			gs.WaitShutdown()
+		}()
+	}
+
+	res, err := gnetbench.Run(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(res)
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
 )
 
 // CRLFByte represents a byte of CRLF.
@@ -20,6 +21,7 @@ type (
 		// Encode encodes frames upon server responses into TCP stream.
 		Encode(c Conn, buf []byte) ([]byte, error)
 		// Decode decodes frames from TCP stream via specific implementation.
+		// The connection is closed if it returns ErrCorruptFrame or ErrFrameTooLarge.
 		Decode(c Conn) ([]byte, error)
 	}
 
@@ -50,6 +52,11 @@ type (
 	}
 )
 
+// isFatalDecodeError reports whether the stream cannot be decoded any further after the error.
+func isFatalDecodeError(err error) bool {
+	return err == ErrCorruptFrame || err == ErrFrameTooLarge
+}
+
 // Encode ...
 func (cc *BuiltInFrameCodec) Encode(c Conn, buf []byte) ([]byte, error) {
 	return buf, nil
@@ -151,8 +158,12 @@ type DecoderConfig struct {
 	LengthFieldLength int
 	// LengthAdjustment is the compensation value to add to the value of the length field
 	LengthAdjustment int
-	// InitialBytesToStrip is the number of first bytes to strip out from the decoded frame
+	// InitialBytesToStrip is the number of first bytes to strip out from the decoded frame, the decoder fails with
+	// ErrTooLessStripLength on a frame shorter than it and leaves the frame in the inbound buffer
 	InitialBytesToStrip int
+	// MaxFrameLength is the maximum length of a frame including the header and the length field before stripping,
+	// the decoder fails with ErrFrameTooLarge beyond it as soon as the length field is read, zero means no limit
+	MaxFrameLength int
 }
 
 // Encode ...
@@ -200,8 +211,8 @@ func (cc *LengthFieldBasedFrameCodec) Encode(c Conn, buf []byte) (out []byte, er
 type innerBuffer []byte
 
 func (in *innerBuffer) readN(n int) (buf []byte, err error) {
-	if n <= 0 {
-		return nil, errors.New("zero or negative length is invalid")
+	if n < 0 {
+		return nil, errors.New("negative length is invalid")
 	} else if n > len(*in) {
 		return nil, errors.New("exceeding buffer length")
 	}
@@ -232,10 +243,20 @@ func (cc *LengthFieldBasedFrameCodec) Decode(c Conn) ([]byte, error) {
 
 	// real message length
 	msgLength := int(frameLength) + cc.decoderConfig.LengthAdjustment
+	if frameLength > math.MaxInt32 || msgLength < 0 {
+		return nil, ErrCorruptFrame
+	}
+	if max := cc.decoderConfig.MaxFrameLength; max > 0 && len(header)+len(lenBuf)+msgLength > max {
+		return nil, ErrFrameTooLarge
+	}
 	msg, err := in.readN(msgLength)
 	if err != nil {
 		return nil, ErrUnexpectedEOF
 	}
+	if cc.decoderConfig.InitialBytesToStrip > len(header)+len(lenBuf)+msgLength {
+		// Leave the frame in the buffer, discarding it would put the decoder out of step with the stream.
+		return nil, ErrTooLessStripLength
+	}
 
 	fullMessage := make([]byte, len(header)+len(lenBuf)+msgLength)
 	copy(fullMessage, header)
@@ -303,3 +324,97 @@ func writeUint24(byteOrder binary.ByteOrder, v int) []byte {
 	}
 	return b
 }
+
+// EscapedDelimiterConfig sets up an EscapedDelimiterFrameCodec.
+type EscapedDelimiterConfig struct {
+	// Delimiter terminates every frame, it may be longer than one byte, e.g. "\r\n".
+	Delimiter []byte
+
+	// Escape makes the next byte literal, so that frames can carry the delimiter, zero disables escaping.
+	// The encoder escapes the escape byte and the first byte of the delimiter, the decoder removes the escapes.
+	Escape byte
+
+	// MaxFrameLength is the maximum length of an encoded frame without the delimiter, the decoder fails with
+	// ErrFrameTooLarge beyond it, which closes the connection, zero means no limit.
+	MaxFrameLength int
+}
+
+// EscapedDelimiterFrameCodec encodes/decodes delimiter-separated frames with escaping into/from TCP stream.
+// The decoded frames without escapes are sliced from the inbound buffer without copying.
+type EscapedDelimiterFrameCodec struct {
+	config EscapedDelimiterConfig
+}
+
+// NewEscapedDelimiterFrameCodec instantiates and returns a codec with the given delimiter and escape byte,
+// it panics if the delimiter is empty or starts with the escape byte.
+func NewEscapedDelimiterFrameCodec(config EscapedDelimiterConfig) *EscapedDelimiterFrameCodec {
+	if len(config.Delimiter) == 0 {
+		panic("gnet: empty delimiter")
+	}
+	if config.Escape != 0 && config.Delimiter[0] == config.Escape {
+		panic("gnet: delimiter starts with the escape byte")
+	}
+	config.Delimiter = append([]byte(nil), config.Delimiter...)
+	return &EscapedDelimiterFrameCodec{config}
+}
+
+// Encode ...
+func (cc *EscapedDelimiterFrameCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	esc, delim := cc.config.Escape, cc.config.Delimiter
+	if esc == 0 {
+		if bytes.Contains(buf, delim) {
+			return nil, ErrDelimiterInFrame
+		}
+		return append(buf, delim...), nil
+	}
+	out := make([]byte, 0, len(buf)+len(delim)+8)
+	for _, b := range buf {
+		if b == esc || b == delim[0] {
+			out = append(out, esc)
+		}
+		out = append(out, b)
+	}
+	return append(out, delim...), nil
+}
+
+// Decode ...
+func (cc *EscapedDelimiterFrameCodec) Decode(c Conn) ([]byte, error) {
+	esc, delim, max := cc.config.Escape, cc.config.Delimiter, cc.config.MaxFrameLength
+	buf := c.Read()
+	var escapes int
+	for i := 0; i < len(buf); i++ {
+		if max > 0 && i > max {
+			return nil, ErrFrameTooLarge
+		}
+		if esc != 0 && buf[i] == esc {
+			escapes++
+			i++
+			continue
+		}
+		if buf[i] != delim[0] || !bytes.HasPrefix(buf[i:], delim) {
+			continue
+		}
+		frame := buf[:i]
+		if escapes > 0 {
+			frame = unescape(frame, esc, escapes)
+		}
+		c.ShiftN(i + len(delim))
+		return frame, nil
+	}
+	if max > 0 && len(buf) > max+len(delim) {
+		return nil, ErrFrameTooLarge
+	}
+	return nil, ErrDelimiterNotFound
+}
+
+// unescape returns a copy of the frame without the escape bytes.
+func unescape(frame []byte, esc byte, escapes int) []byte {
+	out := make([]byte, 0, len(frame)-escapes)
+	for i := 0; i < len(frame); i++ {
+		if frame[i] == esc {
+			i++
+		}
+		out = append(out, frame[i])
+	}
+	return out
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build go1.18,!gofuzz
+
+package gnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// fuzzLengthFieldCodec derives the configuration of a length-field codec from a byte of the input,
+// a negative strip means stripping the header and the length field.
+func fuzzLengthFieldCodec(b byte, strip int) *LengthFieldBasedFrameCodec {
+	var order binary.ByteOrder = binary.BigEndian
+	if b&0x80 != 0 {
+		order = binary.LittleEndian
+	}
+	lengthFieldLength := []int{1, 2, 3, 4, 8}[int(b&0x7)%5]
+	offset := int(b>>3) & 0x3
+	adjustment := int(b>>5)&0x3 - 1
+	if strip < 0 {
+		strip = offset + lengthFieldLength
+	}
+	return NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: order, LengthFieldLength: lengthFieldLength, LengthAdjustment: -adjustment},
+		DecoderConfig{
+			ByteOrder:           order,
+			LengthFieldOffset:   offset,
+			LengthFieldLength:   lengthFieldLength,
+			LengthAdjustment:    adjustment,
+			InitialBytesToStrip: strip,
+		})
+}
+
+func fuzzDecode(t *testing.T, codec ICodec, data []byte) {
+	frames, leftover, err := DecodeFrames(codec, data)
+	if err == ErrDecoderStalled {
+		t.Fatalf("decoder stalled on %q", data)
+	}
+	if leftover < 0 || leftover > len(data) {
+		t.Fatalf("bad leftover %d of %d bytes", leftover, len(data))
+	}
+	var n int
+	for _, frame := range frames {
+		n += len(frame)
+	}
+	if n > len(data) {
+		t.Fatalf("decoded %d bytes out of %d bytes", n, len(data))
+	}
+}
+
+func FuzzLengthFieldBasedFrameCodec(f *testing.F) {
+	f.Add(byte(0), uint8(1), []byte("\x05hello"))
+	f.Add(byte(0x81), uint8(0), []byte("\x00\x00\x00\x05\x00\x00\x00hello"))
+	f.Add(byte(0x20), uint8(8), []byte("\x00\x00"))
+	f.Fuzz(func(t *testing.T, conf byte, strip uint8, data []byte) {
+		fuzzDecode(t, fuzzLengthFieldCodec(conf, int(strip%16)), data)
+
+		// Whatever is encoded must be decoded back.
+		codec := fuzzLengthFieldCodec(conf, -1)
+		payload := data
+		if len(payload) > 255 {
+			payload = payload[:255]
+		}
+		out, err := codec.Encode(nil, payload)
+		if err != nil {
+			return
+		}
+		header := bytes.Repeat([]byte{0xff}, codec.decoderConfig.LengthFieldOffset)
+		frames, leftover, err := DecodeFrames(codec, append(header, out...))
+		if err != nil || leftover != 0 || len(frames) != 1 || !bytes.Equal(frames[0], payload) {
+			t.Fatalf("failed to decode the encoded %q: frames=%q leftover=%d err=%v", payload, frames, leftover, err)
+		}
+	})
+}
+
+func FuzzLineBasedFrameCodec(f *testing.F) {
+	f.Add([]byte("hello\nworld\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzDecode(t, new(LineBasedFrameCodec), data)
+	})
+}
+
+func FuzzDelimiterBasedFrameCodec(f *testing.F) {
+	f.Add(byte('|'), []byte("hello|world|"))
+	f.Fuzz(func(t *testing.T, delimiter byte, data []byte) {
+		fuzzDecode(t, NewDelimiterBasedFrameCodec(delimiter), data)
+	})
+}
+
+func FuzzFixedLengthFrameCodec(f *testing.F) {
+	f.Add(uint8(5), []byte("helloworld"))
+	f.Fuzz(func(t *testing.T, frameLength uint8, data []byte) {
+		if frameLength == 0 {
+			return
+		}
+		fuzzDecode(t, NewFixedLengthFrameCodec(int(frameLength)), data)
+	})
+}
@@ -1,8 +1,11 @@
 package gnet
 
 import (
+	"compress/flate"
+	"compress/gzip"
 	"encoding/binary"
 	"math/rand"
+	"strings"
 	"testing"
 )
 
@@ -232,3 +235,173 @@ func TestInnerBufferReadN(t *testing.T) {
 		t.Fatal("wrong length of leftover bytes")
 	}
 }
+
+func TestCompressionCodec(t *testing.T) {
+	for _, comp := range []Compressor{NewGzipCompressor(gzip.BestSpeed), NewDeflateCompressor(flate.DefaultCompression)} {
+		codec := NewCompressionCodec(new(LineBasedFrameCodec), CompressionConfig{Compressor: comp, MaxFrameLength: 1024})
+		var stream []byte
+		for _, s := range []string{"hello", strings.Repeat("a", 1000)} {
+			out, err := codec.Encode(nil, []byte(s))
+			if err != nil {
+				t.Fatal(err)
+			}
+			stream = append(stream, out...)
+		}
+		frames, leftover, err := DecodeFrames(codec, stream[:len(stream)-1])
+		if err != ErrUnexpectedEOF || len(frames) != 1 || string(frames[0]) != "hello" || leftover == 0 {
+			t.Fatalf("unexpected result of a truncated stream: %q, %d, %v", frames, leftover, err)
+		}
+		frames, _, err = DecodeFrames(codec, stream)
+		if err != nil || len(frames) != 2 || string(frames[1]) != strings.Repeat("a", 1000) {
+			t.Fatalf("unexpected result: %d frames, %v", len(frames), err)
+		}
+
+		big, _ := codec.Encode(nil, make([]byte, 2048))
+		if _, _, err = DecodeFrames(codec, big); err != ErrFrameTooLarge {
+			t.Fatalf("expected ErrFrameTooLarge, got %v", err)
+		}
+		corrupt := append([]byte(nil), stream...)
+		corrupt[6] ^= 0xff
+		if _, _, err = DecodeFrames(codec, corrupt); err != ErrCorruptFrame {
+			t.Fatalf("expected ErrCorruptFrame, got %v", err)
+		}
+	}
+
+	// Without a negotiated compressor the frames pass through.
+	codec := NewCompressionCodec(new(LineBasedFrameCodec), CompressionConfig{
+		Negotiate: func(c Conn) Compressor { return nil },
+	})
+	if out, _ := codec.Encode(nil, []byte("plain")); string(out) != "plain\n" {
+		t.Fatalf("expected a plain frame, got %q", out)
+	}
+}
+
+func TestEscapedDelimiterFrameCodec(t *testing.T) {
+	codec := NewEscapedDelimiterFrameCodec(EscapedDelimiterConfig{
+		Delimiter:      []byte("\r\n"),
+		Escape:         '\\',
+		MaxFrameLength: 16,
+	})
+	var stream []byte
+	for _, s := range []string{"plain", "a\r\nb", `c\d`, ""} {
+		out, err := codec.Encode(nil, []byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, out...)
+	}
+	if string(stream) != "plain\r\na\\\r\nb\r\nc\\\\d\r\n\r\n" {
+		t.Fatalf("unexpected encoding: %q", stream)
+	}
+	frames, leftover, err := DecodeFrames(codec, append(stream, "tail\r"...))
+	if err != ErrDelimiterNotFound || leftover != 5 || len(frames) != 4 {
+		t.Fatalf("unexpected result: %q, %d, %v", frames, leftover, err)
+	}
+	if string(frames[0]) != "plain" || string(frames[1]) != "a\r\nb" || string(frames[2]) != `c\d` || len(frames[3]) != 0 {
+		t.Fatalf("unexpected frames: %q", frames)
+	}
+
+	if _, _, err = DecodeFrames(codec, []byte("0123456789abcdefg\r\n")); err != ErrFrameTooLarge {
+		t.Fatalf("expected ErrFrameTooLarge, got %v", err)
+	}
+	if _, _, err = DecodeFrames(codec, []byte("0123456789abcdefghij")); err != ErrFrameTooLarge {
+		t.Fatalf("expected ErrFrameTooLarge without a delimiter, got %v", err)
+	}
+	if frames, _, _ = DecodeFrames(codec, []byte("0123456789abcdef\r\n")); len(frames) != 1 {
+		t.Fatalf("expected a frame of the maximum length, got %q", frames)
+	}
+
+	codec = NewEscapedDelimiterFrameCodec(EscapedDelimiterConfig{Delimiter: []byte{'|'}})
+	if _, err = codec.Encode(nil, []byte("a|b")); err != ErrDelimiterInFrame {
+		t.Fatalf("expected ErrDelimiterInFrame, got %v", err)
+	}
+}
+
+func TestLengthFieldBasedFrameCodecLimits(t *testing.T) {
+	// A 2-byte header followed by a 3-byte little-endian length field counting the whole frame.
+	codec := NewLengthFieldBasedFrameCodec(
+		EncoderConfig{ByteOrder: binary.LittleEndian, LengthFieldLength: 3, LengthIncludesLengthFieldLength: true},
+		DecoderConfig{
+			ByteOrder:           binary.LittleEndian,
+			LengthFieldOffset:   2,
+			LengthFieldLength:   3,
+			LengthAdjustment:    -3,
+			InitialBytesToStrip: 5,
+			MaxFrameLength:      16,
+		})
+	frames, leftover, err := DecodeFrames(codec, []byte("HD\x08\x00\x00helloHD"))
+	if err != ErrUnexpectedEOF || leftover != 2 || len(frames) != 1 || string(frames[0]) != "hello" {
+		t.Fatalf("unexpected result: %q, %d, %v", frames, leftover, err)
+	}
+	// The limit applies as soon as the length field is read.
+	if _, _, err = DecodeFrames(codec, []byte("HD\x20\x00\x00")); err != ErrFrameTooLarge {
+		t.Fatalf("expected ErrFrameTooLarge, got %v", err)
+	}
+	if _, _, err = DecodeFrames(codec, []byte("HD\x01\x00\x00")); err != ErrCorruptFrame {
+		t.Fatalf("expected ErrCorruptFrame, got %v", err)
+	}
+}
+
+func TestLengthFieldBasedFrameCodecStrip(t *testing.T) {
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldLength:   1,
+		InitialBytesToStrip: 4,
+	})
+	// The frame shorter than the bytes to strip is left in the buffer rather than discarded.
+	frames, leftover, err := DecodeFrames(codec, []byte("\x03abc\x01a\x03def"))
+	if err != ErrTooLessStripLength || leftover != 6 || len(frames) != 1 || len(frames[0]) != 0 {
+		t.Fatalf("unexpected result: %q, %d, %v", frames, leftover, err)
+	}
+}
+
+func TestJSONLinesCodec(t *testing.T) {
+	codec := NewJSONLinesCodec(JSONLinesConfig{MaxFrameLength: 32})
+	out, err := codec.Encode(nil, []byte("{\n  \"a\": 1\n}"))
+	if err != nil || string(out) != "{\"a\":1}\n" {
+		t.Fatalf("unexpected encoding: %q, %v", out, err)
+	}
+	frames, leftover, err := DecodeFrames(codec, []byte("{\"a\":1}\r\n\n[2]\n{\"b\""))
+	if err != ErrCRLFNotFound || leftover != 4 || len(frames) != 2 || string(frames[0]) != `{"a":1}` ||
+		string(frames[1]) != "[2]" {
+		t.Fatalf("unexpected result: %q, %d, %v", frames, leftover, err)
+	}
+	if _, _, err = DecodeFrames(codec, []byte(strings.Repeat(" ", 40))); err != ErrFrameTooLarge {
+		t.Fatalf("expected ErrFrameTooLarge, got %v", err)
+	}
+
+	codec = NewJSONLinesCodec(JSONLinesConfig{Streaming: true, MaxFrameLength: 32})
+	frames, leftover, err = DecodeFrames(codec, []byte("{\"a\":\n1} [2]\n\"x\" {\"b\""))
+	if err != ErrUnexpectedEOF || leftover != 5 || len(frames) != 3 || string(frames[0]) != "{\"a\":\n1}" ||
+		string(frames[1]) != "[2]" || string(frames[2]) != `"x"` {
+		t.Fatalf("unexpected result: %q, %d, %v", frames, leftover, err)
+	}
+	if _, _, err = DecodeFrames(codec, []byte("{\"a\" 1}")); err != ErrCorruptFrame {
+		t.Fatalf("expected ErrCorruptFrame, got %v", err)
+	}
+	if _, _, err = DecodeFrames(codec, []byte("[\""+strings.Repeat("a", 40))); err != ErrFrameTooLarge {
+		t.Fatalf("expected ErrFrameTooLarge, got %v", err)
+	}
+}
+
+func TestTextLineCodec(t *testing.T) {
+	codec := NewTextLineCodec(TextLineConfig{CRLF: true, MaxLineLength: 8, Telnet: true})
+	if out, _ := codec.Encode(nil, []byte("a\xffb")); string(out) != "a\xff\xffb\r\n" {
+		t.Fatalf("unexpected encoding: %q", out)
+	}
+	// An option negotiation, a subnegotiation holding a newline and an escaped IAC.
+	data := "HELO\r\nhi\xff\xfb\x01 there\n\xff\xfa\x18\n\xff\xff\xff\xf0x\xff\xffy\r\nQU"
+	frames, leftover, err := DecodeFrames(codec, []byte(data))
+	if err != ErrCRLFNotFound || leftover != 2 || len(frames) != 3 {
+		t.Fatalf("unexpected result: %q, %d, %v", frames, leftover, err)
+	}
+	if string(frames[0]) != "HELO" || string(frames[1]) != "hi there" || string(frames[2]) != "x\xffy" {
+		t.Fatalf("unexpected frames: %q", frames)
+	}
+	if _, _, err = DecodeFrames(codec, []byte("123456789\n")); err != ErrFrameTooLarge {
+		t.Fatalf("expected ErrFrameTooLarge, got %v", err)
+	}
+	if _, _, err = DecodeFrames(codec, []byte("\xff\xfa"+strings.Repeat("x", 16))); err != ErrFrameTooLarge {
+		t.Fatalf("expected ErrFrameTooLarge of an endless subnegotiation, got %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+const defaultMaxCompressedFrameLength = 16 << 20
+
+// Compressor compresses and decompresses the blocks of CompressionCodec, the built-in ones are gzip and deflate,
+// others such as snappy, lz4 or zstd plug in by implementing it with their block APIs.
+type Compressor interface {
+	// Compress appends the compressed src to dst.
+	Compress(dst, src []byte) ([]byte, error)
+
+	// Decompress returns the decompressed src, it fails with ErrFrameTooLarge if the result exceeds maxLength
+	// and with ErrCorruptFrame if src is malformed.
+	Decompress(src []byte, maxLength int) ([]byte, error)
+}
+
+// CompressionConfig sets up a CompressionCodec.
+type CompressionConfig struct {
+	// Compressor compresses the frames of every connection unless Negotiate is set.
+	Compressor Compressor
+
+	// Negotiate picks the compressor of a connection each time a frame is encoded or decoded, e.g. from
+	// the context of the connection after the peers have agreed on an algorithm, nil means no compression.
+	// Switching the compressor must happen at a frame boundary of both directions.
+	Negotiate func(c Conn) Compressor
+
+	// MaxFrameLength is the maximum length of a block before and after decompression, defaults to 16MiB.
+	MaxFrameLength int
+}
+
+// CompressionCodec compresses the frames encoded by an inner codec and decompresses the inbound data before
+// it is decoded by the inner codec, each frame is sent as a block prefixed with its 4-byte big-endian length.
+type CompressionCodec struct {
+	codec  ICodec
+	config CompressionConfig
+}
+
+// NewCompressionCodec instantiates and returns a codec compressing the frames of the given codec,
+// the built-in codec is used if it is nil.
+func NewCompressionCodec(codec ICodec, config CompressionConfig) *CompressionCodec {
+	if codec == nil {
+		codec = new(BuiltInFrameCodec)
+	}
+	if config.MaxFrameLength <= 0 {
+		config.MaxFrameLength = defaultMaxCompressedFrameLength
+	}
+	return &CompressionCodec{codec, config}
+}
+
+func (cc *CompressionCodec) compressor(c Conn) Compressor {
+	if cc.config.Negotiate != nil {
+		return cc.config.Negotiate(c)
+	}
+	return cc.config.Compressor
+}
+
+// Encode ...
+func (cc *CompressionCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	frame, err := cc.codec.Encode(c, buf)
+	if err != nil {
+		return nil, err
+	}
+	comp := cc.compressor(c)
+	if comp == nil {
+		return frame, nil
+	}
+	out, err := comp.Compress(make([]byte, 4, 4+len(frame)/2), frame)
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+	return out, nil
+}
+
+// Decode ...
+func (cc *CompressionCodec) Decode(c Conn) ([]byte, error) {
+	comp := cc.compressor(c)
+	if comp == nil {
+		return cc.codec.Decode(c)
+	}
+	size, header := c.ReadN(4)
+	if size == 0 {
+		return nil, ErrUnexpectedEOF
+	}
+	length := int(binary.BigEndian.Uint32(header))
+	if length > cc.config.MaxFrameLength {
+		return nil, ErrFrameTooLarge
+	}
+	size, block := c.ReadN(4 + length)
+	if size == 0 {
+		return nil, ErrUnexpectedEOF
+	}
+	plain, err := comp.Decompress(block[4:], cc.config.MaxFrameLength)
+	c.ShiftN(size)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every block holds exactly one frame of the inner codec.
+	mc := &memConn{ctx: c.Context(), localAddr: c.LocalAddr(), remoteAddr: c.RemoteAddr(), buffer: plain}
+	frame, _ := cc.codec.Decode(mc)
+	if frame == nil || len(mc.buffer) > 0 {
+		return nil, ErrCorruptFrame
+	}
+	return frame, nil
+}
+
+// GzipCompressor compresses blocks with gzip.
+type GzipCompressor struct {
+	level   int
+	writers sync.Pool
+}
+
+// NewGzipCompressor instantiates and returns a gzip compressor with the given level of compress/gzip.
+func NewGzipCompressor(level int) *GzipCompressor {
+	return &GzipCompressor{level: level}
+}
+
+// Compress ...
+func (gc *GzipCompressor) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w, _ := gc.writers.Get().(*gzip.Writer)
+	if w == nil {
+		var err error
+		if w, err = gzip.NewWriterLevel(buf, gc.level); err != nil {
+			return nil, err
+		}
+	} else {
+		w.Reset(buf)
+	}
+	defer gc.writers.Put(w)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress ...
+func (gc *GzipCompressor) Decompress(src []byte, maxLength int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, ErrCorruptFrame
+	}
+	return readDecompressed(r, maxLength)
+}
+
+// DeflateCompressor compresses blocks with raw deflate.
+type DeflateCompressor struct {
+	level   int
+	writers sync.Pool
+}
+
+// NewDeflateCompressor instantiates and returns a deflate compressor with the given level of compress/flate.
+func NewDeflateCompressor(level int) *DeflateCompressor {
+	return &DeflateCompressor{level: level}
+}
+
+// Compress ...
+func (dc *DeflateCompressor) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w, _ := dc.writers.Get().(*flate.Writer)
+	if w == nil {
+		var err error
+		if w, err = flate.NewWriter(buf, dc.level); err != nil {
+			return nil, err
+		}
+	} else {
+		w.Reset(buf)
+	}
+	defer dc.writers.Put(w)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress ...
+func (dc *DeflateCompressor) Decompress(src []byte, maxLength int) ([]byte, error) {
+	return readDecompressed(flate.NewReader(bytes.NewReader(src)), maxLength)
+}
+
+// readDecompressed reads at most maxLength bytes from a decompressing reader.
+func readDecompressed(r io.ReadCloser, maxLength int) ([]byte, error) {
+	defer r.Close()
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(io.LimitReader(r, int64(maxLength)+1))
+	if err != nil {
+		return nil, ErrCorruptFrame
+	}
+	if n > int64(maxLength) {
+		return nil, ErrFrameTooLarge
+	}
+	return buf.Bytes(), nil
+}
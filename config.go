@@ -0,0 +1,301 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the deployment configuration of a server, it is loaded from a JSON or YAML file by LoadConfig and
+// overridden by environment variables by LoadEnv, so that deployments can tune gnet without recompiling.
+// The keys of the files are the names in the tags of the fields, the durations are written like "30s".
+type Config struct {
+	// Addr is the address to serve, e.g. "tcp://:9000".
+	Addr string `json:"addr"`
+
+	// Multicore sets up Options.Multicore.
+	Multicore bool `json:"multicore"`
+
+	// NumEventLoop sets up Options.NumEventLoop.
+	NumEventLoop int `json:"num_event_loop"`
+
+	// ReusePort sets up Options.ReusePort.
+	ReusePort bool `json:"reuse_port"`
+
+	// Ticker sets up Options.Ticker.
+	Ticker bool `json:"ticker"`
+
+	// BindToDevice sets up Options.BindToDevice.
+	BindToDevice string `json:"bind_to_device"`
+
+	// Freebind sets up Options.Freebind.
+	Freebind bool `json:"freebind"`
+
+	// Transparent sets up Options.Transparent.
+	Transparent bool `json:"transparent"`
+
+	// Mark sets up Options.Mark, e.g. "0x10".
+	Mark uint32 `json:"mark"`
+
+	// ExclusiveAddrUse sets up Options.ExclusiveAddrUse.
+	ExclusiveAddrUse bool `json:"exclusive_addr_use"`
+
+	// LoopbackFastPath sets up Options.LoopbackFastPath.
+	LoopbackFastPath bool `json:"loopback_fast_path"`
+
+	// PacketRing sets up Options.PacketRing.
+	PacketRing int `json:"packet_ring"`
+
+	// TCPKeepAlive sets up Options.TCPKeepAlive, it can be changed at runtime.
+	TCPKeepAlive time.Duration `json:"tcp_keep_alive"`
+
+	// HandshakeTimeout sets up Options.HandshakeTimeout.
+	HandshakeTimeout time.Duration `json:"handshake_timeout"`
+
+	// FirstByteTimeout sets up Options.FirstByteTimeout.
+	FirstByteTimeout time.Duration `json:"first_byte_timeout"`
+
+	// ConnMaxLifetime sets up Options.ConnMaxLifetime.
+	ConnMaxLifetime time.Duration `json:"conn_max_lifetime"`
+
+	// ConnRotationGrace sets up Options.ConnRotationGrace.
+	ConnRotationGrace time.Duration `json:"conn_rotation_grace"`
+
+	// WriteQuantum sets up Options.WriteQuantum, it can be changed at runtime.
+	WriteQuantum int `json:"write_quantum"`
+
+	// StreamHighWatermark sets up Options.StreamWriter.HighWatermark, it can be changed at runtime.
+	StreamHighWatermark int `json:"stream_high_watermark"`
+
+	// StreamChunkSize sets up Options.StreamWriter.ChunkSize, it can be changed at runtime.
+	StreamChunkSize int `json:"stream_chunk_size"`
+
+	// TLSCertFile and TLSKeyFile are the paths of the PEM encoded certificate and key loaded by TLSConfig.
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
+}
+
+// LoadConfig loads the configuration from a JSON file, or a YAML file if its extension is .yaml or .yml.
+// Only flat YAML mappings of scalars are supported, i.e. "key: value" lines and comments.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		values, err = parseYAML(data)
+	default:
+		values, err = parseJSON(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	cfg := new(Config)
+	for key, value := range values {
+		if err = cfg.set(key, value); err != nil {
+			return nil, fmt.Errorf("invalid config %s: %v", path, err)
+		}
+	}
+	return cfg, nil
+}
+
+// LoadEnv overrides the configuration by the environment variables named after the upper-cased keys with
+// the given prefix, e.g. GNET_NUM_EVENT_LOOP for the prefix "GNET_".
+func (cfg *Config) LoadEnv(prefix string) error {
+	t := reflect.TypeOf(cfg).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("json")
+		name := prefix + strings.ToUpper(key)
+		if value, ok := os.LookupEnv(name); ok {
+			if err := cfg.set(key, value); err != nil {
+				return fmt.Errorf("invalid environment variable %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Options returns the options set up by the configuration, to be passed to Serve along with Addr.
+func (cfg *Config) Options() []Option {
+	return []Option{
+		WithMulticore(cfg.Multicore),
+		WithNumEventLoop(cfg.NumEventLoop),
+		WithReusePort(cfg.ReusePort),
+		WithTicker(cfg.Ticker),
+		WithBindToDevice(cfg.BindToDevice),
+		WithFreebind(cfg.Freebind),
+		WithTransparent(cfg.Transparent),
+		WithMark(cfg.Mark),
+		WithExclusiveAddrUse(cfg.ExclusiveAddrUse),
+		WithLoopbackFastPath(cfg.LoopbackFastPath),
+		WithPacketRing(cfg.PacketRing),
+		WithTCPKeepAlive(cfg.TCPKeepAlive),
+		WithHandshakeTimeout(cfg.HandshakeTimeout),
+		WithFirstByteTimeout(cfg.FirstByteTimeout),
+		WithConnMaxLifetime(cfg.ConnMaxLifetime),
+		WithConnRotationGrace(cfg.ConnRotationGrace),
+		WithWriteQuantum(cfg.WriteQuantum),
+		WithStreamWriter(StreamWriter{HighWatermark: cfg.StreamHighWatermark, ChunkSize: cfg.StreamChunkSize}),
+	}
+}
+
+// Tunables returns the part of the configuration which can be changed at runtime by GServer.SetTunables.
+func (cfg *Config) Tunables() Tunables {
+	return Tunables{
+		TCPKeepAlive: cfg.TCPKeepAlive,
+		WriteQuantum: cfg.WriteQuantum,
+		StreamWriter: StreamWriter{HighWatermark: cfg.StreamHighWatermark, ChunkSize: cfg.StreamChunkSize},
+	}
+}
+
+// TLSConfig loads the certificate and key from TLSCertFile and TLSKeyFile, e.g. for StartTLS,
+// it returns nil if they are not set.
+func (cfg *Config) TLSConfig() (*tls.Config, error) {
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// set sets the field tagged with key to the parsed value.
+func (cfg *Config) set(key, value string) error {
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("json") != key {
+			continue
+		}
+		field := v.Field(i)
+		switch {
+		case field.Type() == reflect.TypeOf(time.Duration(0)):
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			field.SetInt(int64(d))
+		case field.Kind() == reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			field.SetInt(int64(n))
+		case field.Kind() == reflect.Uint32:
+			n, err := strconv.ParseUint(value, 0, 32)
+			if err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			field.SetUint(n)
+		case field.Kind() == reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			field.SetBool(b)
+		default:
+			field.SetString(value)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown key %q", key)
+}
+
+func parseJSON(data []byte) (map[string]string, error) {
+	var raw map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch value.(type) {
+		case string, bool, json.Number:
+			values[key] = fmt.Sprint(value)
+		default:
+			return nil, fmt.Errorf("%s: not a scalar", key)
+		}
+	}
+	return values, nil
+}
+
+func parseYAML(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line == "---" {
+			continue
+		}
+		idx := strings.Index(line, ":")
+		if idx <= 0 {
+			return nil, fmt.Errorf("line %d: not a key-value pair", n)
+		}
+		key, value := strings.TrimSpace(line[:idx]), strings.TrimSpace(line[idx+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// Tunables are the options which can be changed while the server is running. TCPKeepAlive and StreamWriter
+// apply to the connections opened and the writers created from then on, WriteQuantum applies at once.
+type Tunables struct {
+	// TCPKeepAlive (SO_KEEPALIVE) socket option.
+	TCPKeepAlive time.Duration
+
+	// WriteQuantum is the maximum number of bytes flushed to a connection per writable event.
+	WriteQuantum int
+
+	// StreamWriter sets up the backpressure of the writers returned by Conn.Writer.
+	StreamWriter StreamWriter
+}
+
+// SetTunables changes the tunables of a running server, e.g. with the configuration reloaded on SIGHUP:
+//
+//	cfg, err := gnet.LoadConfig(path)
+//	if err == nil {
+//		err = s.SetTunables(cfg.Tunables())
+//	}
+//
+// The tunables are validated as the options at startup, an invalid one fails with ErrInvalidOptions.
+func (s *GServer) SetTunables(t Tunables) error {
+	if s.s == nil {
+		return ErrServerShutdown
+	}
+	opts := *s.s.opts
+	opts.TCPKeepAlive, opts.WriteQuantum, opts.StreamWriter = t.TCPKeepAlive, t.WriteQuantum, t.StreamWriter
+	if err := opts.validate(s.s.ln.network); err != nil {
+		return err
+	}
+	opts.resolve()
+	t.StreamWriter = opts.StreamWriter
+	s.s.tunables.Store(&t)
+	return nil
+}
+
+// tunings returns the current tunables of the server.
+func (svr *server) tunings() *Tunables {
+	return svr.tunables.Load().(*Tunables)
+}
@@ -0,0 +1,419 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Copyright 2018 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows gnet_net
+
+package gnet
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/panlibin/gnet/pool/bytebuffer"
+	prb "github.com/panlibin/gnet/pool/ringbuffer"
+	"github.com/panlibin/gnet/ringbuffer"
+)
+
+type stderr struct {
+	c   *stdConn
+	err error
+}
+
+type wakeReq struct {
+	c *stdConn
+}
+
+type tcpIn struct {
+	c  *stdConn
+	in *bytebuffer.ByteBuffer
+}
+
+type udpIn struct {
+	c *stdConn
+}
+
+type stdConn struct {
+	ctx           interface{}            // user-defined context
+	conn          net.Conn               // original connection
+	loop          *eventloop             // owner event-loop
+	done          int32                  // 0: attached, 1: closed
+	closeReason   CloseReason            // why the connection has been closed, see Conn.CloseReason
+	buffer        *bytebuffer.ByteBuffer // reuse memory of inbound data as a temporary buffer
+	codec         ICodec                 // codec for TCP
+	localAddr     net.Addr               // local server addr
+	remoteAddr    net.Addr               // remote peer addr
+	peer          *Peer                  // metadata of the remote peer
+	tap           *connTap               // traffic tap, nil if the connection is not tapped
+	fault         *connFault             // fault injection, nil if it is disabled
+	byteBuffer    *bytebuffer.ByteBuffer // bytes buffer for buffering current packet and data in ring-buffer
+	inboundBuffer *ringbuffer.RingBuffer // buffer for data from client
+	writerOnce    sync.Once              // creates writer
+	writer        *connWriter            // stream writer, created on the first call to Writer
+	stream        *connWriter            // stream writer seen by the event-loop, nil if no data has been streamed
+	tickers       []*connTicker          // periodic callbacks registered by Tick
+	interned      []internRef            // interned strings held by the connection, see Interner.Attach
+	throttled     bool                   // reading is stopped by the Throttle action until a wake-up
+	paused        int32                  // 1 if the reading goroutine is paused
+	pausedRead    int32                  // 1 if reading is stopped by PauseRead
+	readGate      chan struct{}          // resumes the paused reading goroutine
+	detached      *detachedConn          // set once the connection has been detached from the event-loop
+	settings      ConnSettings           // settings returned by OnConfigure
+	stats         connStats              // statistics of the connection
+}
+
+func newTCPConn(conn net.Conn, el *eventloop) *stdConn {
+	return &stdConn{
+		conn:          conn,
+		loop:          el,
+		codec:         el.codec,
+		inboundBuffer: prb.Get(),
+		readGate:      make(chan struct{}, 1),
+	}
+}
+
+func (c *stdConn) releaseTCP() {
+	c.ctx = nil
+	c.localAddr = nil
+	c.remoteAddr = nil
+	c.peer = nil
+	c.tap = nil
+	c.fault = nil
+	c.stream = nil
+	c.throttled = false
+	c.settings = ConnSettings{}
+	prb.Put(c.inboundBuffer)
+	c.inboundBuffer = nil
+	bytebuffer.Put(c.buffer)
+	c.buffer = nil
+}
+
+func newUDPConn(el *eventloop, localAddr, remoteAddr net.Addr, buf *bytebuffer.ByteBuffer) *stdConn {
+	return &stdConn{
+		loop:       el,
+		localAddr:  localAddr,
+		remoteAddr: remoteAddr,
+		buffer:     buf,
+	}
+}
+
+func (c *stdConn) releaseUDP() {
+	c.ctx = nil
+	c.localAddr = nil
+	bytebuffer.Put(c.buffer)
+	c.buffer = nil
+}
+
+func (c *stdConn) holdInterned(ref internRef) bool {
+	if c.conn == nil || c.detached != nil {
+		return false
+	}
+	c.interned = append(c.interned, ref)
+	return true
+}
+
+func (c *stdConn) write(buf []byte) (int, error) {
+	if c.tap != nil {
+		c.tap.mirror(TapOutbound, buf)
+	}
+	if c.fault != nil {
+		return len(buf), c.fault.inject(FaultWrite, buf)
+	}
+	n, err := c.conn.Write(buf)
+	c.stats.wrote(n)
+	return n, err
+}
+
+// pauseReading makes the reading goroutine wait before its next read.
+func (c *stdConn) pauseReading() {
+	select {
+	case <-c.readGate:
+	default:
+	}
+	atomic.StoreInt32(&c.paused, 1)
+}
+
+// resumeReading resumes the paused reading goroutine.
+func (c *stdConn) resumeReading() {
+	if atomic.SwapInt32(&c.paused, 0) == 1 {
+		select {
+		case c.readGate <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (c *stdConn) read() ([]byte, error) {
+	return c.codec.Decode(c)
+}
+
+// ================================= Public APIs of gnet.Conn =================================
+
+func (c *stdConn) Read() []byte {
+	if c.inboundBuffer.IsEmpty() {
+		if c.buffer.Len() == 0 {
+			return nil
+		}
+		return c.buffer.Bytes()
+	}
+	c.byteBuffer = c.inboundBuffer.WithByteBuffer(c.buffer.Bytes())
+	return c.byteBuffer.Bytes()
+}
+
+func (c *stdConn) ResetBuffer() {
+	c.buffer.Reset()
+	c.inboundBuffer.Reset()
+	bytebuffer.Put(c.byteBuffer)
+	c.byteBuffer = nil
+}
+
+func (c *stdConn) ReadN(n int) (size int, buf []byte) {
+	inBufferLen := c.inboundBuffer.Length()
+	tempBufferLen := c.buffer.Len()
+	if inBufferLen+tempBufferLen < n || n <= 0 {
+		return
+	}
+	size = n
+	if c.inboundBuffer.IsEmpty() {
+		buf = c.buffer.B[:n]
+		return
+	}
+	head, tail := c.inboundBuffer.LazyRead(n)
+	c.byteBuffer = bytebuffer.Get()
+	_, _ = c.byteBuffer.Write(head)
+	_, _ = c.byteBuffer.Write(tail)
+	if inBufferLen >= n {
+		buf = c.byteBuffer.Bytes()
+		return
+	}
+
+	restSize := n - inBufferLen
+	_, _ = c.byteBuffer.Write(c.buffer.B[:restSize])
+	buf = c.byteBuffer.Bytes()
+	return
+}
+
+func (c *stdConn) ShiftN(n int) (size int) {
+	inBufferLen := c.inboundBuffer.Length()
+	tempBufferLen := c.buffer.Len()
+	if inBufferLen+tempBufferLen < n || n <= 0 {
+		c.ResetBuffer()
+		size = inBufferLen + tempBufferLen
+		return
+	}
+	size = n
+	if c.inboundBuffer.IsEmpty() {
+		c.buffer.B = c.buffer.B[n:]
+		return
+	}
+
+	bytebuffer.Put(c.byteBuffer)
+	c.byteBuffer = nil
+
+	if inBufferLen >= n {
+		c.inboundBuffer.Shift(n)
+		return
+	}
+	c.inboundBuffer.Reset()
+
+	restSize := n - inBufferLen
+	c.buffer.B = c.buffer.B[restSize:]
+	return
+}
+
+func (c *stdConn) BufferLength() int {
+	return c.inboundBuffer.Length() + c.buffer.Len()
+}
+
+func (c *stdConn) AsyncWrite(buf []byte) (err error) {
+	var encodedBuf []byte
+	if encodedBuf, err = c.codec.Encode(c, buf); err == nil {
+		c.loop.ch <- func() error {
+			_, _ = c.write(encodedBuf)
+			return nil
+		}
+	}
+	return
+}
+
+// AsyncWriteWithPriority writes data in order regardless of the priority since there is no outbound buffer
+// on Windows, data is written to the socket directly by the event-loop.
+func (c *stdConn) AsyncWriteWithPriority(buf []byte, priority WritePriority) error {
+	return c.AsyncWrite(buf)
+}
+
+func (c *stdConn) Writer() io.Writer {
+	c.writerOnce.Do(func() {
+		if c.conn == nil {
+			c.writer = newConnWriter(StreamWriter{}, func([]byte) error { return ErrProtocolNotSupported })
+			return
+		}
+		var w *connWriter
+		w = newConnWriter(c.loop.svr.tunings().StreamWriter, func(chunk []byte) error {
+			c.loop.ch <- func() error {
+				if _, ok := c.loop.connections[c]; !ok {
+					w.fail(ErrConnectionClosed)
+					return nil
+				}
+				c.stream = w
+				_, _ = c.write(chunk)
+				w.settle(len(chunk), 0)
+				return nil
+			}
+			return nil
+		})
+		c.writer = w
+	})
+	return c.writer
+}
+
+func (c *stdConn) Write(p []byte) (int, error) {
+	return c.Writer().Write(p)
+}
+
+func (c *stdConn) ReadFrom(r io.Reader) (n int64, err error) {
+	w := c.Writer().(*connWriter)
+	if n, err = io.Copy(w, r); err == nil {
+		err = w.drain()
+	}
+	return
+}
+
+func (c *stdConn) WriteTo(w io.Writer) (n int64, err error) {
+	return writeInboundTo(c, w)
+}
+
+func (c *stdConn) SendTo(buf []byte) (err error) {
+	_, err = c.loop.svr.ln.pconn.WriteTo(buf, c.remoteAddr)
+	return
+}
+
+func (c *stdConn) Upgrade(codec ICodec) {
+	c.codec = codec
+}
+
+func (c *stdConn) Detach() (net.Conn, error) {
+	if c.conn == nil {
+		return nil, ErrProtocolNotSupported
+	}
+	if c.detached != nil || atomic.LoadInt32(&c.done) != 0 {
+		return nil, ErrConnectionClosed
+	}
+	dc := c.loop.detach(c)
+	c.releaseTCP()
+	return dc, nil
+}
+
+func (c *stdConn) Tick(interval time.Duration, fn ConnTickFunc) (cancel func()) {
+	t := newConnTicker(interval, fn)
+	c.tickers = append(c.tickers, t)
+	c.loop.scheduleTick(c, t)
+	return func() {
+		if !t.stopped {
+			t.stopped = true
+			c.tickers = removeTicker(c.tickers, t)
+		}
+	}
+}
+
+func (c *stdConn) Wake() error {
+	c.loop.ch <- wakeReq{c}
+	return nil
+}
+
+func (c *stdConn) priority() int {
+	return c.settings.Priority
+}
+
+func (c *stdConn) LoopIndex() int {
+	if c.loop == nil {
+		return -1
+	}
+	return c.loop.idx
+}
+
+func (c *stdConn) Migrate(idx int) error {
+	return ErrProtocolNotSupported
+}
+
+func (c *stdConn) OriginalDst() (net.Addr, error) {
+	return nil, ErrProtocolNotSupported
+}
+
+func (c *stdConn) SyscallConn() (syscall.RawConn, error) {
+	// The connection is read by its own goroutine, the runtime poller coordinates the raw calls with it.
+	if sc, ok := c.conn.(syscall.Conn); ok {
+		rc, err := sc.SyscallConn()
+		if err != nil {
+			return nil, err
+		}
+		return controlOnlyConn{rc}, nil
+	}
+	return nil, ErrProtocolNotSupported
+}
+
+// controlOnlyConn is the syscall.RawConn of a TCP connection, see Conn.SyscallConn.
+type controlOnlyConn struct {
+	syscall.RawConn
+}
+
+// Read fails since the connection is read by its own goroutine.
+func (rc controlOnlyConn) Read(func(fd uintptr) (done bool)) error {
+	return ErrProtocolNotSupported
+}
+
+// Write fails since the connection is written by the event-loop.
+func (rc controlOnlyConn) Write(func(fd uintptr) (done bool)) error {
+	return ErrProtocolNotSupported
+}
+
+func (c *stdConn) PauseRead() error {
+	if atomic.CompareAndSwapInt32(&c.pausedRead, 0, 1) {
+		c.pauseReading()
+	}
+	return nil
+}
+
+func (c *stdConn) ResumeRead() error {
+	if atomic.CompareAndSwapInt32(&c.pausedRead, 1, 0) {
+		c.loop.ch <- func() error {
+			return c.loop.loopResumeRead(c)
+		}
+	}
+	return nil
+}
+
+// readPaused reports whether reading is stopped by PauseRead.
+func (c *stdConn) readPaused() bool {
+	return atomic.LoadInt32(&c.pausedRead) == 1
+}
+
+func (c *stdConn) Close() error {
+	c.loop.ch <- func() error {
+		return c.loop.loopClose(c)
+	}
+	return nil
+}
+
+func (c *stdConn) Context() interface{}       { return c.ctx }
+func (c *stdConn) SetContext(ctx interface{}) { c.ctx = ctx }
+func (c *stdConn) LocalAddr() net.Addr        { return c.localAddr }
+func (c *stdConn) RemoteAddr() net.Addr       { return c.remoteAddr }
+func (c *stdConn) Peer() *Peer                { return c.peer }
+func (c *stdConn) CloseReason() CloseReason   { return c.closeReason }
+
+func (c *stdConn) Stats() ConnStats {
+	if c.inboundBuffer == nil {
+		return ConnStats{}
+	}
+	return c.stats.snapshot(c.BufferLength(), 0)
+}
+
+func (c *stdConn) SetTOS(tos byte) error {
+	return ErrProtocolNotSupported
+}
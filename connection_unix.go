@@ -4,12 +4,19 @@
 // license that can be found in the LICENSE file.
 
 // +build linux darwin netbsd freebsd openbsd dragonfly
+// +build !gnet_net
 
 package gnet
 
 import (
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/panlibin/gnet/internal"
 	"github.com/panlibin/gnet/internal/netpoll"
 	"github.com/panlibin/gnet/pool/bytebuffer"
 	prb "github.com/panlibin/gnet/pool/ringbuffer"
@@ -22,18 +29,100 @@ type conn struct {
 	sa             unix.Sockaddr          // remote socket address
 	ctx            interface{}            // user-defined context
 	loop           *eventloop             // connected event-loop
+	owner          atomic.Value           // *eventloop owning the connection for other goroutines, see trigger
 	buffer         []byte                 // reuse memory of inbound data as a temporary buffer
 	codec          ICodec                 // codec for TCP
 	opened         bool                   // connection opened event fired
 	localAddr      net.Addr               // local addr
 	remoteAddr     net.Addr               // remote addr
+	peer           *Peer                  // metadata of the remote peer
+	tap            *connTap               // traffic tap, nil if the connection is not tapped
+	fault          *connFault             // fault injection, nil if it is disabled
 	byteBuffer     *bytebuffer.ByteBuffer // bytes buffer for buffering current packet and data in ring-buffer
 	inboundBuffer  *ringbuffer.RingBuffer // buffer for data from client
 	outboundBuffer *ringbuffer.RingBuffer // buffer for data that is ready to write to client
+	shaping        *connShaping           // traffic shaping state, nil if bandwidth is unlimited
+	urgent         [][]byte               // high-priority frames waiting to jump ahead of the outbound buffer
+	frameSizes     []int                  // sizes of the frames in the outbound buffer
+	frameOffset    int                    // number of bytes of the head frame in the outbound buffer that have been written
+	urgentOffset   int                    // number of bytes of the head high-priority frame that have been written
+	writerOnce     sync.Once              // creates writer
+	writer         *connWriter            // stream writer, created on the first call to Writer
+	stream         *connWriter            // stream writer seen by the event-loop, nil if no data has been streamed
+	tickers        []*connTicker          // periodic callbacks registered by Tick
+	handshake      *internal.Timer        // timer of Options.HandshakeTimeout, nil once the handshake has completed
+	handshakeBy    time.Time              // deadline of the handshake
+	firstByte      *internal.Timer        // timer of Options.FirstByteTimeout, nil once the connection has read
+	firstByteBy    time.Time              // deadline of the first byte
+	lifetime       *internal.Timer        // timer of the rotation by Options.ConnMaxLifetime, nil if there is none
+	lifetimeBy     time.Time              // deadline of the current stage of the rotation
+	writeRetry     *internal.Timer        // timer holding the writes back after ENOBUFS, nil if there is none
+	rotated        bool                   // OnDraining has fired as the connection has reached its maximum lifetime
+	probedAt       time.Time              // when OnIdle has fired for the current idle period, zero if it has not
+	settings       ConnSettings           // settings returned by OnConfigure
+	idleTimeout    *internal.Timer        // timer of ConnSettings.IdleTimeout, nil if there is none
+	closeReason    CloseReason            // why the connection has been closed, see Conn.CloseReason
+	interned       []internRef            // interned strings held by the connection, see Interner.Attach
+	throttled      bool                   // reading is stopped by the Throttle action until a wake-up
+	stalledAt      time.Time              // start of the current stall of the outbound data, zero if there is none
+	slow           bool                   // the slow consumer policy has been applied to the current stall
+	slowPaused     bool                   // reading is stopped by SlowConsumerThrottle until the outbound data drains
+	tenant         *tenant                // tenant of the connection, nil if it has none, see Options.Quotas
+	tenantPaused   bool                   // reading is stopped by TenantQuota.MaxPending until the outbound data drains
+	pausedRead     int32                  // 1 if reading is stopped by PauseRead, accessed atomically
+	queuedWrites   int32                  // asynchronous writes waiting in the job queue, accessed atomically
+	udpLoop        *eventloop             // event-loop which has read the UDP datagram, nil for TCP
+	udpTOS         int                    // TOS byte of the UDP datagram, -1 if it is unknown
+	addrs          connAddrs              // storage of the resolved addresses
+	stats          connStats              // statistics of the connection
+}
+
+// connAddrs keeps the resolved remote address and the 4-tuple within the connection, so that resolving them
+// at accept costs no allocations of its own and the accessors hand them out as they are.
+type connAddrs struct {
+	tcp   net.TCPAddr        // remote address of a TCP connection
+	udp   net.UDPAddr        // remote address of a UDP connection
+	ip    [net.IPv6len]byte  // backing array of the remote IP address
+	tuple [AddrTupleLen]byte // see AddrTuple
+	inet  bool               // the tuple has been laid out
+}
+
+// resolveAddrs resolves the remote address of the connection into the storage and lays out the 4-tuple.
+func (c *conn) resolveAddrs(udp bool) {
+	a := &c.addrs
+	ip, port, zone := netpoll.SockaddrInet(c.sa, &a.ip)
+	switch {
+	case ip == nil && udp:
+		c.remoteAddr = datagramSockaddrToAddr(c.sa)
+	case ip == nil:
+		c.remoteAddr = sockaddrToAddr(c.sa)
+	case udp:
+		a.udp = net.UDPAddr{IP: ip, Port: port, Zone: zone}
+		c.remoteAddr = &a.udp
+	default:
+		a.tcp = net.TCPAddr{IP: ip, Port: port, Zone: zone}
+		c.remoteAddr = &a.tcp
+	}
+	a.inet = putAddrTuple(&a.tuple, c.localAddr, c.remoteAddr)
+}
+
+func (c *conn) addrTuple() []byte {
+	if !c.addrs.inet {
+		return nil
+	}
+	return c.addrs.tuple[:]
+}
+
+func (c *conn) holdInterned(ref internRef) bool {
+	if !c.opened {
+		return false
+	}
+	c.interned = append(c.interned, ref)
+	return true
 }
 
 func newTCPConn(fd int, el *eventloop, sa unix.Sockaddr) *conn {
-	return &conn{
+	c := &conn{
 		fd:             fd,
 		sa:             sa,
 		loop:           el,
@@ -41,6 +130,55 @@ func newTCPConn(fd int, el *eventloop, sa unix.Sockaddr) *conn {
 		inboundBuffer:  prb.Get(),
 		outboundBuffer: prb.Get(),
 	}
+	c.owner.Store(el)
+	return c
+}
+
+// eventLoop returns the event-loop owning the connection, nil for UDP, it may be invoked from any goroutine
+// unlike c.loop which belongs to the event-loop.
+func (c *conn) eventLoop() *eventloop {
+	el, _ := c.owner.Load().(*eventloop)
+	return el
+}
+
+// trigger runs the job on the event-loop owning the connection, it follows the connection if the connection
+// has migrated to another event-loop before the job runs, see Migrate.
+func (c *conn) trigger(job func() error) error {
+	return c.triggerLane(false, job)
+}
+
+// triggerUrgent is trigger for the urgent jobs, which jump ahead of the ordinary ones, see Poller.TriggerUrgent.
+func (c *conn) triggerUrgent(job func() error) error {
+	return c.triggerLane(true, job)
+}
+
+func (c *conn) triggerLane(urgent bool, job func() error) error {
+	el := c.eventLoop()
+	follow := func() error {
+		if owner := c.eventLoop(); owner != el {
+			// The connection can only migrate on its own event-loop, so it stays with the owner seen here.
+			sniffError(c.triggerLane(urgent, job))
+			return nil
+		}
+		return job()
+	}
+	if urgent {
+		return el.poller.TriggerUrgent(follow)
+	}
+	return el.poller.Trigger(follow)
+}
+
+// triggerWrite is trigger for the asynchronous writes, which are counted so that Close doesn't jump ahead of them.
+func (c *conn) triggerWrite(job func() error) error {
+	atomic.AddInt32(&c.queuedWrites, 1)
+	err := c.trigger(func() error {
+		atomic.AddInt32(&c.queuedWrites, -1)
+		return job()
+	})
+	if err != nil {
+		atomic.AddInt32(&c.queuedWrites, -1)
+	}
+	return err
 }
 
 func (c *conn) releaseTCP() {
@@ -50,38 +188,100 @@ func (c *conn) releaseTCP() {
 	c.buffer = nil
 	c.localAddr = nil
 	c.remoteAddr = nil
+	c.addrs.inet = false
+	c.peer = nil
+	c.tap = nil
+	c.fault = nil
 	prb.Put(c.inboundBuffer)
 	prb.Put(c.outboundBuffer)
 	c.inboundBuffer = nil
 	c.outboundBuffer = nil
 	bytebuffer.Put(c.byteBuffer)
 	c.byteBuffer = nil
+	c.shaping = nil
+	c.urgent = nil
+	c.frameSizes = nil
+	c.frameOffset = 0
+	c.urgentOffset = 0
+	c.stream = nil
+	c.throttled = false
+	c.stalledAt = time.Time{}
+	c.slow = false
+	c.slowPaused = false
+	c.tenant = nil
+	c.tenantPaused = false
+	c.rotated = false
+	c.probedAt = time.Time{}
+	c.settings = ConnSettings{}
+	c.closeReason = CloseNone
 }
 
-func newUDPConn(fd int, el *eventloop, sa unix.Sockaddr) *conn {
-	return &conn{
-		fd:         fd,
-		sa:         sa,
-		localAddr:  el.svr.ln.lnaddr,
-		remoteAddr: netpoll.SockaddrToUDPAddr(sa),
+func newUDPConn(fd int, el *eventloop, sa unix.Sockaddr, localAddr net.Addr) *conn {
+	c := &conn{
+		fd:        fd,
+		sa:        sa,
+		udpLoop:   el,
+		localAddr: localAddr,
 	}
+	c.resolveAddrs(true)
+	return c
 }
 
 func (c *conn) releaseUDP() {
 	c.ctx = nil
+	c.udpLoop = nil
 	c.localAddr = nil
 	c.remoteAddr = nil
+	c.addrs.inet = false
 }
 
 func (c *conn) open(buf []byte) {
+	if c.tap != nil {
+		c.tap.mirror(TapOutbound, buf)
+	}
+	if c.fault != nil {
+		_ = c.fault.inject(FaultWrite, buf)
+		return
+	}
 	n, err := unix.Write(c.fd, buf)
 	if err != nil {
-		_, _ = c.outboundBuffer.Write(buf)
+		// The data is kept on any error, the errors which are not transient surface on the next write.
+		c.bufferOutbound(buf)
+		c.loop.writeBlocked(c, err)
 		return
 	}
+	c.stats.wrote(n)
 
 	if n < len(buf) {
-		_, _ = c.outboundBuffer.Write(buf[n:])
+		atomic.AddInt64(&c.loop.svr.stats.partialWrites, 1)
+		c.bufferRest(buf, n)
+	}
+}
+
+// pendingOutbound returns a copy of the data waiting to be written in the order it would have been written.
+func (c *conn) pendingOutbound() []byte {
+	head, tail := c.outboundBuffer.LazyReadAll()
+	buffered := append(append([]byte(nil), head...), tail...)
+	if len(c.urgent) == 0 {
+		return buffered
+	}
+	// The high-priority frames are written right after the head frame if it has been partially written.
+	var split int
+	if c.frameOffset > 0 {
+		split = c.frameSizes[0] - c.frameOffset
+	}
+	out := append([]byte(nil), buffered[:split]...)
+	out = append(out, c.urgent[0][c.urgentOffset:]...)
+	for _, buf := range c.urgent[1:] {
+		out = append(out, buf...)
+	}
+	return append(out, buffered[split:]...)
+}
+
+// settleStream reports the length of the outbound buffer to the stream writer.
+func (c *conn) settleStream() {
+	if c.stream != nil && c.outboundBuffer != nil {
+		c.stream.settle(0, c.outboundBuffer.Length())
 	}
 }
 
@@ -89,24 +289,168 @@ func (c *conn) read() ([]byte, error) {
 	return c.codec.Decode(c)
 }
 
+// bufferOutbound appends a frame to the outbound buffer.
+func (c *conn) bufferOutbound(buf []byte) {
+	_, _ = c.outboundBuffer.Write(buf)
+	c.frameSizes = append(c.frameSizes, len(buf))
+	c.addPending(len(buf))
+}
+
+// bufferRest buffers the unwritten rest of a frame whose first n bytes have been written directly,
+// it must be invoked only when the outbound buffer is empty.
+func (c *conn) bufferRest(buf []byte, n int) {
+	_, _ = c.outboundBuffer.Write(buf[n:])
+	c.frameSizes = append(c.frameSizes, len(buf))
+	c.frameOffset = n
+	c.addPending(len(buf) - n)
+}
+
+// shiftOutbound evicts the written bytes from the outbound buffer and keeps track of the frame boundaries.
+func (c *conn) shiftOutbound(n int) {
+	c.outboundBuffer.Shift(n)
+	c.addPending(-n)
+	for n > 0 && len(c.frameSizes) > 0 {
+		rest := c.frameSizes[0] - c.frameOffset
+		if n < rest {
+			c.frameOffset += n
+			return
+		}
+		n -= rest
+		c.frameSizes = c.frameSizes[1:]
+		c.frameOffset = 0
+	}
+}
+
+// addPending counts the bytes buffered in (or drained from, if n is negative) the outbound buffer for the tenant
+// of the connection, the connection stops being read once it buffers data while its tenant is over MaxPending.
+func (c *conn) addPending(n int) {
+	t := c.tenant
+	if t == nil {
+		return
+	}
+	atomic.AddInt64(&t.pending, int64(n))
+	if n > 0 && !c.tenantPaused && t.overPending() {
+		c.tenantPaused = true
+		atomic.AddInt64(&t.throttled, 1)
+		c.loop.watch(c)
+	}
+}
+
+// setTenant moves the connection to the tenant, see SetTenant.
+func (c *conn) setTenant(name string) error {
+	if c.loop == nil || c.loop.svr.quotas == nil || !c.opened {
+		return ErrProtocolNotSupported
+	}
+	if c.tenant != nil && c.tenant.name == name {
+		return nil
+	}
+	tt := c.loop.svr.quotas
+	t, err := tt.join(name)
+	if err != nil {
+		return err
+	}
+	pending := c.outboundBuffer.Length()
+	if c.tenant != nil {
+		tt.leave(c.tenant, pending)
+	}
+	c.tenant = t
+	atomic.AddInt64(&t.pending, int64(pending))
+	if c.shaping != nil {
+		c.shaping.tenant = &t.buckets
+	}
+	return nil
+}
+
+func (c *conn) tenantName() string {
+	if c.tenant == nil {
+		return ""
+	}
+	return c.tenant.name
+}
+
+// writeUrgent writes a high-priority frame which jumps ahead of the frames in the outbound buffer.
+func (c *conn) writeUrgent(buf []byte) {
+	if c.outboundBuffer.IsEmpty() || c.fault != nil {
+		c.write(buf)
+		return
+	}
+	if c.tap != nil {
+		c.tap.mirror(TapOutbound, buf)
+	}
+	c.urgent = append(c.urgent, buf)
+}
+
+// flushUrgent writes the pending high-priority frames, it must be invoked only when the outbound buffer is
+// at a frame boundary and reports whether all of them have been written.
+func (c *conn) flushUrgent() (bool, error) {
+	for len(c.urgent) > 0 {
+		n, err := unix.Write(c.fd, c.urgent[0][c.urgentOffset:])
+		if err != nil {
+			if err == unix.EAGAIN {
+				return false, nil
+			}
+			return false, err
+		}
+		c.stats.wrote(n)
+		if c.shaping != nil {
+			c.shaping.consumeWrite(n)
+		}
+		if c.urgentOffset += n; c.urgentOffset < len(c.urgent[0]) {
+			return false, nil
+		}
+		c.urgentOffset = 0
+		c.urgent[0] = nil
+		c.urgent = c.urgent[1:]
+	}
+	c.urgent = nil
+	return true, nil
+}
+
 func (c *conn) write(buf []byte) {
+	if c.tap != nil {
+		c.tap.mirror(TapOutbound, buf)
+	}
+	if c.fault != nil {
+		_ = c.fault.inject(FaultWrite, buf)
+		return
+	}
+	c.writeNow(buf)
+}
+
+// writeNow writes the frame to the socket, or buffers it if the socket is not writable.
+func (c *conn) writeNow(buf []byte) {
 	if !c.outboundBuffer.IsEmpty() {
-		_, _ = c.outboundBuffer.Write(buf)
+		c.bufferOutbound(buf)
 		return
 	}
-	n, err := unix.Write(c.fd, buf)
-	if err != nil {
-		if err == unix.EAGAIN {
-			_, _ = c.outboundBuffer.Write(buf)
-			_ = c.loop.poller.ModReadWrite(c.fd)
+	size := len(buf)
+	if c.shaping != nil {
+		if size = c.shaping.writeQuota(size); size == 0 {
+			c.bufferOutbound(buf)
+			c.loop.throttleWrite(c)
 			return
 		}
-		_ = c.loop.loopCloseConn(c, err)
+	}
+	n, err := unix.Write(c.fd, buf[:size])
+	if err != nil {
+		c.bufferOutbound(buf)
+		if !c.loop.writeBlocked(c, err) {
+			_ = c.loop.loopCloseConn(c, err)
+		}
 		return
 	}
+	c.stats.wrote(n)
+	if c.shaping != nil {
+		c.shaping.consumeWrite(n)
+	}
 	if n < len(buf) {
-		_, _ = c.outboundBuffer.Write(buf[n:])
-		_ = c.loop.poller.ModReadWrite(c.fd)
+		atomic.AddInt64(&c.loop.svr.stats.partialWrites, 1)
+		c.bufferRest(buf, n)
+		if c.shaping != nil && c.shaping.writeQuota(1) == 0 {
+			c.loop.throttleWrite(c)
+			return
+		}
+		c.loop.watch(c)
 	}
 }
 
@@ -192,7 +536,7 @@ func (c *conn) BufferLength() int {
 func (c *conn) AsyncWrite(buf []byte) (err error) {
 	var encodedBuf []byte
 	if encodedBuf, err = c.codec.Encode(c, buf); err == nil {
-		return c.loop.poller.Trigger(func() error {
+		return c.triggerWrite(func() error {
 			if c.opened {
 				c.write(encodedBuf)
 			}
@@ -202,23 +546,288 @@ func (c *conn) AsyncWrite(buf []byte) (err error) {
 	return
 }
 
+func (c *conn) AsyncWriteWithPriority(buf []byte, priority WritePriority) (err error) {
+	if priority == PriorityNormal {
+		return c.AsyncWrite(buf)
+	}
+	var encodedBuf []byte
+	if encodedBuf, err = c.codec.Encode(c, buf); err == nil {
+		return c.triggerWrite(func() error {
+			if c.opened {
+				c.writeUrgent(encodedBuf)
+			}
+			return nil
+		})
+	}
+	return
+}
+
+func (c *conn) Writer() io.Writer {
+	c.writerOnce.Do(func() {
+		el := c.eventLoop()
+		if el == nil {
+			c.writer = newConnWriter(StreamWriter{}, func([]byte) error { return ErrProtocolNotSupported })
+			return
+		}
+		var w *connWriter
+		w = newConnWriter(el.svr.tunings().StreamWriter, func(chunk []byte) error {
+			return c.triggerWrite(func() error {
+				if !c.opened {
+					w.fail(ErrConnectionClosed)
+					return nil
+				}
+				c.stream = w
+				c.write(chunk)
+				if c.outboundBuffer != nil {
+					w.settle(len(chunk), c.outboundBuffer.Length())
+				}
+				return nil
+			})
+		})
+		c.writer = w
+	})
+	return c.writer
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	return c.Writer().Write(p)
+}
+
+func (c *conn) ReadFrom(r io.Reader) (n int64, err error) {
+	if c.eventLoop() != nil {
+		var handled bool
+		if n, handled, err = c.sendFile(r); handled {
+			return
+		}
+	}
+	w := c.Writer().(*connWriter)
+	if n, err = io.Copy(w, r); err == nil {
+		err = w.drain()
+	}
+	return
+}
+
+func (c *conn) WriteTo(w io.Writer) (n int64, err error) {
+	return writeInboundTo(c, w)
+}
+
 func (c *conn) SendTo(buf []byte) error {
 	return c.sendTo(buf)
 }
 
+func (c *conn) Upgrade(codec ICodec) {
+	c.codec = codec
+}
+
+func (c *conn) Detach() (net.Conn, error) {
+	if c.loop == nil {
+		return nil, ErrProtocolNotSupported
+	}
+	if !c.opened {
+		return nil, ErrConnectionClosed
+	}
+	dc, err := c.loop.detach(c)
+	if err != nil {
+		return nil, err
+	}
+	c.releaseTCP()
+	return dc, nil
+}
+
+func (c *conn) Tick(interval time.Duration, fn ConnTickFunc) (cancel func()) {
+	t := newConnTicker(interval, fn)
+	c.tickers = append(c.tickers, t)
+	c.loop.scheduleTick(c, t)
+	return func() {
+		if !t.stopped {
+			t.stopped = true
+			c.loop.poller.DelTimer(t.timer)
+			c.tickers = removeTicker(c.tickers, t)
+		}
+	}
+}
+
 func (c *conn) Wake() error {
-	return c.loop.poller.Trigger(func() error {
+	return c.trigger(func() error {
 		return c.loop.loopWake(c)
 	})
 }
 
+func (c *conn) priority() int {
+	return c.settings.Priority
+}
+
+func (c *conn) LoopIndex() int {
+	if el := c.eventLoop(); el != nil {
+		return el.idx
+	}
+	return -1
+}
+
+func (c *conn) Migrate(idx int) error {
+	el := c.eventLoop()
+	if el == nil {
+		return ErrProtocolNotSupported
+	}
+	to := el.svr.loopAt(idx)
+	if to == nil {
+		return ErrInvalidLoopIndex
+	}
+	return c.trigger(func() error {
+		return c.loop.loopMigrate(c, to)
+	})
+}
+
+// schedule runs the job on the event-loop owning the connection after the given delay, it must be invoked
+// on the event-loop.
+func (c *conn) schedule(delay time.Duration, job func() error) {
+	el := c.loop
+	el.schedule(delay, func() error {
+		if c.eventLoop() != el {
+			// The connection has migrated since.
+			sniffError(c.trigger(job))
+			return nil
+		}
+		return job()
+	})
+}
+
+func (c *conn) PauseRead() error {
+	if !atomic.CompareAndSwapInt32(&c.pausedRead, 0, 1) {
+		return nil
+	}
+	return c.trigger(func() error {
+		if c.opened {
+			c.loop.watch(c)
+		}
+		return nil
+	})
+}
+
+func (c *conn) ResumeRead() error {
+	if !atomic.CompareAndSwapInt32(&c.pausedRead, 1, 0) {
+		return nil
+	}
+	return c.trigger(func() error {
+		return c.loop.loopResumeRead(c)
+	})
+}
+
+// readPaused reports whether reading is stopped by PauseRead.
+func (c *conn) readPaused() bool {
+	return atomic.LoadInt32(&c.pausedRead) == 1
+}
+
+func (c *conn) SyscallConn() (syscall.RawConn, error) {
+	if c.eventLoop() == nil {
+		return nil, ErrProtocolNotSupported
+	}
+	return rawConn{c}, nil
+}
+
+// rawConn is the syscall.RawConn of a TCP connection, see Conn.SyscallConn.
+type rawConn struct {
+	c *conn
+}
+
+func (rc rawConn) Control(f func(fd uintptr)) error {
+	c, done := rc.c, make(chan error, 1)
+	if err := c.trigger(func() error {
+		done <- c.loop.loopControl(c, f)
+		return nil
+	}); err != nil {
+		return err
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-c.eventLoop().svr.loopsDone:
+		return ErrServerShutdown
+	}
+}
+
+func (rc rawConn) Read(f func(fd uintptr) (done bool)) error {
+	return ErrProtocolNotSupported
+}
+
+func (rc rawConn) Write(f func(fd uintptr) (done bool)) error {
+	return ErrProtocolNotSupported
+}
+
+// Close jumps ahead of the ordinary jobs queued on the event-loop, unless the connection has asynchronous writes
+// queued, which it must not overtake.
 func (c *conn) Close() error {
-	return c.loop.poller.Trigger(func() error {
+	closeConn := func() error {
 		return c.loop.loopCloseConn(c, nil)
-	})
+	}
+	if atomic.LoadInt32(&c.queuedWrites) > 0 {
+		return c.trigger(closeConn)
+	}
+	return c.triggerUrgent(closeConn)
 }
 
 func (c *conn) Context() interface{}       { return c.ctx }
 func (c *conn) SetContext(ctx interface{}) { c.ctx = ctx }
 func (c *conn) LocalAddr() net.Addr        { return c.localAddr }
 func (c *conn) RemoteAddr() net.Addr       { return c.remoteAddr }
+func (c *conn) Peer() *Peer                { return c.peer }
+func (c *conn) CloseReason() CloseReason   { return c.closeReason }
+
+func (c *conn) Stats() ConnStats {
+	if c.inboundBuffer == nil {
+		return ConnStats{}
+	}
+	outbound := c.outboundBuffer.Length()
+	for _, buf := range c.urgent {
+		outbound += len(buf)
+	}
+	return c.stats.snapshot(c.BufferLength(), outbound-c.urgentOffset)
+}
+
+func (c *conn) SetTOS(tos byte) error {
+	if c.loop != nil && !c.opened {
+		return ErrConnectionClosed
+	}
+	return netpoll.SetTOS(c.fd, int(tos))
+}
+
+func (c *conn) datagramECN() (ECN, error) {
+	if c.udpLoop == nil || c.udpTOS < 0 {
+		return ECNNotECT, ErrProtocolNotSupported
+	}
+	return ECN(c.udpTOS & 3), nil
+}
+
+func (c *conn) sendToECN(buf []byte, ecn ECN) error {
+	if c.udpLoop == nil {
+		return ErrProtocolNotSupported
+	}
+	return netpoll.SendmsgTOS(c.fd, buf, c.sa, int(c.udpLoop.svr.opts.TOS)&^3|int(ecn&3))
+}
+
+func (c *conn) tcpECN() (bool, error) {
+	switch {
+	case c.loop == nil:
+		return false, ErrProtocolNotSupported
+	case !c.opened:
+		return false, ErrConnectionClosed
+	case c.loop.svr.ln.network == "unix" || c.loop.svr.ln.network == "pipe" || c.loop.svr.ln.network == "vsock":
+		return false, ErrProtocolNotSupported
+	}
+	return netpoll.TCPECN(c.fd)
+}
+
+func (c *conn) OriginalDst() (net.Addr, error) {
+	switch {
+	case c.loop == nil:
+		// The local address of a UDP datagram is its original destination with WithTransparent.
+		return c.localAddr, nil
+	case !c.opened:
+		return nil, ErrConnectionClosed
+	case c.loop.svr.opts.Transparent:
+		return c.localAddr, nil
+	case c.loop.svr.ln.network == "unix" || c.loop.svr.ln.network == "pipe" || c.loop.svr.ln.network == "vsock":
+		return nil, ErrProtocolNotSupported
+	}
+	return netpoll.OriginalDst(c.fd)
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "time"
+
+// ConnStats are the statistics of a connection, which are maintained by its event-loop.
+type ConnStats struct {
+	// BytesRead and BytesWritten are the numbers of bytes read from and written to the socket.
+	BytesRead, BytesWritten int64
+
+	// FramesDecoded is the number of frames decoded by the codec and fed to React.
+	FramesDecoded int64
+
+	// InboundBuffered is the number of bytes read but not decoded into frames yet.
+	InboundBuffered int
+
+	// OutboundBuffered is the number of bytes waiting to be written to the socket, it is always zero on Windows
+	// where the data is written to the socket directly.
+	OutboundBuffered int
+
+	// CreatedAt is when the connection was opened.
+	CreatedAt time.Time
+
+	// LastActivity is when data was last read from or written to the socket.
+	LastActivity time.Time
+}
+
+// connStats holds the counters of a connection, it is only accessed by the event-loop of the connection.
+type connStats struct {
+	bytesRead, bytesWritten, framesDecoded int64
+	createdAt, lastActivity, lastRead      time.Time
+	busy                                   int64 // nanoseconds spent in reading and React, see RebalanceCallbackTime
+	sampled                                int64 // the load at the last sample of the rebalancer
+}
+
+func (s *connStats) open() {
+	s.createdAt = time.Now()
+	s.lastActivity = s.createdAt
+	s.lastRead = s.createdAt
+}
+
+func (s *connStats) read(n int) {
+	s.bytesRead += int64(n)
+	s.lastActivity = time.Now()
+	s.lastRead = s.lastActivity
+}
+
+func (s *connStats) wrote(n int) {
+	if n > 0 {
+		s.bytesWritten += int64(n)
+		s.lastActivity = time.Now()
+	}
+}
+
+// sampleLoad returns the load of the connection by the metric since the last sample.
+func (s *connStats) sampleLoad(metric RebalanceMetric) int64 {
+	load := s.busy
+	if metric == RebalanceBytes {
+		load = s.bytesRead + s.bytesWritten
+	}
+	delta := load - s.sampled
+	s.sampled = load
+	return delta
+}
+
+func (s *connStats) snapshot(inbound, outbound int) ConnStats {
+	return ConnStats{
+		BytesRead:        s.bytesRead,
+		BytesWritten:     s.bytesWritten,
+		FramesDecoded:    s.framesDecoded,
+		InboundBuffered:  inbound,
+		OutboundBuffered: outbound,
+		CreatedAt:        s.createdAt,
+		LastActivity:     s.lastActivity,
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+// DecodeFrames decodes the frames in data with the given codec as if data was read from a connection at once,
+// it returns the decoded frames and the number of bytes left in the inbound buffer. It runs the codec the same
+// way as an event-loop does without any connection, which makes it suitable for unit-testing and fuzzing codecs.
+// If there are bytes left, err is the error returned by the codec when it failed to decode them,
+// so is it if the codec failed with ErrCorruptFrame or ErrFrameTooLarge, upon which an event-loop closes
+// the connection.
+func DecodeFrames(codec ICodec, data []byte) (frames [][]byte, leftover int, err error) {
+	c := &memConn{buffer: data, codec: codec}
+	for {
+		size := c.BufferLength()
+		frame, e := c.codec.Decode(c)
+		if frame == nil {
+			if leftover = c.BufferLength(); leftover > 0 || isFatalDecodeError(e) {
+				err = e
+			}
+			return
+		}
+		frames = append(frames, copyBytes(frame))
+		if c.BufferLength() >= size {
+			// The codec produced a frame without consuming anything, an event-loop would spin forever.
+			return frames, c.BufferLength(), ErrDecoderStalled
+		}
+	}
+}
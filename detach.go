@@ -0,0 +1,55 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"net"
+	"sync"
+)
+
+// detachedConn is a connection detached from an event-loop, it yields the inbound data left by the event-loop
+// before reading the socket and writes the outbound data left by the event-loop before anything else.
+type detachedConn struct {
+	net.Conn
+	mu       sync.Mutex
+	ready    chan struct{} // closed once the event-loop has handed over all the inbound data
+	prefix   []byte        // inbound data left by the event-loop
+	flushed  chan struct{} // closed once the outbound data left by the event-loop has been written
+	flushErr error
+}
+
+func newDetachedConn(conn net.Conn, pending []byte) *detachedConn {
+	dc := &detachedConn{Conn: conn, ready: make(chan struct{}), flushed: make(chan struct{})}
+	if len(pending) == 0 {
+		close(dc.flushed)
+		return dc
+	}
+	go func() {
+		_, dc.flushErr = conn.Write(pending)
+		close(dc.flushed)
+	}()
+	return dc
+}
+
+func (dc *detachedConn) Read(p []byte) (int, error) {
+	<-dc.ready
+	dc.mu.Lock()
+	if len(dc.prefix) > 0 {
+		n := copy(p, dc.prefix)
+		dc.prefix = dc.prefix[n:]
+		dc.mu.Unlock()
+		return n, nil
+	}
+	dc.mu.Unlock()
+	return dc.Conn.Read(p)
+}
+
+func (dc *detachedConn) Write(p []byte) (int, error) {
+	<-dc.flushed
+	if dc.flushErr != nil {
+		return 0, dc.flushErr
+	}
+	return dc.Conn.Write(p)
+}
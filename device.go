@@ -0,0 +1,39 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/panlibin/gnet/internal/netpoll"
+)
+
+// NewDeviceDialer returns a dialer whose sockets are bound to the given network interface by SO_BINDTODEVICE,
+// so that the client connections of a multi-network appliance leave through the interface regardless of the
+// routing table. On Linux, device may name a VRF device to dial within the VRF.
+func NewDeviceDialer(device string) *net.Dialer {
+	return &net.Dialer{Control: bindToDeviceControl(device)}
+}
+
+// bindToDeviceControl returns a net.Dialer/net.ListenConfig control function binding sockets to device.
+func bindToDeviceControl(device string) func(network, address string, c syscall.RawConn) error {
+	return sockoptControl(func(fd int) error {
+		return netpoll.BindToDevice(fd, device)
+	})
+}
+
+// sockoptControl returns a net.Dialer/net.ListenConfig control function setting up sockets by setsockopt.
+func sockoptControl(setsockopt func(fd int) error) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if e := c.Control(func(fd uintptr) {
+			err = setsockopt(int(fd))
+		}); e != nil {
+			return e
+		}
+		return err
+	}
+}
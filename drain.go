@@ -0,0 +1,55 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// drainPollInterval is how often Drain checks whether the connections have been closed.
+const drainPollInterval = 10 * time.Millisecond
+
+// Drain shuts down the server gracefully for a rolling deploy: it stops accepting new connections, fires
+// OnDraining for every connection so that the event handler can tell the peers to go away, waits up to grace
+// for the connections to be closed and then shuts down the server, closing the connections which remain.
+// It returns once the server has been shut down. A UDP server is shut down at once since it has no connections
+// to drain.
+func (s *GServer) Drain(grace time.Duration) {
+	svr := s.s
+	if svr == nil {
+		return
+	}
+	svr.stopAccepting()
+	shutdown := svr.drainConns()
+	deadline := time.Now().Add(grace)
+	for !shutdown && time.Now().Before(deadline) && s.numConns() > 0 {
+		time.Sleep(drainPollInterval)
+	}
+	s.Stop()
+}
+
+// numConns returns the number of connections of the server.
+func (s *GServer) numConns() (n int) {
+	s.s.forEachConn(func(c Conn) bool {
+		n++
+		return true
+	})
+	return
+}
+
+// drainOnSignal drains the server with the given grace period once the process receives SIGTERM.
+func (s *GServer) drainOnSignal(grace time.Duration) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	select {
+	case <-sig:
+		s.Drain(grace)
+	case <-s.s.loopsDone:
+	}
+}
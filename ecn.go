@@ -0,0 +1,72 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+// ECN is an Explicit Congestion Notification codepoint of RFC 3168, the lower two bits of the IP_TOS
+// (IPV6_TCLASS) byte.
+type ECN byte
+
+const (
+	// ECNNotECT marks the packets of the transports which are not ECN-capable.
+	ECNNotECT ECN = iota
+
+	// ECNECT1 marks the packets of ECN-capable transports, e.g. of L4S.
+	ECNECT1
+
+	// ECNECT0 marks the packets of ECN-capable transports.
+	ECNECT0
+
+	// ECNCE marks the packets which have experienced congestion.
+	ECNCE
+)
+
+// String returns the name of the codepoint.
+func (e ECN) String() string {
+	switch e {
+	case ECNNotECT:
+		return "Not-ECT"
+	case ECNECT1:
+		return "ECT(1)"
+	case ECNECT0:
+		return "ECT(0)"
+	case ECNCE:
+		return "CE"
+	}
+	return "unknown"
+}
+
+// DatagramECN returns the ECN codepoint of the UDP datagram handed to React, the server must be set up by
+// WithECN. It fails with ErrProtocolNotSupported otherwise.
+func DatagramECN(c Conn) (ECN, error) {
+	if c, ok := c.(interface {
+		datagramECN() (ECN, error)
+	}); ok {
+		return c.datagramECN()
+	}
+	return ECNNotECT, ErrProtocolNotSupported
+}
+
+// SendToECN sends the datagram to the client of the UDP datagram handed to React with the ECN codepoint,
+// the DSCP of the packet is that of Options.TOS. It is Linux only and fails with ErrProtocolNotSupported
+// for TCP.
+func SendToECN(c Conn, buf []byte, ecn ECN) error {
+	if c, ok := c.(interface {
+		sendToECN(buf []byte, ecn ECN) error
+	}); ok {
+		return c.sendToECN(buf, ecn)
+	}
+	return ErrProtocolNotSupported
+}
+
+// TCPECN reports whether ECN has been negotiated on the TCP connection, which is up to the net.ipv4.tcp_ecn
+// sysctl of both ends. It is retrieved from TCP_INFO, Linux only, and must be invoked on the event-loop.
+func TCPECN(c Conn) (bool, error) {
+	if c, ok := c.(interface {
+		tcpECN() (bool, error)
+	}); ok {
+		return c.tcpECN()
+	}
+	return false, ErrProtocolNotSupported
+}
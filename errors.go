@@ -1,10 +1,15 @@
 package gnet
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	// ErrProtocolNotSupported occurs when trying to use protocol that is not supported.
 	ErrProtocolNotSupported = errors.New("not supported protocol on this platform")
+	// ErrInvalidOptions occurs when the options of a server are invalid or do not work together.
+	ErrInvalidOptions = errors.New("invalid options")
 	// ErrServerShutdown occurs when server is closing.
 	ErrServerShutdown = errors.New("server is going to be shutdown")
 	// ErrInvalidFixedLength occurs when the output data have invalid fixed length.
@@ -13,10 +18,98 @@ var (
 	ErrUnexpectedEOF = errors.New("there is no enough data")
 	// ErrDelimiterNotFound occurs when no such a delimiter is in input data.
 	ErrDelimiterNotFound = errors.New("there is no such a delimiter")
+	// ErrDelimiterInFrame occurs when a frame to be encoded contains the delimiter and escaping is disabled.
+	ErrDelimiterInFrame = errors.New("frame contains the delimiter")
 	// ErrCRLFNotFound occurs when a CRLF is not found by codec.
 	ErrCRLFNotFound = errors.New("there is no CRLF")
 	// ErrUnsupportedLength occurs when unsupported lengthFieldLength is from input data.
 	ErrUnsupportedLength = errors.New("unsupported lengthFieldLength. (expected: 1, 2, 3, 4, or 8)")
+	// ErrServerOverloaded occurs when a connection is dropped by load shedding.
+	ErrServerOverloaded = errors.New("server is overloaded")
+	// ErrPipeNotFound occurs when dialing a pipe which no server is serving on.
+	ErrPipeNotFound = errors.New("no server is serving on the pipe")
+	// ErrPipeInUse occurs when serving on a pipe which another server is already serving on.
+	ErrPipeInUse = errors.New("pipe is already in use")
+	// ErrDecoderStalled occurs when a codec decodes a frame without consuming any input.
+	ErrDecoderStalled = errors.New("codec decoded a frame without consuming input")
 	// ErrTooLessLength occurs when adjusted frame length is less than zero.
 	ErrTooLessLength = errors.New("adjusted frame length is less than zero")
+	// ErrTooLessStripLength occurs when adjusted frame length is less than initial bytes to strip.
+	ErrTooLessStripLength = errors.New("adjusted frame length is less than initial bytes to strip")
+	// ErrConnectionClosed occurs when writing to a connection which has been closed.
+	ErrConnectionClosed = errors.New("connection has been closed")
+	// ErrConnectionDetached occurs when writing to a connection which has been detached from the event-loop.
+	ErrConnectionDetached = errors.New("connection has been detached")
+	// ErrClientDisconnected occurs when writing to a client which is disconnected and cannot buffer the frame.
+	ErrClientDisconnected = errors.New("client is disconnected")
+	// ErrReconnectFailed occurs when a client has given up reconnecting.
+	ErrReconnectFailed = errors.New("client failed to reconnect")
+	// ErrTooManyStreams occurs when opening a stream of a multiplexing session which has reached its limit.
+	ErrTooManyStreams = errors.New("too many streams")
+	// ErrWriterFull occurs when a nonblocking stream writer is above its high watermark.
+	ErrWriterFull = errors.New("stream writer is above the high watermark")
+	// ErrCorruptFrame occurs when a codec decodes malformed data, the connection is closed then.
+	ErrCorruptFrame = errors.New("frame is corrupted")
+	// ErrFrameTooLarge occurs when a codec decodes a frame exceeding its maximum length, the connection is closed then.
+	ErrFrameTooLarge = errors.New("frame exceeds the maximum length")
+	// ErrInvalidLoopIndex occurs when migrating a connection to an event-loop which does not exist.
+	ErrInvalidLoopIndex = errors.New("event-loop index is out of range")
+	// ErrSlowConsumer occurs when a connection is closed by SlowConsumerClose.
+	ErrSlowConsumer = errors.New("outbound data has stalled on a slow consumer")
+	// ErrMalformedSTUN occurs when parsing data which is not a whole STUN message.
+	ErrMalformedSTUN = errors.New("malformed STUN message")
+	// ErrSTUNAttrNotFound occurs when getting an attribute which a STUN message does not have.
+	ErrSTUNAttrNotFound = errors.New("STUN attribute not found")
+	// ErrMalformedNetlink occurs when parsing data which is not a sequence of whole netlink messages or attributes.
+	ErrMalformedNetlink = errors.New("malformed netlink message")
+	// ErrHandshakeTimeout occurs when a connection is closed as it has not completed its handshake in time, see
+	// Options.HandshakeTimeout.
+	ErrHandshakeTimeout = errors.New("connection has not completed its handshake in time")
+	// ErrFirstByteTimeout occurs when a connection is closed as it has sent nothing in time after it has been
+	// accepted, see Options.FirstByteTimeout.
+	ErrFirstByteTimeout = errors.New("connection has not sent its first byte in time")
+	// ErrConnMaxLifetime occurs when a connection is closed as it has reached its maximum lifetime, see
+	// Options.ConnMaxLifetime.
+	ErrConnMaxLifetime = errors.New("connection has reached its maximum lifetime")
+	// ErrIdleTimeout occurs when a connection is closed as it has read nothing since it was probed, see
+	// Options.IdleReaper.
+	ErrIdleTimeout = errors.New("connection has been idle for too long")
+	// ErrServerRunning occurs when serving or restarting a GServer which has not been shut down yet.
+	ErrServerRunning = errors.New("server is already serving")
+	// ErrServerNotStarted occurs when restarting a GServer which has never served.
+	ErrServerNotStarted = errors.New("server has never been started")
+	// ErrQuotaExceeded occurs when a connection is over the quota of its tenant, see Options.Quotas.
+	ErrQuotaExceeded = errors.New("tenant quota exceeded")
+	// ErrInboundTooLarge occurs when a connection is closed as it holds more inbound data than it may, see
+	// ConnSettings.MaxInbound.
+	ErrInboundTooLarge = errors.New("inbound data exceeds the maximum length")
 )
+
+// LoopError is the cause handed to OnShutdown when an event-loop has died of an error, e.g. its poller has failed,
+// which brings the whole server down: the listener is closed, the other event-loops are stopped and every
+// connection is closed before OnShutdown fires.
+type LoopError struct {
+	// Loop is the index of the event-loop, negative for the loops accepting connections,
+	// -1-i for the i-th acceptor counting from zero, the main reactor being the first one.
+	Loop int
+
+	// Err is the error the event-loop has died of.
+	Err error
+}
+
+func (e *LoopError) Error() string {
+	return fmt.Sprintf("event-loop %d: %v", e.Loop, e.Err)
+}
+
+// Unwrap returns the error the event-loop has died of.
+func (e *LoopError) Unwrap() error {
+	return e.Err
+}
+
+// loopFailure wraps the error an event-loop has died of, nil and ErrServerShutdown are a normal exit.
+func loopFailure(idx int, err error) error {
+	if err == nil || err == ErrServerShutdown {
+		return err
+	}
+	return &LoopError{Loop: idx, Err: err}
+}
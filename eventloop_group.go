@@ -4,6 +4,8 @@
 
 package gnet
 
+import "sync/atomic"
+
 // LoadBalance sets the load balancing method.
 //type LoadBalance int
 //
@@ -28,7 +30,7 @@ type (
 	}
 
 	eventLoopGroup struct {
-		nextLoopIndex int
+		nextLoopIndex uint32 // accessed atomically, the acceptors pick the loops concurrently
 		eventLoops    []*eventloop
 		size          int
 	}
@@ -42,11 +44,7 @@ func (g *eventLoopGroup) register(el *eventloop) {
 // Built-in load-balance algorithm is Round-Robin.
 // TODO: support more load-balance algorithms.
 func (g *eventLoopGroup) next() (el *eventloop) {
-	el = g.eventLoops[g.nextLoopIndex]
-	if g.nextLoopIndex++; g.nextLoopIndex >= g.size {
-		g.nextLoopIndex = 0
-	}
-	return
+	return g.eventLoops[(atomic.AddUint32(&g.nextLoopIndex, 1)-1)%uint32(g.size)]
 }
 
 func (g *eventLoopGroup) iterate(f func(int, *eventloop) bool) {
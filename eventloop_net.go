@@ -0,0 +1,450 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Copyright 2018 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows gnet_net
+
+package gnet
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/panlibin/gnet/internal"
+	"github.com/panlibin/gnet/pool/bytebuffer"
+)
+
+type eventloop struct {
+	ch           chan interface{}      // command channel
+	idx          int                   // loop index
+	svr          *server               // server in loop
+	codec        ICodec                // codec for TCP
+	connections  map[*stdConn]bool     // track all the sockets bound to this loop
+	eventHandler EventHandler          // user eventHandler
+	metrics      *internal.LoopMetrics // histograms of the loop, nil unless Options.LoopMetrics is set
+	handoff      *handoffQueue         // new connections handed over by the acceptor, nil unless Handoff.Direct is set
+}
+
+func (el *eventloop) loopRun() {
+	var err error
+	defer func() {
+		if el.idx == 0 && el.svr.opts.Ticker {
+			close(el.svr.ticktock)
+		}
+		el.svr.signalShutdown()
+		el.svr.loopWG.Done()
+		el.loopEgress()
+		el.svr.loopWG.Done()
+	}()
+	if el.idx == 0 && el.svr.opts.Ticker {
+		go el.loopTicker()
+	}
+	for v := range el.ch {
+		if el.metrics != nil {
+			el.metrics.QueueDepth.Record(int64(len(el.ch)))
+		}
+		// The connections handed over directly are taken first, as their reads may follow in the channel.
+		if err = el.loopHandoff(); err == nil {
+			switch v := v.(type) {
+			case error:
+				err = v
+			case *stdConn:
+				err = el.loopAccept(v)
+			case *tcpIn:
+				err = el.loopRead(v)
+			case *udpIn:
+				err = el.loopReadUDP(v.c)
+			case *stderr:
+				err = el.loopError(v.c, v.err)
+			case wakeReq:
+				err = el.loopWake(v.c)
+			case func() error:
+				if err = v(); err != nil {
+					err = el.jobFailed(err)
+				}
+			}
+		}
+		if err != nil {
+			if err != errClosing {
+				el.svr.fail(loopFailure(el.idx, err))
+			}
+			el.svr.logger.Printf("event-loop:%d exits with error:%v\n", el.idx, err)
+			break
+		}
+	}
+}
+
+func (el *eventloop) loopAccept(c *stdConn) error {
+	el.connections[c] = true
+	c.stats.open()
+	c.localAddr = el.svr.ln.lnaddr
+	c.remoteAddr = normalizeAddr(c.conn.RemoteAddr())
+	if tagger := el.svr.opts.PeerTagger; tagger != nil {
+		c.peer = tagger(c.remoteAddr)
+	}
+	if el.svr.tapper != nil {
+		c.tap = el.svr.tapper.attach(c)
+	}
+	if policy := el.svr.opts.FaultPolicy; policy != nil {
+		c.fault = newConnFault(c, policy, el.schedule, func(data []byte) error {
+			buf := bytebuffer.Get()
+			_, _ = buf.Write(data)
+			return el.loopInbound(c, buf)
+		}, func(data []byte) error {
+			if _, err := c.conn.Write(data); err != nil {
+				return el.loopClose(c)
+			}
+			return nil
+		})
+	}
+
+	c.settings = el.eventHandler.OnConfigure(c)
+	if c.settings.Codec != nil {
+		c.codec = c.settings.Codec
+	}
+	out, action := el.eventHandler.OnOpened(c)
+	if c.detached != nil {
+		return nil // detached by the event handler
+	}
+	if out != nil {
+		el.eventHandler.PreWrite()
+		_, _ = c.write(out)
+	}
+	if keepAlive := el.svr.tunings().TCPKeepAlive; keepAlive > 0 {
+		if c, ok := c.conn.(*net.TCPConn); ok {
+			_ = c.SetKeepAlive(true)
+			_ = c.SetKeepAlivePeriod(keepAlive)
+		}
+	}
+	return el.handleAction(c, action)
+}
+
+func (el *eventloop) loopRead(ti *tcpIn) (err error) {
+	c := ti.c
+	if c.detached != nil {
+		return el.loopInbound(c, ti.in)
+	}
+	c.stats.read(ti.in.Len())
+	if c.tap != nil {
+		c.tap.mirror(TapInbound, ti.in.Bytes())
+	}
+	if c.fault != nil {
+		err = c.fault.inject(FaultRead, ti.in.Bytes())
+		bytebuffer.Put(ti.in)
+		return
+	}
+	return el.loopInbound(c, ti.in)
+}
+
+// loopInbound decodes the inbound data and feeds the frames to the event handler.
+func (el *eventloop) loopInbound(c *stdConn, in *bytebuffer.ByteBuffer) (err error) {
+	if dc := c.detached; dc != nil {
+		dc.mu.Lock()
+		dc.prefix = append(dc.prefix, in.Bytes()...)
+		dc.mu.Unlock()
+		bytebuffer.Put(in)
+		return nil
+	}
+	c.buffer = in
+
+	for !c.throttled && !c.readPaused() {
+		inFrame, e := c.read()
+		if inFrame == nil {
+			if isFatalDecodeError(e) {
+				return el.loopError(c, e)
+			}
+			break
+		}
+		c.stats.framesDecoded++
+		out, action := el.eventHandler.React(inFrame, c)
+		if c.detached != nil {
+			return nil // detached by the event handler
+		}
+		if out != nil {
+			outFrame, _ := c.codec.Encode(c, out)
+			el.eventHandler.PreWrite()
+			_, err = c.write(outFrame)
+		}
+		switch action {
+		case None:
+		case Close:
+			return el.loopClose(c)
+		case Shutdown:
+			return ErrServerShutdown
+		case Throttle:
+			c.throttled = true
+			c.pauseReading()
+		case Detach:
+			return el.loopDetach(c)
+		}
+		if err != nil {
+			return el.loopError(c, err)
+		}
+	}
+	_, _ = c.inboundBuffer.Write(c.buffer.Bytes())
+	bytebuffer.Put(c.buffer)
+	c.buffer = nil
+	if max := c.settings.MaxInbound; max > 0 && c.inboundBuffer.Length() > max {
+		return el.loopError(c, ErrInboundTooLarge)
+	}
+	return nil
+}
+
+// scheduleTick schedules the next run of the periodic callback of the connection.
+func (el *eventloop) scheduleTick(c *stdConn, t *connTicker) {
+	el.schedule(t.interval, func() error {
+		if t.stopped {
+			return nil
+		}
+		out, action := t.fn(c)
+		if c.detached != nil {
+			return nil
+		}
+		if out != nil {
+			frame, _ := c.codec.Encode(c, out)
+			_, _ = c.write(frame)
+		}
+		if !t.stopped {
+			el.scheduleTick(c, t)
+		}
+		return el.handleAction(c, action)
+	})
+}
+
+// schedule runs the job on the event-loop after the given delay.
+func (el *eventloop) schedule(delay time.Duration, job func() error) {
+	time.AfterFunc(delay, func() {
+		el.ch <- job
+	})
+}
+
+func (el *eventloop) loopClose(c *stdConn) error {
+	atomic.StoreInt32(&c.done, 1)
+	err := c.conn.SetReadDeadline(time.Now())
+	c.resumeReading()
+	return err
+}
+
+// loopDetach removes the connection from the event-loop and hands it over to the event handler as a net.Conn,
+// the data read by the reading goroutine until it stops is handed over as well.
+func (el *eventloop) loopDetach(c *stdConn) error {
+	dc := el.detach(c)
+	action := el.eventHandler.OnDetached(c, dc)
+	c.releaseTCP()
+	if action == Shutdown {
+		return ErrServerShutdown
+	}
+	return nil
+}
+
+// detach removes the connection from the event-loop and returns it as a net.Conn.
+func (el *eventloop) detach(c *stdConn) *detachedConn {
+	atomic.StoreInt32(&c.done, 2)
+	dc := newDetachedConn(c.conn, nil)
+	head, tail := c.inboundBuffer.LazyReadAll()
+	dc.prefix = append(append(dc.prefix, head...), tail...)
+	if c.buffer != nil {
+		dc.prefix = append(dc.prefix, c.buffer.Bytes()...)
+	}
+	c.detached = dc
+	delete(el.connections, c)
+	el.releaseLoopState(c, ErrConnectionDetached)
+	_ = c.conn.SetReadDeadline(time.Now())
+	c.resumeReading()
+	return dc
+}
+
+// releaseLoopState stops everything the event-loop keeps for the connection when it leaves the event-loop,
+// err is reported to the stream writer.
+func (el *eventloop) releaseLoopState(c *stdConn, err error) {
+	if c.fault != nil {
+		c.fault.close()
+	}
+	if c.stream != nil {
+		c.stream.fail(err)
+	}
+	for _, t := range c.tickers {
+		t.stopped = true
+	}
+	c.tickers = nil
+	releaseInterned(c.interned)
+	c.interned = nil
+}
+
+func (el *eventloop) loopEgress() {
+	var closed bool
+	for v := range el.ch {
+		switch v := v.(type) {
+		case error:
+			if v == errCloseConns {
+				closed = true
+				for c := range el.connections {
+					_ = el.loopClose(c)
+				}
+			}
+		case *stderr:
+			_ = el.loopError(v.c, v.err)
+		}
+		if len(el.connections) == 0 && closed {
+			break
+		}
+	}
+}
+
+// jobFailed logs the error of an asynchronous job, which doesn't stop the event-loop unless it shuts the
+// server down.
+func (el *eventloop) jobFailed(err error) error {
+	if err == ErrServerShutdown || err == errClosing {
+		return err
+	}
+	atomic.AddInt64(&el.svr.stats.jobErrors, 1)
+	el.svr.logger.Printf("event-loop:%d job failed with error:%v\n", el.idx, err)
+	return nil
+}
+
+func (el *eventloop) loopTicker() {
+	var (
+		delay time.Duration
+		open  bool
+	)
+	for {
+		el.ch <- func() (err error) {
+			delay, action := el.eventHandler.Tick()
+			el.svr.ticktock <- delay
+			switch action {
+			case Shutdown:
+				err = errClosing
+			}
+			return
+		}
+		if delay, open = <-el.svr.ticktock; open {
+			time.Sleep(delay)
+		} else {
+			break
+		}
+	}
+}
+
+func (el *eventloop) loopError(c *stdConn, err error) (e error) {
+	if !el.connections[c] {
+		// Closed already by the event-loop, e.g. on ErrInboundTooLarge, the reading goroutine reports it again.
+		return nil
+	}
+	if e = c.conn.Close(); e == nil {
+		delete(el.connections, c)
+		el.releaseLoopState(c, ErrConnectionClosed)
+		switch atomic.LoadInt32(&c.done) {
+		case 0: // read error
+			if err != io.EOF {
+				el.svr.logger.Printf("socket: %s with err: %v\n", c.remoteAddr.String(), err)
+			}
+			c.closeReason = CloseReasonOf(err)
+		case 1: // closed
+			el.svr.logger.Printf("socket: %s has been closed by client\n", c.remoteAddr.String())
+			c.closeReason = CloseServer
+		}
+		switch el.eventHandler.OnClosed(c, err) {
+		case Shutdown:
+			return errClosing
+		}
+		c.releaseTCP()
+	} else {
+		el.svr.logger.Printf("failed to close connection:%s, error:%v\n", c.remoteAddr.String(), e)
+	}
+	return
+}
+
+func (el *eventloop) loopWake(c *stdConn) error {
+	//if co, ok := el.connections[c]; !ok || co != c {
+	//	return nil // ignore stale wakes.
+	//}
+	if c.detached != nil {
+		return nil // the connection has been detached since.
+	}
+	resumed := c.throttled
+	if resumed {
+		c.throttled = false
+		if !c.readPaused() {
+			c.resumeReading()
+		}
+	}
+	out, action := el.eventHandler.React(nil, c)
+	if c.detached != nil {
+		return nil // detached by the event handler
+	}
+	if out != nil {
+		frame, _ := c.codec.Encode(c, out)
+		_, _ = c.write(frame)
+	}
+	if err := el.handleAction(c, action); err != nil || !resumed || c.throttled || c.detached != nil {
+		return err
+	}
+	// Decode the frames held back while the connection was throttled.
+	return el.loopInbound(c, bytebuffer.Get())
+}
+
+// loopResumeRead resumes reading the connection after PauseRead and decodes the frames held back.
+func (el *eventloop) loopResumeRead(c *stdConn) error {
+	if c.detached != nil || c.readPaused() {
+		return nil // the connection has been detached or paused again since.
+	}
+	if !c.throttled {
+		c.resumeReading()
+	}
+	return el.loopInbound(c, bytebuffer.Get())
+}
+
+// loopDrain fires OnDraining for the connection, it reports whether the event handler has asked to shut down.
+func (el *eventloop) loopDrain(c *stdConn) bool {
+	out, action := el.eventHandler.OnDraining(c)
+	if c.detached != nil {
+		return false // detached by the event handler
+	}
+	if out != nil {
+		frame, _ := c.codec.Encode(c, out)
+		_, _ = c.write(frame)
+	}
+	switch action {
+	case Close:
+		sniffError(el.loopClose(c))
+	case Shutdown:
+		return true
+	}
+	return false
+}
+
+func (el *eventloop) handleAction(c *stdConn, action Action) error {
+	switch action {
+	case None:
+		return nil
+	case Close:
+		return el.loopClose(c)
+	case Shutdown:
+		return ErrServerShutdown
+	case Throttle:
+		c.throttled = true
+		c.pauseReading()
+		return nil
+	case Detach:
+		return el.loopDetach(c)
+	default:
+		return nil
+	}
+}
+
+func (el *eventloop) loopReadUDP(c *stdConn) error {
+	out, action := el.eventHandler.React(c.buffer.Bytes(), c)
+	if out != nil {
+		el.eventHandler.PreWrite()
+		_, _ = el.svr.ln.pconn.WriteTo(out, c.remoteAddr)
+	}
+	switch action {
+	case Shutdown:
+		return errClosing
+	}
+	c.releaseUDP()
+	return nil
+}
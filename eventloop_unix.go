@@ -4,25 +4,36 @@
 // license that can be found in the LICENSE file.
 
 // +build linux darwin netbsd freebsd openbsd dragonfly
+// +build !gnet_net
 
 package gnet
 
 import (
 	"net"
+	"os"
+	"sync/atomic"
 	"time"
 
+	"github.com/panlibin/gnet/internal"
 	"github.com/panlibin/gnet/internal/netpoll"
 	"golang.org/x/sys/unix"
 )
 
 type eventloop struct {
-	idx          int             // loop index in the server loops list
-	svr          *server         // server in loop
-	codec        ICodec          // codec for TCP
-	packet       []byte          // read packet buffer
-	poller       *netpoll.Poller // epoll or kqueue
-	connections  map[int]*conn   // loop connections fd -> conn
-	eventHandler EventHandler    // user eventHandler
+	idx          int                   // loop index in the server loops list
+	svr          *server               // server in loop
+	codec        ICodec                // codec for TCP
+	packet       []byte                // read packet buffer
+	poller       *netpoll.Poller       // epoll or kqueue
+	connections  map[int]*conn         // loop connections fd -> conn
+	eventHandler EventHandler          // user eventHandler
+	metrics      *internal.LoopMetrics // histograms of the loop, nil unless Options.LoopMetrics is set
+	natSessions  map[int]*natSession   // UDP NAT sessions opened by the loop fd -> session, see Options.UDPNAT
+	sources      map[int]*Source       // event sources registered with the loop fd -> source, see EventSource
+	ln           *listener             // listener accepted from by the loop, nil unless it accepts connections
+
+	acceptFailures int             // accept failures in a row for the lack of file descriptors
+	acceptPause    *internal.Timer // timer resuming accepting, nil unless it has been paused
 }
 
 func (el *eventloop) loopRun() {
@@ -36,43 +47,118 @@ func (el *eventloop) loopRun() {
 	if el.idx == 0 && el.svr.opts.Ticker {
 		go el.loopTicker()
 	}
+	el.watchStalls()
+	el.watchIdle()
+	el.watchNAT()
 
-	el.svr.logger.Printf("event-loop:%d exits with error: %v\n", el.idx, el.poller.Polling(el.handleEvent))
+	err := el.poller.Polling(el.handleEvent)
+	el.svr.fail(loopFailure(el.idx, err))
+	el.svr.logger.Printf("event-loop:%d exits with error: %v\n", el.idx, err)
 }
 
 func (el *eventloop) loopAccept(fd int) error {
+	if s, ok := el.natSessions[fd]; ok {
+		return el.loopReadNAT(s)
+	}
 	if fd == el.svr.ln.fd {
+		if ring := el.svr.packetRing(); ring != nil {
+			return el.loopReadRing(ring)
+		}
 		if el.svr.ln.pconn != nil {
 			return el.loopReadUDP(fd)
 		}
-		nfd, sa, err := unix.Accept(fd)
-		if err != nil {
-			if err == unix.EAGAIN {
-				return nil
+		var n int
+		defer func() { el.svr.stats.recordAccepts(n, el.svr.opts.AcceptBatch) }()
+		for ; n < el.svr.opts.AcceptBatch; n++ {
+			nfd, sa, err := el.svr.ln.accept()
+			if err != nil {
+				if err == unix.EAGAIN {
+					return nil
+				}
+				return el.acceptFailed(err)
+			}
+			el.acceptFailures = 0
+			if !el.svr.admit(nfd, sa, el) {
+				continue
+			}
+			if err = unix.SetNonblock(nfd, true); err != nil {
+				return err
+			}
+			c := newTCPConn(nfd, el, sa)
+			if err = el.poller.AddRead(c.fd); err != nil {
+				return err
 			}
-			return err
-		}
-		if err = unix.SetNonblock(nfd, true); err != nil {
-			return err
-		}
-		c := newTCPConn(nfd, el, sa)
-		if err = el.poller.AddRead(c.fd); err == nil {
 			el.connections[c.fd] = c
-			return el.loopOpen(c)
+			if err = el.loopOpen(c); err != nil {
+				return err
+			}
 		}
-		return err
 	}
 	return nil
 }
 
 func (el *eventloop) loopOpen(c *conn) error {
 	c.opened = true
+	c.stats.open()
 	c.localAddr = el.svr.ln.lnaddr
-	c.remoteAddr = netpoll.SockaddrToTCPOrUnixAddr(c.sa)
+	if el.svr.opts.Transparent {
+		// The local address of a connection intercepted by TPROXY is its original destination.
+		if sa, err := unix.Getsockname(c.fd); err == nil {
+			c.localAddr = sockaddrToAddr(sa)
+		}
+	}
+	c.resolveAddrs(false)
+	if tagger := el.svr.opts.PeerTagger; tagger != nil {
+		c.peer = tagger(c.remoteAddr)
+	}
+	if el.svr.tapper != nil {
+		c.tap = el.svr.tapper.attach(c)
+	}
+	if policy := el.svr.opts.FaultPolicy; policy != nil {
+		c.fault = newConnFault(c, policy, c.schedule, func(data []byte) error {
+			return c.loop.loopInbound(c, data)
+		}, func(data []byte) error {
+			c.writeNow(data)
+			return nil
+		})
+	}
+	if el.svr.shaper != nil {
+		c.shaping = el.svr.shaper.attach(c.remoteAddr)
+	}
+	if tos := el.svr.opts.TOS; tos != 0 {
+		_ = netpoll.SetTOS(c.fd, int(tos))
+	}
+	if d := el.svr.opts.BusyPoll.Socket; d > 0 {
+		_ = netpoll.SetBusyPoll(c.fd, int(d/time.Microsecond))
+	}
+	if el.svr.quotas != nil {
+		if err := el.extractTenant(c, nil); err != nil {
+			// Like a connection rejected at accept, the event handler never sees it.
+			sniffError(el.poller.Delete(c.fd))
+			sniffError(unix.Close(c.fd))
+			delete(el.connections, c.fd)
+			el.releaseLoopState(c, err)
+			c.releaseTCP()
+			return nil
+		}
+	}
+	el.configure(c)
 	out, action := el.eventHandler.OnOpened(c)
-	if el.svr.opts.TCPKeepAlive > 0 {
+	if !c.opened {
+		return nil // detached by the event handler
+	}
+	if d := el.svr.opts.HandshakeTimeout; d > 0 {
+		el.armHandshake(c, time.Now().Add(d))
+	}
+	if d := el.svr.opts.FirstByteTimeout; d > 0 {
+		el.armFirstByte(c, c.stats.createdAt.Add(d))
+	}
+	if d := el.svr.opts.ConnMaxLifetime; d > 0 {
+		el.armLifetime(c, d)
+	}
+	if keepAlive := el.svr.tunings().TCPKeepAlive; keepAlive > 0 {
 		if _, ok := el.svr.ln.ln.(*net.TCPListener); ok {
-			_ = netpoll.SetKeepAlive(c.fd, int(el.svr.opts.TCPKeepAlive/time.Second))
+			_ = netpoll.SetKeepAlive(c.fd, int(keepAlive/time.Second))
 		}
 	}
 	if out != nil {
@@ -80,26 +166,115 @@ func (el *eventloop) loopOpen(c *conn) error {
 	}
 
 	if !c.outboundBuffer.IsEmpty() {
-		_ = el.poller.AddWrite(c.fd)
+		// The connection has been added to the poller for reading at accept.
+		el.watch(c)
 	}
 
 	return el.handleAction(c, action)
 }
 
-func (el *eventloop) loopRead(c *conn) error {
-	n, err := unix.Read(c.fd, el.packet)
+// extractTenant gives the connection the tenant extracted by Quotas.Tenant, see TenantFunc.
+func (el *eventloop) extractTenant(c *conn, frame []byte) error {
+	extract := el.svr.opts.Quotas.Tenant
+	if extract == nil {
+		return nil
+	}
+	if name, ok := extract(c, frame); ok {
+		return c.setTenant(name)
+	}
+	return nil
+}
+
+func (el *eventloop) loopRead(c *conn, ev netpoll.IOEvent) error {
+	if c.throttled || c.slowPaused || c.tenantPaused || c.readPaused() {
+		return el.loopPausedEvent(c, ev)
+	}
+	if rb := el.svr.opts.Rebalance; rb.Interval > 0 && rb.Metric == RebalanceCallbackTime {
+		defer func(start time.Time) {
+			c.stats.busy += int64(time.Since(start))
+		}(time.Now())
+	}
+	size := len(el.packet)
+	if c.shaping != nil && !c.shaping.readPaused {
+		if size = c.shaping.readQuota(size); size == 0 {
+			el.throttleRead(c)
+			return nil
+		}
+	}
+	n, err := unix.Read(c.fd, el.packet[:size])
 	if n == 0 || err != nil {
 		if err == unix.EAGAIN {
 			return nil
 		}
+		if err == nil {
+			c.closeReason = ClosePeer
+		}
 		return el.loopCloseConn(c, err)
 	}
-	c.buffer = el.packet[:n]
+	c.stats.read(n)
+	if c.firstByte != nil {
+		el.poller.DelTimer(c.firstByte)
+		c.firstByte = nil
+	}
+	if c.shaping != nil {
+		c.shaping.consumeRead(n)
+	}
+	if c.tap != nil {
+		c.tap.mirror(TapInbound, el.packet[:n])
+	}
+	if c.fault != nil {
+		return c.fault.inject(FaultRead, el.packet[:n])
+	}
+	return el.loopInbound(c, el.packet[:n])
+}
+
+// loopPausedEvent handles an event of a connection which is not read for the time being. The poller reports
+// the hang-ups and the errors of a connection watched for no events too, and again on every poll as long as it is
+// open, so the connection is closed at once with the pending error of the socket, if any. The other events are
+// stale and ignored.
+func (el *eventloop) loopPausedEvent(c *conn, ev netpoll.IOEvent) error {
+	if ev&netpoll.ErrEvents == 0 {
+		return nil
+	}
+	var err error
+	if errno, e := unix.GetsockoptInt(c.fd, unix.SOL_SOCKET, unix.SO_ERROR); e == nil && errno != 0 {
+		err = unix.Errno(errno)
+	} else {
+		c.closeReason = ClosePeer
+	}
+	return el.loopCloseConn(c, err)
+}
 
-	for inFrame, _ := c.read(); inFrame != nil; inFrame, _ = c.read() {
+// loopInbound decodes the inbound data and feeds the frames to the event handler.
+func (el *eventloop) loopInbound(c *conn, data []byte) error {
+	c.buffer = data
+
+	for !c.throttled && !c.readPaused() {
+		inFrame, err := c.read()
+		if inFrame == nil {
+			if isFatalDecodeError(err) {
+				return el.loopCloseConn(c, err)
+			}
+			if c.handshake != nil {
+				el.checkHandshake(c)
+			}
+			break
+		}
+		c.stats.framesDecoded++
+		if c.handshake != nil {
+			el.checkHandshake(c)
+		}
+		if c.tenant == nil && el.svr.quotas != nil {
+			if err = el.extractTenant(c, inFrame); err != nil {
+				return el.loopCloseConn(c, err)
+			}
+		}
 		out, action := el.eventHandler.React(inFrame, c)
+		if !c.opened {
+			return nil // detached by the event handler
+		}
 		if out != nil {
-			outFrame, _ := el.codec.Encode(c, out)
+			outFrame, _ := c.codec.Encode(c, out)
 			el.eventHandler.PreWrite()
 			c.write(outFrame)
 		}
@@ -111,50 +286,322 @@ func (el *eventloop) loopRead(c *conn) error {
 		case Shutdown:
 			_ = el.loopWrite(c)
 			return ErrServerShutdown
+		case Throttle:
+			c.throttled = true
+			el.watch(c)
+		case Detach:
+			return el.loopDetach(c)
 		}
 		if !c.opened {
 			return nil
 		}
 	}
 	_, _ = c.inboundBuffer.Write(c.buffer)
+	if max := c.settings.MaxInbound; max > 0 && c.inboundBuffer.Length() > max {
+		return el.loopCloseConn(c, ErrInboundTooLarge)
+	}
 
 	return nil
 }
 
+// configure applies the settings returned by OnConfigure to the connection, see ConnSettings.
+func (el *eventloop) configure(c *conn) {
+	c.settings = el.eventHandler.OnConfigure(c)
+	if c.settings.Codec != nil {
+		c.codec = c.settings.Codec
+	}
+	if c.settings.IdleTimeout > 0 {
+		el.armIdleTimeout(c)
+	}
+}
+
+// watch renews the events of the connection in the poller according to its state.
+func (el *eventloop) watch(c *conn) {
+	read := !c.throttled && !c.slowPaused && !c.tenantPaused && !c.readPaused() &&
+		(c.shaping == nil || !c.shaping.readPaused)
+	write := !c.outboundBuffer.IsEmpty() && c.writeRetry == nil && (c.shaping == nil || !c.shaping.writePaused)
+	switch {
+	case read && write:
+		_ = el.poller.ModReadWrite(c.fd)
+	case read:
+		_ = el.poller.ModRead(c.fd)
+	case write:
+		_ = el.poller.ModWrite(c.fd)
+	default:
+		_ = el.poller.ModNone(c.fd)
+	}
+}
+
+// loopControl runs f with the connection taken off the poller, see Conn.SyscallConn.
+func (el *eventloop) loopControl(c *conn, f func(fd uintptr)) error {
+	if !c.opened {
+		return ErrConnectionClosed
+	}
+	_ = el.poller.ModNone(c.fd)
+	f(uintptr(c.fd))
+	el.watch(c)
+	return nil
+}
+
+// loopDetach removes the connection from the event-loop and hands it over to the event handler as a net.Conn.
+func (el *eventloop) loopDetach(c *conn) error {
+	dc, err := el.detach(c)
+	if err != nil {
+		return el.loopCloseConn(c, err)
+	}
+	action := el.eventHandler.OnDetached(c, dc)
+	c.releaseTCP()
+	if action == Shutdown {
+		return ErrServerShutdown
+	}
+	return nil
+}
+
+// detach removes the connection from the event-loop and returns it as a net.Conn, the connection is left
+// untouched if it fails.
+func (el *eventloop) detach(c *conn) (*detachedConn, error) {
+	f := os.NewFile(uintptr(c.fd), "gnet")
+	nc, err := net.FileConn(f)
+	if err != nil {
+		return nil, err
+	}
+	dc := newDetachedConn(nc, c.pendingOutbound())
+	dc.prefix = append([]byte(nil), c.Read()...)
+	close(dc.ready)
+
+	_ = el.poller.Delete(c.fd)
+	// Closing the original fd after it has been removed from the poller, the net.Conn owns a duplicate.
+	_ = f.Close()
+	delete(el.connections, c.fd)
+	el.releaseLoopState(c, ErrConnectionDetached)
+	return dc, nil
+}
+
 func (el *eventloop) loopWrite(c *conn) error {
 	el.eventHandler.PreWrite()
+	if c.stream != nil {
+		defer c.settleStream()
+	}
+
+	if len(c.urgent) > 0 && c.frameOffset == 0 {
+		if done, err := c.flushUrgent(); err != nil {
+			return el.loopCloseConn(c, err)
+		} else if !done {
+			return nil
+		}
+	}
 
 	head, tail := c.outboundBuffer.LazyReadAll()
-	n, err := unix.Write(c.fd, head)
+	limit := len(head) + len(tail)
+	if len(c.urgent) > 0 {
+		// Only finish the head frame so that high-priority frames can be written right after it.
+		limit = c.frameSizes[0] - c.frameOffset
+	}
+	if quantum := el.svr.tunings().WriteQuantum; quantum > 0 && limit > quantum {
+		limit = quantum
+	}
+	if c.shaping != nil && !c.shaping.writePaused {
+		if limit = c.shaping.writeQuota(limit); limit == 0 {
+			el.throttleWrite(c)
+			return nil
+		}
+	}
+	if limit < len(head) {
+		head, tail = head[:limit], nil
+	} else if limit < len(head)+len(tail) {
+		tail = tail[:limit-len(head)]
+	}
+
+	var (
+		written int
+		err     error
+	)
+	if len(tail) == 0 {
+		written, err = unix.Write(c.fd, head)
+	} else {
+		// The outbound data wraps around the ring-buffer, both parts go out in a single system call.
+		written, err = netpoll.Writev(c.fd, [][]byte{head, tail})
+	}
 	if err != nil {
-		if err == unix.EAGAIN {
+		if el.writeBlocked(c, err) {
 			return nil
 		}
 		return el.loopCloseConn(c, err)
 	}
-	c.outboundBuffer.Shift(n)
+	if written < len(head)+len(tail) {
+		atomic.AddInt64(&el.svr.stats.partialWrites, 1)
+	}
+	// The residual data stays where it is in the outbound buffer until the socket is writable again.
+	c.shiftOutbound(written)
+
+	c.stats.wrote(written)
+	if c.shaping != nil {
+		c.shaping.consumeWrite(written)
+	}
+	if written > 0 {
+		// The outbound data is draining, which ends the stall, see checkStalls.
+		c.stalledAt, c.slow = time.Time{}, false
+	}
 
-	if len(head) == n && tail != nil {
-		n, err = unix.Write(c.fd, tail)
+	if len(c.urgent) > 0 && c.frameOffset == 0 {
+		done, err := c.flushUrgent()
 		if err != nil {
-			if err == unix.EAGAIN {
-				return nil
-			}
 			return el.loopCloseConn(c, err)
 		}
-		c.outboundBuffer.Shift(n)
+		if !done && c.outboundBuffer.IsEmpty() {
+			// Nothing is left ahead of the high-priority frames, move them into the outbound buffer.
+			c.bufferRest(c.urgent[0], c.urgentOffset)
+			for _, buf := range c.urgent[1:] {
+				c.bufferOutbound(buf)
+			}
+			c.urgent, c.urgentOffset = nil, 0
+		}
+	}
+
+	if c.shaping != nil {
+		if c.outboundBuffer.IsEmpty() && c.shaping.readPaused {
+			_ = el.poller.ModNone(c.fd)
+			return nil
+		}
+		if !c.outboundBuffer.IsEmpty() && c.shaping.writeQuota(1) == 0 {
+			el.throttleWrite(c)
+			return nil
+		}
 	}
 
 	if c.outboundBuffer.IsEmpty() {
-		_ = el.poller.ModRead(c.fd)
+		c.slowPaused, c.tenantPaused = false, false
+		el.watch(c)
 	}
 	return nil
 }
 
+// scheduleTick arms the timer of the periodic callback of the connection.
+func (el *eventloop) scheduleTick(c *conn, t *connTicker) {
+	t.timer = el.poller.AddTimer(t.interval, func() error {
+		out, action := t.fn(c)
+		if !c.opened {
+			return nil
+		}
+		if out != nil {
+			frame, _ := c.codec.Encode(c, out)
+			c.write(frame)
+		}
+		if !c.opened {
+			return nil
+		}
+		if !t.stopped {
+			el.scheduleTick(c, t)
+		}
+		return el.handleAction(c, action)
+	})
+}
+
+// armHandshake arms the timer closing the connection with ErrHandshakeTimeout unless it has completed its
+// handshake by the deadline, see Options.HandshakeTimeout.
+func (el *eventloop) armHandshake(c *conn, deadline time.Time) {
+	c.handshakeBy = deadline
+	c.handshake = el.poller.AddTimer(time.Until(deadline), func() error {
+		c.handshake = nil
+		return el.loopCloseConn(c, ErrHandshakeTimeout)
+	})
+}
+
+// armFirstByte arms the timer closing the connection with ErrFirstByteTimeout unless it has read something by
+// the deadline, see Options.FirstByteTimeout.
+func (el *eventloop) armFirstByte(c *conn, deadline time.Time) {
+	c.firstByteBy = deadline
+	c.firstByte = el.poller.AddTimer(time.Until(deadline), func() error {
+		c.firstByte = nil
+		return el.loopCloseConn(c, ErrFirstByteTimeout)
+	})
+}
+
+// checkHandshake disarms the timer of the handshake once the connection has completed it.
+func (el *eventloop) checkHandshake(c *conn) {
+	if handshaken(c, c.codec, c.stats.framesDecoded) {
+		el.poller.DelTimer(c.handshake)
+		c.handshake = nil
+	}
+}
+
+// schedule runs the job on the event-loop after the given delay.
+func (el *eventloop) schedule(delay time.Duration, job func() error) {
+	el.poller.AddTimer(delay, job)
+}
+
+// writeRetryDelay is how long the writes to a connection are held back after ENOBUFS.
+const writeRetryDelay = time.Millisecond
+
+// writeBlocked reports whether the failed write to the connection is transient and to be retried later on,
+// keeping the data in the outbound buffer, any other error such as EPIPE or ECONNRESET closes the connection.
+// On EAGAIN and EINTR the socket is watched for writable, on ENOBUFS, a transient shortage of kernel memory which
+// doesn't wait for the socket to drain, the writes are held back for writeRetryDelay so that the writable events
+// don't spin on it.
+func (el *eventloop) writeBlocked(c *conn, err error) bool {
+	switch err {
+	case unix.EAGAIN:
+		atomic.AddInt64(&el.svr.stats.writeEAGAIN, 1)
+		el.watch(c)
+		return true
+	case unix.EINTR:
+		el.watch(c)
+		return true
+	case unix.ENOBUFS:
+		atomic.AddInt64(&el.svr.stats.writeENOBUFS, 1)
+		if c.writeRetry == nil {
+			c.writeRetry = el.poller.AddTimer(writeRetryDelay, func() error {
+				c.writeRetry = nil
+				if c.opened {
+					el.watch(c)
+				}
+				return nil
+			})
+			el.watch(c)
+		}
+		return true
+	}
+	return false
+}
+
+// throttleRead stops reading the connection until its read buckets are refilled.
+func (el *eventloop) throttleRead(c *conn) {
+	cs := c.shaping
+	if cs.readPaused {
+		return
+	}
+	cs.readPaused = true
+	_ = el.poller.ModNone(c.fd)
+	cs.readTimer = el.poller.AddTimer(cs.readDelay(), func() error {
+		cs.readPaused, cs.readTimer = false, nil
+		el.watch(c)
+		return nil
+	})
+}
+
+// throttleWrite stops flushing the connection until its write buckets are refilled.
+func (el *eventloop) throttleWrite(c *conn) {
+	cs := c.shaping
+	if cs.writePaused {
+		return
+	}
+	cs.writePaused = true
+	_ = el.poller.ModNone(c.fd)
+	cs.writeTimer = el.poller.AddTimer(cs.writeDelay(), func() error {
+		cs.writePaused, cs.writeTimer = false, nil
+		el.watch(c)
+		return nil
+	})
+}
+
 func (el *eventloop) loopCloseConn(c *conn, err error) error {
 	err0, err1 := el.poller.Delete(c.fd), unix.Close(c.fd)
 	if err0 == nil && err1 == nil {
 		delete(el.connections, c.fd)
+		el.releaseLoopState(c, ErrConnectionClosed)
+		if err != nil || c.closeReason == CloseNone {
+			c.closeReason = CloseReasonOf(err)
+		}
 		switch el.eventHandler.OnClosed(c, err) {
 		case Shutdown:
 			return ErrServerShutdown
@@ -171,16 +618,182 @@ func (el *eventloop) loopCloseConn(c *conn, err error) error {
 	return nil
 }
 
+// releaseLoopState stops everything the event-loop keeps for the connection when it leaves the event-loop,
+// err is reported to the stream writer.
+func (el *eventloop) releaseLoopState(c *conn, err error) {
+	if c.fault != nil {
+		c.fault.close()
+	}
+	if c.stream != nil {
+		c.stream.fail(err)
+	}
+	for _, t := range c.tickers {
+		t.stopped = true
+		el.poller.DelTimer(t.timer)
+	}
+	c.tickers = nil
+	el.poller.DelTimer(c.handshake)
+	c.handshake = nil
+	el.poller.DelTimer(c.firstByte)
+	c.firstByte = nil
+	el.poller.DelTimer(c.lifetime)
+	c.lifetime = nil
+	el.poller.DelTimer(c.idleTimeout)
+	c.idleTimeout = nil
+	el.poller.DelTimer(c.writeRetry)
+	c.writeRetry = nil
+	if c.tenant != nil {
+		el.svr.quotas.leave(c.tenant, c.outboundBuffer.Length())
+		c.tenant = nil
+	}
+	releaseInterned(c.interned)
+	c.interned = nil
+	if cs := c.shaping; cs != nil {
+		el.poller.DelTimer(cs.readTimer)
+		el.poller.DelTimer(cs.writeTimer)
+		cs.shaper.detach(cs)
+	}
+}
+
 func (el *eventloop) loopWake(c *conn) error {
 	//if co, ok := el.connections[c.fd]; !ok || co != c {
 	//	return nil // ignore stale wakes.
 	//}
+	if !c.opened {
+		return nil // the connection has been closed or detached since.
+	}
+	resumed := c.throttled
+	if resumed {
+		c.throttled = false
+		el.watch(c)
+	}
 	out, action := el.eventHandler.React(nil, c)
+	if !c.opened {
+		return nil // detached by the event handler
+	}
 	if out != nil {
-		frame, _ := el.codec.Encode(c, out)
+		frame, _ := c.codec.Encode(c, out)
 		c.write(frame)
 	}
-	return el.handleAction(c, action)
+	if err := el.handleAction(c, action); err != nil || !resumed || !c.opened || c.throttled {
+		return err
+	}
+	// Decode the frames held back while the connection was throttled.
+	return el.loopInbound(c, nil)
+}
+
+// loopMigrate moves the connection over to another event-loop. The connection is removed from the poller of this
+// event-loop before it is registered with the other one, so that none of its events is handled twice, nor is any
+// lost since the pollers are level-triggered: the other poller reports the events pending on the connection again.
+func (el *eventloop) loopMigrate(c *conn, to *eventloop) error {
+	if !c.opened || to == el {
+		return nil
+	}
+	err := el.poller.ModNone(c.fd)
+	if err == nil {
+		err = el.poller.Delete(c.fd)
+	}
+	if err != nil {
+		return el.loopCloseConn(c, err)
+	}
+	delete(el.connections, c.fd)
+	for _, t := range c.tickers {
+		el.poller.DelTimer(t.timer)
+	}
+	el.poller.DelTimer(c.handshake)
+	el.poller.DelTimer(c.firstByte)
+	el.poller.DelTimer(c.lifetime)
+	el.poller.DelTimer(c.idleTimeout)
+	el.poller.DelTimer(c.writeRetry)
+	c.writeRetry = nil
+	if cs := c.shaping; cs != nil {
+		el.poller.DelTimer(cs.readTimer)
+		el.poller.DelTimer(cs.writeTimer)
+		cs.readPaused, cs.readTimer, cs.writePaused, cs.writeTimer = false, nil, false, nil
+	}
+	c.stalledAt, c.slow = time.Time{}, false
+	c.loop = to
+	c.owner.Store(to)
+	if err = to.poller.Trigger(func() error {
+		return to.loopAdopt(c)
+	}); err != nil {
+		el.svr.logger.Printf("failed to migrate fd:%d to event-loop:%d, error:%v\n", c.fd, to.idx, err)
+		c.loop = el
+		c.owner.Store(el)
+		return el.loopAdopt(c)
+	}
+	return nil
+}
+
+// loopAdopt registers the connection migrated from another event-loop.
+func (el *eventloop) loopAdopt(c *conn) error {
+	if err := el.poller.AddRead(c.fd); err != nil {
+		sniffError(unix.Close(c.fd))
+		el.releaseLoopState(c, ErrConnectionClosed)
+		c.closeReason = CloseReasonOf(err)
+		if el.eventHandler.OnClosed(c, err) == Shutdown {
+			return ErrServerShutdown
+		}
+		c.releaseTCP()
+		return nil
+	}
+	el.connections[c.fd] = c
+	el.watch(c)
+	for _, t := range c.tickers {
+		el.scheduleTick(c, t)
+	}
+	if c.handshake != nil {
+		el.armHandshake(c, c.handshakeBy)
+	}
+	if c.firstByte != nil {
+		el.armFirstByte(c, c.firstByteBy)
+	}
+	if c.lifetime != nil {
+		el.armRotation(c, c.lifetimeBy)
+	}
+	if c.idleTimeout != nil {
+		el.armIdleTimeout(c)
+	}
+	return nil
+}
+
+// loopResumeRead resumes reading the connection after PauseRead and decodes the frames held back.
+func (el *eventloop) loopResumeRead(c *conn) error {
+	if !c.opened {
+		return nil // the connection has been closed or detached since.
+	}
+	el.watch(c)
+	return el.loopInbound(c, nil)
+}
+
+// loopDrain fires OnDraining for the connection, it reports whether the event handler has asked to shut down.
+func (el *eventloop) loopDrain(c *conn) bool {
+	out, action := el.eventHandler.OnDraining(c)
+	if !c.opened {
+		return false // detached by the event handler
+	}
+	if out != nil {
+		frame, _ := c.codec.Encode(c, out)
+		c.write(frame)
+	}
+	switch action {
+	case Close:
+		sniffError(el.loopCloseConn(c, nil))
+	case Shutdown:
+		return true
+	}
+	return false
+}
+
+// jobFailed logs the error of an asynchronous job, which doesn't stop the event-loop unless it shuts the
+// server down.
+func (el *eventloop) jobFailed(err error) error {
+	if err == ErrServerShutdown {
+		return err
+	}
+	atomic.AddInt64(&el.svr.stats.jobErrors, 1)
+	el.svr.logger.Printf("event-loop:%d job failed with error:%v\n", el.idx, err)
+	return nil
 }
 
 func (el *eventloop) loopTicker() {
@@ -222,20 +835,73 @@ func (el *eventloop) handleAction(c *conn, action Action) error {
 	case Shutdown:
 		_ = el.loopWrite(c)
 		return ErrServerShutdown
+	case Throttle:
+		c.throttled = true
+		el.watch(c)
+		return nil
+	case Detach:
+		return el.loopDetach(c)
 	default:
 		return nil
 	}
 }
 
+// loopReadErrQueue fires OnError for the errors queued on the UDP listener, see Options.ICMPErrors.
+func (el *eventloop) loopReadErrQueue(fd int) error {
+	var oob [256]byte
+	for {
+		qe, err := netpoll.RecvErr(fd, oob[:])
+		if err != nil {
+			return nil
+		}
+		if qe.To == nil || !qe.ICMP {
+			continue
+		}
+		c := newUDPConn(fd, el, qe.To, el.svr.ln.lnaddr)
+		action := el.eventHandler.OnError(c, &ICMPError{Err: qe.Errno, Type: qe.Type, Code: qe.Code, Offender: qe.Offender})
+		c.releaseUDP()
+		if action == Shutdown {
+			return ErrServerShutdown
+		}
+	}
+}
+
 func (el *eventloop) loopReadUDP(fd int) error {
-	n, sa, err := unix.Recvfrom(fd, el.packet, 0)
+	var (
+		n    int
+		sa   unix.Sockaddr
+		meta = netpoll.DatagramMeta{TOS: -1}
+		err  error
+	)
+	if el.svr.opts.Transparent || el.svr.opts.ECN {
+		var oob [128]byte
+		n, sa, meta, err = netpoll.RecvmsgMeta(fd, el.packet, oob[:])
+	} else {
+		n, sa, err = unix.Recvfrom(fd, el.packet, 0)
+	}
+	if err != nil && el.svr.opts.ICMPErrors {
+		// The pending error has been reported by the read, or the error queue is all that is ready.
+		return el.loopReadErrQueue(fd)
+	}
 	if err != nil || n == 0 {
 		if err != nil && err != unix.EAGAIN {
 			el.svr.logger.Printf("failed to read UPD packet from fd:%d, error:%v\n", fd, err)
 		}
 		return nil
 	}
-	c := newUDPConn(fd, el, sa)
+	if fw := el.svr.opts.Firewall; fw != nil {
+		if ip := netpoll.SockaddrIP(sa); ip != nil && !fw.Allowed(ip) {
+			atomic.AddInt64(&el.svr.stats.firewallDenied, 1)
+			return nil
+		}
+	}
+	localAddr := el.svr.ln.lnaddr
+	if meta.Dst != nil {
+		// The local address of a datagram intercepted by TPROXY is its original destination.
+		localAddr = meta.Dst
+	}
+	c := newUDPConn(fd, el, sa, localAddr)
+	c.udpTOS = meta.TOS
 	out, action := el.eventHandler.React(el.packet[:n], c)
 	if out != nil {
 		el.eventHandler.PreWrite()
@@ -0,0 +1,43 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+// EventSource is a file-descriptor other than a connection which is polled by an event-loop of a server, e.g.
+// a serial port, a vsock or an eventfd, see GServer.AddEventSource. Its callbacks run on the event-loop.
+type EventSource interface {
+	// FD returns the file-descriptor, which should be non-blocking.
+	FD() int
+
+	// OnReadable fires when the file-descriptor is readable, hung up or has failed. The source is removed from
+	// the event-loop if it returns an error, ErrServerShutdown shuts the server down as well.
+	OnReadable() error
+
+	// OnWritable fires when the file-descriptor is writable while the source watches for it, see
+	// Source.SetWritable. It returns an error like OnReadable.
+	OnWritable() error
+
+	// OnClose fires once the source has been removed from the event-loop, with the error which removed it,
+	// ErrServerShutdown if the server has shut down or nil if it has been closed by Source.Close.
+	// The file-descriptor is left to the source to close.
+	OnClose(err error)
+}
+
+// Source is an EventSource registered with an event-loop.
+type Source struct {
+	src      EventSource
+	fd       int
+	loop     *eventloop
+	writable bool // whether the source watches for OnWritable, owned by the event-loop
+}
+
+// AddEventSource registers the event source with the idx-th event-loop of the server, which polls it for
+// OnReadable until it is removed. It fails with ErrInvalidLoopIndex if there is no such event-loop and with
+// ErrProtocolNotSupported with the net transport, which has no poller.
+func (s *GServer) AddEventSource(idx int, src EventSource) (*Source, error) {
+	if s.s == nil {
+		return nil, ErrServerNotStarted
+	}
+	return s.s.addEventSource(idx, src)
+}
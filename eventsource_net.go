@@ -0,0 +1,23 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows gnet_net
+
+package gnet
+
+// addEventSource fails since the net transport has no poller.
+func (svr *server) addEventSource(idx int, src EventSource) (*Source, error) {
+	return nil, ErrProtocolNotSupported
+}
+
+// SetWritable sets whether OnWritable fires when the file-descriptor is writable, e.g. once a write would
+// block, it takes effect on the event-loop.
+func (s *Source) SetWritable(writable bool) error {
+	return ErrProtocolNotSupported
+}
+
+// Close removes the source from the event-loop, OnClose fires on the event-loop with a nil error.
+func (s *Source) Close() error {
+	return ErrProtocolNotSupported
+}
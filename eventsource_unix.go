@@ -0,0 +1,93 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+// +build !gnet_net
+
+package gnet
+
+func (svr *server) addEventSource(idx int, src EventSource) (*Source, error) {
+	el := svr.loopAt(idx)
+	if el == nil {
+		return nil, ErrInvalidLoopIndex
+	}
+	s := &Source{src: src, fd: src.FD(), loop: el}
+	// The events polled before the source is put in the event-loop are polled again, as they are level-triggered.
+	if err := el.poller.AddRead(s.fd); err != nil {
+		return nil, err
+	}
+	if err := el.poller.Trigger(func() error {
+		el.sources[s.fd] = s
+		return nil
+	}); err != nil {
+		_ = el.poller.Delete(s.fd)
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetWritable sets whether OnWritable fires when the file-descriptor is writable, e.g. once a write would
+// block, it takes effect on the event-loop. The source is removed with the error of the poller if any.
+func (s *Source) SetWritable(writable bool) error {
+	el := s.loop
+	return el.poller.Trigger(func() error {
+		if el.sources[s.fd] != s || s.writable == writable {
+			return nil
+		}
+		var err error
+		if writable {
+			err = el.poller.ModReadWrite(s.fd)
+		} else {
+			err = el.poller.ModRead(s.fd)
+		}
+		if err != nil {
+			el.removeSource(s, err)
+			return nil
+		}
+		s.writable = writable
+		return nil
+	})
+}
+
+// Close removes the source from the event-loop, OnClose fires on the event-loop with a nil error.
+func (s *Source) Close() error {
+	el := s.loop
+	return el.poller.Trigger(func() error {
+		if el.sources[s.fd] == s {
+			el.removeSource(s, nil)
+		}
+		return nil
+	})
+}
+
+// loopSource runs the callbacks of the source for its events.
+func (el *eventloop) loopSource(s *Source, readable, writable bool) error {
+	var err error
+	if writable && s.writable {
+		err = s.src.OnWritable()
+	}
+	if err == nil && readable {
+		err = s.src.OnReadable()
+	}
+	if err != nil {
+		el.removeSource(s, err)
+	}
+	if err == ErrServerShutdown {
+		return err
+	}
+	return nil
+}
+
+func (el *eventloop) removeSource(s *Source, err error) {
+	_ = el.poller.Delete(s.fd)
+	delete(el.sources, s.fd)
+	s.src.OnClose(err)
+}
+
+// closeSources removes all the sources of the event-loop after it has exited.
+func (el *eventloop) closeSources() {
+	for _, s := range el.sources {
+		el.removeSource(s, ErrServerShutdown)
+	}
+}
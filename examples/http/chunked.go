@@ -0,0 +1,122 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// chunkedLength is the length parseHead returns for a body in the chunked transfer coding.
+const chunkedLength = -1
+
+// maxChunkLine is the limit of the chunk-size lines, extensions included, and of the trailer fields.
+const maxChunkLine = 4 << 10
+
+// chunkedBody decodes a body in the chunked transfer coding as it arrives. The chunk extensions and
+// the trailer fields are discarded.
+type chunkedBody struct {
+	remaining int  // the data of the current chunk yet to arrive
+	crlf      bool // whether the CRLF after the data of the current chunk is yet to arrive
+	trailer   bool // whether the last chunk has arrived and the trailer is being read
+}
+
+// next decodes data up to the end of the next piece of chunk data, which it returns along with the number of bytes
+// consumed, or up to the end of the body. It consumes what it can and returns no chunk if more data is needed.
+func (cb *chunkedBody) next(data []byte) (chunk []byte, n int, done bool, err error) {
+	for {
+		if cb.remaining > 0 {
+			if chunk = data[n:]; len(chunk) > cb.remaining {
+				chunk = chunk[:cb.remaining]
+			}
+			cb.remaining -= len(chunk)
+			return chunk, n + len(chunk), false, nil
+		}
+		line, ok, err := chunkLine(data[n:])
+		if !ok {
+			return nil, n, false, err
+		}
+		n += len(line) + 2
+		switch {
+		case cb.crlf:
+			if line != "" {
+				return nil, n, false, errBadRequest
+			}
+			cb.crlf = false
+		case cb.trailer:
+			if line == "" {
+				return nil, n, true, nil
+			}
+			if strings.IndexByte(line, ':') <= 0 {
+				return nil, n, false, errBadRequest
+			}
+		default:
+			size, err := parseChunkSize(line)
+			if err != nil {
+				return nil, n, false, err
+			}
+			if size == 0 {
+				cb.trailer = true
+			} else {
+				cb.remaining, cb.crlf = size, true
+			}
+		}
+	}
+}
+
+// chunkLine returns the line at the start of data without its CRLF, ok is false if the line has not arrived
+// in full yet. Lines longer than maxChunkLine and bare CRs and LFs are refused.
+func chunkLine(data []byte) (line string, ok bool, err error) {
+	i := bytes.Index(data, []byte("\r\n"))
+	if i < 0 {
+		if len(data) > maxChunkLine {
+			err = errBadRequest
+		}
+		return "", false, err
+	}
+	if line = b2s(data[:i]); i > maxChunkLine || strings.ContainsAny(line, "\r\n") {
+		return "", false, errBadRequest
+	}
+	return line, true, nil
+}
+
+// parseChunkSize parses a chunk-size line, a chunk too large for an int32 is refused with 413.
+func parseChunkSize(line string) (int, error) {
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	size, err := strconv.ParseUint(strings.TrimRight(line, " \t"), 16, 31)
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+			return 0, errEntityTooLarge
+		}
+		return 0, errBadRequest
+	}
+	return int(size), nil
+}
+
+// readChunked decodes a body in the chunked transfer coding buffered in full and returns it along with
+// the number of bytes it takes in data, or -1 if it has not arrived in full yet. Bodies larger than max are
+// refused with 413 as soon as a chunk-size announces it.
+func readChunked(data []byte, max int) (body []byte, n int, err error) {
+	var cb chunkedBody
+	for {
+		chunk, m, done, err := cb.next(data[n:])
+		if err != nil {
+			return nil, 0, err
+		}
+		n += m
+		if body = append(body, chunk...); len(body)+cb.remaining > max {
+			return nil, 0, errEntityTooLarge
+		}
+		if done {
+			return body, n, nil
+		}
+		if m == 0 {
+			return nil, -1, nil
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The content codings the server is able to produce, in the order of preference.
+// br is not among them as the standard library has no brotli encoder.
+var supportedEncodings = []string{"gzip", "deflate"}
+
+// compressor compresses the response bodies for the clients accepting it,
+// the encoders and the buffers are pooled so that nothing is allocated per response.
+type compressor struct {
+	level   int
+	minSize int // bodies smaller than this are sent as they are, they would hardly shrink
+
+	gzipPool sync.Pool
+	zlibPool sync.Pool
+	bufPool  sync.Pool
+}
+
+func newCompressor(level, minSize int) *compressor {
+	cp := &compressor{level: level, minSize: minSize}
+	cp.gzipPool.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, level)
+		return w
+	}
+	cp.zlibPool.New = func() interface{} {
+		w, _ := zlib.NewWriterLevel(nil, level)
+		return w
+	}
+	cp.bufPool.New = func() interface{} {
+		return new(bytes.Buffer)
+	}
+	return cp
+}
+
+// resetWriter is the common part of gzip.Writer and zlib.Writer.
+type resetWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// encoding picks the content coding of a response, "" means the body is sent as it is. The small bodies
+// and the ones not worth compressing are only compressed for the clients refusing them as they are.
+func (cp *compressor) encoding(acceptEncoding, contentType string, size int) string {
+	if cp == nil {
+		return ""
+	}
+	if (size < cp.minSize || !compressible(contentType)) && !identityRefused(acceptEncoding) {
+		return ""
+	}
+	return negotiateEncoding(acceptEncoding)
+}
+
+// appendResp appends the response with the body compressed with the given content coding.
+func (cp *compressor) appendResp(b []byte, status, head, body, encoding string) []byte {
+	pool := &cp.gzipPool
+	if encoding == "deflate" {
+		pool = &cp.zlibPool
+	}
+	w := pool.Get().(resetWriter)
+	buf := cp.bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	w.Reset(buf)
+	_, _ = io.WriteString(w, body)
+	_ = w.Close()
+	b = appendResp(b, status, head+"Content-Encoding: "+encoding+"\r\n", b2s(buf.Bytes()))
+	w.Reset(nil)
+	pool.Put(w)
+	cp.bufPool.Put(buf)
+	return b
+}
+
+// negotiateEncoding picks the most preferred of the supported content codings in an Accept-Encoding header,
+// the client's qvalues take precedence over the server's order.
+func negotiateEncoding(acceptEncoding string) (encoding string) {
+	best := 0.0
+	for _, e := range supportedEncodings {
+		if q, _ := qvalue(acceptEncoding, e); q > best {
+			encoding, best = e, q
+		}
+	}
+	return
+}
+
+// qvalue returns the qvalue the Accept-Encoding header gives to a content coding, 0 if it is not acceptable,
+// and whether the header mentions it at all, by its name or by *.
+func qvalue(acceptEncoding, encoding string) (q float64, ok bool) {
+	q, wildcard := -1.0, -1.0
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(item, ";")
+		v := 1.0
+		for _, param := range params[1:] {
+			if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+				var err error
+				if v, err = strconv.ParseFloat(param[2:], 64); err != nil || v < 0 || v > 1 {
+					v = 0
+				}
+			}
+		}
+		switch name := strings.TrimSpace(params[0]); {
+		case strings.EqualFold(name, encoding):
+			q = v
+		case name == "*":
+			wildcard = v
+		}
+	}
+	switch {
+	case q >= 0:
+		return q, true
+	case wildcard >= 0:
+		return wildcard, true
+	}
+	return 0, false
+}
+
+// identityRefused reports whether the Accept-Encoding header refuses the bodies sent as they are,
+// by identity;q=0 or by *;q=0 without identity. The identity coding is acceptable unless it is refused.
+func identityRefused(acceptEncoding string) bool {
+	q, ok := qvalue(acceptEncoding, "identity")
+	return ok && q == 0
+}
+
+// compressible reports whether the bodies of a media type are worth compressing,
+// i.e. textual ones, the images and archives are compressed already.
+func compressible(contentType string) bool {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	switch {
+	case strings.HasPrefix(contentType, "text/"),
+		strings.HasSuffix(contentType, "+json"),
+		strings.HasSuffix(contentType, "+xml"):
+		return true
+	}
+	switch contentType {
+	case "application/json", "application/javascript", "application/xml":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for _, c := range []struct {
+		acceptEncoding, want string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"GZIP", "gzip"},
+		{"deflate", "deflate"},
+		{"br", ""},
+		{"gzip, deflate", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate;q=0.8", "deflate"},
+		{"gzip;q=0, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"gzip;level=1;q=0.2, deflate;q=0.3", "deflate"},
+		{"gzip;q=abc, deflate;q=2", ""},
+		{"*", "gzip"},
+		{"*;q=0.5, gzip;q=0.1", "deflate"},
+		{"gzip;q=0, *", "deflate"},
+		{"*;q=0", ""},
+		{"identity;q=0", ""},
+		{"gzip, identity;q=0", "gzip"},
+	} {
+		if got := negotiateEncoding(c.acceptEncoding); got != c.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", c.acceptEncoding, got, c.want)
+		}
+	}
+}
+
+func TestIdentityRefused(t *testing.T) {
+	for _, c := range []struct {
+		acceptEncoding string
+		want           bool
+	}{
+		{"", false},
+		{"gzip", false},
+		{"identity;q=0", true},
+		{"gzip, identity;q=0.0", true},
+		{"identity;q=0.1", false},
+		{"*;q=0", true},
+		{"*;q=0, identity", false},
+		{"*", false},
+	} {
+		if got := identityRefused(c.acceptEncoding); got != c.want {
+			t.Errorf("identityRefused(%q) = %t, want %t", c.acceptEncoding, got, c.want)
+		}
+	}
+}
+
+func TestCompressDecision(t *testing.T) {
+	cp := newCompressor(gzip.DefaultCompression, 1024)
+	for _, c := range []struct {
+		acceptEncoding, contentType string
+		size                        int
+		want                        string
+	}{
+		{"gzip", "text/plain; charset=utf-8", 2048, "gzip"},
+		{"gzip", "text/html", 1024, "gzip"},
+		{"gzip", "text/plain", 1023, ""},
+		{"gzip", "application/json", 2048, "gzip"},
+		{"gzip", "application/ld+json", 2048, "gzip"},
+		{"gzip", "image/svg+xml", 2048, "gzip"},
+		{"gzip", "APPLICATION/JAVASCRIPT", 2048, "gzip"},
+		{"gzip", "image/png", 2048, ""},
+		{"gzip", "application/zip", 2048, ""},
+		{"", "text/plain", 2048, ""},
+		{"br", "text/plain", 2048, ""},
+
+		// The client refusing the bodies as they are gets them compressed regardless.
+		{"gzip, identity;q=0", "text/plain", 10, "gzip"},
+		{"deflate, identity;q=0", "image/png", 2048, "deflate"},
+		{"*;q=0, gzip", "text/plain", 10, "gzip"},
+		{"*;q=0, identity, gzip", "text/plain", 10, ""},
+	} {
+		if got := cp.encoding(c.acceptEncoding, c.contentType, c.size); got != c.want {
+			t.Errorf("encoding(%q, %q, %d) = %q, want %q", c.acceptEncoding, c.contentType, c.size, got, c.want)
+		}
+	}
+	if got := (*compressor)(nil).encoding("gzip", "text/plain", 2048); got != "" {
+		t.Errorf("got %q without a compressor, want the body as it is", got)
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	cp := newCompressor(gzip.BestSpeed, 0)
+	body := strings.Repeat("Hello World!\r\n", 100)
+	// Twice per coding, so that the pooled encoders and buffers are reused.
+	for _, encoding := range []string{"gzip", "deflate", "gzip", "deflate"} {
+		b := cp.appendResp([]byte("prefix"), "200 OK", "Content-Type: text/plain\r\n", body, encoding)
+		if !bytes.HasPrefix(b, []byte("prefix")) {
+			t.Fatalf("the response is not appended: %q", b)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b[len("prefix"):])), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.Header.Get("Content-Encoding"); got != encoding {
+			t.Fatalf("got Content-Encoding %q, want %q", got, encoding)
+		}
+		compressed, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if length := resp.Header.Get("Content-Length"); length != strconv.Itoa(len(compressed)) {
+			t.Fatalf("got Content-Length %s for %d bytes", length, len(compressed))
+		}
+		if len(compressed) >= len(body) {
+			t.Fatalf("%s did not shrink the body: %d bytes", encoding, len(compressed))
+		}
+		var r io.Reader
+		if encoding == "gzip" {
+			r, err = gzip.NewReader(bytes.NewReader(compressed))
+		} else {
+			r, err = zlib.NewReader(bytes.NewReader(compressed))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decoded) != body {
+			t.Fatalf("%s round trip got %q", encoding, decoded)
+		}
+	}
+
+	// appendHandle only compresses for the clients accepting it and varies on Accept-Encoding either way.
+	for acceptEncoding, want := range map[string]string{"gzip": "gzip", "": ""} {
+		b := appendHandle(nil, cp, &request{acceptEncoding: acceptEncoding}, body)
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.Header.Get("Content-Encoding"); got != want || resp.Header.Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("got Content-Encoding %q and Vary %q for %q", got, resp.Header.Get("Vary"), acceptEncoding)
+		}
+	}
+}
@@ -6,8 +6,11 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"strconv"
 	"strings"
@@ -15,6 +18,7 @@ import (
 	"unsafe"
 
 	"github.com/panlibin/gnet"
+	"github.com/panlibin/gnet/pool/goroutine"
 )
 
 var res string
@@ -24,45 +28,287 @@ type request struct {
 	path, query   string
 	head, body    string
 	remoteAddr    string
+
+	acceptEncoding string
+
+	stream interface{} // the state of the body handler streaming the body
+}
+
+// header returns the value of the first header field with the given name, "" if there is none.
+func (req *request) header(name string) string {
+	for head := req.head; head != ""; {
+		line := head
+		if i := strings.Index(head, "\r\n"); i >= 0 {
+			line, head = head[:i], head[i+2:]
+		} else {
+			head = ""
+		}
+		if i := strings.IndexByte(line, ':'); i > 0 && strings.EqualFold(line[:i], name) {
+			return strings.Trim(line[i+1:], " \t")
+		}
+	}
+	return ""
+}
+
+// clone copies the request out of the inbound buffer of the connection, which it refers to.
+func (req *request) clone() *request {
+	return &request{
+		proto:  string(append([]byte(nil), req.proto...)),
+		method: string(append([]byte(nil), req.method...)),
+		path:   string(append([]byte(nil), req.path...)),
+		query:  string(append([]byte(nil), req.query...)),
+		head:   string(append([]byte(nil), req.head...)),
+	}
 }
 
 type httpServer struct {
 	*gnet.EventServer
+	idleTimeout time.Duration
+	files       *fileServer
+	bodies      bodyHandler
+	workerPool  *goroutine.Pool
 }
 
 var errMsg = "Internal Server Error"
 var errMsgBytes = []byte(errMsg)
 
+// httpError is a request the server refuses, the connection is closed after the response with its status.
+type httpError struct {
+	status string
+}
+
+func (e *httpError) Error() string { return e.status }
+
+var (
+	errBadRequest      = &httpError{"400 Bad Request"}
+	errRequestTimeout  = &httpError{"408 Request Timeout"}
+	errTooManyRequests = &httpError{"429 Too Many Requests"}
+	errEntityTooLarge  = &httpError{"413 Payload Too Large"}
+	errExpectFailed    = &httpError{"417 Expectation Failed"}
+	errHeaderTooLarge  = &httpError{"431 Request Header Fields Too Large"}
+	errNotImplemented  = &httpError{"501 Not Implemented"}
+)
+
+// httpConn is the state of a connection kept in its context.
+type httpConn struct {
+	err          *httpError // the request refused, nil if there is none
+	partialSince time.Time  // when the head of the pending request has started to arrive, zero if there is none
+	file         *request   // the request for a static file to serve, nil if there is none
+	body         *bodyState // the request whose body is being streamed, nil if there is none
+	replying     bool       // whether the response to a streamed request is pending
+	continued    bool       // whether 100 Continue has been sent for the pending request
+}
+
+// bodyState is the progress of a body streamed to the body handler.
+type bodyState struct {
+	req       *request
+	remaining int          // the length of the body yet to arrive, unless it is chunked
+	chunked   *chunkedBody // nil if the length of the body is known
+}
+
+// httpCodec guards the server against the slow and malicious clients, which it is exposed to on the internet:
+// the head of a request must arrive within headerTimeout and fit in maxHeaderBytes, at most maxPipeline
+// requests may be pipelined at once, and the requests which could be smuggled are refused, see parseReq.
 type httpCodec struct {
-	req request
+	headerTimeout  time.Duration
+	maxHeaderBytes int
+	maxPipeline    int
+	maxBodyBytes   int // the limit of the bodies buffered in full, the streamed ones are not limited
+
+	compress *compressor // nil if the responses are not compressed
+	files    *fileServer // nil if no static files are served
+	bodies   bodyHandler // nil if no bodies are streamed
 }
 
 func (hc *httpCodec) Encode(c gnet.Conn, buf []byte) (out []byte, err error) {
-	if c.Context() == nil {
-		return buf, nil
+	if hs, ok := c.Context().(*httpConn); ok && hs.err != nil {
+		return appendResp(out, hs.err.status, "Connection: close\r\n", hs.err.status+"\n"), nil
 	}
-	return appendResp(out, "500 Error", "", errMsg+"\n"), nil
+	return buf, nil
 }
 
 func (hc *httpCodec) Decode(c gnet.Conn) (out []byte, err error) {
+	hs, ok := c.Context().(*httpConn)
+	if !ok || hs.err != nil || hs.file != nil || hs.replying {
+		return nil, nil
+	}
 	buf := c.Read()
-	c.ResetBuffer()
 
 	// process the pipeline
-	var leftover []byte
-pipeline:
-	leftover, err = parseReq(buf, &hc.req)
-	// bad thing happened
-	if err != nil {
-		c.SetContext(err)
-		return nil, err
-	} else if len(leftover) == len(buf) {
-		// request not ready, yet
-		return
+	var n, requests int
+	for n < len(buf) {
+		if hs.body != nil {
+			// Return after every chunk, so that PauseRead by the body handler takes effect at once.
+			m, e := hc.streamBody(c, hs, buf[n:])
+			if e != nil {
+				return hc.refuse(c, hs, e), nil
+			}
+			if n += m; m > 0 && out == nil {
+				out = []byte{}
+			}
+			break
+		}
+		var req request
+		leftover, clen, e := parseHead(buf[n:], &req)
+		if e == nil && len(leftover) == len(buf)-n {
+			// request not ready, yet
+			e = hc.checkPartial(hs, buf[n:])
+			if e == nil {
+				break
+			}
+		}
+		if requests++; e == nil && requests > hc.maxPipeline {
+			e = errTooManyRequests
+		}
+		stream := e == nil && hc.bodies != nil && hc.bodies.match(&req)
+		if e == nil {
+			e = hc.checkBody(&req, clen, stream)
+		}
+		var body []byte
+		bodyLen := -1
+		if e == nil && !stream {
+			body, bodyLen, e = readBody(leftover, clen, hc.maxBodyBytes)
+		}
+		if e != nil {
+			// bad thing happened
+			return hc.refuse(c, hs, e), nil
+		}
+		hs.partialSince = time.Time{}
+		continueBody := clen != 0 && len(leftover) == 0 && req.proto == "HTTP/1.1" && req.header("Expect") != ""
+		if stream {
+			n = len(buf) - len(leftover)
+			if continueBody {
+				out = append(out, "HTTP/1.1 100 Continue\r\n\r\n"...)
+			}
+			hs.body = &bodyState{req: req.clone(), remaining: clen}
+			if clen == chunkedLength {
+				hs.body.chunked = new(chunkedBody)
+			}
+			m, e := hc.streamBody(c, hs, leftover)
+			if e != nil {
+				return hc.refuse(c, hs, e), nil
+			}
+			n += m
+			if out == nil {
+				out = []byte{}
+			}
+			break
+		}
+		if bodyLen < 0 {
+			// the body is on its way, the client expecting 100 Continue waits for it before sending the body
+			if continueBody && !hs.continued {
+				hs.continued = true
+				out = append(out, "HTTP/1.1 100 Continue\r\n\r\n"...)
+			}
+			break
+		}
+		hs.continued = false
+		req.body = b2s(body)
+		n = len(buf) - len(leftover) + bodyLen
+		if hc.files.match(&req) {
+			// The requests pipelined after it are decoded once the file has been served.
+			hs.file = req.clone()
+			if out == nil {
+				out = []byte{}
+			}
+			break
+		}
+		out = appendHandle(out, hc.compress, &req, res)
 	}
-	out = appendHandle(out, res)
-	buf = leftover
-	goto pipeline
+	if n > 0 {
+		c.ShiftN(n) // ShiftN(0) would discard the pending request
+	}
+	return
+}
+
+// refuse refuses the request with the status of err, the pending body, if any, is aborted.
+func (hc *httpCodec) refuse(c gnet.Conn, hs *httpConn, err error) []byte {
+	hs.err, _ = err.(*httpError)
+	if hs.err == nil {
+		hs.err = errBadRequest
+	}
+	if b := hs.body; b != nil {
+		hs.body = nil
+		hc.bodies.onBodyEnd(c, b.req, err, func([]byte) {})
+	}
+	c.ResetBuffer()
+	return errMsgBytes
+}
+
+// readBody returns the body of a request buffered in full along with the number of bytes it takes in data,
+// or -1 if it has not arrived in full yet.
+func readBody(data []byte, clen, max int) (body []byte, n int, err error) {
+	if clen == chunkedLength {
+		return readChunked(data, max)
+	}
+	if len(data) < clen {
+		return nil, -1, nil
+	}
+	return data[:clen], clen, nil
+}
+
+// checkBody checks the body of a request against the limits before it arrives, so that the client expecting
+// 100 Continue is refused before sending it.
+func (hc *httpCodec) checkBody(req *request, clen int, stream bool) error {
+	if expect := req.header("Expect"); expect != "" && !strings.EqualFold(expect, "100-continue") {
+		return errExpectFailed
+	}
+	if !stream && clen > hc.maxBodyBytes {
+		return errEntityTooLarge
+	}
+	return nil
+}
+
+// streamBody hands the next chunk of the body being streamed over to the body handler and returns the number
+// of bytes consumed, the handler replies once the body is over while the rest of the pipeline is held back.
+func (hc *httpCodec) streamBody(c gnet.Conn, hs *httpConn, data []byte) (n int, err error) {
+	b := hs.body
+	var chunk []byte
+	var done bool
+	if b.chunked != nil {
+		if chunk, n, done, err = b.chunked.next(data); err != nil {
+			return
+		}
+	} else {
+		if chunk = data; len(chunk) > b.remaining {
+			chunk = chunk[:b.remaining]
+		}
+		n = len(chunk)
+		b.remaining -= n
+		done = b.remaining == 0
+	}
+	if len(chunk) > 0 {
+		hc.bodies.onBody(c, b.req, chunk)
+	}
+	if done {
+		hs.body, hs.replying = nil, true
+		hc.bodies.onBodyEnd(c, b.req, nil, func(resp []byte) {
+			_ = c.AsyncWrite(resp)
+			_ = c.Wake()
+		})
+	}
+	return
+}
+
+// checkPartial checks the head of the request which has not arrived in full yet against the limits.
+func (hc *httpCodec) checkPartial(hs *httpConn, data []byte) error {
+	head := data
+	if i := bytes.Index(data, []byte("\r\n\r\n")); i >= 0 {
+		head = data[:i]
+	}
+	if len(head) > hc.maxHeaderBytes {
+		return errHeaderTooLarge
+	}
+	if len(head) < len(data) {
+		return nil // the head is complete, the body is on its way
+	}
+	if hs.partialSince.IsZero() {
+		hs.partialSince = time.Now()
+	} else if time.Since(hs.partialSince) > hc.headerTimeout {
+		// A client trickling the head byte by byte never goes idle.
+		return errRequestTimeout
+	}
+	return nil
 }
 
 func (hs *httpServer) OnInitComplete(srv gnet.Server) (action gnet.Action) {
@@ -71,13 +317,53 @@ func (hs *httpServer) OnInitComplete(srv gnet.Server) (action gnet.Action) {
 	return
 }
 
+// OnConfigure closes the connections which have read nothing for the idle timeout, e.g. in the middle of
+// the head of a request.
+func (hs *httpServer) OnConfigure(c gnet.Conn) (settings gnet.ConnSettings) {
+	settings.IdleTimeout = hs.idleTimeout
+	return
+}
+
+func (hs *httpServer) OnOpened(c gnet.Conn) (out []byte, action gnet.Action) {
+	c.SetContext(new(httpConn))
+	return
+}
+
+// OnClosed aborts the body being streamed, if any.
+func (hs *httpServer) OnClosed(c gnet.Conn, err error) (action gnet.Action) {
+	if st, ok := c.Context().(*httpConn); ok && st.body != nil {
+		hs.bodies.onBodyEnd(c, st.body.req, io.ErrUnexpectedEOF, func([]byte) {})
+	}
+	return
+}
+
 func (hs *httpServer) React(frame []byte, c gnet.Conn) (out []byte, action gnet.Action) {
-	if c.Context() != nil {
+	if st, ok := c.Context().(*httpConn); ok && st.err != nil {
 		// bad thing happened
 		out = errMsgBytes
 		action = gnet.Close
 		return
 	}
+	if st, ok := c.Context().(*httpConn); ok && st.replying {
+		// The body handler replies by AsyncWrite, hold back the rest of the pipeline until it wakes the connection.
+		st.replying = false
+		return frame, gnet.Throttle
+	}
+	if st, ok := c.Context().(*httpConn); ok && st.file != nil {
+		// Serve the file after the responses ahead of it and hold back the rest of the pipeline meanwhile.
+		req := st.file
+		st.file = nil
+		if err := hs.workerPool.Submit(func() {
+			if err := hs.files.serve(c, req); err != nil {
+				_ = c.Close()
+				return
+			}
+			_ = c.Wake()
+		}); err != nil {
+			return frame, gnet.Close
+		}
+		return frame, gnet.Throttle
+	}
 	// handle the request
 	out = frame
 	return
@@ -86,25 +372,57 @@ func (hs *httpServer) React(frame []byte, c gnet.Conn) (out []byte, action gnet.
 func main() {
 	var port int
 	var multicore bool
+	var repeat, compressLevel, compressMinSize int
+	var files fileServer
+	var uploads uploadHandler
+	http := &httpServer{workerPool: goroutine.Default()}
+	hc := new(httpCodec)
 
 	// Example command: go run http.go --port 8080 --multicore=true
 	flag.IntVar(&port, "port", 8080, "server port")
 	flag.BoolVar(&multicore, "multicore", true, "multicore")
+	flag.DurationVar(&hc.headerTimeout, "header-timeout", 5*time.Second, "deadline of the head of a request")
+	flag.IntVar(&hc.maxHeaderBytes, "max-header-bytes", 8<<10, "maximum size of the head of a request")
+	flag.IntVar(&hc.maxPipeline, "max-pipeline", 16, "maximum number of requests pipelined at once")
+	flag.DurationVar(&http.idleTimeout, "idle-timeout", time.Minute, "idle timeout of the connections")
+	flag.IntVar(&repeat, "repeat", 1, "times the response body is repeated")
+	flag.IntVar(&compressLevel, "compress-level", gzip.DefaultCompression, "compression level of the responses, 0 disables it")
+	flag.IntVar(&compressMinSize, "compress-min-size", 1024, "minimum size of the response bodies to compress")
+	flag.IntVar(&hc.maxBodyBytes, "max-body-bytes", 1<<20, "maximum size of the request bodies buffered in full")
+	flag.StringVar(&uploads.prefix, "upload-prefix", "/upload", "URL path prefix of the uploads streamed in chunks")
+	flag.StringVar(&files.prefix, "static-prefix", "/static/", "URL path prefix of the static files")
+	flag.StringVar(&files.root, "static-root", "", "directory of the static files, none are served if it is empty")
 	flag.Parse()
 
-	res = "Hello World!\r\n"
-
-	http := new(httpServer)
-	hc := new(httpCodec)
+	res = strings.Repeat("Hello World!\r\n", repeat)
+	if compressLevel != gzip.NoCompression {
+		hc.compress = newCompressor(compressLevel, compressMinSize)
+	}
+	if uploads.prefix != "" {
+		hc.bodies, http.bodies = &uploads, &uploads
+	}
+	if files.root != "" {
+		hc.files, http.files = &files, &files
+	}
 
-	// Start serving!
-	log.Fatal(gnet.Serve(http, fmt.Sprintf("tcp://:%d", port), gnet.WithMulticore(multicore), gnet.WithCodec(hc)))
+	// Start serving! The first request must arrive in full within the header timeout.
+	log.Fatal(gnet.Serve(http, fmt.Sprintf("tcp://:%d", port), gnet.WithMulticore(multicore), gnet.WithCodec(hc),
+		gnet.WithHandshakeTimeout(hc.headerTimeout)))
 }
 
 // appendHandle handles the incoming request and appends the response to
 // the provided bytes, which is then returned to the caller.
-func appendHandle(b []byte, res string) []byte {
-	return appendResp(b, "200 OK", "", res)
+// The response is compressed if the client accepts one of the supported content codings.
+func appendHandle(b []byte, cp *compressor, req *request, res string) []byte {
+	const contentType = "text/plain; charset=utf-8"
+	if cp == nil {
+		return appendResp(b, "200 OK", "Content-Type: "+contentType+"\r\n", res)
+	}
+	head := "Content-Type: " + contentType + "\r\nVary: Accept-Encoding\r\n"
+	if encoding := cp.encoding(req.acceptEncoding, contentType, len(res)); encoding != "" {
+		return cp.appendResp(b, "200 OK", head, res, encoding)
+	}
+	return appendResp(b, "200 OK", head, res)
 }
 
 // appendResp will append a valid http response to the provide bytes.
@@ -140,10 +458,33 @@ func b2s(b []byte) string {
 // waits for the entire payload to be buffered before returning a
 // valid request.
 func parseReq(data []byte, req *request) (leftover []byte, err error) {
+	rest, clen, err := parseHead(data, req)
+	if err != nil || len(rest) == len(data) {
+		return data, err
+	}
+	body, n, err := readBody(rest, clen, len(rest))
+	if err != nil || n < 0 {
+		return data, err
+	}
+	req.body = b2s(body)
+	return rest[n:], nil
+}
+
+// parseHead parses the head of a request, it returns the data after the head and the length of the body,
+// chunkedLength if it is chunked, or the data as it is if the head has not arrived in full yet.
+// It refuses the requests which the proxies in front of the server may frame differently, i.e. the ones with
+// both Content-Length and Transfer-Encoding, with Content-Lengths which disagree or are not plain numbers,
+// and with malformed or folded header lines. The transfer codings other than chunked are not implemented.
+func parseHead(data []byte, req *request) (leftover []byte, clen int, err error) {
 	sdata := b2s(data)
+	if !strings.Contains(sdata, "\r\n") {
+		// not enough data for the request line
+		return data, 0, nil
+	}
 	var i, s int
 	var head string
-	var clen int
+	var te string
+	clen = -1
 	var q = -1
 	// method, path, proto line
 	for ; i < len(sdata); i++ {
@@ -151,17 +492,17 @@ func parseReq(data []byte, req *request) (leftover []byte, err error) {
 			req.method = sdata[s:i]
 			for i, s = i+1, i+1; i < len(sdata); i++ {
 				if sdata[i] == '?' && q == -1 {
-					q = i - s
+					q = i
 				} else if sdata[i] == ' ' {
 					if q != -1 {
 						req.path = sdata[s:q]
-						req.query = req.path[q+1 : i]
+						req.query = sdata[q+1 : i]
 					} else {
 						req.path = sdata[s:i]
 					}
 					for i, s = i+1, i+1; i < len(sdata); i++ {
 						if sdata[i] == '\n' && sdata[i-1] == '\r' {
-							req.proto = sdata[s:i]
+							req.proto = sdata[s : i-1]
 							i, s = i+1, i+1
 							break
 						}
@@ -173,7 +514,7 @@ func parseReq(data []byte, req *request) (leftover []byte, err error) {
 		}
 	}
 	if req.proto == "" {
-		return data, fmt.Errorf("malformed request")
+		return data, 0, errBadRequest
 	}
 	head = sdata[:s]
 	for ; i < len(sdata); i++ {
@@ -181,25 +522,51 @@ func parseReq(data []byte, req *request) (leftover []byte, err error) {
 			line := sdata[s : i-1]
 			s = i + 1
 			if line == "" {
-				req.head = sdata[len(head)+2 : i+1]
+				req.head = sdata[len(head) : i+1]
 				i++
-				if clen > 0 {
-					if len(sdata[i:]) < clen {
-						break
+				if te != "" {
+					if clen >= 0 {
+						return data, 0, errBadRequest
 					}
-					req.body = sdata[i : i+clen]
-					i += clen
+					if !strings.EqualFold(te, "chunked") {
+						return data, 0, errNotImplemented
+					}
+					return data[i:], chunkedLength, nil
+				}
+				if clen < 0 {
+					clen = 0
 				}
-				return data[i:], nil
+				return data[i:], clen, nil
+			}
+			colon := strings.IndexByte(line, ':')
+			if colon <= 0 || strings.ContainsAny(line[:colon], " \t") || strings.ContainsAny(line, "\r\n") {
+				// no header name, whitespace before the colon or a folded line
+				return data, 0, errBadRequest
 			}
-			if strings.HasPrefix(line, "Content-Length:") {
-				n, err := strconv.ParseInt(strings.TrimSpace(line[len("Content-Length:"):]), 10, 64)
-				if err == nil {
-					clen = int(n)
+			name, value := line[:colon], strings.Trim(line[colon+1:], " \t")
+			switch {
+			case strings.EqualFold(name, "Content-Length"):
+				n, err := strconv.ParseUint(value, 10, 31)
+				if err != nil || clen >= 0 && int(n) != clen {
+					return data, 0, errBadRequest
+				}
+				clen = int(n)
+			case strings.EqualFold(name, "Transfer-Encoding"):
+				if value == "" {
+					return data, 0, errBadRequest
+				}
+				if te != "" {
+					value = te + "," + value
+				}
+				te = value
+			case strings.EqualFold(name, "Accept-Encoding"):
+				if req.acceptEncoding != "" {
+					value = req.acceptEncoding + "," + value
 				}
+				req.acceptEncoding = value
 			}
 		}
 	}
 	// not enough data
-	return data, nil
+	return data, 0, nil
 }
@@ -0,0 +1,73 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build go1.18
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/panlibin/gnet"
+	"github.com/panlibin/gnet/gnettest"
+)
+
+func FuzzParseReq(f *testing.F) {
+	f.Add([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	f.Add([]byte("GET /search?q=gnet HTTP/1.1\r\n\r\n"))
+	f.Add([]byte("POST /echo HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello"))
+	f.Add([]byte("POST /echo HTTP/1.1\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\nhello"))
+	f.Add([]byte("POST /echo HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5;ext\r\nhello\r\n0\r\nTrailer: x\r\n\r\n"))
+	f.Add([]byte("POST /echo HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var req request
+		leftover, err := parseReq(data, &req)
+		if err != nil {
+			return
+		}
+		if len(leftover) > len(data) {
+			t.Fatalf("leftover %d bytes out of %d bytes", len(leftover), len(data))
+		}
+	})
+}
+
+func FuzzReadChunked(f *testing.F) {
+	f.Add([]byte("5;ext\r\nhello\r\n0\r\nTrailer: x\r\n\r\n"))
+	f.Add([]byte("ffffffff\r\n"))
+	f.Add([]byte("5\r\nhelloXX\r\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		body, n, err := readChunked(data, 1<<10)
+		if err != nil || n < 0 {
+			return
+		}
+		if n > len(data) || len(body) > n {
+			t.Fatalf("decoded %d bytes of body out of %d bytes, %d of %d consumed", len(body), n, n, len(data))
+		}
+		// The body is complete at n and not before.
+		if _, m, err := readChunked(data[:n-1], 1<<10); m != -1 || err != nil {
+			t.Fatalf("the body cut short got %d, %v, want it to wait for the rest", m, err)
+		}
+	})
+}
+
+// FuzzHTTPCodec feeds the input to the codec of the server in two reads split at the given offset,
+// the codec must neither panic nor spin.
+func FuzzHTTPCodec(f *testing.F) {
+	f.Add(uint16(10), []byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\nGET / HTTP/1.1\r\n\r\n"))
+	f.Add(uint16(40), []byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n"))
+	f.Add(uint16(50), []byte("POST /stream HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello"))
+	f.Fuzz(func(t *testing.T, split uint16, data []byte) {
+		bodies := new(testBodyHandler)
+		hc := &httpCodec{headerTimeout: time.Second, maxHeaderBytes: 1 << 10, maxPipeline: 4, maxBodyBytes: 64,
+			bodies: bodies}
+		l := gnettest.NewLoop(testHTTPServer{&httpServer{bodies: bodies}}, gnet.WithCodec(hc))
+		c := l.Dial()
+		i := int(split) % (len(data) + 1)
+		l.Send(c, data[:i])
+		l.RunJobs()
+		l.Send(c, data[i:])
+		l.RunJobs()
+	})
+}
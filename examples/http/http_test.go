@@ -0,0 +1,223 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/panlibin/gnet"
+	"github.com/panlibin/gnet/gnettest"
+)
+
+const continueResp = "HTTP/1.1 100 Continue\r\n\r\n"
+
+// testBodyHandler records the chunks of the bodies it streams and replies with them at once.
+type testBodyHandler struct {
+	chunks []string
+	ended  int
+	err    error
+}
+
+func (h *testBodyHandler) match(req *request) bool { return req.path == "/stream" }
+
+func (h *testBodyHandler) onBody(c gnet.Conn, req *request, chunk []byte) {
+	h.chunks = append(h.chunks, string(chunk))
+}
+
+func (h *testBodyHandler) onBodyEnd(c gnet.Conn, req *request, err error, reply func(resp []byte)) {
+	h.ended++
+	if h.err = err; err == nil {
+		reply(appendResp(nil, "200 OK", "", strings.Join(h.chunks, "")))
+	}
+}
+
+// testHTTPServer keeps quiet about the loop it is not listening on.
+type testHTTPServer struct {
+	*httpServer
+}
+
+func (s testHTTPServer) OnInitComplete(srv gnet.Server) (action gnet.Action) { return }
+
+func newTestLoop(bodies bodyHandler) (*gnettest.Loop, *gnettest.Conn) {
+	hc := &httpCodec{headerTimeout: time.Second, maxHeaderBytes: 1 << 10, maxPipeline: 4, maxBodyBytes: 64,
+		bodies: bodies}
+	l := gnettest.NewLoop(testHTTPServer{&httpServer{bodies: bodies}}, gnet.WithCodec(hc))
+	return l, l.Dial()
+}
+
+func TestParseChunkSize(t *testing.T) {
+	for _, c := range []struct {
+		line string
+		size int
+		err  error
+	}{
+		{"5", 5, nil},
+		{"1a;name=value", 26, nil},
+		{`A ; name="quoted;value"`, 10, nil},
+		{"0", 0, nil},
+		{"7fffffff", 1<<31 - 1, nil},
+		{"80000000", 0, errEntityTooLarge},
+		{"ffffffffffffffffffff", 0, errEntityTooLarge},
+		{"", 0, errBadRequest},
+		{";name", 0, errBadRequest},
+		{"x", 0, errBadRequest},
+		{"-1", 0, errBadRequest},
+		{"0x5", 0, errBadRequest},
+	} {
+		if size, err := parseChunkSize(c.line); size != c.size || err != c.err {
+			t.Errorf("parseChunkSize(%q) = %d, %v, want %d, %v", c.line, size, err, c.size, c.err)
+		}
+	}
+}
+
+func TestReadChunked(t *testing.T) {
+	for _, c := range []struct {
+		data string
+		body string
+		n    int
+		err  error
+	}{
+		{"0\r\n\r\n", "", 5, nil},
+		{"5\r\nhello\r\n6;ext=1\r\n world\r\n0\r\n\r\nGET", "hello world", 32, nil},
+		{"5\r\nhello\r\n0\r\nChecksum: abc\r\nExpires: never\r\n\r\n", "hello", 46, nil},
+		{"5\r\nhello\r\n0\r\n", "", -1, nil},
+		{"5\r\nhel", "", -1, nil},
+		{"41\r\n", "", 0, errEntityTooLarge},
+		{"20\r\n" + strings.Repeat("a", 32) + "\r\n21\r\n", "", 0, errEntityTooLarge},
+		{"5\r\nhelloXX\r\n0\r\n\r\n", "", 0, errBadRequest},
+		{"5\r\nhello\r\n0\r\nno colon\r\n\r\n", "", 0, errBadRequest},
+		{"5\nhello\r\n0\r\n\r\n", "", 0, errBadRequest},
+		{strings.Repeat("0", maxChunkLine+1), "", 0, errBadRequest},
+	} {
+		body, n, err := readChunked([]byte(c.data), 64)
+		if string(body) != c.body || n != c.n || err != c.err {
+			t.Errorf("readChunked(%q) = %q, %d, %v, want %q, %d, %v", c.data, body, n, err, c.body, c.n, c.err)
+		}
+	}
+
+	// A body split anywhere is decoded once it has arrived in full.
+	data := "3;a=b\r\nabc\r\n2\r\nde\r\n0\r\nTrailer: x\r\n\r\n"
+	for i := 0; i < len(data); i++ {
+		if body, n, err := readChunked([]byte(data[:i]), 64); body != nil || n != -1 || err != nil {
+			t.Fatalf("readChunked(%q) = %q, %d, %v, want it to wait for the rest", data[:i], body, n, err)
+		}
+	}
+}
+
+func TestParseHeadTransferEncoding(t *testing.T) {
+	for _, c := range []struct {
+		head string
+		clen int
+		err  error
+	}{
+		{"Transfer-Encoding: chunked\r\n", chunkedLength, nil},
+		{"Transfer-Encoding: Chunked\r\n", chunkedLength, nil},
+		{"Transfer-Encoding: chunked\r\nContent-Length: 5\r\n", 0, errBadRequest},
+		{"Content-Length: 5\r\nTransfer-Encoding: chunked\r\n", 0, errBadRequest},
+		{"Transfer-Encoding:\r\n", 0, errBadRequest},
+		{"Transfer-Encoding: gzip, chunked\r\n", 0, errNotImplemented},
+		{"Transfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n", 0, errNotImplemented},
+	} {
+		var req request
+		if _, clen, err := parseHead([]byte("POST / HTTP/1.1\r\n"+c.head+"\r\n"), &req); clen != c.clen || err != c.err {
+			t.Errorf("parseHead(%q) = %d, %v, want %d, %v", c.head, clen, err, c.clen, c.err)
+		}
+	}
+}
+
+func TestChunkedBody(t *testing.T) {
+	l, c := newTestLoop(nil)
+	l.Send(c, []byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel"))
+	if w := c.Written(); len(w) != 0 {
+		t.Fatalf("got %q before the body has arrived", w)
+	}
+	l.Send(c, []byte("lo\r\n0\r\n\r\nGET / HTTP/1.1\r\n\r\n"))
+	if w := string(c.Written()); strings.Count(w, "200 OK") != 2 {
+		t.Fatalf("got %q, want the responses to both requests", w)
+	}
+
+	// A chunk announcing more than the limit is refused before it arrives.
+	l.Send(c, []byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n100\r\n"))
+	if w := string(c.Written()); !strings.HasPrefix(w, "HTTP/1.1 413 ") || !c.Closed() {
+		t.Fatalf("got %q, want 413 and the connection closed", w)
+	}
+}
+
+func TestStreamChunkedBody(t *testing.T) {
+	h := new(testBodyHandler)
+	l, c := newTestLoop(h)
+	l.Send(c, []byte("POST /stream HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5;ext\r\nhel"))
+	l.Send(c, []byte("lo\r\n200\r\n"))
+	if strings.Join(h.chunks, "|") != "hel|lo" {
+		t.Fatalf("got the chunks %q, want hel and lo", h.chunks)
+	}
+	// The bodies streamed are not limited.
+	l.Send(c, append(bytes.Repeat([]byte("a"), 0x200), "\r\n0\r\nTrailer: x\r\n\r\n"...))
+	l.RunJobs()
+	if w := string(c.Written()); h.ended != 1 || !strings.HasSuffix(w, "\r\n\r\nhello"+strings.Repeat("a", 0x200)) {
+		t.Fatalf("got %q after the body, want the reply of the handler", w)
+	}
+
+	// A malformed chunk aborts the body.
+	h = new(testBodyHandler)
+	l, c = newTestLoop(h)
+	l.Send(c, []byte("POST /stream HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nabc\r\n"))
+	if h.ended != 1 || h.err != errBadRequest || !c.Closed() {
+		t.Fatalf("got the body ended %d times with %v, want it aborted and the connection closed", h.ended, h.err)
+	}
+	l.Hangup(c, nil)
+	if h.ended != 1 {
+		t.Fatalf("the body ended %d times, want once", h.ended)
+	}
+}
+
+func TestContinue(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		head string
+		body []string
+	}{
+		{"buffered", "POST / HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n", []string{"he", "llo"}},
+		{"chunked", "POST / HTTP/1.1\r\nExpect: 100-continue\r\nTransfer-Encoding: chunked\r\n\r\n",
+			[]string{"5\r\nhe", "llo\r\n", "0\r\n\r\n"}},
+		{"streamed", "POST /stream HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n",
+			[]string{"he", "llo"}},
+		{"streamed chunked", "POST /stream HTTP/1.1\r\nExpect: 100-continue\r\nTransfer-Encoding: chunked\r\n\r\n",
+			[]string{"5\r\nhe", "llo\r\n", "0\r\n\r\n"}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			l, conn := newTestLoop(new(testBodyHandler))
+			l.Send(conn, []byte(c.head))
+			// Decoding the pending request again must not repeat it.
+			l.Send(conn, nil)
+			if w := string(conn.Written()); w != continueResp {
+				t.Fatalf("got %q after the head, want 100 Continue", w)
+			}
+			var w string
+			for _, chunk := range c.body {
+				l.Send(conn, []byte(chunk))
+				l.RunJobs()
+				w += string(conn.Written())
+			}
+			if strings.Contains(w, "100 Continue") || !strings.HasPrefix(w, "HTTP/1.1 200 OK") {
+				t.Fatalf("got %q after the body, want a single response", w)
+			}
+		})
+	}
+
+	// The client sending the body without waiting gets no 100 Continue, nor does one expecting something else.
+	l, conn := newTestLoop(nil)
+	l.Send(conn, []byte("POST / HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello"))
+	if w := string(conn.Written()); !strings.HasPrefix(w, "HTTP/1.1 200 OK") {
+		t.Fatalf("got %q, want the response alone", w)
+	}
+	l.Send(conn, []byte("POST / HTTP/1.1\r\nExpect: something\r\nContent-Length: 5\r\n\r\n"))
+	if w := string(conn.Written()); !strings.HasPrefix(w, "HTTP/1.1 417 ") {
+		t.Fatalf("got %q, want 417", w)
+	}
+}
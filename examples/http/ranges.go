@@ -0,0 +1,164 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/panlibin/gnet"
+)
+
+// maxRanges is the number of ranges above which the Range header is ignored, as a client asking for many tiny
+// ranges costs the server a lot more than the file itself.
+const maxRanges = 16
+
+// byteRange is a range of a file.
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(r.start, 10) + "-" + strconv.FormatInt(r.start+r.length-1, 10) + "/" +
+		strconv.FormatInt(size, 10)
+}
+
+// parseRanges parses the Range header of a request for a file of the given size. It returns nil if the header
+// should be ignored and the whole file be served, i.e. it is malformed, has a unit other than bytes, too many
+// ranges or ranges adding up to more than the file, and false if none of the ranges is satisfiable.
+func parseRanges(header string, size int64) (ranges []byteRange, ok bool) {
+	const unit = "bytes="
+	if !strings.HasPrefix(header, unit) {
+		return nil, true
+	}
+	specs := strings.Split(header[len(unit):], ",")
+	if len(specs) > maxRanges {
+		return nil, true
+	}
+	var total int64
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		i := strings.IndexByte(spec, '-')
+		if i < 0 {
+			return nil, true
+		}
+		first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+		var r byteRange
+		if first == "" {
+			// The suffix of the file.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, true
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{size - n, n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, true
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+					return nil, true
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start, end - start + 1}
+		}
+		total += r.length
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, false
+	}
+	if total > size {
+		return nil, true
+	}
+	return ranges, true
+}
+
+// notModified reports whether the client has the file cached already. If-None-Match takes precedence
+// over If-Modified-Since.
+func notModified(req *request, etag string, modTime time.Time) bool {
+	if inm := req.header("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			// The weak comparison, W/ is insignificant.
+			if tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/"); tag == etag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	t, err := http.ParseTime(req.header("If-Modified-Since"))
+	return err == nil && !modTime.After(t)
+}
+
+// ifRange reports whether the Range header of a request holds, i.e. the transfer which the client resumes
+// is of the current file. The strong comparison applies, a weak entity-tag never matches.
+func ifRange(header, etag string, modTime time.Time) bool {
+	switch {
+	case header == "":
+		return true
+	case strings.HasPrefix(header, `"`):
+		return header == etag
+	case strings.HasPrefix(header, "W/"):
+		return false
+	}
+	t, err := http.ParseTime(header)
+	return err == nil && t.Equal(modTime)
+}
+
+// serveMultipart writes the ranges of the file as a multipart/byteranges response, each range is sent
+// by a sendfile of its own.
+func serveMultipart(c gnet.Conn, req *request, f *os.File, head, ctype string, size int64, ranges []byteRange) error {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return writeStatus(c, "500 Internal Server Error")
+	}
+	boundary := hex.EncodeToString(b[:])
+
+	// The part headers are known in advance, so is the length of the content.
+	parts := make([]string, len(ranges))
+	length := int64(len("\r\n--" + boundary + "--\r\n"))
+	for i, r := range ranges {
+		parts[i] = "\r\n--" + boundary + "\r\nContent-Type: " + ctype + "\r\nContent-Range: " + r.contentRange(size) +
+			"\r\n\r\n"
+		if i == 0 {
+			parts[i] = parts[i][2:]
+		}
+		length += int64(len(parts[i])) + r.length
+	}
+	head += "Content-Type: multipart/byteranges; boundary=" + boundary + "\r\nContent-Length: " +
+		strconv.FormatInt(length, 10) + "\r\n"
+	if _, err := c.Write(appendResp(nil, "206 Partial Content", head, "")); err != nil || req.method == "HEAD" {
+		return err
+	}
+	for i, r := range ranges {
+		if _, err := c.Write([]byte(parts[i])); err != nil {
+			return err
+		}
+		if err := sendRange(c, f, r); err != nil {
+			return err
+		}
+	}
+	_, err := c.Write([]byte("\r\n--" + boundary + "--\r\n"))
+	return err
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/panlibin/gnet/gnettest"
+)
+
+func TestParseRanges(t *testing.T) {
+	for _, c := range []struct {
+		header string
+		size   int64
+		ranges []byteRange
+		ok     bool
+	}{
+		{"bytes=0-9", 100, []byteRange{{0, 10}}, true},
+		{"bytes=-10", 100, []byteRange{{90, 10}}, true},
+		{"bytes=-200", 100, []byteRange{{0, 100}}, true},
+		{"bytes=90-", 100, []byteRange{{90, 10}}, true},
+		{"bytes=90-200", 100, []byteRange{{90, 10}}, true},
+		{"bytes= 0-0 , -1", 100, []byteRange{{0, 1}, {99, 1}}, true},
+		{"bytes=100-,0-4", 100, []byteRange{{0, 5}}, true},
+
+		// None of the ranges is satisfiable.
+		{"bytes=100-", 100, nil, false},
+		{"bytes=200-300", 100, nil, false},
+		{"bytes=-0", 100, nil, false},
+		{"bytes=0-", 0, nil, false},
+
+		// Ignored, the whole file is served.
+		{"", 100, nil, true},
+		{"items=0-9", 100, nil, true},
+		{"bytes=abc", 100, nil, true},
+		{"bytes=5-1", 100, nil, true},
+		{"bytes=x-5", 100, nil, true},
+		{"bytes=-x", 100, nil, true},
+		{"bytes=" + strings.Repeat("0-0,", maxRanges) + "0-0", 100, nil, true},
+		{"bytes=0-99,0-99", 100, nil, true},
+	} {
+		ranges, ok := parseRanges(c.header, c.size)
+		if !reflect.DeepEqual(ranges, c.ranges) || ok != c.ok {
+			t.Errorf("parseRanges(%q, %d) = %v, %t, want %v, %t", c.header, c.size, ranges, ok, c.ranges, c.ok)
+		}
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	modTime := time.Date(2019, 10, 1, 8, 0, 0, 0, time.UTC)
+	const etag = `"abc"`
+	for _, c := range []struct {
+		header string
+		want   bool
+	}{
+		{"", true},
+		{`"abc"`, true},
+		{`"abd"`, false},
+		{`W/"abc"`, false},
+		{modTime.Format(http.TimeFormat), true},
+		{modTime.Add(-time.Second).Format(http.TimeFormat), false},
+		{modTime.Add(time.Second).Format(http.TimeFormat), false},
+		{"yesterday", false},
+	} {
+		if got := ifRange(c.header, etag, modTime); got != c.want {
+			t.Errorf("ifRange(%q) = %t, want %t", c.header, got, c.want)
+		}
+	}
+}
+
+func TestServeMultipart(t *testing.T) {
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	f, err := ioutil.TempFile("", "gnet-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err = f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+
+	size := int64(len(content))
+	ranges := []byteRange{{0, 5}, {10, 1}, {30, 6}}
+	c := gnettest.NewConn(nil)
+	if err = serveMultipart(c, &request{method: "GET"}, f, "", "text/plain", size, ranges); err != nil {
+		t.Fatal(err)
+	}
+	resp := c.Written()
+	i := bytes.Index(resp, []byte("\r\n\r\n"))
+	if i < 0 {
+		t.Fatalf("no end of the header in %q", resp)
+	}
+	head, body := &request{head: string(resp[:i+2])}, resp[i+4:]
+	if length, _ := strconv.Atoi(head.header("Content-Length")); length != len(body) {
+		t.Fatalf("Content-Length %d, %d bytes written", length, len(body))
+	}
+
+	_, params, err := mime.ParseMediaType(head.header("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for _, r := range ranges {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := part.Header.Get("Content-Range"), r.contentRange(size); got != want {
+			t.Fatalf("got Content-Range %q, want %q", got, want)
+		}
+		data, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := content[r.start : r.start+r.length]; !bytes.Equal(data, want) {
+			t.Fatalf("got part %q, want %q", data, want)
+		}
+	}
+	if _, err = mr.NextPart(); err == nil {
+		t.Fatal("expected the parts to end")
+	}
+
+	// A HEAD request declares the same length but gets no content.
+	if err = serveMultipart(c, &request{method: "HEAD"}, f, "", "text/plain", size, ranges); err != nil {
+		t.Fatal(err)
+	}
+	if resp = c.Written(); !bytes.HasSuffix(resp, []byte("\r\n\r\n")) {
+		t.Fatalf("got content in the response to a HEAD request: %q", resp)
+	}
+}
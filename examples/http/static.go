@@ -0,0 +1,146 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/panlibin/gnet"
+)
+
+// fileServer serves the files under root for the URL paths under prefix, e.g. /static/css/a.css is served from
+// root/css/a.css. The files are streamed by Conn.ReadFrom, hence sent by sendfile(2) with no copy in user space.
+// As ReadFrom blocks, the files are served in a goroutine while the connection is throttled, so that the responses
+// to the requests pipelined after it are not written ahead.
+type fileServer struct {
+	prefix string
+	root   string
+}
+
+// match reports whether the request is for a static file.
+func (fs *fileServer) match(req *request) bool {
+	return fs != nil && (req.method == "GET" || req.method == "HEAD") && strings.HasPrefix(req.path, fs.prefix)
+}
+
+// serve writes the response with the file the request is for to the connection, it must not be invoked on
+// the event-loop.
+func (fs *fileServer) serve(c gnet.Conn, req *request) error {
+	p, err := url.PathUnescape(strings.TrimPrefix(req.path, fs.prefix))
+	if err != nil {
+		return writeStatus(c, "404 Not Found")
+	}
+	if !validPath(p) {
+		return writeStatus(c, "400 Bad Request")
+	}
+	// Cleaning the path rooted at / drops the .. elements which would escape the root, should any be left.
+	name := filepath.Join(fs.root, filepath.FromSlash(path.Clean("/"+p)))
+	f, err := os.Open(name)
+	if err != nil {
+		return writeStatus(c, "404 Not Found")
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err == nil && fi.IsDir() {
+		_ = f.Close()
+		if f, err = os.Open(filepath.Join(name, "index.html")); err != nil {
+			return writeStatus(c, "404 Not Found")
+		}
+		defer f.Close()
+		fi, err = f.Stat()
+	}
+	if err != nil || !fi.Mode().IsRegular() {
+		return writeStatus(c, "404 Not Found")
+	}
+
+	modTime := fi.ModTime().UTC().Truncate(time.Second)
+	etag := fmt.Sprintf(`"%x-%x"`, modTime.Unix(), fi.Size())
+	head := "Last-Modified: " + modTime.Format(http.TimeFormat) + "\r\nETag: " + etag + "\r\nAccept-Ranges: bytes\r\n"
+	if notModified(req, etag, modTime) {
+		_, err = c.Write(appendResp(nil, "304 Not Modified", head, ""))
+		return err
+	}
+
+	ctype := mime.TypeByExtension(filepath.Ext(fi.Name()))
+	if ctype == "" {
+		// Sniff the content as net/http does.
+		var buf [512]byte
+		n, _ := io.ReadFull(f, buf[:])
+		ctype = http.DetectContentType(buf[:n])
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return writeStatus(c, "500 Internal Server Error")
+		}
+	}
+
+	ranges := []byteRange{{0, fi.Size()}}
+	if r := req.header("Range"); r != "" && ifRange(req.header("If-Range"), etag, modTime) {
+		rs, ok := parseRanges(r, fi.Size())
+		if !ok {
+			head += "Content-Range: bytes */" + strconv.FormatInt(fi.Size(), 10) + "\r\nContent-Length: 0\r\n"
+			_, err = c.Write(appendResp(nil, "416 Range Not Satisfiable", head, ""))
+			return err
+		}
+		if rs != nil {
+			ranges = rs
+		}
+	}
+	if len(ranges) > 1 {
+		return serveMultipart(c, req, f, head, ctype, fi.Size(), ranges)
+	}
+
+	status, r := "200 OK", ranges[0]
+	head += "Content-Type: " + ctype + "\r\n"
+	if r.length < fi.Size() {
+		status = "206 Partial Content"
+		head += "Content-Range: " + r.contentRange(fi.Size()) + "\r\n"
+	}
+	head += "Content-Length: " + strconv.FormatInt(r.length, 10) + "\r\n"
+	if _, err = c.Write(appendResp(nil, status, head, "")); err != nil || req.method == "HEAD" {
+		return err
+	}
+	return sendRange(c, f, r)
+}
+
+// validPath reports whether the unescaped path of a file is free of the .. elements, the backslashes
+// which are separators on windows and the NULs, which are all refused rather than resolved.
+func validPath(p string) bool {
+	if strings.ContainsAny(p, "\\\x00") {
+		return false
+	}
+	for _, elem := range strings.Split(p, "/") {
+		if elem == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// sendRange writes the range of the file to the connection.
+func sendRange(c gnet.Conn, f *os.File, r byteRange) error {
+	if r.length == 0 {
+		return nil
+	}
+	if _, err := f.Seek(r.start, io.SeekStart); err != nil {
+		return err
+	}
+	// Pass the *io.LimitedReader around the file itself, so that ReadFrom sees the file.
+	_, err := c.ReadFrom(io.LimitReader(f, r.length))
+	return err
+}
+
+// writeStatus writes a response with no content but its status.
+func writeStatus(c gnet.Conn, status string) error {
+	_, err := c.Write(appendResp(nil, status, "", status+"\n"))
+	return err
+}
@@ -0,0 +1,197 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/panlibin/gnet/gnettest"
+)
+
+// newTestFileServer serves a temporary directory holding a.txt, sub/index.html and the empty directory empty,
+// next to secret.txt which must not be served.
+func newTestFileServer(t *testing.T) (fs *fileServer, cleanup func()) {
+	dir, err := ioutil.TempDir("", "gnet-http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "root")
+	for name, content := range map[string]string{
+		"secret.txt":           "secret",
+		"root/a.txt":           "hello static",
+		"root/noext":           "plain text without an extension",
+		"root/sub/index.html":  "<html>index</html>",
+		"root/sub/deeper/b.js": "var b;",
+	} {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Mkdir(filepath.Join(root, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &fileServer{prefix: "/static/", root: root}, func() { _ = os.RemoveAll(dir) }
+}
+
+// serveFile serves the request in raw and returns the response with its body read.
+func serveFile(t *testing.T, fs *fileServer, raw string) (*http.Response, string) {
+	var req request
+	if _, _, err := parseHead([]byte(raw), &req); err != nil {
+		t.Fatalf("parseHead(%q): %v", raw, err)
+	}
+	if !fs.match(&req) {
+		t.Fatalf("%q does not match the file server", raw)
+	}
+	c := gnettest.NewConn(nil)
+	if err := fs.serve(c, &req); err != nil {
+		t.Fatal(err)
+	}
+	w := c.Written()
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(w)), &http.Request{Method: req.method})
+	if err != nil {
+		t.Fatalf("malformed response %q: %v", w, err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestStaticPaths(t *testing.T) {
+	fs, cleanup := newTestFileServer(t)
+	defer cleanup()
+	for _, c := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/static/a.txt", 200, "hello static"},
+		{"/static/./a.txt", 200, "hello static"},
+		{"/static//sub/deeper/b.js", 200, "var b;"},
+		{"/static/sub/", 200, "<html>index</html>"},
+		{"/static/sub", 200, "<html>index</html>"},
+		{"/static/", 404, ""},
+		{"/static/empty/", 404, ""},
+		{"/static/missing.txt", 404, ""},
+		{"/static/%zz", 404, ""},
+
+		// The escapes of the root are refused however they are spelled.
+		{"/static/../secret.txt", 400, ""},
+		{"/static/sub/../../secret.txt", 400, ""},
+		{"/static/%2e%2e/secret.txt", 400, ""},
+		{"/static/sub%2f..%2f..%2fsecret.txt", 400, ""},
+		{"/static/..%5csecret.txt", 400, ""},
+		{"/static/a.txt%00.html", 400, ""},
+		{"/static/..", 400, ""},
+	} {
+		resp, body := serveFile(t, fs, "GET "+c.path+" HTTP/1.1\r\n\r\n")
+		if resp.StatusCode != c.status || c.status == 200 && body != c.body {
+			t.Errorf("GET %s = %d %q, want %d %q", c.path, resp.StatusCode, body, c.status, c.body)
+		}
+		if strings.Contains(body, "secret") {
+			t.Errorf("GET %s escaped the root", c.path)
+		}
+	}
+}
+
+func TestStaticHeaders(t *testing.T) {
+	fs, cleanup := newTestFileServer(t)
+	defer cleanup()
+	modTime := time.Date(2019, 10, 1, 8, 0, 0, 500, time.UTC)
+	if err := os.Chtimes(filepath.Join(fs.root, "a.txt"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body := serveFile(t, fs, "GET /static/a.txt HTTP/1.1\r\n\r\n")
+	lastModified, etag := resp.Header.Get("Last-Modified"), resp.Header.Get("ETag")
+	if lastModified != "Tue, 01 Oct 2019 08:00:00 GMT" {
+		t.Fatalf("got Last-Modified %q", lastModified)
+	}
+	if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+		t.Fatalf("got ETag %q, want a strong one", etag)
+	}
+	if ctype := resp.Header.Get("Content-Type"); !strings.HasPrefix(ctype, "text/plain") {
+		t.Fatalf("got Content-Type %q", ctype)
+	}
+	if resp.ContentLength != int64(len(body)) || resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("got Content-Length %d for %q and Accept-Ranges %q", resp.ContentLength, body,
+			resp.Header.Get("Accept-Ranges"))
+	}
+	resp, _ = serveFile(t, fs, "GET /static/noext HTTP/1.1\r\n\r\n")
+	if ctype := resp.Header.Get("Content-Type"); !strings.HasPrefix(ctype, "text/plain") {
+		t.Fatalf("got Content-Type %q for a file without extension, want it sniffed", ctype)
+	}
+
+	// The file is the same as long as it is not modified.
+	if resp, _ = serveFile(t, fs, "GET /static/a.txt HTTP/1.1\r\n\r\n"); resp.Header.Get("ETag") != etag {
+		t.Fatalf("got ETag %q, then %q", etag, resp.Header.Get("ETag"))
+	}
+	modified := modTime.Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(fs.root, "a.txt"), modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ = serveFile(t, fs, "GET /static/a.txt HTTP/1.1\r\n\r\n"); resp.Header.Get("ETag") == etag {
+		t.Fatalf("got the same ETag %q for the modified file", etag)
+	}
+	if err := os.Chtimes(filepath.Join(fs.root, "a.txt"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body = serveFile(t, fs, "HEAD /static/a.txt HTTP/1.1\r\n\r\n")
+	if resp.StatusCode != 200 || body != "" || resp.ContentLength != int64(len("hello static")) {
+		t.Fatalf("HEAD got %d %q with Content-Length %d", resp.StatusCode, body, resp.ContentLength)
+	}
+}
+
+func TestStaticNotModified(t *testing.T) {
+	fs, cleanup := newTestFileServer(t)
+	defer cleanup()
+	modTime := time.Date(2019, 10, 1, 8, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(fs.root, "a.txt"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := serveFile(t, fs, "GET /static/a.txt HTTP/1.1\r\n\r\n")
+	etag := resp.Header.Get("ETag")
+	before, after := modTime.Add(-time.Hour).Format(http.TimeFormat), modTime.Add(time.Hour).Format(http.TimeFormat)
+	for _, c := range []struct {
+		head   string
+		status int
+	}{
+		{"If-None-Match: " + etag, 304},
+		{"If-None-Match: W/" + etag, 304},
+		{`If-None-Match: "other", ` + etag, 304},
+		{"If-None-Match: *", 304},
+		{`If-None-Match: "other"`, 200},
+		{"If-Modified-Since: " + modTime.Format(http.TimeFormat), 304},
+		{"If-Modified-Since: " + after, 304},
+		{"If-Modified-Since: " + before, 200},
+		{"If-Modified-Since: yesterday", 200},
+
+		// If-None-Match takes precedence.
+		{`If-None-Match: "other"` + "\r\nIf-Modified-Since: " + after, 200},
+		{"If-None-Match: " + etag + "\r\nIf-Modified-Since: " + before, 304},
+	} {
+		resp, body := serveFile(t, fs, "GET /static/a.txt HTTP/1.1\r\n"+c.head+"\r\n\r\n")
+		if resp.StatusCode != c.status {
+			t.Errorf("%q got %d, want %d", c.head, resp.StatusCode, c.status)
+		}
+		if c.status == 304 && (body != "" || resp.Header.Get("ETag") != etag) {
+			t.Errorf("%q got 304 with %q and ETag %q", c.head, body, resp.Header.Get("ETag"))
+		}
+	}
+}
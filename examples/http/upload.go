@@ -0,0 +1,102 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"strings"
+	"sync"
+
+	"github.com/panlibin/gnet"
+)
+
+// bodyHandler consumes the bodies of the requests it matches chunk by chunk as they arrive,
+// so that an upload is never buffered in memory in full.
+type bodyHandler interface {
+	// match reports whether the body of the request is to be streamed.
+	match(req *request) bool
+
+	// onBody is invoked on the event-loop with every chunk of the body, which is only valid during the call.
+	// A handler falling behind may PauseRead the connection until it catches up, which holds back the client.
+	onBody(c gnet.Conn, req *request, chunk []byte)
+
+	// onBodyEnd is invoked on the event-loop after the last chunk, or with an error if the connection has been
+	// closed in the middle of the body. The response is written by reply, which may be invoked from any goroutine,
+	// the requests pipelined after it are held back until then.
+	onBodyEnd(c gnet.Conn, req *request, err error, reply func(resp []byte))
+}
+
+// uploadQueueSize is the number of chunks an upload buffers before it pauses reading the connection.
+const uploadQueueSize = 4
+
+// uploadHandler digests the bodies of the POST and PUT requests under prefix in a goroutine of their own
+// and replies with their size and SHA-256 checksum.
+type uploadHandler struct {
+	prefix string
+}
+
+// upload is the state of a body being digested.
+type upload struct {
+	c      gnet.Conn
+	chunks chan []byte
+	reply  func(resp []byte)
+	err    error
+
+	mu      sync.Mutex
+	pending int  // chunks queued but not digested yet
+	paused  bool // whether reading the connection has been paused for the upload to catch up
+}
+
+func (uh *uploadHandler) match(req *request) bool {
+	return (req.method == "POST" || req.method == "PUT") && strings.HasPrefix(req.path, uh.prefix)
+}
+
+func (uh *uploadHandler) upload(c gnet.Conn, req *request) *upload {
+	if up, ok := req.stream.(*upload); ok {
+		return up
+	}
+	up := &upload{c: c, chunks: make(chan []byte, uploadQueueSize)}
+	req.stream = up
+	go up.digest(sha256.New())
+	return up
+}
+
+func (uh *uploadHandler) onBody(c gnet.Conn, req *request, chunk []byte) {
+	up := uh.upload(c, req)
+	up.mu.Lock()
+	up.pending++
+	up.chunks <- append([]byte(nil), chunk...)
+	if up.pending == uploadQueueSize {
+		up.paused = true
+		_ = c.PauseRead()
+	}
+	up.mu.Unlock()
+}
+
+func (uh *uploadHandler) onBodyEnd(c gnet.Conn, req *request, err error, reply func(resp []byte)) {
+	up := uh.upload(c, req)
+	up.reply, up.err = reply, err
+	close(up.chunks)
+}
+
+func (up *upload) digest(h hash.Hash) {
+	var size int
+	for chunk := range up.chunks {
+		_, _ = h.Write(chunk)
+		size += len(chunk)
+		up.mu.Lock()
+		if up.pending--; up.paused {
+			up.paused = false
+			_ = up.c.ResumeRead()
+		}
+		up.mu.Unlock()
+	}
+	if up.err != nil {
+		return
+	}
+	up.reply(appendResp(nil, "200 OK", "", fmt.Sprintf("received %d bytes, sha256 %x\n", size, h.Sum(nil))))
+}
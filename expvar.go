@@ -0,0 +1,129 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+// expvarCounters are the core counters published under Options.Expvar, by their names.
+var expvarCounters = []struct {
+	name  string
+	value func(s *GServer, stats Stats) int64
+}{
+	{"connections", func(s *GServer, _ Stats) int64 { return int64(s.numConns()) }},
+	{"shed_accepts", func(_ *GServer, stats Stats) int64 { return stats.ShedAccepts }},
+	{"shed_drops", func(_ *GServer, stats Stats) int64 { return stats.ShedDrops }},
+	{"firewall_denied", func(_ *GServer, stats Stats) int64 { return stats.FirewallDenied }},
+	{"tap_dropped", func(_ *GServer, stats Stats) int64 { return stats.TapDropped }},
+	{"accept_backlog", func(_ *GServer, stats Stats) int64 { return int64(stats.AcceptBacklog) }},
+	{"accept_backlog_limit", func(_ *GServer, stats Stats) int64 { return int64(stats.AcceptBacklogLimit) }},
+	{"accept_wakeups", func(_ *GServer, stats Stats) int64 { return stats.AcceptWakeups }},
+	{"accepted", func(_ *GServer, stats Stats) int64 { return stats.Accepted }},
+	{"accept_batch_full", func(_ *GServer, stats Stats) int64 { return stats.AcceptBatchFull }},
+	{"fd_exhausted", func(_ *GServer, stats Stats) int64 { return stats.FDExhausted }},
+	{"partial_writes", func(_ *GServer, stats Stats) int64 { return stats.PartialWrites }},
+	{"write_eagain", func(_ *GServer, stats Stats) int64 { return stats.WriteEAGAIN }},
+	{"write_enobufs", func(_ *GServer, stats Stats) int64 { return stats.WriteENOBUFS }},
+	{"listen_overflows", func(_ *GServer, stats Stats) int64 { return stats.ListenOverflows }},
+	{"listen_drops", func(_ *GServer, stats Stats) int64 { return stats.ListenDrops }},
+	{"slow_consumers", func(_ *GServer, stats Stats) int64 { return stats.SlowConsumers }},
+	{"rebalanced", func(_ *GServer, stats Stats) int64 { return stats.Rebalanced }},
+	{"nat_sessions", func(_ *GServer, stats Stats) int64 { return stats.NATSessions }},
+	{"urgent_jobs", func(_ *GServer, stats Stats) int64 { return int64(stats.UrgentJobs) }},
+	{"queued_jobs", func(_ *GServer, stats Stats) int64 { return int64(stats.QueuedJobs) }},
+	{"wakeups", func(_ *GServer, stats Stats) int64 { return stats.Wakeups }},
+	{"wakeups_coalesced", func(_ *GServer, stats Stats) int64 { return stats.WakeupsCoalesced }},
+	{"job_errors", func(_ *GServer, stats Stats) int64 { return stats.JobErrors }},
+	{"handoff_overflows", func(_ *GServer, stats Stats) int64 { return stats.HandoffOverflows }},
+}
+
+// expvarBinding is the server whose counters are published under a prefix.
+type expvarBinding struct {
+	s    *GServer
+	done chan struct{} // the loopsDone of the server, nil until it is serving
+}
+
+var (
+	expvarMu       sync.Mutex
+	expvarBindings = make(map[string]*expvarBinding)
+)
+
+// claimExpvar reserves the prefix for the server, it fails if the prefix is taken by another running server
+// or the names are published by others.
+func (s *GServer) claimExpvar(prefix string) error {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if b, ok := expvarBindings[prefix]; ok {
+		if b.running() {
+			return fmt.Errorf("%w: expvar prefix %q is taken by another server", ErrInvalidOptions, prefix)
+		}
+		expvarBindings[prefix] = &expvarBinding{s: s}
+		return nil
+	}
+	for _, counter := range expvarCounters {
+		if expvar.Get(prefix+"."+counter.name) != nil {
+			return fmt.Errorf("%w: expvar %q is already published", ErrInvalidOptions, prefix+"."+counter.name)
+		}
+	}
+	expvarBindings[prefix] = &expvarBinding{s: s}
+	for _, counter := range expvarCounters {
+		value := counter.value
+		expvar.Publish(prefix+"."+counter.name, expvar.Func(func() interface{} {
+			s := boundExpvar(prefix)
+			if s == nil {
+				return nil
+			}
+			return value(s, s.Stats())
+		}))
+	}
+	return nil
+}
+
+// bindExpvar publishes the counters of the server once it is serving.
+func (s *GServer) bindExpvar(prefix string) {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if b := expvarBindings[prefix]; b != nil && b.s == s {
+		b.done = s.s.loopsDone
+	}
+}
+
+// releaseExpvar gives the prefix up after the server has failed to serve.
+func (s *GServer) releaseExpvar(prefix string) {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if b := expvarBindings[prefix]; b != nil && b.s == s {
+		b.s = nil
+	}
+}
+
+// boundExpvar returns the running server published under the prefix, nil if there is none.
+func boundExpvar(prefix string) *GServer {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if b := expvarBindings[prefix]; b != nil && b.done != nil && b.running() {
+		return b.s
+	}
+	return nil
+}
+
+func (b *expvarBinding) running() bool {
+	if b.s == nil {
+		return false
+	}
+	if b.done == nil {
+		// The server is being started.
+		return true
+	}
+	select {
+	case <-b.done:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"math/rand"
+	"net"
+	"time"
+)
+
+const defaultTargetCooldown = 5 * time.Second
+
+// ClientTarget is an upstream address of a Client along with its weight.
+type ClientTarget struct {
+	// Addr is the address in the same format as ClientConfig.Addr.
+	Addr string
+
+	// Weight is the relative share of the dials going to the target, zero makes it a backup target which is
+	// only dialed when all the targets with weights are unhealthy.
+	Weight int
+}
+
+// TargetState is the health state of a target of a Client.
+type TargetState struct {
+	ClientTarget
+
+	// Healthy reports whether the target is healthy, a target is unhealthy for the cooldown after a failed dial
+	// or until it is marked healthy again after MarkTarget has marked it unhealthy.
+	Healthy bool
+
+	// Connected reports whether the client is connected to the target.
+	Connected bool
+}
+
+type clientTarget struct {
+	ClientTarget
+	down      bool      // a dial has failed
+	downUntil time.Time // the end of the cooldown after the failed dial
+	marked    bool      // marked unhealthy by MarkTarget
+}
+
+func (t *clientTarget) healthy(now time.Time) bool {
+	return !t.marked && (!t.down || !now.Before(t.downUntil))
+}
+
+// Targets returns the health state of the targets.
+func (c *Client) Targets() []TargetState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	states := make([]TargetState, 0, len(c.targets))
+	for _, t := range c.targets {
+		states = append(states, TargetState{
+			ClientTarget: t.ClientTarget,
+			Healthy:      t.healthy(now),
+			Connected:    c.conn != nil && c.target == t,
+		})
+	}
+	return states
+}
+
+// MarkTarget marks a target healthy or unhealthy, e.g. by the result of an external health check,
+// it reports whether addr is a target of the client. Marking a target healthy rebalances the client
+// if ClientConfig.Rebalance is set.
+func (c *Client) MarkTarget(addr string, healthy bool) bool {
+	c.mu.Lock()
+	var found bool
+	for _, t := range c.targets {
+		if t.Addr == addr {
+			found = true
+			t.marked = !healthy
+			if healthy {
+				t.down = false
+			}
+		}
+	}
+	c.mu.Unlock()
+	if found && healthy && c.config.Rebalance {
+		select {
+		case c.recovered <- struct{}{}:
+		default:
+		}
+	}
+	return found
+}
+
+// dialOrder returns the targets in the order to dial them: the healthy targets with weights in weighted
+// random order, the healthy backup targets and the unhealthy targets. It must be invoked with c.mu locked.
+func (c *Client) dialOrder() []*clientTarget {
+	now := time.Now()
+	var weighted, backups, unhealthy []*clientTarget
+	total := 0
+	for _, t := range c.targets {
+		switch {
+		case !t.healthy(now):
+			unhealthy = append(unhealthy, t)
+		case t.Weight > 0:
+			weighted = append(weighted, t)
+			total += t.Weight
+		default:
+			backups = append(backups, t)
+		}
+	}
+	order := make([]*clientTarget, 0, len(c.targets))
+	for len(weighted) > 0 {
+		n := rand.Intn(total)
+		for i, t := range weighted {
+			if n -= t.Weight; n < 0 {
+				order = append(order, t)
+				total -= t.Weight
+				weighted = append(weighted[:i], weighted[i+1:]...)
+				break
+			}
+		}
+	}
+	return append(append(order, backups...), unhealthy...)
+}
+
+// dialTargets dials the targets in order until one succeeds, the targets failing to be dialed are unhealthy
+// for the cooldown.
+func (c *Client) dialTargets(order []*clientTarget) (conn net.Conn, target *clientTarget, err error) {
+	for _, t := range order {
+		conn, err = c.dialAddr(t.Addr)
+		c.mu.Lock()
+		if err != nil {
+			t.down, t.downUntil = true, time.Now().Add(c.config.TargetCooldown)
+		} else {
+			t.down = false
+		}
+		c.mu.Unlock()
+		if err == nil {
+			return conn, t, nil
+		}
+	}
+	return
+}
+
+// watchTargets rebalances the client whenever a target has recovered.
+func (c *Client) watchTargets() {
+	ticker := time.NewTicker(c.config.TargetCooldown)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !c.recoverTargets() {
+				continue
+			}
+		case <-c.recovered:
+		case <-c.done:
+			return
+		}
+		c.rebalance()
+	}
+}
+
+// recoverTargets reports whether the cooldown of any unhealthy target has elapsed.
+func (c *Client) recoverTargets() (recovered bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, t := range c.targets {
+		if t.down && !now.Before(t.downUntil) {
+			t.down = false
+			recovered = true
+		}
+	}
+	return
+}
+
+// rebalance draws a target again and moves the connection over to it if it is another target,
+// the responses in flight on the connection left are lost.
+func (c *Client) rebalance() {
+	c.mu.Lock()
+	if c.conn == nil || c.closed {
+		c.mu.Unlock()
+		return
+	}
+	order, current := c.dialOrder(), c.target
+	c.mu.Unlock()
+	if len(order) == 0 || order[0] == current {
+		return
+	}
+	conn, target, err := c.dialTargets(order[:1])
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	if c.conn == nil || c.closed {
+		c.mu.Unlock()
+		_ = conn.Close()
+		return
+	}
+	old := c.conn
+	c.conn, c.target = conn, target
+	c.mu.Unlock()
+	// The reading goroutine moves over to the new connection once the old one has been closed.
+	_ = old.Close()
+	if c.config.OnReconnect != nil {
+		c.config.OnReconnect(c, 0)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "time"
+
+// FaultOp is the kind of I/O operation which a fault is injected into.
+type FaultOp uint8
+
+const (
+	// FaultRead indicates the data read from a connection, before it is decoded.
+	FaultRead FaultOp = iota
+
+	// FaultWrite indicates the data written to a connection by the event handler, after it is encoded.
+	FaultWrite
+)
+
+func (op FaultOp) String() string {
+	if op == FaultRead {
+		return "read"
+	}
+	return "write"
+}
+
+// Fault describes how a chunk of data is mangled, the zero value leaves the data intact.
+// The steps are applied in order: Drop, Truncate, Reorder, Split and Delay.
+type Fault struct {
+	// Drop discards the data.
+	Drop bool
+
+	// Truncate discards the data beyond the first Truncate bytes, zero means no truncation.
+	Truncate int
+
+	// Reorder holds the data back until the next chunk in the same direction has been handled,
+	// or until the reorder window expires.
+	Reorder bool
+
+	// Split breaks the data into pieces of at most Split bytes which are handled one by one,
+	// simulating short reads and partial writes, zero means no splitting.
+	Split int
+
+	// Delay holds the data for the duration before it is handled, split pieces are Delay apart.
+	// The order of the data in the same direction is preserved.
+	Delay time.Duration
+}
+
+// FaultPolicy decides the fault to be injected into a chunk of data of a TCP connection, it is invoked on
+// the event-loop goroutine, data is only valid during the call.
+type FaultPolicy func(c Conn, op FaultOp, data []byte) Fault
+
+// faultReorderWindow is how long a reordered chunk waits for the next chunk.
+const faultReorderWindow = 10 * time.Millisecond
+
+type faultPiece struct {
+	data []byte
+	at   time.Time
+}
+
+// faultDir is the fault injection state of a direction of a connection.
+type faultDir struct {
+	pending []faultPiece
+	last    time.Time
+	armed   bool
+	held    []byte
+	heldGen int
+	deliver func(data []byte) error
+}
+
+// connFault injects faults into the data of a connection, it is owned by the event-loop of the connection.
+type connFault struct {
+	c        Conn
+	policy   FaultPolicy
+	schedule func(delay time.Duration, job func() error)
+	dirs     [2]faultDir
+	closed   bool
+}
+
+func newConnFault(c Conn, policy FaultPolicy, schedule func(time.Duration, func() error),
+	deliverRead, deliverWrite func([]byte) error) *connFault {
+	cf := &connFault{c: c, policy: policy, schedule: schedule}
+	cf.dirs[FaultRead].deliver = deliverRead
+	cf.dirs[FaultWrite].deliver = deliverWrite
+	return cf
+}
+
+// inject applies the policy to a chunk of data and hands it over, now or later, to the deliver function.
+func (cf *connFault) inject(op FaultOp, data []byte) error {
+	f := cf.policy(cf.c, op, data)
+	if f.Drop || len(data) == 0 {
+		return nil
+	}
+	if f.Truncate > 0 && len(data) > f.Truncate {
+		data = data[:f.Truncate]
+	}
+	d := &cf.dirs[op]
+	if f.Reorder && d.held == nil {
+		d.held = copyBytes(data)
+		d.heldGen++
+		gen := d.heldGen
+		cf.schedule(faultReorderWindow, func() error {
+			if cf.closed || d.held == nil || d.heldGen != gen {
+				return nil
+			}
+			cf.enqueue(d, d.held, Fault{})
+			d.held = nil
+			return cf.flush(op)
+		})
+		return nil
+	}
+	cf.enqueue(d, data, f)
+	if d.held != nil {
+		cf.enqueue(d, d.held, Fault{})
+		d.held = nil
+	}
+	return cf.flush(op)
+}
+
+func (cf *connFault) enqueue(d *faultDir, data []byte, f Fault) {
+	size := len(data)
+	if f.Split > 0 {
+		size = f.Split
+	}
+	at := time.Now().Add(f.Delay)
+	if at.Before(d.last) {
+		at = d.last
+	}
+	for len(data) > 0 {
+		n := size
+		if n > len(data) {
+			n = len(data)
+		}
+		d.pending = append(d.pending, faultPiece{data: copyBytes(data[:n]), at: at})
+		d.last = at
+		data, at = data[n:], at.Add(f.Delay)
+	}
+}
+
+// flush delivers the pending pieces which are due and schedules the rest.
+func (cf *connFault) flush(op FaultOp) error {
+	d := &cf.dirs[op]
+	now := time.Now()
+	for len(d.pending) > 0 && !cf.closed {
+		p := d.pending[0]
+		if p.at.After(now) {
+			if !d.armed {
+				d.armed = true
+				cf.schedule(p.at.Sub(now), func() error {
+					d.armed = false
+					if cf.closed {
+						return nil
+					}
+					return cf.flush(op)
+				})
+			}
+			return nil
+		}
+		d.pending[0].data = nil
+		d.pending = d.pending[1:]
+		if err := d.deliver(p.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close discards the pending data.
+func (cf *connFault) close() {
+	cf.closed = true
+	for i := range cf.dirs {
+		cf.dirs[i].pending = nil
+		cf.dirs[i].held = nil
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "time"
+
+const (
+	defaultFDBackoff    = 10 * time.Millisecond
+	defaultFDMaxBackoff = time.Second
+)
+
+// FDExhaustionPolicy tells how the server copes with running out of file descriptors on accept.
+type FDExhaustionPolicy int
+
+const (
+	// FDExhaustionPause stops accepting for a backoff period, the pending connections wait in the accept queue
+	// until the server has file descriptors again.
+	FDExhaustionPause FDExhaustionPolicy = iota
+
+	// FDExhaustionReserve keeps a spare file descriptor which is given up to accept the pending connections and
+	// close them right away, so that their peers fail fast instead of waiting in the accept queue. Accepting
+	// pauses as FDExhaustionPause does when even the spare file descriptor can't be taken back.
+	FDExhaustionReserve
+)
+
+func (p FDExhaustionPolicy) String() string {
+	switch p {
+	case FDExhaustionPause:
+		return "pause"
+	case FDExhaustionReserve:
+		return "reserve"
+	default:
+		return "unknown"
+	}
+}
+
+// FDExhaustion sets up how the server copes with accept failing with EMFILE or ENFILE, which would otherwise
+// spin on the listener which stays readable.
+type FDExhaustion struct {
+	// Policy is the way of coping, FDExhaustionPause by default.
+	Policy FDExhaustionPolicy
+
+	// Backoff is the first pause of accepting, which doubles on every failure in a row up to MaxBackoff,
+	// they default to 10ms and 1s.
+	Backoff, MaxBackoff time.Duration
+
+	// OnExhausted fires on the event-loop accepting the connections when accept has failed with err, pause is
+	// how long accepting pauses, zero if the connections have been shed by the spare file descriptor.
+	// It must not block.
+	OnExhausted func(err error, pause time.Duration)
+}
+
+// backoff returns the pause of accepting after the given number of failures in a row.
+func (fe FDExhaustion) backoff(failures int) time.Duration {
+	d, max := fe.Backoff, fe.MaxBackoff
+	if d <= 0 {
+		d = defaultFDBackoff
+	}
+	if max <= 0 {
+		max = defaultFDMaxBackoff
+	}
+	for ; failures > 1 && d < max; failures-- {
+		d *= 2
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+// +build !gnet_net
+
+package gnet
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// spareFD is the file descriptor kept in reserve by FDExhaustionReserve, it is shared by the event-loops
+// accepting the connections.
+type spareFD struct {
+	mu sync.Mutex
+	fd int // -1 if it has been given up and not taken back yet
+}
+
+func newSpareFD() *spareFD {
+	sf := &spareFD{fd: -1}
+	sf.take()
+	return sf
+}
+
+// take takes the spare file descriptor back if it has been given up, it must be invoked with the lock held or
+// before the spare file descriptor is shared.
+func (sf *spareFD) take() {
+	if sf.fd < 0 {
+		if fd, err := unix.Open("/dev/null", unix.O_RDONLY|unix.O_CLOEXEC, 0); err == nil {
+			sf.fd = fd
+		}
+	}
+}
+
+// shed gives up the spare file descriptor to accept a pending connection and close it right away, it reports
+// whether the spare file descriptor has been taken back.
+func (sf *spareFD) shed(ln *listener) bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.fd < 0 {
+		return false
+	}
+	sniffError(unix.Close(sf.fd))
+	sf.fd = -1
+	if nfd, _, err := ln.accept(); err == nil {
+		sniffError(unix.Close(nfd))
+	}
+	sf.take()
+	return sf.fd >= 0
+}
+
+// retake takes the spare file descriptor back if it has been given up.
+func (sf *spareFD) retake() {
+	sf.mu.Lock()
+	sf.take()
+	sf.mu.Unlock()
+}
+
+func (sf *spareFD) close() {
+	sf.mu.Lock()
+	if sf.fd >= 0 {
+		sniffError(unix.Close(sf.fd))
+		sf.fd = -1
+	}
+	sf.mu.Unlock()
+}
+
+// acceptFailed copes with accept failing with err on the event-loop polling the listener, it returns err unless
+// the server has run out of file descriptors, see Options.FDExhaustion.
+func (el *eventloop) acceptFailed(err error) error {
+	if err != unix.EMFILE && err != unix.ENFILE {
+		return err
+	}
+	svr := el.svr
+	atomic.AddInt64(&svr.stats.fdExhausted, 1)
+	fe := svr.opts.FDExhaustion
+	var pause time.Duration
+	if svr.spare == nil || !svr.spare.shed(el.ln) {
+		el.acceptFailures++
+		pause = fe.backoff(el.acceptFailures)
+		el.pauseAccepting(pause)
+	}
+	if fe.OnExhausted != nil {
+		fe.OnExhausted(err, pause)
+	}
+	return nil
+}
+
+// pauseAccepting takes the listener off the event-loop for the pause, which would otherwise spin on it.
+func (el *eventloop) pauseAccepting(pause time.Duration) {
+	if el.acceptPause != nil {
+		return
+	}
+	_ = el.poller.Delete(el.ln.fd)
+	el.acceptPause = el.poller.AddTimer(pause, func() error {
+		el.acceptPause = nil
+		if el.svr.spare != nil {
+			el.svr.spare.retake()
+		}
+		_ = el.poller.AddRead(el.ln.fd)
+		return nil
+	})
+}
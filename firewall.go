@@ -0,0 +1,131 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Firewall filters peers by their IP addresses with CIDR allow and deny lists, denied peers are rejected
+// right after being accepted, before OnOpened fires and before any buffers are allocated.
+//
+// A peer is denied if it matches the deny list, or if the allow list is not empty and it doesn't match
+// the allow list. Firewall is safe for concurrent use, the lists can be replaced at runtime.
+type Firewall struct {
+	rules atomic.Value // *firewallRules
+}
+
+type firewallRules struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewFirewall instantiates a firewall with the given lists of CIDRs or plain IP addresses.
+func NewFirewall(allow, deny []string) (*Firewall, error) {
+	fw := new(Firewall)
+	if err := fw.Update(allow, deny); err != nil {
+		return nil, err
+	}
+	return fw, nil
+}
+
+// Update replaces the allow and deny lists atomically, the lists are left untouched if any entry is invalid.
+func (fw *Firewall) Update(allow, deny []string) error {
+	rules := new(firewallRules)
+	var err error
+	if rules.allow, err = parseCIDRs(allow); err != nil {
+		return err
+	}
+	if rules.deny, err = parseCIDRs(deny); err != nil {
+		return err
+	}
+	fw.rules.Store(rules)
+	return nil
+}
+
+// LoadFile replaces the allow and deny lists with the rules in the given file, each line of the file
+// is either "allow <cidr>" or "deny <cidr>", blank lines and lines starting with '#' are ignored.
+func (fw *Firewall) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var allow, deny []string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("firewall: malformed rule at %s:%d", path, line)
+		}
+		switch fields[0] {
+		case "allow":
+			allow = append(allow, fields[1])
+		case "deny":
+			deny = append(deny, fields[1])
+		default:
+			return fmt.Errorf("firewall: unknown action %q at %s:%d", fields[0], path, line)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	return fw.Update(allow, deny)
+}
+
+// Allowed reports whether the peer with the given IP address is allowed.
+func (fw *Firewall) Allowed(ip net.IP) bool {
+	rules, _ := fw.rules.Load().(*firewallRules)
+	if rules == nil {
+		return true
+	}
+	for _, n := range rules.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(rules.allow) == 0 {
+		return true
+	}
+	for _, n := range rules.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("firewall: invalid IP address %q", s)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				nets = append(nets, &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)})
+			} else {
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+			}
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("firewall: invalid CIDR %q", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
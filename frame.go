@@ -0,0 +1,73 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"sync/atomic"
+
+	"github.com/panlibin/gnet/pool/bytebuffer"
+)
+
+// Frame is a frame retained beyond the event handler, the frame passed to React and the data returned by Conn.Read
+// are only valid until the event handler returns as the event-loop recycles the buffers behind them, so they must
+// be retained before being handed over to other goroutines:
+//
+//	f := gnet.RetainFrame(frame)
+//	_ = pool.Submit(func() {
+//		defer f.Release()
+//		process(f.Bytes())
+//	})
+//
+// The data of a frame lives in a pooled buffer which is recycled when the frame has been released as many times
+// as it has been retained, using or releasing it afterwards panics rather than reading recycled data.
+type Frame struct {
+	buf  *bytebuffer.ByteBuffer
+	refs int32
+}
+
+// RetainFrame copies the data into a pooled buffer and returns a frame holding one reference.
+func RetainFrame(data []byte) *Frame {
+	buf := bytebuffer.Get()
+	_, _ = buf.Write(data)
+	return &Frame{buf: buf, refs: 1}
+}
+
+// Bytes returns the data of the frame, which is valid until the last reference is released.
+func (f *Frame) Bytes() []byte {
+	if atomic.LoadInt32(&f.refs) <= 0 {
+		panic("gnet: use of a released frame")
+	}
+	return f.buf.B
+}
+
+// Len returns the length of the frame.
+func (f *Frame) Len() int {
+	return len(f.Bytes())
+}
+
+// Retain adds a reference to the frame for another holder, e.g. a second worker, and returns the frame.
+func (f *Frame) Retain() *Frame {
+	for {
+		refs := atomic.LoadInt32(&f.refs)
+		if refs <= 0 {
+			panic("gnet: retain of a released frame")
+		}
+		if atomic.CompareAndSwapInt32(&f.refs, refs, refs+1) {
+			return f
+		}
+	}
+}
+
+// Release drops a reference to the frame, the buffer is recycled when the last one is dropped.
+func (f *Frame) Release() {
+	switch refs := atomic.AddInt32(&f.refs, -1); {
+	case refs == 0:
+		buf := f.buf
+		f.buf = nil
+		bytebuffer.Put(buf)
+	case refs < 0:
+		panic("gnet: release of a released frame")
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build gofuzz
+
+package gnet
+
+import "encoding/binary"
+
+// The go-fuzz entry points of the built-in frame codecs: the length-field, line, delimiter and fixed-length ones,
+// build them with:
+//
+//	go-fuzz-build -func FuzzLengthFieldBasedFrameCodec github.com/panlibin/gnet
+//
+// They return 1 if the input was decoded into frames, 0 otherwise. The package has no HTTP nor WebSocket codec,
+// the HTTP parsing of examples/http is fuzzed by its own go test -fuzz targets.
+
+func fuzzResult(frames [][]byte, err error) int {
+	if err == ErrDecoderStalled {
+		panic(err)
+	}
+	if len(frames) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// FuzzLengthFieldBasedFrameCodec derives the configuration of the codec from the first byte of the input
+// and decodes the rest of it.
+func FuzzLengthFieldBasedFrameCodec(data []byte) int {
+	if len(data) < 2 {
+		return -1
+	}
+	b := data[0]
+	var order binary.ByteOrder = binary.BigEndian
+	if b&0x80 != 0 {
+		order = binary.LittleEndian
+	}
+	lengthFieldLength := []int{1, 2, 3, 4, 8}[int(b&0x7)%5]
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{
+		ByteOrder:           order,
+		LengthFieldOffset:   int(b>>3) & 0x3,
+		LengthFieldLength:   lengthFieldLength,
+		LengthAdjustment:    int(b>>5)&0x3 - 1,
+		InitialBytesToStrip: int(data[1] % 16),
+	})
+	frames, _, err := DecodeFrames(codec, data[2:])
+	return fuzzResult(frames, err)
+}
+
+// FuzzLineBasedFrameCodec decodes the input with LineBasedFrameCodec.
+func FuzzLineBasedFrameCodec(data []byte) int {
+	frames, _, err := DecodeFrames(new(LineBasedFrameCodec), data)
+	return fuzzResult(frames, err)
+}
+
+// FuzzDelimiterBasedFrameCodec decodes the input with DelimiterBasedFrameCodec whose delimiter is
+// the first byte of the input.
+func FuzzDelimiterBasedFrameCodec(data []byte) int {
+	if len(data) < 1 {
+		return -1
+	}
+	frames, _, err := DecodeFrames(NewDelimiterBasedFrameCodec(data[0]), data[1:])
+	return fuzzResult(frames, err)
+}
+
+// FuzzFixedLengthFrameCodec decodes the input with FixedLengthFrameCodec whose frame length is
+// the first byte of the input.
+func FuzzFixedLengthFrameCodec(data []byte) int {
+	if len(data) < 1 || data[0] == 0 {
+		return -1
+	}
+	frames, _, err := DecodeFrames(NewFixedLengthFrameCodec(int(data[0])), data[1:])
+	return fuzzResult(frames, err)
+}
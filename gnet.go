@@ -6,15 +6,15 @@
 package gnet
 
 import (
+	"io"
 	"log"
 	"net"
 	"os"
 	"runtime"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
-
-	"github.com/panlibin/gnet/internal/netpoll"
 )
 
 // Action is an action that occurs after the completion of an event.
@@ -29,6 +29,27 @@ const (
 
 	// Shutdown shutdowns the server.
 	Shutdown
+
+	// Throttle stops reading the connection until it is woken up by Conn.Wake, the frames left in the inbound
+	// buffer are held back as well and decoded after the wake-up.
+	Throttle
+
+	// Detach removes the connection from the event-loop and hands it over to OnDetached as a blocking net.Conn,
+	// OnClosed does not fire for a detached connection.
+	Detach
+)
+
+// WritePriority is the priority class of outbound data.
+type WritePriority int
+
+const (
+	// PriorityNormal queues data behind the data that is already waiting to be written.
+	PriorityNormal WritePriority = iota
+
+	// PriorityHigh makes data jump ahead of the normal data waiting to be written, which suits control frames
+	// like pings, acks and errors. Frames are never interleaved, so it is written right after the frame
+	// that is being written.
+	PriorityHigh
 )
 
 var defaultLogger = Logger(log.New(os.Stderr, "", log.LstdFlags))
@@ -60,6 +81,16 @@ type Server struct {
 
 	// TCPKeepAlive (SO_KEEPALIVE) socket option.
 	TCPKeepAlive time.Duration
+
+	opts *Options
+}
+
+// Options returns the effective configuration of the server with the defaults resolved, e.g. for logging it.
+func (s Server) Options() Options {
+	if s.opts == nil {
+		return Options{}
+	}
+	return *s.opts
 }
 
 // Conn is a interface of gnet connection.
@@ -70,10 +101,17 @@ type Conn interface {
 	// SetContext sets a user-defined context.
 	SetContext(ctx interface{})
 
+	// Peer returns the metadata attached to the connection by the PeerTagger, nil if it is untagged.
+	Peer() (peer *Peer)
+
+	// Stats returns the statistics of the connection, the stats of a UDP connection are zero.
+	Stats() (stats ConnStats)
+
 	// LocalAddr is the connection's local socket address.
 	LocalAddr() (addr net.Addr)
 
-	// RemoteAddr is the connection's remote peer address.
+	// RemoteAddr is the connection's remote peer address, it is resolved once when the connection is opened,
+	// see AddrTuple for the raw 4-tuple.
 	RemoteAddr() (addr net.Addr)
 
 	// Read reads all data from inbound ring-buffer and event-loop-buffer without moving "read" pointer, which means
@@ -110,10 +148,92 @@ type Conn interface {
 	// instead of the event-loop goroutines.
 	AsyncWrite(buf []byte) error
 
+	// Writer returns a writer streaming data to the connection, which is safe to use from any goroutine.
+	// The data is written in chunks as is, bypassing the codec, and the writes block or fail with
+	// ErrWriterFull when too many bytes are pending on the connection, see StreamWriter.
+	Writer() (w io.Writer)
+
+	// Write implements io.Writer with the writer returned by Writer, so that io.Copy(c, r) takes ReadFrom.
+	Write(p []byte) (n int, err error)
+
+	// ReadFrom implements io.ReaderFrom, it writes the data from r to the connection through Writer and
+	// returns after the data has been handed to the event-loop, so it must not be invoked on the event-loop.
+	// Regular files are sent by sendfile(2) on the platforms with epoll/kqueue when the socket keeps up,
+	// invoke it directly with the file since io.Copy(c, f) may hide the file behind its WriteTo method.
+	ReadFrom(r io.Reader) (n int64, err error)
+
+	// WriteTo implements io.WriterTo, it writes the inbound data buffered by the connection to w and discards it,
+	// it is meant to be invoked in React.
+	WriteTo(w io.Writer) (n int64, err error)
+
+	// AsyncWriteWithPriority is like AsyncWrite but data with PriorityHigh jumps ahead of the normal data in the
+	// outbound buffer of the connection.
+	AsyncWriteWithPriority(buf []byte, priority WritePriority) error
+
+	// Upgrade replaces the codec of the connection, the inbound data after the current frame is decoded by the
+	// new codec and so is the outbound data encoded from then on, e.g. after a protocol upgrade. It must be invoked
+	// on the event-loop, typically in React.
+	Upgrade(codec ICodec)
+
+	// Detach removes the connection from the event-loop and returns it as a blocking net.Conn, which yields the
+	// unread inbound data first and writes the pending outbound data before any other data, so that it can be
+	// handed over to code that needs blocking IO while the other connections stay on the event-loop.
+	// It must be invoked on the event-loop, the output and action returned by the event handler along with
+	// the call are discarded and OnDetached does not fire. It fails with ErrProtocolNotSupported for UDP.
+	Detach() (net.Conn, error)
+
+	// SetTOS sets the IP_TOS (IPV6_TCLASS for IPv6) byte of the outbound packets of the connection, whose upper
+	// six bits are the DSCP, e.g. 0xb8 marks them as Expedited Forwarding. It must be invoked on the event-loop,
+	// for UDP it marks all the datagrams sent by the listener. It fails with ErrProtocolNotSupported on windows.
+	SetTOS(tos byte) error
+
+	// Tick registers fn to be invoked on the event-loop periodically with the given interval until the returned
+	// cancel function is invoked or the connection is closed, which is handy for keepalives and polling per session.
+	// Both Tick and cancel must be invoked on the event-loop, e.g. in OnOpened or React.
+	Tick(interval time.Duration, fn ConnTickFunc) (cancel func())
+
 	// Wake triggers a React event for this connection.
 	Wake() error
 
-	// Close closes the current connection.
+	// LoopIndex returns the index of the event-loop serving the connection, -1 for UDP.
+	LoopIndex() int
+
+	// Migrate moves the connection over to the idx-th event-loop, e.g. to rebalance the event-loops or to co-locate
+	// related sessions, see LoopIndex. It is asynchronous: the connection moves once the running callbacks of its
+	// event-loop have returned, the jobs queued for it by AsyncWrite, Wake and the like follow it to the other
+	// event-loop, and so do its tickers. It may be invoked from any goroutine, it fails with ErrInvalidLoopIndex
+	// for an unknown event-loop, and with ErrProtocolNotSupported for UDP and on Windows.
+	Migrate(idx int) error
+
+	// PauseRead stops reading the connection until ResumeRead, which gives the event handler inbound flow control,
+	// e.g. while a worker digests a huge request. It takes effect right away: the frames already read but not
+	// decoded yet are held back as well, so React does not fire for the connection in the meantime.
+	// Both PauseRead and ResumeRead may be invoked from any goroutine.
+	PauseRead() error
+
+	// ResumeRead resumes reading the connection paused by PauseRead and decodes the frames held back.
+	ResumeRead() error
+
+	// OriginalDst returns the address the client connected or sent a datagram to before it was intercepted by
+	// an interception proxy. It is the local address of the connection when the server is set up by WithTransparent
+	// for TPROXY, and it is retrieved by SO_ORIGINAL_DST from the connection tracking of netfilter otherwise,
+	// e.g. for REDIRECT or DNAT. It is only supported by the TCP connections and UDP datagrams of the epoll
+	// event-loops and must be invoked on the event-loop.
+	OriginalDst() (net.Addr, error)
+
+	// SyscallConn implements syscall.Conn for the socket options which gnet does not cover. The Control of
+	// the returned syscall.RawConn runs f on the event-loop with the connection taken off the poller and blocks
+	// until f has returned, so that getsockopt/setsockopt do not race the event-loop, hence it must not be
+	// invoked on the event-loop. Read and Write of it fail with ErrProtocolNotSupported since the IO belongs to
+	// the event-loop. It fails with ErrProtocolNotSupported for UDP.
+	SyscallConn() (syscall.RawConn, error)
+
+	// CloseReason tells why the connection has been closed, it is meant for OnClosed and returns CloseNone
+	// while the connection is open.
+	CloseReason() (reason CloseReason)
+
+	// Close closes the current connection. With the poll transport it jumps ahead of the AsyncWrites of the other
+	// connections queued on the event-loop, but not of the ones of this connection, which are written first.
 	Close() error
 }
 
@@ -126,6 +246,23 @@ type (
 		// The server parameter has information and various utilities.
 		OnInitComplete(server Server) (action Action)
 
+		// OnShutdown fires once when the server has shut down and every connection has been closed, or when it
+		// has failed to start after OnInitComplete. err is the cause: nil for a shutdown by the Shutdown action,
+		// GServer.Stop or GServer.SignalShutdown, otherwise the error which has brought the server down, e.g.
+		// a failure of accepting connections or polling, so that the application can restart or alert.
+		OnShutdown(server Server, err error)
+
+		// OnAccept fires when a new TCP or unix connection has been accepted, before it is registered with
+		// an event-loop or any buffer is allocated for it, so that rejecting it costs only closing the socket.
+		// fd is the socket of the connection, -1 if there is none such as for a pipe on Windows. It fires on
+		// the goroutine accepting the connections concurrently with the event-loops, so it must not block.
+		// Return Close to reject the connection, Shutdown to reject it and shut down the server.
+		OnAccept(addr net.Addr, fd int) (action Action)
+
+		// OnConfigure fires when a new connection has been opened, right before OnOpened, the settings it returns
+		// are applied to the connection before OnOpened fires and before anything is read from it.
+		OnConfigure(c Conn) (settings ConnSettings)
+
 		// OnOpened fires when a new connection has been opened.
 		// The info parameter has information about the connection such as
 		// it's local and remote address.
@@ -133,15 +270,44 @@ type (
 		OnOpened(c Conn) (out []byte, action Action)
 
 		// OnClosed fires when a connection has been closed.
-		// The err parameter is the last known connection error.
+		// The err parameter is the last known connection error, c.CloseReason tells why it has been closed.
 		OnClosed(c Conn, err error) (action Action)
 
+		// OnDraining fires for every connection when the server starts draining by GServer.Drain, or for a
+		// connection which has reached Options.ConnMaxLifetime, so that the event handler can send the peer
+		// a protocol-level GOAWAY or close frame by the out return value. Return Close to close the connection
+		// right away, Shutdown to shut down the server without waiting for the grace period, the other actions
+		// are ignored.
+		OnDraining(c Conn) (out []byte, action Action)
+
+		// OnSlowConsumer fires when the outbound data of a connection has been pending without being written
+		// for Options.SlowConsumer.Stall, stalled is how long it has been. It fires once per stall unless
+		// the policy is SlowConsumerClose, return Close to evict the peer.
+		OnSlowConsumer(c Conn, stalled time.Duration) (action Action)
+
+		// OnIdle fires when a connection has read nothing for Options.IdleReaper.Probe, idle is how long it has
+		// been, so that the event handler can send the peer an application-level ping by the out return value.
+		// The connection is closed with ErrIdleTimeout unless it reads something within IdleReaper.Timeout,
+		// return Close to close it right away.
+		OnIdle(c Conn, idle time.Duration) (out []byte, action Action)
+
+		// OnError fires when an ICMP error, e.g. a port unreachable or a TTL exceeded, is reported for the
+		// datagrams sent to the remote address of c by a UDP server set up by Options.ICMPErrors, err is
+		// an *ICMPError. Return Shutdown to shut down the server, the other actions are ignored.
+		OnError(c Conn, err error) (action Action)
+
+		// OnDetached fires when a connection has been detached from the event-loop by the Detach action,
+		// conn yields the unread inbound data first and writes the pending outbound data before any other data,
+		// it belongs to the event handler from now on.
+		OnDetached(c Conn, conn net.Conn) (action Action)
+
 		// PreWrite fires just before any data is written to any client socket.
 		PreWrite()
 
 		// React fires when a connection sends the server data.
 		// Invoke c.Read() or c.ReadN(n) within the parameter c to read incoming data from client/connection.
 		// Use the out return value to write data to the client/connection.
+		// The frame and the data read from c are only valid until React returns, use RetainFrame to keep them.
 		React(frame []byte, c Conn) (out []byte, action Action)
 
 		// Tick fires immediately after the server starts and will fire again
@@ -161,6 +327,23 @@ func (es *EventServer) OnInitComplete(svr Server) (action Action) {
 	return
 }
 
+// OnShutdown fires once when the server has shut down, err is the cause, nil for a normal shutdown.
+func (es *EventServer) OnShutdown(svr Server, err error) {
+}
+
+// OnAccept fires when a new TCP or unix connection has been accepted, before it is registered with
+// an event-loop or any buffer is allocated for it.
+// Return Close to reject the connection, Shutdown to reject it and shut down the server.
+func (es *EventServer) OnAccept(addr net.Addr, fd int) (action Action) {
+	return
+}
+
+// OnConfigure fires when a new connection has been opened, right before OnOpened.
+// Return the settings of the connection, the zero settings keep the ones of the server.
+func (es *EventServer) OnConfigure(c Conn) (settings ConnSettings) {
+	return
+}
+
 // OnOpened fires when a new connection has been opened.
 // The info parameter has information about the connection such as
 // it's local and remote address.
@@ -175,6 +358,38 @@ func (es *EventServer) OnClosed(c Conn, err error) (action Action) {
 	return
 }
 
+// OnDraining fires for every connection when the server starts draining by GServer.Drain, or for a connection
+// which has reached Options.ConnMaxLifetime.
+// Use the out return value to send the peer a GOAWAY or close frame, return Close to close the connection.
+func (es *EventServer) OnDraining(c Conn) (out []byte, action Action) {
+	return
+}
+
+// OnSlowConsumer fires when the outbound data of a connection has stalled for Options.SlowConsumer.Stall.
+// Return Close to evict the peer.
+func (es *EventServer) OnSlowConsumer(c Conn, stalled time.Duration) (action Action) {
+	return
+}
+
+// OnIdle fires when a connection has read nothing for Options.IdleReaper.Probe.
+// Use the out return value to send the peer a ping, return Close to close the connection.
+func (es *EventServer) OnIdle(c Conn, idle time.Duration) (out []byte, action Action) {
+	return
+}
+
+// OnError fires when an ICMP error is reported for the datagrams sent to the remote address of c,
+// see Options.ICMPErrors.
+func (es *EventServer) OnError(c Conn, err error) (action Action) {
+	return
+}
+
+// OnDetached fires when a connection has been detached from the event-loop by the Detach action,
+// conn yields the unread inbound data first and writes the pending outbound data before any other data,
+// it belongs to the event handler from now on.
+func (es *EventServer) OnDetached(c Conn, conn net.Conn) (action Action) {
+	return
+}
+
 // PreWrite fires just before any data is written to any client socket.
 func (es *EventServer) PreWrite() {
 }
@@ -192,20 +407,102 @@ func (es *EventServer) Tick() (delay time.Duration, action Action) {
 	return
 }
 
+// GServer is the handle of a server for controlling it programmatically, Serve of a zero GServer starts serving
+// without blocking, so does Start.
 type GServer struct {
 	s    *server
 	sdwg sync.WaitGroup
+
+	mu      sync.Mutex   // guards the fields below
+	serving bool         // set from Serve until WaitShutdown has cleaned up after the server
+	handler EventHandler // event handler of the last Serve, see Restart
+	addr    string       // address of the last Serve
+	opts    []Option     // options of the last Serve
+}
+
+// Start starts handling events for the specified address like Serve does, but returns the handle of the server
+// instead of blocking until the server is shut down.
+func Start(eventHandler EventHandler, addr string, opts ...Option) (*GServer, error) {
+	s := new(GServer)
+	if err := s.Serve(eventHandler, addr, opts...); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
+// SignalShutdown signals the server to shut down without waiting for it.
 func (s *GServer) SignalShutdown() {
 	if s.s != nil {
 		s.s.signalShutdown()
 	}
 }
 
+// WaitShutdown waits until the server has been shut down. Once it returns, the event-loops, their pollers and
+// the listener have been closed, so the server can be restarted by Restart or another server can serve on
+// the same address right away.
 func (s *GServer) WaitShutdown() {
 	s.sdwg.Wait()
-	s.closeListener(s.s.ln)
+	if s.s != nil {
+		s.closeListener(s.s.ln)
+	}
+	s.mu.Lock()
+	s.serving = false
+	s.mu.Unlock()
+}
+
+// Restart serves again with the event handler, the address and the options of the last Serve once the server
+// has been shut down, e.g. by Stop. The state of the previous run such as Stats starts over. It fails with
+// ErrServerRunning if the server is still serving, ErrServerNotStarted if it has never served.
+func (s *GServer) Restart() error {
+	s.mu.Lock()
+	serving, handler, addr, opts := s.serving, s.handler, s.addr, s.opts
+	s.mu.Unlock()
+	switch {
+	case serving:
+		return ErrServerRunning
+	case handler == nil:
+		return ErrServerNotStarted
+	}
+	return s.Serve(handler, addr, opts...)
+}
+
+// Stop shuts down the server and waits until every connection has been closed.
+func (s *GServer) Stop() {
+	if s.s == nil {
+		return
+	}
+	s.SignalShutdown()
+	s.WaitShutdown()
+}
+
+// Addr returns the address the server is listening on, which tells the port picked for port 0.
+func (s *GServer) Addr() net.Addr {
+	if s.s == nil || s.s.ln == nil {
+		return nil
+	}
+	return s.s.ln.lnaddr
+}
+
+// Options returns the effective configuration of the server with the defaults resolved, including the
+// tunables changed by SetTunables.
+func (s *GServer) Options() Options {
+	if s.s == nil {
+		return Options{}
+	}
+	opts := *s.s.opts
+	t := s.s.tunings()
+	opts.TCPKeepAlive, opts.WriteQuantum, opts.StreamWriter = t.TCPKeepAlive, t.WriteQuantum, t.StreamWriter
+	return opts
+}
+
+// ForEachConn invokes fn for every connection until fn returns false. The connections are visited on their
+// event-loops one event-loop after another, so fn may use them as the event handler does, e.g. to broadcast by
+// AsyncWrite or close idle connections, and must not block. ForEachConn waits until fn has visited every
+// connection, which is why it must not be invoked on an event-loop.
+func (s *GServer) ForEachConn(fn func(c Conn) bool) {
+	if s.s != nil {
+		s.s.forEachConn(fn)
+	}
 }
 
 func (s *GServer) closeListener(ln *listener) {
@@ -217,7 +514,8 @@ func (s *GServer) closeListener(ln *listener) {
 	}
 }
 
-// Serve starts handling events for the specified addresses.
+// Serve starts handling events for the specified addresses, it fails with ErrServerRunning if the GServer is
+// serving already, a GServer serves one server at a time.
 //
 // Addresses should use a scheme prefix and be formatted
 // like `tcp://192.168.0.10:9851` or `unix://socket`.
@@ -229,50 +527,86 @@ func (s *GServer) closeListener(ln *listener) {
 //  udp4  - IPv4
 //  udp6  - IPv6
 //  unix  - Unix Domain Socket
+//  pipe  - in-memory pipe, dialed by DialPipe
+//  winpipe - named pipe on Windows, `winpipe://name` serves on `\\.\pipe\name`, dialed by DialWinPipe
+//  vsock - VM socket on Linux, `vsock://cid:port` or `vsock://:port` for any CID, dialed by DialVsock
+//  packet - raw Ethernet frames of an interface on Linux, `packet://eth0` or `packet://any/0x88cc` for an EtherType
 //
 // The "tcp" network scheme is assumed when one is not specified.
-func (s *GServer) Serve(eventHandler EventHandler, addr string, opts ...Option) error {
+func (s *GServer) Serve(eventHandler EventHandler, addr string, opts ...Option) (err error) {
+	s.mu.Lock()
+	if s.serving {
+		s.mu.Unlock()
+		return ErrServerRunning
+	}
+	s.serving = true
+	s.mu.Unlock()
+	defer func() {
+		if err != nil {
+			s.mu.Lock()
+			s.serving = false
+			s.mu.Unlock()
+		}
+	}()
+
 	var ln listener
 
 	options := loadOptions(opts...)
 
 	ln.network, ln.addr = parseAddr(addr)
+	if err := options.validate(ln.network); err != nil {
+		return err
+	}
+	if err := options.validateHandler(eventHandler); err != nil {
+		return err
+	}
+	options.resolve()
+	if options.Expvar != "" {
+		if err := s.claimExpvar(options.Expvar); err != nil {
+			return err
+		}
+	}
 	if ln.network == "unix" {
 		sniffError(os.RemoveAll(ln.addr))
 		if runtime.GOOS == "windows" {
 			s.closeListener(&ln)
+			s.releaseExpvar(options.Expvar)
 			return ErrProtocolNotSupported
 		}
 	}
-	var err error
-	if ln.network == "udp" {
-		if options.ReusePort && runtime.GOOS != "windows" {
-			ln.pconn, err = netpoll.ReusePortListenPacket(ln.network, ln.addr)
-		} else {
-			ln.pconn, err = net.ListenPacket(ln.network, ln.addr)
-		}
-	} else {
-		if options.ReusePort && runtime.GOOS != "windows" {
-			ln.ln, err = netpoll.ReusePortListen(ln.network, ln.addr)
-		} else {
-			ln.ln, err = net.Listen(ln.network, ln.addr)
-		}
+	switch ln.network {
+	case "pipe":
+		err = ln.listenPipe()
+	case "winpipe":
+		err = ln.listenWinPipe()
+	case "vsock":
+		err = ln.listenVsock(options)
+	case "packet":
+		err = ln.listenPacket(options)
+	default:
+		err = ln.listen(options)
 	}
 	if err != nil {
 		s.closeListener(&ln)
+		s.releaseExpvar(options.Expvar)
 		return err
 	}
-	if ln.pconn != nil {
-		ln.lnaddr = ln.pconn.LocalAddr()
-	} else {
-		ln.lnaddr = ln.ln.Addr()
-	}
-	if err := ln.system(); err != nil {
+	if err = s.serve(eventHandler, &ln, options); err != nil {
 		s.closeListener(&ln)
+		s.releaseExpvar(options.Expvar)
 		return err
 	}
-	if err := s.serve(eventHandler, &ln, options); err != nil {
-		s.closeListener(&ln)
+	s.mu.Lock()
+	s.handler, s.addr, s.opts = eventHandler, addr, opts
+	s.mu.Unlock()
+	if options.Expvar != "" && s.s != nil {
+		s.bindExpvar(options.Expvar)
+	}
+	if options.DrainGrace > 0 && s.s != nil {
+		go s.drainOnSignal(options.DrainGrace)
+	}
+	if options.Rebalance.Interval > 0 && s.s != nil {
+		go s.rebalanceLoops(options.Rebalance)
 	}
 	return nil
 }
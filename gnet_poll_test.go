@@ -0,0 +1,59 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+// +build !gnet_net
+
+package gnet
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLoopFailure(t *testing.T) {
+	events := &testOnShutdownServer{causes: make(chan error, 1)}
+	gs, err := Start(events, "tcp://127.0.0.1:0", WithNumEventLoop(2))
+	must(err)
+	defer gs.Stop()
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	var opened bool
+	for deadline := time.Now().Add(3 * time.Second); !opened && time.Now().Before(deadline); {
+		gs.ForEachConn(func(Conn) bool {
+			opened = true
+			return false
+		})
+		time.Sleep(time.Millisecond)
+	}
+	if !opened {
+		t.Fatal("timed out waiting for the connection")
+	}
+
+	// Closing the poller of an event-loop from within makes it fail to wait for events.
+	el := gs.s.loopAt(1)
+	must(el.poller.Trigger(el.poller.Close))
+	select {
+	case err = <-events.causes:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for OnShutdown")
+	}
+	var le *LoopError
+	if !errors.As(err, &le) || le.Loop != 1 || !errors.Is(err, syscall.EBADF) {
+		t.Fatalf("expected the event-loop 1 to have died of EBADF, got %v", err)
+	}
+	// Every connection is closed, whichever event-loop it is on.
+	must(conn.SetReadDeadline(time.Now().Add(3 * time.Second)))
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the connection closed, got %v", err)
+	}
+	if _, err = net.Dial("tcp", gs.Addr().String()); err == nil {
+		t.Fatal("expected the listener closed")
+	}
+}
@@ -7,18 +7,35 @@ package gnet
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
+	"errors"
+	"expvar"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"math/big"
 	"math/rand"
 	"net"
 	"os"
+	"reflect"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/panlibin/gnet/netpoll"
 	"github.com/panlibin/gnet/pool/bytebuffer"
 	"github.com/panlibin/gnet/pool/goroutine"
 	"github.com/valyala/bytebufferpool"
@@ -213,6 +230,7 @@ var (
 
 func testCodecServe(network, addr string, multicore, async bool, nclients int, reuseport bool, codec ICodec) {
 	var err error
+	reuseport = reuseport && runtime.GOOS != "windows" // rejected on windows
 	fieldLength := fieldLengths[n]
 	if codec == nil {
 		encoderConfig := EncoderConfig{
@@ -487,6 +505,7 @@ func (s *testServer) Tick() (delay time.Duration, action Action) {
 }
 
 func testServe(network, addr string, reuseport, multicore, async bool, nclients int) {
+	reuseport = reuseport && runtime.GOOS != "windows" // rejected on windows
 	ts := &testServer{network: network, addr: addr, multicore: multicore, async: async, nclients: nclients, workerPool: goroutine.Default()}
 	must(Serve(ts, network+"://"+addr, WithMulticore(multicore), WithReusePort(reuseport), WithTicker(true), WithTCPKeepAlive(time.Minute*5)))
 }
@@ -960,3 +979,4036 @@ func testCloseConnection(network, addr string) {
 	events := &testCloseConnectionServer{network: network, addr: addr}
 	must(Serve(events, network+"://"+addr, WithTicker(true)))
 }
+
+// skipNetTransport skips the tests of the features which rely on the epoll/kqueue event-loops.
+func skipNetTransport(t *testing.T, feature string) {
+	if builtinTransport == TransportNet {
+		t.Skipf("%s only works with the epoll/kqueue event-loops", feature)
+	}
+}
+
+func TestTrafficShaping(t *testing.T) {
+	skipNetTransport(t, "TrafficShaping")
+	limit := BandwidthLimit{ReadRate: 32 * 1024, WriteRate: 32 * 1024}
+	t.Run("per-conn", func(t *testing.T) {
+		testTrafficShaping("tcp", ":9991", TrafficShaping{PerConn: limit}, 1)
+	})
+	// The connections share the limit, so two of them take as long as one sending all the data.
+	t.Run("per-ip", func(t *testing.T) {
+		testTrafficShaping("tcp", ":9991", TrafficShaping{PerIP: limit}, 2)
+	})
+	t.Run("per-listener", func(t *testing.T) {
+		testTrafficShaping("tcp", ":9991", TrafficShaping{PerListener: limit}, 2)
+	})
+}
+
+type testTrafficShapingServer struct {
+	*EventServer
+	network, addr string
+	conns         int
+	started       bool
+	closed        int
+	elapsed       chan time.Duration
+}
+
+func (t *testTrafficShapingServer) OnClosed(c Conn, err error) (action Action) {
+	if t.closed++; t.closed == t.conns {
+		action = Shutdown
+	}
+	return
+}
+func (t *testTrafficShapingServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+func (t *testTrafficShapingServer) Tick() (delay time.Duration, action Action) {
+	if !t.started {
+		t.started = true
+		for i := 0; i < t.conns; i++ {
+			go func() {
+				conn, err := net.Dial(t.network, t.addr)
+				must(err)
+				defer conn.Close()
+				start := time.Now()
+				data := make([]byte, 96*1024/t.conns)
+				go func() {
+					_, _ = conn.Write(data)
+				}()
+				_, err = io.ReadFull(conn, make([]byte, len(data)))
+				must(err)
+				t.elapsed <- time.Since(start)
+			}()
+		}
+	}
+	delay = time.Millisecond * 100
+	return
+}
+
+func testTrafficShaping(network, addr string, shaping TrafficShaping, conns int) {
+	events := &testTrafficShapingServer{network: network, addr: addr, conns: conns, elapsed: make(chan time.Duration, conns)}
+	must(Serve(events, network+"://"+addr, WithTicker(true), WithTrafficShaping(shaping)))
+	var elapsed time.Duration
+	for i := 0; i < conns; i++ {
+		if d := <-events.elapsed; d > elapsed {
+			elapsed = d
+		}
+	}
+	// The first 32KB passes within the burst, the rest is throttled to 32KB per second.
+	if elapsed < time.Second || elapsed > 10*time.Second {
+		panic(fmt.Sprintf("bad traffic shaping timing: %v", elapsed))
+	}
+}
+
+func TestLoadShedding(t *testing.T) {
+	testLoadShedding("tcp", ":9991")
+}
+
+type testLoadSheddingServer struct {
+	*EventServer
+	gs            *GServer
+	network, addr string
+	started       bool
+	rejected      int32
+	done          int32
+}
+
+func (t *testLoadSheddingServer) OnOpened(c Conn) (out []byte, action Action) {
+	panic("connection should have been shed")
+}
+func (t *testLoadSheddingServer) Tick() (delay time.Duration, action Action) {
+	if !t.started {
+		t.started = true
+		go func() {
+			// Wait for the memory sampler to mark the server as overloaded.
+			time.Sleep(time.Millisecond * 100)
+			conn, err := net.Dial(t.network, t.addr)
+			must(err)
+			defer conn.Close()
+			data, err := ioutil.ReadAll(conn)
+			must(err)
+			if string(data) != "overloaded" {
+				panic("bad reject payload: " + string(data))
+			}
+			atomic.StoreInt32(&t.done, 1)
+		}()
+	}
+	if atomic.LoadInt32(&t.done) == 1 {
+		if t.gs.Stats().ShedAccepts != 1 || atomic.LoadInt32(&t.rejected) != 1 {
+			panic("load shedding is not reported")
+		}
+		action = Shutdown
+	}
+	delay = time.Millisecond * 10
+	return
+}
+
+func testLoadShedding(network, addr string) {
+	events := &testLoadSheddingServer{gs: new(GServer), network: network, addr: addr}
+	ls := LoadShedding{
+		MaxMemory:           1,
+		MemoryCheckInterval: time.Millisecond * 10,
+		RejectPayload:       []byte("overloaded"),
+		OnShed: func(reason ShedReason, remoteAddr net.Addr, dropped bool) {
+			if reason != ShedMemory || dropped {
+				panic("bad shedding action")
+			}
+			atomic.AddInt32(&events.rejected, 1)
+		},
+	}
+	must(events.gs.Serve(events, network+"://"+addr, WithTicker(true), WithLoadShedding(ls)))
+	events.gs.WaitShutdown()
+}
+
+func TestFirewall(t *testing.T) {
+	testFirewall("tcp", ":9991")
+}
+
+type testFirewallServer struct {
+	*EventServer
+	gs            *GServer
+	fw            *Firewall
+	network, addr string
+	started       bool
+	done          int32
+}
+
+func (t *testFirewallServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+func (t *testFirewallServer) Tick() (delay time.Duration, action Action) {
+	if !t.started {
+		t.started = true
+		go func() {
+			conn, err := net.Dial(t.network, t.addr)
+			must(err)
+			data, err := ioutil.ReadAll(conn)
+			must(err)
+			if len(data) != 0 {
+				panic("denied connection should have been closed")
+			}
+			conn.Close()
+
+			// Lift the deny rule at runtime.
+			f, err := ioutil.TempFile("", "gnet-firewall")
+			must(err)
+			defer os.Remove(f.Name())
+			_, err = f.WriteString("# loopback only\nallow 127.0.0.0/8\nallow ::1\n")
+			must(err)
+			must(f.Close())
+			must(t.fw.LoadFile(f.Name()))
+
+			conn, err = net.Dial(t.network, t.addr)
+			must(err)
+			defer conn.Close()
+			_, err = conn.Write([]byte("PING"))
+			must(err)
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(conn, buf)
+			must(err)
+			if string(buf) != "PING" {
+				panic("bad echo: " + string(buf))
+			}
+			atomic.StoreInt32(&t.done, 1)
+		}()
+	}
+	if atomic.LoadInt32(&t.done) == 1 {
+		if t.gs.Stats().FirewallDenied != 1 {
+			panic("firewall denial is not reported")
+		}
+		action = Shutdown
+	}
+	delay = time.Millisecond * 10
+	return
+}
+
+func testFirewall(network, addr string) {
+	fw, err := NewFirewall(nil, []string{"127.0.0.1", "::1/128"})
+	must(err)
+	if fw.Allowed(net.ParseIP("127.0.0.1")) || !fw.Allowed(net.ParseIP("10.0.0.1")) {
+		panic("bad firewall rules")
+	}
+	if _, err = NewFirewall([]string{"10.0.0.0/33"}, nil); err == nil {
+		panic("invalid CIDR should be rejected")
+	}
+	events := &testFirewallServer{gs: new(GServer), fw: fw, network: network, addr: addr}
+	must(events.gs.Serve(events, network+"://"+addr, WithTicker(true), WithFirewall(fw)))
+	events.gs.WaitShutdown()
+}
+
+func TestPeerTagger(t *testing.T) {
+	testPeerTagger("tcp", ":9991")
+}
+
+type testPeerTaggerServer struct {
+	*EventServer
+	network, addr string
+	started       bool
+}
+
+func (t *testPeerTaggerServer) OnOpened(c Conn) (out []byte, action Action) {
+	if p := c.Peer(); p == nil || p.Country != "ZZ" || p.Labels["addr"] != c.RemoteAddr().String() {
+		panic("connection is not tagged")
+	}
+	return
+}
+func (t *testPeerTaggerServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+func (t *testPeerTaggerServer) Tick() (delay time.Duration, action Action) {
+	if !t.started {
+		t.started = true
+		go func() {
+			conn, err := net.Dial(t.network, t.addr)
+			must(err)
+			time.Sleep(time.Millisecond * 100)
+			conn.Close()
+		}()
+	}
+	delay = time.Millisecond * 10
+	return
+}
+
+func testPeerTagger(network, addr string) {
+	events := &testPeerTaggerServer{network: network, addr: addr}
+	tagger := func(remoteAddr net.Addr) *Peer {
+		return &Peer{Country: "ZZ", Labels: map[string]string{"addr": remoteAddr.String()}}
+	}
+	must(Serve(events, network+"://"+addr, WithTicker(true), WithPeerTagger(tagger)))
+}
+
+func TestTap(t *testing.T) {
+	testTap("tcp", ":9991")
+}
+
+type testTapServer struct {
+	*EventServer
+	network, addr string
+	started       bool
+}
+
+func (t *testTapServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+func (t *testTapServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+func (t *testTapServer) Tick() (delay time.Duration, action Action) {
+	if !t.started {
+		t.started = true
+		go func() {
+			conn, err := net.Dial(t.network, t.addr)
+			must(err)
+			defer conn.Close()
+			_, err = conn.Write([]byte("PING"))
+			must(err)
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(conn, buf)
+			must(err)
+		}()
+	}
+	delay = time.Millisecond * 10
+	return
+}
+
+func testTap(network, addr string) {
+	ring := NewTapRing(8)
+	var capture bytes.Buffer
+	pcap, err := NewPcapTapSink(&capture)
+	must(err)
+	sink := TapSinkFunc(func(rec *TapRecord) {
+		ring.WriteTap(rec)
+		pcap.WriteTap(rec)
+	})
+	events := &testTapServer{network: network, addr: addr}
+	must(Serve(events, network+"://"+addr, WithTicker(true), WithTap(Tap{Sink: sink})))
+
+	recs := ring.Records()
+	if len(recs) != 2 || recs[0].Direction != TapInbound || recs[1].Direction != TapOutbound ||
+		string(recs[0].Data) != "PING" || string(recs[1].Data) != "PING" || recs[1].PeerOffset != 4 {
+		panic("bad tap records")
+	}
+	must(pcap.Err())
+	// file header + 2 * (record header + IPv4/IPv6 header + TCP header + payload)
+	if n := capture.Len(); n != 24+2*(16+20+20+4) && n != 24+2*(16+40+20+4) {
+		panic("bad pcap capture")
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	testRecordReplay("tcp", ":9991")
+}
+
+type testRecordReplayServer struct {
+	*EventServer
+	network, addr string
+	started       bool
+}
+
+func (t *testRecordReplayServer) OnOpened(c Conn) (out []byte, action Action) {
+	c.SetContext(0)
+	out = []byte("HELLO")
+	return
+}
+func (t *testRecordReplayServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	n := c.Context().(int) + 1
+	c.SetContext(n)
+	out = []byte(fmt.Sprintf("%d:%s", n, frame))
+	if string(frame) == "BYE" {
+		action = Close
+	}
+	return
+}
+func (t *testRecordReplayServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+func (t *testRecordReplayServer) Tick() (delay time.Duration, action Action) {
+	if !t.started {
+		t.started = true
+		go func() {
+			conn, err := net.Dial(t.network, t.addr)
+			must(err)
+			defer conn.Close()
+			buf := make([]byte, 8)
+			_, err = io.ReadFull(conn, buf[:5])
+			must(err)
+			for _, msg := range []string{"PING", "BYE"} {
+				_, err = conn.Write([]byte(msg))
+				must(err)
+				_, err = io.ReadFull(conn, buf[:len(msg)+2])
+				must(err)
+			}
+		}()
+	}
+	delay = time.Millisecond * 10
+	return
+}
+
+func testRecordReplay(network, addr string) {
+	rec := NewRecorder(&testRecordReplayServer{network: network, addr: addr})
+	must(Serve(rec, network+"://"+addr, WithTicker(true)))
+
+	var buf bytes.Buffer
+	_, err := rec.WriteTo(&buf)
+	must(err)
+	events, err := ReadRecording(&buf)
+	must(err)
+	replayed, err := Replay(&testRecordReplayServer{network: network, addr: addr, started: true}, events)
+	must(err)
+
+	var reacts int
+	for i, ev := range events {
+		if ev.Kind == EventTick {
+			continue
+		}
+		if !bytes.Equal(ev.Out, replayed[i].Out) || ev.Action != replayed[i].Action {
+			panic(fmt.Sprintf("replayed %s event diverges: %q != %q", ev.Kind, replayed[i].Out, ev.Out))
+		}
+		if ev.Kind == EventReact {
+			reacts++
+		}
+	}
+	if reacts != 2 || string(events[len(events)-1].Out) != "" || events[len(events)-1].Kind != EventClosed {
+		panic("bad recording")
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	testFaultInjection("tcp", ":9991")
+}
+
+type testFaultInjectionServer struct {
+	*EventServer
+	network, addr string
+	started       bool
+	reacts        int32
+}
+
+func (t *testFaultInjectionServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	atomic.AddInt32(&t.reacts, 1)
+	out = frame
+	return
+}
+func (t *testFaultInjectionServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+func (t *testFaultInjectionServer) Tick() (delay time.Duration, action Action) {
+	if !t.started {
+		t.started = true
+		go func() {
+			conn, err := net.Dial(t.network, t.addr)
+			must(err)
+			defer conn.Close()
+			buf := make([]byte, 5)
+			start := time.Now()
+			_, err = conn.Write([]byte("HELLO"))
+			must(err)
+			_, err = io.ReadFull(conn, buf)
+			must(err)
+			if string(buf) != "HELLO" || time.Since(start) < time.Millisecond*20 {
+				panic("writes are not delayed")
+			}
+			if atomic.LoadInt32(&t.reacts) != 3 {
+				panic("reads are not split")
+			}
+			_, err = conn.Write([]byte("DROP"))
+			must(err)
+			time.Sleep(time.Millisecond * 50)
+			_, err = conn.Write([]byte("BYE"))
+			must(err)
+			_, err = io.ReadFull(conn, buf[:3])
+			must(err)
+			if string(buf[:3]) != "BYE" {
+				panic("reads are not dropped")
+			}
+		}()
+	}
+	delay = time.Millisecond * 10
+	return
+}
+
+func testFaultInjection(network, addr string) {
+	events := &testFaultInjectionServer{network: network, addr: addr}
+	policy := func(c Conn, op FaultOp, data []byte) (f Fault) {
+		switch {
+		case op == FaultRead && string(data) == "DROP":
+			f.Drop = true
+		case op == FaultRead:
+			f.Split = 2
+		case op == FaultWrite:
+			f.Delay = time.Millisecond * 20
+		}
+		return
+	}
+	must(Serve(events, network+"://"+addr, WithTicker(true), WithFaultInjection(policy)))
+}
+
+func TestPipe(t *testing.T) {
+	events := &testPipeServer{}
+	gs := new(GServer)
+	must(gs.Serve(events, "pipe://gnet-test"))
+	if err := new(GServer).Serve(events, "pipe://gnet-test"); err != ErrPipeInUse {
+		panic("pipe should be in use")
+	}
+	for i := 0; i < 10; i++ {
+		conn, err := DialPipe("gnet-test")
+		must(err)
+		_, err = conn.Write([]byte("PING"))
+		must(err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		must(err)
+		if string(buf) != "PING" {
+			panic("bad echo: " + string(buf))
+		}
+		must(conn.Close())
+	}
+	gs.SignalShutdown()
+	gs.WaitShutdown()
+	if _, err := DialPipe("gnet-test"); err != ErrPipeNotFound {
+		panic("pipe should have been closed")
+	}
+}
+
+type testPipeServer struct {
+	*EventServer
+}
+
+func (t *testPipeServer) OnOpened(c Conn) (out []byte, action Action) {
+	if c.RemoteAddr().String() != "gnet-test" {
+		panic("bad remote address: " + c.RemoteAddr().String())
+	}
+	return
+}
+func (t *testPipeServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func TestWinPipe(t *testing.T) {
+	events := &testWinPipeServer{}
+	gs := new(GServer)
+	if runtime.GOOS != "windows" {
+		if err := gs.Serve(events, "winpipe://gnet-test"); err != ErrProtocolNotSupported {
+			panic("named pipes should only be supported on windows")
+		}
+		if _, err := DialWinPipe("gnet-test", time.Second); err != ErrProtocolNotSupported {
+			panic("named pipes should only be supported on windows")
+		}
+		return
+	}
+	must(gs.Serve(events, "winpipe://gnet-test"))
+	if err := new(GServer).Serve(events, "winpipe://gnet-test"); err == nil {
+		panic("named pipe should be in use")
+	}
+	for i := 0; i < 10; i++ {
+		conn, err := DialWinPipe("gnet-test", time.Second)
+		must(err)
+		_, err = conn.Write([]byte("PING"))
+		must(err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		must(err)
+		if string(buf) != "PING" {
+			panic("bad echo: " + string(buf))
+		}
+		must(conn.Close())
+	}
+	gs.SignalShutdown()
+	gs.WaitShutdown()
+	if _, err := DialWinPipe("gnet-test", time.Second); err == nil {
+		panic("named pipe should have been closed")
+	}
+}
+
+type testWinPipeServer struct {
+	*EventServer
+}
+
+func (t *testWinPipeServer) OnOpened(c Conn) (out []byte, action Action) {
+	if c.RemoteAddr().String() != `\\.\pipe\gnet-test` {
+		panic("bad remote address: " + c.RemoteAddr().String())
+	}
+	return
+}
+func (t *testWinPipeServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func TestWritePriority(t *testing.T) {
+	skipNetTransport(t, "AsyncWriteWithPriority")
+	testWritePriority("tcp", ":9991")
+}
+
+type testWritePriorityServer struct {
+	*EventServer
+	network, addr string
+	started       bool
+}
+
+func (t *testWritePriorityServer) OnClosed(c Conn, err error) (action Action) {
+	action = Shutdown
+	return
+}
+func (t *testWritePriorityServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	// The bulk data is too large to be flushed at once, so the high-priority frame has to jump the queue.
+	_ = c.AsyncWrite(bytes.Repeat([]byte{'a'}, 16<<20))
+	_ = c.AsyncWrite(bytes.Repeat([]byte{'b'}, 16<<20))
+	_ = c.AsyncWriteWithPriority([]byte("PING!"), PriorityHigh)
+	return
+}
+func (t *testWritePriorityServer) Tick() (delay time.Duration, action Action) {
+	if !t.started {
+		t.started = true
+		go func() {
+			conn, err := net.Dial(t.network, t.addr)
+			must(err)
+			defer conn.Close()
+			_, err = conn.Write([]byte("go"))
+			must(err)
+			// Hold off reading so that the head frame can't be written out at once.
+			time.Sleep(time.Millisecond * 100)
+			expected := [][]byte{bytes.Repeat([]byte{'a'}, 16<<20), []byte("PING!"), bytes.Repeat([]byte{'b'}, 16<<20)}
+			for _, exp := range expected {
+				data := make([]byte, len(exp))
+				_, err = io.ReadFull(conn, data)
+				must(err)
+				if !bytes.Equal(data, exp) {
+					panic("high-priority frame is not written right after the head frame")
+				}
+			}
+		}()
+	}
+	delay = time.Millisecond * 100
+	return
+}
+
+func testWritePriority(network, addr string) {
+	events := &testWritePriorityServer{network: network, addr: addr}
+	must(Serve(events, network+"://"+addr, WithTicker(true), WithWriteQuantum(64*1024)))
+}
+
+type testChainMiddleware struct {
+	EventHandler
+	name  string
+	trace *[]string
+}
+
+func (m *testChainMiddleware) React(frame []byte, c Conn) (out []byte, action Action) {
+	*m.trace = append(*m.trace, m.name+">")
+	out, action = m.EventHandler.React(frame, c)
+	*m.trace = append(*m.trace, "<"+m.name)
+	return
+}
+
+type testChainServer struct {
+	*EventServer
+	trace *[]string
+}
+
+func (t *testChainServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	*t.trace = append(*t.trace, "handler")
+	return frame, None
+}
+
+func TestChain(t *testing.T) {
+	var trace []string
+	mw := func(name string) Middleware {
+		return func(next EventHandler) EventHandler {
+			return &testChainMiddleware{EventHandler: next, name: name, trace: &trace}
+		}
+	}
+	auth := Interceptor{
+		React: func(frame []byte, c Conn, next EventHandler) (out []byte, action Action) {
+			if c.Context() == nil {
+				return []byte("DENIED"), Close
+			}
+			return next.React(frame, c)
+		},
+	}
+	handler := Chain(&testChainServer{trace: &trace}, mw("a"), mw("b"), auth.Middleware())
+
+	c := new(memConn)
+	if out, action := handler.React([]byte("PING"), c); string(out) != "DENIED" || action != Close {
+		t.Fatalf("expected the interceptor to deny the frame, got %q, %v", out, action)
+	}
+	if _, action := handler.OnOpened(c); action != None {
+		t.Fatalf("expected OnOpened to pass through, got %v", action)
+	}
+	c.SetContext(true)
+	trace = nil
+	if out, _ := handler.React([]byte("PING"), c); string(out) != "PING" {
+		t.Fatalf("expected PING, got %q", out)
+	}
+	if expected := "a> b> handler <b <a"; strings.Join(trace, " ") != expected {
+		t.Fatalf("expected trace %q, got %q", expected, strings.Join(trace, " "))
+	}
+}
+
+func TestStreamWriter(t *testing.T) {
+	events := &testStreamWriterServer{done: make(chan error, 1)}
+	gs := new(GServer)
+	must(gs.Serve(events, "tcp://127.0.0.1:9992", WithStreamWriter(StreamWriter{HighWatermark: 64 * 1024, ChunkSize: 4096})))
+	conn, err := net.Dial("tcp", "127.0.0.1:9992")
+	must(err)
+	// Let the writer hit the high watermark before reading.
+	time.Sleep(time.Millisecond * 100)
+	data, err := ioutil.ReadAll(io.LimitReader(conn, 8<<20))
+	must(err)
+	if len(data) != 8<<20 {
+		t.Fatalf("expected %d bytes, got %d", 8<<20, len(data))
+	}
+	for i := range data {
+		if data[i] != byte(i%251) {
+			t.Fatalf("bad byte at %d", i)
+		}
+	}
+	must(<-events.done)
+	if events.maxPending > 64*1024+4096 {
+		t.Fatalf("pending bytes %d exceeded the high watermark", events.maxPending)
+	}
+	must(conn.Close())
+	gs.SignalShutdown()
+	gs.WaitShutdown()
+}
+
+type testStreamWriterServer struct {
+	*EventServer
+	done       chan error
+	maxPending int
+}
+
+func (t *testStreamWriterServer) OnOpened(c Conn) (out []byte, action Action) {
+	w := c.Writer().(*connWriter)
+	go func() {
+		buf := make([]byte, 8<<20)
+		for i := range buf {
+			buf[i] = byte(i % 251)
+		}
+		for len(buf) > 0 {
+			chunk := buf
+			if len(chunk) > 10000 {
+				chunk = chunk[:10000]
+			}
+			n, err := w.Write(chunk)
+			if err != nil {
+				t.done <- err
+				return
+			}
+			buf = buf[n:]
+			w.mu.Lock()
+			if pending := w.queued + w.buffered; pending > t.maxPending {
+				t.maxPending = pending
+			}
+			w.mu.Unlock()
+		}
+		t.done <- nil
+	}()
+	return
+}
+
+func TestReadFrom(t *testing.T) {
+	f, err := ioutil.TempFile("", "gnet-sendfile")
+	must(err)
+	defer os.Remove(f.Name())
+	data := make([]byte, 4<<20)
+	rand.Read(data)
+	_, err = f.Write(data)
+	must(err)
+
+	events := &testReadFromServer{file: f, done: make(chan error, 1)}
+	gs := new(GServer)
+	must(gs.Serve(events, "tcp://127.0.0.1:9993"))
+	conn, err := net.Dial("tcp", "127.0.0.1:9993")
+	must(err)
+	// Half of the file is sent, then the whole file along with a trailer.
+	expected := append(append(data[1<<20:3<<20:3<<20], data...), "EOF"...)
+	got, err := ioutil.ReadAll(io.LimitReader(conn, int64(len(expected))))
+	must(err)
+	must(<-events.done)
+	if !bytes.Equal(got, expected) {
+		t.Fatalf("expected %d bytes, got %d bytes which differ", len(expected), len(got))
+	}
+	must(conn.Close())
+	must(f.Close())
+	gs.SignalShutdown()
+	gs.WaitShutdown()
+}
+
+type testReadFromServer struct {
+	*EventServer
+	file *os.File
+	done chan error
+}
+
+func (t *testReadFromServer) OnOpened(c Conn) (out []byte, action Action) {
+	go func() {
+		t.done <- func() error {
+			if _, err := t.file.Seek(1<<20, io.SeekStart); err != nil {
+				return err
+			}
+			if n, err := io.Copy(c, io.LimitReader(t.file, 2<<20)); err != nil || n != 2<<20 {
+				return fmt.Errorf("copied %d bytes: %v", n, err)
+			}
+			if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			if _, err := c.ReadFrom(t.file); err != nil {
+				return err
+			}
+			_, err := io.Copy(c, strings.NewReader("EOF"))
+			return err
+		}()
+	}()
+	return
+}
+
+func TestConnTick(t *testing.T) {
+	events := new(testConnTickServer)
+	gs := new(GServer)
+	must(gs.Serve(events, "tcp://127.0.0.1:9994"))
+	conn, err := net.Dial("tcp", "127.0.0.1:9994")
+	must(err)
+	_, err = conn.Write([]byte("STOP"))
+	must(err)
+	data, err := ioutil.ReadAll(conn)
+	must(err)
+	if string(data) != "123" {
+		t.Fatalf("expected 123, got %q", data)
+	}
+	must(conn.Close())
+	gs.SignalShutdown()
+	gs.WaitShutdown()
+}
+
+type testConnTickServer struct {
+	*EventServer
+	cancel func()
+}
+
+func (t *testConnTickServer) OnOpened(c Conn) (out []byte, action Action) {
+	var n int
+	c.Tick(time.Millisecond*20, func(c Conn) (out []byte, action Action) {
+		n++
+		out = []byte(fmt.Sprint(n))
+		if n == 3 {
+			action = Close
+		}
+		return
+	})
+	t.cancel = c.Tick(time.Millisecond*30, func(c Conn) (out []byte, action Action) {
+		return []byte("X"), None
+	})
+	return
+}
+
+func (t *testConnTickServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "STOP" {
+		t.cancel()
+	}
+	return
+}
+
+func TestActions(t *testing.T) {
+	events := new(testActionsServer)
+	gs := new(GServer)
+	must(gs.Serve(events, "tcp://127.0.0.1:9993", WithCodec(new(LineBasedFrameCodec))))
+	conn, err := net.Dial("tcp", "127.0.0.1:9993")
+	must(err)
+	defer conn.Close()
+	expect := func(s string) {
+		buf := make([]byte, len(s))
+		_, err := io.ReadFull(conn, buf)
+		must(err)
+		if string(buf) != s {
+			t.Fatalf("expected %q, got %q", s, buf)
+		}
+	}
+
+	// The frames behind PAUSE are held back until the wake-up.
+	_, err = conn.Write([]byte("PAUSE\na\nb\n"))
+	must(err)
+	expect("paused\nwoke\na\nb\n")
+
+	// The frames behind UPGRADE are decoded by the new codec.
+	_, err = conn.Write([]byte("UPGRADE\nxyz"))
+	must(err)
+	expect("xyz")
+
+	// The data behind DET is read first from the detached connection.
+	_, err = conn.Write([]byte("DETrest"))
+	must(err)
+	expect("rest")
+	_, err = conn.Write([]byte("more"))
+	must(err)
+	expect("more")
+
+	gs.SignalShutdown()
+	gs.WaitShutdown()
+}
+
+type testActionsServer struct {
+	*EventServer
+}
+
+func (t *testActionsServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if frame == nil {
+		return []byte("woke"), None
+	}
+	switch string(frame) {
+	case "PAUSE":
+		go func() {
+			time.Sleep(time.Millisecond * 50)
+			_ = c.Wake()
+		}()
+		return []byte("paused"), Throttle
+	case "UPGRADE":
+		c.Upgrade(NewFixedLengthFrameCodec(3))
+		return
+	case "DET":
+		return nil, Detach
+	}
+	return append([]byte(nil), frame...), None
+}
+
+func (t *testActionsServer) OnDetached(c Conn, conn net.Conn) (action Action) {
+	go func() {
+		_, _ = io.Copy(conn, conn)
+		_ = conn.Close()
+	}()
+	return
+}
+
+func TestDetach(t *testing.T) {
+	events := new(testDetachServer)
+	gs := new(GServer)
+	must(gs.Serve(events, "tcp://127.0.0.1:9992", WithCodec(new(LineBasedFrameCodec))))
+	conn, err := net.Dial("tcp", "127.0.0.1:9992")
+	must(err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("a\nRAW\nb\n"))
+	must(err)
+	r := bufio.NewReader(conn)
+	for _, expect := range []string{"a\n", "b\n"} {
+		line, err := r.ReadString('\n')
+		must(err)
+		if line != expect {
+			t.Fatalf("expected %q, got %q", expect, line)
+		}
+	}
+	_, err = conn.Write([]byte("c\n"))
+	must(err)
+	line, err := r.ReadString('\n')
+	must(err)
+	if line != "raw c\n" {
+		t.Fatalf("expected %q, got %q", "raw c\n", line)
+	}
+	gs.SignalShutdown()
+	gs.WaitShutdown()
+}
+
+type testDetachServer struct {
+	*EventServer
+}
+
+func (t *testDetachServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) != "RAW" {
+		return append([]byte(nil), frame...), None
+	}
+	conn, err := c.Detach()
+	if err != nil {
+		return nil, Close
+	}
+	go func() {
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		// The frame behind RAW was left in the inbound buffer by the event-loop.
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte(line))
+		for {
+			if line, err = r.ReadString('\n'); err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("raw " + line))
+		}
+	}()
+	return []byte("discarded"), None
+}
+
+func TestStartTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	must(err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	must(err)
+	config := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+
+	events := &testStartTLSServer{config: config}
+	gs := new(GServer)
+	must(gs.Serve(events, "tcp://127.0.0.1:9991", WithCodec(new(LineBasedFrameCodec))))
+	conn, err := net.Dial("tcp", "127.0.0.1:9991")
+	must(err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("STARTTLS\n"))
+	must(err)
+	reply := make([]byte, 3)
+	_, err = io.ReadFull(conn, reply)
+	must(err)
+	if string(reply) != "OK\n" {
+		t.Fatalf("expected %q, got %q", "OK\n", reply)
+	}
+	tc := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	_, err = tc.Write([]byte("secret"))
+	must(err)
+	buf := make([]byte, 6)
+	_, err = io.ReadFull(tc, buf)
+	must(err)
+	if string(buf) != "secret" {
+		t.Fatalf("expected %q, got %q", "secret", buf)
+	}
+	gs.SignalShutdown()
+	gs.WaitShutdown()
+}
+
+type testStartTLSServer struct {
+	*EventServer
+	config *tls.Config
+}
+
+func (t *testStartTLSServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) != "STARTTLS" {
+		return nil, Close
+	}
+	tc, err := StartTLS(c, []byte("OK\n"), t.config)
+	if err != nil {
+		return nil, Close
+	}
+	go func() {
+		defer tc.Close()
+		_, _ = io.Copy(tc, tc)
+	}()
+	return
+}
+
+func TestRetainFrame(t *testing.T) {
+	data := []byte("frame")
+	f := RetainFrame(data)
+	data[0] = 'X'
+	if string(f.Bytes()) != "frame" || f.Len() != 5 {
+		t.Fatalf("expected a copy of the frame, got %q", f.Bytes())
+	}
+	f.Retain()
+	f.Release()
+	if string(f.Bytes()) != "frame" {
+		t.Fatalf("frame recycled while still retained")
+	}
+	f.Release()
+	for name, fn := range map[string]func(){
+		"Bytes":   func() { f.Bytes() },
+		"Retain":  func() { f.Retain() },
+		"Release": f.Release,
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%s of a released frame did not panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+func TestServerHandle(t *testing.T) {
+	events := &testServerHandleServer{opened: make(chan struct{}, 2)}
+	gs, err := Start(events, "tcp://127.0.0.1:0", WithNumEventLoop(2))
+	must(err)
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", gs.Addr().String())
+		must(err)
+		defer conn.Close()
+		conns = append(conns, conn)
+		<-events.opened
+	}
+
+	var n int
+	gs.ForEachConn(func(c Conn) bool {
+		n++
+		_ = c.AsyncWrite([]byte("hi"))
+		return true
+	})
+	if n != 2 {
+		t.Fatalf("expected 2 connections, visited %d", n)
+	}
+	for _, conn := range conns {
+		buf := make([]byte, 2)
+		_, err = io.ReadFull(conn, buf)
+		must(err)
+		if string(buf) != "hi" {
+			t.Fatalf("expected hi, got %q", buf)
+		}
+	}
+	n = 0
+	gs.ForEachConn(func(c Conn) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Fatalf("expected the iteration to stop after 1 connection, visited %d", n)
+	}
+	gs.Stop()
+}
+
+type testServerHandleServer struct {
+	*EventServer
+	opened chan struct{}
+}
+
+func (t *testServerHandleServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.opened <- struct{}{}
+	return
+}
+
+func TestOptionsValidation(t *testing.T) {
+	invalid := [][]Option{
+		{WithNumEventLoop(-1)},
+		{WithTCPKeepAlive(time.Millisecond)},
+		{WithTap(Tap{Sink: NewTapRing(1), SampleRate: 2})},
+		{WithFaultInjection(func(Conn, FaultOp, []byte) Fault { return Fault{} })},
+	}
+	for _, opts := range invalid {
+		err := Serve(new(EventServer), "udp://127.0.0.1:0", opts...)
+		if !errors.Is(err, ErrInvalidOptions) {
+			t.Fatalf("expected ErrInvalidOptions, got %v", err)
+		}
+	}
+	// The ticker of a handler without Tick would only ever run the no-op one of EventServer.
+	if err := Serve(new(testServerHandleServer), "tcp://127.0.0.1:0", WithTicker(true)); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions for a ticker without Tick, got %v", err)
+	}
+	if runtime.GOOS == "windows" {
+		err := Serve(new(EventServer), "tcp://127.0.0.1:0", WithMulticore(true), WithReusePort(true))
+		if !errors.Is(err, ErrInvalidOptions) {
+			t.Fatalf("expected ErrInvalidOptions for ReusePort on windows, got %v", err)
+		}
+	}
+
+	gs, err := Start(new(EventServer), "tcp://127.0.0.1:0", WithMulticore(true))
+	must(err)
+	defer gs.Stop()
+	opts := gs.Options()
+	if opts.NumEventLoop != runtime.NumCPU() {
+		t.Fatalf("expected %d event-loops, got %d", runtime.NumCPU(), opts.NumEventLoop)
+	}
+	if _, ok := opts.Codec.(*BuiltInFrameCodec); !ok || opts.Logger == nil {
+		t.Fatalf("expected the default codec and logger, got %T and %v", opts.Codec, opts.Logger)
+	}
+	if opts.StreamWriter.HighWatermark != defaultStreamHighWatermark {
+		t.Fatalf("expected the default high watermark, got %d", opts.StreamWriter.HighWatermark)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	other := TransportNet
+	if builtinTransport == TransportNet {
+		other = TransportPoll
+	}
+	for _, transport := range []string{other, "iouring"} {
+		if err := Serve(new(EventServer), "tcp://127.0.0.1:0", WithTransport(transport)); !errors.Is(err, ErrInvalidOptions) {
+			t.Fatalf("expected ErrInvalidOptions for the %s transport, got %v", transport, err)
+		}
+	}
+	for _, transport := range []string{"", builtinTransport} {
+		gs, err := Start(new(testClientEchoServer), "tcp://127.0.0.1:0", WithTransport(transport))
+		must(err)
+		if got := gs.Options().Transport; got != builtinTransport {
+			t.Fatalf("expected the %s transport, got %q", builtinTransport, got)
+		}
+		conn, err := net.Dial("tcp", gs.Addr().String())
+		must(err)
+		_, err = conn.Write([]byte("ping"))
+		must(err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		must(err)
+		must(conn.Close())
+		gs.Stop()
+	}
+}
+
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gnet-config")
+	must(err)
+	defer os.RemoveAll(dir)
+	yamlPath, jsonPath := dir+"/gnet.yaml", dir+"/gnet.json"
+	must(ioutil.WriteFile(yamlPath, []byte(`# gnet
+addr: "tcp://127.0.0.1:0"
+num_event_loop: 2
+tcp_keep_alive: 1m # one minute
+write_quantum: 4096
+`), 0644))
+	must(ioutil.WriteFile(jsonPath, []byte(`{"addr": "tcp://127.0.0.1:0", "num_event_loop": 2, "tcp_keep_alive": "1m", "write_quantum": 4096}`), 0644))
+	for _, path := range []string{yamlPath, jsonPath} {
+		cfg, err := LoadConfig(path)
+		must(err)
+		if cfg.Addr != "tcp://127.0.0.1:0" || cfg.NumEventLoop != 2 || cfg.TCPKeepAlive != time.Minute ||
+			cfg.WriteQuantum != 4096 {
+			t.Fatalf("unexpected config loaded from %s: %+v", path, cfg)
+		}
+	}
+	must(ioutil.WriteFile(jsonPath, []byte(`{"num_event_loops": 2}`), 0644))
+	if _, err = LoadConfig(jsonPath); err == nil {
+		t.Fatal("expected an error on an unknown key")
+	}
+
+	cfg, err := LoadConfig(yamlPath)
+	must(err)
+	must(os.Setenv("GNET_TEST_WRITE_QUANTUM", "8192"))
+	defer os.Unsetenv("GNET_TEST_WRITE_QUANTUM")
+	must(cfg.LoadEnv("GNET_TEST_"))
+	if cfg.WriteQuantum != 8192 {
+		t.Fatalf("expected the write quantum from the environment, got %d", cfg.WriteQuantum)
+	}
+
+	gs, err := Start(new(EventServer), cfg.Addr, cfg.Options()...)
+	must(err)
+	defer gs.Stop()
+	if opts := gs.Options(); opts.NumEventLoop != 2 || opts.WriteQuantum != 8192 {
+		t.Fatalf("unexpected options: %+v", opts)
+	}
+	cfg.WriteQuantum = 1024
+	must(gs.SetTunables(cfg.Tunables()))
+	if opts := gs.Options(); opts.WriteQuantum != 1024 || opts.TCPKeepAlive != time.Minute {
+		t.Fatalf("expected the tunables to be changed, got %+v", opts)
+	}
+	cfg.TCPKeepAlive = -time.Second
+	if err = gs.SetTunables(cfg.Tunables()); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions, got %v", err)
+	}
+}
+
+func TestWindowsSocketOptions(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		err := Serve(new(EventServer), "tcp://127.0.0.1:0", WithExclusiveAddrUse(true), WithLoopbackFastPath(true))
+		if !errors.Is(err, ErrInvalidOptions) {
+			t.Fatalf("expected ErrInvalidOptions, got %v", err)
+		}
+		return
+	}
+	if err := Serve(new(EventServer), "udp://127.0.0.1:0", WithLoopbackFastPath(true)); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions, got %v", err)
+	}
+	events := &testServerHandleServer{opened: make(chan struct{}, 1)}
+	gs, err := Start(events, "tcp://127.0.0.1:0", WithExclusiveAddrUse(true), WithLoopbackFastPath(true))
+	must(err)
+	defer gs.Stop()
+	if _, err = net.Listen("tcp", gs.Addr().String()); err == nil {
+		t.Fatal("expected the address to be used exclusively")
+	}
+	conn, err := NewLoopbackFastPathDialer().Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	<-events.opened
+}
+
+func TestBindToDevice(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_BINDTODEVICE is linux only")
+	}
+	if err := Serve(new(EventServer), "tcp://127.0.0.1:0", WithBindToDevice("gnet-none0")); err == nil {
+		t.Fatal("expected an error binding to a nonexistent device")
+	}
+	events := &testServerHandleServer{opened: make(chan struct{}, 1)}
+	gs, err := Start(events, "tcp://127.0.0.1:0", WithBindToDevice("lo"))
+	if err != nil && strings.Contains(err.Error(), "operation not permitted") {
+		t.Skip("binding to a device is not permitted")
+	}
+	must(err)
+	defer gs.Stop()
+	conn, err := NewDeviceDialer("lo").Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	<-events.opened
+}
+
+func TestMark(t *testing.T) {
+	cfg := new(Config)
+	must(cfg.set("mark", "0x10"))
+	if cfg.Mark != 0x10 {
+		t.Fatalf("expected the mark parsed from hex, got %d", cfg.Mark)
+	}
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_MARK is linux only")
+	}
+	skipNetTransport(t, "Mark")
+	events := &testServerHandleServer{opened: make(chan struct{}, 1)}
+	gs, err := Start(events, "tcp://127.0.0.1:0", WithMark(cfg.Mark))
+	if err != nil && strings.Contains(err.Error(), "operation not permitted") {
+		t.Skip("SO_MARK is not permitted")
+	}
+	must(err)
+	defer gs.Stop()
+	conn, err := NewMarkDialer(cfg.Mark).Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	<-events.opened
+}
+
+func TestTransparent(t *testing.T) {
+	skipNetTransport(t, "Transparent")
+	if runtime.GOOS != "linux" {
+		t.Skip("IP_FREEBIND and IP_TRANSPARENT are linux only")
+	}
+	// 192.0.2.0/24 is reserved for documentation and not configured on any interface.
+	if err := Serve(new(EventServer), "tcp://192.0.2.1:0"); err == nil {
+		t.Fatal("expected an error binding to an address not configured")
+	}
+	gs, err := Start(new(EventServer), "tcp://192.0.2.1:0", WithFreebind(true))
+	must(err)
+	gs.Stop()
+
+	events := &testTransparentServer{dst: make(chan net.Addr, 1)}
+	gs, err = Start(events, "udp://127.0.0.1:0", WithTransparent(true))
+	if err != nil && strings.Contains(err.Error(), "operation not permitted") {
+		t.Skip("IP_TRANSPARENT is not permitted")
+	}
+	must(err)
+	defer gs.Stop()
+	conn, err := net.Dial("udp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hi"))
+	must(err)
+	if dst := <-events.dst; dst.String() != gs.Addr().String() {
+		t.Fatalf("expected the original destination %v, got %v", gs.Addr(), dst)
+	}
+}
+
+type testTransparentServer struct {
+	*EventServer
+	dst chan net.Addr
+}
+
+func (t *testTransparentServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	dst, err := c.OriginalDst()
+	must(err)
+	t.dst <- dst
+	return
+}
+
+func TestIPStack(t *testing.T) {
+	events := &testIPStackServer{remote: make(chan net.Addr, 1)}
+	gs, err := Start(events, "tcp://:0")
+	if err != nil {
+		t.Skipf("dual-stack listening is not available: %v", err)
+	}
+	port := gs.Addr().(*net.TCPAddr).Port
+	conn, err := net.Dial("tcp4", fmt.Sprintf("127.0.0.1:%d", port))
+	must(err)
+	if remote := <-events.remote; len(remote.(*net.TCPAddr).IP) != net.IPv4len {
+		t.Fatalf("expected an IPv4 remote address, got %#v", remote)
+	}
+	conn.Close()
+	gs.Stop()
+
+	gs, err = Start(events, "tcp://:0", WithIPStack(IPv4Only))
+	must(err)
+	if ip := gs.Addr().(*net.TCPAddr).IP; ip.To4() == nil {
+		t.Fatalf("expected an IPv4 listener, got %v", ip)
+	}
+	gs.Stop()
+
+	gs, err = Start(events, "tcp://:0", WithIPStack(IPv6Only))
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+	defer gs.Stop()
+	port = gs.Addr().(*net.TCPAddr).Port
+	if conn, err = net.Dial("tcp4", fmt.Sprintf("127.0.0.1:%d", port)); err == nil {
+		conn.Close()
+		t.Fatal("expected an IPv6 only listener to refuse IPv4 connections")
+	}
+	if err = Serve(events, "unix://gnet-ipstack.sock", WithIPStack(IPv6Only)); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions, got %v", err)
+	}
+}
+
+type testIPStackServer struct {
+	*EventServer
+	remote chan net.Addr
+}
+
+func (t *testIPStackServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.remote <- c.RemoteAddr()
+	return
+}
+
+func TestSetTOS(t *testing.T) {
+	skipNetTransport(t, "TOS")
+	events := &testSetTOSServer{errs: make(chan error, 1)}
+	gs, err := Start(events, "tcp://127.0.0.1:0", WithTOS(0x20))
+	must(err)
+	defer gs.Stop()
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hi"))
+	must(err)
+	must(<-events.errs)
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(conn, buf)
+	must(err)
+}
+
+type testSetTOSServer struct {
+	*EventServer
+	errs chan error
+}
+
+func (t *testSetTOSServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	t.errs <- c.SetTOS(0xb8)
+	return frame, None
+}
+
+func TestOnAccept(t *testing.T) {
+	server := &testAcceptServer{opened: make(chan string, 1)}
+	gs, err := Start(server, "tcp://127.0.0.1:0")
+	must(err)
+	defer gs.Stop()
+
+	atomic.StoreInt32(&server.reject, 1)
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the connection to be rejected")
+	}
+	_ = conn.Close()
+
+	atomic.StoreInt32(&server.reject, 0)
+	conn, err = net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	if addr := <-server.opened; addr != conn.LocalAddr().String() {
+		t.Fatalf("expected the connection from %s, got %s", conn.LocalAddr(), addr)
+	}
+	if n := atomic.LoadInt32(&server.accepted); n != 2 {
+		t.Fatalf("expected 2 accepted connections, got %d", n)
+	}
+	if fd := atomic.LoadInt32(&server.fd); fd <= 0 {
+		t.Fatalf("expected the socket of the connection, got %d", fd)
+	}
+}
+
+type testAcceptServer struct {
+	*EventServer
+	reject, accepted, fd int32
+	opened               chan string
+}
+
+func (t *testAcceptServer) OnAccept(addr net.Addr, fd int) (action Action) {
+	atomic.AddInt32(&t.accepted, 1)
+	atomic.StoreInt32(&t.fd, int32(fd))
+	if atomic.LoadInt32(&t.reject) == 1 {
+		return Close
+	}
+	return None
+}
+
+func (t *testAcceptServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.opened <- c.RemoteAddr().String()
+	return
+}
+
+func TestAcceptBatch(t *testing.T) {
+	if _, err := Start(new(EventServer), "tcp://127.0.0.1:0", WithAcceptBatch(-1)); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions, got %v", err)
+	}
+	for _, reusePort := range []bool{false, true} {
+		server := &testAcceptServer{opened: make(chan string, 32)}
+		gs, err := Start(server, "tcp://127.0.0.1:0", WithAcceptBatch(8), WithReusePort(reusePort))
+		must(err)
+		if batch := gs.Options().AcceptBatch; batch != 8 {
+			t.Fatalf("expected an accept batch of 8, got %d", batch)
+		}
+		var conns []net.Conn
+		for i := 0; i < 32; i++ {
+			conn, err := net.Dial("tcp", gs.Addr().String())
+			must(err)
+			conns = append(conns, conn)
+		}
+		for range conns {
+			<-server.opened
+		}
+		stats := gs.Stats()
+		if stats.AcceptBacklog != 0 {
+			t.Fatalf("expected the accept queue to be drained, got %d", stats.AcceptBacklog)
+		}
+		if builtinTransport == TransportPoll && (stats.Accepted != 32 || stats.AcceptWakeups < 4 ||
+			stats.AcceptWakeups > 32 || stats.AcceptBatchFull > stats.AcceptWakeups) {
+			t.Fatalf("unexpected accept stats %+v", stats)
+		}
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+		gs.Stop()
+	}
+}
+
+func TestListenBacklog(t *testing.T) {
+	skipNetTransport(t, "ListenBacklog")
+	if _, err := Start(new(EventServer), "udp://127.0.0.1:0", WithListenBacklog(16)); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions, got %v", err)
+	}
+	gs, err := Start(new(EventServer), "tcp://127.0.0.1:0", WithListenBacklog(16))
+	must(err)
+	defer gs.Stop()
+	if backlog := gs.Options().ListenBacklog; backlog != 16 {
+		t.Fatalf("expected a backlog of 16, got %d", backlog)
+	}
+	if runtime.GOOS == "linux" {
+		if limit := gs.Stats().AcceptBacklogLimit; limit != 16 {
+			t.Fatalf("expected the accept queue limited to 16, got %d", limit)
+		}
+	}
+}
+
+type testAcceptorServer struct {
+	*EventServer
+	opened chan int
+}
+
+func (t *testAcceptorServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.opened <- c.LoopIndex()
+	return
+}
+
+func (t *testAcceptorServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = frame
+	return
+}
+
+func TestAcceptors(t *testing.T) {
+	skipNetTransport(t, "Acceptors")
+	for _, c := range []struct {
+		addr string
+		opts []Option
+	}{
+		{"tcp://127.0.0.1:0", []Option{WithAcceptors(-1)}},
+		{"tcp://127.0.0.1:0", []Option{WithAcceptors(2)}},
+		{"unix://gnet-acceptors.sock", []Option{WithAcceptors(2), WithReusePort(true)}},
+		{"udp://127.0.0.1:0", []Option{WithAcceptors(1)}},
+	} {
+		if _, err := Start(new(EventServer), c.addr, c.opts...); !errors.Is(err, ErrInvalidOptions) {
+			t.Fatalf("expected ErrInvalidOptions for %s, got %v", c.addr, err)
+		}
+	}
+	server := &testAcceptorServer{opened: make(chan int, 32)}
+	gs, err := Start(server, "tcp://127.0.0.1:0", WithAcceptors(3), WithReusePort(true), WithNumEventLoop(2),
+		WithLoopMetrics(true))
+	must(err)
+	defer gs.Stop()
+	var conns []net.Conn
+	for i := 0; i < 32; i++ {
+		conn, err := net.Dial("tcp", gs.Addr().String())
+		must(err)
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	for range conns {
+		// The connections are handed over to the event-loops rather than served by the acceptors.
+		if idx := <-server.opened; idx < 0 || idx > 1 {
+			t.Fatalf("expected the connection on one of the 2 event-loops, got %d", idx)
+		}
+	}
+	for _, conn := range conns {
+		_, err = conn.Write([]byte("ping"))
+		must(err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		must(err)
+		if string(buf) != "ping" {
+			t.Fatalf("expected the echo of ping, got %q", buf)
+		}
+	}
+	if accepted := gs.Stats().Accepted; accepted != 32 {
+		t.Fatalf("expected 32 connections accepted, got %d", accepted)
+	}
+	var indexes []int
+	for _, stats := range gs.LoopStats() {
+		indexes = append(indexes, stats.Index)
+	}
+	if fmt.Sprint(indexes) != "[0 1 -1 -2 -3]" {
+		t.Fatalf("expected 2 event-loops and 3 acceptors, got the loops %v", indexes)
+	}
+}
+
+func TestServeOnAccept(t *testing.T) {
+	skipNetTransport(t, "ServeOnAccept")
+	_, err := Start(new(EventServer), "tcp://127.0.0.1:0", WithServeOnAccept(true), WithAcceptors(1))
+	if !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions, got %v", err)
+	}
+	server := &testAcceptorServer{opened: make(chan int, 1)}
+	gs, err := Start(server, "tcp://127.0.0.1:0", WithServeOnAccept(true), WithNumEventLoop(1),
+		WithLoopMetrics(true))
+	must(err)
+	defer gs.Stop()
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	if idx := <-server.opened; idx != 0 {
+		t.Fatalf("expected the connection on event-loop 0, got %d", idx)
+	}
+	_, err = conn.Write([]byte("ping"))
+	must(err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	must(err)
+	if string(buf) != "ping" {
+		t.Fatalf("expected the echo of ping, got %q", buf)
+	}
+	// The event-loop accepts the connections itself, there is no main reactor.
+	if stats := gs.LoopStats(); len(stats) != 1 || stats[0].Index != 0 {
+		t.Fatalf("expected a single event-loop, got %+v", stats)
+	}
+}
+
+func TestHandoff(t *testing.T) {
+	_, err := Start(new(EventServer), "tcp://127.0.0.1:0", WithHandoff(Handoff{QueueSize: -1}))
+	if !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions, got %v", err)
+	}
+	for _, direct := range []bool{false, true} {
+		server := &testAcceptorServer{opened: make(chan int, 16)}
+		gs, err := Start(server, "tcp://127.0.0.1:0", WithHandoff(Handoff{Direct: direct, QueueSize: 1}),
+			WithNumEventLoop(2))
+		must(err)
+		if size := gs.Options().Handoff.QueueSize; size != 1 {
+			t.Fatalf("expected a queue size of 1, got %d", size)
+		}
+		var conns []net.Conn
+		for i := 0; i < 16; i++ {
+			conn, err := net.Dial("tcp", gs.Addr().String())
+			must(err)
+			conns = append(conns, conn)
+			// The data sent right away is read after the connection has been opened even if it is handed over
+			// directly.
+			_, err = conn.Write([]byte("ping"))
+			must(err)
+		}
+		for _, conn := range conns {
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(conn, buf)
+			must(err)
+			if string(buf) != "ping" {
+				t.Fatalf("expected the echo of ping, got %q", buf)
+			}
+			_ = conn.Close()
+		}
+		if n := len(server.opened); n != 16 {
+			t.Fatalf("expected 16 connections opened, got %d", n)
+		}
+		gs.Stop()
+	}
+}
+
+type testConfigureServer struct {
+	*EventServer
+	settings atomic.Value
+	closed   chan error
+}
+
+func (t *testConfigureServer) OnConfigure(c Conn) (settings ConnSettings) {
+	return t.settings.Load().(ConnSettings)
+}
+
+func (t *testConfigureServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	out = append([]byte("echo:"), frame...)
+	return
+}
+
+func (t *testConfigureServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- err
+	return
+}
+
+func TestConnSettings(t *testing.T) {
+	server := &testConfigureServer{closed: make(chan error, 1)}
+	server.settings.Store(ConnSettings{Codec: new(LineBasedFrameCodec)})
+	gs, err := Start(server, "tcp://127.0.0.1:0")
+	must(err)
+	defer gs.Stop()
+
+	// The codec of the settings decodes the very first bytes read from the connection.
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	_, err = conn.Write([]byte("a\nb\n"))
+	must(err)
+	buf := make([]byte, 14)
+	_, err = io.ReadFull(conn, buf)
+	must(err)
+	if string(buf) != "echo:a\necho:b\n" {
+		t.Fatalf("expected the lines echoed, got %q", buf)
+	}
+	_ = conn.Close()
+	<-server.closed
+
+	server.settings.Store(ConnSettings{Codec: new(LineBasedFrameCodec), MaxInbound: 4})
+	conn, err = net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("123456789"))
+	must(err)
+	if err = <-server.closed; err != ErrInboundTooLarge {
+		t.Fatalf("expected ErrInboundTooLarge, got %v", err)
+	}
+
+	if builtinTransport != TransportPoll {
+		return
+	}
+	server.settings.Store(ConnSettings{IdleTimeout: 50 * time.Millisecond})
+	conn, err = net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		time.Sleep(30 * time.Millisecond)
+		_, err = conn.Write([]byte("x"))
+		must(err)
+	}
+	if err = <-server.closed; err != ErrIdleTimeout {
+		t.Fatalf("expected ErrIdleTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 130*time.Millisecond {
+		t.Fatalf("expected the reads to keep the connection open, closed after %v", elapsed)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	server := &testDrainServer{opened: make(chan struct{}, 2), closed: make(chan struct{}, 2)}
+	gs, err := Start(server, "tcp://127.0.0.1:0")
+	must(err)
+	addr := gs.Addr().String()
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", addr)
+		must(err)
+		defer conn.Close()
+		conns = append(conns, conn)
+		<-server.opened
+	}
+	go func() {
+		// The first peer goes away as told, the second one stays until it is closed by the server.
+		buf := make([]byte, 6)
+		if _, err := io.ReadFull(conns[0], buf); err == nil && string(buf) == "goaway" {
+			_ = conns[0].Close()
+		}
+	}()
+
+	start := time.Now()
+	gs.Drain(100 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("expected the server to be shut down after the grace period, took %v", elapsed)
+	}
+	for i := 0; i < 2; i++ {
+		<-server.closed
+	}
+	buf := make([]byte, 16)
+	_ = conns[1].SetReadDeadline(time.Now().Add(time.Second))
+	if n, _ := io.ReadAtLeast(conns[1], buf, 6); string(buf[:n]) != "goaway" {
+		t.Fatalf("expected goaway, got %q", buf[:n])
+	}
+	if _, err = net.Dial("tcp", addr); err == nil {
+		t.Fatal("expected the listener to be closed")
+	}
+}
+
+type testDrainServer struct {
+	*EventServer
+	opened, closed chan struct{}
+}
+
+func (t *testDrainServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.opened <- struct{}{}
+	return
+}
+
+func (t *testDrainServer) OnDraining(c Conn) (out []byte, action Action) {
+	return []byte("goaway"), None
+}
+
+func (t *testDrainServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- struct{}{}
+	return
+}
+
+func TestConnStats(t *testing.T) {
+	server := &testConnStatsServer{stats: make(chan ConnStats, 2)}
+	gs, err := Start(server, "tcp://127.0.0.1:0")
+	must(err)
+	defer gs.Stop()
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	buf := make([]byte, 8)
+	for _, data := range []string{"first", "second"} {
+		_, err = conn.Write([]byte(data))
+		must(err)
+		_, err = io.ReadFull(conn, buf[:len(data)])
+		must(err)
+	}
+	<-server.stats
+	stats := <-server.stats
+	if stats.BytesRead != 11 || stats.BytesWritten != 5 || stats.FramesDecoded != 2 {
+		t.Fatalf("expected 11 bytes read, 5 bytes written and 2 frames, got %+v", stats)
+	}
+	if stats.InboundBuffered != 0 || stats.OutboundBuffered != 0 {
+		t.Fatalf("expected nothing buffered, got %+v", stats)
+	}
+	if stats.CreatedAt.IsZero() || stats.LastActivity.Before(stats.CreatedAt) {
+		t.Fatalf("expected the activity after the creation, got %+v", stats)
+	}
+}
+
+type testConnStatsServer struct {
+	*EventServer
+	stats chan ConnStats
+}
+
+func (t *testConnStatsServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	t.stats <- c.Stats()
+	return frame, None
+}
+
+func TestLoopStats(t *testing.T) {
+	gs, err := Start(new(testLoopStatsServer), "tcp://127.0.0.1:0", WithLoopMetrics(true), WithNumEventLoop(2))
+	must(err)
+	defer gs.Stop()
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	must(err)
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	must(err)
+	var jobs, events int64
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		stats := gs.LoopStats()
+		if len(stats) != 3 && builtinTransport == TransportPoll {
+			t.Fatalf("expected 2 event-loops and the main reactor, got %d", len(stats))
+		}
+		jobs, events = 0, 0
+		for _, ls := range stats {
+			jobs += ls.JobLatency.Count
+			events += ls.EventLatency.Count
+			if q := ls.EventLatency.Quantile(1); q > ls.EventLatency.Max {
+				t.Fatalf("expected the quantile no more than the max %d, got %d", ls.EventLatency.Max, q)
+			}
+		}
+		if jobs > 0 || builtinTransport == TransportNet {
+			break
+		}
+	}
+	if (jobs == 0 || events == 0) && builtinTransport == TransportPoll {
+		t.Fatalf("expected the jobs and events recorded, got %d jobs and %d events", jobs, events)
+	}
+
+	gs2, err := Start(new(EventServer), "tcp://127.0.0.1:0")
+	must(err)
+	defer gs2.Stop()
+	if stats := gs2.LoopStats(); stats != nil {
+		t.Fatalf("expected no loop stats without LoopMetrics, got %v", stats)
+	}
+}
+
+type testLoopStatsServer struct {
+	*EventServer
+}
+
+func (t *testLoopStatsServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	_ = c.Wake()
+	return frame, None
+}
+
+func TestExpvar(t *testing.T) {
+	gs, err := Start(new(EventServer), "tcp://127.0.0.1:0", WithExpvar("gnet_test"))
+	must(err)
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	v := expvar.Get("gnet_test.connections")
+	if v == nil {
+		t.Fatal("expected gnet_test.connections to be published")
+	}
+	for start := time.Now(); v.String() != "1"; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("expected 1 connection, got %s", v)
+		}
+	}
+	if _, err = Start(new(EventServer), "tcp://127.0.0.1:0", WithExpvar("gnet_test")); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions for the prefix taken, got %v", err)
+	}
+	gs.Stop()
+	if v.String() != "null" {
+		t.Fatalf("expected null after the server has stopped, got %s", v)
+	}
+	gs, err = Start(new(EventServer), "tcp://127.0.0.1:0", WithExpvar("gnet_test"))
+	must(err)
+	defer gs.Stop()
+	if v.String() != "0" {
+		t.Fatalf("expected no connections of the new server, got %s", v)
+	}
+}
+
+func TestSlowConsumer(t *testing.T) {
+	skipNetTransport(t, "SlowConsumer")
+	server := &testSlowConsumerServer{stalled: make(chan time.Duration, 1), closed: make(chan error, 1)}
+	gs, err := Start(server, "tcp://127.0.0.1:0", WithSlowConsumer(SlowConsumer{Stall: 100 * time.Millisecond}))
+	must(err)
+	defer gs.Stop()
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	// The client never reads the flood so that it stalls once the socket buffers are full.
+	select {
+	case stalled := <-server.stalled:
+		if stalled < 100*time.Millisecond {
+			t.Fatalf("expected a stall of at least 100ms, got %v", stalled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnSlowConsumer")
+	}
+	if err := <-server.closed; err != nil {
+		t.Fatalf("expected the connection closed by the Close action, got %v", err)
+	}
+	if n := gs.Stats().SlowConsumers; n != 1 {
+		t.Fatalf("expected 1 slow consumer, got %d", n)
+	}
+
+	_, err = Start(server, "tcp://127.0.0.1:0", WithSlowConsumer(SlowConsumer{Stall: time.Second, Policy: 3}))
+	if !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions for an unknown policy, got %v", err)
+	}
+}
+
+type testSlowConsumerServer struct {
+	*EventServer
+	stalled chan time.Duration
+	closed  chan error
+}
+
+func (t *testSlowConsumerServer) OnOpened(c Conn) (out []byte, action Action) {
+	return make([]byte, 32<<20), None
+}
+
+func (t *testSlowConsumerServer) OnSlowConsumer(c Conn, stalled time.Duration) (action Action) {
+	t.stalled <- stalled
+	return Close
+}
+
+func (t *testSlowConsumerServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- err
+	return
+}
+
+func TestPauseRead(t *testing.T) {
+	server := &testPauseReadServer{conns: make(chan Conn, 1), frames: make(chan string, 4)}
+	gs, err := Start(server, "tcp://127.0.0.1:0", WithCodec(new(LineBasedFrameCodec)))
+	must(err)
+	defer gs.Stop()
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	c := <-server.conns
+	_, err = conn.Write([]byte("pause\nfirst\n"))
+	must(err)
+	if frame := <-server.frames; frame != "pause" {
+		t.Fatalf("expected the pause frame, got %q", frame)
+	}
+	_, err = conn.Write([]byte("second\n"))
+	must(err)
+	select {
+	case frame := <-server.frames:
+		t.Fatalf("expected no frames while reading is paused, got %q", frame)
+	case <-time.After(100 * time.Millisecond):
+	}
+	must(c.ResumeRead())
+	for _, expected := range []string{"first", "second"} {
+		select {
+		case frame := <-server.frames:
+			if frame != expected {
+				t.Fatalf("expected %q, got %q", expected, frame)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", expected)
+		}
+	}
+}
+
+type testPauseReadServer struct {
+	*EventServer
+	conns  chan Conn
+	frames chan string
+}
+
+func (t *testPauseReadServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.conns <- c
+	return
+}
+
+func (t *testPauseReadServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "pause" {
+		must(c.PauseRead())
+	}
+	t.frames <- string(frame)
+	return
+}
+
+func TestPauseReadReset(t *testing.T) {
+	skipNetTransport(t, "The hang-ups of the connections not read")
+	server := &testPausedResetServer{closed: make(chan error, 1)}
+	gs, err := Start(server, "tcp://127.0.0.1:0")
+	must(err)
+	defer gs.Stop()
+	testPausedReset(t, gs, nil, server.closed)
+}
+
+type testPausedResetServer struct {
+	*EventServer
+	closed chan error
+}
+
+func (t *testPausedResetServer) OnOpened(c Conn) (out []byte, action Action) {
+	must(c.PauseRead())
+	return
+}
+
+func (t *testPausedResetServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- err
+	return
+}
+
+func TestThrottleReset(t *testing.T) {
+	skipNetTransport(t, "The hang-ups of the connections not read")
+	server := &testThrottleResetServer{closed: make(chan error, 1)}
+	gs, err := Start(server, "tcp://127.0.0.1:0")
+	must(err)
+	defer gs.Stop()
+	testPausedReset(t, gs, []byte("throttle"), server.closed)
+}
+
+type testThrottleResetServer struct {
+	*EventServer
+	closed chan error
+}
+
+func (t *testThrottleResetServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	return nil, Throttle
+}
+
+func (t *testThrottleResetServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- err
+	return
+}
+
+// testPausedReset resets a connection whose reading the server has paused once it has received data, and checks
+// that the server closes it rather than spinning on the hang-up reported by the poller over and over.
+func testPausedReset(t *testing.T, gs *GServer, data []byte, closed chan error) {
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	if data != nil {
+		_, err = conn.Write(data)
+		must(err)
+		time.Sleep(50 * time.Millisecond)
+	}
+	must(conn.(*net.TCPConn).SetLinger(0))
+	must(conn.Close())
+	select {
+	case err = <-closed:
+		if !errors.Is(err, syscall.ECONNRESET) {
+			t.Fatalf("expected the connection closed by the reset, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for OnClosed of the connection reset while it was not read")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	skipNetTransport(t, "Migrate")
+	server := &testMigrateServer{conns: make(chan Conn, 1), loops: make(chan int, 4)}
+	gs, err := Start(server, "tcp://127.0.0.1:0", WithNumEventLoop(2))
+	must(err)
+	defer gs.Stop()
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	c := <-server.conns
+	from := c.LoopIndex()
+	if err = c.Migrate(2); err != ErrInvalidLoopIndex {
+		t.Fatalf("expected ErrInvalidLoopIndex, got %v", err)
+	}
+	to := 1 - from
+	must(c.Migrate(to))
+	for start := time.Now(); c.LoopIndex() != to; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("timed out migrating from event-loop %d to %d", from, to)
+		}
+	}
+	must(c.AsyncWrite([]byte("moved")))
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	must(err)
+	if string(buf) != "moved" {
+		t.Fatalf("expected the async write after the migration, got %q", buf)
+	}
+	_, err = conn.Write([]byte("ping"))
+	must(err)
+	_, err = io.ReadFull(conn, buf[:4])
+	must(err)
+	if idx := <-server.loops; idx != to {
+		t.Fatalf("expected the frame handled by event-loop %d, got %d", to, idx)
+	}
+}
+
+type testMigrateServer struct {
+	*EventServer
+	conns chan Conn
+	loops chan int
+}
+
+func (t *testMigrateServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.conns <- c
+	return
+}
+
+func (t *testMigrateServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	select {
+	case t.loops <- c.LoopIndex():
+	default:
+	}
+	return frame, None
+}
+
+func TestRebalance(t *testing.T) {
+	skipNetTransport(t, "Rebalance")
+	rb := Rebalance{Threshold: 0.25, MaxMoves: 4}
+	loads := [][]connLoad{{{load: 50}, {load: 30}, {load: 20}}, {{load: 10}}, nil}
+	moves := planMoves(loads, rb)
+	if len(moves) != 2 || moves[0].to != 2 || moves[1].to != 1 {
+		t.Fatalf("expected 2 moves to event-loops 2 and 1, got %+v", moves)
+	}
+	if moves := planMoves([][]connLoad{{{load: 100}}, nil}, rb); len(moves) != 0 {
+		t.Fatalf("expected no move of the only heavy connection, got %+v", moves)
+	}
+
+	server := &testMigrateServer{conns: make(chan Conn, 2), loops: make(chan int, 1)}
+	gs, err := Start(server, "tcp://127.0.0.1:0", WithNumEventLoop(2),
+		WithRebalance(Rebalance{Interval: 50 * time.Millisecond}))
+	must(err)
+	defer gs.Stop()
+	var clients []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", gs.Addr().String())
+		must(err)
+		defer conn.Close()
+		clients = append(clients, conn)
+		// Both connections are piled up on event-loop 0.
+		must((<-server.conns).Migrate(0))
+	}
+	buf := make([]byte, 1024)
+	for start := time.Now(); gs.Stats().Rebalanced == 0; {
+		if time.Since(start) > 3*time.Second {
+			t.Fatal("timed out waiting for the rebalancer")
+		}
+		for _, conn := range clients {
+			_, err = conn.Write(buf)
+			must(err)
+			_, err = io.ReadFull(conn, buf)
+			must(err)
+		}
+	}
+}
+
+func TestBusyPoll(t *testing.T) {
+	skipNetTransport(t, "BusyPoll")
+	gs, err := Start(new(testClientEchoServer), "tcp://127.0.0.1:0",
+		WithBusyPoll(BusyPoll{Budget: 200 * time.Microsecond}))
+	must(err)
+	defer gs.Stop()
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	buf := make([]byte, 4)
+	for i := 0; i < 10; i++ {
+		_, err = conn.Write([]byte("ping"))
+		must(err)
+		_, err = io.ReadFull(conn, buf)
+		must(err)
+		if string(buf) != "ping" {
+			t.Fatalf("expected the echo, got %q", buf)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err = Start(new(EventServer), "tcp://127.0.0.1:0", WithBusyPoll(BusyPoll{Budget: -1})); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions for a negative budget, got %v", err)
+	}
+}
+
+func TestIdleStrategy(t *testing.T) {
+	skipNetTransport(t, "Idle")
+	gs, err := Start(new(testClientEchoServer), "tcp://127.0.0.1:0", WithIdleStrategy(IdleStrategy{
+		Spin:    100 * time.Microsecond,
+		Yield:   time.Millisecond,
+		Sleep:   5 * time.Millisecond,
+		MaxWait: 20 * time.Millisecond,
+	}))
+	must(err)
+	defer gs.Stop()
+	if d := gs.Options().Idle.SleepInterval; d != defaultIdleSleepInterval {
+		t.Fatalf("expected the default sleep interval, got %v", d)
+	}
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	buf := make([]byte, 4)
+	// The pauses let the event-loop back off to every stage before the ping arrives.
+	for _, pause := range []time.Duration{0, 500 * time.Microsecond, 3 * time.Millisecond, 30 * time.Millisecond} {
+		time.Sleep(pause)
+		_, err = conn.Write([]byte("ping"))
+		must(err)
+		_, err = io.ReadFull(conn, buf)
+		must(err)
+		if string(buf) != "ping" {
+			t.Fatalf("expected the echo after a pause of %v, got %q", pause, buf)
+		}
+	}
+	if _, err = Start(new(EventServer), "tcp://127.0.0.1:0", WithIdleStrategy(IdleStrategy{Yield: -1})); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions for a negative stage, got %v", err)
+	}
+}
+
+func TestSyscallConn(t *testing.T) {
+	server := &testMigrateServer{conns: make(chan Conn, 1), loops: make(chan int, 1)}
+	gs, err := Start(server, "tcp://127.0.0.1:0")
+	must(err)
+	defer gs.Stop()
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	rc, err := (<-server.conns).SyscallConn()
+	must(err)
+	var controlled uintptr
+	must(rc.Control(func(fd uintptr) {
+		controlled = fd
+	}))
+	if controlled == 0 {
+		t.Fatal("expected Control to run with the socket")
+	}
+	if err = rc.Read(func(uintptr) bool { return true }); err != ErrProtocolNotSupported {
+		t.Fatalf("expected ErrProtocolNotSupported for Read, got %v", err)
+	}
+	// The connection is back on the poller after Control.
+	_, err = conn.Write([]byte("ping"))
+	must(err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	must(err)
+	if string(buf) != "ping" {
+		t.Fatalf("expected the echo after Control, got %q", buf)
+	}
+}
+
+func TestSocketOptions(t *testing.T) {
+	var listenerFd, connFds int32
+	gs, err := Start(new(testClientEchoServer), "tcp://127.0.0.1:0",
+		WithListenerSocketOptions(func(fd int) error {
+			atomic.StoreInt32(&listenerFd, int32(fd))
+			return nil
+		}),
+		WithConnSocketOptions(func(fd int) error {
+			if atomic.AddInt32(&connFds, 1) > 1 {
+				return errors.New("rejected")
+			}
+			return nil
+		}))
+	must(err)
+	defer gs.Stop()
+	if atomic.LoadInt32(&listenerFd) <= 0 {
+		t.Fatal("expected the listener hook to run with the socket")
+	}
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	must(err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	must(err)
+	// The second connection fails to be set up and is closed.
+	rejected, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer rejected.Close()
+	must(rejected.SetReadDeadline(time.Now().Add(time.Second)))
+	if _, err = rejected.Read(buf); err != io.EOF {
+		t.Fatalf("expected the rejected connection to be closed, got %v", err)
+	}
+
+	failed := errors.New("unsupported option")
+	if _, err = Start(new(EventServer), "tcp://127.0.0.1:0", WithListenerSocketOptions(func(int) error {
+		return failed
+	})); !errors.Is(err, failed) {
+		t.Fatalf("expected the error of the listener hook, got %v", err)
+	}
+}
+
+func TestUDPNAT(t *testing.T) {
+	skipNetTransport(t, "UDPNAT")
+	upstream, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	must(err)
+	defer upstream.Close()
+	peers := make(chan string, 4)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, addr, err := upstream.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			peers <- addr.String()
+			_, _ = upstream.WriteToUDP(append([]byte("pong:"), buf[:n]...), addr)
+		}
+	}()
+	events := &testUDPNATServer{upstream: upstream.LocalAddr().(*net.UDPAddr)}
+	gs, err := Start(events, "udp://127.0.0.1:0", WithUDPNAT(UDPNAT{
+		IdleTimeout: 100 * time.Millisecond,
+		Reply: func(client, upstream *net.UDPAddr, reply []byte) []byte {
+			return append([]byte("r:"), reply...)
+		},
+	}))
+	must(err)
+	defer gs.Stop()
+	conn, err := net.Dial("udp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	buf := make([]byte, 64)
+	for _, msg := range []string{"a", "b"} {
+		_, err = conn.Write([]byte(msg))
+		must(err)
+		must(conn.SetReadDeadline(time.Now().Add(time.Second)))
+		n, err := conn.Read(buf)
+		must(err)
+		if string(buf[:n]) != "r:pong:"+msg {
+			t.Fatalf("expected the rewritten reply, got %q", buf[:n])
+		}
+	}
+	if first, second := <-peers, <-peers; first != second {
+		t.Fatalf("expected the datagrams from the same session, got %s and %s", first, second)
+	}
+	if n := gs.Stats().NATSessions; n != 1 {
+		t.Fatalf("expected 1 session, got %d", n)
+	}
+	for start := time.Now(); gs.Stats().NATSessions != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("timed out waiting for the idle session to expire")
+		}
+	}
+	if _, err = Start(new(EventServer), "tcp://127.0.0.1:0", WithUDPNAT(UDPNAT{IdleTimeout: time.Second})); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions for tcp, got %v", err)
+	}
+}
+
+type testUDPNATServer struct {
+	*EventServer
+	upstream *net.UDPAddr
+}
+
+func (t *testUDPNATServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	must(ForwardUDP(c, t.upstream, frame))
+	return
+}
+
+func TestSTUN(t *testing.T) {
+	gs, err := Start(new(testSTUNServer), "udp://127.0.0.1:0")
+	must(err)
+	defer gs.Stop()
+	conn, err := net.Dial("udp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	var txns STUNTransactions
+	req := NewSTUNRequest(STUNBinding)
+	txns.Start(req, time.Second)
+	_, err = conn.Write(req.Marshal())
+	must(err)
+	buf := make([]byte, 512)
+	must(conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := conn.Read(buf)
+	must(err)
+	resp, err := ParseSTUN(buf[:n])
+	must(err)
+	if matched, ok := txns.Match(resp); !ok || matched != req || resp.Class != STUNSuccess {
+		t.Fatalf("expected a success response matching the request, got %+v", resp)
+	}
+	addr, err := resp.XORMappedAddress()
+	must(err)
+	if addr.String() != conn.LocalAddr().String() {
+		t.Fatalf("expected the mapped address %v, got %v", conn.LocalAddr(), addr)
+	}
+	if _, err = resp.Response(STUNSuccess).XORMappedAddress(); err != ErrSTUNAttrNotFound {
+		t.Fatalf("expected ErrSTUNAttrNotFound, got %v", err)
+	}
+
+	m := NewSTUNRequest(STUNBinding)
+	m.AddXORMappedAddress(&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 3478})
+	m.Add(STUNAttrSoftware, []byte("gnet"))
+	if m, err = ParseSTUN(m.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if addr, err = m.XORMappedAddress(); err != nil || addr.String() != "[2001:db8::1]:3478" {
+		t.Fatalf("expected the IPv6 address to round-trip, got %v, %v", addr, err)
+	}
+	if software, _ := m.Get(STUNAttrSoftware); string(software) != "gnet" {
+		t.Fatalf("expected the padded attribute to round-trip, got %q", software)
+	}
+	if _, err = ParseSTUN([]byte("GET / HTTP/1.1\r\nHost: x\r\n")); err != ErrMalformedSTUN {
+		t.Fatalf("expected ErrMalformedSTUN, got %v", err)
+	}
+	txns.Start(m, 0)
+	if expired := txns.Expire(time.Now().Add(time.Millisecond)); len(expired) != 1 || txns.Len() != 0 {
+		t.Fatalf("expected the request to expire, got %d", len(expired))
+	}
+}
+
+type testSTUNServer struct {
+	*EventServer
+}
+
+func (t *testSTUNServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	req, err := ParseSTUN(frame)
+	if err != nil || req.Class != STUNRequest || req.Method != STUNBinding {
+		return
+	}
+	resp := req.Response(STUNSuccess)
+	resp.AddXORMappedAddress(c.RemoteAddr().(*net.UDPAddr))
+	return resp.Marshal(), None
+}
+
+func TestECN(t *testing.T) {
+	if ECNCE.String() != "CE" || ECNECT1.String() != "ECT(1)" {
+		t.Fatalf("unexpected names of the codepoints: %v, %v", ECNCE, ECNECT1)
+	}
+	if runtime.GOOS != "linux" {
+		t.Skip("ECN is linux only")
+	}
+	skipNetTransport(t, "ECN")
+	events := &testECNServer{ecn: make(chan error, 1)}
+	gs, err := Start(events, "udp://127.0.0.1:0", WithECN(true))
+	must(err)
+	defer gs.Stop()
+	conn, err := net.Dial("udp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	must(err)
+	must(<-events.ecn)
+	buf := make([]byte, 16)
+	must(conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := conn.Read(buf)
+	must(err)
+	if string(buf[:n]) != "ping" {
+		t.Fatalf("expected the datagram sent with ECT(0), got %q", buf[:n])
+	}
+	if _, err = Start(new(EventServer), "tcp://127.0.0.1:0", WithECN(true)); !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions for tcp, got %v", err)
+	}
+}
+
+type testECNServer struct {
+	*EventServer
+	ecn chan error
+}
+
+func (t *testECNServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	// The datagrams of the client are not ECN-capable.
+	ecn, err := DatagramECN(c)
+	if err == nil && ecn != ECNNotECT {
+		err = fmt.Errorf("expected Not-ECT, got %v", ecn)
+	}
+	if err == nil {
+		err = SendToECN(c, frame, ECNECT0)
+	}
+	t.ecn <- err
+	return
+}
+
+func TestICMPErrors(t *testing.T) {
+	skipNetTransport(t, "ICMPErrors")
+	if runtime.GOOS != "linux" {
+		t.Skip("IP_RECVERR is linux only")
+	}
+	events := &testICMPServer{
+		received: make(chan struct{}),
+		proceed:  make(chan struct{}),
+		errs:     make(chan error, 1),
+		peers:    make(chan string, 1),
+	}
+	gs, err := Start(events, "udp://127.0.0.1:0", WithICMPErrors(true))
+	must(err)
+	defer gs.Stop()
+	conn, err := net.Dial("udp", gs.Addr().String())
+	must(err)
+	_, err = conn.Write([]byte("ping"))
+	must(err)
+	<-events.received
+	// The reply goes to a closed port.
+	addr := conn.LocalAddr().String()
+	must(conn.Close())
+	close(events.proceed)
+	select {
+	case err = <-events.errs:
+		if !errors.Is(err, syscall.ECONNREFUSED) {
+			t.Fatalf("expected a port unreachable, got %v", err)
+		}
+		if icmpErr := err.(*ICMPError); icmpErr.Type != 3 || icmpErr.Code != 3 || <-events.peers != addr {
+			t.Fatalf("unexpected ICMP error: %+v", icmpErr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the ICMP error")
+	}
+}
+
+type testICMPServer struct {
+	*EventServer
+	received, proceed chan struct{}
+	errs              chan error
+	peers             chan string
+}
+
+func (t *testICMPServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	close(t.received)
+	<-t.proceed
+	return frame, None
+}
+
+func (t *testICMPServer) OnError(c Conn, err error) (action Action) {
+	t.peers <- c.RemoteAddr().String()
+	t.errs <- err
+	return
+}
+
+func TestAddrTuple(t *testing.T) {
+	events := &testAddrTupleServer{tuples: make(chan []byte, 1), allocs: make(chan float64, 1)}
+	gs, err := Start(events, "udp://127.0.0.1:0")
+	must(err)
+	defer gs.Stop()
+	conn, err := net.Dial("udp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	must(err)
+	tuple := <-events.tuples
+	if allocs := <-events.allocs; allocs != 0 && builtinTransport == TransportPoll {
+		t.Fatalf("expected the accessors not to allocate, got %v allocations", allocs)
+	}
+	local, remote := gs.Addr().(*net.UDPAddr), conn.LocalAddr().(*net.UDPAddr)
+	expected := make([]byte, 0, AddrTupleLen)
+	for _, addr := range []*net.UDPAddr{local, remote} {
+		expected = append(expected, addr.IP.To16()...)
+		expected = append(expected, byte(addr.Port>>8), byte(addr.Port))
+	}
+	if !bytes.Equal(tuple, expected) {
+		t.Fatalf("expected the 4-tuple %x, got %x", expected, tuple)
+	}
+	if AddrTuple(&memConn{localAddr: &net.UnixAddr{Name: "sock", Net: "unix"}}) != nil {
+		t.Fatal("expected no 4-tuple for unix addresses")
+	}
+}
+
+type testAddrTupleServer struct {
+	*EventServer
+	tuples chan []byte
+	allocs chan float64
+}
+
+func (t *testAddrTupleServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	t.allocs <- testing.AllocsPerRun(100, func() {
+		_, _ = c.LocalAddr(), c.RemoteAddr()
+		_ = AddrTuple(c)
+	})
+	t.tuples <- append([]byte(nil), AddrTuple(c)...)
+	return
+}
+
+func TestInterner(t *testing.T) {
+	events := &testInternServer{in: NewInterner(), tenants: make(chan string, 2), closed: make(chan struct{}, 2)}
+	gs, err := Start(events, "tcp://127.0.0.1:0")
+	must(err)
+	defer gs.Stop()
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", gs.Addr().String())
+		must(err)
+		conns = append(conns, conn)
+	}
+	a, b := <-events.tenants, <-events.tenants
+	if a != "tenant" || b != "tenant" {
+		t.Fatalf("unexpected tenants %q and %q", a, b)
+	}
+	if n := events.in.Len(); n != 1 {
+		t.Fatalf("expected the connections to share 1 interned string, got %d", n)
+	}
+	for _, conn := range conns {
+		must(conn.Close())
+		<-events.closed
+	}
+	if n := events.in.Len(); n != 0 {
+		t.Fatalf("expected the strings to be released with the connections, %d left", n)
+	}
+}
+
+type testInternServer struct {
+	*EventServer
+	in      *Interner
+	tenants chan string
+	closed  chan struct{}
+}
+
+func (t *testInternServer) OnOpened(c Conn) (out []byte, action Action) {
+	tenant := t.in.Attach(c, []byte("tenant"))
+	c.SetContext(tenant)
+	t.tenants <- tenant
+	return
+}
+
+func (t *testInternServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- struct{}{}
+	return
+}
+
+func TestQuotas(t *testing.T) {
+	skipNetTransport(t, "Quotas")
+	events := &testQuotaServer{tenants: make(chan string, 1), closed: make(chan error, 1)}
+	gs, err := Start(events, "tcp://127.0.0.1:0", WithQuotas(Quotas{
+		Tenant: func(c Conn, frame []byte) (string, bool) {
+			return ClientHelloServerName(frame)
+		},
+		Tenants: map[string]TenantQuota{"a.example": {MaxConns: 1}},
+	}))
+	must(err)
+	defer gs.Stop()
+	hello := func() net.Conn {
+		conn, err := net.Dial("tcp", gs.Addr().String())
+		must(err)
+		// The handshake stalls since the server never answers, the ClientHello is all it needs.
+		go func() {
+			_ = tls.Client(conn, &tls.Config{ServerName: "a.example", InsecureSkipVerify: true}).Handshake()
+		}()
+		return conn
+	}
+	first := hello()
+	defer first.Close()
+	if tenant := <-events.tenants; tenant != "a.example" {
+		t.Fatalf("expected the tenant from the SNI, got %q", tenant)
+	}
+	second := hello()
+	defer second.Close()
+	if err = <-events.closed; err != ErrQuotaExceeded {
+		t.Fatalf("expected the second connection to be closed with ErrQuotaExceeded, got %v", err)
+	}
+	if stats := gs.TenantStats()["a.example"]; stats.Conns != 1 || stats.Rejected != 1 {
+		t.Fatalf("unexpected stats of the tenant: %+v", stats)
+	}
+}
+
+type testQuotaServer struct {
+	*EventServer
+	tenants chan string
+	closed  chan error
+}
+
+func (t *testQuotaServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	t.tenants <- Tenant(c)
+	return
+}
+
+func (t *testQuotaServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- err
+	return
+}
+
+func TestOnShutdown(t *testing.T) {
+	events := &testOnShutdownServer{causes: make(chan error, 2)}
+	gs, err := Start(events, "tcp://127.0.0.1:0")
+	must(err)
+	gs.Stop()
+	if err = <-events.causes; err != nil {
+		t.Fatalf("expected no cause of a normal shutdown, got %v", err)
+	}
+
+	gs, err = Start(events, "tcp://127.0.0.1:0")
+	must(err)
+	boom := errors.New("boom")
+	gs.s.fail(boom)
+	gs.SignalShutdown()
+	gs.WaitShutdown()
+	if err = <-events.causes; err != boom {
+		t.Fatalf("expected the cause to be reported, got %v", err)
+	}
+	if len(events.causes) != 0 {
+		t.Fatal("expected OnShutdown to fire once per server")
+	}
+}
+
+type testOnShutdownServer struct {
+	*EventServer
+	causes chan error
+}
+
+func (t *testOnShutdownServer) OnShutdown(server Server, err error) {
+	t.causes <- err
+}
+
+type testRestartServer struct {
+	testClientEchoServer
+}
+
+func (t *testRestartServer) Tick() (delay time.Duration, action Action) {
+	return time.Millisecond * 10, None
+}
+
+func TestRestart(t *testing.T) {
+	echo := func(gs *GServer) {
+		conn, err := net.Dial("tcp", gs.Addr().String())
+		must(err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("ping"))
+		must(err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		must(err)
+	}
+	gs, err := Start(new(testRestartServer), "tcp://127.0.0.1:0", WithTicker(true))
+	must(err)
+	if err = gs.Restart(); err != ErrServerRunning {
+		t.Fatalf("expected ErrServerRunning while serving, got %v", err)
+	}
+	// Serving again would leave the running server out of the reach of Stop.
+	if err = gs.Serve(new(testClientEchoServer), "tcp://127.0.0.1:0"); err != ErrServerRunning {
+		t.Fatalf("expected ErrServerRunning when serving twice, got %v", err)
+	}
+	echo(gs)
+	addr := gs.Addr().String()
+	gs.Stop()
+	// Another server takes the address over right away.
+	other, err := Start(new(testClientEchoServer), "tcp://"+addr)
+	must(err)
+	echo(other)
+	other.Stop()
+	for i := 0; i < 3; i++ {
+		must(gs.Restart())
+		echo(gs)
+		gs.Stop()
+	}
+	if err = new(GServer).Restart(); err != ErrServerNotStarted {
+		t.Fatalf("expected ErrServerNotStarted, got %v", err)
+	}
+
+	// A failed Serve leaves the GServer free to serve, only one of concurrent Serves wins.
+	gs = new(GServer)
+	if err = gs.Serve(new(testClientEchoServer), "tcp://127.0.0.1:0", WithNumEventLoop(-1)); err == nil {
+		t.Fatal("expected the invalid options to fail")
+	}
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		go func() { errs <- gs.Serve(new(testClientEchoServer), "tcp://127.0.0.1:0") }()
+	}
+	var served int
+	for i := 0; i < cap(errs); i++ {
+		switch err := <-errs; err {
+		case nil:
+			served++
+		case ErrServerRunning:
+		default:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if served != 1 {
+		t.Fatalf("expected a single Serve to succeed, %d did", served)
+	}
+	echo(gs)
+	gs.Stop()
+}
+
+func TestClientReconnect(t *testing.T) {
+	gs, err := Start(new(testClientEchoServer), "tcp://127.0.0.1:0")
+	must(err)
+	addr := gs.Addr().String()
+	frames := make(chan string, 4)
+	disconnected, reconnected := make(chan error, 4), make(chan int, 4)
+	client, err := DialClient(ClientConfig{
+		Addr:        "tcp://" + addr,
+		Reconnect:   ReconnectPolicy{InitialBackoff: time.Millisecond, MaxBackoff: 20 * time.Millisecond, Jitter: 0.5, MaxAttempts: 20},
+		WriteBuffer: 16,
+		OnFrame: func(c *Client, frame []byte) {
+			frames <- string(frame)
+		},
+		OnDisconnect: func(c *Client, err error) {
+			disconnected <- err
+		},
+		OnReconnect: func(c *Client, attempts int) {
+			reconnected <- attempts
+		},
+	})
+	must(err)
+	defer client.Close()
+	must(client.Write([]byte("hi")))
+	if frame := <-frames; frame != "hi" {
+		t.Fatalf("expected hi, got %q", frame)
+	}
+
+	gs.Stop()
+	<-disconnected
+	must(client.Write([]byte("buffered")))
+	if err = client.Write([]byte("beyond the write buffer")); err != ErrClientDisconnected {
+		t.Fatalf("expected ErrClientDisconnected, got %v", err)
+	}
+	gs, err = Start(new(testClientEchoServer), "tcp://"+addr)
+	must(err)
+	if attempts := <-reconnected; attempts < 1 {
+		t.Fatalf("expected at least 1 attempt, got %d", attempts)
+	}
+	if frame := <-frames; frame != "buffered" {
+		t.Fatalf("expected the buffered frame, got %q", frame)
+	}
+
+	gs.Stop()
+	<-disconnected
+	<-client.Done()
+	if err = client.Write([]byte("hi")); !errors.Is(err, ErrReconnectFailed) {
+		t.Fatalf("expected ErrReconnectFailed, got %v", err)
+	}
+}
+
+func TestClientFailover(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	must(err)
+	primary := ln.Addr().String()
+	_ = ln.Close()
+	backup, err := Start(new(testClientEchoServer), "tcp://127.0.0.1:0")
+	must(err)
+	defer backup.Stop()
+	frames, reconnected := make(chan string, 4), make(chan int, 4)
+	client, err := DialClient(ClientConfig{
+		Targets: []ClientTarget{
+			{Addr: "tcp://" + primary, Weight: 1},
+			{Addr: "tcp://" + backup.Addr().String()},
+		},
+		TargetCooldown: 20 * time.Millisecond,
+		Rebalance:      true,
+		OnFrame: func(c *Client, frame []byte) {
+			frames <- string(frame)
+		},
+		OnReconnect: func(c *Client, attempts int) {
+			reconnected <- attempts
+		},
+	})
+	must(err)
+	defer client.Close()
+	if states := client.Targets(); states[0].Healthy || states[0].Connected || !states[1].Connected {
+		t.Fatalf("expected the backup target to be connected, got %+v", states)
+	}
+
+	gs, err := Start(new(testClientEchoServer), "tcp://"+primary)
+	must(err)
+	defer gs.Stop()
+	if attempts := <-reconnected; attempts != 0 {
+		t.Fatalf("expected a rebalance, got %d attempts", attempts)
+	}
+	if states := client.Targets(); !states[0].Connected || states[1].Connected {
+		t.Fatalf("expected the primary target to be connected, got %+v", states)
+	}
+	must(client.Write([]byte("hi")))
+	if frame := <-frames; frame != "hi" {
+		t.Fatalf("expected hi, got %q", frame)
+	}
+	if client.MarkTarget("tcp://unknown", true) {
+		t.Fatal("expected an unknown target not to be marked")
+	}
+}
+
+type testClientEchoServer struct {
+	*EventServer
+}
+
+func (t *testClientEchoServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	return frame, None
+}
+
+func TestMuxSession(t *testing.T) {
+	config := MuxConfig{StreamWindow: 64 * 1024, MaxFrameSize: 8 * 1024}
+	server := &testMuxServer{config: config, closed: make(chan uint32, 4)}
+	gs, err := Start(server, "tcp://127.0.0.1:0", WithCodec(NewMuxCodec()))
+	must(err)
+	defer gs.Stop()
+
+	echoed := &testMuxClient{received: make(map[uint32][]byte), done: make(chan uint32, 4), size: 200 * 1024}
+	ready := make(chan struct{})
+	var client *Client
+	config.Client = true
+	session := NewMuxSession(func(data []byte) error {
+		<-ready
+		return client.Write(data)
+	}, echoed, config)
+	client, err = DialClient(ClientConfig{
+		Addr:  "tcp://" + gs.Addr().String(),
+		Codec: NewMuxCodec(),
+		OnFrame: func(c *Client, frame []byte) {
+			must(session.Handle(frame))
+		},
+	})
+	must(err)
+	close(ready)
+	defer client.Close()
+
+	data := make([]byte, echoed.size)
+	_, _ = rand.Read(data)
+	streams := make(map[uint32]*Stream)
+	for i := 0; i < 3; i++ {
+		s, err := session.OpenStream()
+		must(err)
+		must(s.Write(data))
+		streams[s.ID()] = s
+	}
+	for i := 0; i < 3; i++ {
+		id := <-echoed.done
+		echoed.mu.Lock()
+		got := echoed.received[id]
+		echoed.mu.Unlock()
+		if !bytes.Equal(got, data) {
+			t.Fatalf("stream %d echoed %d bytes, expected %d bytes", id, len(got), len(data))
+		}
+		must(streams[id].Close())
+		if closed := <-server.closed; closed != id {
+			t.Fatalf("expected stream %d to be closed on the server, got %d", id, closed)
+		}
+	}
+	if n := session.NumStreams(); n != 0 {
+		t.Fatalf("expected no streams left, got %d", n)
+	}
+}
+
+type testMuxServer struct {
+	*EventServer
+	config MuxConfig
+	closed chan uint32
+}
+
+func (t *testMuxServer) OnOpened(c Conn) (out []byte, action Action) {
+	c.SetContext(NewMuxSession(c.AsyncWrite, t, t.config))
+	return
+}
+
+func (t *testMuxServer) OnClosed(c Conn, err error) (action Action) {
+	c.Context().(*MuxSession).Close()
+	return
+}
+
+func (t *testMuxServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if err := c.Context().(*MuxSession).Handle(frame); err != nil {
+		return nil, Close
+	}
+	return
+}
+
+func (t *testMuxServer) OnStreamOpen(s *Stream) {}
+
+func (t *testMuxServer) OnStreamData(s *Stream, data []byte) {
+	_ = s.Write(data)
+}
+
+func (t *testMuxServer) OnStreamClose(s *Stream) {
+	t.closed <- s.ID()
+}
+
+type testMuxClient struct {
+	mu       sync.Mutex
+	received map[uint32][]byte
+	size     int
+	done     chan uint32
+}
+
+func (t *testMuxClient) OnStreamOpen(s *Stream) {}
+
+func (t *testMuxClient) OnStreamData(s *Stream, data []byte) {
+	t.mu.Lock()
+	t.received[s.ID()] = append(t.received[s.ID()], data...)
+	n := len(t.received[s.ID()])
+	t.mu.Unlock()
+	if n == t.size {
+		t.done <- s.ID()
+	}
+}
+
+func (t *testMuxClient) OnStreamClose(s *Stream) {}
+
+func TestResolverCache(t *testing.T) {
+	gs, err := Start(new(testClientEchoServer), "tcp://127.0.0.1:0")
+	must(err)
+	defer gs.Stop()
+	port := gs.Addr().(*net.TCPAddr).Port
+
+	resolver := &testResolver{port: uint16(port)}
+	cache := NewResolverCache(resolver, time.Minute, time.Minute)
+	for _, addr := range []string{fmt.Sprintf("tcp://gnet.test:%d", port), "srv://_echo._tcp.gnet.test"} {
+		for i := 0; i < 3; i++ {
+			client, err := DialClient(ClientConfig{Addr: addr, Resolver: cache})
+			must(err)
+			client.Close()
+		}
+	}
+	if hosts, srvs := atomic.LoadInt32(&resolver.hosts), atomic.LoadInt32(&resolver.srvs); hosts != 1 || srvs != 1 {
+		t.Fatalf("expected 1 host lookup and 1 SRV lookup, got %d and %d", hosts, srvs)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = cache.LookupHost(context.Background(), "unknown.gnet.test"); err == nil {
+			t.Fatal("expected the lookup of an unknown host to fail")
+		}
+	}
+	if hosts := atomic.LoadInt32(&resolver.hosts); hosts != 2 {
+		t.Fatalf("expected the failed lookup to be cached, got %d lookups", hosts)
+	}
+}
+
+type testResolver struct {
+	port        uint16
+	hosts, srvs int32
+}
+
+func (r *testResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	atomic.AddInt32(&r.hosts, 1)
+	if host != "gnet.test" {
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	}
+	return []string{"127.0.0.1"}, nil
+}
+
+func (r *testResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	atomic.AddInt32(&r.srvs, 1)
+	return name, []*net.SRV{{Target: "gnet.test.", Port: r.port}}, nil
+}
+
+// testStaticResolver resolves every host name to its addresses.
+type testStaticResolver []string
+
+func (r testStaticResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return r, nil
+}
+
+func (r testStaticResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return "", nil, &net.DNSError{Err: "no such host", Name: name}
+}
+
+func TestHappyEyeballs(t *testing.T) {
+	gs, err := Start(new(testClientEchoServer), "tcp://127.0.0.1:0")
+	must(err)
+	defer gs.Stop()
+	port := gs.Addr().(*net.TCPAddr).Port
+
+	// The first address hangs, the second one is raced after the fallback delay and wins.
+	dialer := &net.Dialer{
+		FallbackDelay: 50 * time.Millisecond,
+		Control: func(network, address string, c syscall.RawConn) error {
+			if strings.HasPrefix(address, "127.0.0.2:") {
+				time.Sleep(2 * time.Second)
+				return errors.New("unreachable")
+			}
+			return nil
+		},
+	}
+	start := time.Now()
+	client, err := DialClient(ClientConfig{
+		Addr:     fmt.Sprintf("tcp://gnet.test:%d", port),
+		Dialer:   dialer,
+		Resolver: testStaticResolver{"127.0.0.2", "127.0.0.1"},
+	})
+	must(err)
+	client.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the second address to be raced, the connection took %v", elapsed)
+	}
+
+	// An address failing starts the next attempt right away, the first error is returned if all of them fail.
+	dialer.FallbackDelay = time.Hour
+	dialer.Control = func(network, address string, c syscall.RawConn) error {
+		if !strings.HasPrefix(address, "127.0.0.1:") {
+			return errors.New("unreachable")
+		}
+		return nil
+	}
+	conn, err := dialAddrs(dialer, "tcp", []string{"127.0.0.3", "127.0.0.1"}, strconv.Itoa(port))
+	must(err)
+	conn.Close()
+	if _, err = dialAddrs(dialer, "tcp", []string{"127.0.0.3", "127.0.0.4"}, strconv.Itoa(port)); err == nil {
+		t.Fatal("expected the dial to fail")
+	}
+
+	for _, c := range []struct {
+		ips, want []string
+	}{
+		{[]string{"::1", "::2", "127.0.0.1", "127.0.0.2"}, []string{"::1", "127.0.0.1", "::2", "127.0.0.2"}},
+		{[]string{"127.0.0.1", "::1", "::2", "::3"}, []string{"127.0.0.1", "::1", "::2", "::3"}},
+		{[]string{"127.0.0.1", "127.0.0.2"}, []string{"127.0.0.1", "127.0.0.2"}},
+	} {
+		if got := interleaveFamilies(c.ips); !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("interleaveFamilies(%v) = %v, want %v", c.ips, got, c.want)
+		}
+	}
+}
+
+func TestNetpoll(t *testing.T) {
+	p, err := netpoll.Open()
+	if err == netpoll.ErrUnsupported {
+		t.Skip(err)
+	}
+	must(err)
+	r, w, err := os.Pipe()
+	must(err)
+	defer r.Close()
+	defer w.Close()
+	fd := int(r.Fd())
+	must(p.AddRead(fd))
+	_, err = w.Write([]byte("x"))
+	must(err)
+	errReady := errors.New("ready")
+	err = p.Polling(netpoll.HandlerFunc(func(ready int, ev netpoll.Event) error {
+		if ready != fd || ev&netpoll.EventRead == 0 {
+			t.Fatalf("expected fd %d readable, got fd %d with %b", fd, ready, ev)
+		}
+		return errReady
+	}))
+	if err != errReady {
+		t.Fatalf("expected the error of the handler, got %v", err)
+	}
+
+	// The registrations are idempotent, the invalid transitions fail.
+	must(p.AddRead(fd))
+	if err = p.AddWrite(fd); err != netpoll.ErrRegistered {
+		t.Fatalf("expected ErrRegistered, got %v", err)
+	}
+	if err = p.ModWrite(int(w.Fd())); err != netpoll.ErrNotRegistered {
+		t.Fatalf("expected ErrNotRegistered, got %v", err)
+	}
+	must(p.Delete(fd))
+	must(p.Delete(fd))
+	must(p.AddRead(fd))
+	must(p.Delete(fd))
+	// A file-descriptor closed before it is deleted is dropped, so that its number can be registered again.
+	r2, w2, err := os.Pipe()
+	must(err)
+	defer w2.Close()
+	must(p.AddRead(int(r2.Fd())))
+	fd2 := int(r2.Fd())
+	must(r2.Close())
+	if err = p.ModReadWrite(fd2); err == nil {
+		t.Fatal("expected renewing a closed file-descriptor to fail")
+	}
+	must(p.Delete(fd2))
+	errStop := errors.New("stop")
+	go func() {
+		must(p.Trigger(func() error { return errStop }))
+	}()
+	if err = p.Polling(netpoll.HandlerFunc(func(int, netpoll.Event) error {
+		return errors.New("unexpected event")
+	})); err != errStop {
+		t.Fatalf("expected the error of the job, got %v", err)
+	}
+	must(p.Close())
+	if err = p.Trigger(func() error { return nil }); err != netpoll.ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+
+	// With a job error handler the failing jobs don't stop the poller, the fatal ones do.
+	p, err = netpoll.Open()
+	must(err)
+	defer p.Close()
+	var handled []error
+	p.SetJobErrorHandler(func(err error) error {
+		handled = append(handled, err)
+		return nil
+	})
+	errJob := errors.New("job failed")
+	must(p.Trigger(func() error { return errJob }))
+	ran := false
+	must(p.Trigger(func() error {
+		ran = true
+		return nil
+	}))
+	must(p.TriggerFatal(func() error { return errStop }))
+	if err = p.Polling(netpoll.HandlerFunc(func(int, netpoll.Event) error {
+		return errors.New("unexpected event")
+	})); err != errStop {
+		t.Fatalf("expected the error of the fatal job, got %v", err)
+	}
+	if !ran || len(handled) != 1 || handled[0] != errJob {
+		t.Fatalf("expected the job error handled and the next job run, got %v and %t", handled, ran)
+	}
+}
+
+func TestEventSource(t *testing.T) {
+	gs, err := Start(new(testClientEchoServer), "tcp://127.0.0.1:0", WithNumEventLoop(2))
+	must(err)
+	r, w, err := os.Pipe()
+	must(err)
+	defer r.Close()
+	defer w.Close()
+	reader := &testEventSource{file: r, events: make(chan string, 4)}
+	if builtinTransport == TransportNet {
+		gs.Stop()
+		if _, err = gs.AddEventSource(0, reader); err != ErrProtocolNotSupported {
+			t.Fatalf("expected ErrProtocolNotSupported, got %v", err)
+		}
+		return
+	}
+	if _, err = gs.AddEventSource(2, reader); err != ErrInvalidLoopIndex {
+		t.Fatalf("expected ErrInvalidLoopIndex, got %v", err)
+	}
+	_, err = gs.AddEventSource(1, reader)
+	must(err)
+	writer := &testEventSource{file: w, events: make(chan string, 4)}
+	ws, err := gs.AddEventSource(0, writer)
+	must(err)
+	must(ws.SetWritable(true))
+	if ev := <-writer.events; ev != "writable" {
+		t.Fatalf("expected the pipe writable, got %s", ev)
+	}
+	must(ws.Close())
+	if ev := <-writer.events; ev != "closed: <nil>" {
+		t.Fatalf("expected the writer closed, got %s", ev)
+	}
+	_, err = w.Write([]byte("ping"))
+	must(err)
+	if ev := <-reader.events; ev != "read: ping" {
+		t.Fatalf("expected the ping read, got %s", ev)
+	}
+	gs.Stop()
+	if ev := <-reader.events; ev != "closed: "+ErrServerShutdown.Error() {
+		t.Fatalf("expected the reader closed by the shutdown, got %s", ev)
+	}
+}
+
+type testEventSource struct {
+	file   *os.File
+	events chan string
+	once   sync.Once
+}
+
+func (s *testEventSource) FD() int {
+	return int(s.file.Fd())
+}
+
+func (s *testEventSource) OnReadable() error {
+	buf := make([]byte, 16)
+	n, err := s.file.Read(buf)
+	if err != nil {
+		return err
+	}
+	s.events <- "read: " + string(buf[:n])
+	return nil
+}
+
+func (s *testEventSource) OnWritable() error {
+	// The pipe stays writable, the event is reported once.
+	s.once.Do(func() {
+		s.events <- "writable"
+	})
+	return nil
+}
+
+func (s *testEventSource) OnClose(err error) {
+	s.events <- fmt.Sprintf("closed: %v", err)
+}
+
+func TestVsock(t *testing.T) {
+	for addr, want := range map[string]*VsockAddr{
+		":5000":  {CID: VsockCIDAny, Port: 5000},
+		"3:5000": {CID: 3, Port: 5000},
+		"x:5000": nil,
+		"5000":   nil,
+	} {
+		got, err := parseVsockAddr(addr)
+		if want == nil && err == nil || want != nil && (err != nil || *got != *want) {
+			t.Fatalf("unexpected parsing of %q: %v, %v", addr, got, err)
+		}
+	}
+	events := &testVsockServer{opened: make(chan net.Addr, 1)}
+	gs, err := Start(events, "vsock://:9991")
+	if runtime.GOOS != "linux" || builtinTransport == TransportNet {
+		if err != ErrProtocolNotSupported {
+			t.Fatalf("expected ErrProtocolNotSupported, got %v", err)
+		}
+		return
+	}
+	if err != nil && strings.Contains(err.Error(), "address family not supported") {
+		t.Skip("AF_VSOCK is not available")
+	}
+	must(err)
+	defer gs.Stop()
+	if addr, ok := gs.Addr().(*VsockAddr); !ok || addr.CID != VsockCIDAny || addr.Port != 9991 {
+		t.Fatalf("unexpected listener address %v", gs.Addr())
+	}
+	conn, err := DialVsock(VsockCIDLocal, 9991)
+	if err != nil {
+		t.Skipf("vsock loopback is not available: %v", err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	must(err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	must(err)
+	if addr, ok := (<-events.opened).(*VsockAddr); !ok || addr.CID != VsockCIDLocal {
+		t.Fatalf("unexpected remote address %v", addr)
+	}
+}
+
+type testVsockServer struct {
+	*EventServer
+	opened chan net.Addr
+}
+
+func (t *testVsockServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.opened <- c.RemoteAddr()
+	return
+}
+
+func (t *testVsockServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	return frame, None
+}
+
+func TestNetlink(t *testing.T) {
+	attrs := AppendNetlinkAttr(nil, NetlinkAttr{Type: 3, Value: []byte("lo")})
+	b := AppendNetlinkMessage(nil, NetlinkMessage{Type: 16, Flags: NetlinkFlagMulti, Seq: 7, Data: attrs})
+	b = AppendNetlinkMessage(b, NetlinkMessage{Type: NetlinkError, Data: []byte{0xfe, 0xff, 0xff, 0xff}})
+	msgs, err := ParseNetlinkMessages(b)
+	must(err)
+	if len(msgs) != 2 || msgs[0].Type != 16 || msgs[0].Seq != 7 || msgs[0].Err() != nil {
+		t.Fatalf("unexpected messages %+v", msgs)
+	}
+	if parsed, err := ParseNetlinkAttrs(msgs[0].Data); err != nil || len(parsed) != 1 ||
+		string(parsed[0].Value) != "lo" {
+		t.Fatalf("unexpected attributes %+v, %v", parsed, err)
+	}
+	if netlinkByteOrder == binary.LittleEndian && msgs[1].Err() != syscall.Errno(2) {
+		t.Fatalf("unexpected error %v", msgs[1].Err())
+	}
+	if _, err = ParseNetlinkMessages(b[:len(b)-1]); err != ErrMalformedNetlink {
+		t.Fatalf("expected ErrMalformedNetlink, got %v", err)
+	}
+
+	gs, err := Start(&EventServer{}, "tcp://:9991")
+	must(err)
+	defer gs.Stop()
+	links := make(chan NetlinkMessage, 64)
+	nl, err := gs.OpenNetlink(0, NetlinkRoute, 0, func(msg NetlinkMessage) error {
+		msg.Data = append([]byte(nil), msg.Data...)
+		links <- msg
+		return nil
+	})
+	if runtime.GOOS != "linux" || builtinTransport == TransportNet {
+		if err != ErrProtocolNotSupported {
+			t.Fatalf("expected ErrProtocolNotSupported, got %v", err)
+		}
+		return
+	}
+	must(err)
+	defer nl.Close()
+	// RTM_GETLINK dumps the links, the request carries a zero ifinfomsg.
+	seq, err := nl.Send(NetlinkMessage{Type: 18, Flags: NetlinkFlagDump, Data: make([]byte, 16)})
+	must(err)
+	var n int
+	for {
+		select {
+		case msg := <-links:
+			if msg.Seq != seq {
+				t.Fatalf("unexpected sequence number %d, expected %d", msg.Seq, seq)
+			}
+			if msg.Type == NetlinkDone {
+				if n == 0 {
+					t.Fatal("no link has been dumped")
+				}
+				return
+			}
+			must(msg.Err())
+			n++
+		case <-time.After(3 * time.Second):
+			t.Fatal("timed out waiting for the links")
+		}
+	}
+}
+
+func TestPacket(t *testing.T) {
+	if _, err := parsePacketAddr("any/0x88b5"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parsePacketAddr("any/x"); err == nil {
+		t.Fatal("expected an error for an invalid EtherType")
+	}
+	if runtime.GOOS != "linux" || builtinTransport == TransportNet {
+		if err := Serve(&EventServer{}, "packet://any/0x88b5"); err != ErrProtocolNotSupported {
+			t.Fatalf("expected ErrProtocolNotSupported, got %v", err)
+		}
+		return
+	}
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface")
+	}
+	// The reflector answers the frames of 0x88b5 by the same frames of 0x88b6, which are served through a ring.
+	reflector, err := Start(&testPacketReflector{}, "packet://lo/0x88b5", WithNumEventLoop(2))
+	if errors.Is(err, os.ErrPermission) {
+		t.Skip("AF_PACKET requires CAP_NET_RAW")
+	}
+	must(err)
+	defer reflector.Stop()
+	events := &testPacketServer{frames: make(chan []byte, 16), addrs: make(chan net.Addr, 16)}
+	gs, err := Start(events, "packet://lo/0x88b6", WithPacketRing(4), WithNumEventLoop(2))
+	must(err)
+	defer gs.Stop()
+	if err = Serve(&EventServer{}, "tcp://:9991", WithPacketRing(4)); err == nil ||
+		!strings.Contains(err.Error(), "PacketRing") {
+		t.Fatalf("expected PacketRing to be rejected, got %v", err)
+	}
+
+	frame := make([]byte, 14, 64)
+	frame[12], frame[13] = 0x88, 0xb5
+	frame = append(frame, "gnet"...)
+	_, err = reflector.s.ln.pconn.WriteTo(frame, &PacketAddr{Ifindex: lo.Index})
+	must(err)
+	select {
+	case got := <-events.frames:
+		if len(got) < len(frame) || got[13] != 0xb6 || !bytes.Equal(got[14:18], []byte("gnet")) {
+			t.Fatalf("unexpected frame %x", got)
+		}
+		if addr, ok := (<-events.addrs).(*PacketAddr); !ok || addr.Ifindex != lo.Index || addr.Protocol != 0x88b6 {
+			t.Fatalf("unexpected remote address %v", addr)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the reflected frame")
+	}
+}
+
+type testPacketReflector struct {
+	*EventServer
+}
+
+func (t *testPacketReflector) React(frame []byte, c Conn) (out []byte, action Action) {
+	if len(frame) < 14 {
+		return
+	}
+	out = append([]byte(nil), frame...)
+	out[13] = 0xb6
+	return
+}
+
+type testPacketServer struct {
+	*EventServer
+	frames chan []byte
+	addrs  chan net.Addr
+}
+
+func (t *testPacketServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	select {
+	case t.frames <- append([]byte(nil), frame...):
+		t.addrs <- c.RemoteAddr()
+	default:
+	}
+	return
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	skipNetTransport(t, "HandshakeTimeout")
+	if _, err := Start(new(EventServer), "udp://127.0.0.1:0", WithHandshakeTimeout(time.Second)); !errors.Is(err,
+		ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions, got %v", err)
+	}
+	for _, codec := range []ICodec{nil, &testNeverHandshakenCodec{}} {
+		server := &testHandshakeServer{closed: make(chan error, 2)}
+		gs, err := Start(server, "tcp://127.0.0.1:0", WithHandshakeTimeout(100*time.Millisecond), WithCodec(codec))
+		must(err)
+		silent, err := net.Dial("tcp", gs.Addr().String())
+		must(err)
+		talking, err := net.Dial("tcp", gs.Addr().String())
+		must(err)
+		_, err = talking.Write([]byte("hello"))
+		must(err)
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(talking, buf)
+		must(err)
+		select {
+		case err = <-server.closed:
+			if err != ErrHandshakeTimeout {
+				t.Fatalf("expected ErrHandshakeTimeout, got %v", err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("timed out waiting for the silent connection to be closed")
+		}
+		_ = silent.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err = silent.Read(buf); err != io.EOF {
+			t.Fatalf("expected the silent connection to be closed, got %v", err)
+		}
+		select {
+		case err = <-server.closed:
+			if codec == nil {
+				t.Fatalf("expected the handshaken connection to be kept, got %v", err)
+			}
+		case <-time.After(300 * time.Millisecond):
+			if codec != nil {
+				t.Fatal("expected the connection which has never completed the handshake to be closed")
+			}
+		}
+		_ = silent.Close()
+		_ = talking.Close()
+		gs.Stop()
+	}
+}
+
+func TestFirstByteTimeout(t *testing.T) {
+	skipNetTransport(t, "FirstByteTimeout")
+	if _, err := Start(new(EventServer), "udp://127.0.0.1:0", WithFirstByteTimeout(time.Second)); !errors.Is(err,
+		ErrInvalidOptions) {
+		t.Fatalf("expected ErrInvalidOptions, got %v", err)
+	}
+	server := &testHandshakeServer{closed: make(chan error, 2)}
+	gs, err := Start(server, "tcp://127.0.0.1:0", WithFirstByteTimeout(100*time.Millisecond))
+	must(err)
+	defer gs.Stop()
+	silent, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer silent.Close()
+	talking, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer talking.Close()
+	_, err = talking.Write([]byte("hello"))
+	must(err)
+	select {
+	case err = <-server.closed:
+		if err != ErrFirstByteTimeout {
+			t.Fatalf("expected ErrFirstByteTimeout, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the silent connection to be closed")
+	}
+	// The connection which has sent its first byte may stay silent afterwards.
+	select {
+	case err = <-server.closed:
+		t.Fatalf("expected the talking connection to be kept, got %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if reason := CloseReasonOf(ErrFirstByteTimeout); reason != CloseIdle {
+		t.Fatalf("expected CloseIdle, got %v", reason)
+	}
+}
+
+type testHandshakeServer struct {
+	*EventServer
+	closed chan error
+}
+
+func (t *testHandshakeServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	return frame, None
+}
+
+func (t *testHandshakeServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- err
+	return
+}
+
+type testNeverHandshakenCodec struct {
+	BuiltInFrameCodec
+}
+
+func (codec *testNeverHandshakenCodec) Handshaken(c Conn) bool {
+	return false
+}
+
+func TestConnMaxLifetime(t *testing.T) {
+	skipNetTransport(t, "ConnMaxLifetime")
+	for _, grace := range []time.Duration{0, 100 * time.Millisecond} {
+		server := &testLifetimeServer{closed: make(chan error, 1)}
+		gs, err := Start(server, "tcp://127.0.0.1:0", WithConnMaxLifetime(200*time.Millisecond),
+			WithConnRotationGrace(grace))
+		must(err)
+		conn, err := net.Dial("tcp", gs.Addr().String())
+		must(err)
+		start := time.Now()
+		_ = conn.SetReadDeadline(start.Add(3 * time.Second))
+		hint, err := ioutil.ReadAll(conn)
+		must(err)
+		if string(hint) != "reconnect" {
+			t.Fatalf("expected the reconnect hint, got %q", hint)
+		}
+		if age := time.Since(start); age < 180*time.Millisecond+grace {
+			t.Fatalf("expected the connection to be rotated after %v, got %v", 180*time.Millisecond+grace, age)
+		}
+		if err = <-server.closed; err != ErrConnMaxLifetime {
+			t.Fatalf("expected ErrConnMaxLifetime, got %v", err)
+		}
+		_ = conn.Close()
+		gs.Stop()
+	}
+}
+
+type testLifetimeServer struct {
+	*EventServer
+	closed chan error
+}
+
+func (t *testLifetimeServer) OnDraining(c Conn) (out []byte, action Action) {
+	return []byte("reconnect"), None
+}
+
+func (t *testLifetimeServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- err
+	return
+}
+
+func TestIdleReaper(t *testing.T) {
+	skipNetTransport(t, "IdleReaper")
+	server := &testIdleServer{closed: make(chan error, 2)}
+	gs, err := Start(server, "tcp://127.0.0.1:0",
+		WithIdleReaper(IdleReaper{Probe: 100 * time.Millisecond, Timeout: 150 * time.Millisecond}))
+	must(err)
+	defer gs.Stop()
+	alive, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer alive.Close()
+	go func() {
+		buf := make([]byte, 4)
+		for {
+			if _, err := io.ReadFull(alive, buf); err != nil {
+				return
+			}
+			if _, err := alive.Write([]byte("pong")); err != nil {
+				return
+			}
+		}
+	}()
+	dead, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer dead.Close()
+	_ = dead.SetReadDeadline(time.Now().Add(3 * time.Second))
+	probes, err := ioutil.ReadAll(dead)
+	must(err)
+	if string(probes) != "ping" {
+		t.Fatalf("expected a single probe, got %q", probes)
+	}
+	if err = <-server.closed; err != ErrIdleTimeout {
+		t.Fatalf("expected ErrIdleTimeout, got %v", err)
+	}
+	select {
+	case err = <-server.closed:
+		t.Fatalf("expected the connection answering the probes to be kept, got %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+type testIdleServer struct {
+	*EventServer
+	closed chan error
+}
+
+func (t *testIdleServer) OnIdle(c Conn, idle time.Duration) (out []byte, action Action) {
+	return []byte("ping"), None
+}
+
+func (t *testIdleServer) OnClosed(c Conn, err error) (action Action) {
+	t.closed <- err
+	return
+}
+
+func TestPartialWrites(t *testing.T) {
+	skipNetTransport(t, "Stats.PartialWrites")
+	gs, err := Start(new(testPartialWriteServer), "tcp://127.0.0.1:0")
+	must(err)
+	defer gs.Stop()
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	// The reply to OnOpened outgrows the socket buffers, the rest of it is flushed from the outbound buffer.
+	buf := make([]byte, 64<<10)
+	for off := 0; off < 16<<20; {
+		n, err := conn.Read(buf)
+		must(err)
+		for i, b := range buf[:n] {
+			if b != byte((off+i)%251) {
+				t.Fatalf("expected %d at offset %d, got %d", byte((off+i)%251), off+i, b)
+			}
+		}
+		off += n
+	}
+	if stats := gs.Stats(); stats.PartialWrites == 0 {
+		t.Fatalf("expected partial writes to be counted, got %+v", stats)
+	}
+}
+
+type testPartialWriteServer struct {
+	*EventServer
+}
+
+func (t *testPartialWriteServer) OnOpened(c Conn) (out []byte, action Action) {
+	out = make([]byte, 16<<20)
+	for i := range out {
+		out[i] = byte(i % 251)
+	}
+	return
+}
+
+func TestCloseReason(t *testing.T) {
+	server := &testCloseReasonServer{reasons: make(chan CloseReason, 1)}
+	gs, err := Start(server, "tcp://127.0.0.1:0")
+	must(err)
+	defer gs.Stop()
+	expect := func(want CloseReason) {
+		t.Helper()
+		select {
+		case reason := <-server.reasons:
+			if reason != want {
+				t.Fatalf("expected %v, got %v", want, reason)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timed out waiting for OnClosed with %v", want)
+		}
+	}
+
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	_, err = conn.Write([]byte("hello"))
+	must(err)
+	must(conn.Close())
+	expect(ClosePeer)
+
+	conn, err = net.Dial("tcp", gs.Addr().String())
+	must(err)
+	_, err = conn.Write([]byte("hello"))
+	must(err)
+	// Lingering for no time resets the connection.
+	must(conn.(*net.TCPConn).SetLinger(0))
+	must(conn.Close())
+	expect(CloseReset)
+
+	conn, err = net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("close"))
+	must(err)
+	expect(CloseServer)
+
+	for err, want := range map[error]CloseReason{
+		nil:                 CloseServer,
+		io.EOF:              ClosePeer,
+		syscall.EPIPE:       CloseReset,
+		ErrSlowConsumer:     CloseWriteTimeout,
+		ErrHandshakeTimeout: CloseIdle,
+		ErrCorruptFrame:     CloseError,
+	} {
+		if reason := CloseReasonOf(err); reason != want {
+			t.Fatalf("expected %v for %v, got %v", want, err, reason)
+		}
+	}
+	if s := CloseReset.String(); s != "reset-by-peer" {
+		t.Fatalf("expected reset-by-peer, got %s", s)
+	}
+}
+
+type testCloseReasonServer struct {
+	*EventServer
+	reasons chan CloseReason
+}
+
+func (t *testCloseReasonServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if string(frame) == "close" {
+		action = Close
+	}
+	return
+}
+
+func (t *testCloseReasonServer) OnClosed(c Conn, err error) (action Action) {
+	t.reasons <- c.CloseReason()
+	return
+}
+
+func TestFDExhaustion(t *testing.T) {
+	skipNetTransport(t, "FDExhaustion")
+	// exhaust opens files until the process runs out of file descriptors but one, which is left for a client.
+	exhaust := func() (release func()) {
+		var files []*os.File
+		for {
+			f, err := os.Open(os.DevNull)
+			if err != nil {
+				break
+			}
+			files = append(files, f)
+		}
+		if len(files) == 0 {
+			t.Fatal("expected to open files before running out of file descriptors")
+		}
+		_ = files[len(files)-1].Close()
+		files = files[:len(files)-1]
+		return func() {
+			for _, f := range files {
+				_ = f.Close()
+			}
+		}
+	}
+
+	pauses := make(chan time.Duration, 16)
+	onExhausted := func(err error, pause time.Duration) {
+		select {
+		case pauses <- pause:
+		default:
+		}
+	}
+	server := &testFDExhaustionServer{opened: make(chan struct{}, 1)}
+	gs, err := Start(server, "tcp://127.0.0.1:0",
+		WithFDExhaustion(FDExhaustion{Backoff: 50 * time.Millisecond, OnExhausted: onExhausted}))
+	must(err)
+	release := exhaust()
+	conn, err := net.Dial("tcp", gs.Addr().String())
+	if err != nil {
+		release()
+		gs.Stop()
+		t.Fatal(err)
+	}
+	var pause time.Duration
+	select {
+	case pause = <-pauses:
+	case <-time.After(3 * time.Second):
+	}
+	release()
+	if pause != 50*time.Millisecond {
+		t.Fatalf("expected accepting to pause for 50ms, got %v", pause)
+	}
+	// The connection waits in the accept queue until accepting resumes.
+	select {
+	case <-server.opened:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the connection to be accepted after the pause")
+	}
+	_ = conn.Close()
+	if n := gs.Stats().FDExhausted; n == 0 {
+		t.Fatal("expected the failed accepts to be counted")
+	}
+	gs.Stop()
+
+	gs, err = Start(server, "tcp://127.0.0.1:0",
+		WithFDExhaustion(FDExhaustion{Policy: FDExhaustionReserve, OnExhausted: onExhausted}))
+	must(err)
+	defer gs.Stop()
+	release = exhaust()
+	conn, err = net.Dial("tcp", gs.Addr().String())
+	if err != nil {
+		release()
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The connection is shed by the spare file descriptor, so the client fails fast.
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	release()
+	if ne, ok := err.(net.Error); err == nil || ok && ne.Timeout() {
+		t.Fatalf("expected the connection to be closed by the server, got %v", err)
+	}
+	for {
+		select {
+		case pause = <-pauses:
+			if pause == 0 {
+				return
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("timed out waiting for OnExhausted to shed the connection")
+		}
+	}
+}
+
+type testFDExhaustionServer struct {
+	*EventServer
+	opened chan struct{}
+}
+
+func (t *testFDExhaustionServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.opened <- struct{}{}
+	return
+}
+
+func TestUrgentJobs(t *testing.T) {
+	skipNetTransport(t, "urgent jobs")
+	server := &testUrgentJobsServer{opened: make(chan Conn, 3), result: make(chan bool, 1)}
+	gs, err := Start(server, "tcp://127.0.0.1:0", WithNumEventLoop(1))
+	must(err)
+	defer gs.Stop()
+	var clients [3]net.Conn
+	var conns [3]Conn
+	for i := range clients {
+		clients[i], err = net.Dial("tcp", gs.Addr().String())
+		must(err)
+		defer clients[i].Close()
+		conns[i] = <-server.opened
+	}
+	server.a, server.b = conns[0], conns[1]
+
+	// Hold the event-loop so that the jobs queue up behind it.
+	held, hold := make(chan struct{}), make(chan struct{})
+	go gs.ForEachConn(func(Conn) bool {
+		close(held)
+		<-hold
+		return false
+	})
+	<-held
+	must(server.b.Wake())
+	must(server.b.Wake())
+	// The writes queued before Close are written first.
+	must(conns[2].AsyncWrite([]byte("bye")))
+	must(conns[2].Close())
+	if stats := gs.Stats(); stats.QueuedJobs != 4 || stats.UrgentJobs != 0 {
+		t.Fatalf("expected 4 queued jobs and no urgent ones, got %d and %d", stats.QueuedJobs, stats.UrgentJobs)
+	}
+	close(hold)
+
+	select {
+	case closed := <-server.result:
+		if !closed {
+			t.Fatal("expected Close to jump ahead of the queued jobs")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the wake-ups")
+	}
+	must(clients[2].SetReadDeadline(time.Now().Add(3 * time.Second)))
+	data, err := ioutil.ReadAll(clients[2])
+	must(err)
+	if string(data) != "bye" {
+		t.Fatalf("expected the write queued before Close, got %q", data)
+	}
+}
+
+type testUrgentJobsServer struct {
+	*EventServer
+	opened  chan Conn
+	result  chan bool
+	a, b    Conn
+	wakes   int
+	aClosed bool
+}
+
+func (t *testUrgentJobsServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.opened <- c
+	return
+}
+
+func (t *testUrgentJobsServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if frame != nil || c != t.b {
+		return
+	}
+	// The first wake-up closes a, the close must run before the second wake-up which is queued ahead of it.
+	if t.wakes++; t.wakes == 1 {
+		_ = t.a.Close()
+	} else {
+		t.result <- t.aClosed
+	}
+	return
+}
+
+func (t *testUrgentJobsServer) OnClosed(c Conn, err error) (action Action) {
+	if c == t.a {
+		t.aClosed = true
+	}
+	return
+}
+
+func TestWakeupCoalescing(t *testing.T) {
+	skipNetTransport(t, "wake-up coalescing")
+	server := &testWakeupServer{opened: make(chan Conn, 1), woken: make(chan struct{}, 1)}
+	gs, err := Start(server, "tcp://127.0.0.1:0", WithNumEventLoop(1))
+	must(err)
+	defer gs.Stop()
+	client, err := net.Dial("tcp", gs.Addr().String())
+	must(err)
+	defer client.Close()
+	c := <-server.opened
+	waitWakes := func() {
+		t.Helper()
+		select {
+		case <-server.woken:
+		case <-time.After(3 * time.Second):
+			t.Fatal("timed out waiting for the wake-ups")
+		}
+	}
+
+	// The jobs triggered while the event-loop is awake don't wake it up again.
+	held, hold := make(chan struct{}), make(chan struct{})
+	go gs.ForEachConn(func(Conn) bool {
+		close(held)
+		<-hold
+		return false
+	})
+	<-held
+	before := gs.Stats()
+	const burst = 100
+	atomic.StoreInt32(&server.expect, burst)
+	for i := 0; i < burst; i++ {
+		must(c.Wake())
+	}
+	after := gs.Stats()
+	if after.Wakeups != before.Wakeups || after.WakeupsCoalesced-before.WakeupsCoalesced != burst {
+		t.Fatalf("expected %d coalesced wake-ups and no wake-up, got %d and %d", burst,
+			after.WakeupsCoalesced-before.WakeupsCoalesced, after.Wakeups-before.Wakeups)
+	}
+	close(hold)
+	waitWakes()
+
+	// A job triggered once the event-loop has fallen asleep wakes it up.
+	time.Sleep(20 * time.Millisecond)
+	atomic.StoreInt32(&server.expect, burst+1)
+	must(c.Wake())
+	waitWakes()
+	if stats := gs.Stats(); stats.Wakeups != after.Wakeups+1 {
+		t.Fatalf("expected a wake-up, got %d", stats.Wakeups-after.Wakeups)
+	}
+}
+
+type testWakeupServer struct {
+	*EventServer
+	opened chan Conn
+	woken  chan struct{}
+	expect int32
+	wakes  int32
+}
+
+func (t *testWakeupServer) OnOpened(c Conn) (out []byte, action Action) {
+	t.opened <- c
+	return
+}
+
+func (t *testWakeupServer) React(frame []byte, c Conn) (out []byte, action Action) {
+	if frame == nil {
+		if t.wakes++; t.wakes == atomic.LoadInt32(&t.expect) {
+			t.woken <- struct{}{}
+		}
+	}
+	return
+}
@@ -0,0 +1,337 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package gnetbench generates load against request/response servers and reports the throughput and
+// latency percentiles, it is meant for catching performance regressions with repeatable benchmarks.
+package gnetbench
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panlibin/gnet"
+)
+
+// Pattern fills the payload of the n-th request of a connection, buf has the configured payload size
+// and the returned slice is sent.
+type Pattern func(n int, buf []byte) []byte
+
+// Fixed returns a pattern sending the same payload for every request.
+func Fixed(payload []byte) Pattern {
+	return func(n int, buf []byte) []byte {
+		return payload
+	}
+}
+
+// Random returns a pattern sending random bytes generated from the given seed.
+func Random(seed int64) Pattern {
+	var mu sync.Mutex
+	r := rand.New(rand.NewSource(seed))
+	return func(n int, buf []byte) []byte {
+		mu.Lock()
+		_, _ = r.Read(buf)
+		mu.Unlock()
+		return buf
+	}
+}
+
+// Sequence returns a pattern sending the sequence number of the request repeatedly, which makes
+// misordered responses easy to spot.
+func Sequence() Pattern {
+	return func(n int, buf []byte) []byte {
+		s := fmt.Sprintf("%d ", n)
+		for i := range buf {
+			buf[i] = s[i%len(s)]
+		}
+		return buf
+	}
+}
+
+// Config is the configuration of a benchmark.
+type Config struct {
+	// Addr is the address of the server like "tcp://127.0.0.1:9000", "unix://socket" or "pipe://name".
+	Addr string
+
+	// Conns is the number of concurrent connections, default to 1.
+	Conns int
+
+	// Duration bounds the benchmark by time, it is ignored if Requests is set.
+	Duration time.Duration
+
+	// Requests is the number of requests sent per connection.
+	Requests int
+
+	// Pipeline is the number of outstanding requests per connection, default to 1.
+	Pipeline int
+
+	// PayloadSize is the size of the buffer passed to Pattern, default to 64.
+	PayloadSize int
+
+	// Pattern generates the payloads, default to Fixed with PayloadSize bytes of 'x'.
+	Pattern Pattern
+
+	// ResponseSize returns the number of bytes to read for a request, default to the size of the request
+	// which suits echo servers.
+	ResponseSize func(req []byte) int
+
+	// Timeout bounds every read and write, default to 10 seconds.
+	Timeout time.Duration
+}
+
+func (cfg *Config) normalize() error {
+	if cfg.Addr == "" {
+		return errors.New("gnetbench: no address")
+	}
+	if cfg.Requests <= 0 && cfg.Duration <= 0 {
+		return errors.New("gnetbench: either Requests or Duration must be set")
+	}
+	if cfg.Conns <= 0 {
+		cfg.Conns = 1
+	}
+	if cfg.Pipeline <= 0 {
+		cfg.Pipeline = 1
+	}
+	if cfg.PayloadSize <= 0 {
+		cfg.PayloadSize = 64
+	}
+	if cfg.Pattern == nil {
+		cfg.Pattern = Fixed([]byte(strings.Repeat("x", cfg.PayloadSize)))
+	}
+	if cfg.ResponseSize == nil {
+		cfg.ResponseSize = func(req []byte) int { return len(req) }
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return nil
+}
+
+// Result is the outcome of a benchmark.
+type Result struct {
+	Conns     int
+	Requests  int64
+	Errors    int64
+	BytesOut  int64
+	BytesIn   int64
+	Elapsed   time.Duration
+	Latencies []time.Duration // sorted latencies of all requests
+}
+
+// Throughput returns the number of requests per second.
+func (r *Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Elapsed.Seconds()
+}
+
+// Percentile returns the latency at the given percentile in [0, 100].
+func (r *Result) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	i := int(float64(len(r.Latencies)-1) * p / 100)
+	if i < 0 {
+		i = 0
+	} else if i >= len(r.Latencies) {
+		i = len(r.Latencies) - 1
+	}
+	return r.Latencies[i]
+}
+
+// Mean returns the mean latency.
+func (r *Result) Mean() time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, l := range r.Latencies {
+		sum += l
+	}
+	return sum / time.Duration(len(r.Latencies))
+}
+
+func (r *Result) String() string {
+	return fmt.Sprintf("%d conns, %d requests, %d errors in %v: %.0f req/s, %.2f MB/s out, %.2f MB/s in\n"+
+		"latency mean %v, p50 %v, p90 %v, p99 %v, p99.9 %v, max %v",
+		r.Conns, r.Requests, r.Errors, r.Elapsed, r.Throughput(),
+		float64(r.BytesOut)/r.Elapsed.Seconds()/1e6, float64(r.BytesIn)/r.Elapsed.Seconds()/1e6,
+		r.Mean(), r.Percentile(50), r.Percentile(90), r.Percentile(99), r.Percentile(99.9), r.Percentile(100))
+}
+
+// Dial connects to the address in the format of Config.Addr.
+func Dial(addr string) (net.Conn, error) {
+	network, address := "tcp", addr
+	if i := strings.Index(addr, "://"); i >= 0 {
+		network, address = addr[:i], addr[i+3:]
+	}
+	if network == "pipe" {
+		return gnet.DialPipe(address)
+	}
+	return net.Dial(network, address)
+}
+
+// Run runs a benchmark with the given configuration.
+func Run(cfg Config) (*Result, error) {
+	if err := cfg.normalize(); err != nil {
+		return nil, err
+	}
+	conns := make([]net.Conn, cfg.Conns)
+	for i := range conns {
+		c, err := Dial(cfg.Addr)
+		if err != nil {
+			for _, c := range conns[:i] {
+				_ = c.Close()
+			}
+			return nil, err
+		}
+		conns[i] = c
+	}
+
+	var (
+		wg      sync.WaitGroup
+		stopped int32
+		res     = &Result{Conns: cfg.Conns}
+		workers = make([]*worker, cfg.Conns)
+	)
+	start := time.Now()
+	if cfg.Requests <= 0 {
+		time.AfterFunc(cfg.Duration, func() { atomic.StoreInt32(&stopped, 1) })
+	}
+	for i, c := range conns {
+		w := &worker{cfg: &cfg, conn: c, stopped: &stopped}
+		workers[i] = w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run()
+		}()
+	}
+	wg.Wait()
+	res.Elapsed = time.Since(start)
+
+	for _, w := range workers {
+		_ = w.conn.Close()
+		res.Requests += int64(len(w.latencies))
+		res.Errors += w.errors
+		res.BytesOut += w.bytesOut
+		res.BytesIn += w.bytesIn
+		res.Latencies = append(res.Latencies, w.latencies...)
+	}
+	sort.Slice(res.Latencies, func(i, j int) bool { return res.Latencies[i] < res.Latencies[j] })
+	return res, nil
+}
+
+// worker drives a connection, it keeps up to Pipeline requests in flight and measures the latency of each
+// request from the time it is written to the time its response is fully read.
+type worker struct {
+	cfg       *Config
+	conn      net.Conn
+	stopped   *int32
+	latencies []time.Duration
+	errors    int64
+	bytesOut  int64
+	bytesIn   int64
+}
+
+type inflight struct {
+	sent time.Time
+	size int
+}
+
+func (w *worker) run() {
+	var (
+		mu      sync.Mutex
+		cond    = sync.NewCond(&mu)
+		queue   []inflight
+		done    bool
+		readErr error
+	)
+	// The reader consumes the responses in order.
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		buf := make([]byte, 64*1024)
+		for {
+			mu.Lock()
+			for len(queue) == 0 && !done {
+				cond.Wait()
+			}
+			if len(queue) == 0 {
+				mu.Unlock()
+				return
+			}
+			req := queue[0]
+			mu.Unlock()
+
+			for n := req.size; n > 0; {
+				chunk := n
+				if chunk > len(buf) {
+					chunk = len(buf)
+				}
+				_ = w.conn.SetReadDeadline(time.Now().Add(w.cfg.Timeout))
+				if _, err := io.ReadFull(w.conn, buf[:chunk]); err != nil {
+					mu.Lock()
+					readErr, done, queue = err, true, nil
+					cond.Broadcast()
+					mu.Unlock()
+					return
+				}
+				n -= chunk
+			}
+
+			mu.Lock()
+			w.latencies = append(w.latencies, time.Since(req.sent))
+			w.bytesIn += int64(req.size)
+			queue = queue[1:]
+			cond.Broadcast()
+			mu.Unlock()
+		}
+	}()
+
+	buf := make([]byte, w.cfg.PayloadSize)
+	for n := 0; w.cfg.Requests <= 0 || n < w.cfg.Requests; n++ {
+		if w.cfg.Requests <= 0 && atomic.LoadInt32(w.stopped) == 1 {
+			break
+		}
+		mu.Lock()
+		for len(queue) >= w.cfg.Pipeline && !done {
+			cond.Wait()
+		}
+		if done {
+			mu.Unlock()
+			break
+		}
+		mu.Unlock()
+
+		req := w.cfg.Pattern(n, buf)
+		mu.Lock()
+		queue = append(queue, inflight{sent: time.Now(), size: w.cfg.ResponseSize(req)})
+		cond.Broadcast()
+		mu.Unlock()
+		_ = w.conn.SetWriteDeadline(time.Now().Add(w.cfg.Timeout))
+		if _, err := w.conn.Write(req); err != nil {
+			w.errors++
+			break
+		}
+		w.bytesOut += int64(len(req))
+	}
+
+	mu.Lock()
+	done = true
+	cond.Broadcast()
+	mu.Unlock()
+	<-readerDone
+	if readErr != nil {
+		w.errors++
+	}
+}
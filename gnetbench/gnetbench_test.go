@@ -0,0 +1,152 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnetbench
+
+import (
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/panlibin/gnet"
+)
+
+type echoServer struct {
+	*gnet.EventServer
+}
+
+func (es *echoServer) React(frame []byte, c gnet.Conn) (out []byte, action gnet.Action) {
+	return frame, gnet.None
+}
+
+type closeServer struct {
+	*gnet.EventServer
+}
+
+func (cs *closeServer) React(frame []byte, c gnet.Conn) (out []byte, action gnet.Action) {
+	return nil, gnet.Close
+}
+
+func startServer(t *testing.T, handler gnet.EventHandler) (addr string, stop func()) {
+	gs, err := gnet.Start(handler, "tcp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "tcp://" + gs.Addr().String(), gs.Stop
+}
+
+func checkLatencies(t *testing.T, res *Result) {
+	if int64(len(res.Latencies)) != res.Requests {
+		t.Fatalf("got %d latencies for %d requests", len(res.Latencies), res.Requests)
+	}
+	if !sort.SliceIsSorted(res.Latencies, func(i, j int) bool { return res.Latencies[i] < res.Latencies[j] }) {
+		t.Fatal("latencies not sorted")
+	}
+	p50, p99, max := res.Percentile(50), res.Percentile(99), res.Percentile(100)
+	if p50 <= 0 || p50 > p99 || p99 > max || max != res.Latencies[len(res.Latencies)-1] {
+		t.Fatalf("got p50 %v, p99 %v and max %v", p50, p99, max)
+	}
+	if mean := res.Mean(); mean < res.Latencies[0] || mean > max {
+		t.Fatalf("got the mean %v out of [%v, %v]", mean, res.Latencies[0], max)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var res Result
+	if res.Percentile(50) != 0 || res.Mean() != 0 || res.Throughput() != 0 {
+		t.Fatal("expected zeros without any request")
+	}
+	for i := 1; i <= 100; i++ {
+		res.Latencies = append(res.Latencies, time.Duration(i)*time.Millisecond)
+	}
+	res.Requests, res.Elapsed = 100, 2*time.Second
+	for p, want := range map[float64]time.Duration{
+		-1:   time.Millisecond,
+		0:    time.Millisecond,
+		50:   50 * time.Millisecond,
+		90:   90 * time.Millisecond,
+		99.9: 99 * time.Millisecond,
+		100:  100 * time.Millisecond,
+		200:  100 * time.Millisecond,
+	} {
+		if got := res.Percentile(p); got != want {
+			t.Fatalf("got %v at p%v, want %v", got, p, want)
+		}
+	}
+	if got, want := res.Mean(), 50500*time.Microsecond; got != want {
+		t.Fatalf("got the mean %v, want %v", got, want)
+	}
+	if got := res.Throughput(); got != 50 {
+		t.Fatalf("got %v req/s, want 50", got)
+	}
+}
+
+func TestRunRequests(t *testing.T) {
+	addr, stop := startServer(t, new(echoServer))
+	defer stop()
+
+	res, err := Run(Config{Addr: addr, Conns: 4, Requests: 100, Pipeline: 4, PayloadSize: 32, Pattern: Sequence()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conns != 4 || res.Requests != 400 || res.Errors != 0 {
+		t.Fatalf("got %d conns, %d requests and %d errors, want 4, 400 and 0", res.Conns, res.Requests, res.Errors)
+	}
+	if res.BytesOut != 400*32 || res.BytesIn != 400*32 {
+		t.Fatalf("got %d bytes out and %d in, want %d", res.BytesOut, res.BytesIn, 400*32)
+	}
+	checkLatencies(t, res)
+}
+
+func TestRunDuration(t *testing.T) {
+	addr, stop := startServer(t, new(echoServer))
+	defer stop()
+
+	res, err := Run(Config{Addr: addr, Conns: 2, Duration: 200 * time.Millisecond, Pattern: Random(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Elapsed < 200*time.Millisecond || res.Elapsed > 10*time.Second {
+		t.Fatalf("ran for %v, want about 200ms", res.Elapsed)
+	}
+	if res.Requests == 0 || res.Errors != 0 {
+		t.Fatalf("got %d requests and %d errors", res.Requests, res.Errors)
+	}
+	if res.BytesOut != res.Requests*64 || res.BytesIn != res.BytesOut {
+		t.Fatalf("got %d bytes out and %d in for %d requests of 64 bytes", res.BytesOut, res.BytesIn, res.Requests)
+	}
+	checkLatencies(t, res)
+}
+
+func TestRunErrors(t *testing.T) {
+	if _, err := Run(Config{Requests: 1}); err == nil {
+		t.Fatal("expected an error without an address")
+	}
+	if _, err := Run(Config{Addr: "tcp://127.0.0.1:0"}); err == nil {
+		t.Fatal("expected an error without Requests and Duration")
+	}
+
+	// Dialing a closed port fails the benchmark.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := "tcp://" + ln.Addr().String()
+	_ = ln.Close()
+	if _, err = Run(Config{Addr: closed, Conns: 2, Requests: 1}); err == nil {
+		t.Fatal("expected an error dialing a closed port")
+	}
+
+	// A connection closed by the server counts an error and stops its worker.
+	addr, stop := startServer(t, new(closeServer))
+	defer stop()
+	res, err := Run(Config{Addr: addr, Conns: 3, Requests: 10, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Requests != 0 || res.Errors != 3 || res.BytesIn != 0 {
+		t.Fatalf("got %d requests, %d errors and %d bytes in, want 0, 3 and 0", res.Requests, res.Errors, res.BytesIn)
+	}
+}
@@ -0,0 +1,453 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnettest
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/panlibin/gnet"
+)
+
+// Conn is a mock gnet.Conn for unit-testing event handlers and codecs without event-loops, the inbound data
+// is supplied by Feed and the data written to the connection is collected for Written.
+//
+// A Conn opened by Loop.Dial defers AsyncWrite, Wake, ResumeRead and Close to the jobs of the loop.
+type Conn struct {
+	loop       *Loop
+	ctx        interface{}
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	peer       *gnet.Peer
+	codec      gnet.ICodec
+	inbound    []byte
+	throttled  bool
+	pausedRead int32
+	detached   net.Conn
+	tos        byte
+	dst        net.Addr
+
+	mu      sync.Mutex
+	written []byte
+	wakes   int
+	closed  bool
+	reason  gnet.CloseReason
+	stats   gnet.ConnStats
+}
+
+// NewConn instantiates a mock connection with the given codec, the built-in codec is used if it is nil.
+func NewConn(codec gnet.ICodec) *Conn {
+	if codec == nil {
+		codec = new(gnet.BuiltInFrameCodec)
+	}
+	return &Conn{
+		codec:      codec,
+		localAddr:  &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000},
+		remoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000},
+	}
+}
+
+// SetAddrs sets the local and remote addresses of the connection.
+func (c *Conn) SetAddrs(localAddr, remoteAddr net.Addr) {
+	c.localAddr, c.remoteAddr = localAddr, remoteAddr
+}
+
+// SetOriginalDst sets the address returned by OriginalDst, as if the connection was intercepted.
+func (c *Conn) SetOriginalDst(dst net.Addr) {
+	c.dst = dst
+}
+
+// SetPeer sets the metadata of the remote peer.
+func (c *Conn) SetPeer(peer *gnet.Peer) {
+	c.peer = peer
+}
+
+// Feed appends data to the inbound buffer as if it was received from the peer.
+func (c *Conn) Feed(data []byte) {
+	c.inbound = append(c.inbound, data...)
+	c.mu.Lock()
+	c.stats.BytesRead += int64(len(data))
+	c.mu.Unlock()
+}
+
+// Open fires OnConfigure and OnOpened of the event handler, the codec of the settings replaces the one of the
+// connection and the output is written to the connection.
+func (c *Conn) Open(eventHandler gnet.EventHandler) gnet.Action {
+	if codec := eventHandler.OnConfigure(c).Codec; codec != nil {
+		c.codec = codec
+	}
+	out, action := eventHandler.OnOpened(c)
+	if out != nil {
+		c.write(out)
+	}
+	return action
+}
+
+// React decodes the inbound buffer and fires React of the event handler for each frame like an event-loop does,
+// the outputs are encoded and written to the connection, it stops at the first action other than None
+// or once reading is paused by PauseRead.
+// It returns Close if the codec fails with ErrCorruptFrame or ErrFrameTooLarge.
+func (c *Conn) React(eventHandler gnet.EventHandler) gnet.Action {
+	for !c.ReadPaused() {
+		frame, err := c.codec.Decode(c)
+		if frame == nil {
+			if err == gnet.ErrCorruptFrame || err == gnet.ErrFrameTooLarge {
+				return gnet.Close
+			}
+			return gnet.None
+		}
+		c.mu.Lock()
+		c.stats.FramesDecoded++
+		c.mu.Unlock()
+		out, action := eventHandler.React(frame, c)
+		if c.Detached() != nil {
+			return gnet.None
+		}
+		if out != nil {
+			if buf, err := c.codec.Encode(c, out); err == nil {
+				c.write(buf)
+			}
+		}
+		if action != gnet.None {
+			return action
+		}
+	}
+	return gnet.None
+}
+
+// Written returns the data written to the connection since the last call.
+func (c *Conn) Written() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	buf := c.written
+	c.written = nil
+	return buf
+}
+
+// Wakes returns how many times Wake has been invoked.
+func (c *Conn) Wakes() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.wakes
+}
+
+// Closed reports whether Close has been invoked.
+func (c *Conn) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Detached returns the peer end of the net.Conn handed to OnDetached when the Detach action is handled
+// by the loop or returned by Detach, it is nil until then.
+func (c *Conn) Detached() net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.detached
+}
+
+// TOS returns the byte set by SetTOS.
+func (c *Conn) TOS() byte {
+	return c.tos
+}
+
+// CloseReason tells why the connection has been closed, by Loop.Hangup, by an action or by Close.
+func (c *Conn) CloseReason() gnet.CloseReason {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reason
+}
+
+func (c *Conn) markClosed(reason gnet.CloseReason) {
+	c.mu.Lock()
+	c.closed, c.reason = true, reason
+	c.mu.Unlock()
+}
+
+func (c *Conn) write(buf []byte) {
+	c.mu.Lock()
+	c.written = append(c.written, buf...)
+	c.stats.BytesWritten += int64(len(buf))
+	c.mu.Unlock()
+}
+
+// touch sets the times of the stats by the virtual clock of the loop.
+func (c *Conn) touch(now time.Time, created bool) {
+	c.mu.Lock()
+	if created {
+		c.stats.CreatedAt = now
+	}
+	c.stats.LastActivity = now
+	c.mu.Unlock()
+}
+
+// ================================= Implementation of gnet.Conn =================================
+
+func (c *Conn) Context() interface{}       { return c.ctx }
+func (c *Conn) SetContext(ctx interface{}) { c.ctx = ctx }
+func (c *Conn) Peer() *gnet.Peer           { return c.peer }
+func (c *Conn) LocalAddr() net.Addr        { return c.localAddr }
+func (c *Conn) RemoteAddr() net.Addr       { return c.remoteAddr }
+func (c *Conn) Read() []byte               { return c.inbound }
+func (c *Conn) ResetBuffer()               { c.inbound = nil }
+func (c *Conn) BufferLength() int          { return len(c.inbound) }
+func (c *Conn) SetTOS(tos byte) error      { c.tos = tos; return nil }
+
+// Stats returns the byte and frame counts of the connection, the times are only set by the virtual clock
+// of the loop for a Conn opened by Loop.Dial. OutboundBuffered is the length of the data not collected by Written.
+func (c *Conn) Stats() gnet.ConnStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.InboundBuffered, stats.OutboundBuffered = len(c.inbound), len(c.written)
+	return stats
+}
+
+func (c *Conn) ReadN(n int) (size int, buf []byte) {
+	if n <= 0 || n > len(c.inbound) {
+		return
+	}
+	return n, c.inbound[:n]
+}
+
+func (c *Conn) ShiftN(n int) (size int) {
+	if n <= 0 || n >= len(c.inbound) {
+		size = len(c.inbound)
+		c.inbound = nil
+		return
+	}
+	c.inbound = c.inbound[n:]
+	return n
+}
+
+func (c *Conn) SendTo(buf []byte) error {
+	c.write(buf)
+	return nil
+}
+
+func (c *Conn) AsyncWrite(buf []byte) error {
+	encoded, err := c.codec.Encode(c, buf)
+	if err != nil {
+		return err
+	}
+	if c.loop != nil {
+		c.loop.enqueue(func() {
+			if !c.Closed() {
+				c.write(encoded)
+			}
+		})
+		return nil
+	}
+	c.write(encoded)
+	return nil
+}
+
+func (c *Conn) AsyncWriteWithPriority(buf []byte, priority gnet.WritePriority) error {
+	return c.AsyncWrite(buf)
+}
+
+// Writer returns a writer streaming data to the connection, the data bypasses the codec. It never blocks,
+// the data is written at once or, for a Conn opened by Loop.Dial, by the jobs of the loop.
+func (c *Conn) Writer() io.Writer {
+	return connWriter{c}
+}
+
+type connWriter struct {
+	c *Conn
+}
+
+func (w connWriter) Write(p []byte) (int, error) {
+	c := w.c
+	if c.Closed() {
+		return 0, gnet.ErrConnectionClosed
+	}
+	if c.loop != nil {
+		data := append([]byte(nil), p...)
+		c.loop.enqueue(func() {
+			if !c.Closed() {
+				c.write(data)
+			}
+		})
+		return len(p), nil
+	}
+	c.write(p)
+	return len(p), nil
+}
+
+// Write writes p to the connection through Writer.
+func (c *Conn) Write(p []byte) (int, error) {
+	return connWriter{c}.Write(p)
+}
+
+// ReadFrom writes the data from r to the connection through Writer.
+func (c *Conn) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(connWriter{c}, r)
+}
+
+// WriteTo writes the inbound data to w and discards it.
+func (c *Conn) WriteTo(w io.Writer) (n int64, err error) {
+	if len(c.inbound) == 0 {
+		return
+	}
+	m, err := w.Write(c.inbound)
+	c.inbound = c.inbound[m:]
+	return int64(m), err
+}
+
+func (c *Conn) Wake() error {
+	c.mu.Lock()
+	c.wakes++
+	c.mu.Unlock()
+	if l := c.loop; l != nil {
+		l.enqueue(func() {
+			if c.Closed() {
+				return
+			}
+			resumed := c.throttled
+			c.throttled = false
+			out, action := l.eventHandler.React(nil, c)
+			if out != nil {
+				if buf, err := c.codec.Encode(c, out); err == nil {
+					c.write(buf)
+				}
+			}
+			l.handleConnAction(c, action)
+			if resumed && !c.throttled && !c.Closed() {
+				// Decode the frames held back while the connection was throttled.
+				l.handleConnAction(c, c.React(l.eventHandler))
+			}
+		})
+	}
+	return nil
+}
+
+// LoopIndex returns 0 since a Loop is the only event-loop.
+func (c *Conn) LoopIndex() int {
+	return 0
+}
+
+// Migrate does nothing since a Loop is the only event-loop, it fails with gnet.ErrInvalidLoopIndex for an index
+// other than 0.
+func (c *Conn) Migrate(idx int) error {
+	if idx != 0 {
+		return gnet.ErrInvalidLoopIndex
+	}
+	return nil
+}
+
+// OriginalDst returns the address set by SetOriginalDst, it fails with gnet.ErrProtocolNotSupported if none is set.
+func (c *Conn) OriginalDst() (net.Addr, error) {
+	if c.dst == nil {
+		return nil, gnet.ErrProtocolNotSupported
+	}
+	return c.dst, nil
+}
+
+// SyscallConn fails with gnet.ErrProtocolNotSupported since there is no socket behind the connection.
+func (c *Conn) SyscallConn() (syscall.RawConn, error) {
+	return nil, gnet.ErrProtocolNotSupported
+}
+
+func (c *Conn) PauseRead() error {
+	atomic.StoreInt32(&c.pausedRead, 1)
+	return nil
+}
+
+func (c *Conn) ResumeRead() error {
+	if !atomic.CompareAndSwapInt32(&c.pausedRead, 1, 0) {
+		return nil
+	}
+	if l := c.loop; l != nil {
+		l.enqueue(func() {
+			if !c.throttled && !c.ReadPaused() && !c.Closed() {
+				// Decode the frames held back while reading was paused.
+				l.handleConnAction(c, c.React(l.eventHandler))
+			}
+		})
+	}
+	return nil
+}
+
+// ReadPaused reports whether reading is paused by PauseRead.
+func (c *Conn) ReadPaused() bool {
+	return atomic.LoadInt32(&c.pausedRead) == 1
+}
+
+func (c *Conn) Upgrade(codec gnet.ICodec) {
+	c.codec = codec
+}
+
+// Detach marks the connection as closed and returns one end of a net.Pipe, the inbound data is read first
+// from that end, the other end is returned by Detached.
+func (c *Conn) Detach() (net.Conn, error) {
+	if c.Closed() {
+		return nil, gnet.ErrConnectionClosed
+	}
+	if l := c.loop; l != nil {
+		l.removeConn(c)
+	}
+	return c.detach(), nil
+}
+
+func (c *Conn) detach() net.Conn {
+	c.markClosed(gnet.CloseNone)
+	local, remote := net.Pipe()
+	c.mu.Lock()
+	c.detached = remote
+	c.mu.Unlock()
+	conn := &prefixConn{Conn: local, prefix: append([]byte(nil), c.inbound...)}
+	c.inbound = nil
+	return conn
+}
+
+// Tick registers a periodic callback driven by the virtual clock of the loop, it is a no-op for a Conn
+// which is not opened by Loop.Dial.
+func (c *Conn) Tick(interval time.Duration, fn gnet.ConnTickFunc) (cancel func()) {
+	l := c.loop
+	if l == nil {
+		return func() {}
+	}
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	var (
+		stopped bool
+		tick    func() error
+	)
+	tick = func() error {
+		if stopped || c.Closed() {
+			return nil
+		}
+		out, action := fn(c)
+		if out != nil {
+			encoded, _ := c.codec.Encode(c, out)
+			c.write(encoded)
+		}
+		if !stopped {
+			l.timers.Add(interval, tick)
+		}
+		l.handleConnAction(c, action)
+		return nil
+	}
+	l.timers.Add(interval, tick)
+	return func() { stopped = true }
+}
+
+func (c *Conn) Close() error {
+	if l := c.loop; l != nil {
+		l.enqueue(func() {
+			l.closeConn(c, nil)
+		})
+		return nil
+	}
+	c.markClosed(gnet.CloseServer)
+	return nil
+}
+
+var _ gnet.Conn = (*Conn)(nil)
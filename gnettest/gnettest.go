@@ -0,0 +1,62 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package gnettest provides utilities for testing the event handlers and codecs of gnet without binding
+// real ports: Server runs real event-loops serving on an in-memory pipe and Conn is a mock gnet.Conn.
+package gnettest
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/panlibin/gnet"
+)
+
+var pipeSeq uint32
+
+// Server is a gnet server serving on an in-memory pipe.
+type Server struct {
+	gs   *gnet.GServer
+	name string
+}
+
+// Serve starts a server with the given event handler and options on a new in-memory pipe,
+// the connections dialed by Server.Dial are handled by real event-loops.
+func Serve(eventHandler gnet.EventHandler, opts ...gnet.Option) (*Server, error) {
+	s := &Server{
+		gs:   new(gnet.GServer),
+		name: fmt.Sprintf("gnettest-%d", atomic.AddUint32(&pipeSeq, 1)),
+	}
+	if err := s.gs.Serve(eventHandler, "pipe://"+s.name, opts...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Name returns the name of the pipe which the server is serving on.
+func (s *Server) Name() string {
+	return s.name
+}
+
+// Dial connects to the server.
+func (s *Server) Dial() (net.Conn, error) {
+	return gnet.DialPipe(s.name)
+}
+
+// Stats returns a snapshot of the server-wide counters.
+func (s *Server) Stats() gnet.Stats {
+	return s.gs.Stats()
+}
+
+// Stop shuts the server down and waits for the event-loops to exit.
+func (s *Server) Stop() {
+	s.gs.SignalShutdown()
+	s.gs.WaitShutdown()
+}
+
+// Wait waits for the server to be shut down by the event handler.
+func (s *Server) Wait() {
+	s.gs.WaitShutdown()
+}
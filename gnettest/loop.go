@@ -0,0 +1,243 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnettest
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/panlibin/gnet"
+	"github.com/panlibin/gnet/internal"
+)
+
+// Loop is a deterministic event-loop with a virtual clock, it drives an event handler over mock connections
+// on the calling goroutine: ticks and timers only fire in Advance and asynchronous jobs such as AsyncWrite,
+// Wake and Close of the connections only run in RunJobs or Advance, so that timeout and heartbeat logic
+// can be tested without sleeps.
+type Loop struct {
+	eventHandler gnet.EventHandler
+	opts         gnet.Options
+	now          time.Time
+	timers       internal.TimerQueue
+	jobsMu       sync.Mutex
+	jobs         []func()
+	conns        []*Conn
+	shutdown     bool
+}
+
+// NewLoop instantiates a loop with the given event handler and options, only the Ticker and Codec options
+// take effect, OnInitComplete fires right away and so does the first tick if the ticker is enabled.
+func NewLoop(eventHandler gnet.EventHandler, opts ...gnet.Option) *Loop {
+	l := &Loop{eventHandler: eventHandler, now: time.Unix(0, 0)}
+	for _, opt := range opts {
+		opt(&l.opts)
+	}
+	l.timers.SetClock(l.Now)
+	l.handleAction(eventHandler.OnInitComplete(gnet.Server{NumEventLoop: 1}))
+	if l.opts.Ticker {
+		l.tick()
+	}
+	return l
+}
+
+// Now returns the virtual time of the loop.
+func (l *Loop) Now() time.Time {
+	return l.now
+}
+
+// IsShutdown reports whether the event handler has requested a shutdown, every connection is closed then.
+func (l *Loop) IsShutdown() bool {
+	return l.shutdown
+}
+
+// Dial opens a new mock connection on the loop and fires OnAccept and OnOpened, it returns nil if OnAccept
+// has rejected the connection.
+func (l *Loop) Dial() *Conn {
+	c := NewConn(l.opts.Codec)
+	c.touch(l.now, true)
+	switch action := l.eventHandler.OnAccept(c.RemoteAddr(), -1); action {
+	case gnet.Close, gnet.Shutdown:
+		l.handleAction(action)
+		return nil
+	}
+	c.loop = l
+	l.conns = append(l.conns, c)
+	l.handleConnAction(c, c.Open(l.eventHandler))
+	return c
+}
+
+// Send feeds data to the connection as if it was received from the peer and fires React for the frames.
+func (l *Loop) Send(c *Conn, data []byte) {
+	if c.Closed() {
+		return
+	}
+	c.Feed(data)
+	c.touch(l.now, false)
+	if c.throttled || c.ReadPaused() {
+		return
+	}
+	l.handleConnAction(c, c.React(l.eventHandler))
+}
+
+// Hangup closes the connection as if the peer has gone away and fires OnClosed with the given error, a nil
+// error stands for the peer closing the connection gracefully.
+func (l *Loop) Hangup(c *Conn, err error) {
+	reason := gnet.ClosePeer
+	if err != nil {
+		reason = gnet.CloseReasonOf(err)
+	}
+	l.closeConnBy(c, err, reason)
+}
+
+// Drain fires OnDraining for every connection as GServer.Drain does and writes the outputs to the connections,
+// the grace period is up to the test, e.g. by Advance followed by Hangup of the connections left.
+func (l *Loop) Drain() {
+	for _, c := range append([]*Conn(nil), l.conns...) {
+		if c.Closed() {
+			continue
+		}
+		out, action := l.eventHandler.OnDraining(c)
+		if out != nil {
+			c.write(out)
+		}
+		if action == gnet.Close || action == gnet.Shutdown {
+			l.handleConnAction(c, action)
+		}
+		if l.shutdown {
+			return
+		}
+	}
+}
+
+// AfterFunc schedules f to be invoked on the loop after the given duration of virtual time.
+func (l *Loop) AfterFunc(d time.Duration, f func()) {
+	l.timers.Add(d, func() error {
+		f()
+		return nil
+	})
+}
+
+// RunJobs runs the pending asynchronous jobs, including the jobs queued by them.
+func (l *Loop) RunJobs() {
+	for {
+		l.jobsMu.Lock()
+		jobs := l.jobs
+		l.jobs = nil
+		l.jobsMu.Unlock()
+		if len(jobs) == 0 {
+			return
+		}
+		for _, job := range jobs {
+			job()
+		}
+	}
+}
+
+// Advance moves the virtual clock forward by the given duration, firing the ticks and timers in order
+// and running the asynchronous jobs after each of them.
+func (l *Loop) Advance(d time.Duration) {
+	deadline := l.now.Add(d)
+	l.RunJobs()
+	for !l.shutdown {
+		timeout := l.timers.Timeout()
+		if timeout < 0 || l.now.Add(timeout).After(deadline) {
+			break
+		}
+		l.now = l.now.Add(timeout)
+		_ = l.timers.Expire()
+		l.RunJobs()
+	}
+	l.now = deadline
+}
+
+func (l *Loop) tick() {
+	delay, action := l.eventHandler.Tick()
+	if l.handleAction(action) {
+		l.timers.Add(delay, func() error {
+			l.tick()
+			return nil
+		})
+	}
+}
+
+// enqueue queues an asynchronous job, it is goroutine-safe.
+func (l *Loop) enqueue(job func()) {
+	l.jobsMu.Lock()
+	l.jobs = append(l.jobs, job)
+	l.jobsMu.Unlock()
+}
+
+// handleAction handles the action of a loop-wide event and reports whether the loop is still running.
+func (l *Loop) handleAction(action gnet.Action) bool {
+	if action == gnet.Shutdown && !l.shutdown {
+		l.shutdown = true
+		// Closing a connection removes it from l.conns.
+		for _, c := range append([]*Conn(nil), l.conns...) {
+			l.closeConn(c, nil)
+		}
+	}
+	return !l.shutdown
+}
+
+func (l *Loop) handleConnAction(c *Conn, action gnet.Action) {
+	switch action {
+	case gnet.Close:
+		l.closeConn(c, nil)
+	case gnet.Shutdown:
+		l.handleAction(action)
+	case gnet.Throttle:
+		c.throttled = true
+	case gnet.Detach:
+		l.detachConn(c)
+	}
+}
+
+// detachConn removes the connection from the loop and hands one end of a net.Pipe over to OnDetached,
+// the inbound data is read first from that end, the other end is returned by Conn.Detached.
+func (l *Loop) detachConn(c *Conn) {
+	if c.Closed() {
+		return
+	}
+	l.removeConn(c)
+	l.handleAction(l.eventHandler.OnDetached(c, c.detach()))
+}
+
+func (l *Loop) closeConn(c *Conn, err error) {
+	l.closeConnBy(c, err, gnet.CloseReasonOf(err))
+}
+
+func (l *Loop) closeConnBy(c *Conn, err error, reason gnet.CloseReason) {
+	if c.Closed() {
+		return
+	}
+	c.markClosed(reason)
+	l.removeConn(c)
+	l.handleAction(l.eventHandler.OnClosed(c, err))
+}
+
+func (l *Loop) removeConn(c *Conn) {
+	for i, cc := range l.conns {
+		if cc == c {
+			l.conns = append(l.conns[:i], l.conns[i+1:]...)
+			break
+		}
+	}
+}
+
+// prefixConn is a net.Conn whose reads return the prefix before the data of the underlying connection.
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(p []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(p, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
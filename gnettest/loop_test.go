@@ -0,0 +1,110 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnettest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/panlibin/gnet"
+)
+
+type testLoopServer struct {
+	*gnet.EventServer
+	interval time.Duration
+	ticks    []time.Time
+	loop     *Loop
+	closed   []*Conn
+	shutdown bool
+}
+
+func (s *testLoopServer) Tick() (delay time.Duration, action gnet.Action) {
+	if s.loop != nil {
+		s.ticks = append(s.ticks, s.loop.Now())
+	}
+	if s.shutdown {
+		action = gnet.Shutdown
+	}
+	return s.interval, action
+}
+
+func (s *testLoopServer) OnClosed(c gnet.Conn, err error) (action gnet.Action) {
+	s.closed = append(s.closed, c.(*Conn))
+	return
+}
+
+func TestLoopClock(t *testing.T) {
+	l := NewLoop(new(testLoopServer))
+	start := l.Now()
+	var fired []time.Duration
+	l.AfterFunc(3*time.Second, func() { fired = append(fired, l.Now().Sub(start)) })
+	l.AfterFunc(time.Second, func() { fired = append(fired, l.Now().Sub(start)) })
+
+	l.Advance(500 * time.Millisecond)
+	if len(fired) != 0 {
+		t.Fatalf("timers fired early: %v", fired)
+	}
+	if got := l.Now().Sub(start); got != 500*time.Millisecond {
+		t.Fatalf("clock at %v, want 500ms", got)
+	}
+	l.Advance(5 * time.Second)
+	if len(fired) != 2 || fired[0] != time.Second || fired[1] != 3*time.Second {
+		t.Fatalf("timers fired at %v, want [1s 3s]", fired)
+	}
+	if got := l.Now().Sub(start); got != 5500*time.Millisecond {
+		t.Fatalf("clock at %v, want 5.5s", got)
+	}
+}
+
+func TestLoopTick(t *testing.T) {
+	s := &testLoopServer{interval: time.Second}
+	l := NewLoop(s, gnet.WithTicker(true))
+	s.loop = l
+	start := l.Now()
+	l.Advance(3500 * time.Millisecond)
+	if len(s.ticks) != 3 {
+		t.Fatalf("got %d ticks, want 3", len(s.ticks))
+	}
+	for i, at := range s.ticks {
+		if want := time.Duration(i+1) * time.Second; at.Sub(start) != want {
+			t.Fatalf("tick %d at %v, want %v", i, at.Sub(start), want)
+		}
+	}
+
+	c := l.Dial()
+	var connTicks int
+	cancel := c.Tick(200*time.Millisecond, func(gnet.Conn) ([]byte, gnet.Action) {
+		connTicks++
+		return []byte("ping"), gnet.None
+	})
+	l.Advance(time.Second)
+	if connTicks != 5 || string(c.Written()) != "pingpingpingpingping" {
+		t.Fatalf("got %d connection ticks writing %q, want 5", connTicks, c.Written())
+	}
+	cancel()
+	l.Advance(time.Second)
+	if connTicks != 5 {
+		t.Fatalf("connection ticked %d times after cancel, want 5", connTicks)
+	}
+}
+
+func TestLoopShutdown(t *testing.T) {
+	s := &testLoopServer{interval: time.Second}
+	l := NewLoop(s, gnet.WithTicker(true))
+	conns := []*Conn{l.Dial(), l.Dial(), l.Dial()}
+	s.shutdown = true
+	l.Advance(time.Second)
+	if !l.IsShutdown() {
+		t.Fatal("loop not shut down")
+	}
+	for i, c := range conns {
+		if !c.Closed() {
+			t.Fatalf("connection %d left open", i)
+		}
+	}
+	if len(s.closed) != len(conns) {
+		t.Fatalf("OnClosed fired %d times, want %d", len(s.closed), len(conns))
+	}
+}
@@ -0,0 +1,22 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+const defaultHandoffQueueSize = 512
+
+// Handoff sets up how the new connections are handed over from the acceptor to the event-loops with the net
+// transport, where the acceptor pushes them into the command channels of the event-loops. It has no effect with
+// the poll transport, which registers them with the pollers of the event-loops directly.
+type Handoff struct {
+	// Direct registers the new connections with the event-loops through queues of their own rather than the
+	// command channels, so that a burst of accepts never waits for an event-loop busy with the reads and
+	// the asynchronous jobs filling its command channel. The queues are unbounded.
+	Direct bool
+
+	// QueueSize is the capacity of the command channel of every event-loop, which carries the reads of the
+	// connections and the asynchronous jobs as well as the new connections unless Direct is set, defaults
+	// to 512. The acceptor waits when it is full, see Stats.HandoffOverflows.
+	QueueSize int
+}
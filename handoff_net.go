@@ -0,0 +1,80 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows gnet_net
+
+package gnet
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// handoffQueue holds the new connections and UDP packets handed over to an event-loop by the acceptor when
+// Handoff.Direct is set, it is unbounded so that the acceptor never waits for the event-loop.
+type handoffQueue struct {
+	mu      sync.Mutex
+	items   []interface{}
+	pending int32 // 1 once items have been pushed and until the event-loop takes them
+}
+
+// handoffReq tells the event-loop to take the items of its handoff queue.
+type handoffReq struct{}
+
+// handOff hands the new connection or UDP packet over to the event-loop.
+func (el *eventloop) handOff(v interface{}) {
+	if q := el.handoff; q != nil {
+		q.mu.Lock()
+		q.items = append(q.items, v)
+		q.mu.Unlock()
+		if atomic.CompareAndSwapInt32(&q.pending, 0, 1) {
+			// The event-loop takes the items before whatever it receives next, so the request may be dropped
+			// when the command channel is full.
+			select {
+			case el.ch <- handoffReq{}:
+			default:
+			}
+		}
+		return
+	}
+	select {
+	case el.ch <- v:
+	default:
+		atomic.AddInt64(&el.svr.stats.handoffOverflows, 1)
+		el.ch <- v
+	}
+}
+
+// loopHandoff takes the items of the handoff queue of the event-loop, if any.
+func (el *eventloop) loopHandoff() error {
+	q := el.handoff
+	if q == nil || atomic.LoadInt32(&q.pending) == 0 {
+		return nil
+	}
+	atomic.StoreInt32(&q.pending, 0)
+	q.mu.Lock()
+	items := q.items
+	q.items = nil
+	q.mu.Unlock()
+	for _, v := range items {
+		var err error
+		switch v := v.(type) {
+		case *stdConn:
+			err = el.loopAccept(v)
+		case *udpIn:
+			err = el.loopReadUDP(v.c)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// len returns the number of items in the queue.
+func (q *handoffQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
@@ -0,0 +1,24 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+// HandshakeCodec is a codec which negotiates its protocol before the connection carries frames, e.g. by a preface
+// or an authentication exchange, see Options.HandshakeTimeout. The connections of the other codecs have completed
+// their handshake once the first frame has been decoded.
+type HandshakeCodec interface {
+	ICodec
+
+	// Handshaken reports whether the connection has completed the negotiation, it is asked on the event-loop
+	// whenever the connection has decoded its inbound data until it reports true.
+	Handshaken(c Conn) bool
+}
+
+// handshaken reports whether the connection has completed its handshake by its codec, see HandshakeCodec.
+func handshaken(c Conn, codec ICodec, framesDecoded int64) bool {
+	if hc, ok := codec.(HandshakeCodec); ok {
+		return hc.Handshaken(c)
+	}
+	return framesDecoded > 0
+}
@@ -0,0 +1,128 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// defaultFallbackDelay is the delay before the next address is raced when Dialer.FallbackDelay is zero,
+// the same as the one of net.Dialer.
+const defaultFallbackDelay = 300 * time.Millisecond
+
+// dialAddrs dials the resolved addresses of a host Happy Eyeballs style (RFC 8305), as net.Dialer does for the
+// host names it resolves itself: the addresses are interleaved by family, the next attempt starts as soon as
+// the previous one fails or once Dialer.FallbackDelay has passed, and the first connection established wins
+// while the other attempts are canceled. The addresses are dialed one after another if FallbackDelay is
+// negative and for the networks other than TCP.
+func dialAddrs(dialer *net.Dialer, network string, ips []string, port string) (net.Conn, error) {
+	if len(ips) == 1 || dialer.FallbackDelay < 0 || !strings.HasPrefix(network, "tcp") {
+		var err error
+		for _, ip := range ips {
+			var conn net.Conn
+			if conn, err = dialer.Dial(network, net.JoinHostPort(ip, port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+
+	ips = interleaveFamilies(ips)
+	delay := dialer.FallbackDelay
+	if delay == 0 {
+		delay = defaultFallbackDelay
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(ips)) // the losers never block
+	next, pending := 0, 0
+	start := func() {
+		addr := net.JoinHostPort(ips[next], port)
+		next++
+		pending++
+		go func() {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			results <- result{conn, err}
+		}()
+	}
+	start()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var firstErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				cancel()
+				// Close the connections of the attempts which have got through nonetheless.
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if r := <-results; r.conn != nil {
+							_ = r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(ips) {
+				// Do not wait for the delay after a failure.
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				start()
+				timer.Reset(delay)
+			}
+		case <-timer.C:
+			if next < len(ips) {
+				start()
+				timer.Reset(delay)
+			}
+		}
+	}
+	return nil, firstErr
+}
+
+// interleaveFamilies reorders the addresses so that the families alternate, starting with the family of the first
+// address and keeping the order within each family.
+func interleaveFamilies(ips []string) []string {
+	isIPv4 := func(ip string) bool {
+		parsed := net.ParseIP(ip)
+		return parsed != nil && parsed.To4() != nil
+	}
+	var primary, fallback []string
+	for _, ip := range ips {
+		if isIPv4(ip) == isIPv4(ips[0]) {
+			primary = append(primary, ip)
+		} else {
+			fallback = append(fallback, ip)
+		}
+	}
+	interleaved := make([]string, 0, len(ips))
+	for i := 0; i < len(primary) || i < len(fallback); i++ {
+		if i < len(primary) {
+			interleaved = append(interleaved, primary[i])
+		}
+		if i < len(fallback) {
+			interleaved = append(interleaved, fallback[i])
+		}
+	}
+	return interleaved
+}
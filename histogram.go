@@ -0,0 +1,108 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "github.com/panlibin/gnet/internal"
+
+// Histogram is a snapshot of a log-linear histogram in the style of HDR histograms, the values are bucketed
+// by powers of two each split into 8 linear sub-buckets, so the quantiles have a relative error of at most 1/8.
+type Histogram struct {
+	// Count is the number of the recorded values.
+	Count int64
+
+	// Sum is the sum of the recorded values.
+	Sum int64
+
+	// Max is the largest recorded value.
+	Max int64
+
+	counts []int64
+}
+
+func newHistogram(h *internal.Histogram) Histogram {
+	counts, count, sum, max := h.Snapshot()
+	return Histogram{Count: count, Sum: sum, Max: max, counts: counts}
+}
+
+// Mean returns the mean of the recorded values.
+func (h Histogram) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return float64(h.Sum) / float64(h.Count)
+}
+
+// Quantile returns the value below which the given fraction of the recorded values fall, e.g. 0.99 for the 99th
+// percentile, it is the upper bound of the bucket holding the quantile capped by Max.
+func (h Histogram) Quantile(q float64) int64 {
+	var total int64
+	for _, n := range h.counts {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := int64(q*float64(total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, n := range h.counts {
+		if seen += n; seen >= rank {
+			_, upper := internal.HistogramBucketBounds(i)
+			if upper > h.Max {
+				upper = h.Max
+			}
+			return upper
+		}
+	}
+	return h.Max
+}
+
+// LoopStats are the histograms of an event-loop, which are recorded when Options.LoopMetrics is set.
+// They tell whether the latency comes from an overloaded event-loop: the jobs and events wait long in
+// an overloaded event-loop while the network is slow.
+type LoopStats struct {
+	// Index is the index of the event-loop, negative for the loops accepting connections,
+	// -1-i for the i-th acceptor counting from zero, the main reactor being the first one.
+	Index int
+
+	// QueueDepth is the histogram of the number of asynchronous jobs pending, e.g. AsyncWrite and Wake,
+	// whenever the event-loop runs them, with the net transport the pending reads are counted as well.
+	QueueDepth Histogram
+
+	// JobLatency is the histogram of the time in nanoseconds the asynchronous jobs wait in the queue.
+	JobLatency Histogram
+
+	// EventLatency is the histogram of the time in nanoseconds from polling the I/O events to invoking their
+	// callbacks, which grows as the callbacks of the events polled together take longer.
+	// It is not recorded with the net transport, nor is JobLatency.
+	EventLatency Histogram
+}
+
+func newLoopStats(idx int, m *internal.LoopMetrics) LoopStats {
+	return LoopStats{
+		Index:        idx,
+		QueueDepth:   newHistogram(&m.QueueDepth),
+		JobLatency:   newHistogram(&m.JobLatency),
+		EventLatency: newHistogram(&m.EventLatency),
+	}
+}
+
+// LoopStats returns the histograms of the event-loops, nil unless Options.LoopMetrics is set.
+func (s *GServer) LoopStats() []LoopStats {
+	if s.s == nil || !s.s.opts.LoopMetrics {
+		return nil
+	}
+	var stats []LoopStats
+	s.s.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+		stats = append(stats, newLoopStats(el.idx, el.metrics))
+		return true
+	})
+	for i, m := range s.s.acceptorMetrics() {
+		stats = append(stats, newLoopStats(-1-i, m))
+	}
+	return stats
+}
@@ -0,0 +1,36 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"fmt"
+	"net"
+)
+
+// ICMPError is an ICMP error reported for the datagrams sent to a peer of a UDP server, see EventHandler.OnError.
+type ICMPError struct {
+	// Err is the error, e.g. syscall.ECONNREFUSED for a port unreachable and syscall.EHOSTUNREACH for
+	// a host unreachable or a TTL exceeded.
+	Err error
+
+	// Type and Code are those of the ICMP (ICMPv6) message.
+	Type, Code uint8
+
+	// Offender is the address of the node which has reported the error, e.g. the router on which the TTL
+	// has been exceeded, nil if it is unknown.
+	Offender net.IP
+}
+
+func (e *ICMPError) Error() string {
+	if e.Offender != nil {
+		return fmt.Sprintf("icmp type %d code %d from %v: %v", e.Type, e.Code, e.Offender, e.Err)
+	}
+	return fmt.Sprintf("icmp type %d code %d: %v", e.Type, e.Code, e.Err)
+}
+
+// Unwrap returns the underlying error, so that errors.Is(err, syscall.ECONNREFUSED) works.
+func (e *ICMPError) Unwrap() error {
+	return e.Err
+}
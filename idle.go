@@ -0,0 +1,35 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "time"
+
+const defaultIdleSleepInterval = 100 * time.Microsecond
+
+// IdleStrategy decides how an event-loop waits for network-events while it is idle, it backs off progressively
+// from spinning over yielding and sleeping to blocking, so that the latency-focused deployments stay hot for a while
+// and the others do not burn the CPU. The zero value blocks right away, which is the default.
+type IdleStrategy struct {
+	// Spin is how long the event-loop spins on non-blocking polls first, see also BusyPoll.Budget.
+	Spin time.Duration
+
+	// Yield is how long the event-loop polls without blocking and yields the processor between the polls next.
+	Yield time.Duration
+
+	// Sleep is how long the event-loop polls without blocking and sleeps between the polls next.
+	Sleep time.Duration
+
+	// SleepInterval is the sleep between the polls, defaults to 100µs.
+	SleepInterval time.Duration
+
+	// MaxWait is the timeout of the epoll_wait/kevent which the event-loop blocks in at last, zero means
+	// no timeout. A timeout wakes the event-loop up periodically even if there is nothing to do.
+	MaxWait time.Duration
+}
+
+// backsOff reports whether the event-loop polls without blocking before it blocks.
+func (is IdleStrategy) backsOff() bool {
+	return is.Spin > 0 || is.Yield > 0 || is.Sleep > 0
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "sync"
+
+// Interner deduplicates the strings which are repeated across connections, e.g. user agents or tenant IDs kept
+// in the contexts of the connections, so that a server holding millions of connections keeps one copy of each.
+// The strings are reference counted and dropped once the last reference is released. It is safe for concurrent
+// use.
+type Interner struct {
+	mu      sync.Mutex
+	strings map[string]internEntry
+}
+
+type internEntry struct {
+	s    string
+	refs int
+}
+
+// internRef is a reference to an interned string held by a connection until it is closed or detached.
+type internRef struct {
+	in *Interner
+	s  string
+}
+
+// NewInterner creates an empty Interner.
+func NewInterner() *Interner {
+	return &Interner{strings: make(map[string]internEntry)}
+}
+
+// Intern returns the interned copy of s and takes a reference to it which is given back by Release.
+func (in *Interner) Intern(s string) string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	e, ok := in.strings[s]
+	if !ok {
+		e.s = s
+	}
+	e.refs++
+	in.strings[e.s] = e
+	return e.s
+}
+
+// InternBytes is like Intern but takes the bytes, e.g. of a frame, it doesn't allocate if the string is interned.
+func (in *Interner) InternBytes(b []byte) string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	e, ok := in.strings[string(b)]
+	if !ok {
+		e.s = string(b)
+	}
+	e.refs++
+	in.strings[e.s] = e
+	return e.s
+}
+
+// Release gives back a reference to s taken by Intern or InternBytes, the string is dropped from the Interner
+// with its last reference. The string itself stays valid for whoever still uses it.
+func (in *Interner) Release(s string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	e, ok := in.strings[s]
+	if !ok {
+		return
+	}
+	if e.refs--; e.refs == 0 {
+		delete(in.strings, s)
+		return
+	}
+	in.strings[s] = e
+}
+
+// Len returns the number of the interned strings.
+func (in *Interner) Len() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return len(in.strings)
+}
+
+// Attach interns the bytes for the connection, the reference is held by the connection and released when it
+// is closed or detached, so the string can be kept in the context of the connection without being released
+// by hand. It must be invoked on the event-loop of the connection, e.g. in OnOpened or React.
+//
+// The string of a UDP connection, or of a connection which doesn't belong to a server, is not held.
+func (in *Interner) Attach(c Conn, b []byte) string {
+	s := in.InternBytes(b)
+	if h, ok := c.(interface {
+		holdInterned(ref internRef) bool
+	}); !ok || !h.holdInterned(internRef{in, s}) {
+		in.Release(s)
+	}
+	return s
+}
+
+// releaseInterned gives back the references held by a connection.
+func releaseInterned(refs []internRef) {
+	for _, ref := range refs {
+		ref.in.Release(ref.s)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// histogramSubBits is the number of bits of the linear sub-buckets of each power of two, which bounds
+// the relative error of the recorded values to 1/8.
+const histogramSubBits = 3
+
+// HistogramBuckets is the number of buckets of a Histogram, which covers all the non-negative int64 values.
+const HistogramBuckets = (64 - histogramSubBits) << histogramSubBits
+
+// Histogram is a log-linear histogram of non-negative values in the style of HDR histograms, it is recorded
+// by one goroutine and may be read by others concurrently.
+type Histogram struct {
+	counts          [HistogramBuckets]int64
+	count, sum, max int64
+}
+
+// Record records a value, the negative values are recorded as zero.
+func (h *Histogram) Record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	atomic.AddInt64(&h.counts[histogramIndex(v)], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, v)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if v <= max || atomic.CompareAndSwapInt64(&h.max, max, v) {
+			return
+		}
+	}
+}
+
+// Snapshot returns the counts of the buckets along with the number, the sum and the maximum of the values.
+func (h *Histogram) Snapshot() (counts []int64, count, sum, max int64) {
+	counts = make([]int64, HistogramBuckets)
+	for i := range counts {
+		counts[i] = atomic.LoadInt64(&h.counts[i])
+	}
+	return counts, atomic.LoadInt64(&h.count), atomic.LoadInt64(&h.sum), atomic.LoadInt64(&h.max)
+}
+
+// HistogramBucketBounds returns the smallest and the largest values of the i-th bucket.
+func HistogramBucketBounds(i int) (lower, upper int64) {
+	lower = histogramLowerBound(i)
+	if i == HistogramBuckets-1 {
+		return lower, 1<<63 - 1
+	}
+	return lower, histogramLowerBound(i+1) - 1
+}
+
+func histogramIndex(v int64) int {
+	if v < 1<<histogramSubBits {
+		return int(v)
+	}
+	e := bits.Len64(uint64(v)) - 1
+	return (e-histogramSubBits+1)<<histogramSubBits | int(v>>uint(e-histogramSubBits))&(1<<histogramSubBits-1)
+}
+
+func histogramLowerBound(i int) int64 {
+	if i < 1<<histogramSubBits {
+		return int64(i)
+	}
+	e := i>>histogramSubBits + histogramSubBits - 1
+	return int64(1<<histogramSubBits|i&(1<<histogramSubBits-1)) << uint(e-histogramSubBits)
+}
+
+// LoopMetrics are the histograms recorded by an event-loop.
+type LoopMetrics struct {
+	// QueueDepth is the number of asynchronous jobs pending whenever the event-loop runs them.
+	QueueDepth Histogram
+
+	// JobLatency is the time in nanoseconds the asynchronous jobs wait in the queue.
+	JobLatency Histogram
+
+	// EventLatency is the time in nanoseconds from polling the events to invoking their callbacks.
+	EventLatency Histogram
+}
+
+// TimeJob wraps the job to record the time it waits in the queue.
+func (m *LoopMetrics) TimeJob(job Job) Job {
+	queued := time.Now()
+	return func() error {
+		m.JobLatency.Record(int64(time.Since(queued)))
+		return job()
+	}
+}
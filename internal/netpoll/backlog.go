@@ -0,0 +1,21 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package netpoll
+
+import "errors"
+
+// ListenBacklog returns the number of connections waiting in the accept queue of a listening TCP socket
+// and the maximum length of the queue.
+func ListenBacklog(fd int) (queued, limit int, err error) {
+	return 0, 0, errors.New("accept queue length is not available on this platform")
+}
+
+// ListenDrops returns the system-wide number of times the accept queue of a listening socket has overflowed
+// and the number of SYNs dropped by listening sockets.
+func ListenDrops() (overflows, drops int64, err error) {
+	return 0, 0, errors.New("listen drops are not available on this platform")
+}
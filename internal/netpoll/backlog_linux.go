@@ -0,0 +1,64 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package netpoll
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// ListenBacklog returns the number of connections waiting in the accept queue of a listening TCP socket
+// and the maximum length of the queue.
+func ListenBacklog(fd int) (queued, limit int, err error) {
+	info, err := unix.GetsockoptTCPInfo(fd, unix.IPPROTO_TCP, unix.TCP_INFO)
+	if err != nil {
+		return 0, 0, err
+	}
+	// For listening sockets the kernel reports the current accept queue length in tcpi_unacked
+	// and the backlog in tcpi_sacked.
+	return int(info.Unacked), int(info.Sacked), nil
+}
+
+// ListenDrops returns the system-wide number of times the accept queue of a listening socket has overflowed
+// and the number of SYNs dropped by listening sockets, which are reported in /proc/net/netstat.
+func ListenDrops() (overflows, drops int64, err error) {
+	f, err := os.Open("/proc/net/netstat")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	// The counters come in pairs of lines, the names of the counters followed by their values.
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		names := strings.Fields(scanner.Text())
+		if !scanner.Scan() {
+			break
+		}
+		values := strings.Fields(scanner.Text())
+		if len(names) == 0 || names[0] != "TcpExt:" || len(values) != len(names) {
+			continue
+		}
+		for i, name := range names {
+			switch name {
+			case "ListenOverflows":
+				overflows, _ = strconv.ParseInt(values[i], 10, 64)
+			case "ListenDrops":
+				drops, _ = strconv.ParseInt(values[i], 10, 64)
+			}
+		}
+		return overflows, drops, nil
+	}
+	if err = scanner.Err(); err == nil {
+		err = errors.New("TcpExt counters are missing in /proc/net/netstat")
+	}
+	return 0, 0, err
+}
@@ -0,0 +1,18 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package netpoll
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// BindToDevice binds a socket to a network interface or a VRF device by SO_BINDTODEVICE.
+func BindToDevice(fd int, device string) error {
+	return os.NewSyscallError("setsockopt", unix.BindToDevice(fd, device))
+}
@@ -0,0 +1,14 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package netpoll
+
+import "errors"
+
+// SetBusyPoll sets up SO_BUSY_POLL of a socket, the microseconds to busy poll the device queue on blocking receives.
+func SetBusyPoll(fd, usec int) error {
+	return errors.New("SO_BUSY_POLL is not available on this platform")
+}
@@ -0,0 +1,18 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package netpoll
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetBusyPoll sets up SO_BUSY_POLL of a socket, the microseconds to busy poll the device queue on blocking receives.
+func SetBusyPoll(fd, usec int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_BUSY_POLL, usec))
+}
@@ -0,0 +1,38 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package netpoll
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// DatagramMeta is the ancillary data of a datagram read by RecvmsgMeta.
+type DatagramMeta struct {
+	// Dst is the original destination, nil unless the socket has been set up by SetTransparent.
+	Dst *net.UDPAddr
+
+	// TOS is the IP_TOS (IPV6_TCLASS) byte, -1 unless the socket has been set up by SetRecvTOS.
+	TOS int
+}
+
+// QueuedErr is an error of a datagram read by RecvErr.
+type QueuedErr struct {
+	// To is the destination of the datagram.
+	To unix.Sockaddr
+
+	// Errno is the error, e.g. ECONNREFUSED for an ICMP port unreachable.
+	Errno unix.Errno
+
+	// ICMP reports whether the error comes from an ICMP (ICMPv6) message of the type and code.
+	ICMP       bool
+	Type, Code uint8
+
+	// Offender is the address of the node which has reported the error, nil if it is unknown.
+	Offender net.IP
+}
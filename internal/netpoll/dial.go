@@ -0,0 +1,36 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package netpoll
+
+import (
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// DialUDP opens a non-blocking UDP socket connected to the address.
+func DialUDP(addr *net.UDPAddr) (int, error) {
+	sa, family := UDPAddrToSockaddr(addr)
+	if sa == nil {
+		return -1, &net.AddrError{Err: "unsupported address", Addr: addr.String()}
+	}
+	fd, err := unix.Socket(family, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return -1, os.NewSyscallError("socket", err)
+	}
+	unix.CloseOnExec(fd)
+	if err = unix.SetNonblock(fd, true); err != nil {
+		_ = unix.Close(fd)
+		return -1, os.NewSyscallError("setnonblock", err)
+	}
+	if err = unix.Connect(fd, sa); err != nil {
+		_ = unix.Close(fd)
+		return -1, os.NewSyscallError("connect", err)
+	}
+	return fd, nil
+}
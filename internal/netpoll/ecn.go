@@ -0,0 +1,21 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package netpoll
+
+import "errors"
+
+var errECNNotAvailable = errors.New("ECN is not available on this platform")
+
+// SetRecvTOS sets up the socket to read the TOS bytes of the datagrams, it is only available on linux.
+func SetRecvTOS(fd int) error {
+	return errECNNotAvailable
+}
+
+// TCPECN reports whether ECN has been negotiated on a TCP connection, it is only available on linux.
+func TCPECN(fd int) (bool, error) {
+	return false, errECNNotAvailable
+}
@@ -0,0 +1,14 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package netpoll
+
+import "golang.org/x/sys/unix"
+
+// SendmsgTOS sends a datagram with the TOS byte, it is only available on linux.
+func SendmsgTOS(fd int, p []byte, to unix.Sockaddr, tos int) error {
+	return errECNNotAvailable
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package netpoll
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// tcpiOptECN is TCPI_OPT_ECN of the tcpi_options of TCP_INFO.
+const tcpiOptECN = 8
+
+// SetRecvTOS sets up IP_RECVTOS (IPV6_RECVTCLASS) so that the TOS bytes of the datagrams are read
+// by RecvmsgMeta.
+func SetRecvTOS(fd int) error {
+	domain, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_DOMAIN)
+	if err != nil {
+		return os.NewSyscallError("getsockopt", err)
+	}
+	// IPv4 datagrams received by a dual-stack socket come with IP_TOS too.
+	if err = unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_RECVTOS, 1); err == nil && domain == unix.AF_INET6 {
+		err = unix.SetsockoptInt(fd, unix.SOL_IPV6, unix.IPV6_RECVTCLASS, 1)
+	}
+	return os.NewSyscallError("setsockopt", err)
+}
+
+// SendmsgTOS sends a datagram with the TOS byte, which is IPV6_TCLASS for an IPv6 destination.
+func SendmsgTOS(fd int, p []byte, to unix.Sockaddr, tos int) error {
+	level, typ := unix.SOL_IP, unix.IP_TOS
+	if _, ok := to.(*unix.SockaddrInet6); ok {
+		level, typ = unix.SOL_IPV6, unix.IPV6_TCLASS
+	}
+	oob := make([]byte, unix.CmsgSpace(4))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level, h.Type = int32(level), int32(typ)
+	h.SetLen(unix.CmsgLen(4))
+	*(*int32)(unsafe.Pointer(&oob[unix.CmsgLen(0)])) = int32(tos)
+	return os.NewSyscallError("sendmsg", unix.Sendmsg(fd, p, oob, to, 0))
+}
+
+// TCPECN reports whether ECN has been negotiated on a TCP connection by TCP_INFO.
+func TCPECN(fd int) (bool, error) {
+	info, err := unix.GetsockoptTCPInfo(fd, unix.IPPROTO_TCP, unix.TCP_INFO)
+	if err != nil {
+		return false, os.NewSyscallError("getsockopt", err)
+	}
+	return info.Options&tcpiOptECN != 0, nil
+}
@@ -8,7 +8,10 @@
 package netpoll
 
 import (
-	"log"
+	"errors"
+	"os"
+	"sync"
+	"time"
 	"unsafe"
 
 	"github.com/panlibin/gnet/internal"
@@ -17,12 +20,26 @@ import (
 
 // Poller represents a poller which is in charge of monitoring file-descriptors.
 type Poller struct {
+	wakeups       int64  // wake-ups of the poller by Trigger, accessed atomically
+	coalesced     int64  // triggers which have not needed to wake the poller up, accessed atomically
+	wakeState     int32  // asleep or awake, accessed atomically, see trigger
 	fd            int    // epoll fd
 	wfd           int    // wake fd
 	wfdBuf        []byte // wfd buffer to read packet
+	timers        internal.TimerQueue
 	asyncJobQueue internal.AsyncJobQueue
+	urgentJobs    internal.AsyncJobQueue // run ahead of asyncJobQueue, see TriggerUrgent
+	metrics       *internal.LoopMetrics  // nil if the metrics are disabled
+	jobErrors     func(err error) error  // handles the errors of the jobs, see SetJobErrorHandler
+	idle          IdleStrategy           // how to wait for network-events, see SetIdleStrategy
+	closeMu       sync.RWMutex           // keeps Trigger from writing to the wake fd once it has been closed
+	closed        bool                   // guarded by closeMu
+	fds           fdTable                // file-descriptors registered with the poller
 }
 
+// ErrPollerClosed occurs when triggering a poller which has been closed.
+var ErrPollerClosed = errors.New("poller has been closed")
+
 // OpenPoller instantiates a poller.
 func OpenPoller() (*Poller, error) {
 	poller := new(Poller)
@@ -39,18 +56,29 @@ func OpenPoller() (*Poller, error) {
 	poller.wfd = int(r0)
 	poller.wfdBuf = make([]byte, 8)
 	if err = poller.AddRead(poller.wfd); err != nil {
+		_ = unix.Close(poller.wfd)
+		_ = unix.Close(epollFD)
 		return nil, err
 	}
 	poller.asyncJobQueue = internal.NewAsyncJobQueue()
+	poller.urgentJobs = internal.NewAsyncJobQueue()
 	return poller, nil
 }
 
-// Close closes the poller.
+// Close closes the poller, it is a no-op if the poller has been closed. The descriptors of a closed poller
+// may be reused right away, so it is never woken up again, see Trigger.
 func (p *Poller) Close() error {
-	if err := unix.Close(p.wfd); err != nil {
-		return err
+	p.closeMu.Lock()
+	defer p.closeMu.Unlock()
+	if p.closed {
+		return nil
 	}
-	return unix.Close(p.fd)
+	p.closed = true
+	err := unix.Close(p.wfd)
+	if err1 := unix.Close(p.fd); err == nil {
+		err = err1
+	}
+	return err
 }
 
 // Make the endianness of bytes compatible with more linux OSs under different processor-architectures,
@@ -60,28 +88,38 @@ var (
 	b        = (*(*[8]byte)(unsafe.Pointer(&u)))[:]
 )
 
-// Trigger wakes up the poller blocked in waiting for network-events and runs jobs in asyncJobQueue.
+// Trigger wakes up the poller blocked in waiting for network-events and runs jobs in asyncJobQueue,
+// it fails once the poller has been closed.
 func (p *Poller) Trigger(job internal.Job) error {
-	if p.asyncJobQueue.Push(job) == 1 {
-		_, err := unix.Write(p.wfd, b)
-		return err
-	}
-	return nil
+	return p.trigger(&p.asyncJobQueue, job)
+}
+
+// wake wakes up the poller blocked in waiting for network-events, it must be invoked with closeMu held.
+func (p *Poller) wake() error {
+	_, err := unix.Write(p.wfd, b)
+	return err
 }
 
-// Polling blocks the current goroutine, waiting for network-events.
-func (p *Poller) Polling(callback func(fd int, ev uint32) error) (err error) {
+// Polling blocks the current goroutine, waiting for network-events, until the callback or a job returns an error
+// or waiting fails, e.g. as the poller has been closed.
+func (p *Poller) Polling(callback func(fd int, ev IOEvent) error) (err error) {
 	el := newEventList(InitEvents)
 	var wakenUp bool
 	for {
-		n, err0 := unix.EpollWait(p.fd, el.events, -1)
+		n, err0 := p.wait(el.events)
 		if err0 != nil && err0 != unix.EINTR {
-			log.Println(err0)
-			continue
+			return os.NewSyscallError("epoll_wait", err0)
+		}
+		var polled time.Time
+		if p.metrics != nil {
+			polled = time.Now()
 		}
 		for i := 0; i < n; i++ {
 			if fd := int(el.events[i].Fd); fd != p.wfd {
-				if err = callback(fd, el.events[i].Events); err != nil {
+				if p.metrics != nil {
+					p.metrics.EventLatency.Record(int64(time.Since(polled)))
+				}
+				if err = callback(fd, epollIOEvents(el.events[i].Events)); err != nil {
 					return
 				}
 			} else {
@@ -89,50 +127,155 @@ func (p *Poller) Polling(callback func(fd int, ev uint32) error) (err error) {
 				_, _ = unix.Read(p.wfd, p.wfdBuf)
 			}
 		}
-		if wakenUp {
-			wakenUp = false
-			if err = p.asyncJobQueue.ForEach(); err != nil {
+		if err = p.runPending(wakenUp); err != nil {
+			return
+		}
+		wakenUp = false
+		if n == el.size {
+			el.increase()
+		}
+	}
+}
+
+// PollingBatch blocks the current goroutine, waiting for network-events like Polling, but it hands the whole batch
+// of the ready events over to the callback at once, which saves a function call per event at high event rates and
+// lets the callback sort or coalesce the events by file-descriptors. The batch is reused by the next wait,
+// and the latencies of the events are left to the callback to record.
+func (p *Poller) PollingBatch(callback func(events []Event) error) (err error) {
+	el := newEventList(InitEvents)
+	batch := make([]Event, 0, InitEvents)
+	var wakenUp bool
+	for {
+		n, err0 := p.wait(el.events)
+		if err0 != nil && err0 != unix.EINTR {
+			return os.NewSyscallError("epoll_wait", err0)
+		}
+		batch = batch[:0]
+		for i := 0; i < n; i++ {
+			if fd := int(el.events[i].Fd); fd != p.wfd {
+				batch = append(batch, Event{Fd: fd, Events: epollIOEvents(el.events[i].Events)})
+			} else {
+				wakenUp = true
+				_, _ = unix.Read(p.wfd, p.wfdBuf)
+			}
+		}
+		if len(batch) > 0 {
+			if err = callback(batch); err != nil {
 				return
 			}
 		}
+		if err = p.runPending(wakenUp); err != nil {
+			return
+		}
+		wakenUp = false
 		if n == el.size {
 			el.increase()
 		}
 	}
 }
 
+// runPending runs the asynchronous jobs if the poller has been woken up, and then the expired timers.
+func (p *Poller) runPending(wakenUp bool) error {
+	if wakenUp {
+		if err := p.runJobs(); err != nil {
+			return err
+		}
+	}
+	if p.timers.Len() > 0 {
+		return p.expireTimers()
+	}
+	return nil
+}
+
+// wait waits for network-events, it polls without blocking by the idle strategy before it blocks.
+func (p *Poller) wait(events []unix.EpollEvent) (int, error) {
+	if p.backsOff() {
+		start, timeout := time.Now(), p.timers.Timeout()
+		for {
+			if n, err := unix.EpollWait(p.fd, events, 0); n != 0 || err != nil {
+				return n, err
+			}
+			if !p.backOff(start, timeout) {
+				break
+			}
+		}
+	}
+	return unix.EpollWait(p.fd, events, p.waitMsec())
+}
+
+// waitMsec returns the timeout in milliseconds for epoll_wait according to the pending timers.
+func (p *Poller) waitMsec() int {
+	d := p.waitTimeout()
+	if d < 0 {
+		return -1
+	}
+	return int((d + time.Millisecond - 1) / time.Millisecond)
+}
+
 const (
-	readEvents      = unix.EPOLLPRI | unix.EPOLLIN
-	writeEvents     = unix.EPOLLOUT
-	readWriteEvents = readEvents | writeEvents
+	readEvents  = unix.EPOLLPRI | unix.EPOLLIN
+	writeEvents = unix.EPOLLOUT
 )
 
 // AddReadWrite registers the given file-descriptor with readable and writable events to the poller.
 func (p *Poller) AddReadWrite(fd int) error {
-	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{Fd: int32(fd), Events: readWriteEvents})
+	return p.fds.add(fd, fdReadWrite, p.ctlAdd)
 }
 
 // AddRead registers the given file-descriptor with readable event to the poller.
 func (p *Poller) AddRead(fd int) error {
-	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{Fd: int32(fd), Events: readEvents})
+	return p.fds.add(fd, fdRead, p.ctlAdd)
 }
 
 // AddWrite registers the given file-descriptor with writable event to the poller.
 func (p *Poller) AddWrite(fd int) error {
-	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{Fd: int32(fd), Events: writeEvents})
+	return p.fds.add(fd, fdWrite, p.ctlAdd)
 }
 
 // ModRead renews the given file-descriptor with readable event in the poller.
 func (p *Poller) ModRead(fd int) error {
-	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{Fd: int32(fd), Events: readEvents})
+	return p.fds.mod(fd, fdRead, p.ctlMod)
 }
 
 // ModReadWrite renews the given file-descriptor with readable and writable events in the poller.
 func (p *Poller) ModReadWrite(fd int) error {
-	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{Fd: int32(fd), Events: readWriteEvents})
+	return p.fds.mod(fd, fdReadWrite, p.ctlMod)
+}
+
+// ModWrite renews the given file-descriptor with writable event in the poller.
+func (p *Poller) ModWrite(fd int) error {
+	return p.fds.mod(fd, fdWrite, p.ctlMod)
+}
+
+// ModNone renews the given file-descriptor with no events in the poller, which keeps it registered
+// but stops reporting readable and writable events.
+func (p *Poller) ModNone(fd int) error {
+	return p.fds.mod(fd, fdNone, p.ctlMod)
 }
 
 // Delete removes the given file-descriptor from the poller.
 func (p *Poller) Delete(fd int) error {
+	return p.fds.del(fd, p.ctlDel)
+}
+
+func epollEvents(ev fdEvents) (events uint32) {
+	if ev&fdRead != 0 {
+		events |= readEvents
+	}
+	if ev&fdWrite != 0 {
+		events |= writeEvents
+	}
+	return
+}
+
+func (p *Poller) ctlAdd(fd int, ev fdEvents) error {
+	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{Fd: int32(fd), Events: epollEvents(ev)})
+}
+
+func (p *Poller) ctlMod(fd int, _, ev fdEvents) error {
+	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{Fd: int32(fd), Events: epollEvents(ev)})
+}
+
+func (p *Poller) ctlDel(fd int, _ fdEvents) error {
 	return unix.EpollCtl(p.fd, unix.EPOLL_CTL_DEL, fd, nil)
 }
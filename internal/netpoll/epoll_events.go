@@ -8,17 +8,25 @@ package netpoll
 
 import "golang.org/x/sys/unix"
 
-const (
-	// InitEvents represents the initial length of poller event-list.
-	InitEvents = 128
-	// ErrEvents represents exceptional events that are not read/write, like socket being closed,
-	// reading/writing from/to a closed socket, etc.
-	ErrEvents = unix.EPOLLERR | unix.EPOLLHUP | unix.EPOLLRDHUP
-	// OutEvents combines EPOLLOUT event and some exceptional events.
-	OutEvents = ErrEvents | unix.EPOLLOUT
-	// InEvents combines EPOLLIN/EPOLLPRI events and some exceptional events.
-	InEvents = ErrEvents | unix.EPOLLIN | unix.EPOLLPRI
-)
+// InitEvents represents the initial length of poller event-list.
+const InitEvents = 128
+
+// epollIOEvents translates the events of epoll.
+func epollIOEvents(ev uint32) (e IOEvent) {
+	if ev&(unix.EPOLLIN|unix.EPOLLPRI) != 0 {
+		e |= Readable
+	}
+	if ev&unix.EPOLLOUT != 0 {
+		e |= Writable
+	}
+	if ev&(unix.EPOLLHUP|unix.EPOLLRDHUP) != 0 {
+		e |= Hup
+	}
+	if ev&unix.EPOLLERR != 0 {
+		e |= Error
+	}
+	return
+}
 
 type eventList struct {
 	size   int
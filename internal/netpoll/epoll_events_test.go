@@ -0,0 +1,41 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package netpoll
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestEpollIOEvents(t *testing.T) {
+	for _, c := range []struct {
+		ev   uint32
+		want IOEvent
+	}{
+		{0, 0},
+		{unix.EPOLLIN, Readable},
+		{unix.EPOLLPRI, Readable},
+		{unix.EPOLLOUT, Writable},
+		{unix.EPOLLIN | unix.EPOLLOUT, Readable | Writable},
+		{unix.EPOLLHUP, Hup},
+		{unix.EPOLLRDHUP, Hup},
+		{unix.EPOLLIN | unix.EPOLLRDHUP, Readable | Hup},
+		{unix.EPOLLERR, Error},
+		{unix.EPOLLERR | unix.EPOLLHUP, Error | Hup},
+		{unix.EPOLLIN | unix.EPOLLOUT | unix.EPOLLERR | unix.EPOLLHUP, Readable | Writable | Error | Hup},
+	} {
+		if got := epollIOEvents(c.ev); got != c.want {
+			t.Errorf("epollIOEvents(%#x) = %04b, want %04b", c.ev, got, c.want)
+		}
+	}
+
+	// The read and write events the poller registers are the ones it translates back.
+	if got := epollIOEvents(epollEvents(fdReadWrite)); got != Readable|Writable {
+		t.Errorf("got %04b for the registered events, want readable and writable", got)
+	}
+}
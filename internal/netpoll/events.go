@@ -0,0 +1,38 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package netpoll
+
+// IOEvent is the set of the readiness events of a file-descriptor, epoll and kqueue translate their own events
+// into it so that the event-loops are the same on every platform.
+type IOEvent uint8
+
+const (
+	// Readable reports that the file-descriptor has data to read.
+	Readable IOEvent = 1 << iota
+	// Writable reports that the file-descriptor has room to write.
+	Writable
+	// Hup reports that the peer has hung up, there may be data left to read.
+	Hup
+	// Error reports that an error is pending on the file-descriptor.
+	Error
+)
+
+const (
+	// ErrEvents represents exceptional events that are not read/write, like socket being closed,
+	// reading/writing from/to a closed socket, etc.
+	ErrEvents = Hup | Error
+	// OutEvents combines the writable event and the exceptional events.
+	OutEvents = ErrEvents | Writable
+	// InEvents combines the readable event and the exceptional events.
+	InEvents = ErrEvents | Readable
+)
+
+// Event is a ready event of a file-descriptor, see PollingBatch.
+type Event struct {
+	Fd     int
+	Events IOEvent
+}
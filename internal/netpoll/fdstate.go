@@ -0,0 +1,105 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package netpoll
+
+import (
+	"errors"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// ErrFDRegistered occurs when adding a file-descriptor which is already registered with other events.
+	ErrFDRegistered = errors.New("file-descriptor is already registered with the poller")
+	// ErrFDNotRegistered occurs when renewing the events of a file-descriptor which is not registered.
+	ErrFDNotRegistered = errors.New("file-descriptor is not registered with the poller")
+)
+
+// fdEvents is the set of events a file-descriptor is registered for.
+type fdEvents uint8
+
+const (
+	fdRead fdEvents = 1 << iota
+	fdWrite
+
+	fdNone      fdEvents = 0
+	fdReadWrite          = fdRead | fdWrite
+)
+
+// fdTable is the state machine of the file-descriptors registered with a poller, which keeps the poller in sync
+// with the kernel: adding a file-descriptor twice for the same events, renewing it for the events it is already
+// registered for and deleting it twice are no-ops, adding it for other events or renewing a file-descriptor which
+// is not registered fail. A file-descriptor which the kernel reports as closed or unknown by EBADF or ENOENT is
+// dropped, so that its number can be registered again once it is reused. A file-descriptor must still be deleted
+// before it is closed, the kernel forgets it on close but the poller doesn't.
+type fdTable struct {
+	mu  sync.Mutex
+	fds map[int]fdEvents
+}
+
+// add registers the file-descriptor for the events by ctl, see fdTable.
+func (t *fdTable) add(fd int, ev fdEvents, ctl func(fd int, ev fdEvents) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cur, ok := t.fds[fd]
+	if ok && cur != ev {
+		return ErrFDRegistered
+	}
+	// The file-descriptor is added again even if it is registered for the same events, in case it has been
+	// closed without being deleted and its number has been reused since.
+	if err := ctl(fd, ev); err != nil {
+		if ok && err == unix.EEXIST {
+			return nil
+		}
+		return err
+	}
+	if t.fds == nil {
+		t.fds = make(map[int]fdEvents)
+	}
+	t.fds[fd] = ev
+	return nil
+}
+
+// mod renews the events of the file-descriptor by ctl, which is handed the events it is registered for,
+// see fdTable.
+func (t *fdTable) mod(fd int, ev fdEvents, ctl func(fd int, cur, ev fdEvents) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cur, ok := t.fds[fd]
+	switch {
+	case !ok:
+		return ErrFDNotRegistered
+	case cur == ev:
+		return nil
+	}
+	if err := ctl(fd, cur, ev); err != nil {
+		if err == unix.EBADF || err == unix.ENOENT {
+			delete(t.fds, fd)
+		}
+		return err
+	}
+	t.fds[fd] = ev
+	return nil
+}
+
+// del removes the file-descriptor by ctl, which is handed the events it is registered for, see fdTable.
+// A file-descriptor which has been closed in the meantime is removed by the kernel already, so EBADF and
+// ENOENT are not errors here.
+func (t *fdTable) del(fd int, ctl func(fd int, cur fdEvents) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cur, ok := t.fds[fd]
+	if !ok {
+		return nil
+	}
+	delete(t.fds, fd)
+	if err := ctl(fd, cur); err != nil && err != unix.EBADF && err != unix.ENOENT {
+		return err
+	}
+	return nil
+}
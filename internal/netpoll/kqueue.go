@@ -8,7 +8,10 @@
 package netpoll
 
 import (
-	"log"
+	"errors"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/panlibin/gnet/internal"
 	"golang.org/x/sys/unix"
@@ -16,10 +19,24 @@ import (
 
 // Poller represents a poller which is in charge of monitoring file-descriptors.
 type Poller struct {
+	wakeups       int64 // wake-ups of the poller by Trigger, accessed atomically
+	coalesced     int64 // triggers which have not needed to wake the poller up, accessed atomically
+	wakeState     int32 // asleep or awake, accessed atomically, see trigger
 	fd            int
+	timers        internal.TimerQueue
 	asyncJobQueue internal.AsyncJobQueue
+	urgentJobs    internal.AsyncJobQueue // run ahead of asyncJobQueue, see TriggerUrgent
+	metrics       *internal.LoopMetrics  // nil if the metrics are disabled
+	jobErrors     func(err error) error  // handles the errors of the jobs, see SetJobErrorHandler
+	idle          IdleStrategy           // how to wait for network-events, see SetIdleStrategy
+	closeMu       sync.RWMutex           // keeps Trigger from waking the kqueue up once it has been closed
+	closed        bool                   // guarded by closeMu
+	fds           fdTable                // file-descriptors registered with the poller
 }
 
+// ErrPollerClosed occurs when triggering a poller which has been closed.
+var ErrPollerClosed = errors.New("poller has been closed")
+
 // OpenPoller instantiates a poller.
 func OpenPoller() (*Poller, error) {
 	poller := new(Poller)
@@ -34,14 +51,23 @@ func OpenPoller() (*Poller, error) {
 		Flags:  unix.EV_ADD | unix.EV_CLEAR,
 	}}, nil, nil)
 	if err != nil {
+		_ = unix.Close(kfd)
 		return nil, err
 	}
 	poller.asyncJobQueue = internal.NewAsyncJobQueue()
+	poller.urgentJobs = internal.NewAsyncJobQueue()
 	return poller, nil
 }
 
-// Close closes the poller.
+// Close closes the poller, it is a no-op if the poller has been closed. The descriptor of a closed poller
+// may be reused right away, so it is never woken up again, see Trigger.
 func (p *Poller) Close() error {
+	p.closeMu.Lock()
+	defer p.closeMu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
 	return unix.Close(p.fd)
 }
 
@@ -51,99 +77,212 @@ var wakeChanges = []unix.Kevent_t{{
 	Fflags: unix.NOTE_TRIGGER,
 }}
 
-// Trigger wakes up the poller blocked in waiting for network-events and runs jobs in asyncJobQueue.
+// Trigger wakes up the poller blocked in waiting for network-events and runs jobs in asyncJobQueue,
+// it fails once the poller has been closed.
 func (p *Poller) Trigger(job internal.Job) error {
-	if p.asyncJobQueue.Push(job) == 1 {
-		_, err := unix.Kevent(p.fd, wakeChanges, nil, nil)
-		return err
-	}
-	return nil
+	return p.trigger(&p.asyncJobQueue, job)
 }
 
-// Polling blocks the current goroutine, waiting for network-events.
-func (p *Poller) Polling(callback func(fd int, filter int16) error) (err error) {
+// wake wakes up the poller blocked in waiting for network-events, it must be invoked with closeMu held.
+func (p *Poller) wake() error {
+	_, err := unix.Kevent(p.fd, wakeChanges, nil, nil)
+	return err
+}
+
+// Polling blocks the current goroutine, waiting for network-events, until the callback or a job returns an error
+// or waiting fails, e.g. as the poller has been closed.
+func (p *Poller) Polling(callback func(fd int, ev IOEvent) error) (err error) {
 	el := newEventList(InitEvents)
 	var wakenUp bool
 	for {
-		n, err0 := unix.Kevent(p.fd, nil, el.events, nil)
+		n, err0 := p.wait(el.events)
 		if err0 != nil && err0 != unix.EINTR {
-			log.Println(err0)
-			continue
+			return os.NewSyscallError("kevent", err0)
+		}
+		var polled time.Time
+		if p.metrics != nil {
+			polled = time.Now()
 		}
-		var evFilter int16
 		for i := 0; i < n; i++ {
 			if fd := int(el.events[i].Ident); fd != 0 {
-				evFilter = el.events[i].Filter
-				if (el.events[i].Flags&unix.EV_EOF != 0) || (el.events[i].Flags&unix.EV_ERROR != 0) {
-					evFilter = EVFilterSock
+				if p.metrics != nil {
+					p.metrics.EventLatency.Record(int64(time.Since(polled)))
 				}
-				if err = callback(fd, evFilter); err != nil {
+				if err = callback(fd, kqueueIOEvents(&el.events[i])); err != nil {
 					return
 				}
 			} else {
 				wakenUp = true
 			}
 		}
-		if wakenUp {
-			wakenUp = false
-			if err = p.asyncJobQueue.ForEach(); err != nil {
+		if err = p.runPending(wakenUp); err != nil {
+			return
+		}
+		wakenUp = false
+		if n == el.size {
+			el.increase()
+		}
+	}
+}
+
+// PollingBatch blocks the current goroutine, waiting for network-events like Polling, but it hands the whole batch
+// of the ready events over to the callback at once, which saves a function call per event at high event rates and
+// lets the callback sort or coalesce the events by file-descriptors. The batch is reused by the next wait,
+// and the latencies of the events are left to the callback to record.
+func (p *Poller) PollingBatch(callback func(events []Event) error) (err error) {
+	el := newEventList(InitEvents)
+	batch := make([]Event, 0, InitEvents)
+	var wakenUp bool
+	for {
+		n, err0 := p.wait(el.events)
+		if err0 != nil && err0 != unix.EINTR {
+			return os.NewSyscallError("kevent", err0)
+		}
+		batch = batch[:0]
+		for i := 0; i < n; i++ {
+			if fd := int(el.events[i].Ident); fd != 0 {
+				batch = append(batch, Event{Fd: fd, Events: kqueueIOEvents(&el.events[i])})
+			} else {
+				wakenUp = true
+			}
+		}
+		if len(batch) > 0 {
+			if err = callback(batch); err != nil {
 				return
 			}
 		}
+		if err = p.runPending(wakenUp); err != nil {
+			return
+		}
+		wakenUp = false
 		if n == el.size {
 			el.increase()
 		}
 	}
 }
 
-// AddReadWrite registers the given file-descriptor with readable and writable events to the poller.
-func (p *Poller) AddReadWrite(fd int) error {
-	if _, err := unix.Kevent(p.fd, []unix.Kevent_t{
-		{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_READ},
-		{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_WRITE},
-	}, nil, nil); err != nil {
-		return err
+// runPending runs the asynchronous jobs if the poller has been woken up, and then the expired timers.
+func (p *Poller) runPending(wakenUp bool) error {
+	if wakenUp {
+		if err := p.runJobs(); err != nil {
+			return err
+		}
+	}
+	if p.timers.Len() > 0 {
+		return p.expireTimers()
 	}
 	return nil
 }
 
+var zeroTimespec unix.Timespec
+
+// wait waits for network-events, it polls without blocking by the idle strategy before it blocks.
+func (p *Poller) wait(events []unix.Kevent_t) (int, error) {
+	if p.backsOff() {
+		start, timeout := time.Now(), p.timers.Timeout()
+		for {
+			if n, err := unix.Kevent(p.fd, nil, events, &zeroTimespec); n != 0 || err != nil {
+				return n, err
+			}
+			if !p.backOff(start, timeout) {
+				break
+			}
+		}
+	}
+	return unix.Kevent(p.fd, nil, events, p.waitTimespec())
+}
+
+// waitTimespec returns the timeout for kevent according to the pending timers.
+func (p *Poller) waitTimespec() *unix.Timespec {
+	d := p.waitTimeout()
+	if d < 0 {
+		return nil
+	}
+	ts := unix.NsecToTimespec(int64(d))
+	return &ts
+}
+
+// AddReadWrite registers the given file-descriptor with readable and writable events to the poller.
+func (p *Poller) AddReadWrite(fd int) error {
+	return p.fds.add(fd, fdReadWrite, p.ctlAdd)
+}
+
 // AddRead registers the given file-descriptor with readable event to the poller.
 func (p *Poller) AddRead(fd int) error {
-	if _, err := unix.Kevent(p.fd, []unix.Kevent_t{
-		{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_READ}}, nil, nil); err != nil {
-		return err
-	}
-	return nil
+	return p.fds.add(fd, fdRead, p.ctlAdd)
 }
 
 // AddWrite registers the given file-descriptor with writable event to the poller.
 func (p *Poller) AddWrite(fd int) error {
-	if _, err := unix.Kevent(p.fd, []unix.Kevent_t{
-		{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_WRITE}}, nil, nil); err != nil {
-		return err
-	}
-	return nil
+	return p.fds.add(fd, fdWrite, p.ctlAdd)
 }
 
 // ModRead renews the given file-descriptor with readable event in the poller.
 func (p *Poller) ModRead(fd int) error {
-	if _, err := unix.Kevent(p.fd, []unix.Kevent_t{
-		{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: unix.EVFILT_WRITE}}, nil, nil); err != nil {
-		return err
-	}
-	return nil
+	return p.fds.mod(fd, fdRead, p.ctlMod)
 }
 
 // ModReadWrite renews the given file-descriptor with readable and writable events in the poller.
 func (p *Poller) ModReadWrite(fd int) error {
-	if _, err := unix.Kevent(p.fd, []unix.Kevent_t{
-		{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_WRITE}}, nil, nil); err != nil {
-		return err
-	}
-	return nil
+	return p.fds.mod(fd, fdReadWrite, p.ctlMod)
+}
+
+// ModWrite renews the given file-descriptor with writable event in the poller.
+func (p *Poller) ModWrite(fd int) error {
+	return p.fds.mod(fd, fdWrite, p.ctlMod)
+}
+
+// ModNone renews the given file-descriptor with no events in the poller, which stops reporting
+// readable and writable events until it is renewed again.
+func (p *Poller) ModNone(fd int) error {
+	return p.fds.mod(fd, fdNone, p.ctlMod)
 }
 
 // Delete removes the given file-descriptor from the poller.
 func (p *Poller) Delete(fd int) error {
+	return p.fds.del(fd, p.ctlDel)
+}
+
+func (p *Poller) ctlAdd(fd int, ev fdEvents) error {
+	return p.ctlMod(fd, fdNone, ev)
+}
+
+// ctlMod adds the filters of the events which the file-descriptor is not registered for yet and deletes the
+// filters of the events which it is no longer registered for.
+func (p *Poller) ctlMod(fd int, cur, ev fdEvents) error {
+	var changes []unix.Kevent_t
+	if ev&fdRead != 0 && cur&fdRead == 0 {
+		changes = append(changes, unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_READ})
+	}
+	if ev&fdWrite != 0 && cur&fdWrite == 0 {
+		changes = append(changes, unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_WRITE})
+	}
+	if len(changes) > 0 {
+		if _, err := unix.Kevent(p.fd, changes, nil, nil); err != nil {
+			return err
+		}
+	}
+	return p.ctlDel(fd, cur&^ev)
+}
+
+// ctlDel deletes the filters of the events, ignoring absent filters.
+func (p *Poller) ctlDel(fd int, ev fdEvents) error {
+	if ev&fdRead != 0 {
+		if err := p.deleteFilter(fd, unix.EVFILT_READ); err != nil {
+			return err
+		}
+	}
+	if ev&fdWrite != 0 {
+		return p.deleteFilter(fd, unix.EVFILT_WRITE)
+	}
+	return nil
+}
+
+// deleteFilter removes the given filter of file-descriptor from the poller, ignoring absent filters.
+func (p *Poller) deleteFilter(fd int, filter int16) error {
+	if _, err := unix.Kevent(p.fd, []unix.Kevent_t{
+		{Ident: uint64(fd), Flags: unix.EV_DELETE, Filter: filter}}, nil, nil); err != nil && err != unix.ENOENT {
+		return err
+	}
 	return nil
 }
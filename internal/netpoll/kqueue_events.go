@@ -8,17 +8,26 @@ package netpoll
 
 import "golang.org/x/sys/unix"
 
-const (
-	// InitEvents represents the initial length of poller event-list.
-	InitEvents = 64
-	// EVFilterWrite represents writeable events from sockets.
-	EVFilterWrite = unix.EVFILT_WRITE
-	// EVFilterRead represents readable events from sockets.
-	EVFilterRead = unix.EVFILT_READ
-	// EVFilterSock represents exceptional events that are not read/write, like socket being closed,
-	// reading/writing from/to a closed socket, etc.
-	EVFilterSock = -0xd
-)
+// InitEvents represents the initial length of poller event-list.
+const InitEvents = 64
+
+// kqueueIOEvents translates a kevent, EV_EOF comes along with the filter which has seen it, so that the data
+// left to read is read before the connection is closed.
+func kqueueIOEvents(kev *unix.Kevent_t) (e IOEvent) {
+	switch kev.Filter {
+	case unix.EVFILT_READ:
+		e = Readable
+	case unix.EVFILT_WRITE:
+		e = Writable
+	}
+	if kev.Flags&unix.EV_EOF != 0 {
+		e |= Hup
+	}
+	if kev.Flags&unix.EV_ERROR != 0 {
+		e |= Error
+	}
+	return
+}
 
 type eventList struct {
 	size   int
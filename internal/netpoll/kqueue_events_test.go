@@ -0,0 +1,36 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package netpoll
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestKqueueIOEvents(t *testing.T) {
+	for _, c := range []struct {
+		filter int16
+		flags  uint16
+		want   IOEvent
+	}{
+		{unix.EVFILT_READ, 0, Readable},
+		{unix.EVFILT_WRITE, 0, Writable},
+		{unix.EVFILT_READ, unix.EV_EOF, Readable | Hup},
+		{unix.EVFILT_WRITE, unix.EV_EOF, Writable | Hup},
+		{unix.EVFILT_READ, unix.EV_ERROR, Readable | Error},
+		{unix.EVFILT_WRITE, unix.EV_EOF | unix.EV_ERROR, Writable | Hup | Error},
+		{unix.EVFILT_TIMER, 0, 0},
+	} {
+		var kev unix.Kevent_t
+		kev.Filter = c.filter
+		kev.Flags = c.flags
+		if got := kqueueIOEvents(&kev); got != c.want {
+			t.Errorf("kqueueIOEvents(filter %d, flags %#x) = %04b, want %04b", c.filter, c.flags, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package netpoll
+
+import "errors"
+
+// SetMark sets up SO_MARK of a socket for policy routing and packet filtering.
+func SetMark(fd int, mark uint32) error {
+	return errors.New("SO_MARK is not available on this platform")
+}
@@ -0,0 +1,55 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package netpoll
+
+import (
+	"errors"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+var errPacketUnsupported = errors.New("AF_PACKET is not available on this platform")
+
+// OpenPacket creates a non-blocking AF_PACKET raw socket receiving the frames of the protocol, an EtherType, from
+// the interface with the given index, zero for all the interfaces. The control function, if any, sets up the
+// socket before it is bound.
+func OpenPacket(ifindex int, proto uint16, control func(fd int) error) (int, error) {
+	return -1, errPacketUnsupported
+}
+
+// SockaddrLinklayer returns the interface index, the EtherType and the hardware address of an AF_PACKET
+// Sockaddr, ok is false for other kinds of Sockaddr.
+func SockaddrLinklayer(sa unix.Sockaddr) (ifindex int, proto uint16, hw net.HardwareAddr, ok bool) {
+	return 0, 0, nil, false
+}
+
+// SendPacket sends the frame out of the interface with the given index by the AF_PACKET socket.
+func SendPacket(fd int, frame []byte, ifindex int, hw net.HardwareAddr) error {
+	return errPacketUnsupported
+}
+
+// PacketRing is a PACKET_RX_RING of TPACKET_V2 frames shared with the kernel.
+type PacketRing struct{}
+
+// NewPacketRing sets up a ring of at least the given number of frames on the AF_PACKET socket and maps it.
+func NewPacketRing(fd, frames int) (*PacketRing, error) {
+	return nil, errPacketUnsupported
+}
+
+// Next returns the next frame received along with the Sockaddr of its sender.
+func (r *PacketRing) Next() (frame []byte, sa unix.Sockaddr, ok bool) {
+	return nil, nil, false
+}
+
+// Release hands the frame returned by Next back to the kernel.
+func (r *PacketRing) Release() {}
+
+// Close unmaps the ring.
+func (r *PacketRing) Close() error {
+	return nil
+}
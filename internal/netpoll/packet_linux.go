@@ -0,0 +1,138 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package netpoll
+
+import (
+	"net"
+	"os"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// packetFrameSize is the size of the frames of a PacketRing, the Ethernet frames longer than about 1.9KB
+// are truncated.
+const packetFrameSize = 2048
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// OpenPacket creates a non-blocking AF_PACKET raw socket receiving the frames of the protocol, an EtherType, from
+// the interface with the given index, zero for all the interfaces. The control function, if any, sets up the
+// socket before it is bound.
+func OpenPacket(ifindex int, proto uint16, control func(fd int) error) (int, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, int(htons(proto)))
+	if err != nil {
+		return -1, os.NewSyscallError("socket", err)
+	}
+	if control != nil {
+		if err = control(fd); err != nil {
+			_ = unix.Close(fd)
+			return -1, err
+		}
+	}
+	if err = unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(proto), Ifindex: ifindex}); err != nil {
+		_ = unix.Close(fd)
+		return -1, os.NewSyscallError("bind", err)
+	}
+	return fd, nil
+}
+
+// SockaddrLinklayer returns the interface index, the EtherType and the hardware address of an AF_PACKET
+// Sockaddr, ok is false for other kinds of Sockaddr.
+func SockaddrLinklayer(sa unix.Sockaddr) (ifindex int, proto uint16, hw net.HardwareAddr, ok bool) {
+	if sa, ok := sa.(*unix.SockaddrLinklayer); ok {
+		halen := int(sa.Halen)
+		if halen > len(sa.Addr) {
+			halen = len(sa.Addr)
+		}
+		return sa.Ifindex, htons(sa.Protocol), append(net.HardwareAddr(nil), sa.Addr[:halen]...), true
+	}
+	return 0, 0, nil, false
+}
+
+// SendPacket sends the frame out of the interface with the given index by the AF_PACKET socket, the hardware
+// address of the destination is only used by the interfaces which have no link-layer header.
+func SendPacket(fd int, frame []byte, ifindex int, hw net.HardwareAddr) error {
+	sa := &unix.SockaddrLinklayer{Ifindex: ifindex}
+	sa.Halen = uint8(copy(sa.Addr[:], hw))
+	return os.NewSyscallError("sendto", unix.Sendto(fd, frame, 0, sa))
+}
+
+// PacketRing is a PACKET_RX_RING of TPACKET_V2 frames shared with the kernel, which saves a system call and
+// a copy per frame received by an AF_PACKET socket. It is not safe for concurrent use.
+type PacketRing struct {
+	mem    []byte
+	frames int
+	next   int
+}
+
+// NewPacketRing sets up a ring of at least the given number of frames on the AF_PACKET socket and maps it.
+func NewPacketRing(fd, frames int) (*PacketRing, error) {
+	if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V2); err != nil {
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	blockSize := os.Getpagesize()
+	if blockSize < packetFrameSize {
+		blockSize = packetFrameSize
+	}
+	perBlock := blockSize / packetFrameSize
+	blocks := (frames + perBlock - 1) / perBlock
+	req := unix.TpacketReq{
+		Block_size: uint32(blockSize),
+		Block_nr:   uint32(blocks),
+		Frame_size: packetFrameSize,
+		Frame_nr:   uint32(blocks * perBlock),
+	}
+	if err := unix.SetsockoptTpacketReq(fd, unix.SOL_PACKET, unix.PACKET_RX_RING, &req); err != nil {
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	mem, err := unix.Mmap(fd, 0, blockSize*blocks, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return nil, os.NewSyscallError("mmap", err)
+	}
+	return &PacketRing{mem: mem, frames: blocks * perBlock}, nil
+}
+
+func (r *PacketRing) header() *unix.Tpacket2Hdr {
+	return (*unix.Tpacket2Hdr)(unsafe.Pointer(&r.mem[r.next*packetFrameSize]))
+}
+
+// Next returns the next frame received along with the Sockaddr of its sender, ok is false if the kernel has
+// not filled it in yet. The frame is only valid until it is released.
+func (r *PacketRing) Next() (frame []byte, sa unix.Sockaddr, ok bool) {
+	hdr := r.header()
+	if atomic.LoadUint32(&hdr.Status)&unix.TP_STATUS_USER == 0 {
+		return nil, nil, false
+	}
+	base := r.next * packetFrameSize
+	// The sockaddr_ll follows the header, aligned to TPACKET_ALIGNMENT.
+	ll := (*unix.RawSockaddrLinklayer)(unsafe.Pointer(&r.mem[base+(unix.SizeofTpacket2Hdr+15)&^15]))
+	sa = &unix.SockaddrLinklayer{
+		Protocol: ll.Protocol,
+		Ifindex:  int(ll.Ifindex),
+		Hatype:   ll.Hatype,
+		Pkttype:  ll.Pkttype,
+		Halen:    ll.Halen,
+		Addr:     ll.Addr,
+	}
+	start := base + int(hdr.Mac)
+	return r.mem[start : start+int(hdr.Snaplen)], sa, true
+}
+
+// Release hands the frame returned by Next back to the kernel.
+func (r *PacketRing) Release() {
+	atomic.StoreUint32(&r.header().Status, unix.TP_STATUS_KERNEL)
+	r.next = (r.next + 1) % r.frames
+}
+
+// Close unmaps the ring, which goes away along with its socket.
+func (r *PacketRing) Close() error {
+	return unix.Munmap(r.mem)
+}
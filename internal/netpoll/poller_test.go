@@ -0,0 +1,144 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package netpoll
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var errStop = errors.New("stop")
+
+// openPipes opens n pipes, registers their read ends with the poller and makes them readable.
+func openPipes(t *testing.T, p *Poller, n int) (fds []int, cleanup func()) {
+	var all []int
+	cleanup = func() {
+		for _, fd := range all {
+			_ = unix.Close(fd)
+		}
+	}
+	for i := 0; i < n; i++ {
+		var pipe [2]int
+		if err := unix.Pipe(pipe[:]); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		all = append(all, pipe[0], pipe[1])
+		if err := p.AddRead(pipe[0]); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		if _, err := unix.Write(pipe[1], []byte("x")); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		fds = append(fds, pipe[0])
+	}
+	return fds, cleanup
+}
+
+func TestPollingBatch(t *testing.T) {
+	p, err := OpenPoller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	fds, cleanup := openPipes(t, p, 8)
+	defer cleanup()
+
+	// The events, the jobs and the timers which are all pending run in this order within an iteration.
+	var order []string
+	if err = p.Trigger(func() error {
+		order = append(order, "job")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.TriggerUrgent(func() error {
+		order = append(order, "urgent job")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	p.AddTimer(0, func() error {
+		order = append(order, "timer")
+		return errStop
+	})
+	var batches [][]Event
+	err = p.PollingBatch(func(events []Event) error {
+		order = append(order, "batch")
+		batches = append(batches, append([]Event(nil), events...))
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("got %v, want the error of the timer", err)
+	}
+	if len(batches) != 1 {
+		t.Fatalf("got %d batches, want the ready events in one", len(batches))
+	}
+	var got []int
+	for _, ev := range batches[0] {
+		if ev.Events&Readable == 0 {
+			t.Fatalf("fd %d not readable: %b", ev.Fd, ev.Events)
+		}
+		got = append(got, ev.Fd)
+	}
+	sort.Ints(got)
+	sort.Ints(fds)
+	if !reflect.DeepEqual(got, fds) {
+		t.Fatalf("got the fds %v, want %v without the wake fd", got, fds)
+	}
+	if want := []string{"batch", "urgent job", "job", "timer"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("ran %v, want %v", order, want)
+	}
+
+	// The callback stops the polling.
+	err = p.PollingBatch(func(events []Event) error {
+		if len(events) != len(fds) {
+			t.Fatalf("got %d events, want %d", len(events), len(fds))
+		}
+		return errStop
+	})
+	if err != errStop {
+		t.Fatalf("got %v, want the error of the callback", err)
+	}
+}
+
+func TestPollingBatchJobsOnly(t *testing.T) {
+	p, err := OpenPoller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// A wake-up with no events of the fds runs the jobs without invoking the callback.
+	go func() {
+		_ = p.Trigger(func() error { return errStop })
+	}()
+	err = p.PollingBatch(func(events []Event) error {
+		t.Errorf("got a batch of %d events without any fd ready", len(events))
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("got %v, want the error of the job", err)
+	}
+
+	// The timers expire while the poller is blocked.
+	var fired bool
+	p.AddTimer(10*time.Millisecond, func() error {
+		fired = true
+		return errStop
+	})
+	if err = p.PollingBatch(func([]Event) error { return nil }); err != errStop || !fired {
+		t.Fatalf("got %v, want the error of the timer", err)
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package netpoll
+
+import "errors"
+
+// SetRecvErr sets up the socket to queue the ICMP errors of the datagrams, it is only available on linux.
+func SetRecvErr(fd int) error {
+	return errors.New("IP_RECVERR is not available on this platform")
+}
@@ -0,0 +1,14 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package netpoll
+
+import "golang.org/x/sys/unix"
+
+// RecvErr reads an error from the error queue of a socket, it is only available on linux.
+func RecvErr(fd int, oob []byte) (QueuedErr, error) {
+	return QueuedErr{}, unix.EAGAIN
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package netpoll
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// sizeofSockExtendedErr is the size of struct sock_extended_err, which is followed by the offender.
+const sizeofSockExtendedErr = 16
+
+// SetRecvErr sets up IP_RECVERR (IPV6_RECVERR) so that the ICMP errors of the datagrams sent by a socket
+// are queued for RecvErr.
+func SetRecvErr(fd int) error {
+	domain, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_DOMAIN)
+	if err != nil {
+		return os.NewSyscallError("getsockopt", err)
+	}
+	// The errors of IPv4 datagrams sent by a dual-stack socket come with IP_RECVERR too.
+	if err = unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_RECVERR, 1); err == nil && domain == unix.AF_INET6 {
+		err = unix.SetsockoptInt(fd, unix.SOL_IPV6, unix.IPV6_RECVERR, 1)
+	}
+	return os.NewSyscallError("setsockopt", err)
+}
+
+// RecvErr reads an error from the error queue of a socket set up by SetRecvErr, it fails with EAGAIN once
+// the queue is empty.
+func RecvErr(fd int, oob []byte) (qe QueuedErr, err error) {
+	var p [1]byte
+	_, oobn, _, to, err := unix.Recvmsg(fd, p[:], oob, unix.MSG_ERRQUEUE)
+	if err != nil {
+		return
+	}
+	qe.To = to
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return
+	}
+	for _, msg := range msgs {
+		if !(msg.Header.Level == unix.SOL_IP && msg.Header.Type == unix.IP_RECVERR) &&
+			!(msg.Header.Level == unix.SOL_IPV6 && msg.Header.Type == unix.IPV6_RECVERR) ||
+			len(msg.Data) < sizeofSockExtendedErr {
+			continue
+		}
+		qe.Errno = unix.Errno(*(*uint32)(unsafe.Pointer(&msg.Data[0])))
+		qe.ICMP = msg.Data[4] == unix.SO_EE_ORIGIN_ICMP || msg.Data[4] == unix.SO_EE_ORIGIN_ICMP6
+		qe.Type, qe.Code = msg.Data[5], msg.Data[6]
+		if addr := rawToTCPAddr(msg.Data[sizeofSockExtendedErr:]); addr != nil {
+			qe.Offender = addr.IP
+		}
+	}
+	return
+}
@@ -42,21 +42,66 @@ func SockaddrToUDPAddr(sa unix.Sockaddr) *net.UDPAddr {
 	return nil
 }
 
-// sockaddrInet4ToIPAndZone converts a SockaddrInet4 to a net.IP.
+// SockaddrIP returns the IP address of an internet Sockaddr without copying it,
+// returns nil for other kinds of Sockaddr.
+func SockaddrIP(sa unix.Sockaddr) net.IP {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return sa.Addr[:]
+	case *unix.SockaddrInet6:
+		return sa.Addr[:]
+	}
+	return nil
+}
+
+// SockaddrInet copies the IP address of an internet Sockaddr into buf and returns it along with the port and
+// the IPv6 zone, an IPv4-mapped address of a dual-stack socket is returned as a 4-byte net.IP.
+// Nothing is allocated unless the address has a zone, returns a nil IP for other kinds of Sockaddr.
+func SockaddrInet(sa unix.Sockaddr, buf *[net.IPv6len]byte) (ip net.IP, port int, zone string) {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		ip = buf[:net.IPv4len]
+		copy(ip, sa.Addr[:])
+		return ip, sa.Port, ""
+	case *unix.SockaddrInet6:
+		if isIPv4Mapped(sa.Addr[:]) {
+			ip = buf[:net.IPv4len]
+			copy(ip, sa.Addr[12:])
+			return ip, sa.Port, ""
+		}
+		ip = buf[:]
+		copy(ip, sa.Addr[:])
+		return ip, sa.Port, ip6ZoneToString(int(sa.ZoneId))
+	}
+	return nil, 0, ""
+}
+
+// isIPv4Mapped reports whether the 16-byte IP address is an IPv4-mapped IPv6 address.
+func isIPv4Mapped(ip []byte) bool {
+	for _, b := range ip[:10] {
+		if b != 0 {
+			return false
+		}
+	}
+	return ip[10] == 0xff && ip[11] == 0xff
+}
+
+// sockaddrInet4ToIPAndZone converts a SockaddrInet4 to a 4-byte net.IP.
 // It returns nil if conversion fails.
 func sockaddrInet4ToIP(sa *unix.SockaddrInet4) net.IP {
-	ip := make([]byte, 16)
-	// V4InV6Prefix
-	ip[10] = 0xff
-	ip[11] = 0xff
-	copy(ip[12:16], sa.Addr[:])
+	ip := make([]byte, net.IPv4len)
+	copy(ip, sa.Addr[:])
 	return ip
 }
 
-// sockaddrInet6ToIPAndZone converts a SockaddrInet6 to a net.IP with IPv6 Zone.
+// sockaddrInet6ToIPAndZone converts a SockaddrInet6 to a net.IP with IPv6 Zone,
+// an IPv4-mapped address of a dual-stack socket is converted to a 4-byte net.IP.
 // It returns nil if conversion fails.
 func sockaddrInet6ToIPAndZone(sa *unix.SockaddrInet6) (net.IP, string) {
-	ip := make([]byte, 16)
+	if ip := net.IP(sa.Addr[:]).To4(); ip != nil {
+		return append(net.IP(nil), ip...), ""
+	}
+	ip := make([]byte, net.IPv6len)
 	copy(ip, sa.Addr[:])
 	return ip, ip6ZoneToString(int(sa.ZoneId))
 }
@@ -88,3 +133,24 @@ func int2decimal(i uint) string {
 	}
 	return string(b[bp:])
 }
+
+// UDPAddrToSockaddr converts a net.UDPAddr to a Sockaddr along with its address family.
+// Returns nil if conversion fails.
+func UDPAddrToSockaddr(addr *net.UDPAddr) (unix.Sockaddr, int) {
+	if ip := addr.IP.To4(); ip != nil {
+		sa := &unix.SockaddrInet4{Port: addr.Port}
+		copy(sa.Addr[:], ip)
+		return sa, unix.AF_INET
+	}
+	if ip := addr.IP.To16(); ip != nil {
+		sa := &unix.SockaddrInet6{Port: addr.Port}
+		copy(sa.Addr[:], ip)
+		if addr.Zone != "" {
+			if ifi, err := net.InterfaceByName(addr.Zone); err == nil {
+				sa.ZoneId = uint32(ifi.Index)
+			}
+		}
+		return sa, unix.AF_INET6
+	}
+	return nil, 0
+}
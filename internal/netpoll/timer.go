@@ -0,0 +1,208 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package netpoll
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/panlibin/gnet/internal"
+)
+
+// AddTimer schedules the job to be executed by the poller after the given delay,
+// it must be invoked within the goroutine of Polling, use Trigger to schedule a timer from other goroutines.
+func (p *Poller) AddTimer(delay time.Duration, job internal.Job) *internal.Timer {
+	return p.timers.Add(delay, job)
+}
+
+// expireTimers runs the expired timers, their errors are handled like the ones of the jobs, see
+// SetJobErrorHandler.
+func (p *Poller) expireTimers() error {
+	err := p.timers.Expire()
+	if err != nil && p.jobErrors != nil {
+		return p.jobErrors(err)
+	}
+	return err
+}
+
+// DelTimer cancels the given timer, it must be invoked within the goroutine of Polling.
+func (p *Poller) DelTimer(t *internal.Timer) {
+	p.timers.Remove(t)
+}
+
+// SetMetrics makes the poller record its metrics, it must be invoked before Polling.
+func (p *Poller) SetMetrics(m *internal.LoopMetrics) {
+	p.metrics = m
+}
+
+// IdleStrategy decides how the poller waits for network-events, it backs off progressively: it polls without
+// blocking and spins for Spin, then yields the processor between the polls for Yield, then sleeps SleepInterval
+// between the polls for Sleep, and blocks in waiting for at most MaxWait in the end.
+type IdleStrategy struct {
+	Spin, Yield, Sleep, SleepInterval, MaxWait time.Duration
+}
+
+// SetIdleStrategy sets up how the poller waits for network-events, it must be invoked before Polling.
+func (p *Poller) SetIdleStrategy(is IdleStrategy) {
+	p.idle = is
+}
+
+// backsOff reports whether the poller polls without blocking before it blocks.
+func (p *Poller) backsOff() bool {
+	return p.idle.Spin > 0 || p.idle.Yield > 0 || p.idle.Sleep > 0
+}
+
+// backOff backs off by the idle strategy after a poll without blocking has found no events, start is when
+// the poller started to wait and timeout is the timeout of the pending timers. It reports whether to poll
+// without blocking again rather than to block.
+func (p *Poller) backOff(start time.Time, timeout time.Duration) bool {
+	elapsed := time.Since(start)
+	if timeout >= 0 && elapsed >= timeout {
+		return false
+	}
+	is := &p.idle
+	switch {
+	case elapsed < is.Spin:
+	case elapsed < is.Spin+is.Yield:
+		runtime.Gosched()
+	case elapsed < is.Spin+is.Yield+is.Sleep:
+		d := is.SleepInterval
+		if timeout >= 0 && timeout-elapsed < d {
+			d = timeout - elapsed
+		}
+		time.Sleep(d)
+	default:
+		return false
+	}
+	return true
+}
+
+// waitTimeout returns the timeout of a blocking wait, -1 for no timeout.
+func (p *Poller) waitTimeout() time.Duration {
+	d := p.timers.Timeout()
+	if max := p.idle.MaxWait; max > 0 && (d < 0 || d > max) {
+		return max
+	}
+	return d
+}
+
+// QueueDepth returns the number of asynchronous jobs waiting to be executed by the poller.
+func (p *Poller) QueueDepth() int {
+	urgent, normal := p.QueueDepths()
+	return urgent + normal
+}
+
+// QueueDepths returns the number of urgent and ordinary asynchronous jobs waiting to be executed by the poller.
+func (p *Poller) QueueDepths() (urgent, normal int) {
+	return p.urgentJobs.Len(), p.asyncJobQueue.Len()
+}
+
+// TriggerUrgent is Trigger for the urgent jobs, e.g. closing a connection or handling an error, which run ahead of
+// the ordinary ones: those triggered while the poller runs the ordinary jobs run in between them, so that a flood of
+// ordinary jobs doesn't hold them up.
+func (p *Poller) TriggerUrgent(job internal.Job) error {
+	return p.trigger(&p.urgentJobs, job)
+}
+
+// The wake-up states of a poller: an asleep poller must be woken up to run the jobs triggered, an awake one has
+// been woken up and runs the jobs triggered until it has run out of them.
+const (
+	asleep int32 = iota
+	awake
+)
+
+// SetJobErrorHandler sets up the handler of the errors of the asynchronous jobs and the timers: an error is handed
+// to it and Polling goes on, unless the handler returns an error, which ends Polling. Without a handler the first
+// error of a job ends Polling. The errors of the jobs triggered by TriggerFatal end Polling either way. It must be
+// invoked before anything is triggered.
+func (p *Poller) SetJobErrorHandler(h func(err error) error) {
+	p.jobErrors = h
+}
+
+// TriggerFatal is Trigger for the jobs which stop the poller, e.g. shutting it down, the error such a job returns
+// ends Polling regardless of the job error handler.
+func (p *Poller) TriggerFatal(job internal.Job) error {
+	if p.jobErrors != nil {
+		job = fatalJob(job)
+	}
+	return p.trigger(&p.asyncJobQueue, job)
+}
+
+// fatalJob marks the job as fatal so that trigger doesn't hand its error over to the job error handler.
+func fatalJob(job internal.Job) internal.Job {
+	return func() error {
+		if err := job(); err != nil {
+			return fatalError{err}
+		}
+		return nil
+	}
+}
+
+// fatalError is the error of a fatal job on its way to trigger, which unwraps it.
+type fatalError struct {
+	err error
+}
+
+func (e fatalError) Error() string { return e.err.Error() }
+
+// trigger queues the job and wakes the poller up unless it is awake, so that the jobs triggered in a burst cost a
+// single wake-up, see runJobs.
+func (p *Poller) trigger(q *internal.AsyncJobQueue, job internal.Job) error {
+	if h := p.jobErrors; h != nil {
+		do := job
+		job = func() error {
+			err := do()
+			if fe, ok := err.(fatalError); ok {
+				return fe.err
+			} else if err != nil {
+				return h(err)
+			}
+			return nil
+		}
+	}
+	if p.metrics != nil {
+		job = p.metrics.TimeJob(job)
+	}
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+	if p.closed {
+		return ErrPollerClosed
+	}
+	q.Push(job)
+	if !atomic.CompareAndSwapInt32(&p.wakeState, asleep, awake) {
+		atomic.AddInt64(&p.coalesced, 1)
+		return nil
+	}
+	atomic.AddInt64(&p.wakeups, 1)
+	return p.wake()
+}
+
+// Wakeups returns the number of times Trigger has woken the poller up and the number of jobs triggered while it
+// was already awake, which have not cost a wake-up.
+func (p *Poller) Wakeups() (wakeups, coalesced int64) {
+	return atomic.LoadInt64(&p.wakeups), atomic.LoadInt64(&p.coalesced)
+}
+
+// runJobs runs the urgent asynchronous jobs and then the ordinary ones, with the urgent jobs triggered in the
+// meantime in between. The poller stays awake until it has run out of jobs, the jobs triggered after it has
+// fallen asleep wake it up again.
+func (p *Poller) runJobs() (err error) {
+	for {
+		if p.metrics != nil {
+			p.metrics.QueueDepth.Record(int64(p.QueueDepth()))
+		}
+		if err = p.urgentJobs.ForEach(); err == nil {
+			err = p.asyncJobQueue.ForEachBetween(p.urgentJobs.ForEach)
+		}
+		atomic.StoreInt32(&p.wakeState, asleep)
+		// A job triggered before the poller has fallen asleep hasn't woken it up, unless a later job has.
+		if err != nil || p.QueueDepth() == 0 || !atomic.CompareAndSwapInt32(&p.wakeState, asleep, awake) {
+			return
+		}
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package netpoll
+
+import (
+	"errors"
+	"net"
+)
+
+var errTransparentNotAvailable = errors.New("transparent proxying is not available on this platform")
+
+// SetFreebind sets up IP_FREEBIND so that a socket can be bound to an address which is not configured yet.
+func SetFreebind(fd int) error {
+	return errTransparentNotAvailable
+}
+
+// SetTransparent sets up IP_TRANSPARENT so that a socket can accept the connections and receive the datagrams
+// redirected to it by TPROXY.
+func SetTransparent(fd int) error {
+	return errTransparentNotAvailable
+}
+
+// OriginalDst returns the original destination of a TCP connection redirected by netfilter.
+func OriginalDst(fd int) (net.Addr, error) {
+	return nil, errTransparentNotAvailable
+}
@@ -0,0 +1,16 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package netpoll
+
+import "golang.org/x/sys/unix"
+
+// RecvmsgMeta reads a datagram, the ancillary data is only available on linux.
+func RecvmsgMeta(fd int, p, oob []byte) (n int, from unix.Sockaddr, meta DatagramMeta, err error) {
+	meta.TOS = -1
+	n, from, err = unix.Recvfrom(fd, p, 0)
+	return
+}
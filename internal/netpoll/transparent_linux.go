@@ -0,0 +1,110 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package netpoll
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// soOriginalDst is SO_ORIGINAL_DST of netfilter, it is the same value for IPv4 and IPv6 (IP6T_SO_ORIGINAL_DST).
+const soOriginalDst = 80
+
+// SetFreebind sets up IP_FREEBIND so that a socket can be bound to an address which is not configured yet.
+func SetFreebind(fd int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_FREEBIND, 1))
+}
+
+// SetTransparent sets up IP_TRANSPARENT (IPV6_TRANSPARENT) so that a socket can accept the connections and
+// receive the datagrams redirected to it by TPROXY, UDP sockets are also set up to receive the original
+// destinations of the datagrams.
+func SetTransparent(fd int) error {
+	domain, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_DOMAIN)
+	if err != nil {
+		return os.NewSyscallError("getsockopt", err)
+	}
+	sotype, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TYPE)
+	if err != nil {
+		return os.NewSyscallError("getsockopt", err)
+	}
+	if err = unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_TRANSPARENT, 1); err == nil && domain == unix.AF_INET6 {
+		err = unix.SetsockoptInt(fd, unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+	}
+	if err == nil && sotype == unix.SOCK_DGRAM {
+		// IPv4 datagrams received by a dual-stack socket come with IP_ORIGDSTADDR too.
+		if err = unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_RECVORIGDSTADDR, 1); err == nil && domain == unix.AF_INET6 {
+			err = unix.SetsockoptInt(fd, unix.SOL_IPV6, unix.IPV6_RECVORIGDSTADDR, 1)
+		}
+	}
+	return os.NewSyscallError("setsockopt", err)
+}
+
+// OriginalDst returns the original destination of a TCP connection redirected by netfilter, e.g. by REDIRECT or
+// DNAT, with SO_ORIGINAL_DST.
+func OriginalDst(fd int) (net.Addr, error) {
+	// IPv6MTUInfo is the only getsockopt result large enough for a sockaddr_in6.
+	info, err := unix.GetsockoptIPv6MTUInfo(fd, unix.SOL_IP, soOriginalDst)
+	if err != nil {
+		if info, err = unix.GetsockoptIPv6MTUInfo(fd, unix.SOL_IPV6, soOriginalDst); err != nil {
+			return nil, os.NewSyscallError("getsockopt", err)
+		}
+	}
+	return rawToTCPAddr((*[unsafe.Sizeof(*info)]byte)(unsafe.Pointer(info))[:]), nil
+}
+
+// RecvmsgMeta reads a datagram along with its original destination and TOS byte, see DatagramMeta.
+func RecvmsgMeta(fd int, p, oob []byte) (n int, from unix.Sockaddr, meta DatagramMeta, err error) {
+	meta.TOS = -1
+	n, oobn, _, from, err := unix.Recvmsg(fd, p, oob, 0)
+	if err != nil || oobn == 0 {
+		return
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return
+	}
+	for _, msg := range msgs {
+		switch {
+		case (msg.Header.Level == unix.SOL_IP && msg.Header.Type == unix.IP_ORIGDSTADDR) ||
+			(msg.Header.Level == unix.SOL_IPV6 && msg.Header.Type == unix.IPV6_ORIGDSTADDR):
+			addr := rawToTCPAddr(msg.Data)
+			if addr != nil {
+				meta.Dst = &net.UDPAddr{IP: addr.IP, Port: addr.Port, Zone: addr.Zone}
+			}
+		case msg.Header.Level == unix.SOL_IP && msg.Header.Type == unix.IP_TOS && len(msg.Data) >= 1:
+			meta.TOS = int(msg.Data[0])
+		case msg.Header.Level == unix.SOL_IPV6 && msg.Header.Type == unix.IPV6_TCLASS && len(msg.Data) >= 4:
+			meta.TOS = int(*(*int32)(unsafe.Pointer(&msg.Data[0])))
+		}
+	}
+	return
+}
+
+// rawToTCPAddr converts a raw sockaddr_in or sockaddr_in6 to a net.TCPAddr.
+func rawToTCPAddr(b []byte) *net.TCPAddr {
+	if len(b) < unix.SizeofSockaddrInet4 {
+		return nil
+	}
+	family := *(*uint16)(unsafe.Pointer(&b[0]))
+	port := int(binary.BigEndian.Uint16(b[2:4]))
+	switch {
+	case family == unix.AF_INET:
+		return &net.TCPAddr{IP: net.IPv4(b[4], b[5], b[6], b[7]).To4(), Port: port}
+	case family == unix.AF_INET6 && len(b) >= unix.SizeofSockaddrInet6:
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, b[8:24])
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		return &net.TCPAddr{IP: ip, Port: port}
+	}
+	return nil
+}
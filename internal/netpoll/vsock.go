@@ -0,0 +1,24 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package netpoll
+
+import "errors"
+
+var errVsockUnsupported = errors.New("AF_VSOCK is not available on this platform")
+
+// ListenVsock creates a non-blocking AF_VSOCK stream socket listening on the CID and port, it returns the socket
+// along with the CID and port it is bound to. The control function, if any, sets up the socket before it is bound.
+func ListenVsock(cid, port uint32, backlog int, control func(fd int) error) (fd int, boundCID, boundPort uint32,
+	err error) {
+	return -1, 0, 0, errVsockUnsupported
+}
+
+// DialVsock connects an AF_VSOCK stream socket to the CID and port, it returns the non-blocking socket along with
+// the local CID and port.
+func DialVsock(cid, port uint32) (fd int, localCID, localPort uint32, err error) {
+	return -1, 0, 0, errVsockUnsupported
+}
@@ -0,0 +1,14 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package netpoll
+
+import "golang.org/x/sys/unix"
+
+// SockaddrVM returns the CID and port of an AF_VSOCK Sockaddr, ok is false for other kinds of Sockaddr.
+func SockaddrVM(sa unix.Sockaddr) (cid, port uint32, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package netpoll
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// ListenVsock creates a non-blocking AF_VSOCK stream socket listening on the CID and port, it returns the socket
+// along with the CID and port it is bound to. The control function, if any, sets up the socket before it is bound.
+func ListenVsock(cid, port uint32, backlog int, control func(fd int) error) (fd int, boundCID, boundPort uint32,
+	err error) {
+	if fd, err = unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0); err != nil {
+		return -1, 0, 0, os.NewSyscallError("socket", err)
+	}
+	if control != nil {
+		if err = control(fd); err != nil {
+			_ = unix.Close(fd)
+			return -1, 0, 0, err
+		}
+	}
+	if err = unix.Bind(fd, &unix.SockaddrVM{CID: cid, Port: port}); err != nil {
+		_ = unix.Close(fd)
+		return -1, 0, 0, os.NewSyscallError("bind", err)
+	}
+	if err = unix.Listen(fd, backlog); err != nil {
+		_ = unix.Close(fd)
+		return -1, 0, 0, os.NewSyscallError("listen", err)
+	}
+	boundCID, boundPort, _ = vsockName(fd)
+	return fd, boundCID, boundPort, nil
+}
+
+// DialVsock connects an AF_VSOCK stream socket to the CID and port, it returns the non-blocking socket along with
+// the local CID and port. It blocks until the connection is established or the connect timeout of the socket
+// expires.
+func DialVsock(cid, port uint32) (fd int, localCID, localPort uint32, err error) {
+	if fd, err = unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0); err != nil {
+		return -1, 0, 0, os.NewSyscallError("socket", err)
+	}
+	if err = unix.Connect(fd, &unix.SockaddrVM{CID: cid, Port: port}); err != nil {
+		_ = unix.Close(fd)
+		return -1, 0, 0, os.NewSyscallError("connect", err)
+	}
+	if err = unix.SetNonblock(fd, true); err != nil {
+		_ = unix.Close(fd)
+		return -1, 0, 0, os.NewSyscallError("setnonblock", err)
+	}
+	localCID, localPort, _ = vsockName(fd)
+	return fd, localCID, localPort, nil
+}
+
+// SockaddrVM returns the CID and port of an AF_VSOCK Sockaddr, ok is false for other kinds of Sockaddr.
+func SockaddrVM(sa unix.Sockaddr) (cid, port uint32, ok bool) {
+	if sa, ok := sa.(*unix.SockaddrVM); ok {
+		return sa.CID, sa.Port, true
+	}
+	return 0, 0, false
+}
+
+func vsockName(fd int) (cid, port uint32, ok bool) {
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return 0, 0, false
+	}
+	return SockaddrVM(sa)
+}
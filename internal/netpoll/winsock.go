@@ -0,0 +1,20 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !windows
+
+package netpoll
+
+import "errors"
+
+// SetExclusiveAddrUse sets up SO_EXCLUSIVEADDRUSE of a socket so that no other socket can be bound to its address.
+func SetExclusiveAddrUse(fd int) error {
+	return errors.New("SO_EXCLUSIVEADDRUSE is not available on this platform")
+}
+
+// SetLoopbackFastPath enables SIO_LOOPBACK_FAST_PATH of a TCP socket, which bypasses most of the TCP/IP stack
+// for the loopback connections whose both ends enable it.
+func SetLoopbackFastPath(fd int) error {
+	return errors.New("SIO_LOOPBACK_FAST_PATH is not available on this platform")
+}
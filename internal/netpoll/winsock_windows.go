@@ -0,0 +1,34 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows
+
+package netpoll
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	soExclusiveAddrUse  = ^windows.SO_REUSEADDR
+	sioLoopbackFastPath = windows.IOC_IN | windows.IOC_VENDOR | 16
+)
+
+// SetExclusiveAddrUse sets up SO_EXCLUSIVEADDRUSE of a socket so that no other socket can be bound to its address.
+func SetExclusiveAddrUse(fd int) error {
+	return os.NewSyscallError("setsockopt",
+		windows.SetsockoptInt(windows.Handle(fd), windows.SOL_SOCKET, soExclusiveAddrUse, 1))
+}
+
+// SetLoopbackFastPath enables SIO_LOOPBACK_FAST_PATH of a TCP socket, which bypasses most of the TCP/IP stack
+// for the loopback connections whose both ends enable it.
+func SetLoopbackFastPath(fd int) error {
+	enabled := uint32(1)
+	var n uint32
+	return os.NewSyscallError("wsaioctl", windows.WSAIoctl(windows.Handle(fd), sioLoopbackFastPath,
+		(*byte)(unsafe.Pointer(&enabled)), uint32(unsafe.Sizeof(enabled)), nil, 0, &n, nil, 0))
+}
@@ -0,0 +1,35 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build darwin netbsd freebsd openbsd dragonfly
+
+package netpoll
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Writev writes the buffers to the file descriptor in a single system call and returns the number of bytes
+// written, which may fall short of their total length on a non-blocking socket.
+func Writev(fd int, iovs [][]byte) (int, error) {
+	vecs := make([]unix.Iovec, 0, len(iovs))
+	for _, b := range iovs {
+		if len(b) == 0 {
+			continue
+		}
+		v := unix.Iovec{Base: &b[0]}
+		v.SetLen(len(b))
+		vecs = append(vecs, v)
+	}
+	if len(vecs) == 0 {
+		return 0, nil
+	}
+	n, _, e := unix.Syscall(unix.SYS_WRITEV, uintptr(fd), uintptr(unsafe.Pointer(&vecs[0])), uintptr(len(vecs)))
+	if e != 0 {
+		return 0, e
+	}
+	return int(n), nil
+}
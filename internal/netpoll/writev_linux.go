@@ -0,0 +1,15 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package netpoll
+
+import "golang.org/x/sys/unix"
+
+// Writev writes the buffers to the file descriptor in a single system call and returns the number of bytes
+// written, which may fall short of their total length on a non-blocking socket.
+func Writev(fd int, iovs [][]byte) (int, error) {
+	return unix.Writev(fd, iovs)
+}
@@ -30,6 +30,14 @@ func (q *AsyncJobQueue) Push(job Job) (jobsNum int) {
 	return
 }
 
+// Len returns the number of pending jobs.
+func (q *AsyncJobQueue) Len() (jobsNum int) {
+	q.lock.Lock()
+	jobsNum = len(q.jobs)
+	q.lock.Unlock()
+	return
+}
+
 // ForEach iterates this queue and executes each note with a given func.
 func (q *AsyncJobQueue) ForEach() (err error) {
 	q.lock.Lock()
@@ -43,3 +51,23 @@ func (q *AsyncJobQueue) ForEach() (err error) {
 	}
 	return
 }
+
+// ForEachBetween iterates this queue like ForEach, running the given function between every two jobs, which lets
+// the jobs of another queue jump ahead of the rest of this one.
+func (q *AsyncJobQueue) ForEachBetween(between func() error) (err error) {
+	q.lock.Lock()
+	jobs := q.jobs
+	q.jobs = nil
+	q.lock.Unlock()
+	for i := range jobs {
+		if i > 0 {
+			if err = between(); err != nil {
+				return err
+			}
+		}
+		if err = jobs[i](); err != nil {
+			return err
+		}
+	}
+	return
+}
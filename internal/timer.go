@@ -0,0 +1,105 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Timer is a job scheduled to be executed at a certain time.
+type Timer struct {
+	when  time.Time
+	job   Job
+	index int
+}
+
+// TimerQueue is a min-heap of timers ordered by their deadlines, it is not goroutine-safe
+// and is meant to be owned by a single event-loop.
+type TimerQueue struct {
+	timers timerHeap
+	now    func() time.Time
+}
+
+// SetClock replaces the clock of the queue which is time.Now by default, it is meant for virtual clocks in tests.
+func (q *TimerQueue) SetClock(now func() time.Time) {
+	q.now = now
+}
+
+func (q *TimerQueue) clock() time.Time {
+	if q.now != nil {
+		return q.now()
+	}
+	return time.Now()
+}
+
+// Add schedules the job to be executed after the given delay.
+func (q *TimerQueue) Add(delay time.Duration, job Job) *Timer {
+	t := &Timer{when: q.clock().Add(delay), job: job}
+	heap.Push(&q.timers, t)
+	return t
+}
+
+// Remove cancels the given timer, it is a no-op if the timer has already fired or been removed.
+func (q *TimerQueue) Remove(t *Timer) {
+	if t == nil || t.index < 0 || t.index >= len(q.timers) || q.timers[t.index] != t {
+		return
+	}
+	heap.Remove(&q.timers, t.index)
+}
+
+// Len returns the number of pending timers.
+func (q *TimerQueue) Len() int {
+	return len(q.timers)
+}
+
+// Timeout returns the duration until the earliest timer expires, or -1 if there is no pending timer.
+func (q *TimerQueue) Timeout() time.Duration {
+	if len(q.timers) == 0 {
+		return -1
+	}
+	if d := q.timers[0].when.Sub(q.clock()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Expire executes all timers whose deadlines have passed.
+func (q *TimerQueue) Expire() (err error) {
+	now := q.clock()
+	for len(q.timers) > 0 && !q.timers[0].when.After(now) {
+		t := heap.Pop(&q.timers).(*Timer)
+		if err = t.job(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+type timerHeap []*Timer
+
+func (h timerHeap) Len() int           { return len(h) }
+func (h timerHeap) Less(i, j int) bool { return h[i].when.Before(h[j].when) }
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	t := x.(*Timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
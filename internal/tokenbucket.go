@@ -0,0 +1,73 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket is a goroutine-safe token bucket which is refilled lazily whenever it is accessed.
+type TokenBucket struct {
+	lock   sync.Locker
+	rate   float64 // tokens per second
+	burst  float64 // capacity of the bucket
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket instantiates a token bucket with the given rate per second and burst size,
+// the bucket starts full.
+func NewTokenBucket(rate, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		lock:   SpinLock(),
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (tb *TokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(tb.last); elapsed > 0 {
+		tb.tokens += elapsed.Seconds() * tb.rate
+		if tb.tokens > tb.burst {
+			tb.tokens = tb.burst
+		}
+		tb.last = now
+	}
+}
+
+// Available returns the number of whole tokens that can be consumed right now.
+func (tb *TokenBucket) Available() int {
+	tb.lock.Lock()
+	tb.refill(time.Now())
+	n := int(tb.tokens)
+	tb.lock.Unlock()
+	return n
+}
+
+// Consume removes n tokens from the bucket, the bucket may go into debt which is paid back by later refills.
+func (tb *TokenBucket) Consume(n int) {
+	tb.lock.Lock()
+	tb.refill(time.Now())
+	tb.tokens -= float64(n)
+	tb.lock.Unlock()
+}
+
+// Delay returns the duration until at least one token becomes available.
+func (tb *TokenBucket) Delay() time.Duration {
+	tb.lock.Lock()
+	tb.refill(time.Now())
+	deficit := 1 - tb.tokens
+	tb.lock.Unlock()
+	if deficit <= 0 || tb.rate <= 0 {
+		return 0
+	}
+	return time.Duration(deficit / tb.rate * float64(time.Second))
+}
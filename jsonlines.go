@@ -0,0 +1,109 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// JSONLinesConfig sets up a JSONLinesCodec.
+type JSONLinesConfig struct {
+	// Streaming decodes the JSON values with an incremental json.Decoder instead of splitting the stream
+	// on newlines, so that values spanning several lines or following each other on a line are framed as well.
+	// The values are validated then, the connection is closed on malformed JSON.
+	Streaming bool
+
+	// MaxFrameLength is the maximum length of a frame, the decoder fails with ErrFrameTooLarge beyond it,
+	// which closes the connection, zero means no limit.
+	MaxFrameLength int
+}
+
+// JSONLinesCodec encodes/decodes JSON values separated by newlines, as known as JSON lines or NDJSON, into/from
+// TCP stream. The decoder accepts both LF and CRLF and skips empty lines, the frames are raw JSON values to be
+// unmarshalled by the event handler.
+type JSONLinesCodec struct {
+	config JSONLinesConfig
+}
+
+// NewJSONLinesCodec instantiates and returns a JSON lines codec.
+func NewJSONLinesCodec(config JSONLinesConfig) *JSONLinesCodec {
+	return &JSONLinesCodec{config}
+}
+
+// Encode compacts a JSON value spanning several lines into one line and appends a newline.
+func (cc *JSONLinesCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	if bytes.IndexByte(buf, '\n') < 0 {
+		return append(buf, '\n'), nil
+	}
+	var out bytes.Buffer
+	if err := json.Compact(&out, buf); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+// Decode ...
+func (cc *JSONLinesCodec) Decode(c Conn) ([]byte, error) {
+	if cc.config.Streaming {
+		return cc.decodeValue(c)
+	}
+	max := cc.config.MaxFrameLength
+	for {
+		buf := c.Read()
+		idx := bytes.IndexByte(buf, '\n')
+		if idx < 0 {
+			if max > 0 && len(buf) > max+1 {
+				return nil, ErrFrameTooLarge
+			}
+			return nil, ErrCRLFNotFound
+		}
+		line := buf[:idx]
+		if n := len(line); n > 0 && line[n-1] == '\r' {
+			line = line[:n-1]
+		}
+		if max > 0 && len(line) > max {
+			return nil, ErrFrameTooLarge
+		}
+		c.ShiftN(idx + 1)
+		if len(bytes.TrimSpace(line)) > 0 {
+			return line, nil
+		}
+	}
+}
+
+// decodeValue decodes a JSON value with an incremental json.Decoder.
+func (cc *JSONLinesCodec) decodeValue(c Conn) ([]byte, error) {
+	max := cc.config.MaxFrameLength
+	buf := c.Read()
+	r := bytes.NewReader(buf)
+	dec := json.NewDecoder(r)
+	var value json.RawMessage
+	if err := dec.Decode(&value); err != nil {
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, ErrCorruptFrame
+		}
+		if max > 0 && len(bytes.TrimSpace(buf)) > max {
+			return nil, ErrFrameTooLarge
+		}
+		if err == io.EOF {
+			// Nothing but whitespaces.
+			c.ResetBuffer()
+		}
+		return nil, ErrUnexpectedEOF
+	}
+	if max > 0 && len(value) > max {
+		return nil, ErrFrameTooLarge
+	}
+	// The decoder has consumed what it has read from r except for what it has buffered.
+	consumed := len(buf) - r.Len()
+	if rest, ok := dec.Buffered().(*bytes.Reader); ok {
+		consumed -= rest.Len()
+	}
+	c.ShiftN(consumed)
+	return value, nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+// +build !gnet_net
+
+package gnet
+
+import (
+	"math/rand"
+	"time"
+)
+
+// lifetimeJitter is the fraction of Options.ConnMaxLifetime by which the rotation of a connection is brought
+// forward at random, so that the connections opened together are not rotated all at once.
+const lifetimeJitter = 0.1
+
+// armLifetime arms the timer rotating the connection once it reaches Options.ConnMaxLifetime.
+func (el *eventloop) armLifetime(c *conn, maxLifetime time.Duration) {
+	maxLifetime -= time.Duration(float64(maxLifetime) * lifetimeJitter * rand.Float64())
+	el.armRotation(c, time.Now().Add(maxLifetime))
+}
+
+// armRotation arms the timer of the next stage of the rotation of the connection: it is notified by OnDraining
+// at the first deadline and closed at the second one, see Options.ConnRotationGrace.
+func (el *eventloop) armRotation(c *conn, deadline time.Time) {
+	c.lifetimeBy = deadline
+	c.lifetime = el.poller.AddTimer(time.Until(deadline), func() error {
+		c.lifetime = nil
+		if c.rotated {
+			return el.expireConn(c)
+		}
+		return el.loopRotate(c)
+	})
+}
+
+// loopRotate fires OnDraining for the connection which has reached its maximum lifetime, so that the event
+// handler can tell the peer to reconnect, and closes it after the grace period.
+func (el *eventloop) loopRotate(c *conn) error {
+	c.rotated = true
+	out, action := el.eventHandler.OnDraining(c)
+	if !c.opened {
+		return nil // detached by the event handler
+	}
+	if out != nil {
+		frame, _ := c.codec.Encode(c, out)
+		c.write(frame)
+	}
+	grace := el.svr.opts.ConnRotationGrace
+	switch {
+	case action == Shutdown:
+		return ErrServerShutdown
+	case action == Close || grace <= 0:
+		return el.expireConn(c)
+	}
+	el.armRotation(c, time.Now().Add(grace))
+	return nil
+}
+
+// expireConn closes the connection which has been rotated with ErrConnMaxLifetime.
+func (el *eventloop) expireConn(c *conn) error {
+	_ = el.loopWrite(c)
+	return el.loopCloseConn(c, ErrConnMaxLifetime)
+}
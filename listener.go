@@ -6,17 +6,173 @@
 package gnet
 
 import (
+	"context"
 	"net"
 	"os"
+	"runtime"
+	"strings"
 	"sync"
+	"syscall"
+
+	"github.com/panlibin/gnet/internal/netpoll"
 )
 
 type listener struct {
 	f             *os.File
 	fd            int
+	pipeFd        int // the dialing end of the accept queue of a pipe listener
 	ln            net.Listener
 	once          sync.Once
 	pconn         net.PacketConn
 	lnaddr        net.Addr
 	addr, network string
 }
+
+func (ln *listener) listen(opts *Options) (err error) {
+	var controls []func(network, address string, c syscall.RawConn) error
+	// SO_REUSEPORT only shares the IP listeners, newer Linux kernels reject it on the other sockets.
+	if opts.ReusePort && runtime.GOOS != "windows" && !nonIPNetwork(ln.network) {
+		controls = append(controls, netpoll.ReusePortControl)
+	}
+	if opts.BindToDevice != "" {
+		controls = append(controls, bindToDeviceControl(opts.BindToDevice))
+	}
+	if opts.Freebind {
+		controls = append(controls, sockoptControl(netpoll.SetFreebind))
+	}
+	if opts.Transparent {
+		controls = append(controls, sockoptControl(netpoll.SetTransparent))
+	}
+	if opts.Mark != 0 {
+		controls = append(controls, markControl(opts.Mark))
+	}
+	if opts.ExclusiveAddrUse {
+		controls = append(controls, sockoptControl(netpoll.SetExclusiveAddrUse))
+	}
+	if opts.LoopbackFastPath {
+		controls = append(controls, sockoptControl(netpoll.SetLoopbackFastPath))
+	}
+	if opts.ECN {
+		controls = append(controls, sockoptControl(netpoll.SetRecvTOS))
+	}
+	if opts.ICMPErrors {
+		controls = append(controls, sockoptControl(netpoll.SetRecvErr))
+	}
+	if opts.ListenerSocketOptions != nil {
+		// It comes last so that it may override the options above.
+		controls = append(controls, sockoptControl(opts.ListenerSocketOptions))
+	}
+	var lc net.ListenConfig
+	if len(controls) > 0 {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			for _, control := range controls {
+				if err := control(network, address, c); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	network := opts.IPStack.network(ln.network)
+	if strings.HasPrefix(network, "udp") {
+		ln.pconn, err = lc.ListenPacket(context.Background(), network, ln.addr)
+	} else {
+		ln.ln, err = lc.Listen(context.Background(), network, ln.addr)
+	}
+	if err != nil {
+		return
+	}
+	if ln.pconn != nil {
+		ln.lnaddr = ln.pconn.LocalAddr()
+	} else {
+		ln.lnaddr = ln.ln.Addr()
+	}
+	if err = ln.system(); err == nil && ln.ln != nil && opts.ListenBacklog > 0 {
+		err = ln.setBacklog(opts.ListenBacklog)
+	}
+	return
+}
+
+// IPStack decides which IP versions are served by a listener of the "tcp" or "udp" network, e.g. "tcp://:9000".
+type IPStack int
+
+const (
+	// DualStack serves both IPv4 and IPv6 on an unspecified or IPv6 address, IPv4 peers have IPv4 addresses
+	// rather than IPv4-mapped IPv6 addresses. It is the default.
+	DualStack IPStack = iota
+
+	// IPv4Only serves IPv4 only, as the "tcp4" and "udp4" networks do.
+	IPv4Only
+
+	// IPv6Only serves IPv6 only by IPV6_V6ONLY, as the "tcp6" and "udp6" networks do.
+	IPv6Only
+)
+
+// network returns the network to listen on for the given "tcp" or "udp" network.
+func (s IPStack) network(network string) string {
+	if network != "tcp" && network != "udp" {
+		return network
+	}
+	switch s {
+	case IPv4Only:
+		return network + "4"
+	case IPv6Only:
+		return network + "6"
+	}
+	return network
+}
+
+// normalizeAddr converts an IPv4-mapped IPv6 address of a dual-stack socket to an IPv4 address.
+func normalizeAddr(addr net.Addr) net.Addr {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		if ip := a.IP.To4(); ip != nil && len(a.IP) != net.IPv4len {
+			return &net.TCPAddr{IP: ip, Port: a.Port}
+		}
+	case *net.UDPAddr:
+		if ip := a.IP.To4(); ip != nil && len(a.IP) != net.IPv4len {
+			return &net.UDPAddr{IP: ip, Port: a.Port}
+		}
+	}
+	return addr
+}
+
+// pipeAddr is the address of an in-memory pipe.
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipes maps the names of the pipes being served to their dialers.
+var pipes = struct {
+	sync.Mutex
+	dialers map[string]func() (net.Conn, error)
+}{dialers: make(map[string]func() (net.Conn, error))}
+
+func registerPipe(name string, dial func() (net.Conn, error)) error {
+	pipes.Lock()
+	defer pipes.Unlock()
+	if _, ok := pipes.dialers[name]; ok {
+		return ErrPipeInUse
+	}
+	pipes.dialers[name] = dial
+	return nil
+}
+
+func unregisterPipe(name string) {
+	pipes.Lock()
+	delete(pipes.dialers, name)
+	pipes.Unlock()
+}
+
+// DialPipe connects to the server serving on the in-memory pipe with the given name, the connection
+// is handled by the event-loops of the server just like a network connection but no port is bound.
+func DialPipe(name string) (net.Conn, error) {
+	pipes.Lock()
+	dial, ok := pipes.dialers[name]
+	pipes.Unlock()
+	if !ok {
+		return nil, ErrPipeNotFound
+	}
+	return dial()
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Copyright 2018 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows gnet_net
+
+package gnet
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+)
+
+func (ln *listener) close() {
+	ln.once.Do(func() {
+		if ln.ln != nil {
+			sniffError(ln.ln.Close())
+		}
+		if ln.pconn != nil {
+			sniffError(ln.pconn.Close())
+		}
+		if ln.network == "unix" {
+			sniffError(os.RemoveAll(ln.addr))
+		}
+	})
+}
+
+// listenPipe sets up an in-memory pipe listener whose connections are created by net.Pipe.
+func (ln *listener) listenPipe() error {
+	pl := &pipeListener{name: ln.addr, conns: make(chan net.Conn), done: make(chan struct{})}
+	if err := registerPipe(ln.addr, pl.dial); err != nil {
+		return err
+	}
+	ln.ln, ln.lnaddr = pl, pl.Addr()
+	return nil
+}
+
+var errPipeClosed = errors.New("pipe listener is closed")
+
+type pipeListener struct {
+	name  string
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (pl *pipeListener) dial() (net.Conn, error) {
+	c1, c2 := net.Pipe()
+	select {
+	case pl.conns <- &namedPipeConn{c2, pipeAddr(pl.name)}:
+		return c1, nil
+	case <-pl.done:
+		return nil, ErrPipeNotFound
+	}
+}
+
+func (pl *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-pl.conns:
+		return c, nil
+	case <-pl.done:
+		return nil, errPipeClosed
+	}
+}
+
+func (pl *pipeListener) Close() error {
+	pl.once.Do(func() {
+		unregisterPipe(pl.name)
+		close(pl.done)
+	})
+	return nil
+}
+
+func (pl *pipeListener) Addr() net.Addr {
+	return pipeAddr(pl.name)
+}
+
+// namedPipeConn is the accepted end of a pipe, whose addresses are the name of the pipe as on unix.
+type namedPipeConn struct {
+	net.Conn
+	addr pipeAddr
+}
+
+func (c *namedPipeConn) LocalAddr() net.Addr  { return c.addr }
+func (c *namedPipeConn) RemoteAddr() net.Addr { return c.addr }
+
+// listenVsock fails since the connections of the net transport are net.Conns.
+func (ln *listener) listenVsock(opts *Options) error {
+	return ErrProtocolNotSupported
+}
+
+func (ln *listener) system() error {
+	return nil
+}
+
+// setBacklog is never invoked on Windows, where listen(2) on a listening socket does not update its backlog.
+func (ln *listener) setBacklog(backlog int) error {
+	return nil
+}
+
+// stats fills in the stats of the accept queue, which are not available on Windows.
+func (ln *listener) stats(s *Stats) {}
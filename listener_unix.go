@@ -4,13 +4,16 @@
 // license that can be found in the LICENSE file.
 
 // +build linux darwin netbsd freebsd openbsd dragonfly
+// +build !gnet_net
 
 package gnet
 
 import (
 	"net"
 	"os"
+	"runtime"
 
+	"github.com/panlibin/gnet/internal/netpoll"
 	"golang.org/x/sys/unix"
 )
 
@@ -29,6 +32,14 @@ func (ln *listener) close() {
 			if ln.network == "unix" {
 				sniffError(os.RemoveAll(ln.addr))
 			}
+			if ln.network == "pipe" && ln.lnaddr != nil {
+				unregisterPipe(ln.addr)
+				sniffError(unix.Close(ln.fd))
+				sniffError(unix.Close(ln.pipeFd))
+			}
+			if ln.network == "vsock" && ln.lnaddr != nil {
+				sniffError(unix.Close(ln.fd))
+			}
 		})
 }
 
@@ -54,3 +65,106 @@ func (ln *listener) system() error {
 	ln.fd = int(ln.f.Fd())
 	return unix.SetNonblock(ln.fd, true)
 }
+
+// setBacklog sets up the backlog of the listener, listen(2) on a listening socket only updates its backlog.
+func (ln *listener) setBacklog(backlog int) error {
+	if err := unix.Listen(ln.fd, backlog); err != nil {
+		ln.close()
+		return os.NewSyscallError("listen", err)
+	}
+	return nil
+}
+
+// stats fills in the stats of the accept queue.
+func (ln *listener) stats(s *Stats) {
+	if ln.ln == nil {
+		return
+	}
+	s.AcceptBacklog, s.AcceptBacklogLimit, _ = netpoll.ListenBacklog(ln.fd)
+	s.ListenOverflows, s.ListenDrops, _ = netpoll.ListenDrops()
+}
+
+// listenPipe sets up an in-memory pipe listener, the connections are socket pairs whose server ends
+// are passed through a datagram socket pair which takes the place of the accept queue.
+func (ln *listener) listenPipe() error {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return os.NewSyscallError("socketpair", err)
+	}
+	if err = registerPipe(ln.addr, ln.dialPipe); err != nil {
+		_ = unix.Close(fds[0])
+		_ = unix.Close(fds[1])
+		return err
+	}
+	ln.fd, ln.pipeFd = fds[0], fds[1]
+	ln.lnaddr = pipeAddr(ln.addr)
+	return unix.SetNonblock(ln.fd, true)
+}
+
+func (ln *listener) dialPipe() (net.Conn, error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socketpair", err)
+	}
+	unix.CloseOnExec(fds[0])
+	err = unix.Sendmsg(ln.pipeFd, []byte{0}, unix.UnixRights(fds[1]), nil, 0)
+	_ = unix.Close(fds[1])
+	if err != nil {
+		_ = unix.Close(fds[0])
+		return nil, os.NewSyscallError("sendmsg", err)
+	}
+	f := os.NewFile(uintptr(fds[0]), "pipe:"+ln.addr)
+	defer f.Close()
+	return net.FileConn(f)
+}
+
+// listenVsock sets up a vsock listener by hand as the net package has no vsock support.
+func (ln *listener) listenVsock(opts *Options) error {
+	if runtime.GOOS != "linux" {
+		return ErrProtocolNotSupported
+	}
+	addr, err := parseVsockAddr(ln.addr)
+	if err != nil {
+		return err
+	}
+	backlog := opts.ListenBacklog
+	if backlog <= 0 {
+		backlog = unix.SOMAXCONN
+	}
+	fd, cid, port, err := netpoll.ListenVsock(addr.CID, addr.Port, backlog, opts.ListenerSocketOptions)
+	if err != nil {
+		return &net.OpError{Op: "listen", Net: "vsock", Addr: addr, Err: err}
+	}
+	ln.fd, ln.lnaddr = fd, &VsockAddr{CID: cid, Port: port}
+	return nil
+}
+
+// sockaddrToAddr converts the Sockaddr of a stream socket to a net.Addr.
+func sockaddrToAddr(sa unix.Sockaddr) net.Addr {
+	if cid, port, ok := netpoll.SockaddrVM(sa); ok {
+		return &VsockAddr{CID: cid, Port: port}
+	}
+	return netpoll.SockaddrToTCPOrUnixAddr(sa)
+}
+
+// accept accepts a new connection from the listener.
+func (ln *listener) accept() (int, unix.Sockaddr, error) {
+	if ln.network != "pipe" {
+		return unix.Accept(ln.fd)
+	}
+	var buf [1]byte
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(ln.fd, buf[:], oob, 0)
+	if err != nil {
+		return -1, nil, err
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) == 0 {
+		return -1, nil, unix.EAGAIN
+	}
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) == 0 {
+		return -1, nil, unix.EAGAIN
+	}
+	return fds[0], &unix.SockaddrUnix{Name: ln.addr}, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+// +build !gnet_net
+
+package gnet
+
+import (
+	"time"
+
+	"github.com/panlibin/gnet/internal/netpoll"
+)
+
+func (el *eventloop) handleEvent(fd int, ev netpoll.IOEvent) error {
+	if c, ok := el.connections[fd]; ok {
+		return el.handleConnEvent(c, ev)
+	}
+	if s, ok := el.sources[fd]; ok {
+		return el.loopSource(s, ev&netpoll.InEvents != 0, ev&netpoll.OutEvents != 0)
+	}
+	return el.loopAccept(fd)
+}
+
+// handleEvents handles a batch of the ready events of the sub reactor, the events of a connection closed
+// by an earlier event of the batch are skipped.
+func (el *eventloop) handleEvents(events []netpoll.Event) (err error) {
+	var polled time.Time
+	if el.metrics != nil {
+		polled = time.Now()
+	}
+	for _, ev := range events {
+		if el.metrics != nil {
+			el.metrics.EventLatency.Record(int64(time.Since(polled)))
+		}
+		if c, ok := el.connections[ev.Fd]; ok {
+			err = el.handleConnEvent(c, ev.Events)
+		} else if s, ok := el.sources[ev.Fd]; ok {
+			err = el.loopSource(s, ev.Events&netpoll.InEvents != 0, ev.Events&netpoll.OutEvents != 0)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (el *eventloop) handleConnEvent(c *conn, ev netpoll.IOEvent) error {
+	switch c.outboundBuffer.IsEmpty() {
+	// Don't change the ordering of processing the writable / readable and hang-up events unless you're 100%
+	// sure what you're doing!
+	// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
+	case false:
+		if ev&netpoll.OutEvents != 0 {
+			return el.loopWrite(c)
+		}
+	case true:
+		if ev&netpoll.InEvents != 0 {
+			return el.loopRead(c, ev)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/panlibin/gnet/internal/netpoll"
+)
+
+// NewMarkDialer returns a dialer whose sockets are marked by SO_MARK, so that the upstream connections of
+// a proxy take part in policy routing and nftables rules, Linux only. It requires CAP_NET_ADMIN.
+func NewMarkDialer(mark uint32) *net.Dialer {
+	return &net.Dialer{Control: markControl(mark)}
+}
+
+// markControl returns a net.Dialer/net.ListenConfig control function marking sockets by SO_MARK.
+func markControl(mark uint32) func(network, address string, c syscall.RawConn) error {
+	return sockoptControl(func(fd int) error {
+		return netpoll.SetMark(fd, mark)
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+// Middleware decorates an EventHandler with cross-cutting behaviors such as logging, metrics or authentication.
+//
+// A middleware usually returns a struct embedding next and overriding the methods it intercepts,
+// the overridden methods call into next to pass the events on:
+//
+//	type logging struct{ gnet.EventHandler }
+//
+//	func (l logging) OnOpened(c gnet.Conn) ([]byte, gnet.Action) {
+//		log.Printf("%s connected", c.RemoteAddr())
+//		return l.EventHandler.OnOpened(c)
+//	}
+//
+//	handler := gnet.Chain(echo, func(next gnet.EventHandler) gnet.EventHandler { return logging{next} })
+type Middleware func(next EventHandler) EventHandler
+
+// Chain decorates the handler with the middlewares, the first middleware is the outermost one,
+// which means it sees the events first and the outputs of the handler last.
+func Chain(handler EventHandler, middlewares ...Middleware) EventHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Interceptor is a Middleware built from functions, the nil functions pass the events through.
+// Each function receives the event and the next EventHandler to which the event may be passed on.
+type Interceptor struct {
+	OnOpened func(c Conn, next EventHandler) (out []byte, action Action)
+	React    func(frame []byte, c Conn, next EventHandler) (out []byte, action Action)
+	OnClosed func(c Conn, err error, next EventHandler) (action Action)
+}
+
+// Middleware returns the Middleware of the interceptor.
+func (i Interceptor) Middleware() Middleware {
+	return func(next EventHandler) EventHandler {
+		return &interceptedHandler{EventHandler: next, i: i}
+	}
+}
+
+type interceptedHandler struct {
+	EventHandler
+	i Interceptor
+}
+
+func (h *interceptedHandler) OnOpened(c Conn) (out []byte, action Action) {
+	if h.i.OnOpened == nil {
+		return h.EventHandler.OnOpened(c)
+	}
+	return h.i.OnOpened(c, h.EventHandler)
+}
+
+func (h *interceptedHandler) React(frame []byte, c Conn) (out []byte, action Action) {
+	if h.i.React == nil {
+		return h.EventHandler.React(frame, c)
+	}
+	return h.i.React(frame, c, h.EventHandler)
+}
+
+func (h *interceptedHandler) OnClosed(c Conn, err error) (action Action) {
+	if h.i.OnClosed == nil {
+		return h.EventHandler.OnClosed(c, err)
+	}
+	return h.i.OnClosed(c, err, h.EventHandler)
+}
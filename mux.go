@@ -0,0 +1,357 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+const (
+	muxVersion    = 1
+	muxHeaderSize = 8
+
+	defaultMuxFrameSize    = 32 * 1024
+	defaultMuxStreamWindow = 256 * 1024
+)
+
+// The commands of the multiplexing frames.
+const (
+	muxSYN byte = iota // opens a stream
+	muxFIN             // closes a stream
+	muxPSH             // carries stream data
+	muxNOP             // keeps the session alive
+	muxUPD             // grants the peer more send window
+)
+
+// MuxCodec encodes/decodes the frames of a MuxSession into/from TCP stream. Each frame consists of an 8-byte
+// header, i.e. the version, the command, the big-endian 2-byte payload length and 4-byte stream id, and the
+// payload. The frames are built by MuxSession, so Encode passes the data through as it is.
+type MuxCodec struct{}
+
+// NewMuxCodec instantiates and returns a multiplexing codec.
+func NewMuxCodec() *MuxCodec {
+	return new(MuxCodec)
+}
+
+// Encode ...
+func (cc *MuxCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	return buf, nil
+}
+
+// Decode ...
+func (cc *MuxCodec) Decode(c Conn) ([]byte, error) {
+	size, header := c.ReadN(muxHeaderSize)
+	if size != muxHeaderSize {
+		return nil, ErrUnexpectedEOF
+	}
+	if header[0] != muxVersion {
+		return nil, ErrCorruptFrame
+	}
+	frameSize := muxHeaderSize + int(binary.BigEndian.Uint16(header[2:4]))
+	size, frame := c.ReadN(frameSize)
+	if size != frameSize {
+		return nil, ErrUnexpectedEOF
+	}
+	c.ShiftN(frameSize)
+	return frame, nil
+}
+
+// MuxConfig sets up a MuxSession.
+type MuxConfig struct {
+	// Client makes the session open the streams of odd ids, the peer must be a server session opening the
+	// streams of even ids.
+	Client bool
+
+	// MaxFrameSize is the maximum payload size of a frame, defaults to 32KiB, at most 65535.
+	MaxFrameSize int
+
+	// StreamWindow is the number of bytes a stream may send before the peer grants it more window,
+	// defaults to 256KiB, both sides of a session must agree on it.
+	StreamWindow int
+
+	// MaxStreams is the maximum number of streams of the session, the streams opened by the peer beyond it are
+	// closed at once, zero means no limit.
+	MaxStreams int
+}
+
+// MuxHandler receives the events of the streams of a MuxSession, the events are dispatched on the goroutine
+// invoking MuxSession.Handle, i.e. the event-loop of the connection for a server.
+type MuxHandler interface {
+	// OnStreamOpen fires when the peer has opened a stream.
+	OnStreamOpen(s *Stream)
+
+	// OnStreamData fires when data has arrived on a stream, data is only valid during the call.
+	// The peer is granted more send window once the call has returned.
+	OnStreamData(s *Stream, data []byte)
+
+	// OnStreamClose fires when a stream has been closed by the peer or along with the session.
+	OnStreamClose(s *Stream)
+}
+
+// MuxSession multiplexes many logical streams over one connection framed by MuxCodec with per-stream flow
+// control. On a server, create a session in OnOpened with AsyncWrite as send, hand the frames to Handle
+// in React and close the session in OnClosed. On a Client, use Client.Write as send and Handle in OnFrame.
+type MuxSession struct {
+	mu      sync.Mutex
+	config  MuxConfig
+	send    func(data []byte) error
+	handler MuxHandler
+	streams map[uint32]*Stream
+	nextID  uint32
+	closed  bool
+}
+
+// NewMuxSession instantiates a session which sends its frames by send.
+func NewMuxSession(send func(data []byte) error, handler MuxHandler, config MuxConfig) *MuxSession {
+	if config.MaxFrameSize <= 0 || config.MaxFrameSize > 0xffff {
+		config.MaxFrameSize = defaultMuxFrameSize
+	}
+	if config.StreamWindow <= 0 {
+		config.StreamWindow = defaultMuxStreamWindow
+	}
+	m := &MuxSession{
+		config:  config,
+		send:    send,
+		handler: handler,
+		streams: make(map[uint32]*Stream),
+		nextID:  2,
+	}
+	if config.Client {
+		m.nextID = 1
+	}
+	return m
+}
+
+// OpenStream opens a new stream.
+func (m *MuxSession) OpenStream() (*Stream, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrConnectionClosed
+	}
+	if m.config.MaxStreams > 0 && len(m.streams) >= m.config.MaxStreams {
+		return nil, ErrTooManyStreams
+	}
+	s := m.newStream(m.nextID)
+	m.nextID += 2
+	if err := m.send(muxFrame(nil, muxSYN, s.id, nil)); err != nil {
+		delete(m.streams, s.id)
+		return nil, err
+	}
+	return s, nil
+}
+
+// NumStreams returns the number of open streams.
+func (m *MuxSession) NumStreams() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.streams)
+}
+
+// Handle handles a frame decoded by MuxCodec, it fails with ErrCorruptFrame when the peer violates the
+// protocol, upon which the connection should be closed.
+func (m *MuxSession) Handle(frame []byte) error {
+	if len(frame) < muxHeaderSize {
+		return ErrCorruptFrame
+	}
+	cmd, id, payload := frame[1], binary.BigEndian.Uint32(frame[4:8]), frame[muxHeaderSize:]
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	s := m.streams[id]
+	switch cmd {
+	case muxSYN:
+		if s != nil {
+			m.mu.Unlock()
+			return ErrCorruptFrame
+		}
+		if m.config.MaxStreams > 0 && len(m.streams) >= m.config.MaxStreams {
+			err := m.send(muxFrame(nil, muxFIN, id, nil))
+			m.mu.Unlock()
+			return err
+		}
+		s = m.newStream(id)
+		m.mu.Unlock()
+		m.handler.OnStreamOpen(s)
+	case muxFIN:
+		if s != nil {
+			delete(m.streams, id)
+			s.closed = true
+			if !s.finSent {
+				s.finSent = true
+				_ = m.send(muxFrame(nil, muxFIN, id, nil))
+			}
+		}
+		m.mu.Unlock()
+		if s != nil {
+			m.handler.OnStreamClose(s)
+		}
+	case muxPSH:
+		if s == nil {
+			// The data in flight to a stream closed locally is dropped.
+			m.mu.Unlock()
+			return nil
+		}
+		if s.recvWindow -= len(payload); s.recvWindow < 0 {
+			m.mu.Unlock()
+			return ErrCorruptFrame
+		}
+		m.mu.Unlock()
+		m.handler.OnStreamData(s, payload)
+		m.mu.Lock()
+		if s.consumed += len(payload); !s.closed && s.consumed >= m.config.StreamWindow/2 {
+			var inc [4]byte
+			binary.BigEndian.PutUint32(inc[:], uint32(s.consumed))
+			s.recvWindow += s.consumed
+			s.consumed = 0
+			_ = m.send(muxFrame(nil, muxUPD, id, inc[:]))
+		}
+		m.mu.Unlock()
+	case muxUPD:
+		if len(payload) != 4 {
+			m.mu.Unlock()
+			return ErrCorruptFrame
+		}
+		var err error
+		if s != nil {
+			s.sendWindow += int(binary.BigEndian.Uint32(payload))
+			err = s.flush()
+		}
+		m.mu.Unlock()
+		return err
+	case muxNOP:
+		m.mu.Unlock()
+	default:
+		m.mu.Unlock()
+		return ErrCorruptFrame
+	}
+	return nil
+}
+
+// Close closes the session and fires OnStreamClose for the open streams, the peer is not notified since the
+// session is meant to be closed along with the connection.
+func (m *MuxSession) Close() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	streams := m.streams
+	m.streams = nil
+	for _, s := range streams {
+		s.closed = true
+		s.pending = nil
+	}
+	m.mu.Unlock()
+	for _, s := range streams {
+		m.handler.OnStreamClose(s)
+	}
+}
+
+func (m *MuxSession) newStream(id uint32) *Stream {
+	s := &Stream{
+		id:         id,
+		m:          m,
+		sendWindow: m.config.StreamWindow,
+		recvWindow: m.config.StreamWindow,
+	}
+	m.streams[id] = s
+	return s
+}
+
+// muxFrame appends a frame to dst.
+func muxFrame(dst []byte, cmd byte, id uint32, payload []byte) []byte {
+	var header [muxHeaderSize]byte
+	header[0], header[1] = muxVersion, cmd
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(payload)))
+	binary.BigEndian.PutUint32(header[4:8], id)
+	return append(append(dst, header[:]...), payload...)
+}
+
+// Stream is a logical stream of a MuxSession.
+type Stream struct {
+	id         uint32
+	m          *MuxSession
+	ctx        interface{}
+	sendWindow int    // bytes the stream may send before the peer grants more
+	recvWindow int    // bytes the peer may send before the stream grants more
+	consumed   int    // bytes received but not granted back to the peer yet
+	pending    []byte // data held back by the send window
+	closing    bool   // FIN is sent once the pending data has been flushed
+	finSent    bool
+	closed     bool
+}
+
+// ID returns the id of the stream.
+func (s *Stream) ID() uint32 { return s.id }
+
+// Context returns a user-defined context.
+func (s *Stream) Context() interface{} { return s.ctx }
+
+// SetContext sets a user-defined context.
+func (s *Stream) SetContext(ctx interface{}) { s.ctx = ctx }
+
+// Write sends data on the stream, the data exceeding the send window is buffered until the peer grants
+// more window, see Buffered.
+func (s *Stream) Write(data []byte) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if s.closed || s.closing {
+		return ErrConnectionClosed
+	}
+	s.pending = append(s.pending, data...)
+	return s.flush()
+}
+
+// Buffered returns the number of bytes held back by the send window.
+func (s *Stream) Buffered() int {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	return len(s.pending)
+}
+
+// Close closes the stream once the buffered data has been sent, OnStreamClose does not fire for it.
+func (s *Stream) Close() error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if s.closed || s.closing {
+		return nil
+	}
+	s.closing = true
+	return s.flush()
+}
+
+// flush sends the pending data within the send window, it must be invoked with the session locked.
+func (s *Stream) flush() error {
+	var out []byte
+	for len(s.pending) > 0 && s.sendWindow > 0 {
+		n := len(s.pending)
+		if n > s.m.config.MaxFrameSize {
+			n = s.m.config.MaxFrameSize
+		}
+		if n > s.sendWindow {
+			n = s.sendWindow
+		}
+		out = muxFrame(out, muxPSH, s.id, s.pending[:n])
+		s.pending = s.pending[n:]
+		s.sendWindow -= n
+	}
+	if len(s.pending) == 0 {
+		s.pending = nil
+		if s.closing && !s.finSent {
+			s.finSent, s.closed = true, true
+			delete(s.m.streams, s.id)
+			out = muxFrame(out, muxFIN, s.id, nil)
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	return s.m.send(out)
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"net"
+	"time"
+)
+
+// UDPNAT sets up the NAT-style session table of a UDP server for proxying, e.g. SOCKS5 UDP ASSOCIATE or
+// game relays. ForwardUDP sends the datagrams of a client to an upstream from the socket of their session,
+// which is opened by the first datagram, the replies of the upstream are sent back to the client through
+// the listener, and the session expires once it has been idle for IdleTimeout.
+type UDPNAT struct {
+	// IdleTimeout is how long a session lives without datagrams in either direction, zero disables the table.
+	IdleTimeout time.Duration
+
+	// Reply rewrites the replies of the upstreams before they are sent back to the clients, e.g. to prepend
+	// the SOCKS5 UDP header, returning nil drops the reply. It is invoked on the event-loops, the replies are
+	// sent back as they are if it is nil.
+	Reply func(client, upstream *net.UDPAddr, reply []byte) []byte
+}
+
+// sweepInterval returns the interval of looking for the idle sessions.
+func (nat UDPNAT) sweepInterval() time.Duration {
+	if d := nat.IdleTimeout / 4; d > 10*time.Millisecond {
+		return d
+	}
+	return 10 * time.Millisecond
+}
+
+// ForwardUDP sends the datagram to the upstream through the NAT session of the client of c and the upstream,
+// opening the session if there is none, see UDPNAT. It must be invoked in React for a UDP datagram, and it fails
+// with ErrProtocolNotSupported for TCP, on Windows and unless the server is set up by WithUDPNAT.
+func ForwardUDP(c Conn, upstream *net.UDPAddr, data []byte) error {
+	if c, ok := c.(interface {
+		forward(upstream *net.UDPAddr, data []byte) error
+	}); ok {
+		return c.forward(upstream, data)
+	}
+	return ErrProtocolNotSupported
+}
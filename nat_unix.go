@@ -0,0 +1,167 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+// +build !gnet_net
+
+package gnet
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panlibin/gnet/internal/netpoll"
+	"golang.org/x/sys/unix"
+)
+
+// natTable maps the pairs of clients and upstreams to their sessions, see UDPNAT. The table is shared by
+// the event-loops since the datagrams of a client may be read by any of them, while the socket of a session
+// is polled and expired by the event-loop which has opened it.
+type natTable struct {
+	mu       sync.Mutex
+	sessions map[natKey]*natSession
+}
+
+type natKey struct {
+	client, upstream string
+}
+
+// natSession is the socket of a client talking to an upstream.
+type natSession struct {
+	mu         sync.Mutex    // guards fd against the expiry for the other event-loops
+	fd         int           // connected to the upstream, -1 once the session has expired
+	key        natKey        // key in the table
+	client     unix.Sockaddr // the replies are sent to
+	clientAddr *net.UDPAddr
+	upstream   *net.UDPAddr
+	active     int64 // unix nanoseconds of the last datagram in either direction, accessed atomically
+}
+
+func newNATTable() *natTable {
+	return &natTable{sessions: make(map[natKey]*natSession)}
+}
+
+func (c *conn) forward(upstream *net.UDPAddr, data []byte) error {
+	el := c.udpLoop
+	if el == nil || el.svr.nat == nil {
+		return ErrProtocolNotSupported
+	}
+	for {
+		s, err := el.svr.nat.session(el, c, upstream)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		if s.fd < 0 {
+			// The session has expired since, a new one is opened.
+			s.mu.Unlock()
+			continue
+		}
+		atomic.StoreInt64(&s.active, time.Now().UnixNano())
+		_, err = unix.Write(s.fd, data)
+		s.mu.Unlock()
+		return err
+	}
+}
+
+// session returns the session of the client of c and the upstream, it is opened on the event-loop if there is none.
+func (nat *natTable) session(el *eventloop, c *conn, upstream *net.UDPAddr) (*natSession, error) {
+	key := natKey{c.remoteAddr.String(), upstream.String()}
+	nat.mu.Lock()
+	defer nat.mu.Unlock()
+	if s := nat.sessions[key]; s != nil {
+		return s, nil
+	}
+	fd, err := netpoll.DialUDP(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if mark := el.svr.opts.Mark; mark != 0 {
+		_ = netpoll.SetMark(fd, mark)
+	}
+	if err = el.poller.AddRead(fd); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	s := &natSession{
+		fd:         fd,
+		key:        key,
+		client:     c.sa,
+		clientAddr: c.remoteAddr.(*net.UDPAddr),
+		upstream:   upstream,
+	}
+	nat.sessions[key] = s
+	el.natSessions[fd] = s
+	atomic.AddInt64(&el.svr.stats.natSessions, 1)
+	return s, nil
+}
+
+// loopReadNAT reads a reply of the upstream of the session and sends it back to the client.
+func (el *eventloop) loopReadNAT(s *natSession) error {
+	n, err := unix.Read(s.fd, el.packet)
+	if err != nil || n == 0 {
+		// e.g. ECONNREFUSED from an upstream which is not listening, the session expires in the end.
+		return nil
+	}
+	atomic.StoreInt64(&s.active, time.Now().UnixNano())
+	reply := el.packet[:n]
+	if fn := el.svr.opts.UDPNAT.Reply; fn != nil {
+		if reply = fn(s.clientAddr, s.upstream, reply); reply == nil {
+			return nil
+		}
+	}
+	_ = unix.Sendto(el.svr.ln.fd, reply, 0, s.client)
+	return nil
+}
+
+// watchNAT arms the timer expiring the idle sessions of the event-loop, if UDPNAT.IdleTimeout is set.
+func (el *eventloop) watchNAT() {
+	if el.svr.nat == nil {
+		return
+	}
+	nat := el.svr.opts.UDPNAT
+	el.poller.AddTimer(nat.sweepInterval(), func() error {
+		el.expireNAT(time.Now().Add(-nat.IdleTimeout).UnixNano())
+		el.watchNAT()
+		return nil
+	})
+}
+
+// expireNAT closes the sessions of the event-loop which have been idle since before.
+func (el *eventloop) expireNAT(before int64) {
+	nat := el.svr.nat
+	for _, s := range el.natSessions {
+		if atomic.LoadInt64(&s.active) > before {
+			continue
+		}
+		nat.mu.Lock()
+		s.mu.Lock()
+		// A datagram may have been forwarded by another event-loop in the meantime.
+		if atomic.LoadInt64(&s.active) <= before {
+			delete(nat.sessions, s.key)
+			el.closeNATSession(s)
+		}
+		s.mu.Unlock()
+		nat.mu.Unlock()
+	}
+}
+
+// closeNATSessions closes all the sessions of the event-loop after it has exited.
+func (el *eventloop) closeNATSessions() {
+	for _, s := range el.natSessions {
+		s.mu.Lock()
+		el.closeNATSession(s)
+		s.mu.Unlock()
+	}
+}
+
+func (el *eventloop) closeNATSession(s *natSession) {
+	_ = el.poller.Delete(s.fd)
+	sniffError(unix.Close(s.fd))
+	delete(el.natSessions, s.fd)
+	s.fd = -1
+	atomic.AddInt64(&el.svr.stats.natSessions, -1)
+}
@@ -0,0 +1,193 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	netlinkHeaderSize = 16
+	netlinkAttrSize   = 4
+	netlinkBufferSize = 32 << 10
+)
+
+// Netlink protocol families, see OpenNetlink.
+const (
+	NetlinkRoute     = 0
+	NetlinkNetfilter = 12
+)
+
+// Netlink message types shared by all the families.
+const (
+	NetlinkNoop    uint16 = 0x1
+	NetlinkError   uint16 = 0x2
+	NetlinkDone    uint16 = 0x3
+	NetlinkOverrun uint16 = 0x4
+)
+
+// Netlink message flags.
+const (
+	NetlinkFlagRequest uint16 = 0x1
+	NetlinkFlagMulti   uint16 = 0x2
+	NetlinkFlagAck     uint16 = 0x4
+	NetlinkFlagDump    uint16 = 0x300
+)
+
+// netlinkByteOrder is the byte order of the host, which the netlink headers are encoded in.
+var netlinkByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// NetlinkMessage is a netlink message, its Data is the payload following the header.
+type NetlinkMessage struct {
+	Type  uint16
+	Flags uint16
+	Seq   uint32
+	PID   uint32
+	Data  []byte
+}
+
+// Err returns the error carried by a NetlinkError message, nil if it is an acknowledgement or another type of
+// message.
+func (m NetlinkMessage) Err() error {
+	if m.Type != NetlinkError || len(m.Data) < 4 {
+		return nil
+	}
+	if errno := int32(netlinkByteOrder.Uint32(m.Data)); errno < 0 {
+		return syscall.Errno(-errno)
+	}
+	return nil
+}
+
+// NetlinkAttr is a netlink attribute, its Type includes the nested and byte order flags if any.
+type NetlinkAttr struct {
+	Type  uint16
+	Value []byte
+}
+
+func netlinkAlign(n int) int {
+	return (n + 3) &^ 3
+}
+
+// ParseNetlinkMessages splits b into netlink messages, whose Data alias b.
+// It fails with ErrMalformedNetlink if b is not a sequence of whole messages.
+func ParseNetlinkMessages(b []byte) ([]NetlinkMessage, error) {
+	var msgs []NetlinkMessage
+	for len(b) > 0 {
+		if len(b) < netlinkHeaderSize {
+			return msgs, ErrMalformedNetlink
+		}
+		n := int(netlinkByteOrder.Uint32(b))
+		if n < netlinkHeaderSize || n > len(b) {
+			return msgs, ErrMalformedNetlink
+		}
+		msgs = append(msgs, NetlinkMessage{
+			Type:  netlinkByteOrder.Uint16(b[4:]),
+			Flags: netlinkByteOrder.Uint16(b[6:]),
+			Seq:   netlinkByteOrder.Uint32(b[8:]),
+			PID:   netlinkByteOrder.Uint32(b[12:]),
+			Data:  b[netlinkHeaderSize:n],
+		})
+		if n = netlinkAlign(n); n > len(b) {
+			n = len(b)
+		}
+		b = b[n:]
+	}
+	return msgs, nil
+}
+
+// AppendNetlinkMessage appends the message to dst, padded to the netlink alignment.
+func AppendNetlinkMessage(dst []byte, m NetlinkMessage) []byte {
+	n := netlinkHeaderSize + len(m.Data)
+	var hdr [netlinkHeaderSize]byte
+	netlinkByteOrder.PutUint32(hdr[0:], uint32(n))
+	netlinkByteOrder.PutUint16(hdr[4:], m.Type)
+	netlinkByteOrder.PutUint16(hdr[6:], m.Flags)
+	netlinkByteOrder.PutUint32(hdr[8:], m.Seq)
+	netlinkByteOrder.PutUint32(hdr[12:], m.PID)
+	dst = append(dst, hdr[:]...)
+	dst = append(dst, m.Data...)
+	return append(dst, make([]byte, netlinkAlign(n)-n)...)
+}
+
+// ParseNetlinkAttrs splits b, e.g. the payload of a message past its family header, into netlink attributes,
+// whose Values alias b. It fails with ErrMalformedNetlink if b is not a sequence of whole attributes.
+func ParseNetlinkAttrs(b []byte) ([]NetlinkAttr, error) {
+	var attrs []NetlinkAttr
+	for len(b) > 0 {
+		if len(b) < netlinkAttrSize {
+			return attrs, ErrMalformedNetlink
+		}
+		n := int(netlinkByteOrder.Uint16(b))
+		if n < netlinkAttrSize || n > len(b) {
+			return attrs, ErrMalformedNetlink
+		}
+		attrs = append(attrs, NetlinkAttr{Type: netlinkByteOrder.Uint16(b[2:]), Value: b[netlinkAttrSize:n]})
+		if n = netlinkAlign(n); n > len(b) {
+			n = len(b)
+		}
+		b = b[n:]
+	}
+	return attrs, nil
+}
+
+// AppendNetlinkAttr appends the attribute to dst, padded to the netlink alignment.
+func AppendNetlinkAttr(dst []byte, a NetlinkAttr) []byte {
+	n := netlinkAttrSize + len(a.Value)
+	var hdr [netlinkAttrSize]byte
+	netlinkByteOrder.PutUint16(hdr[0:], uint16(n))
+	netlinkByteOrder.PutUint16(hdr[2:], a.Type)
+	dst = append(dst, hdr[:]...)
+	dst = append(dst, a.Value...)
+	return append(dst, make([]byte, netlinkAlign(n)-n)...)
+}
+
+// Netlink is a netlink socket polled by an event-loop of a server, see GServer.OpenNetlink.
+type Netlink struct {
+	fd       int
+	pid      uint32
+	src      *Source
+	handler  func(msg NetlinkMessage) error
+	buf      []byte
+	seq      uint32 // the last sequence number assigned by Send, accessed atomically
+	overruns uint64 // accessed atomically
+}
+
+// OpenNetlink opens a netlink socket of the protocol family, e.g. NetlinkRoute, subscribed to the multicast
+// groups in the bitmask and polls it on the idx-th event-loop of the server. The handler runs on the event-loop
+// for every message received, whose Data is only valid until it returns; the socket is closed once the handler
+// returns an error, ErrServerShutdown shuts the server down as well. It fails with ErrProtocolNotSupported on
+// platforms other than Linux and with the net transport.
+func (s *GServer) OpenNetlink(idx, proto int, groups uint32, handler func(msg NetlinkMessage) error) (*Netlink,
+	error) {
+	if s.s == nil {
+		return nil, ErrServerNotStarted
+	}
+	return s.openNetlink(idx, proto, groups, handler)
+}
+
+// PID returns the port ID the socket is bound to, which the kernel addresses its unicast replies to.
+func (nl *Netlink) PID() uint32 {
+	return nl.pid
+}
+
+// Overruns returns how many times the receive buffer of the socket has overrun, the kernel drops the messages
+// which don't fit in it, e.g. the events of a busy conntrack table.
+func (nl *Netlink) Overruns() uint64 {
+	return atomic.LoadUint64(&nl.overruns)
+}
+
+// Close removes the socket from the event-loop and closes it.
+func (nl *Netlink) Close() error {
+	return nl.src.Close()
+}
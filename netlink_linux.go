@@ -0,0 +1,89 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package gnet
+
+import (
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+func (s *GServer) openNetlink(idx, proto int, groups uint32, handler func(msg NetlinkMessage) error) (*Netlink,
+	error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: groups}); err != nil {
+		_ = unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	nl := &Netlink{fd: fd, handler: handler, buf: make([]byte, netlinkBufferSize)}
+	if sa, err := unix.Getsockname(fd); err == nil {
+		if sa, ok := sa.(*unix.SockaddrNetlink); ok {
+			nl.pid = sa.Pid
+		}
+	}
+	if nl.src, err = s.AddEventSource(idx, (*netlinkSource)(nl)); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	return nl, nil
+}
+
+// Send sends the message to the kernel with NetlinkFlagRequest set, a zero Seq is replaced by the next sequence
+// number of the socket. It returns the sequence number of the message, which the replies carry.
+// It is safe to call from any goroutine.
+func (nl *Netlink) Send(msg NetlinkMessage) (uint32, error) {
+	msg.Flags |= NetlinkFlagRequest
+	if msg.Seq == 0 {
+		msg.Seq = atomic.AddUint32(&nl.seq, 1)
+	}
+	msg.PID = nl.pid
+	err := unix.Sendto(nl.fd, AppendNetlinkMessage(nil, msg), 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+	if err != nil {
+		return msg.Seq, os.NewSyscallError("sendto", err)
+	}
+	return msg.Seq, nil
+}
+
+// netlinkSource is the EventSource of a Netlink.
+type netlinkSource Netlink
+
+func (nl *netlinkSource) FD() int {
+	return nl.fd
+}
+
+func (nl *netlinkSource) OnReadable() error {
+	n, _, err := unix.Recvfrom(nl.fd, nl.buf, 0)
+	switch err {
+	case nil:
+	case unix.EAGAIN, unix.EINTR:
+		return nil
+	case unix.ENOBUFS:
+		atomic.AddUint64(&nl.overruns, 1)
+		return nil
+	default:
+		return os.NewSyscallError("recvfrom", err)
+	}
+	msgs, err := ParseNetlinkMessages(nl.buf[:n])
+	for _, msg := range msgs {
+		if err := nl.handler(msg); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (nl *netlinkSource) OnWritable() error {
+	return nil
+}
+
+func (nl *netlinkSource) OnClose(err error) {
+	_ = unix.Close(nl.fd)
+}
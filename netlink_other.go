@@ -0,0 +1,19 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package gnet
+
+// openNetlink fails as netlink is only available on Linux.
+func (s *GServer) openNetlink(idx, proto int, groups uint32, handler func(msg NetlinkMessage) error) (*Netlink,
+	error) {
+	return nil, ErrProtocolNotSupported
+}
+
+// Send sends the message to the kernel with NetlinkFlagRequest set, a zero Seq is replaced by the next sequence
+// number of the socket. It returns the sequence number of the message, which the replies carry.
+func (nl *Netlink) Send(msg NetlinkMessage) (uint32, error) {
+	return 0, ErrProtocolNotSupported
+}
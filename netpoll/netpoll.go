@@ -0,0 +1,76 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package netpoll is the poller which drives the event-loops of gnet, epoll on Linux and kqueue on the BSDs,
+// for the projects which manage their own file-descriptors.
+//
+// A Poller is polled by one goroutine, which runs the Handler for every ready file-descriptor and the jobs
+// handed over by Trigger from other goroutines:
+//
+//	p, err := netpoll.Open()
+//	if err != nil {
+//		return err
+//	}
+//	defer p.Close()
+//	if err = p.AddRead(fd); err != nil {
+//		return err
+//	}
+//	err = p.Polling(netpoll.HandlerFunc(func(fd int, ev netpoll.Event) error {
+//		if ev&netpoll.EventHangup != 0 {
+//			return p.Delete(fd)
+//		}
+//		return serve(fd)
+//	}))
+//
+// Polling runs until the Handler or a job returns an error, which it returns, so a poller is stopped by
+// triggering a job which returns an error of choice. With a job error handler set by SetJobErrorHandler, the jobs
+// which fail don't stop the poller but the ones triggered by TriggerFatal.
+package netpoll
+
+import "errors"
+
+var (
+	// ErrClosed occurs when triggering a poller which has been closed.
+	ErrClosed = errors.New("poller has been closed")
+
+	// ErrUnsupported occurs when opening a poller on a platform which has neither epoll nor kqueue.
+	ErrUnsupported = errors.New("poller is not supported on this platform")
+
+	// ErrRegistered occurs when adding a file-descriptor which is already registered for other events, adding
+	// it again for the same events is a no-op.
+	ErrRegistered = errors.New("file-descriptor is already registered with the poller")
+
+	// ErrNotRegistered occurs when changing the events of a file-descriptor which is not registered.
+	ErrNotRegistered = errors.New("file-descriptor is not registered with the poller")
+)
+
+// Event is the set of the readiness events of a file-descriptor.
+type Event uint8
+
+const (
+	// EventRead reports that the file-descriptor is readable.
+	EventRead Event = 1 << iota
+
+	// EventWrite reports that the file-descriptor is writable.
+	EventWrite
+
+	// EventHangup reports that the file-descriptor is closed by the peer or has failed, it may come along
+	// with EventRead while there is data left to read.
+	EventHangup
+)
+
+// Handler handles the readiness events of the file-descriptors of a poller.
+type Handler interface {
+	// OnEvent is invoked on the polling goroutine for every ready file-descriptor, Polling returns the error
+	// it returns.
+	OnEvent(fd int, ev Event) error
+}
+
+// HandlerFunc is a function which implements Handler.
+type HandlerFunc func(fd int, ev Event) error
+
+// OnEvent invokes f.
+func (f HandlerFunc) OnEvent(fd int, ev Event) error {
+	return f(fd, ev)
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package netpoll
+
+import "github.com/panlibin/gnet/internal/netpoll"
+
+// Poller monitors file-descriptors for readiness events, see Polling.
+type Poller struct {
+	p *netpoll.Poller
+}
+
+// Open creates a poller.
+func Open() (*Poller, error) {
+	p, err := netpoll.OpenPoller()
+	if err != nil {
+		return nil, err
+	}
+	return &Poller{p}, nil
+}
+
+// Close closes the poller, it is a no-op if the poller has been closed. It doesn't close the file-descriptors
+// registered to the poller and is meant to be invoked once Polling has returned.
+func (p *Poller) Close() error {
+	return p.p.Close()
+}
+
+// AddRead registers the file-descriptor for EventRead.
+func (p *Poller) AddRead(fd int) error {
+	return fdError(p.p.AddRead(fd))
+}
+
+// AddWrite registers the file-descriptor for EventWrite.
+func (p *Poller) AddWrite(fd int) error {
+	return fdError(p.p.AddWrite(fd))
+}
+
+// AddReadWrite registers the file-descriptor for EventRead and EventWrite.
+func (p *Poller) AddReadWrite(fd int) error {
+	return fdError(p.p.AddReadWrite(fd))
+}
+
+// ModRead changes the registered file-descriptor to EventRead only.
+func (p *Poller) ModRead(fd int) error {
+	return fdError(p.p.ModRead(fd))
+}
+
+// ModWrite changes the registered file-descriptor to EventWrite only.
+func (p *Poller) ModWrite(fd int) error {
+	return fdError(p.p.ModWrite(fd))
+}
+
+// ModReadWrite changes the registered file-descriptor to EventRead and EventWrite.
+func (p *Poller) ModReadWrite(fd int) error {
+	return fdError(p.p.ModReadWrite(fd))
+}
+
+// Delete unregisters the file-descriptor, which must be done before closing it. Deleting a file-descriptor which
+// is not registered, or which has been closed in the meantime, is a no-op.
+func (p *Poller) Delete(fd int) error {
+	return fdError(p.p.Delete(fd))
+}
+
+// Trigger hands the job over to the polling goroutine and wakes it up, the jobs run in the order they are
+// triggered and Polling returns the first error any of them returns. It is safe for concurrent use and fails
+// with ErrClosed once the poller has been closed.
+func (p *Poller) Trigger(job func() error) error {
+	if err := p.p.Trigger(job); err != netpoll.ErrPollerClosed {
+		return err
+	}
+	return ErrClosed
+}
+
+// TriggerFatal is Trigger for the jobs which stop the poller, the error such a job returns ends Polling even if
+// the job errors are handled by SetJobErrorHandler.
+func (p *Poller) TriggerFatal(job func() error) error {
+	if err := p.p.TriggerFatal(job); err != netpoll.ErrPollerClosed {
+		return err
+	}
+	return ErrClosed
+}
+
+// SetJobErrorHandler hands the errors of the jobs over to the handler instead of ending Polling with them, Polling
+// goes on unless the handler returns an error, which it returns. It must be invoked before anything is triggered.
+func (p *Poller) SetJobErrorHandler(h func(err error) error) {
+	p.p.SetJobErrorHandler(h)
+}
+
+// Polling blocks the current goroutine, waiting for readiness events and running the handler for them along
+// with the jobs triggered, until either of them returns an error or waiting fails.
+func (p *Poller) Polling(h Handler) error {
+	return p.p.Polling(func(fd int, ev netpoll.IOEvent) error {
+		var e Event
+		if ev&netpoll.Readable != 0 {
+			e |= EventRead
+		}
+		if ev&netpoll.Writable != 0 {
+			e |= EventWrite
+		}
+		if ev&netpoll.ErrEvents != 0 {
+			e |= EventHangup
+		}
+		return h.OnEvent(fd, e)
+	})
+}
+
+// fdError translates the errors of registering a file-descriptor into the ones of this package.
+func fdError(err error) error {
+	switch err {
+	case netpoll.ErrFDRegistered:
+		return ErrRegistered
+	case netpoll.ErrFDNotRegistered:
+		return ErrNotRegistered
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin,!netbsd,!freebsd,!openbsd,!dragonfly
+
+package netpoll
+
+// Poller monitors file-descriptors for readiness events, see Polling.
+type Poller struct{}
+
+// Open fails with ErrUnsupported as there is neither epoll nor kqueue on this platform.
+func Open() (*Poller, error) {
+	return nil, ErrUnsupported
+}
+
+func (p *Poller) Close() error                               { return ErrUnsupported }
+func (p *Poller) AddRead(fd int) error                       { return ErrUnsupported }
+func (p *Poller) AddWrite(fd int) error                      { return ErrUnsupported }
+func (p *Poller) AddReadWrite(fd int) error                  { return ErrUnsupported }
+func (p *Poller) ModRead(fd int) error                       { return ErrUnsupported }
+func (p *Poller) ModWrite(fd int) error                      { return ErrUnsupported }
+func (p *Poller) ModReadWrite(fd int) error                  { return ErrUnsupported }
+func (p *Poller) Delete(fd int) error                        { return ErrUnsupported }
+func (p *Poller) Trigger(job func() error) error             { return ErrUnsupported }
+func (p *Poller) TriggerFatal(job func() error) error        { return ErrUnsupported }
+func (p *Poller) SetJobErrorHandler(h func(err error) error) {}
+func (p *Poller) Polling(h Handler) error                    { return ErrUnsupported }
@@ -4,7 +4,12 @@
 
 package gnet
 
-import "time"
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"time"
+)
 
 // Option is a function that will set up option.
 type Option func(opts *Options)
@@ -17,6 +22,332 @@ func loadOptions(options ...Option) *Options {
 	return opts
 }
 
+// validate rejects the options which are invalid or do not work together on the network and platform,
+// the errors wrap ErrInvalidOptions.
+func (opts *Options) validate(network string) error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", ErrInvalidOptions, fmt.Sprintf(format, args...))
+	}
+	switch {
+	case opts.ReusePort && runtime.GOOS == "windows":
+		return invalid("ReusePort is not supported on windows, the event-loops of Multicore share a single listener")
+	case opts.Transport != "" && opts.Transport != TransportPoll && opts.Transport != TransportNet:
+		return invalid("unknown Transport %q", opts.Transport)
+	case opts.Transport == TransportNet && builtinTransport != TransportNet:
+		return invalid("the net transport is selected by the gnet_net build tag")
+	case opts.Transport == TransportPoll && builtinTransport != TransportPoll:
+		return invalid("the poll transport is not available on windows or with the gnet_net build tag")
+	case opts.NumEventLoop < 0:
+		return invalid("NumEventLoop must not be negative, got %d", opts.NumEventLoop)
+	case opts.IPStack < DualStack || opts.IPStack > IPv6Only:
+		return invalid("unknown IPStack %d", opts.IPStack)
+	case opts.IPStack != DualStack && network != "tcp" && network != "udp":
+		return invalid("IPStack only applies to the tcp and udp networks, not to %s", network)
+	case opts.WriteQuantum < 0:
+		return invalid("WriteQuantum must not be negative, got %d", opts.WriteQuantum)
+	case opts.AcceptBatch < 0:
+		return invalid("AcceptBatch must not be negative, got %d", opts.AcceptBatch)
+	case opts.Handoff.QueueSize < 0:
+		return invalid("Handoff.QueueSize must not be negative, got %d", opts.Handoff.QueueSize)
+	case opts.Acceptors < 0:
+		return invalid("Acceptors must not be negative, got %d", opts.Acceptors)
+	case opts.Acceptors > 0 && (network == "udp" || network == "udp4" || network == "udp6" || network == "packet"):
+		return invalid("Acceptors only apply to the stream listeners, not to %s", network)
+	case opts.Acceptors > 1 && (!opts.ReusePort || nonIPNetwork(network)):
+		return invalid("more than one of Acceptors needs ReusePort on a tcp listener, got %d", opts.Acceptors)
+	case opts.ServeOnAccept && opts.Acceptors > 0:
+		return invalid("ServeOnAccept does not work with Acceptors, the connections are served where accepted")
+	case opts.DrainGrace < 0:
+		return invalid("DrainGrace must not be negative, got %v", opts.DrainGrace)
+	case opts.SlowConsumer.Stall < 0:
+		return invalid("SlowConsumer.Stall must not be negative, got %v", opts.SlowConsumer.Stall)
+	case opts.IdleReaper.Probe < 0 || opts.IdleReaper.Timeout < 0:
+		return invalid("IdleReaper must not be negative, got %+v", opts.IdleReaper)
+	case opts.FDExhaustion.Backoff < 0 || opts.FDExhaustion.MaxBackoff < 0:
+		return invalid("FDExhaustion must not be negative, got %+v", opts.FDExhaustion)
+	case opts.FDExhaustion.Policy < FDExhaustionPause || opts.FDExhaustion.Policy > FDExhaustionReserve:
+		return invalid("unknown FDExhaustion.Policy %d", opts.FDExhaustion.Policy)
+	case opts.SlowConsumer.Policy < SlowConsumerNotify || opts.SlowConsumer.Policy > SlowConsumerClose:
+		return invalid("unknown SlowConsumer.Policy %d", opts.SlowConsumer.Policy)
+	case opts.Rebalance.Interval < 0:
+		return invalid("Rebalance.Interval must not be negative, got %v", opts.Rebalance.Interval)
+	case opts.Rebalance.Metric < RebalanceBytes || opts.Rebalance.Metric > RebalanceCallbackTime:
+		return invalid("unknown Rebalance.Metric %d", opts.Rebalance.Metric)
+	case opts.BusyPoll.Budget < 0 || opts.BusyPoll.Socket < 0:
+		return invalid("BusyPoll must not be negative, got %+v", opts.BusyPoll)
+	case opts.BusyPoll.Socket > 0 && runtime.GOOS != "linux":
+		return invalid("BusyPoll.Socket is only supported on linux")
+	case opts.Idle.Spin < 0 || opts.Idle.Yield < 0 || opts.Idle.Sleep < 0 || opts.Idle.SleepInterval < 0 ||
+		opts.Idle.MaxWait < 0:
+		return invalid("Idle must not be negative, got %+v", opts.Idle)
+	case opts.UDPNAT.IdleTimeout < 0:
+		return invalid("UDPNAT.IdleTimeout must not be negative, got %v", opts.UDPNAT.IdleTimeout)
+	case opts.UDPNAT.IdleTimeout > 0 && network != "udp" && network != "udp4" && network != "udp6":
+		return invalid("UDPNAT only applies to the udp networks, not to %s", network)
+	case opts.ListenBacklog < 0:
+		return invalid("ListenBacklog must not be negative, got %d", opts.ListenBacklog)
+	case opts.ListenBacklog > 0 && (network == "pipe" || network == "winpipe" || network == "packet" ||
+		network == "udp" || network == "udp4" || network == "udp6"):
+		return invalid("ListenBacklog only applies to the tcp and unix networks, not to %s", network)
+	case opts.PacketRing < 0:
+		return invalid("PacketRing must not be negative, got %d", opts.PacketRing)
+	case opts.PacketRing > 0 && network != "packet":
+		return invalid("PacketRing only applies to the packet network, not to %s", network)
+	case opts.HandshakeTimeout < 0:
+		return invalid("HandshakeTimeout must not be negative, got %v", opts.HandshakeTimeout)
+	case opts.FirstByteTimeout < 0:
+		return invalid("FirstByteTimeout must not be negative, got %v", opts.FirstByteTimeout)
+	case opts.ConnMaxLifetime < 0 || opts.ConnRotationGrace < 0:
+		return invalid("ConnMaxLifetime and ConnRotationGrace must not be negative, got %v and %v",
+			opts.ConnMaxLifetime, opts.ConnRotationGrace)
+	case opts.TCPKeepAlive < 0:
+		return invalid("TCPKeepAlive must not be negative, got %v", opts.TCPKeepAlive)
+	case opts.TCPKeepAlive > 0 && opts.TCPKeepAlive < time.Second:
+		return invalid("TCPKeepAlive is set in seconds, got %v", opts.TCPKeepAlive)
+	case opts.Tap.SampleRate < 0 || opts.Tap.SampleRate > 1:
+		return invalid("Tap.SampleRate must be within [0, 1], got %v", opts.Tap.SampleRate)
+	case opts.Tap.RateLimit < 0:
+		return invalid("Tap.RateLimit must not be negative, got %d", opts.Tap.RateLimit)
+	}
+	for _, limit := range []BandwidthLimit{
+		opts.TrafficShaping.PerConn, opts.TrafficShaping.PerIP, opts.TrafficShaping.PerListener} {
+		if limit.ReadRate < 0 || limit.WriteRate < 0 {
+			return invalid("the rates of TrafficShaping must not be negative, got %+v", limit)
+		}
+	}
+	for tenant, quota := range opts.Quotas.Tenants {
+		if quota.MaxConns < 0 || quota.MaxPending < 0 || quota.Bandwidth.ReadRate < 0 || quota.Bandwidth.WriteRate < 0 {
+			return invalid("the quota of tenant %q must not be negative, got %+v", tenant, quota)
+		}
+	}
+	if q := opts.Quotas.Default; q.MaxConns < 0 || q.MaxPending < 0 || q.Bandwidth.ReadRate < 0 ||
+		q.Bandwidth.WriteRate < 0 {
+		return invalid("Quotas.Default must not be negative, got %+v", q)
+	}
+	var linuxOnly []string
+	if opts.BindToDevice != "" {
+		linuxOnly = append(linuxOnly, "BindToDevice")
+	}
+	if opts.Freebind {
+		linuxOnly = append(linuxOnly, "Freebind")
+	}
+	if opts.Transparent {
+		linuxOnly = append(linuxOnly, "Transparent")
+	}
+	if opts.Mark != 0 {
+		linuxOnly = append(linuxOnly, "Mark")
+	}
+	if opts.ECN {
+		linuxOnly = append(linuxOnly, "ECN")
+	}
+	if opts.ICMPErrors {
+		linuxOnly = append(linuxOnly, "ICMPErrors")
+	}
+	if len(linuxOnly) > 0 && runtime.GOOS != "linux" {
+		return invalid("%v are only supported on linux", linuxOnly)
+	}
+	if len(linuxOnly) > 0 && nonIPNetwork(network) {
+		return invalid("%v do not apply to %s", linuxOnly, network)
+	}
+	var windowsOnly []string
+	if opts.ExclusiveAddrUse {
+		windowsOnly = append(windowsOnly, "ExclusiveAddrUse")
+	}
+	if opts.LoopbackFastPath {
+		windowsOnly = append(windowsOnly, "LoopbackFastPath")
+	}
+	if len(windowsOnly) > 0 && runtime.GOOS != "windows" {
+		return invalid("%v are only supported on windows", windowsOnly)
+	}
+	if len(windowsOnly) > 0 && nonIPNetwork(network) {
+		return invalid("%v do not apply to %s", windowsOnly, network)
+	}
+	if pollOnly := opts.pollOnly(); len(pollOnly) > 0 && builtinTransport != TransportPoll {
+		return invalid("%v only work with the epoll/kqueue event-loops, not with the net transport", pollOnly)
+	}
+	if opts.TOS != 0 && nonIPNetwork(network) {
+		return invalid("TOS does not apply to %s", network)
+	}
+
+	if opts.ECN && network != "udp" && network != "udp4" && network != "udp6" {
+		return invalid("ECN only applies to the udp networks, not to %s", network)
+	}
+	if opts.ICMPErrors && network != "udp" && network != "udp4" && network != "udp6" {
+		return invalid("ICMPErrors only applies to the udp networks, not to %s", network)
+	}
+
+	var tcpOnly []string
+	if opts.TrafficShaping.enabled() {
+		tcpOnly = append(tcpOnly, "TrafficShaping")
+	}
+	if opts.Tap.Sink != nil {
+		tcpOnly = append(tcpOnly, "Tap")
+	}
+	if opts.FaultPolicy != nil {
+		tcpOnly = append(tcpOnly, "FaultPolicy")
+	}
+	if opts.LoopbackFastPath {
+		tcpOnly = append(tcpOnly, "LoopbackFastPath")
+	}
+	if opts.Quotas.enabled() {
+		tcpOnly = append(tcpOnly, "Quotas")
+	}
+	if opts.HandshakeTimeout > 0 {
+		tcpOnly = append(tcpOnly, "HandshakeTimeout")
+	}
+	if opts.FirstByteTimeout > 0 {
+		tcpOnly = append(tcpOnly, "FirstByteTimeout")
+	}
+	if opts.ConnMaxLifetime > 0 {
+		tcpOnly = append(tcpOnly, "ConnMaxLifetime")
+	}
+	if opts.IdleReaper.Probe > 0 {
+		tcpOnly = append(tcpOnly, "IdleReaper")
+	}
+	if len(tcpOnly) > 0 && (network == "udp" || network == "udp4" || network == "udp6" || network == "packet") {
+		return invalid("%v only apply to TCP connections, not to %s", tcpOnly, network)
+	}
+	return nil
+}
+
+// nonIPNetwork reports whether the network is served on sockets other than IP ones.
+func nonIPNetwork(network string) bool {
+	return network == "unix" || network == "pipe" || network == "winpipe" || network == "vsock" || network == "packet"
+}
+
+// pollOnly returns the options which are set and rely on the epoll/kqueue event-loops, see TransportPoll.
+func (opts *Options) pollOnly() (pollOnly []string) {
+	for _, opt := range []struct {
+		name string
+		set  bool
+	}{
+		{"SlowConsumer", opts.SlowConsumer.Stall > 0},
+		{"IdleReaper", opts.IdleReaper.Probe > 0},
+		{"FDExhaustion", opts.FDExhaustion.Policy != FDExhaustionPause || opts.FDExhaustion.Backoff > 0 ||
+			opts.FDExhaustion.MaxBackoff > 0 || opts.FDExhaustion.OnExhausted != nil},
+		{"Rebalance", opts.Rebalance.Interval > 0},
+		{"BusyPoll", opts.BusyPoll.Budget > 0 || opts.BusyPoll.Socket > 0},
+		{"Idle", opts.Idle.backsOff() || opts.Idle.MaxWait > 0},
+		{"UDPNAT", opts.UDPNAT.IdleTimeout > 0},
+		{"ListenBacklog", opts.ListenBacklog > 0},
+		{"Acceptors", opts.Acceptors > 0},
+		{"ServeOnAccept", opts.ServeOnAccept},
+		{"TOS", opts.TOS != 0},
+		{"Mark", opts.Mark != 0},
+		{"Transparent", opts.Transparent},
+		{"ECN", opts.ECN},
+		{"ICMPErrors", opts.ICMPErrors},
+		{"TrafficShaping", opts.TrafficShaping.enabled()},
+		{"Quotas", opts.Quotas.enabled()},
+		{"HandshakeTimeout", opts.HandshakeTimeout > 0},
+		{"FirstByteTimeout", opts.FirstByteTimeout > 0},
+		{"ConnMaxLifetime", opts.ConnMaxLifetime > 0},
+	} {
+		if opt.set {
+			pollOnly = append(pollOnly, opt.name)
+		}
+	}
+	return
+}
+
+// resolve fills in the defaults which the server resolves at startup.
+func (opts *Options) resolve() {
+	if opts.NumEventLoop <= 0 {
+		opts.NumEventLoop = 1
+		if opts.Multicore {
+			opts.NumEventLoop = runtime.NumCPU()
+		}
+	}
+	if opts.Codec == nil {
+		opts.Codec = new(BuiltInFrameCodec)
+	}
+	if opts.Logger == nil {
+		opts.Logger = defaultLogger
+	}
+	if opts.StreamWriter.HighWatermark <= 0 {
+		opts.StreamWriter.HighWatermark = defaultStreamHighWatermark
+	}
+	if opts.StreamWriter.ChunkSize <= 0 {
+		opts.StreamWriter.ChunkSize = defaultStreamChunkSize
+	}
+	if opts.AcceptBatch <= 0 {
+		opts.AcceptBatch = 1
+	}
+	if opts.Handoff.QueueSize <= 0 {
+		opts.Handoff.QueueSize = defaultHandoffQueueSize
+	}
+	if opts.Idle.SleepInterval <= 0 {
+		opts.Idle.SleepInterval = defaultIdleSleepInterval
+	}
+	if opts.Rebalance.Threshold <= 0 {
+		opts.Rebalance.Threshold = defaultRebalanceThreshold
+	}
+	if opts.Rebalance.MaxMoves <= 0 {
+		opts.Rebalance.MaxMoves = defaultRebalanceMaxMoves
+	}
+	opts.Transport = builtinTransport
+}
+
+// validateHandler checks the options against the event handler.
+func (opts *Options) validateHandler(eventHandler EventHandler) error {
+	if opts.Ticker && !declaresTick(reflect.TypeOf(eventHandler)) {
+		return fmt.Errorf("%w: Ticker is set but %T does not implement Tick, the one of EventServer does nothing",
+			ErrInvalidOptions, eventHandler)
+	}
+	return nil
+}
+
+var eventServerType = reflect.TypeOf(EventServer{})
+
+// declaresTick reports whether the type has a Tick method of its own or promoted from an embedded type other than
+// EventServer. Promoted methods only differ from the declared ones by being generated by the compiler, so the
+// embedded fields are searched for the declaration of a generated method. It errs on the side of true.
+func declaresTick(t reflect.Type) bool {
+	base := t
+	for base.Kind() == reflect.Ptr {
+		base = base.Elem()
+	}
+	if base == eventServerType {
+		return false
+	}
+	for ; t.Kind() == reflect.Ptr; t = t.Elem() {
+		if m, ok := t.MethodByName("Tick"); ok && !generatedMethod(m) {
+			return true
+		}
+	}
+	if m, ok := t.MethodByName("Tick"); ok && !generatedMethod(m) || t.Kind() != reflect.Struct {
+		return true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.Anonymous && hasTick(f.Type) && declaresTick(f.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTick(t reflect.Type) bool {
+	if _, ok := t.MethodByName("Tick"); ok {
+		return true
+	}
+	_, ok := reflect.PtrTo(t).MethodByName("Tick")
+	return ok && t.Kind() != reflect.Ptr && t.Kind() != reflect.Interface
+}
+
+// generatedMethod reports whether the method is a wrapper generated by the compiler, e.g. for a promoted method.
+func generatedMethod(m reflect.Method) bool {
+	if !m.Func.IsValid() {
+		return false
+	}
+	pc := m.Func.Pointer()
+	fn := runtime.FuncForPC(pc)
+	if fn == nil {
+		return false
+	}
+	file, _ := fn.FileLine(pc)
+	return file == "<autogenerated>"
+}
+
 // Options are set when the client opens.
 type Options struct {
 	// Multicore indicates whether the server will be effectively created with multi-cores, if so,
@@ -29,20 +360,192 @@ type Options struct {
 	// Note: Setting up NumEventLoop will override Multicore.
 	NumEventLoop int
 
-	// ReusePort indicates whether to set up the SO_REUSEPORT socket option.
+	// Transport asserts the transport the event-loops are built upon, TransportPoll or TransportNet, the server
+	// fails to start if it is not the one selected at build time. Empty accepts either of them, it is filled in
+	// with the selected one once the server has started.
+	Transport string
+
+	// ReusePort indicates whether to set up the SO_REUSEPORT socket option, it is not supported on windows.
 	ReusePort bool
 
-	// Ticker indicates whether the ticker has been set up.
+	// Ticker indicates whether the ticker has been set up, the event handler must implement Tick then.
 	Ticker bool
 
 	// TCPKeepAlive (SO_KEEPALIVE) socket option.
 	TCPKeepAlive time.Duration
 
+	// HandshakeTimeout closes the connections which have not completed their handshake within it with
+	// ErrHandshakeTimeout, e.g. the ones which connect and send nothing to exhaust the sockets, see HandshakeCodec.
+	// Zero disables it.
+	HandshakeTimeout time.Duration
+
+	// FirstByteTimeout closes the connections which have sent nothing within it after they have been accepted with
+	// ErrFirstByteTimeout, e.g. 3s against slowloris while ConnSettings.IdleTimeout or IdleReaper allow minutes
+	// of silence later on. Zero disables it.
+	FirstByteTimeout time.Duration
+
+	// ConnMaxLifetime rotates the connections older than it, e.g. so that the clients rebalance across the servers:
+	// OnDraining fires once a connection reaches it so that the event handler can send the peer a reconnect hint,
+	// and the connection is closed with ErrConnMaxLifetime after ConnRotationGrace. Every connection is rotated at
+	// a random age within the last 10% of it so that the connections opened together are not rotated at once.
+	// Zero disables the rotation.
+	ConnMaxLifetime time.Duration
+
+	// ConnRotationGrace is how long a connection rotated by ConnMaxLifetime is left to the peer to close it,
+	// zero closes it as soon as OnDraining has returned.
+	ConnRotationGrace time.Duration
+
 	// ICodec encodes and decodes TCP stream.
 	Codec ICodec
 
 	// Logger is the customized logger for logging info, if it is not set, default standard logger from log package is used.
 	Logger Logger
+
+	// TrafficShaping throttles the bandwidth of connections, it only takes effect with the epoll/kqueue event-loops.
+	TrafficShaping TrafficShaping
+
+	// WriteQuantum is the maximum number of bytes flushed to a connection per writable event, which shares
+	// each flush cycle of an event-loop fairly among its connections, zero means unlimited.
+	WriteQuantum int
+
+	// AcceptBatch is the maximum number of connections accepted per wakeup of the loop listening, defaults to 1.
+	// A larger batch drains a burst of new connections faster, at the cost of the I/O of the established
+	// connections when the listener shares event-loops with them under ReusePort. It has no effect on Windows.
+	AcceptBatch int
+
+	// Acceptors is the number of dedicated acceptor loops, which accept the connections and hand them over to the
+	// NumEventLoop event-loops, so that a burst of new connections never contends with the I/O of the established
+	// ones on the same poller. Every acceptor listens on a socket of its own bound to the address with ReusePort,
+	// which more than one acceptor needs, and the kernel spreads the new connections over them on Linux.
+	// Zero leaves accepting to a single main reactor, or to the event-loops themselves under ReusePort.
+	// It only works with the epoll/kqueue event-loops.
+	Acceptors int
+
+	// Handoff sets up how the new connections are handed over to the event-loops with the net transport.
+	Handoff Handoff
+
+	// ServeOnAccept makes every event-loop poll the listener and serve the connections it accepts itself, which
+	// saves the handoff from the main reactor and the wake-up of the event-loop taking the connection over.
+	// It is meant for the latency-critical servers with few connections: with a single event-loop it makes a
+	// single reactor, with more of them they are all woken up by a new connection and the connections are not
+	// spread by round-robin but by whichever event-loop accepts first. ReusePort implies it unless Acceptors
+	// are set. It only works with the epoll/kqueue event-loops.
+	ServeOnAccept bool
+
+	// ListenBacklog is the maximum length of the accept queue of a TCP or unix listener, which is capped by
+	// net.core.somaxconn on Linux and kern.ipc.somaxconn on the BSDs, zero leaves it to the default of Go,
+	// i.e. the cap itself. The connections beyond it are dropped by the kernel, see Stats.ListenOverflows.
+	ListenBacklog int
+
+	// DrainGrace makes the server drain with the grace period once the process receives SIGTERM,
+	// see GServer.Drain, zero leaves SIGTERM alone.
+	DrainGrace time.Duration
+
+	// LoopMetrics makes the event-loops record the histograms of their queue depths and latencies,
+	// see GServer.LoopStats.
+	LoopMetrics bool
+
+	// SlowConsumer sets up the detection of the peers which do not read their outbound data, it is not
+	// supported on Windows.
+	SlowConsumer SlowConsumer
+
+	// IdleReaper sets up probing and then closing the connections which read nothing, it is not supported on
+	// Windows.
+	IdleReaper IdleReaper
+
+	// FDExhaustion sets up how the server copes with running out of file descriptors on accept, it is not
+	// supported on Windows.
+	FDExhaustion FDExhaustion
+
+	// Rebalance sets up moving connections off the overloaded event-loops periodically, it is not supported
+	// on Windows.
+	Rebalance Rebalance
+
+	// BusyPoll sets up spinning event-loops and SO_BUSY_POLL for ultra-low latency, it is not supported on Windows.
+	BusyPoll BusyPoll
+
+	// Idle sets up how the event-loops wait for network-events while they are idle, it is not supported on Windows.
+	Idle IdleStrategy
+
+	// UDPNAT sets up the NAT-style session table of a UDP proxy, see ForwardUDP, it is not supported on Windows.
+	UDPNAT UDPNAT
+
+	// Expvar publishes the core counters of the server via expvar under the prefix, e.g. "gnet" publishes
+	// "gnet.connections", "gnet.shed_accepts" and so on, empty leaves expvar alone. A prefix is taken by one
+	// running server at a time, the counters of a stopped server are null until another one takes the prefix.
+	Expvar string
+
+	// ListenerSocketOptions sets up the socket of the listener right after it is created and before it is bound,
+	// as an escape hatch for the options not covered by gnet, e.g. SO_MARK or MPTCP. The server fails to start
+	// with the error it returns.
+	ListenerSocketOptions func(fd int) error
+
+	// ConnSocketOptions sets up the socket of every accepted connection before OnAccept fires, e.g. TCP_ULP,
+	// the connection is closed if it returns an error. It is not invoked for the pipes on Windows, which have
+	// no socket.
+	ConnSocketOptions func(fd int) error
+
+	// PeerTagger attaches the metadata of remote peers to TCP connections before OnOpened fires.
+	PeerTagger PeerTagger
+
+	// Tap mirrors the bytes of TCP connections to a sink for debugging.
+	Tap Tap
+
+	// FaultPolicy injects faults into the reads and writes of TCP connections for resilience testing.
+	FaultPolicy FaultPolicy
+
+	// Firewall rejects peers by their IP addresses before connections are set up.
+	Firewall *Firewall
+
+	// LoadShedding rejects new connections and drops established ones when the server is overloaded.
+	LoadShedding LoadShedding
+
+	// StreamWriter sets up the backpressure of the writers returned by Conn.Writer.
+	StreamWriter StreamWriter
+
+	// BindToDevice binds the listener to a network interface or a VRF device by SO_BINDTODEVICE, Linux only.
+	BindToDevice string
+
+	// Freebind sets up IP_FREEBIND on the listener so that it can be bound to an address which is not
+	// configured yet, Linux only.
+	Freebind bool
+
+	// Transparent sets up IP_TRANSPARENT on the listener so that it serves the connections and datagrams
+	// intercepted by TPROXY, Linux only.
+	Transparent bool
+
+	// Mark sets up SO_MARK on the listener and the accepted connections for policy routing and nftables rules,
+	// zero leaves it alone, Linux only.
+	Mark uint32
+
+	// ExclusiveAddrUse sets up SO_EXCLUSIVEADDRUSE on the listener so that no other socket can be bound to its
+	// address, even by SO_REUSEADDR, Windows only.
+	ExclusiveAddrUse bool
+
+	// LoopbackFastPath enables SIO_LOOPBACK_FAST_PATH on a TCP listener, the loopback connections whose clients
+	// enable it too bypass most of the TCP/IP stack, see NewLoopbackFastPathDialer. Windows only.
+	LoopbackFastPath bool
+
+	// PacketRing sets up a PACKET_RX_RING of at least the given number of frames on a `packet://` listener, which
+	// is shared with the kernel to save a system call and a copy per frame; the frames longer than about 1.9KB
+	// are truncated and only the first event-loop serves them. Zero reads the frames one by one.
+	PacketRing int
+
+	// IPStack decides which IP versions are served by a listener of the "tcp" or "udp" network.
+	IPStack IPStack
+
+	// TOS is the default IP_TOS (IPV6_TCLASS) byte of the connections, zero leaves it to the system.
+	TOS byte
+
+	// ECN makes a UDP server receive the ECN codepoints of the datagrams, see DatagramECN, Linux only.
+	ECN bool
+
+	// ICMPErrors makes a UDP server deliver the ICMP errors of the datagrams it has sent to
+	// EventHandler.OnError, Linux only.
+	ICMPErrors bool
+
+	// Quotas enforces per-tenant limits on the connections, see Quotas.
+	Quotas Quotas
 }
 
 // WithOptions sets up all options.
@@ -59,6 +562,14 @@ func WithMulticore(multicore bool) Option {
 	}
 }
 
+// WithTransport asserts the transport of the event-loops, e.g. WithTransport(TransportNet) makes sure that a
+// binary built with the gnet_net tag is running.
+func WithTransport(transport string) Option {
+	return func(opts *Options) {
+		opts.Transport = transport
+	}
+}
+
 // WithNumEventLoop sets up NumEventLoop in gnet server.
 func WithNumEventLoop(numEventLoop int) Option {
 	return func(opts *Options) {
@@ -80,6 +591,35 @@ func WithTCPKeepAlive(tcpKeepAlive time.Duration) Option {
 	}
 }
 
+// WithFirstByteTimeout closes the connections which have sent nothing within the timeout after they have been
+// accepted.
+func WithFirstByteTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.FirstByteTimeout = timeout
+	}
+}
+
+// WithHandshakeTimeout closes the connections which have not completed their handshake within the timeout.
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.HandshakeTimeout = timeout
+	}
+}
+
+// WithConnMaxLifetime rotates the connections which are older than the given lifetime.
+func WithConnMaxLifetime(lifetime time.Duration) Option {
+	return func(opts *Options) {
+		opts.ConnMaxLifetime = lifetime
+	}
+}
+
+// WithConnRotationGrace sets up how long a connection rotated by ConnMaxLifetime is left to the peer to close it.
+func WithConnRotationGrace(grace time.Duration) Option {
+	return func(opts *Options) {
+		opts.ConnRotationGrace = grace
+	}
+}
+
 // WithTicker indicates that a ticker is set.
 func WithTicker(ticker bool) Option {
 	return func(opts *Options) {
@@ -100,3 +640,275 @@ func WithLogger(logger Logger) Option {
 		opts.Logger = logger
 	}
 }
+
+// WithTrafficShaping sets up the bandwidth limits per connection, per IP and per listener.
+func WithTrafficShaping(ts TrafficShaping) Option {
+	return func(opts *Options) {
+		opts.TrafficShaping = ts
+	}
+}
+
+// WithLoadShedding sets up the thresholds and behaviours of load shedding.
+func WithLoadShedding(ls LoadShedding) Option {
+	return func(opts *Options) {
+		opts.LoadShedding = ls
+	}
+}
+
+// WithWriteQuantum sets up the maximum number of bytes flushed to a connection per writable event.
+func WithWriteQuantum(quantum int) Option {
+	return func(opts *Options) {
+		opts.WriteQuantum = quantum
+	}
+}
+
+// WithAcceptBatch sets up the maximum number of connections accepted per wakeup of the loop listening.
+func WithAcceptBatch(batch int) Option {
+	return func(opts *Options) {
+		opts.AcceptBatch = batch
+	}
+}
+
+// WithAcceptors sets up the number of dedicated acceptor loops.
+func WithAcceptors(acceptors int) Option {
+	return func(opts *Options) {
+		opts.Acceptors = acceptors
+	}
+}
+
+// WithHandoff sets up how the new connections are handed over to the event-loops with the net transport.
+func WithHandoff(handoff Handoff) Option {
+	return func(opts *Options) {
+		opts.Handoff = handoff
+	}
+}
+
+// WithServeOnAccept sets up the event-loops to serve the connections they accept.
+func WithServeOnAccept(serveOnAccept bool) Option {
+	return func(opts *Options) {
+		opts.ServeOnAccept = serveOnAccept
+	}
+}
+
+// WithListenBacklog sets up the maximum length of the accept queue of the listener.
+func WithListenBacklog(backlog int) Option {
+	return func(opts *Options) {
+		opts.ListenBacklog = backlog
+	}
+}
+
+// WithDrainGrace sets up draining the server with the grace period on SIGTERM.
+func WithDrainGrace(grace time.Duration) Option {
+	return func(opts *Options) {
+		opts.DrainGrace = grace
+	}
+}
+
+// WithLoopMetrics sets up recording the histograms of the event-loops.
+func WithLoopMetrics(loopMetrics bool) Option {
+	return func(opts *Options) {
+		opts.LoopMetrics = loopMetrics
+	}
+}
+
+// WithExpvar sets up publishing the core counters of the server via expvar under the prefix.
+func WithExpvar(prefix string) Option {
+	return func(opts *Options) {
+		opts.Expvar = prefix
+	}
+}
+
+// WithIdleReaper sets up probing and then closing the idle connections.
+func WithIdleReaper(ir IdleReaper) Option {
+	return func(opts *Options) {
+		opts.IdleReaper = ir
+	}
+}
+
+// WithFDExhaustion sets up how the server copes with running out of file descriptors on accept.
+func WithFDExhaustion(fe FDExhaustion) Option {
+	return func(opts *Options) {
+		opts.FDExhaustion = fe
+	}
+}
+
+// WithSlowConsumer sets up the detection of slow consumers and what happens to them.
+func WithSlowConsumer(sc SlowConsumer) Option {
+	return func(opts *Options) {
+		opts.SlowConsumer = sc
+	}
+}
+
+// WithRebalance sets up the rebalancer of the event-loops.
+func WithRebalance(rb Rebalance) Option {
+	return func(opts *Options) {
+		opts.Rebalance = rb
+	}
+}
+
+// WithBusyPoll sets up busy polling of the event-loops and the sockets.
+func WithBusyPoll(bp BusyPoll) Option {
+	return func(opts *Options) {
+		opts.BusyPoll = bp
+	}
+}
+
+// WithIdleStrategy sets up how the event-loops wait for network-events while they are idle.
+func WithIdleStrategy(is IdleStrategy) Option {
+	return func(opts *Options) {
+		opts.Idle = is
+	}
+}
+
+// WithUDPNAT sets up the NAT-style session table of a UDP proxy.
+func WithUDPNAT(nat UDPNAT) Option {
+	return func(opts *Options) {
+		opts.UDPNAT = nat
+	}
+}
+
+// WithFirewall sets up a firewall filtering peers by their IP addresses.
+func WithFirewall(fw *Firewall) Option {
+	return func(opts *Options) {
+		opts.Firewall = fw
+	}
+}
+
+// WithListenerSocketOptions sets up a hook setting up the socket of the listener.
+func WithListenerSocketOptions(setsockopt func(fd int) error) Option {
+	return func(opts *Options) {
+		opts.ListenerSocketOptions = setsockopt
+	}
+}
+
+// WithConnSocketOptions sets up a hook setting up the sockets of the accepted connections.
+func WithConnSocketOptions(setsockopt func(fd int) error) Option {
+	return func(opts *Options) {
+		opts.ConnSocketOptions = setsockopt
+	}
+}
+
+// WithPeerTagger sets up a tagger attaching the metadata of remote peers to connections.
+func WithPeerTagger(tagger PeerTagger) Option {
+	return func(opts *Options) {
+		opts.PeerTagger = tagger
+	}
+}
+
+// WithTap sets up a tap mirroring the bytes of TCP connections to a sink.
+func WithTap(tap Tap) Option {
+	return func(opts *Options) {
+		opts.Tap = tap
+	}
+}
+
+// WithFaultInjection sets up a policy injecting faults into the reads and writes of TCP connections,
+// it is meant for testing only.
+func WithFaultInjection(policy FaultPolicy) Option {
+	return func(opts *Options) {
+		opts.FaultPolicy = policy
+	}
+}
+
+// WithStreamWriter sets up the high watermark and chunk size of the writers returned by Conn.Writer.
+func WithStreamWriter(sw StreamWriter) Option {
+	return func(opts *Options) {
+		opts.StreamWriter = sw
+	}
+}
+
+// WithBindToDevice binds the listener to a network interface, e.g. "eth1", or a VRF device on Linux,
+// so that connections are accepted only from that interface.
+func WithBindToDevice(device string) Option {
+	return func(opts *Options) {
+		opts.BindToDevice = device
+	}
+}
+
+// WithFreebind sets up IP_FREEBIND on the listener so that it can be bound to an address which is not
+// configured yet, e.g. a floating IP.
+func WithFreebind(freebind bool) Option {
+	return func(opts *Options) {
+		opts.Freebind = freebind
+	}
+}
+
+// WithTransparent sets up IP_TRANSPARENT on the listener so that gnet can serve as the data plane of a
+// TPROXY-based interception proxy, the local address of a connection or a datagram is then its original
+// destination, see OriginalDst. It requires CAP_NET_ADMIN.
+func WithTransparent(transparent bool) Option {
+	return func(opts *Options) {
+		opts.Transparent = transparent
+	}
+}
+
+// WithMark marks the listener and the accepted connections by SO_MARK, so that their packets take part in
+// policy routing and nftables rules, see also NewMarkDialer. It requires CAP_NET_ADMIN.
+func WithMark(mark uint32) Option {
+	return func(opts *Options) {
+		opts.Mark = mark
+	}
+}
+
+// WithExclusiveAddrUse sets up SO_EXCLUSIVEADDRUSE on the listener so that no other process can steal its
+// address on Windows.
+func WithExclusiveAddrUse(exclusive bool) Option {
+	return func(opts *Options) {
+		opts.ExclusiveAddrUse = exclusive
+	}
+}
+
+// WithLoopbackFastPath enables SIO_LOOPBACK_FAST_PATH on a TCP listener on Windows, which speeds up the loopback
+// connections of the clients dialing by NewLoopbackFastPathDialer.
+func WithLoopbackFastPath(fastPath bool) Option {
+	return func(opts *Options) {
+		opts.LoopbackFastPath = fastPath
+	}
+}
+
+// WithPacketRing sets up a PACKET_RX_RING of the given number of frames on a `packet://` listener.
+func WithPacketRing(frames int) Option {
+	return func(opts *Options) {
+		opts.PacketRing = frames
+	}
+}
+
+// WithECN makes a UDP server receive the ECN codepoints of the datagrams by IP_RECVTOS (IPV6_RECVTCLASS)
+// for the congestion-aware protocols such as QUIC, see DatagramECN and SendToECN.
+func WithECN(ecn bool) Option {
+	return func(opts *Options) {
+		opts.ECN = ecn
+	}
+}
+
+// WithICMPErrors makes a UDP server read the ICMP errors of the datagrams it has sent, e.g. port unreachable,
+// by IP_RECVERR (IPV6_RECVERR) and deliver them to EventHandler.OnError rather than dropping them.
+func WithICMPErrors(icmp bool) Option {
+	return func(opts *Options) {
+		opts.ICMPErrors = icmp
+	}
+}
+
+// WithQuotas enforces the resource quotas of the tenants sharing the server, the tenants of the connections
+// are extracted by Quotas.Tenant or set by SetTenant.
+func WithQuotas(quotas Quotas) Option {
+	return func(opts *Options) {
+		opts.Quotas = quotas
+	}
+}
+
+// WithIPStack sets up which IP versions are served by a listener of the "tcp" or "udp" network,
+// dual-stack, IPv4 only or IPv6 only.
+func WithIPStack(stack IPStack) Option {
+	return func(opts *Options) {
+		opts.IPStack = stack
+	}
+}
+
+// WithTOS sets up the default IP_TOS (IPV6_TCLASS) byte of the connections and UDP datagrams, e.g. 0xb8 marks
+// them as Expedited Forwarding, see Conn.SetTOS.
+func WithTOS(tos byte) Option {
+	return func(opts *Options) {
+		opts.TOS = tos
+	}
+}
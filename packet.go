@@ -0,0 +1,67 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// EtherTypes of `packet://` listeners.
+const (
+	// EtherTypeAll receives the frames of all the protocols, it is the EtherType of `packet://ifname`.
+	EtherTypeAll uint16 = 0x0003
+
+	// EtherTypeARP is the EtherType of ARP.
+	EtherTypeARP uint16 = 0x0806
+
+	// EtherTypeLLDP is the EtherType of LLDP.
+	EtherTypeLLDP uint16 = 0x88cc
+)
+
+// PacketAddr is the address of an AF_PACKET socket, i.e. a network interface and an EtherType, along with the
+// hardware address of the sender of a frame. The remote addresses of the frames served on `packet://` are
+// PacketAddrs.
+type PacketAddr struct {
+	Ifindex      int // zero for all the interfaces
+	Protocol     uint16
+	HardwareAddr net.HardwareAddr
+}
+
+// Network returns "packet".
+func (a *PacketAddr) Network() string { return "packet" }
+
+// String returns the address in the form of "[hwaddr@]ifindex/0xethertype".
+func (a *PacketAddr) String() string {
+	s := strconv.Itoa(a.Ifindex) + "/0x" + strconv.FormatUint(uint64(a.Protocol)|0x10000, 16)[1:]
+	if len(a.HardwareAddr) > 0 {
+		s = a.HardwareAddr.String() + "@" + s
+	}
+	return s
+}
+
+// parsePacketAddr parses an address in the form of "ifname[/ethertype]", where "any" is all the interfaces and
+// the EtherType defaults to EtherTypeAll.
+func parsePacketAddr(addr string) (*PacketAddr, error) {
+	a := &PacketAddr{Protocol: EtherTypeAll}
+	name := addr
+	if i := strings.IndexByte(addr, '/'); i >= 0 {
+		name = addr[:i]
+		proto, err := strconv.ParseUint(addr[i+1:], 0, 16)
+		if err != nil {
+			return nil, &net.AddrError{Err: "invalid EtherType in packet address", Addr: addr}
+		}
+		a.Protocol = uint16(proto)
+	}
+	if name != "any" {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, &net.AddrError{Err: "unknown interface in packet address", Addr: addr}
+		}
+		a.Ifindex = ifi.Index
+	}
+	return a, nil
+}
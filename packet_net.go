@@ -0,0 +1,12 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows gnet_net
+
+package gnet
+
+// listenPacket fails as the net transport has no support for AF_PACKET.
+func (ln *listener) listenPacket(opts *Options) error {
+	return ErrProtocolNotSupported
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+// +build !gnet_net
+
+package gnet
+
+import (
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/panlibin/gnet/internal/netpoll"
+	"golang.org/x/sys/unix"
+)
+
+// listenPacket sets up an AF_PACKET socket by hand as the net package has no support for it, the frames are
+// served like the datagrams of a UDP listener.
+func (ln *listener) listenPacket(opts *Options) error {
+	if runtime.GOOS != "linux" {
+		return ErrProtocolNotSupported
+	}
+	addr, err := parsePacketAddr(ln.addr)
+	if err != nil {
+		return err
+	}
+	fd, err := netpoll.OpenPacket(addr.Ifindex, addr.Protocol, opts.ListenerSocketOptions)
+	if err != nil {
+		return &net.OpError{Op: "listen", Net: "packet", Addr: addr, Err: err}
+	}
+	pc := &packetConn{fd: fd, addr: addr}
+	if opts.PacketRing > 0 {
+		if pc.ring, err = netpoll.NewPacketRing(fd, opts.PacketRing); err != nil {
+			_ = unix.Close(fd)
+			return &net.OpError{Op: "listen", Net: "packet", Addr: addr, Err: err}
+		}
+	}
+	ln.fd, ln.pconn, ln.lnaddr = fd, pc, addr
+	return nil
+}
+
+// datagramSockaddrToAddr converts the Sockaddr of the sender of a datagram or a frame to a net.Addr.
+func datagramSockaddrToAddr(sa unix.Sockaddr) net.Addr {
+	if ifindex, proto, hw, ok := netpoll.SockaddrLinklayer(sa); ok {
+		return &PacketAddr{Ifindex: ifindex, Protocol: proto, HardwareAddr: hw}
+	}
+	if addr := netpoll.SockaddrToUDPAddr(sa); addr != nil {
+		return addr
+	}
+	return nil
+}
+
+var errPacketDeadline = errors.New("deadlines are not supported by packet listeners")
+
+// packetConn is the net.PacketConn of a packet listener, which is only there to close the socket and its ring,
+// the frames are read and written by the event-loops.
+type packetConn struct {
+	fd   int
+	addr *PacketAddr
+	ring *netpoll.PacketRing // nil unless Options.PacketRing is set
+}
+
+func (pc *packetConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, sa, err := unix.Recvfrom(pc.fd, p, 0)
+	if err != nil {
+		return 0, nil, os.NewSyscallError("recvfrom", err)
+	}
+	return n, datagramSockaddrToAddr(sa), nil
+}
+
+func (pc *packetConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	a, ok := addr.(*PacketAddr)
+	if !ok {
+		return 0, &net.AddrError{Err: "not a packet address", Addr: addr.String()}
+	}
+	if err := netpoll.SendPacket(pc.fd, p, a.Ifindex, a.HardwareAddr); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (pc *packetConn) Close() error {
+	if pc.ring != nil {
+		sniffError(pc.ring.Close())
+	}
+	return unix.Close(pc.fd)
+}
+
+func (pc *packetConn) LocalAddr() net.Addr                { return pc.addr }
+func (pc *packetConn) SetDeadline(t time.Time) error      { return errPacketDeadline }
+func (pc *packetConn) SetReadDeadline(t time.Time) error  { return errPacketDeadline }
+func (pc *packetConn) SetWriteDeadline(t time.Time) error { return errPacketDeadline }
+
+// packetRing returns the ring of the packet listener of the server, nil if there is none.
+func (svr *server) packetRing() *netpoll.PacketRing {
+	if pc, ok := svr.ln.pconn.(*packetConn); ok {
+		return pc.ring
+	}
+	return nil
+}
+
+// loopReadRing serves the frames filled in the ring of the packet listener, which is only polled by the first
+// event-loop as the ring is not safe for concurrent use.
+func (el *eventloop) loopReadRing(ring *netpoll.PacketRing) error {
+	for {
+		frame, sa, ok := ring.Next()
+		if !ok {
+			return nil
+		}
+		c := newUDPConn(el.svr.ln.fd, el, sa, el.svr.ln.lnaddr)
+		out, action := el.eventHandler.React(frame, c)
+		if out != nil {
+			el.eventHandler.PreWrite()
+			_ = c.sendTo(out)
+		}
+		c.releaseUDP()
+		ring.Release()
+		if action == Shutdown {
+			return ErrServerShutdown
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "net"
+
+// Peer is the metadata of a remote peer which is attached to a connection before OnOpened fires,
+// so that routing and limits can take the geography and network of the peer into account.
+type Peer struct {
+	// Country is the ISO 3166-1 alpha-2 code of the country where the peer is located.
+	Country string
+
+	// ASN is the number of the autonomous system which the peer belongs to.
+	ASN uint
+
+	// ASOrg is the organization name of the autonomous system.
+	ASOrg string
+
+	// Labels holds arbitrary user-defined metadata.
+	Labels map[string]string
+}
+
+// PeerTagger looks up the metadata of the remote address of a newly accepted TCP connection,
+// it is invoked on the event-loop goroutine so it should be fast, returning nil leaves the connection untagged.
+type PeerTagger func(remoteAddr net.Addr) *Peer
+
+// MaxMindReader is the lookup method of a MaxMind database reader, it is satisfied by *maxminddb.Reader of
+// github.com/oschwald/maxminddb-golang, so gnet doesn't have to depend on it.
+type MaxMindReader interface {
+	Lookup(ip net.IP, result interface{}) error
+}
+
+type maxMindRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+// NewMaxMindTagger returns a PeerTagger which looks up peers in the MaxMind GeoIP2/GeoLite2 country and ASN
+// databases, either of the readers can be nil.
+func NewMaxMindTagger(country, asn MaxMindReader) PeerTagger {
+	return func(remoteAddr net.Addr) *Peer {
+		ip := addrIP(remoteAddr)
+		if ip == nil {
+			return nil
+		}
+		var rec maxMindRecord
+		if country != nil {
+			_ = country.Lookup(ip, &rec)
+		}
+		if asn != nil {
+			_ = asn.Lookup(ip, &rec)
+		}
+		if rec.Country.ISOCode == "" && rec.AutonomousSystemNumber == 0 {
+			return nil
+		}
+		return &Peer{
+			Country: rec.Country.ISOCode,
+			ASN:     rec.AutonomousSystemNumber,
+			ASOrg:   rec.AutonomousSystemOrganization,
+		}
+	}
+}
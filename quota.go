@@ -0,0 +1,274 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+)
+
+// TenantFunc extracts the tenant of a TCP connection, it is invoked on the event-loop with a nil frame right
+// before OnOpened, then with every inbound frame before React until it gives a tenant, so that the tenant can
+// come from the remote IP (see TenantByIP), the first frame, or the TLS SNI of the first frame
+// (see ClientHelloServerName).
+type TenantFunc func(c Conn, frame []byte) (tenant string, ok bool)
+
+// TenantByIP is a TenantFunc which makes the remote IP of a connection its tenant.
+func TenantByIP(c Conn, _ []byte) (string, bool) {
+	if ip := addrIP(c.RemoteAddr()); ip != nil {
+		return ip.String(), true
+	}
+	return "", false
+}
+
+// TenantQuota limits the resources of a tenant across all of its connections, zero means unlimited.
+type TenantQuota struct {
+	// MaxConns limits the number of connections, the connections over it are closed with ErrQuotaExceeded.
+	MaxConns int
+
+	// Bandwidth limits the throughput of all the connections.
+	Bandwidth BandwidthLimit
+
+	// MaxPending limits the outbound bytes pending on all the connections, a connection which buffers outbound
+	// data while its tenant is over it stops being read until its own outbound data has drained.
+	MaxPending int
+}
+
+// Quotas enforces the resource quotas of the tenants sharing a server.
+//
+// A connection over the MaxConns of its tenant is closed before OnOpened fires if its tenant is known by then,
+// or right after the frame which gives its tenant otherwise. The tenants are tracked while they have
+// connections, see GServer.TenantStats.
+type Quotas struct {
+	// Tenant extracts the tenants of the connections, SetTenant sets them by hand.
+	Tenant TenantFunc
+
+	// Default is the quota of the tenants which are not in Tenants.
+	Default TenantQuota
+
+	// Tenants overrides the quotas of particular tenants.
+	Tenants map[string]TenantQuota
+}
+
+func (q Quotas) enabled() bool {
+	return q.Tenant != nil || q.Default != TenantQuota{} || len(q.Tenants) > 0
+}
+
+// shapes reports whether any of the quotas limits the bandwidth.
+func (q Quotas) shapes() bool {
+	if q.Default.Bandwidth != (BandwidthLimit{}) {
+		return true
+	}
+	for _, quota := range q.Tenants {
+		if quota.Bandwidth != (BandwidthLimit{}) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q Quotas) quota(tenant string) TenantQuota {
+	if quota, ok := q.Tenants[tenant]; ok {
+		return quota
+	}
+	return q.Default
+}
+
+// TenantStats is a snapshot of the counters of a tenant.
+type TenantStats struct {
+	// Conns is the current number of connections.
+	Conns int
+
+	// Pending is the number of outbound bytes pending on the connections.
+	Pending int64
+
+	// Rejected is the number of connections closed for being over MaxConns.
+	Rejected int64
+
+	// Throttled is the number of times a connection has stopped being read for being over MaxPending.
+	Throttled int64
+}
+
+// tenant holds the state of a tenant shared by its connections across all event-loops.
+type tenant struct {
+	name      string
+	quota     TenantQuota
+	conns     int // guarded by tenantTable.mu
+	buckets   buckets
+	pending   int64 // accessed atomically
+	rejected  int64 // accessed atomically
+	throttled int64 // accessed atomically
+}
+
+// overPending reports whether the outbound bytes pending on the connections of the tenant are over its quota.
+func (t *tenant) overPending() bool {
+	return t.quota.MaxPending > 0 && atomic.LoadInt64(&t.pending) > int64(t.quota.MaxPending)
+}
+
+// tenantTable keeps the tenants having connections.
+type tenantTable struct {
+	mu      sync.Mutex
+	opts    Quotas
+	tenants map[string]*tenant
+}
+
+func newTenantTable(opts Quotas) *tenantTable {
+	return &tenantTable{opts: opts, tenants: make(map[string]*tenant)}
+}
+
+// join counts a connection in for the tenant, it fails with ErrQuotaExceeded if the tenant is at MaxConns.
+func (tt *tenantTable) join(name string) (*tenant, error) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	t, ok := tt.tenants[name]
+	if !ok {
+		quota := tt.opts.quota(name)
+		t = &tenant{name: name, quota: quota, buckets: newBuckets(quota.Bandwidth)}
+		tt.tenants[name] = t
+	}
+	if t.quota.MaxConns > 0 && t.conns >= t.quota.MaxConns {
+		atomic.AddInt64(&t.rejected, 1)
+		return nil, ErrQuotaExceeded
+	}
+	t.conns++
+	return t, nil
+}
+
+// leave counts a connection out of the tenant along with its pending outbound bytes.
+func (tt *tenantTable) leave(t *tenant, pending int) {
+	atomic.AddInt64(&t.pending, -int64(pending))
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if t.conns--; t.conns <= 0 {
+		delete(tt.tenants, t.name)
+	}
+}
+
+func (tt *tenantTable) stats() map[string]TenantStats {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	stats := make(map[string]TenantStats, len(tt.tenants))
+	for name, t := range tt.tenants {
+		stats[name] = TenantStats{
+			Conns:     t.conns,
+			Pending:   atomic.LoadInt64(&t.pending),
+			Rejected:  atomic.LoadInt64(&t.rejected),
+			Throttled: atomic.LoadInt64(&t.throttled),
+		}
+	}
+	return stats
+}
+
+// TenantStats returns the counters of the tenants which have connections, see Options.Quotas.
+func (s *GServer) TenantStats() map[string]TenantStats {
+	if s.s == nil || s.s.quotas == nil {
+		return nil
+	}
+	return s.s.quotas.stats()
+}
+
+// Tenant returns the tenant of the connection, "" if it has none.
+func Tenant(c Conn) string {
+	if c, ok := c.(interface {
+		tenantName() string
+	}); ok {
+		return c.tenantName()
+	}
+	return ""
+}
+
+// SetTenant moves the connection to the tenant, e.g. once the SNI of a TLS handshake is known. It fails with
+// ErrQuotaExceeded if the tenant is at MaxConns, leaving the connection with its current tenant to be closed
+// or served as the event handler sees fit. It must be invoked on the event-loop of the connection and fails
+// with ErrProtocolNotSupported unless the server is set up by WithQuotas.
+func SetTenant(c Conn, tenant string) error {
+	if c, ok := c.(interface {
+		setTenant(name string) error
+	}); ok {
+		return c.setTenant(tenant)
+	}
+	return ErrProtocolNotSupported
+}
+
+// ClientHelloServerName returns the server name (SNI) of the TLS ClientHello which the frame starts with,
+// ok is false if the frame doesn't start with a whole ClientHello or the ClientHello has no server name.
+func ClientHelloServerName(frame []byte) (name string, ok bool) {
+	// TLS record: type(1) version(2) length(2), handshake: type(1) length(3) version(2) random(32).
+	const recordHeader, handshakeHeader = 5, 4
+	if len(frame) < recordHeader+handshakeHeader || frame[0] != 0x16 || frame[recordHeader] != 0x01 {
+		return "", false
+	}
+	end := recordHeader + int(binary.BigEndian.Uint16(frame[3:5]))
+	if end > len(frame) {
+		return "", false
+	}
+	b := frame[recordHeader+handshakeHeader : end]
+	skip := func(n int) bool {
+		if n > len(b) {
+			return false
+		}
+		b = b[n:]
+		return true
+	}
+	vec := func(lenSize int) (v []byte, ok bool) {
+		if len(b) < lenSize {
+			return nil, false
+		}
+		n := 0
+		for _, x := range b[:lenSize] {
+			n = n<<8 | int(x)
+		}
+		if !skip(lenSize) || n > len(b) {
+			return nil, false
+		}
+		v, b = b[:n], b[n:]
+		return v, true
+	}
+	if !skip(2 + 32) {
+		return "", false
+	}
+	if _, ok = vec(1); !ok { // session id
+		return "", false
+	}
+	if _, ok = vec(2); !ok { // cipher suites
+		return "", false
+	}
+	if _, ok = vec(1); !ok { // compression methods
+		return "", false
+	}
+	if b, ok = vec(2); !ok { // extensions
+		return "", false
+	}
+	for len(b) >= 4 {
+		typ := binary.BigEndian.Uint16(b)
+		b = b[2:]
+		ext, ok := vec(2)
+		if !ok {
+			return "", false
+		}
+		if typ != 0 { // server_name
+			continue
+		}
+		b = ext
+		list, ok := vec(2)
+		if !ok {
+			return "", false
+		}
+		for b = list; len(b) >= 3; {
+			nameType := b[0]
+			b = b[1:]
+			host, ok := vec(2)
+			if !ok {
+				return "", false
+			}
+			if nameType == 0 && len(host) > 0 { // host_name
+				return string(host), true
+			}
+		}
+		return "", false
+	}
+	return "", false
+}
@@ -3,17 +3,20 @@
 // license that can be found in the LICENSE file.
 
 // +build darwin netbsd freebsd openbsd dragonfly
+// +build !gnet_net
 
 package gnet
 
 import "github.com/panlibin/gnet/internal/netpoll"
 
-func (svr *server) activateMainReactor() {
+func (svr *server) activateAcceptor(el *eventloop) {
 	defer svr.signalShutdown()
 
-	svr.logger.Printf("main reactor exits with error:%v\n", svr.mainLoop.poller.Polling(func(fd int, filter int16) error {
-		return svr.acceptNewConnection(fd)
-	}))
+	err := el.poller.Polling(func(fd int, _ netpoll.IOEvent) error {
+		return svr.acceptNewConnection(el)
+	})
+	svr.fail(loopFailure(el.idx, err))
+	svr.logger.Printf("acceptor:%d exits with error:%v\n", el.idx, err)
 }
 
 func (svr *server) activateSubReactor(el *eventloop) {
@@ -22,28 +25,10 @@ func (svr *server) activateSubReactor(el *eventloop) {
 	if el.idx == 0 && svr.opts.Ticker {
 		go el.loopTicker()
 	}
+	el.watchStalls()
+	el.watchIdle()
 
-	svr.logger.Printf("event-loop:%d exits with error:%v\n", el.idx, el.poller.Polling(func(fd int, filter int16) error {
-		if c, ack := el.connections[fd]; ack {
-			if filter == netpoll.EVFilterSock {
-				return el.loopCloseConn(c, nil)
-			}
-			switch c.outboundBuffer.IsEmpty() {
-			// Don't change the ordering of processing EVFILT_WRITE | EVFILT_READ | EV_ERROR/EV_EOF unless you're 100%
-			// sure what you're doing!
-			// Re-ordering can easily introduce bugs and bad side-effects, as I found out painfully in the past.
-			case false:
-				if filter == netpoll.EVFilterWrite {
-					return el.loopWrite(c)
-				}
-				return nil
-			case true:
-				if filter == netpoll.EVFilterRead {
-					return el.loopRead(c)
-				}
-				return nil
-			}
-		}
-		return nil
-	}))
+	err := el.poller.PollingBatch(el.handleEvents)
+	svr.fail(loopFailure(el.idx, err))
+	svr.logger.Printf("event-loop:%d exits with error:%v\n", el.idx, err)
 }
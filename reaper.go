@@ -0,0 +1,37 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "time"
+
+// minIdleCheckInterval is the shortest interval at which the event-loops look for idle connections.
+const minIdleCheckInterval = 10 * time.Millisecond
+
+// IdleReaper sets up closing the idle connections in two phases: a connection which has read nothing for Probe
+// is probed by EventHandler.OnIdle, e.g. with an application-level ping, and it is closed with ErrIdleTimeout
+// only if it still reads nothing for Timeout afterwards. Unlike the connections closed at once, the ones whose
+// peers are alive but quiet survive the probe.
+type IdleReaper struct {
+	// Probe is how long a connection may read nothing before OnIdle fires, zero disables the reaper.
+	// The event-loops look for idle connections every quarter of the shorter of Probe and Timeout, so
+	// a connection is probed and closed up to a quarter of it late.
+	Probe time.Duration
+
+	// Timeout is how long a probed connection may read nothing more before it is closed, zero closes it as soon
+	// as OnIdle has returned.
+	Timeout time.Duration
+}
+
+// checkInterval returns the interval at which the event-loops look for idle connections.
+func (ir IdleReaper) checkInterval() time.Duration {
+	d := ir.Probe
+	if ir.Timeout > 0 && ir.Timeout < d {
+		d = ir.Timeout
+	}
+	if d /= 4; d > minIdleCheckInterval {
+		return d
+	}
+	return minIdleCheckInterval
+}
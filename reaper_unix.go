@@ -0,0 +1,81 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+// +build !gnet_net
+
+package gnet
+
+import "time"
+
+// watchIdle arms the timer looking for the idle connections of the event-loop, if IdleReaper.Probe is set.
+func (el *eventloop) watchIdle() {
+	ir := el.svr.opts.IdleReaper
+	if ir.Probe <= 0 {
+		return
+	}
+	el.poller.AddTimer(ir.checkInterval(), func() error {
+		if err := el.checkIdle(time.Now()); err != nil {
+			return err
+		}
+		el.watchIdle()
+		return nil
+	})
+}
+
+// checkIdle probes the connections which have read nothing for IdleReaper.Probe and closes the probed ones
+// which have read nothing for IdleReaper.Timeout since.
+func (el *eventloop) checkIdle(now time.Time) error {
+	ir := el.svr.opts.IdleReaper
+	for _, c := range el.connections {
+		if !c.probedAt.IsZero() && c.stats.lastRead.After(c.probedAt) {
+			c.probedAt = time.Time{} // the peer has answered the probe
+		}
+		switch {
+		case c.probedAt.IsZero():
+			if idle := now.Sub(c.stats.lastRead); idle >= ir.Probe {
+				if err := el.loopIdle(c, idle, now); err != nil {
+					return err
+				}
+			}
+		case now.Sub(c.probedAt) >= ir.Timeout:
+			_ = el.loopWrite(c)
+			if err := el.loopCloseConn(c, ErrIdleTimeout); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// armIdleTimeout arms the timer closing the connection once it has read nothing for ConnSettings.IdleTimeout.
+func (el *eventloop) armIdleTimeout(c *conn) {
+	c.idleTimeout = el.poller.AddTimer(c.settings.IdleTimeout-time.Since(c.stats.lastRead), func() error {
+		c.idleTimeout = nil
+		if time.Since(c.stats.lastRead) < c.settings.IdleTimeout {
+			el.armIdleTimeout(c) // it has read something since the timer was armed
+			return nil
+		}
+		_ = el.loopWrite(c)
+		return el.loopCloseConn(c, ErrIdleTimeout)
+	})
+}
+
+// loopIdle fires OnIdle for the idle connection, which is closed right away if IdleReaper.Timeout is zero.
+func (el *eventloop) loopIdle(c *conn, idle time.Duration, now time.Time) error {
+	c.probedAt = now
+	out, action := el.eventHandler.OnIdle(c, idle)
+	if !c.opened {
+		return nil // detached by the event handler
+	}
+	if out != nil {
+		frame, _ := c.codec.Encode(c, out)
+		c.write(frame)
+	}
+	if action == None && el.svr.opts.IdleReaper.Timeout <= 0 {
+		_ = el.loopWrite(c)
+		return el.loopCloseConn(c, ErrIdleTimeout)
+	}
+	return el.handleAction(c, action)
+}
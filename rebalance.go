@@ -0,0 +1,125 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultRebalanceThreshold = 0.25
+	defaultRebalanceMaxMoves  = 16
+)
+
+// RebalanceMetric is the measure of the load of connections and event-loops, see Rebalance.
+type RebalanceMetric int
+
+const (
+	// RebalanceBytes measures the load by the bytes read and written per second.
+	RebalanceBytes RebalanceMetric = iota
+
+	// RebalanceCallbackTime measures the load by the time spent in reading, decoding and React per second.
+	RebalanceCallbackTime
+)
+
+// Rebalance sets up the rebalancer which periodically moves connections off the overloaded event-loops,
+// since long-lived connections make the initial round-robin assignment drift badly, see Conn.Migrate.
+type Rebalance struct {
+	// Interval is the interval of sampling the load and rebalancing, zero disables the rebalancer.
+	Interval time.Duration
+
+	// Metric is the measure of the load.
+	Metric RebalanceMetric
+
+	// Threshold is the fraction above the mean load of the event-loops beyond which an event-loop is
+	// overloaded, defaults to 0.25.
+	Threshold float64
+
+	// MaxMoves is the maximum number of connections moved per interval, defaults to 16.
+	MaxMoves int
+}
+
+// connLoad is the load of a connection over the last interval.
+type connLoad struct {
+	c    Conn
+	load int64
+}
+
+// connMove is a connection to move to the event-loop of the index.
+type connMove struct {
+	c  Conn
+	to int
+}
+
+// rebalanceLoops samples the load of the connections and rebalances the event-loops periodically
+// until the server stops.
+func (s *GServer) rebalanceLoops(rb Rebalance) {
+	ticker := time.NewTicker(rb.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, m := range planMoves(s.s.sampleLoads(rb.Metric), rb) {
+				if m.c.Migrate(m.to) == nil {
+					atomic.AddInt64(&s.s.stats.rebalanced, 1)
+				}
+			}
+		case <-s.s.loopsDone:
+			return
+		}
+	}
+}
+
+// planMoves picks the connections to move off the overloaded event-loops, the loads are indexed by event-loops.
+func planMoves(loads [][]connLoad, rb Rebalance) (moves []connMove) {
+	if len(loads) < 2 {
+		return
+	}
+	totals := make([]int64, len(loads))
+	var sum int64
+	for i, conns := range loads {
+		for _, cl := range conns {
+			totals[i] += cl.load
+		}
+		sum += totals[i]
+		// The heaviest connections are considered first.
+		sort.Slice(conns, func(a, b int) bool { return conns[a].load > conns[b].load })
+	}
+	limit := float64(sum) / float64(len(loads)) * (1 + rb.Threshold)
+	for len(moves) < rb.MaxMoves {
+		src, dst := 0, 0
+		for i, total := range totals {
+			if total > totals[src] {
+				src = i
+			}
+			if total < totals[dst] {
+				dst = i
+			}
+		}
+		if float64(totals[src]) <= limit {
+			return
+		}
+		// Only the connections lighter than the gap are moved, otherwise the destination ends up heavier
+		// than the source was.
+		gap, picked := totals[src]-totals[dst], -1
+		for i, cl := range loads[src] {
+			if cl.load > 0 && cl.load < gap {
+				picked = i
+				break
+			}
+		}
+		if picked < 0 {
+			return
+		}
+		cl := loads[src][picked]
+		loads[src] = append(loads[src][:picked], loads[src][picked+1:]...)
+		totals[src] -= cl.load
+		totals[dst] += cl.load
+		moves = append(moves, connMove{c: cl.c, to: dst})
+	}
+	return
+}
@@ -0,0 +1,370 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// EventKind is the kind of a recorded event.
+type EventKind uint8
+
+const (
+	// EventOpened is recorded when OnOpened fires.
+	EventOpened EventKind = iota + 1
+
+	// EventReact is recorded when React fires.
+	EventReact
+
+	// EventTick is recorded when Tick fires.
+	EventTick
+
+	// EventClosed is recorded when OnClosed fires.
+	EventClosed
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventOpened:
+		return "opened"
+	case EventReact:
+		return "react"
+	case EventTick:
+		return "tick"
+	case EventClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("EventKind(%d)", k)
+}
+
+// RecordedEvent is an event received by an EventHandler along with its outcome.
+type RecordedEvent struct {
+	// Kind is the kind of the event.
+	Kind EventKind `json:"kind"`
+
+	// Conn is the sequence number of the connection starting from 1, zero for ticks.
+	Conn int `json:"conn,omitempty"`
+
+	// LocalAddr and RemoteAddr are the addresses of the connection, only recorded for EventOpened.
+	LocalAddr  string `json:"local_addr,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+
+	// Frame is the frame passed to React, it is nil for wake-ups.
+	Frame []byte `json:"frame,omitempty"`
+
+	// Err is the error passed to OnClosed.
+	Err string `json:"err,omitempty"`
+
+	// Out and Action are returned by the EventHandler, Delay is returned by Tick.
+	Out    []byte        `json:"out,omitempty"`
+	Action Action        `json:"action,omitempty"`
+	Delay  time.Duration `json:"delay,omitempty"`
+}
+
+// Recorder is an EventHandler which records the events received by the wrapped EventHandler,
+// the recording can be saved with WriteTo and fed through an EventHandler later with Replay.
+type Recorder struct {
+	EventHandler
+
+	mu     sync.Mutex
+	conns  map[Conn]int
+	nextID int
+	events []RecordedEvent
+}
+
+// NewRecorder wraps the given EventHandler with a Recorder.
+func NewRecorder(handler EventHandler) *Recorder {
+	return &Recorder{EventHandler: handler, conns: make(map[Conn]int)}
+}
+
+func (r *Recorder) record(ev RecordedEvent) {
+	r.mu.Lock()
+	r.events = append(r.events, ev)
+	r.mu.Unlock()
+}
+
+func (r *Recorder) connID(c Conn, closed bool) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id, ok := r.conns[c]
+	if !ok {
+		r.nextID++
+		id = r.nextID
+		r.conns[c] = id
+	}
+	if closed {
+		delete(r.conns, c)
+	}
+	return id
+}
+
+// OnOpened records the opening of a connection.
+func (r *Recorder) OnOpened(c Conn) (out []byte, action Action) {
+	id := r.connID(c, false)
+	out, action = r.EventHandler.OnOpened(c)
+	ev := RecordedEvent{Kind: EventOpened, Conn: id, Out: copyBytes(out), Action: action}
+	if c.LocalAddr() != nil {
+		ev.LocalAddr = c.LocalAddr().String()
+	}
+	if c.RemoteAddr() != nil {
+		ev.RemoteAddr = c.RemoteAddr().String()
+	}
+	r.record(ev)
+	return
+}
+
+// React records a frame received from a connection.
+func (r *Recorder) React(frame []byte, c Conn) (out []byte, action Action) {
+	id := r.connID(c, false)
+	in := copyBytes(frame)
+	out, action = r.EventHandler.React(frame, c)
+	r.record(RecordedEvent{Kind: EventReact, Conn: id, Frame: in, Out: copyBytes(out), Action: action})
+	return
+}
+
+// Tick records a tick.
+func (r *Recorder) Tick() (delay time.Duration, action Action) {
+	delay, action = r.EventHandler.Tick()
+	r.record(RecordedEvent{Kind: EventTick, Delay: delay, Action: action})
+	return
+}
+
+// OnClosed records the closing of a connection.
+func (r *Recorder) OnClosed(c Conn, err error) (action Action) {
+	id := r.connID(c, true)
+	action = r.EventHandler.OnClosed(c, err)
+	ev := RecordedEvent{Kind: EventClosed, Conn: id, Action: action}
+	if err != nil {
+		ev.Err = err.Error()
+	}
+	r.record(ev)
+	return
+}
+
+// Events returns a copy of the events recorded so far.
+func (r *Recorder) Events() []RecordedEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecordedEvent(nil), r.events...)
+}
+
+// WriteTo writes the recorded events to w as JSON lines.
+func (r *Recorder) WriteTo(w io.Writer) (n int64, err error) {
+	cw := &countWriter{w: w}
+	enc := json.NewEncoder(cw)
+	for _, ev := range r.Events() {
+		if err = enc.Encode(&ev); err != nil {
+			break
+		}
+	}
+	return cw.n, err
+}
+
+// ReadRecording reads the events written by Recorder.WriteTo.
+func ReadRecording(r io.Reader) (events []RecordedEvent, err error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var ev RecordedEvent
+		if err = dec.Decode(&ev); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		events = append(events, ev)
+	}
+}
+
+// Replay feeds the recorded events through the given EventHandler one by one on the calling goroutine,
+// the connections are simulated in memory, and returns the events along with the outcomes of the handler,
+// so that they can be compared with the recorded ones. Data written by AsyncWrite or SendTo of the simulated
+// connections is appended to the Out of the event being replayed.
+func Replay(handler EventHandler, events []RecordedEvent) ([]RecordedEvent, error) {
+	conns := make(map[int]*memConn)
+	replayed := make([]RecordedEvent, 0, len(events))
+	for _, ev := range events {
+		res := RecordedEvent{Kind: ev.Kind, Conn: ev.Conn, LocalAddr: ev.LocalAddr, RemoteAddr: ev.RemoteAddr,
+			Frame: ev.Frame, Err: ev.Err}
+		switch ev.Kind {
+		case EventOpened:
+			c := &memConn{localAddr: replayAddr(ev.LocalAddr), remoteAddr: replayAddr(ev.RemoteAddr)}
+			conns[ev.Conn] = c
+			res.Out, res.Action = handler.OnOpened(c)
+			res.Out = c.flush(res.Out)
+		case EventReact:
+			c, ok := conns[ev.Conn]
+			if !ok {
+				return replayed, fmt.Errorf("replay: frame of unknown connection %d", ev.Conn)
+			}
+			c.buffer = ev.Frame
+			res.Out, res.Action = handler.React(ev.Frame, c)
+			res.Out = c.flush(res.Out)
+		case EventTick:
+			res.Delay, res.Action = handler.Tick()
+		case EventClosed:
+			c, ok := conns[ev.Conn]
+			if !ok {
+				return replayed, fmt.Errorf("replay: close of unknown connection %d", ev.Conn)
+			}
+			var err error
+			if ev.Err != "" {
+				err = errors.New(ev.Err)
+			}
+			res.Action = handler.OnClosed(c, err)
+			delete(conns, ev.Conn)
+		default:
+			return replayed, fmt.Errorf("replay: unknown event kind %d", ev.Kind)
+		}
+		replayed = append(replayed, res)
+	}
+	return replayed, nil
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+	return
+}
+
+// replayAddr is the net.Addr of a simulated connection.
+type replayAddr string
+
+func (a replayAddr) Network() string { return "replay" }
+func (a replayAddr) String() string  { return string(a) }
+
+// memConn is a Conn simulated in memory whose inbound buffer is set directly, it is used for replaying and
+// decoding frames out of event-loops.
+type memConn struct {
+	ctx                   interface{}
+	localAddr, remoteAddr net.Addr
+	buffer                []byte
+	written               []byte
+	codec                 ICodec
+}
+
+// flush returns the output of the handler appended with the data written asynchronously.
+func (c *memConn) flush(out []byte) []byte {
+	out = append(copyBytes(out), c.written...)
+	c.written = nil
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func (c *memConn) Context() interface{}       { return c.ctx }
+func (c *memConn) SetContext(ctx interface{}) { c.ctx = ctx }
+func (c *memConn) Peer() *Peer                { return nil }
+func (c *memConn) SetTOS(tos byte) error      { return ErrProtocolNotSupported }
+func (c *memConn) Stats() ConnStats           { return ConnStats{InboundBuffered: len(c.buffer)} }
+func (c *memConn) LocalAddr() net.Addr        { return c.localAddr }
+func (c *memConn) RemoteAddr() net.Addr       { return c.remoteAddr }
+func (c *memConn) Read() []byte               { return c.buffer }
+func (c *memConn) ResetBuffer()               { c.buffer = nil }
+func (c *memConn) BufferLength() int          { return len(c.buffer) }
+func (c *memConn) Wake() error                { return nil }
+func (c *memConn) LoopIndex() int             { return -1 }
+func (c *memConn) Migrate(idx int) error      { return ErrProtocolNotSupported }
+func (c *memConn) PauseRead() error           { return nil }
+func (c *memConn) ResumeRead() error          { return nil }
+func (c *memConn) Close() error               { return nil }
+func (c *memConn) CloseReason() CloseReason   { return CloseNone }
+
+func (c *memConn) OriginalDst() (net.Addr, error) {
+	return nil, ErrProtocolNotSupported
+}
+
+func (c *memConn) SyscallConn() (syscall.RawConn, error) {
+	return nil, ErrProtocolNotSupported
+}
+
+func (c *memConn) Upgrade(codec ICodec) {
+	c.codec = codec
+}
+
+func (c *memConn) Detach() (net.Conn, error) {
+	return nil, ErrProtocolNotSupported
+}
+
+func (c *memConn) Tick(interval time.Duration, fn ConnTickFunc) (cancel func()) {
+	return func() {}
+}
+
+func (c *memConn) ReadN(n int) (size int, buf []byte) {
+	if n <= 0 || n > len(c.buffer) {
+		return
+	}
+	return n, c.buffer[:n]
+}
+
+func (c *memConn) ShiftN(n int) (size int) {
+	if n <= 0 || n > len(c.buffer) {
+		size = len(c.buffer)
+		c.buffer = nil
+		return
+	}
+	c.buffer = c.buffer[n:]
+	return n
+}
+
+func (c *memConn) SendTo(buf []byte) error {
+	c.written = append(c.written, buf...)
+	return nil
+}
+
+func (c *memConn) AsyncWrite(buf []byte) error {
+	c.written = append(c.written, buf...)
+	return nil
+}
+
+func (c *memConn) AsyncWriteWithPriority(buf []byte, priority WritePriority) error {
+	return c.AsyncWrite(buf)
+}
+
+func (c *memConn) Writer() io.Writer {
+	return memConnWriter{c}
+}
+
+func (c *memConn) Write(p []byte) (int, error) {
+	return memConnWriter{c}.Write(p)
+}
+
+func (c *memConn) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(memConnWriter{c}, r)
+}
+
+func (c *memConn) WriteTo(w io.Writer) (int64, error) {
+	return writeInboundTo(c, w)
+}
+
+// memConnWriter streams data to a memConn.
+type memConnWriter struct {
+	c *memConn
+}
+
+func (w memConnWriter) Write(p []byte) (int, error) {
+	w.c.written = append(w.c.written, p...)
+	return len(p), nil
+}
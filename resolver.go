@@ -0,0 +1,118 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Resolver resolves the host names and SRV records of the addresses dialed by Client, *net.Resolver
+// implements it.
+type Resolver interface {
+	// LookupHost returns the addresses of the host.
+	LookupHost(ctx context.Context, host string) (addrs []string, err error)
+
+	// LookupSRV returns the SRV records of the service, see net.Resolver.LookupSRV.
+	LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+}
+
+// ResolverCache caches the results of a Resolver and coalesces the concurrent lookups of the same name, so that
+// a storm of dials does not hammer the resolver. The results are cached for a fixed TTL since the system
+// resolver does not report the TTLs of the records, the failed lookups for the negative TTL.
+type ResolverCache struct {
+	resolver         Resolver
+	ttl, negativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*resolverEntry
+}
+
+// resolverEntry is the result of a lookup, its fields are set before ready is closed.
+type resolverEntry struct {
+	ready   chan struct{}
+	expires time.Time
+	addrs   []string
+	cname   string
+	srvs    []*net.SRV
+	err     error
+}
+
+// NewResolverCache instantiates a cache of the given resolver, net.DefaultResolver is used if it is nil.
+func NewResolverCache(resolver Resolver, ttl, negativeTTL time.Duration) *ResolverCache {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &ResolverCache{
+		resolver:    resolver,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		entries:     make(map[string]*resolverEntry),
+	}
+}
+
+// LookupHost ...
+func (r *ResolverCache) LookupHost(ctx context.Context, host string) ([]string, error) {
+	e, err := r.lookup(ctx, "host:"+host, func(e *resolverEntry) {
+		e.addrs, e.err = r.resolver.LookupHost(ctx, host)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), e.addrs...), e.err
+}
+
+// LookupSRV ...
+func (r *ResolverCache) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	e, err := r.lookup(ctx, "srv:"+service+"/"+proto+"/"+name, func(e *resolverEntry) {
+		e.cname, e.srvs, e.err = r.resolver.LookupSRV(ctx, service, proto, name)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return e.cname, append([]*net.SRV(nil), e.srvs...), e.err
+}
+
+// lookup returns the cached entry of key, it looks the entry up by fn if it is missing or expired and waits for
+// the lookup in flight if there is one.
+func (r *ResolverCache) lookup(ctx context.Context, key string, fn func(e *resolverEntry)) (*resolverEntry, error) {
+	r.mu.Lock()
+	if e := r.entries[key]; e != nil {
+		select {
+		case <-e.ready:
+			if time.Now().Before(e.expires) {
+				r.mu.Unlock()
+				return e, nil
+			}
+		default:
+			r.mu.Unlock()
+			select {
+			case <-e.ready:
+				return e, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+	e := &resolverEntry{ready: make(chan struct{})}
+	r.entries[key] = e
+	r.mu.Unlock()
+
+	fn(e)
+	ttl := r.ttl
+	switch {
+	case e.err == nil:
+	case ctx.Err() != nil:
+		// The lookup has been canceled by the caller rather than failed.
+		ttl = 0
+	default:
+		ttl = r.negativeTTL
+	}
+	e.expires = time.Now().Add(ttl)
+	close(e.ready)
+	return e, nil
+}
@@ -0,0 +1,235 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package rpc is a scaffold for request/response servers built on gnet.
+//
+// The frames decoded by the codec of the gnet server are parsed into requests by a Protocol, the requests
+// are served by a Handler which returns a Future, and the responses are written back to the connection
+// when the futures are resolved, either in the order of the requests or as soon as they are ready:
+//
+//	handler := func(c gnet.Conn, req []byte) *rpc.Future {
+//		return rpc.Go(pool, func() ([]byte, error) { return process(req) })
+//	}
+//	log.Fatal(gnet.Serve(rpc.NewServer(protocol, handler, rpc.InOrder), "tcp://:9000", gnet.WithCodec(codec)))
+package rpc
+
+import (
+	"sync"
+
+	"github.com/panlibin/gnet"
+	"github.com/panlibin/gnet/pool/goroutine"
+)
+
+// Protocol extracts the requests from the frames and builds the frames of the responses.
+type Protocol interface {
+	// ParseRequest extracts the ID and the payload of a request from a frame decoded by the codec,
+	// the connection is closed if it returns an error.
+	ParseRequest(frame []byte) (id uint64, req []byte, err error)
+
+	// FormatResponse builds the frame of the response to the request with the given ID,
+	// err is the error returned by the Handler, the frame is encoded by the codec before being written.
+	FormatResponse(id uint64, resp []byte, err error) []byte
+}
+
+// Handler serves a request, the payload of the request is only valid until Handler returns,
+// so it must be copied before being used by another goroutine.
+type Handler func(c gnet.Conn, req []byte) *Future
+
+// Ordering decides the order in which the responses are written to a connection.
+type Ordering int
+
+const (
+	// InOrder writes the responses in the order of the requests, as most pipelined protocols require.
+	InOrder Ordering = iota
+
+	// ByID writes the responses as soon as they are ready, the peer correlates them with the requests by ID.
+	ByID
+)
+
+// Future is the response to a request which is resolved later. A handler may return the same future for
+// several requests, e.g. to coalesce them, each of them is answered with its result.
+type Future struct {
+	mu       sync.Mutex
+	done     chan struct{}
+	resolved bool
+	resp     []byte
+	err      error
+	then     []func(resp []byte, err error) // the callbacks of the requests waiting for the future
+}
+
+// NewFuture returns an unresolved future.
+func NewFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+// Resolved returns a future resolved with the given response.
+func Resolved(resp []byte, err error) *Future {
+	f := NewFuture()
+	f.Resolve(resp, err)
+	return f
+}
+
+// Go runs fn on the worker pool and returns a future resolved with its result,
+// the future fails if the job cannot be submitted to the pool.
+func Go(pool *goroutine.Pool, fn func() ([]byte, error)) *Future {
+	f := NewFuture()
+	if err := pool.Submit(func() { f.Resolve(fn()) }); err != nil {
+		f.Resolve(nil, err)
+	}
+	return f
+}
+
+// Resolve resolves the future, it returns false if the future has already been resolved.
+func (f *Future) Resolve(resp []byte, err error) bool {
+	f.mu.Lock()
+	if f.resolved {
+		f.mu.Unlock()
+		return false
+	}
+	f.resolved, f.resp, f.err = true, resp, err
+	then := f.then
+	f.then = nil
+	f.mu.Unlock()
+	close(f.done)
+	for _, fn := range then {
+		fn(resp, err)
+	}
+	return true
+}
+
+// Wait blocks until the future is resolved and returns its result.
+func (f *Future) Wait() ([]byte, error) {
+	<-f.done
+	return f.resp, f.err
+}
+
+// result returns the result if the future has been resolved, otherwise it arranges for fn to be called
+// with the result once the future is resolved, along with the callbacks arranged before.
+func (f *Future) result(fn func(resp []byte, err error)) (ok bool, resp []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.resolved {
+		return true, f.resp, f.err
+	}
+	f.then = append(f.then, fn)
+	return
+}
+
+// Server is a gnet.EventHandler serving requests with a Handler, compose it with other handlers by
+// gnet.Chain or by embedding it.
+type Server struct {
+	*gnet.EventServer
+
+	protocol Protocol
+	handler  Handler
+	ordering Ordering
+	conns    sync.Map // gnet.Conn -> *connState
+}
+
+// connState tracks the outstanding requests of a connection.
+type connState struct {
+	mu        sync.Mutex
+	closed    bool
+	nextSeq   uint64               // sequence of the next request
+	nextWrite uint64               // sequence of the next response to write, used by InOrder
+	pending   map[uint64][]byte    // responses resolved out of order, used by InOrder
+	futures   map[*Future]struct{} // futures not resolved yet
+}
+
+// NewServer returns a Server which serves the requests parsed by protocol with handler.
+func NewServer(protocol Protocol, handler Handler, ordering Ordering) *Server {
+	return &Server{protocol: protocol, handler: handler, ordering: ordering}
+}
+
+// OnOpened starts tracking the requests of the connection.
+func (s *Server) OnOpened(c gnet.Conn) (out []byte, action gnet.Action) {
+	s.conns.Store(c, &connState{pending: make(map[uint64][]byte), futures: make(map[*Future]struct{})})
+	return
+}
+
+// OnClosed discards the outstanding responses of the connection and fails the futures not resolved yet
+// with gnet.ErrConnectionClosed, so that the handlers may learn from Resolve returning false to give up.
+func (s *Server) OnClosed(c gnet.Conn, err error) (action gnet.Action) {
+	if v, ok := s.conns.Load(c); ok {
+		cs := v.(*connState)
+		cs.mu.Lock()
+		cs.closed = true
+		cs.pending = nil
+		futures := cs.futures
+		cs.futures = nil
+		cs.mu.Unlock()
+		s.conns.Delete(c)
+		for f := range futures {
+			f.Resolve(nil, gnet.ErrConnectionClosed)
+		}
+	}
+	return
+}
+
+// React parses a request and serves it. The response of ByID is returned directly when it is resolved
+// synchronously, other responses are written asynchronously since responses in front of them may be queued.
+func (s *Server) React(frame []byte, c gnet.Conn) (out []byte, action gnet.Action) {
+	v, ok := s.conns.Load(c)
+	if !ok || frame == nil {
+		return
+	}
+	cs := v.(*connState)
+	id, req, err := s.protocol.ParseRequest(frame)
+	if err != nil {
+		action = gnet.Close
+		return
+	}
+
+	cs.mu.Lock()
+	seq := cs.nextSeq
+	cs.nextSeq++
+	cs.mu.Unlock()
+
+	f := s.handler(c, req)
+	cs.mu.Lock()
+	if cs.futures != nil {
+		cs.futures[f] = struct{}{}
+	}
+	cs.mu.Unlock()
+	ok, resp, err := f.result(func(resp []byte, err error) {
+		s.reply(c, cs, seq, f, s.protocol.FormatResponse(id, resp, err))
+	})
+	if !ok {
+		return
+	}
+	cs.mu.Lock()
+	delete(cs.futures, f)
+	cs.mu.Unlock()
+	if s.ordering == ByID {
+		out = s.protocol.FormatResponse(id, resp, err)
+		return
+	}
+	s.reply(c, cs, seq, nil, s.protocol.FormatResponse(id, resp, err))
+	return
+}
+
+// reply writes the response with the given sequence asynchronously, for InOrder it is held until
+// the responses in front of it are written.
+func (s *Server) reply(c gnet.Conn, cs *connState, seq uint64, f *Future, frame []byte) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.closed {
+		return
+	}
+	delete(cs.futures, f)
+	if s.ordering == ByID {
+		_ = c.AsyncWrite(frame)
+		return
+	}
+	cs.pending[seq] = frame
+	for {
+		frame, ok := cs.pending[cs.nextWrite]
+		if !ok {
+			return
+		}
+		delete(cs.pending, cs.nextWrite)
+		cs.nextWrite++
+		_ = c.AsyncWrite(frame)
+	}
+}
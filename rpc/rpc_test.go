@@ -0,0 +1,176 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/panlibin/gnet"
+	"github.com/panlibin/gnet/gnettest"
+)
+
+// testProtocol parses the requests as "id:payload" and formats the responses as "id=payload;".
+type testProtocol struct{}
+
+func (testProtocol) ParseRequest(frame []byte) (id uint64, req []byte, err error) {
+	i := bytes.IndexByte(frame, ':')
+	if i < 0 {
+		return 0, nil, errors.New("malformed request")
+	}
+	id, err = strconv.ParseUint(string(frame[:i]), 10, 64)
+	return id, frame[i+1:], err
+}
+
+func (testProtocol) FormatResponse(id uint64, resp []byte, err error) []byte {
+	if err != nil {
+		resp = []byte(err.Error())
+	}
+	return []byte(strconv.FormatUint(id, 10) + "=" + string(resp) + ";")
+}
+
+// testHandler hands out a future per request, which the test resolves.
+type testHandler struct {
+	futures []*Future
+}
+
+func (h *testHandler) serve(c gnet.Conn, req []byte) *Future {
+	f := NewFuture()
+	h.futures = append(h.futures, f)
+	return f
+}
+
+func openTestServer(ordering Ordering) (*Server, *testHandler, *gnettest.Conn) {
+	h := new(testHandler)
+	s := NewServer(testProtocol{}, h.serve, ordering)
+	c := gnettest.NewConn(nil)
+	s.OnOpened(c)
+	return s, h, c
+}
+
+func TestInOrder(t *testing.T) {
+	s, h, c := openTestServer(InOrder)
+	for _, req := range []string{"1:a", "2:b", "3:c"} {
+		if out, action := s.React([]byte(req), c); out != nil || action != gnet.None {
+			t.Fatalf("unexpected output %q and action %v", out, action)
+		}
+	}
+	h.futures[2].Resolve([]byte("C"), nil)
+	h.futures[1].Resolve([]byte("B"), nil)
+	if w := c.Written(); len(w) != 0 {
+		t.Fatalf("responses %q written ahead of the first one", w)
+	}
+	h.futures[0].Resolve([]byte("A"), nil)
+	if w := string(c.Written()); w != "1=A;2=B;3=C;" {
+		t.Fatalf("got %q, want the responses in the order of the requests", w)
+	}
+
+	// A future resolved already is written right away once the ones in front of it are.
+	s.React([]byte("4:d"), c)
+	h.futures[3].Resolve([]byte("D"), nil)
+	if w := string(c.Written()); w != "4=D;" {
+		t.Fatalf("got %q, want 4=D;", w)
+	}
+}
+
+func TestByID(t *testing.T) {
+	s, h, c := openTestServer(ByID)
+	s.React([]byte("7:a"), c)
+	s.React([]byte("9:b"), c)
+	h.futures[1].Resolve([]byte("B"), nil)
+	h.futures[0].Resolve(nil, errors.New("failed"))
+	if w := string(c.Written()); w != "9=B;7=failed;" {
+		t.Fatalf("got %q, want the responses as they are resolved, tagged with the request IDs", w)
+	}
+
+	// A response resolved synchronously is returned by React.
+	s.handler = func(c gnet.Conn, req []byte) *Future { return Resolved([]byte("now"), nil) }
+	if out, _ := s.React([]byte("11:c"), c); string(out) != "11=now;" {
+		t.Fatalf("got %q, want 11=now;", out)
+	}
+
+	if _, action := s.React([]byte("malformed"), c); action != gnet.Close {
+		t.Fatalf("got action %v for a malformed request, want Close", action)
+	}
+}
+
+func TestSharedFuture(t *testing.T) {
+	s, _, c := openTestServer(InOrder)
+	// The requests for the same payload are coalesced into a single future.
+	shared := make(map[string]*Future)
+	s.handler = func(c gnet.Conn, req []byte) *Future {
+		f, ok := shared[string(req)]
+		if !ok {
+			f = NewFuture()
+			shared[string(req)] = f
+		}
+		return f
+	}
+	for _, req := range []string{"1:a", "2:b", "3:a", "4:b"} {
+		s.React([]byte(req), c)
+	}
+	shared["b"].Resolve([]byte("B"), nil)
+	if w := c.Written(); len(w) != 0 {
+		t.Fatalf("responses %q written ahead of the first one", w)
+	}
+	shared["a"].Resolve([]byte("A"), nil)
+	if w := string(c.Written()); w != "1=A;2=B;3=A;4=B;" {
+		t.Fatalf("got %q, want every request answered in order", w)
+	}
+
+	// The connection carries on with the requests after them.
+	s.React([]byte("5:c"), c)
+	shared["c"].Resolve([]byte("C"), nil)
+	if w := string(c.Written()); w != "5=C;" {
+		t.Fatalf("got %q, want 5=C;", w)
+	}
+}
+
+func TestFutureResolvedElsewhere(t *testing.T) {
+	s, h, c := openTestServer(InOrder)
+	s.React([]byte("1:a"), c)
+	f := h.futures[0]
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		f.Resolve([]byte("A"), nil)
+	}()
+	resp, err := f.Wait()
+	if err != nil || string(resp) != "A" {
+		t.Fatalf("got %q, %v, want A", resp, err)
+	}
+	if w := string(c.Written()); w != "1=A;" {
+		t.Fatalf("got %q, want 1=A;", w)
+	}
+	if f.Resolve([]byte("again"), nil) {
+		t.Fatal("a future is resolved twice")
+	}
+}
+
+func TestPendingFuturesFailOnClose(t *testing.T) {
+	s, h, c := openTestServer(InOrder)
+	s.React([]byte("1:a"), c)
+	s.React([]byte("2:b"), c)
+	h.futures[1].Resolve([]byte("B"), nil)
+	s.OnClosed(c, nil)
+
+	for i, f := range h.futures {
+		_, err := f.Wait()
+		if i == 0 && err != gnet.ErrConnectionClosed {
+			t.Fatalf("pending future failed with %v, want ErrConnectionClosed", err)
+		}
+		if i == 1 && err != nil {
+			t.Fatalf("resolved future failed with %v", err)
+		}
+	}
+	if h.futures[0].Resolve([]byte("A"), nil) {
+		t.Fatal("a future of a closed connection is resolved")
+	}
+	if w := c.Written(); len(w) != 0 {
+		t.Fatalf("responses %q written to a closed connection", w)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+// +build !gnet_net
+
+package gnet
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// sendFile streams the region of the file from the current offset through the stream writer of the connection,
+// the chunks are sent by sendfile(2) when they can be written to the socket at once, otherwise they are read
+// into the outbound buffer. It returns false if r is not a regular file.
+func (c *conn) sendFile(r io.Reader) (n int64, handled bool, err error) {
+	remain := int64(1<<63 - 1)
+	lr, ok := r.(*io.LimitedReader)
+	if ok {
+		remain, r = lr.N, lr.R
+		if remain <= 0 {
+			return 0, true, nil
+		}
+	}
+	f, ok := r.(*os.File)
+	if !ok {
+		return
+	}
+	fi, e := f.Stat()
+	if e != nil || !fi.Mode().IsRegular() {
+		return
+	}
+	offset, e := f.Seek(0, io.SeekCurrent)
+	if e != nil {
+		return
+	}
+	if size := fi.Size() - offset; size < remain {
+		remain = size
+	}
+
+	handled = true
+	w := c.Writer().(*connWriter)
+	fd := int(f.Fd())
+	for remain > 0 {
+		size := int64(w.conf.ChunkSize)
+		if size > remain {
+			size = remain
+		}
+		if err = w.reserve(int(size)); err != nil {
+			break
+		}
+		off := offset + n
+		if err = c.triggerWrite(func() error {
+			c.loop.loopSendFile(c, w, fd, off, int(size))
+			return nil
+		}); err != nil {
+			w.fail(err)
+			break
+		}
+		n += size
+		remain -= size
+	}
+	// The file must not be touched by the event-loop once sendFile returns.
+	if e := w.drain(); err == nil {
+		err = e
+	}
+	if _, e := f.Seek(offset+n, io.SeekStart); err == nil {
+		err = e
+	}
+	if lr != nil {
+		lr.N -= n
+	}
+	return
+}
+
+// loopSendFile writes size bytes of the file at the offset to the connection.
+func (el *eventloop) loopSendFile(c *conn, w *connWriter, fd int, offset int64, size int) {
+	if !c.opened {
+		w.fail(ErrConnectionClosed)
+		return
+	}
+	c.stream = w
+	defer c.settleStream()
+	defer w.settle(size, 0)
+
+	// Bytes must be copied when they are ahead of the socket, mirrored or subject to faults.
+	if c.outboundBuffer.IsEmpty() && len(c.urgent) == 0 && c.tap == nil && c.fault == nil && c.shaping == nil {
+		for size > 0 {
+			// Not every platform advances the offset passed to sendfile.
+			off := offset
+			n, err := unix.Sendfile(c.fd, fd, &off, size)
+			if n > 0 {
+				offset += int64(n)
+				size -= n
+			}
+			if n <= 0 || err != nil {
+				break
+			}
+		}
+		if size == 0 {
+			return
+		}
+	}
+
+	buf := make([]byte, size)
+	for read := 0; read < size; {
+		n, err := unix.Pread(fd, buf[read:], offset+int64(read))
+		if n <= 0 {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			w.fail(err)
+			_ = el.loopCloseConn(c, err)
+			return
+		}
+		read += n
+	}
+	c.write(buf)
+}
@@ -0,0 +1,285 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Copyright 2018 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows gnet_net
+
+package gnet
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panlibin/gnet/internal"
+)
+
+var (
+	errClosing    = errors.New("closing")
+	errCloseConns = errors.New("close conns")
+)
+
+type server struct {
+	ln               *listener          // all the listeners
+	cond             *sync.Cond         // shutdown signaler
+	signaled         bool               // shutdown has been signaled, guarded by cond.L
+	opts             *Options           // options with server
+	tunables         atomic.Value       // *Tunables changed at runtime
+	cause            error              // error which has brought the server down, guarded by cond.L
+	info             Server             // server information handed to OnInitComplete and OnShutdown
+	once             sync.Once          // make sure only signalShutdown once
+	codec            ICodec             // codec for TCP stream
+	loops            []*eventloop       // all the loops
+	loopWG           sync.WaitGroup     // loop close WaitGroup
+	logger           Logger             // customized logger for logging info
+	ticktock         chan time.Duration // ticker channel
+	listenerWG       sync.WaitGroup     // listener close WaitGroup
+	eventHandler     EventHandler       // user eventHandler
+	shedder          *shedder           // load shedder, nil if load shedding is disabled
+	tapper           *tapper            // traffic tapper, nil if tapping is disabled
+	quotas           *tenantTable       // always nil, Options.Quotas is not supported on windows
+	stats            serverStats        // server-wide counters
+	subLoopGroup     IEventLoopGroup    // loops for handling events
+	subLoopGroupSize int                // number of loops
+	loopsDone        chan struct{}      // closed once all the loops have exited
+	acceptStopped    int32              // set once the server has stopped accepting new connections
+}
+
+// waitForShutdown waits for a signal to shutdown.
+func (svr *server) waitForShutdown() error {
+	svr.cond.L.Lock()
+	for !svr.signaled {
+		svr.cond.Wait()
+	}
+	err := svr.cause
+	svr.cond.L.Unlock()
+	return err
+}
+
+// signalShutdown signals a shutdown an begins server closing.
+func (svr *server) signalShutdown() {
+	svr.once.Do(func() {
+		svr.cond.L.Lock()
+		svr.signaled = true
+		svr.cond.Signal()
+		svr.cond.L.Unlock()
+	})
+}
+
+// fail records the error which brings the server down unless it is shutting down already, see OnShutdown.
+func (svr *server) fail(err error) {
+	if err == nil || err == ErrServerShutdown {
+		return
+	}
+	svr.cond.L.Lock()
+	if !svr.signaled && svr.cause == nil {
+		svr.cause = err
+	}
+	svr.cond.L.Unlock()
+}
+
+// stopAccepting stops accepting new connections and closes the listener, which ends the listener goroutine
+// without shutting down the server.
+func (svr *server) stopAccepting() {
+	if svr.ln.pconn != nil {
+		return
+	}
+	atomic.StoreInt32(&svr.acceptStopped, 1)
+	svr.ln.close()
+	svr.listenerWG.Wait()
+}
+
+// drainConns fires OnDraining for every connection, it reports whether the event handler has asked to shut down.
+func (svr *server) drainConns() (shutdown bool) {
+	svr.forEachConn(func(c Conn) bool {
+		sc := c.(*stdConn)
+		shutdown = sc.loop.loopDrain(sc)
+		return !shutdown
+	})
+	return
+}
+
+// sampleLoads returns nil since the connections cannot be migrated on Windows.
+func (svr *server) sampleLoads(metric RebalanceMetric) [][]connLoad {
+	return nil
+}
+
+// acceptorMetrics returns nil since there are no acceptor loops with the net transport.
+func (svr *server) acceptorMetrics() []*internal.LoopMetrics {
+	return nil
+}
+
+// queueDepths returns the numbers of urgent and ordinary asynchronous jobs pending on the event-loops, there are
+// no urgent jobs with the net transport as the event-loops have a single queue.
+func (svr *server) queueDepths() (urgent, normal int) {
+	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+		normal += len(el.ch)
+		if el.handoff != nil {
+			normal += el.handoff.len()
+		}
+		return true
+	})
+	return
+}
+
+// wakeups returns zeros since the event-loops of the net transport are woken up by their channels.
+func (svr *server) wakeups() (wakeups, coalesced int64) {
+	return
+}
+
+func (svr *server) startListener() {
+	svr.listenerWG.Add(1)
+	go func() {
+		svr.listenerRun()
+		svr.listenerWG.Done()
+	}()
+}
+
+func (svr *server) startLoops(numEventLoop int) {
+	for i := 0; i < numEventLoop; i++ {
+		el := &eventloop{
+			ch:           make(chan interface{}, svr.opts.Handoff.QueueSize),
+			idx:          i,
+			svr:          svr,
+			codec:        svr.codec,
+			connections:  make(map[*stdConn]bool),
+			eventHandler: svr.eventHandler,
+		}
+		if svr.opts.LoopMetrics {
+			el.metrics = new(internal.LoopMetrics)
+		}
+		if svr.opts.Handoff.Direct {
+			el.handoff = new(handoffQueue)
+		}
+		svr.subLoopGroup.register(el)
+	}
+	svr.subLoopGroupSize = svr.subLoopGroup.len()
+	svr.loopWG.Add(svr.subLoopGroupSize)
+	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+		go el.loopRun()
+		return true
+	})
+}
+
+func (svr *server) stop() {
+	// Wait on a signal for shutdown.
+	cause := svr.waitForShutdown()
+	svr.logger.Printf("server is being shutdown with err: %v\n", cause)
+
+	// Close listener.
+	svr.ln.close()
+	svr.listenerWG.Wait()
+
+	// Notify all loops to close.
+	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+		el.ch <- errClosing
+		return true
+	})
+
+	// Wait on all loops to close.
+	svr.loopWG.Wait()
+
+	// Close all connections.
+	svr.loopWG.Add(svr.subLoopGroupSize)
+	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+		el.ch <- errCloseConns
+		return true
+	})
+	svr.loopWG.Wait()
+	close(svr.loopsDone)
+
+	if svr.shedder != nil {
+		svr.shedder.stop()
+	}
+	svr.eventHandler.OnShutdown(svr.info, cause)
+}
+
+// forEachConn invokes fn for every connection on its event-loop until fn returns false.
+func (svr *server) forEachConn(fn func(c Conn) bool) {
+	var stopped bool
+	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+		done := make(chan struct{})
+		job := func() error {
+			defer close(done)
+			for c := range el.connections {
+				if !fn(c) {
+					stopped = true
+					break
+				}
+			}
+			return nil
+		}
+		select {
+		case el.ch <- job:
+		case <-svr.loopsDone:
+			return false
+		}
+		select {
+		case <-done:
+			return !stopped
+		case <-svr.loopsDone:
+			return false
+		}
+	})
+}
+
+func (s *GServer) serve(eventHandler EventHandler, listener *listener, options *Options) (err error) {
+	// The options have been resolved, see Options.resolve.
+	numEventLoop := options.NumEventLoop
+
+	svr := new(server)
+	svr.opts = options
+	svr.tunables.Store(&Tunables{
+		TCPKeepAlive: options.TCPKeepAlive,
+		WriteQuantum: options.WriteQuantum,
+		StreamWriter: options.StreamWriter,
+	})
+	svr.eventHandler = eventHandler
+	svr.ln = listener
+	svr.subLoopGroup = new(eventLoopGroup)
+	svr.ticktock = make(chan time.Duration, 1)
+	svr.loopsDone = make(chan struct{})
+	svr.cond = sync.NewCond(&sync.Mutex{})
+	svr.logger = options.Logger
+	svr.codec = options.Codec
+	if options.LoadShedding.enabled() {
+		svr.shedder = newShedder(options.LoadShedding, &svr.stats)
+	}
+	if options.Tap.Sink != nil {
+		svr.tapper = newTapper(options.Tap, &svr.stats)
+	}
+	s.s = svr
+
+	svr.info = Server{
+		Multicore:    options.Multicore,
+		Addr:         listener.lnaddr,
+		NumEventLoop: numEventLoop,
+		ReusePort:    options.ReusePort,
+		TCPKeepAlive: options.TCPKeepAlive,
+		opts:         options,
+	}
+	switch svr.eventHandler.OnInitComplete(svr.info) {
+	case None:
+	case Shutdown:
+		close(svr.loopsDone)
+		return
+	}
+
+	// Start all loops.
+	svr.startLoops(numEventLoop)
+	// Start listener.
+	svr.startListener()
+	if svr.shedder != nil {
+		go svr.shedder.sampleMemory(svr.shedLoad)
+	}
+	// defer svr.stop()
+	s.sdwg.Add(1)
+	go func() {
+		svr.stop()
+		s.sdwg.Done()
+	}()
+
+	return
+}
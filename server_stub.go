@@ -3,7 +3,7 @@
 // Use of this source code is governed by an MIT-style
 // license that can be found in the LICENSE file.
 
-// +build !darwin,!netbsd,!freebsd,!openbsd,!dragonfly,!linux,!windows
+// +build !darwin,!netbsd,!freebsd,!openbsd,!dragonfly,!linux,!windows,!gnet_net
 
 package gnet
 
@@ -28,6 +28,10 @@ func (ln *listener) system() error {
 	return nil
 }
 
+func (ln *listener) listenPipe() error {
+	return ErrProtocolNotSupported
+}
+
 func (s *GServer) serve(eventHandler EventHandler, listeners []*listener) error {
 	return errors.New("Unsupported platform in gnet")
 }
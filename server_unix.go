@@ -4,14 +4,16 @@
 // license that can be found in the LICENSE file.
 
 // +build linux darwin netbsd freebsd openbsd dragonfly
+// +build !gnet_net
 
 package gnet
 
 import (
-	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/panlibin/gnet/internal"
 	"github.com/panlibin/gnet/internal/netpoll"
 )
 
@@ -19,21 +21,35 @@ type server struct {
 	ln               *listener          // all the listeners
 	wg               sync.WaitGroup     // event-loop close WaitGroup
 	opts             *Options           // options with server
+	tunables         atomic.Value       // *Tunables changed at runtime
 	once             sync.Once          // make sure only signalShutdown once
 	cond             *sync.Cond         // shutdown signaler
+	signaled         bool               // shutdown has been signaled, guarded by cond.L
+	cause            error              // error which has brought the server down, guarded by cond.L
+	info             Server             // server information handed to OnInitComplete and OnShutdown
 	codec            ICodec             // codec for TCP stream
 	logger           Logger             // customized logger for logging info
 	ticktock         chan time.Duration // ticker channel
-	mainLoop         *eventloop         // main loop for accepting connections
+	acceptors        []*eventloop       // loops for accepting connections, the main reactor is the first of them
 	eventHandler     EventHandler       // user eventHandler
+	shaper           *shaper            // traffic shaper, nil if bandwidth is unlimited
+	shedder          *shedder           // load shedder, nil if load shedding is disabled
+	tapper           *tapper            // traffic tapper, nil if tapping is disabled
+	nat              *natTable          // UDP NAT sessions, nil unless Options.UDPNAT is set
+	spare            *spareFD           // spare file descriptor, nil unless Options.FDExhaustion reserves one
+	quotas           *tenantTable       // tenants of the connections, nil unless Options.Quotas is set
+	stats            serverStats        // server-wide counters
 	subLoopGroup     IEventLoopGroup    // loops for handling events
 	subLoopGroupSize int                // number of loops
+	loopsDone        chan struct{}      // closed once all the loops have exited
 }
 
 // waitForShutdown waits for a signal to shutdown
 func (svr *server) waitForShutdown() {
 	svr.cond.L.Lock()
-	svr.cond.Wait()
+	for !svr.signaled {
+		svr.cond.Wait()
+	}
 	svr.cond.L.Unlock()
 }
 
@@ -41,11 +57,172 @@ func (svr *server) waitForShutdown() {
 func (svr *server) signalShutdown() {
 	svr.once.Do(func() {
 		svr.cond.L.Lock()
+		svr.signaled = true
 		svr.cond.Signal()
 		svr.cond.L.Unlock()
 	})
 }
 
+// fail records the error which brings the server down unless it is shutting down already, see OnShutdown.
+func (svr *server) fail(err error) {
+	if err == nil || err == ErrServerShutdown {
+		return
+	}
+	svr.cond.L.Lock()
+	if !svr.signaled && svr.cause == nil {
+		svr.cause = err
+	}
+	svr.cond.L.Unlock()
+}
+
+// stopAccepting stops accepting new connections and closes the listener, which is taken off the event-loops
+// polling it first so that its fd is not mistaken for the listener when the number is reused.
+func (svr *server) stopAccepting() {
+	if svr.ln.pconn != nil {
+		return
+	}
+	loops := svr.acceptors
+	if len(loops) == 0 {
+		svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+			loops = append(loops, el)
+			return true
+		})
+	}
+	for _, el := range loops {
+		el := el
+		done := make(chan struct{})
+		if err := el.poller.Trigger(func() error {
+			el.poller.DelTimer(el.acceptPause)
+			el.acceptPause = nil
+			_ = el.poller.Delete(el.ln.fd)
+			close(done)
+			return nil
+		}); err != nil {
+			return
+		}
+		select {
+		case <-done:
+		case <-svr.loopsDone:
+			return
+		}
+	}
+	svr.closeListeners()
+}
+
+// listeners returns the listeners of the server, including the ones of the acceptors.
+func (svr *server) listeners() []*listener {
+	lns := []*listener{svr.ln}
+	for _, el := range svr.acceptors {
+		if el.ln != svr.ln {
+			lns = append(lns, el.ln)
+		}
+	}
+	return lns
+}
+
+// closeListeners closes the listeners of the server.
+func (svr *server) closeListeners() {
+	for _, ln := range svr.listeners() {
+		ln.close()
+	}
+}
+
+// drainConns fires OnDraining for every connection, it reports whether the event handler has asked to shut down.
+func (svr *server) drainConns() (shutdown bool) {
+	svr.forEachConn(func(c Conn) bool {
+		tc := c.(*conn)
+		shutdown = tc.loop.loopDrain(tc)
+		return !shutdown
+	})
+	return
+}
+
+// sampleLoads samples the load of the connections by the metric, indexed by their event-loops, see Rebalance.
+func (svr *server) sampleLoads(metric RebalanceMetric) [][]connLoad {
+	loads := make([][]connLoad, svr.subLoopGroupSize)
+	svr.forEachConn(func(c Conn) bool {
+		tc := c.(*conn)
+		loads[tc.loop.idx] = append(loads[tc.loop.idx], connLoad{c: c, load: tc.stats.sampleLoad(metric)})
+		return true
+	})
+	return loads
+}
+
+// loopAt returns the idx-th event-loop, nil if there is none.
+func (svr *server) loopAt(idx int) (el *eventloop) {
+	svr.subLoopGroup.iterate(func(i int, e *eventloop) bool {
+		if i == idx {
+			el = e
+		}
+		return el == nil
+	})
+	return
+}
+
+// prepareLoop sets up the poller of the event-loop according to the options before it starts.
+func (svr *server) prepareLoop(el *eventloop) {
+	if svr.nat != nil {
+		el.natSessions = make(map[int]*natSession)
+	}
+	el.sources = make(map[int]*Source)
+	el.poller.SetJobErrorHandler(el.jobFailed)
+	if svr.opts.LoopMetrics {
+		el.metrics = new(internal.LoopMetrics)
+		el.poller.SetMetrics(el.metrics)
+	}
+	idle := svr.opts.Idle
+	if idle.Spin < svr.opts.BusyPoll.Budget {
+		idle.Spin = svr.opts.BusyPoll.Budget
+	}
+	if idle.backsOff() || idle.MaxWait > 0 {
+		el.poller.SetIdleStrategy(netpoll.IdleStrategy{
+			Spin:          idle.Spin,
+			Yield:         idle.Yield,
+			Sleep:         idle.Sleep,
+			SleepInterval: idle.SleepInterval,
+			MaxWait:       idle.MaxWait,
+		})
+	}
+}
+
+// acceptorMetrics returns the histograms of the acceptors, the main reactor first.
+func (svr *server) acceptorMetrics() []*internal.LoopMetrics {
+	metrics := make([]*internal.LoopMetrics, 0, len(svr.acceptors))
+	for _, el := range svr.acceptors {
+		metrics = append(metrics, el.metrics)
+	}
+	return metrics
+}
+
+// queueDepths returns the numbers of urgent and ordinary asynchronous jobs pending on the event-loops.
+func (svr *server) queueDepths() (urgent, normal int) {
+	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+		u, n := el.poller.QueueDepths()
+		urgent, normal = urgent+u, normal+n
+		return true
+	})
+	for _, el := range svr.acceptors {
+		u, n := el.poller.QueueDepths()
+		urgent, normal = urgent+u, normal+n
+	}
+	return
+}
+
+// wakeups returns the numbers of wake-ups of the event-loops by the asynchronous jobs and of the jobs which have
+// not cost a wake-up.
+func (svr *server) wakeups() (wakeups, coalesced int64) {
+	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+		w, c := el.poller.Wakeups()
+		wakeups, coalesced = wakeups+w, coalesced+c
+		return true
+	})
+	for _, el := range svr.acceptors {
+		w, c := el.poller.Wakeups()
+		wakeups, coalesced = wakeups+w, coalesced+c
+	}
+	return
+}
+
 func (svr *server) startLoops() {
 	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
 		svr.wg.Add(1)
@@ -62,6 +239,9 @@ func (svr *server) closeLoops() {
 		_ = el.poller.Close()
 		return true
 	})
+	for _, el := range svr.acceptors {
+		sniffError(el.poller.Close())
+	}
 }
 
 func (svr *server) startReactors() {
@@ -87,8 +267,13 @@ func (svr *server) activateLoops(numEventLoop int) error {
 				packet:       make([]byte, 0x10000),
 				connections:  make(map[int]*conn),
 				eventHandler: svr.eventHandler,
+				ln:           svr.ln,
+			}
+			svr.prepareLoop(el)
+			// The ring of a packet listener is consumed by the first loop only.
+			if i == 0 || svr.packetRing() == nil {
+				_ = el.poller.AddRead(svr.ln.fd)
 			}
-			_ = el.poller.AddRead(svr.ln.fd)
 			svr.subLoopGroup.register(el)
 		} else {
 			return err
@@ -112,37 +297,61 @@ func (svr *server) activateReactors(numEventLoop int) error {
 				connections:  make(map[int]*conn),
 				eventHandler: svr.eventHandler,
 			}
+			svr.prepareLoop(el)
 			svr.subLoopGroup.register(el)
 		} else {
 			return err
 		}
 	}
 	svr.subLoopGroupSize = svr.subLoopGroup.len()
-	// Start sub reactors.
-	svr.startReactors()
 
-	if p, err := netpoll.OpenPoller(); err == nil {
+	// Every poller is opened before any reactor starts, so that a failure leaves nothing running.
+	numAcceptors := svr.opts.Acceptors
+	if numAcceptors == 0 {
+		numAcceptors = 1
+	}
+	for i := 0; i < numAcceptors; i++ {
+		ln := svr.ln
+		if i > 0 {
+			// The acceptors other than the main reactor listen on sockets of their own, bound to the address
+			// of the main one with SO_REUSEPORT.
+			ln = &listener{addr: svr.ln.lnaddr.String(), network: svr.ln.network}
+			if err := ln.listen(svr.opts); err != nil {
+				return err
+			}
+		}
+		p, err := netpoll.OpenPoller()
+		if err != nil {
+			ln.close()
+			return err
+		}
 		el := &eventloop{
-			idx:    -1,
+			idx:    -1 - i,
 			poller: p,
 			svr:    svr,
+			ln:     ln,
 		}
-		_ = el.poller.AddRead(svr.ln.fd)
-		svr.mainLoop = el
-		// Start main reactor.
+		svr.prepareLoop(el)
+		_ = el.poller.AddRead(ln.fd)
+		svr.acceptors = append(svr.acceptors, el)
+	}
+
+	// Start sub reactors.
+	svr.startReactors()
+	// Start acceptors.
+	for _, el := range svr.acceptors {
+		el := el
 		svr.wg.Add(1)
 		go func() {
-			svr.activateMainReactor()
+			svr.activateAcceptor(el)
 			svr.wg.Done()
 		}()
-	} else {
-		return err
 	}
 	return nil
 }
 
 func (svr *server) start(numEventLoop int) error {
-	if svr.opts.ReusePort || svr.ln.pconn != nil {
+	if svr.opts.ServeOnAccept || svr.opts.ReusePort && svr.opts.Acceptors == 0 || svr.ln.pconn != nil {
 		return svr.activateLoops(numEventLoop)
 	}
 	return svr.activateReactors(numEventLoop)
@@ -152,87 +361,161 @@ func (svr *server) stop() {
 	// Wait on a signal for shutdown
 	svr.waitForShutdown()
 
+	// An event-loop which has died closes the listener right away, so that the other event-loops take no new
+	// connections on their way down.
+	svr.cond.L.Lock()
+	failed := svr.cause != nil
+	svr.cond.L.Unlock()
+	if failed {
+		svr.closeListeners()
+	}
+
 	// Notify all loops to close by closing all listeners
 	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
-		sniffError(el.poller.Trigger(func() error {
+		sniffError(el.poller.TriggerFatal(func() error {
 			return ErrServerShutdown
 		}))
 		return true
 	})
 
-	if svr.mainLoop != nil {
-		svr.ln.close()
-		sniffError(svr.mainLoop.poller.Trigger(func() error {
+	if len(svr.acceptors) > 0 {
+		svr.closeListeners()
+	}
+	for _, el := range svr.acceptors {
+		sniffError(el.poller.TriggerFatal(func() error {
 			return ErrServerShutdown
 		}))
 	}
 
 	// Wait on all loops to complete reading events
 	svr.wg.Wait()
+	close(svr.loopsDone)
+
+	if svr.shedder != nil {
+		svr.shedder.stop()
+	}
+	if svr.spare != nil {
+		svr.spare.close()
+	}
 
 	// Close loops and all outstanding connections
 	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
 		for _, c := range el.connections {
 			sniffError(el.loopCloseConn(c, nil))
 		}
+		el.closeNATSessions()
+		el.closeSources()
 		return true
 	})
 	svr.closeLoops()
 
-	if svr.mainLoop != nil {
-		sniffError(svr.mainLoop.poller.Close())
-	}
+	svr.cond.L.Lock()
+	cause := svr.cause
+	svr.cond.L.Unlock()
+	svr.eventHandler.OnShutdown(svr.info, cause)
+}
+
+// forEachConn invokes fn for every connection on its event-loop until fn returns false.
+func (svr *server) forEachConn(fn func(c Conn) bool) {
+	var stopped bool
+	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+		done := make(chan struct{})
+		if err := el.poller.Trigger(func() error {
+			defer close(done)
+			for _, c := range el.connections {
+				if !fn(c) {
+					stopped = true
+					break
+				}
+			}
+			return nil
+		}); err != nil {
+			return false
+		}
+		select {
+		case <-done:
+			return !stopped
+		case <-svr.loopsDone:
+			return false
+		}
+	})
 }
 
 func (s *GServer) serve(eventHandler EventHandler, listener *listener, options *Options) error {
-	// Figure out the correct number of loops/goroutines to use.
-	numEventLoop := 1
-	if options.Multicore {
-		numEventLoop = runtime.NumCPU()
+	// The options have been resolved, see Options.resolve.
+	numEventLoop := options.NumEventLoop
+	if d := options.BusyPoll.Socket; d > 0 && listener.pconn != nil {
+		if err := netpoll.SetBusyPoll(listener.fd, int(d/time.Microsecond)); err != nil {
+			return err
+		}
 	}
-	if options.NumEventLoop > 0 {
-		numEventLoop = options.NumEventLoop
+	if options.TOS != 0 && listener.pconn != nil {
+		// The datagrams are sent by the listener.
+		if err := netpoll.SetTOS(listener.fd, int(options.TOS)); err != nil {
+			return err
+		}
 	}
 
 	svr := new(server)
 	svr.opts = options
+	svr.tunables.Store(&Tunables{
+		TCPKeepAlive: options.TCPKeepAlive,
+		WriteQuantum: options.WriteQuantum,
+		StreamWriter: options.StreamWriter,
+	})
 	svr.eventHandler = eventHandler
 	svr.ln = listener
 	svr.subLoopGroup = new(eventLoopGroup)
 	svr.cond = sync.NewCond(&sync.Mutex{})
 	svr.ticktock = make(chan time.Duration, 1)
-	svr.logger = func() Logger {
-		if options.Logger == nil {
-			return defaultLogger
-		}
-		return options.Logger
-	}()
-	svr.codec = func() ICodec {
-		if options.Codec == nil {
-			return new(BuiltInFrameCodec)
-		}
-		return options.Codec
-	}()
+	svr.loopsDone = make(chan struct{})
+	svr.logger = options.Logger
+	svr.codec = options.Codec
+	if options.TrafficShaping.enabled() || options.Quotas.shapes() {
+		svr.shaper = newShaper(options.TrafficShaping)
+	}
+	if options.Quotas.enabled() {
+		svr.quotas = newTenantTable(options.Quotas)
+	}
+	if options.LoadShedding.enabled() {
+		svr.shedder = newShedder(options.LoadShedding, &svr.stats)
+	}
+	if options.Tap.Sink != nil {
+		svr.tapper = newTapper(options.Tap, &svr.stats)
+	}
+	if options.UDPNAT.IdleTimeout > 0 {
+		svr.nat = newNATTable()
+	}
+	if options.FDExhaustion.Policy == FDExhaustionReserve && listener.pconn == nil {
+		svr.spare = newSpareFD()
+	}
 	s.s = svr
 
-	server := Server{
+	svr.info = Server{
 		Multicore:    options.Multicore,
 		Addr:         listener.lnaddr,
 		NumEventLoop: numEventLoop,
 		ReusePort:    options.ReusePort,
 		TCPKeepAlive: options.TCPKeepAlive,
+		opts:         options,
 	}
-	switch svr.eventHandler.OnInitComplete(server) {
+	switch svr.eventHandler.OnInitComplete(svr.info) {
 	case None:
 	case Shutdown:
+		close(svr.loopsDone)
 		return nil
 	}
 
 	if err := svr.start(numEventLoop); err != nil {
 		svr.closeLoops()
+		svr.closeListeners()
 		svr.logger.Printf("gnet server is stoping with error: %v\n", err)
+		svr.eventHandler.OnShutdown(svr.info, err)
 		return err
 	}
+	if svr.shedder != nil {
+		go svr.shedder.sampleMemory(svr.shedLoad)
+	}
 	// defer svr.stop()
 	s.sdwg.Add(1)
 	go func() {
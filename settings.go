@@ -0,0 +1,36 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "time"
+
+// ConnSettings are the settings of a connection returned by EventHandler.OnConfigure, which are applied before
+// OnOpened fires and before anything is read from the connection, so that no setter races its I/O.
+type ConnSettings struct {
+	// Codec replaces the codec of the server for the connection, nil keeps it, see Conn.Upgrade for replacing it
+	// later on.
+	Codec ICodec
+
+	// MaxInbound caps the inbound data read from the connection but not decoded into frames yet, the connection
+	// is closed with ErrInboundTooLarge once it holds more, e.g. when the peer never completes a frame.
+	// Zero leaves it unlimited.
+	MaxInbound int
+
+	// IdleTimeout closes the connection with ErrIdleTimeout once it has read nothing for it, regardless of
+	// Options.IdleReaper. Zero disables it. It only takes effect with the epoll/kqueue event-loops.
+	IdleTimeout time.Duration
+
+	// Priority is the priority of the connection for load shedding, lower priorities are dropped first.
+	// LoadShedding.Priority takes precedence if it is set.
+	Priority int
+}
+
+// connPriority returns the priority of the connection set up by ConnSettings.Priority.
+func connPriority(c Conn) int {
+	if p, ok := c.(interface{ priority() int }); ok {
+		return p.priority()
+	}
+	return 0
+}
@@ -0,0 +1,209 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/panlibin/gnet/internal"
+)
+
+// BandwidthLimit limits the throughput in bytes per second, zero means unlimited.
+// Each limit allows a burst of one second worth of bytes.
+type BandwidthLimit struct {
+	// ReadRate limits the inbound bytes per second.
+	ReadRate int
+
+	// WriteRate limits the outbound bytes per second.
+	WriteRate int
+}
+
+// TrafficShaping throttles the read scheduling and write flushing of connections, the limits are applied
+// per connection, per remote IP and per listener, a connection is held back by whichever is exhausted first.
+type TrafficShaping struct {
+	// PerConn limits each connection on its own.
+	PerConn BandwidthLimit
+
+	// PerIP limits all connections sharing the same remote IP.
+	PerIP BandwidthLimit
+
+	// PerListener limits all connections accepted by the listener.
+	PerListener BandwidthLimit
+}
+
+func (ts TrafficShaping) enabled() bool {
+	return ts.PerConn != BandwidthLimit{} || ts.PerIP != BandwidthLimit{} || ts.PerListener != BandwidthLimit{}
+}
+
+// buckets holds the read and write token buckets of a shaping scope, a nil bucket means unlimited.
+type buckets struct {
+	read, write *internal.TokenBucket
+}
+
+func newBuckets(limit BandwidthLimit) buckets {
+	var b buckets
+	if limit.ReadRate > 0 {
+		b.read = internal.NewTokenBucket(limit.ReadRate, limit.ReadRate)
+	}
+	if limit.WriteRate > 0 {
+		b.write = internal.NewTokenBucket(limit.WriteRate, limit.WriteRate)
+	}
+	return b
+}
+
+type ipBuckets struct {
+	buckets
+	refs int
+}
+
+// shaper owns the buckets shared by connections across all event-loops.
+type shaper struct {
+	sync.Mutex
+	opts     TrafficShaping
+	ips      map[string]*ipBuckets
+	listener buckets
+}
+
+func newShaper(opts TrafficShaping) *shaper {
+	return &shaper{
+		opts:     opts,
+		ips:      make(map[string]*ipBuckets),
+		listener: newBuckets(opts.PerListener),
+	}
+}
+
+// attach creates the shaping state of a new connection.
+func (s *shaper) attach(remoteAddr net.Addr) *connShaping {
+	cs := &connShaping{shaper: s, own: newBuckets(s.opts.PerConn)}
+	if s.opts.PerIP != (BandwidthLimit{}) {
+		if ip := addrIP(remoteAddr); ip != nil {
+			cs.ipKey = string(ip.To16())
+			s.Lock()
+			ib, ok := s.ips[cs.ipKey]
+			if !ok {
+				ib = &ipBuckets{buckets: newBuckets(s.opts.PerIP)}
+				s.ips[cs.ipKey] = ib
+			}
+			ib.refs++
+			cs.ip = &ib.buckets
+			s.Unlock()
+		}
+	}
+	return cs
+}
+
+// detach releases the shaping state of a closed connection.
+func (s *shaper) detach(cs *connShaping) {
+	if cs.ip == nil {
+		return
+	}
+	s.Lock()
+	if ib, ok := s.ips[cs.ipKey]; ok {
+		if ib.refs--; ib.refs <= 0 {
+			delete(s.ips, cs.ipKey)
+		}
+	}
+	s.Unlock()
+	cs.ip = nil
+}
+
+// connShaping is the loop-local shaping state of a connection.
+type connShaping struct {
+	shaper      *shaper
+	own         buckets
+	ip          *buckets
+	ipKey       string
+	tenant      *buckets
+	readPaused  bool
+	writePaused bool
+	readTimer   *internal.Timer
+	writeTimer  *internal.Timer
+}
+
+func (cs *connShaping) scopes() [4]*buckets {
+	return [4]*buckets{&cs.own, cs.ip, cs.tenant, &cs.shaper.listener}
+}
+
+// readQuota returns how many bytes (up to max) may be read right now.
+func (cs *connShaping) readQuota(max int) int {
+	for _, b := range cs.scopes() {
+		if b != nil && b.read != nil {
+			if n := b.read.Available(); n < max {
+				max = n
+			}
+		}
+	}
+	if max < 0 {
+		return 0
+	}
+	return max
+}
+
+// writeQuota returns how many bytes (up to max) may be written right now.
+func (cs *connShaping) writeQuota(max int) int {
+	for _, b := range cs.scopes() {
+		if b != nil && b.write != nil {
+			if n := b.write.Available(); n < max {
+				max = n
+			}
+		}
+	}
+	if max < 0 {
+		return 0
+	}
+	return max
+}
+
+func (cs *connShaping) consumeRead(n int) {
+	for _, b := range cs.scopes() {
+		if b != nil && b.read != nil {
+			b.read.Consume(n)
+		}
+	}
+}
+
+func (cs *connShaping) consumeWrite(n int) {
+	for _, b := range cs.scopes() {
+		if b != nil && b.write != nil {
+			b.write.Consume(n)
+		}
+	}
+}
+
+// readDelay returns how long to wait until reading is allowed again.
+func (cs *connShaping) readDelay() (d time.Duration) {
+	for _, b := range cs.scopes() {
+		if b != nil && b.read != nil {
+			if bd := b.read.Delay(); bd > d {
+				d = bd
+			}
+		}
+	}
+	return
+}
+
+// writeDelay returns how long to wait until writing is allowed again.
+func (cs *connShaping) writeDelay() (d time.Duration) {
+	for _, b := range cs.scopes() {
+		if b != nil && b.write != nil {
+			if bd := b.write.Delay(); bd > d {
+				d = bd
+			}
+		}
+	}
+	return
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+	return nil
+}
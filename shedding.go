@@ -0,0 +1,169 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"net"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ShedReason tells which threshold has triggered load shedding.
+type ShedReason int
+
+const (
+	// ShedQueueDepth indicates that the event-loop has too many pending asynchronous jobs.
+	ShedQueueDepth ShedReason = iota
+
+	// ShedAcceptBacklog indicates that too many connections are waiting in the accept queue.
+	ShedAcceptBacklog
+
+	// ShedMemory indicates that the heap in use exceeds the memory threshold.
+	ShedMemory
+)
+
+func (r ShedReason) String() string {
+	switch r {
+	case ShedQueueDepth:
+		return "queue-depth"
+	case ShedAcceptBacklog:
+		return "accept-backlog"
+	case ShedMemory:
+		return "memory"
+	default:
+		return "unknown"
+	}
+}
+
+// LoadShedding makes the server reject new connections and optionally drop established ones
+// when it is overloaded, a zero threshold disables the corresponding check.
+type LoadShedding struct {
+	// MaxQueueDepth is the number of pending asynchronous jobs of an event-loop above which it is overloaded.
+	MaxQueueDepth int
+
+	// MaxAcceptBacklog is the length of the listener accept queue above which the server is overloaded,
+	// it is only available on Linux.
+	MaxAcceptBacklog int
+
+	// MaxMemory is the number of heap bytes in use above which the server is overloaded.
+	MaxMemory uint64
+
+	// MemoryCheckInterval is the interval of sampling the memory usage, defaults to one second.
+	MemoryCheckInterval time.Duration
+
+	// RejectPayload is written to rejected connections before closing them,
+	// rejected connections are reset (RST) when it is empty.
+	RejectPayload []byte
+
+	// DropBatch is the number of established connections with the lowest priority that
+	// an overloaded event-loop drops each time load is shed, zero disables dropping.
+	DropBatch int
+
+	// Priority returns the priority of a connection, lower priorities are dropped first.
+	// The priorities are set up by ConnSettings.Priority if it is not set.
+	Priority func(c Conn) int
+
+	// OnShed is invoked after a connection has been rejected (dropped is false) or dropped,
+	// it may be invoked from multiple goroutines concurrently.
+	OnShed func(reason ShedReason, remoteAddr net.Addr, dropped bool)
+}
+
+func (ls LoadShedding) enabled() bool {
+	return ls.MaxQueueDepth > 0 || ls.MaxAcceptBacklog > 0 || ls.MaxMemory > 0
+}
+
+// shedder decides when the server is overloaded.
+type shedder struct {
+	opts       LoadShedding
+	stats      *serverStats
+	overMemory int32 // set by the memory sampler
+	done       chan struct{}
+}
+
+func newShedder(opts LoadShedding, stats *serverStats) *shedder {
+	if opts.MemoryCheckInterval <= 0 {
+		opts.MemoryCheckInterval = time.Second
+	}
+	return &shedder{opts: opts, stats: stats, done: make(chan struct{})}
+}
+
+// overloaded checks the thresholds with the queue depth of the target event-loop and
+// the accept backlog which is only queried when it is needed.
+func (sd *shedder) overloaded(queueDepth int, backlog func() int) (ShedReason, bool) {
+	if atomic.LoadInt32(&sd.overMemory) == 1 {
+		return ShedMemory, true
+	}
+	if sd.opts.MaxQueueDepth > 0 && queueDepth > sd.opts.MaxQueueDepth {
+		return ShedQueueDepth, true
+	}
+	if sd.opts.MaxAcceptBacklog > 0 && backlog != nil && backlog() > sd.opts.MaxAcceptBacklog {
+		return ShedAcceptBacklog, true
+	}
+	return 0, false
+}
+
+// sampleMemory periodically samples the heap in use and invokes onOverload while it exceeds MaxMemory.
+func (sd *shedder) sampleMemory(onOverload func()) {
+	if sd.opts.MaxMemory == 0 {
+		return
+	}
+	var ms runtime.MemStats
+	ticker := time.NewTicker(sd.opts.MemoryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sd.done:
+			return
+		case <-ticker.C:
+			runtime.ReadMemStats(&ms)
+			if ms.HeapInuse > sd.opts.MaxMemory {
+				atomic.StoreInt32(&sd.overMemory, 1)
+				onOverload()
+			} else {
+				atomic.StoreInt32(&sd.overMemory, 0)
+			}
+		}
+	}
+}
+
+func (sd *shedder) stop() {
+	close(sd.done)
+}
+
+func (sd *shedder) rejected(reason ShedReason, remoteAddr net.Addr) {
+	atomic.AddInt64(&sd.stats.shedAccepts, 1)
+	if sd.opts.OnShed != nil {
+		sd.opts.OnShed(reason, remoteAddr, false)
+	}
+}
+
+func (sd *shedder) dropped(reason ShedReason, remoteAddr net.Addr) {
+	atomic.AddInt64(&sd.stats.shedDrops, 1)
+	if sd.opts.OnShed != nil {
+		sd.opts.OnShed(reason, remoteAddr, true)
+	}
+}
+
+// victims picks at most DropBatch connections with the lowest priority.
+func (sd *shedder) victims(conns []Conn) []Conn {
+	if sd.opts.DropBatch <= 0 || len(conns) == 0 {
+		return nil
+	}
+	priority := sd.opts.Priority
+	if priority == nil {
+		priority = connPriority
+	}
+	prio := make(map[Conn]int, len(conns))
+	for _, c := range conns {
+		prio[c] = priority(c)
+	}
+	sort.SliceStable(conns, func(i, j int) bool { return prio[conns[i]] < prio[conns[j]] })
+	if len(conns) > sd.opts.DropBatch {
+		conns = conns[:sd.opts.DropBatch]
+	}
+	return conns
+}
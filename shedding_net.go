@@ -0,0 +1,50 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows gnet_net
+
+package gnet
+
+import "net"
+
+// rejectConn closes a newly accepted connection which has been shed, it is reset if there is no reject payload.
+func (svr *server) rejectConn(conn net.Conn, reason ShedReason) {
+	if payload := svr.shedder.opts.RejectPayload; len(payload) > 0 {
+		_, _ = conn.Write(payload)
+	} else if tc, ok := conn.(*net.TCPConn); ok {
+		_ = tc.SetLinger(0)
+	}
+	sniffError(conn.Close())
+	svr.shedder.rejected(reason, conn.RemoteAddr())
+}
+
+// shedLoad asks every event-loop to drop its connections with the lowest priority.
+func (svr *server) shedLoad() {
+	if svr.shedder.opts.DropBatch <= 0 {
+		return
+	}
+	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+		el.ch <- func() error {
+			return el.shedConnections(ShedMemory)
+		}
+		return true
+	})
+}
+
+// shedConnections drops the connections with the lowest priority in this event-loop.
+func (el *eventloop) shedConnections(reason ShedReason) error {
+	if el.svr.shedder.opts.DropBatch <= 0 || len(el.connections) == 0 {
+		return nil
+	}
+	conns := make([]Conn, 0, len(el.connections))
+	for c := range el.connections {
+		conns = append(conns, c)
+	}
+	for _, v := range el.svr.shedder.victims(conns) {
+		c := v.(*stdConn)
+		_ = el.loopClose(c)
+		el.svr.shedder.dropped(reason, c.remoteAddr)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+// +build !gnet_net
+
+package gnet
+
+import (
+	"github.com/panlibin/gnet/internal/netpoll"
+	"golang.org/x/sys/unix"
+)
+
+// acceptBacklog returns the current length of the accept queues of the listeners.
+func (svr *server) acceptBacklog() (backlog int) {
+	for _, ln := range svr.listeners() {
+		n, _, _ := netpoll.ListenBacklog(ln.fd)
+		backlog += n
+	}
+	return
+}
+
+// rejectConn closes a newly accepted connection which has been shed, it is reset if there is no reject payload.
+func (svr *server) rejectConn(fd int, sa unix.Sockaddr, reason ShedReason) {
+	if payload := svr.shedder.opts.RejectPayload; len(payload) > 0 {
+		_, _ = unix.Write(fd, payload)
+	} else {
+		_ = unix.SetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER, &unix.Linger{Onoff: 1, Linger: 0})
+	}
+	sniffError(unix.Close(fd))
+	svr.shedder.rejected(reason, sockaddrToAddr(sa))
+}
+
+// shedLoad asks every event-loop to drop its connections with the lowest priority.
+func (svr *server) shedLoad() {
+	if svr.shedder.opts.DropBatch <= 0 {
+		return
+	}
+	svr.subLoopGroup.iterate(func(i int, el *eventloop) bool {
+		_ = el.poller.TriggerUrgent(func() error {
+			return el.shedConnections(ShedMemory)
+		})
+		return true
+	})
+}
+
+// shedConnections drops the connections with the lowest priority in this event-loop.
+func (el *eventloop) shedConnections(reason ShedReason) error {
+	if el.svr.shedder.opts.DropBatch <= 0 || len(el.connections) == 0 {
+		return nil
+	}
+	conns := make([]Conn, 0, len(el.connections))
+	for _, c := range el.connections {
+		conns = append(conns, c)
+	}
+	for _, v := range el.svr.shedder.victims(conns) {
+		c := v.(*conn)
+		remoteAddr := c.remoteAddr
+		if err := el.loopCloseConn(c, ErrServerOverloaded); err != nil {
+			return err
+		}
+		el.svr.shedder.dropped(reason, remoteAddr)
+	}
+	return nil
+}
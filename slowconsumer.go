@@ -0,0 +1,57 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "time"
+
+// minStallCheckInterval is the shortest interval at which the event-loops look for slow consumers.
+const minStallCheckInterval = 10 * time.Millisecond
+
+// SlowConsumerPolicy decides what happens to a slow consumer, see SlowConsumer.
+type SlowConsumerPolicy int
+
+const (
+	// SlowConsumerNotify fires EventHandler.OnSlowConsumer and leaves the rest to the action it returns.
+	SlowConsumerNotify SlowConsumerPolicy = iota
+
+	// SlowConsumerThrottle stops reading the connection until its outbound data has drained,
+	// and fires EventHandler.OnSlowConsumer.
+	SlowConsumerThrottle
+
+	// SlowConsumerClose closes the connection with ErrSlowConsumer.
+	SlowConsumerClose
+)
+
+func (p SlowConsumerPolicy) String() string {
+	switch p {
+	case SlowConsumerNotify:
+		return "notify"
+	case SlowConsumerThrottle:
+		return "throttle"
+	case SlowConsumerClose:
+		return "close"
+	}
+	return "unknown"
+}
+
+// SlowConsumer sets up the detection of slow consumers, the peers which leave the outbound data of their
+// connections pending without reading it, e.g. the dead-but-not-closed clients of a broadcast server.
+type SlowConsumer struct {
+	// Stall is how long the outbound data of a connection may be pending without any of it being written
+	// before the connection is a slow consumer, zero disables the detection. The event-loops look for
+	// slow consumers every quarter of it, so a stall is detected up to a quarter of Stall late.
+	Stall time.Duration
+
+	// Policy decides what happens to a slow consumer, it is applied once per stall.
+	Policy SlowConsumerPolicy
+}
+
+// checkInterval returns the interval at which the event-loops look for slow consumers.
+func (sc SlowConsumer) checkInterval() time.Duration {
+	if d := sc.Stall / 4; d > minStallCheckInterval {
+		return d
+	}
+	return minStallCheckInterval
+}
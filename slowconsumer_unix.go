@@ -0,0 +1,72 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin netbsd freebsd openbsd dragonfly
+// +build !gnet_net
+
+package gnet
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// watchStalls arms the timer looking for the slow consumers of the event-loop, if SlowConsumer.Stall is set.
+func (el *eventloop) watchStalls() {
+	sc := el.svr.opts.SlowConsumer
+	if sc.Stall <= 0 {
+		return
+	}
+	el.poller.AddTimer(sc.checkInterval(), func() error {
+		if err := el.checkStalls(time.Now()); err != nil {
+			return err
+		}
+		el.watchStalls()
+		return nil
+	})
+}
+
+// checkStalls applies the slow consumer policy to the connections whose outbound data has been pending
+// without being written for SlowConsumer.Stall. The stall of a connection starts when it is first found
+// with pending outbound data and ends whenever any of the data is written, see loopWrite.
+func (el *eventloop) checkStalls(now time.Time) error {
+	sc := el.svr.opts.SlowConsumer
+	for _, c := range el.connections {
+		if c.outboundBuffer.IsEmpty() && len(c.urgent) == 0 || c.shaping != nil && c.shaping.writePaused {
+			// Either nothing is pending or the writes are held back by the traffic shaper.
+			c.stalledAt = time.Time{}
+			continue
+		}
+		if c.stalledAt.IsZero() {
+			c.stalledAt = now
+			continue
+		}
+		stalled := now.Sub(c.stalledAt)
+		if c.slow || stalled < sc.Stall {
+			continue
+		}
+		c.slow = true
+		atomic.AddInt64(&el.svr.stats.slowConsumers, 1)
+		if err := el.loopSlowConsumer(c, stalled); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loopSlowConsumer applies the slow consumer policy to the connection.
+func (el *eventloop) loopSlowConsumer(c *conn, stalled time.Duration) error {
+	switch el.svr.opts.SlowConsumer.Policy {
+	case SlowConsumerClose:
+		return el.loopCloseConn(c, ErrSlowConsumer)
+	case SlowConsumerThrottle:
+		c.slowPaused = true
+		el.watch(c)
+	}
+	action := el.eventHandler.OnSlowConsumer(c, stalled)
+	if !c.opened {
+		return nil // detached by the event handler
+	}
+	return el.handleAction(c, action)
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+)
+
+// StartTLS upgrades an established plaintext connection to TLS on the server side, as STARTTLS of SMTP or
+// SSLRequest of PostgreSQL do. The connection is detached from the event-loop by Conn.Detach and the handshake
+// runs on the returned tls.Conn at its first read or write, after the pending outbound data and reply,
+// e.g. "220 Ready to start TLS", have been written. StartTLS must be invoked on the event-loop, typically in
+// React, and the returned tls.Conn is served by another goroutine.
+//
+// The inbound data left behind the frame which has requested the upgrade is fed to the handshake, so a client
+// which sends its ClientHello without waiting for reply is served as well and no plaintext byte is processed twice.
+func StartTLS(c Conn, reply []byte, config *tls.Config) (*tls.Conn, error) {
+	conn, err := c.Detach()
+	if err != nil {
+		return nil, err
+	}
+	return tls.Server(&startTLSConn{Conn: conn, reply: append([]byte(nil), reply...)}, config), nil
+}
+
+// startTLSConn writes the reply to the upgrade request before anything else, so that the event-loop
+// does not block on it.
+type startTLSConn struct {
+	net.Conn
+	once  sync.Once
+	reply []byte
+	err   error
+}
+
+func (sc *startTLSConn) flush() error {
+	sc.once.Do(func() {
+		if len(sc.reply) > 0 {
+			_, sc.err = sc.Conn.Write(sc.reply)
+		}
+		sc.reply = nil
+	})
+	return sc.err
+}
+
+func (sc *startTLSConn) Read(p []byte) (int, error) {
+	if err := sc.flush(); err != nil {
+		return 0, err
+	}
+	return sc.Conn.Read(p)
+}
+
+func (sc *startTLSConn) Write(p []byte) (int, error) {
+	if err := sc.flush(); err != nil {
+		return 0, err
+	}
+	return sc.Conn.Write(p)
+}
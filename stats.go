@@ -0,0 +1,148 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "sync/atomic"
+
+// Stats is a snapshot of the server-wide counters.
+type Stats struct {
+	// ShedAccepts is the number of new connections rejected by load shedding.
+	ShedAccepts int64
+
+	// ShedDrops is the number of established connections dropped by load shedding.
+	ShedDrops int64
+
+	// FirewallDenied is the number of connections and UDP packets denied by the firewall.
+	FirewallDenied int64
+
+	// TapDropped is the number of bytes not mirrored due to the rate limit of the tap.
+	TapDropped int64
+
+	// AcceptBacklog is the current length of the accept queue of the listener, which grows when new connections
+	// arrive faster than they are accepted, see Options.AcceptBatch. It is only available on Linux.
+	AcceptBacklog int
+
+	// AcceptWakeups is the number of times the listener has woken an event-loop up to accept connections, and
+	// Accepted is the number of connections accepted, so that Accepted/AcceptWakeups is the mean number of
+	// accepts per wakeup. AcceptBatchFull is the number of wakeups which have reached Options.AcceptBatch, the
+	// batch is too small for the connection storms if it grows along with AcceptWakeups.
+	// They are only counted with the poll transport.
+	AcceptWakeups, Accepted, AcceptBatchFull int64
+
+	// AcceptBacklogLimit is the maximum length of the accept queue of the listener, see Options.ListenBacklog.
+	// It is only available on Linux.
+	AcceptBacklogLimit int
+
+	// ListenOverflows and ListenDrops are the system-wide numbers of times the accept queue of a listener has
+	// overflowed and of the SYNs dropped by listeners, they are only available on Linux.
+	ListenOverflows, ListenDrops int64
+
+	// FDExhausted is the number of accepts which have failed with EMFILE or ENFILE, see Options.FDExhaustion.
+	// It is only counted with the poll transport.
+	FDExhausted int64
+
+	// SlowConsumers is the number of stalls on slow consumers, see Options.SlowConsumer.
+	SlowConsumers int64
+
+	// Rebalanced is the number of connections moved by the rebalancer, see Options.Rebalance.
+	Rebalanced int64
+
+	// NATSessions is the current number of UDP NAT sessions, see Options.UDPNAT.
+	NATSessions int64
+
+	// PartialWrites is the number of writes to the connections which the sockets have taken only in part, the
+	// rest is kept in the outbound buffers until the sockets are writable. WriteEAGAIN and WriteENOBUFS are the
+	// numbers of writes which have failed with EAGAIN, as the sockets were full, and with ENOBUFS, as the kernel
+	// was short of memory. They are only counted with the poll transport.
+	PartialWrites, WriteEAGAIN, WriteENOBUFS int64
+
+	// UrgentJobs and QueuedJobs are the current numbers of urgent and ordinary asynchronous jobs pending on the
+	// event-loops. The urgent jobs, e.g. Conn.Close and load shedding, jump ahead of the ordinary ones, e.g.
+	// AsyncWrite and Wake, so that a flood of writes doesn't hold them up. With the net transport all the jobs
+	// are ordinary.
+	UrgentJobs, QueuedJobs int
+
+	// Wakeups is the number of times the asynchronous jobs have woken the event-loops up, and WakeupsCoalesced
+	// is the number of jobs triggered while their event-loops were awake, which have run without a wake-up.
+	// They are only counted with the poll transport.
+	Wakeups, WakeupsCoalesced int64
+
+	// JobErrors is the number of asynchronous jobs which have failed, e.g. AsyncWrite or Wake running into an
+	// error, which are logged without stopping their event-loops.
+	JobErrors int64
+
+	// HandoffOverflows is the number of new connections and UDP packets which the acceptor has waited to hand
+	// over as the command channel of the event-loop was full, see Options.Handoff. It is only counted with the
+	// net transport.
+	HandoffOverflows int64
+}
+
+// serverStats holds the server-wide counters which are updated atomically.
+type serverStats struct {
+	shedAccepts    int64
+	shedDrops      int64
+	firewallDenied int64
+	tapDropped     int64
+	slowConsumers  int64
+	rebalanced     int64
+	natSessions    int64
+
+	acceptWakeups   int64
+	accepted        int64
+	acceptBatchFull int64
+	fdExhausted     int64
+
+	partialWrites int64
+	writeEAGAIN   int64
+	writeENOBUFS  int64
+
+	jobErrors        int64
+	handoffOverflows int64
+}
+
+func (ss *serverStats) snapshot() Stats {
+	return Stats{
+		ShedAccepts:    atomic.LoadInt64(&ss.shedAccepts),
+		ShedDrops:      atomic.LoadInt64(&ss.shedDrops),
+		FirewallDenied: atomic.LoadInt64(&ss.firewallDenied),
+		TapDropped:     atomic.LoadInt64(&ss.tapDropped),
+		SlowConsumers:  atomic.LoadInt64(&ss.slowConsumers),
+		Rebalanced:     atomic.LoadInt64(&ss.rebalanced),
+		NATSessions:    atomic.LoadInt64(&ss.natSessions),
+
+		AcceptWakeups:   atomic.LoadInt64(&ss.acceptWakeups),
+		Accepted:        atomic.LoadInt64(&ss.accepted),
+		AcceptBatchFull: atomic.LoadInt64(&ss.acceptBatchFull),
+		FDExhausted:     atomic.LoadInt64(&ss.fdExhausted),
+
+		PartialWrites: atomic.LoadInt64(&ss.partialWrites),
+		WriteEAGAIN:   atomic.LoadInt64(&ss.writeEAGAIN),
+		WriteENOBUFS:  atomic.LoadInt64(&ss.writeENOBUFS),
+
+		JobErrors:        atomic.LoadInt64(&ss.jobErrors),
+		HandoffOverflows: atomic.LoadInt64(&ss.handoffOverflows),
+	}
+}
+
+// recordAccepts records a wakeup of the listener which has accepted n connections out of a batch.
+func (ss *serverStats) recordAccepts(n, batch int) {
+	atomic.AddInt64(&ss.acceptWakeups, 1)
+	atomic.AddInt64(&ss.accepted, int64(n))
+	if n == batch {
+		atomic.AddInt64(&ss.acceptBatchFull, 1)
+	}
+}
+
+// Stats returns a snapshot of the server-wide counters.
+func (s *GServer) Stats() Stats {
+	if s.s == nil {
+		return Stats{}
+	}
+	stats := s.s.stats.snapshot()
+	s.s.ln.stats(&stats)
+	stats.UrgentJobs, stats.QueuedJobs = s.s.queueDepths()
+	stats.Wakeups, stats.WakeupsCoalesced = s.s.wakeups()
+	return stats
+}
@@ -0,0 +1,273 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	stunHeaderSize  = 20
+	stunMagicCookie = 0x2112A442
+)
+
+// STUNMethod is the method of a STUN message.
+type STUNMethod uint16
+
+// STUNBinding is the Binding method of STUN, which asks for the reflexive transport address of the client.
+const STUNBinding STUNMethod = 0x001
+
+// STUNClass is the class of a STUN message.
+type STUNClass uint16
+
+const (
+	// STUNRequest is the class of requests.
+	STUNRequest STUNClass = iota
+
+	// STUNIndication is the class of indications, which have no response.
+	STUNIndication
+
+	// STUNSuccess is the class of success responses.
+	STUNSuccess
+
+	// STUNError is the class of error responses.
+	STUNError
+)
+
+// STUN attribute types.
+const (
+	STUNAttrMappedAddress    uint16 = 0x0001
+	STUNAttrUsername         uint16 = 0x0006
+	STUNAttrMessageIntegrity uint16 = 0x0008
+	STUNAttrErrorCode        uint16 = 0x0009
+	STUNAttrXORMappedAddress uint16 = 0x0020
+	STUNAttrSoftware         uint16 = 0x8022
+	STUNAttrFingerprint      uint16 = 0x8028
+)
+
+// STUNAttr is an attribute of a STUN message.
+type STUNAttr struct {
+	Type  uint16
+	Value []byte
+}
+
+// STUNMessage is a STUN message of RFC 5389, see ParseSTUN and Marshal.
+type STUNMessage struct {
+	Method        STUNMethod
+	Class         STUNClass
+	TransactionID [12]byte
+	Attrs         []STUNAttr
+}
+
+// NewSTUNRequest returns a request of the method with a random transaction ID.
+func NewSTUNRequest(method STUNMethod) *STUNMessage {
+	m := &STUNMessage{Method: method, Class: STUNRequest}
+	_, _ = crand.Read(m.TransactionID[:])
+	return m
+}
+
+// IsSTUN reports whether b looks like a STUN message, e.g. to tell STUN from the other protocols multiplexed
+// on the same port such as DTLS and RTP.
+func IsSTUN(b []byte) bool {
+	return len(b) >= stunHeaderSize && b[0]&0xc0 == 0 && binary.BigEndian.Uint32(b[4:8]) == stunMagicCookie
+}
+
+// ParseSTUN parses a STUN message, the values of the attributes are sliced from b without copying.
+// It fails with ErrMalformedSTUN if b is not a whole STUN message.
+func ParseSTUN(b []byte) (*STUNMessage, error) {
+	if !IsSTUN(b) {
+		return nil, ErrMalformedSTUN
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length%4 != 0 || len(b) != stunHeaderSize+length {
+		return nil, ErrMalformedSTUN
+	}
+	t := binary.BigEndian.Uint16(b[0:2])
+	m := &STUNMessage{
+		Method: STUNMethod(t&0x000f | t>>1&0x0070 | t>>2&0x0f80),
+		Class:  STUNClass(t>>4&1 | t>>7&2),
+	}
+	copy(m.TransactionID[:], b[8:stunHeaderSize])
+	for attrs := b[stunHeaderSize:]; len(attrs) > 0; {
+		if len(attrs) < 4 {
+			return nil, ErrMalformedSTUN
+		}
+		n := int(binary.BigEndian.Uint16(attrs[2:4]))
+		padded := 4 + (n+3)&^3
+		if len(attrs) < padded {
+			return nil, ErrMalformedSTUN
+		}
+		m.Attrs = append(m.Attrs, STUNAttr{Type: binary.BigEndian.Uint16(attrs[0:2]), Value: attrs[4 : 4+n]})
+		attrs = attrs[padded:]
+	}
+	return m, nil
+}
+
+// Marshal encodes the message, the attributes are padded to the 4-byte boundaries.
+func (m *STUNMessage) Marshal() []byte {
+	length := 0
+	for _, attr := range m.Attrs {
+		length += 4 + (len(attr.Value)+3)&^3
+	}
+	b := make([]byte, stunHeaderSize+length)
+	method, class := uint16(m.Method), uint16(m.Class)
+	binary.BigEndian.PutUint16(b[0:2], method&0x000f|(method&0x0070)<<1|(method&0x0f80)<<2|(class&1)<<4|(class&2)<<7)
+	binary.BigEndian.PutUint16(b[2:4], uint16(length))
+	binary.BigEndian.PutUint32(b[4:8], stunMagicCookie)
+	copy(b[8:stunHeaderSize], m.TransactionID[:])
+	off := stunHeaderSize
+	for _, attr := range m.Attrs {
+		binary.BigEndian.PutUint16(b[off:], attr.Type)
+		binary.BigEndian.PutUint16(b[off+2:], uint16(len(attr.Value)))
+		copy(b[off+4:], attr.Value)
+		off += 4 + (len(attr.Value)+3)&^3
+	}
+	return b
+}
+
+// Response returns a response of the class to the request, with the same method and transaction ID.
+func (m *STUNMessage) Response(class STUNClass) *STUNMessage {
+	return &STUNMessage{Method: m.Method, Class: class, TransactionID: m.TransactionID}
+}
+
+// Add appends an attribute to the message.
+func (m *STUNMessage) Add(t uint16, value []byte) {
+	m.Attrs = append(m.Attrs, STUNAttr{Type: t, Value: value})
+}
+
+// Get returns the value of the first attribute of the type.
+func (m *STUNMessage) Get(t uint16) ([]byte, bool) {
+	for _, attr := range m.Attrs {
+		if attr.Type == t {
+			return attr.Value, true
+		}
+	}
+	return nil, false
+}
+
+// AddXORMappedAddress appends the XOR-MAPPED-ADDRESS attribute of the address, typically the remote address
+// of the UDP datagram of a Binding request, to the response.
+func (m *STUNMessage) AddXORMappedAddress(addr *net.UDPAddr) {
+	ip, family := addr.IP.To4(), byte(1)
+	if ip == nil {
+		ip, family = addr.IP.To16(), 2
+	}
+	value := make([]byte, 4+len(ip))
+	value[1] = family
+	binary.BigEndian.PutUint16(value[2:4], uint16(addr.Port)^stunMagicCookie>>16)
+	m.xorAddress(value[4:], ip)
+	m.Add(STUNAttrXORMappedAddress, value)
+}
+
+// XORMappedAddress returns the address of the XOR-MAPPED-ADDRESS attribute, it fails with ErrSTUNAttrNotFound
+// if there is none and with ErrMalformedSTUN if it is malformed.
+func (m *STUNMessage) XORMappedAddress() (*net.UDPAddr, error) {
+	value, ok := m.Get(STUNAttrXORMappedAddress)
+	if !ok {
+		return nil, ErrSTUNAttrNotFound
+	}
+	if len(value) != 4+net.IPv4len && len(value) != 4+net.IPv6len {
+		return nil, ErrMalformedSTUN
+	}
+	ip := make(net.IP, len(value)-4)
+	m.xorAddress(ip, value[4:])
+	return &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(value[2:4]) ^ stunMagicCookie>>16)}, nil
+}
+
+// xorAddress XORs the address with the magic cookie, followed by the transaction ID for IPv6, into dst.
+func (m *STUNMessage) xorAddress(dst, src []byte) {
+	var key [16]byte
+	binary.BigEndian.PutUint32(key[0:4], stunMagicCookie)
+	copy(key[4:], m.TransactionID[:])
+	for i := range src {
+		dst[i] = src[i] ^ key[i]
+	}
+}
+
+// STUNCodec frames the STUN messages over TCP by the lengths in their headers, the decoded frames are whole
+// messages to be parsed by ParseSTUN, and the frames to be encoded are expected to be marshaled already.
+// The decoder fails with ErrCorruptFrame on data other than STUN, which closes the connection.
+type STUNCodec struct{}
+
+// Encode ...
+func (cc *STUNCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	return buf, nil
+}
+
+// Decode ...
+func (cc *STUNCodec) Decode(c Conn) ([]byte, error) {
+	buf := c.Read()
+	if len(buf) < stunHeaderSize {
+		return nil, ErrUnexpectedEOF
+	}
+	if !IsSTUN(buf) {
+		return nil, ErrCorruptFrame
+	}
+	n := stunHeaderSize + int(binary.BigEndian.Uint16(buf[2:4]))
+	if len(buf) < n {
+		return nil, ErrUnexpectedEOF
+	}
+	c.ShiftN(n)
+	return buf[:n], nil
+}
+
+// STUNTransactions matches the responses to the pending requests by their transaction IDs, e.g. for a
+// connectivity checker sending the requests from the event-loop. It is safe for concurrent use.
+type STUNTransactions struct {
+	mu      sync.Mutex
+	pending map[[12]byte]stunPending
+}
+
+type stunPending struct {
+	req      *STUNMessage
+	deadline time.Time
+}
+
+// Start registers the request as pending until it is matched by Match or expired by Expire after the timeout.
+func (t *STUNTransactions) Start(req *STUNMessage, timeout time.Duration) {
+	t.mu.Lock()
+	if t.pending == nil {
+		t.pending = make(map[[12]byte]stunPending)
+	}
+	t.pending[req.TransactionID] = stunPending{req, time.Now().Add(timeout)}
+	t.mu.Unlock()
+}
+
+// Match returns the pending request of the response and removes it, it reports false for an unknown or
+// expired transaction, or a response whose method differs from the request.
+func (t *STUNTransactions) Match(resp *STUNMessage) (*STUNMessage, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.pending[resp.TransactionID]
+	if !ok || p.req.Method != resp.Method || time.Now().After(p.deadline) {
+		return nil, false
+	}
+	delete(t.pending, resp.TransactionID)
+	return p.req, true
+}
+
+// Expire removes and returns the pending requests whose timeouts have elapsed by now, e.g. to retransmit them.
+func (t *STUNTransactions) Expire(now time.Time) (expired []*STUNMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, p := range t.pending {
+		if now.After(p.deadline) {
+			expired = append(expired, p.req)
+			delete(t.pending, id)
+		}
+	}
+	return
+}
+
+// Len returns the number of the pending requests.
+func (t *STUNTransactions) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
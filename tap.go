@@ -0,0 +1,179 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panlibin/gnet/internal"
+)
+
+// TapDirection is the direction of the bytes mirrored by a tap.
+type TapDirection uint8
+
+const (
+	// TapInbound indicates the bytes are read from the peer.
+	TapInbound TapDirection = iota
+
+	// TapOutbound indicates the bytes are written to the peer.
+	TapOutbound
+)
+
+func (d TapDirection) String() string {
+	if d == TapInbound {
+		return "inbound"
+	}
+	return "outbound"
+}
+
+// TapRecord is a chunk of bytes mirrored from a tapped TCP connection.
+type TapRecord struct {
+	// Time is the time when the bytes are mirrored.
+	Time time.Time
+
+	// Direction is the direction of the bytes.
+	Direction TapDirection
+
+	// LocalAddr and RemoteAddr are the addresses of the connection.
+	LocalAddr, RemoteAddr net.Addr
+
+	// Offset is the number of bytes preceding Data in the same direction, including the bytes dropped
+	// by the rate limit, PeerOffset is the same number in the opposite direction.
+	Offset, PeerOffset uint64
+
+	// Data is the mirrored bytes, it is only valid during the call of WriteTap.
+	Data []byte
+}
+
+// TapSink receives the bytes mirrored by a tap, WriteTap is invoked on the event-loop goroutines
+// so it must be goroutine-safe and fast.
+type TapSink interface {
+	WriteTap(rec *TapRecord)
+}
+
+// TapSinkFunc is an adapter to allow the use of an ordinary function as a TapSink.
+type TapSinkFunc func(rec *TapRecord)
+
+// WriteTap calls f(rec).
+func (f TapSinkFunc) WriteTap(rec *TapRecord) {
+	f(rec)
+}
+
+// Tap mirrors the inbound and outbound bytes of TCP connections to a sink for debugging protocol issues,
+// the outbound bytes are mirrored in the order they are written by the event handler.
+type Tap struct {
+	// Sink receives the mirrored bytes, tapping is disabled if it is nil.
+	Sink TapSink
+
+	// Filter selects the connections to be tapped when they are opened, nil means all connections.
+	Filter func(c Conn) bool
+
+	// SampleRate is the fraction of the selected connections which are tapped, zero means all of them.
+	SampleRate float64
+
+	// RateLimit is the maximum number of bytes per second mirrored across all connections, zero means
+	// unlimited, the excess bytes are dropped and counted in Stats.TapDropped.
+	RateLimit int
+}
+
+// tapper decides which connections are tapped and enforces the rate limit.
+type tapper struct {
+	opts   Tap
+	bucket *internal.TokenBucket
+	stats  *serverStats
+}
+
+func newTapper(opts Tap, stats *serverStats) *tapper {
+	t := &tapper{opts: opts, stats: stats}
+	if opts.RateLimit > 0 {
+		t.bucket = internal.NewTokenBucket(opts.RateLimit, opts.RateLimit)
+	}
+	return t
+}
+
+// attach returns the tap of a newly opened connection, nil if the connection is not tapped.
+func (t *tapper) attach(c Conn) *connTap {
+	if t.opts.Filter != nil && !t.opts.Filter(c) {
+		return nil
+	}
+	if t.opts.SampleRate > 0 && t.opts.SampleRate < 1 && rand.Float64() >= t.opts.SampleRate {
+		return nil
+	}
+	return &connTap{tapper: t, localAddr: c.LocalAddr(), remoteAddr: c.RemoteAddr()}
+}
+
+// connTap is the tapping state of a connection.
+type connTap struct {
+	tapper                *tapper
+	localAddr, remoteAddr net.Addr
+	offsets               [2]uint64
+}
+
+func (ct *connTap) mirror(dir TapDirection, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	offset := ct.offsets[dir]
+	ct.offsets[dir] += uint64(len(data))
+	if b := ct.tapper.bucket; b != nil {
+		if b.Available() < len(data) {
+			atomic.AddInt64(&ct.tapper.stats.tapDropped, int64(len(data)))
+			return
+		}
+		b.Consume(len(data))
+	}
+	ct.tapper.opts.Sink.WriteTap(&TapRecord{
+		Time:       time.Now(),
+		Direction:  dir,
+		LocalAddr:  ct.localAddr,
+		RemoteAddr: ct.remoteAddr,
+		Offset:     offset,
+		PeerOffset: ct.offsets[1-dir],
+		Data:       data,
+	})
+}
+
+// TapRing is a TapSink keeping the latest records in memory.
+type TapRing struct {
+	mu      sync.Mutex
+	records []TapRecord
+	next    int
+	full    bool
+}
+
+// NewTapRing instantiates a TapRing which keeps at most size records.
+func NewTapRing(size int) *TapRing {
+	if size < 1 {
+		size = 1
+	}
+	return &TapRing{records: make([]TapRecord, size)}
+}
+
+// WriteTap stores a copy of the record, evicting the oldest one if the ring is full.
+func (r *TapRing) WriteTap(rec *TapRecord) {
+	cp := *rec
+	cp.Data = append([]byte(nil), rec.Data...)
+	r.mu.Lock()
+	r.records[r.next] = cp
+	if r.next++; r.next == len(r.records) {
+		r.next = 0
+		r.full = true
+	}
+	r.mu.Unlock()
+}
+
+// Records returns the stored records from the oldest to the latest.
+func (r *TapRing) Records() []TapRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]TapRecord(nil), r.records[:r.next]...)
+	}
+	return append(append([]TapRecord(nil), r.records[r.next:]...), r.records[:r.next]...)
+}
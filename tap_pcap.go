@@ -0,0 +1,144 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	pcapLinkTypeRaw = 101
+	pcapSnapLen     = 65535
+	pcapMaxSegment  = 65000
+)
+
+// PcapTapSink is a TapSink writing the records in the pcap format, each record is wrapped in synthesized
+// IP and TCP headers whose sequence numbers are derived from the offsets, so that the capture can be
+// opened in Wireshark and the dropped bytes show up as lost segments.
+type PcapTapSink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+	err error
+}
+
+// NewPcapTapSink instantiates a PcapTapSink and writes the pcap file header to w.
+func NewPcapTapSink(w io.Writer) (*PcapTapSink, error) {
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], pcapLinkTypeRaw)
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return &PcapTapSink{w: w}, nil
+}
+
+// WriteTap writes the record as one or more TCP segments.
+func (p *PcapTapSink) WriteTap(rec *TapRecord) {
+	src, dst := tcpAddrOf(rec.LocalAddr), tcpAddrOf(rec.RemoteAddr)
+	if rec.Direction == TapInbound {
+		src, dst = dst, src
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return
+	}
+	data, seq := rec.Data, rec.Offset
+	for len(data) > 0 {
+		n := len(data)
+		if n > pcapMaxSegment {
+			n = pcapMaxSegment
+		}
+		p.buf = appendPcapPacket(p.buf[:0], rec, src, dst, uint32(seq+1), uint32(rec.PeerOffset+1), data[:n])
+		if _, p.err = p.w.Write(p.buf); p.err != nil {
+			return
+		}
+		data, seq = data[n:], seq+uint64(n)
+	}
+}
+
+// Err returns the first error encountered while writing records.
+func (p *PcapTapSink) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func tcpAddrOf(addr net.Addr) *net.TCPAddr {
+	if a, ok := addr.(*net.TCPAddr); ok && a.IP != nil {
+		return a
+	}
+	return &net.TCPAddr{IP: net.IPv4zero}
+}
+
+func appendPcapPacket(b []byte, rec *TapRecord, src, dst *net.TCPAddr, seq, ack uint32, data []byte) []byte {
+	src4, dst4 := src.IP.To4(), dst.IP.To4()
+	ipLen := 40
+	if src4 == nil || dst4 == nil {
+		ipLen = 60
+	}
+	pktLen := ipLen + 20 + len(data)
+
+	// pcap record header
+	b = appendUint32LE(b, uint32(rec.Time.Unix()))
+	b = appendUint32LE(b, uint32(rec.Time.Nanosecond()/1000))
+	b = appendUint32LE(b, uint32(pktLen))
+	b = appendUint32LE(b, uint32(pktLen))
+
+	// IP header
+	if ipLen == 40 {
+		start := len(b)
+		b = append(b, 0x45, 0)
+		b = appendUint16BE(b, uint16(pktLen))
+		b = append(b, 0, 0, 0x40, 0, 64, 6, 0, 0)
+		b = append(b, src4...)
+		b = append(b, dst4...)
+		binary.BigEndian.PutUint16(b[start+10:], ipv4Checksum(b[start:]))
+	} else {
+		b = append(b, 0x60, 0, 0, 0)
+		b = appendUint16BE(b, uint16(20+len(data)))
+		b = append(b, 6, 64)
+		b = append(b, src.IP.To16()...)
+		b = append(b, dst.IP.To16()...)
+	}
+
+	// TCP header with PSH and ACK flags, the checksum is left zero.
+	b = appendUint16BE(b, uint16(src.Port))
+	b = appendUint16BE(b, uint16(dst.Port))
+	b = appendUint32BE(b, seq)
+	b = appendUint32BE(b, ack)
+	b = append(b, 5<<4, 0x18, 0xff, 0xff, 0, 0, 0, 0)
+	return append(b, data...)
+}
+
+func ipv4Checksum(hdr []byte) uint16 {
+	var sum uint32
+	for i := 0; i < 20; i += 2 {
+		sum += uint32(hdr[i])<<8 | uint32(hdr[i+1])
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+func appendUint16BE(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32BE(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint32LE(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
@@ -0,0 +1,140 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "bytes"
+
+// Telnet command bytes.
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetIAC  = 255
+)
+
+// TextLineConfig sets up a TextLineCodec.
+type TextLineConfig struct {
+	// CRLF terminates the encoded lines with CRLF instead of LF, as SMTP, POP3, IMAP and IRC expect.
+	CRLF bool
+
+	// MaxLineLength is the maximum length of a line without its terminator, the decoder fails with
+	// ErrFrameTooLarge beyond it, which closes the connection, zero means no limit.
+	MaxLineLength int
+
+	// Telnet strips the Telnet IAC sequences, e.g. the option negotiations sent by telnet clients, from the
+	// decoded lines and doubles the IAC bytes of the encoded lines.
+	Telnet bool
+}
+
+// TextLineCodec encodes/decodes text lines into/from TCP stream, the decoder accepts both CRLF and LF.
+// The decoded lines without IAC sequences are sliced from the inbound buffer without copying.
+type TextLineCodec struct {
+	config TextLineConfig
+}
+
+// NewTextLineCodec instantiates and returns a text line codec.
+func NewTextLineCodec(config TextLineConfig) *TextLineCodec {
+	return &TextLineCodec{config}
+}
+
+// Encode ...
+func (cc *TextLineCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	if cc.config.Telnet && bytes.IndexByte(buf, telnetIAC) >= 0 {
+		out := make([]byte, 0, len(buf)+8)
+		for _, b := range buf {
+			if b == telnetIAC {
+				out = append(out, telnetIAC)
+			}
+			out = append(out, b)
+		}
+		buf = out
+	}
+	if cc.config.CRLF {
+		return append(buf, '\r', '\n'), nil
+	}
+	return append(buf, '\n'), nil
+}
+
+// Decode ...
+func (cc *TextLineCodec) Decode(c Conn) ([]byte, error) {
+	buf := c.Read()
+	max := cc.config.MaxLineLength
+	var iac bool
+scan:
+	for i := 0; i < len(buf); i++ {
+		switch {
+		case buf[i] == '\n':
+			line := buf[:i]
+			if iac {
+				line = stripIAC(line)
+			}
+			if n := len(line); n > 0 && line[n-1] == '\r' {
+				line = line[:n-1]
+			}
+			if max > 0 && len(line) > max {
+				return nil, ErrFrameTooLarge
+			}
+			c.ShiftN(i + 1)
+			return line, nil
+		case buf[i] == telnetIAC && cc.config.Telnet:
+			n := iacLength(buf[i:])
+			if n == 0 {
+				break scan
+			}
+			iac = true
+			i += n - 1
+		}
+	}
+	if max > 0 && len(buf) > max+2 {
+		return nil, ErrFrameTooLarge
+	}
+	return nil, ErrCRLFNotFound
+}
+
+// iacLength returns the length of the IAC sequence at the beginning of buf, zero if it is incomplete.
+func iacLength(buf []byte) int {
+	if len(buf) < 2 {
+		return 0
+	}
+	switch cmd := buf[1]; {
+	case cmd == telnetSB:
+		// The subnegotiation ends with IAC SE, IAC IAC stands for a data byte within it.
+		for i := 2; i+1 < len(buf); i++ {
+			if buf[i] != telnetIAC {
+				continue
+			}
+			if buf[i+1] == telnetSE {
+				return i + 2
+			}
+			i++
+		}
+		return 0
+	case cmd >= telnetWILL && cmd < telnetIAC:
+		if len(buf) < 3 {
+			return 0
+		}
+		return 3
+	default:
+		return 2
+	}
+}
+
+// stripIAC returns a copy of the line without the IAC sequences, IAC IAC stands for a 0xFF data byte.
+func stripIAC(line []byte) []byte {
+	out := make([]byte, 0, len(line))
+	for i := 0; i < len(line); i++ {
+		if line[i] != telnetIAC {
+			out = append(out, line[i])
+			continue
+		}
+		if i+1 < len(line) && line[i+1] == telnetIAC {
+			out = append(out, telnetIAC)
+			i++
+			continue
+		}
+		i += iacLength(line[i:]) - 1
+	}
+	return out
+}
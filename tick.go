@@ -0,0 +1,40 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"time"
+
+	"github.com/panlibin/gnet/internal"
+)
+
+// ConnTickFunc is a periodic callback of a connection registered by Conn.Tick,
+// the out and action return values are handled as the ones of React.
+type ConnTickFunc func(c Conn) (out []byte, action Action)
+
+// connTicker is a periodic callback tied to the lifetime of a connection, it is owned by the event-loop.
+type connTicker struct {
+	interval time.Duration
+	fn       ConnTickFunc
+	timer    *internal.Timer // pending timer on the epoll/kqueue event-loops
+	stopped  bool
+}
+
+func newConnTicker(interval time.Duration, fn ConnTickFunc) *connTicker {
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	return &connTicker{interval: interval, fn: fn}
+}
+
+// removeTicker removes t from the tickers.
+func removeTicker(tickers []*connTicker, t *connTicker) []*connTicker {
+	for i, tt := range tickers {
+		if tt == t {
+			return append(tickers[:i], tickers[i+1:]...)
+		}
+	}
+	return tickers
+}
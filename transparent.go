@@ -0,0 +1,14 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import "net"
+
+// OriginalDst returns the address a client connected or sent a datagram to before it was intercepted.
+//
+// Deprecated: use Conn.OriginalDst.
+func OriginalDst(c Conn) (net.Addr, error) {
+	return c.OriginalDst()
+}
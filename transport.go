@@ -0,0 +1,16 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+// The transports the event-loops can be built upon, see Options.Transport.
+const (
+	// TransportPoll drives the sockets by epoll or kqueue on the event-loops, it is the default on unix.
+	TransportPoll = "poll"
+
+	// TransportNet drives the connections by the portable net package with a goroutine reading each of them,
+	// it is the one on Windows and is selected on any other platform by the gnet_net build tag, e.g. to tell
+	// a suspected poller bug apart by A/B comparison. The features which rely on the poller are not supported.
+	TransportNet = "net"
+)
@@ -0,0 +1,10 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !windows,!gnet_net
+
+package gnet
+
+// builtinTransport is the transport the event-loops are built upon, see Options.Transport.
+const builtinTransport = TransportPoll
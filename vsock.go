@@ -0,0 +1,91 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/panlibin/gnet/internal/netpoll"
+)
+
+// The well-known context IDs of vsock.
+const (
+	// VsockCIDHypervisor is the CID of the hypervisor.
+	VsockCIDHypervisor uint32 = 0
+
+	// VsockCIDLocal is the CID of the local loopback, it requires the vsock_loopback module.
+	VsockCIDLocal uint32 = 1
+
+	// VsockCIDHost is the CID of the host, as seen from the guests.
+	VsockCIDHost uint32 = 2
+
+	// VsockCIDAny binds a listener to any CID, it is the CID of `vsock://:port`.
+	VsockCIDAny uint32 = 0xffffffff
+)
+
+// VsockAddr is the address of a vsock (AF_VSOCK) endpoint, i.e. the context ID of a VM or the host and a port,
+// the addresses of the connections served on `vsock://cid:port` are VsockAddrs.
+type VsockAddr struct {
+	CID  uint32
+	Port uint32
+}
+
+// Network returns "vsock".
+func (a *VsockAddr) Network() string { return "vsock" }
+
+// String returns the address in the form of "cid:port".
+func (a *VsockAddr) String() string {
+	return strconv.FormatUint(uint64(a.CID), 10) + ":" + strconv.FormatUint(uint64(a.Port), 10)
+}
+
+// parseVsockAddr parses an address in the form of "cid:port", an empty CID is VsockCIDAny.
+func parseVsockAddr(addr string) (*VsockAddr, error) {
+	i := strings.LastIndexByte(addr, ':')
+	if i < 0 {
+		return nil, &net.AddrError{Err: "missing port in vsock address", Addr: addr}
+	}
+	a := &VsockAddr{CID: VsockCIDAny}
+	if cid := addr[:i]; cid != "" {
+		n, err := strconv.ParseUint(cid, 10, 32)
+		if err != nil {
+			return nil, &net.AddrError{Err: "invalid CID in vsock address", Addr: addr}
+		}
+		a.CID = uint32(n)
+	}
+	port, err := strconv.ParseUint(addr[i+1:], 10, 32)
+	if err != nil {
+		return nil, &net.AddrError{Err: "invalid port in vsock address", Addr: addr}
+	}
+	a.Port = uint32(port)
+	return a, nil
+}
+
+// DialVsock connects to the vsock port of the VM or host with the given context ID, e.g. to a server on
+// `vsock://:port` of the host from a guest by DialVsock(VsockCIDHost, port), Linux only.
+func DialVsock(cid, port uint32) (net.Conn, error) {
+	if runtime.GOOS != "linux" {
+		return nil, ErrProtocolNotSupported
+	}
+	raddr := &VsockAddr{CID: cid, Port: port}
+	fd, localCID, localPort, err := netpoll.DialVsock(cid, port)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: "vsock", Addr: raddr, Err: err}
+	}
+	return &vsockConn{os.NewFile(uintptr(fd), "vsock:"+raddr.String()), &VsockAddr{localCID, localPort}, raddr}, nil
+}
+
+// vsockConn is a dialed vsock connection, which is driven by the runtime poller through os.File as the net
+// package has no vsock support.
+type vsockConn struct {
+	*os.File
+	laddr, raddr *VsockAddr
+}
+
+func (c *vsockConn) LocalAddr() net.Addr  { return c.laddr }
+func (c *vsockConn) RemoteAddr() net.Addr { return c.raddr }
@@ -0,0 +1,36 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// winPipePrefix is the namespace of the local named pipes on Windows.
+const winPipePrefix = `\\.\pipe\`
+
+// winPipePath returns the path of the named pipe with the given name, a name which is already a path is kept.
+func winPipePath(name string) string {
+	if strings.HasPrefix(name, `\\`) {
+		return name
+	}
+	return winPipePrefix + name
+}
+
+// winPipeAddr is the address of a named pipe on Windows.
+type winPipeAddr string
+
+func (a winPipeAddr) Network() string { return "winpipe" }
+func (a winPipeAddr) String() string  { return string(a) }
+
+// DialWinPipe connects to the server serving on the named pipe with the given name on Windows, e.g.
+// `winpipe://app` is dialed by DialWinPipe("app") or DialWinPipe(`\\.\pipe\app`). It waits up to the timeout
+// while all the instances of the pipe are busy, zero means the default timeout of the pipe. It fails with
+// ErrProtocolNotSupported on other platforms.
+func DialWinPipe(name string, timeout time.Duration) (net.Conn, error) {
+	return dialWinPipe(winPipePath(name), timeout)
+}
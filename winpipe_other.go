@@ -0,0 +1,21 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build !windows
+
+package gnet
+
+import (
+	"net"
+	"time"
+)
+
+// listenWinPipe fails as the named pipes are only available on Windows.
+func (ln *listener) listenWinPipe() error {
+	return ErrProtocolNotSupported
+}
+
+func dialWinPipe(path string, timeout time.Duration) (net.Conn, error) {
+	return nil, ErrProtocolNotSupported
+}
@@ -0,0 +1,375 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// +build windows
+
+package gnet
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modkernel32 = windows.NewLazySystemDLL("kernel32.dll")
+
+	procCreateNamedPipeW = modkernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe = modkernel32.NewProc("ConnectNamedPipe")
+	procWaitNamedPipeW   = modkernel32.NewProc("WaitNamedPipeW")
+)
+
+const (
+	pipeAccessDuplex         = 0x3
+	pipeRejectRemoteClients  = 0x8
+	pipeUnlimitedInstances   = 255
+	winPipeBufferSize        = 0x10000
+	winPipeTypeByteReadWait  = 0 // PIPE_TYPE_BYTE | PIPE_READMODE_BYTE | PIPE_WAIT
+	winPipeDefaultDialWaitMs = 0 // NMPWAIT_USE_DEFAULT_WAIT
+)
+
+var errWinPipeClosed = errors.New("use of closed named pipe")
+
+// winPipeTimeout is the error of the operations on a named pipe past their deadlines.
+type winPipeTimeout struct{}
+
+func (winPipeTimeout) Error() string   { return "i/o timeout" }
+func (winPipeTimeout) Timeout() bool   { return true }
+func (winPipeTimeout) Temporary() bool { return true }
+
+// listenWinPipe sets up a listener on the named pipe, the first instance of the pipe is created right away so
+// that serving on a pipe which is in use fails.
+func (ln *listener) listenWinPipe() error {
+	path := winPipePath(ln.addr)
+	h, err := createWinPipe(path, true)
+	if err != nil {
+		return &net.OpError{Op: "listen", Net: "winpipe", Addr: winPipeAddr(path), Err: err}
+	}
+	op, err := newWinPipeIO()
+	if err != nil {
+		sniffError(windows.CloseHandle(h))
+		return err
+	}
+	ln.ln = &winPipeListener{path: path, next: h, op: op}
+	ln.lnaddr = ln.ln.Addr()
+	return nil
+}
+
+func createWinPipe(path string, first bool) (windows.Handle, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return windows.InvalidHandle, err
+	}
+	mode := uint32(pipeAccessDuplex | windows.FILE_FLAG_OVERLAPPED)
+	if first {
+		mode |= windows.FILE_FLAG_FIRST_PIPE_INSTANCE
+	}
+	r, _, e := procCreateNamedPipeW.Call(uintptr(unsafe.Pointer(name)), uintptr(mode),
+		winPipeTypeByteReadWait|pipeRejectRemoteClients, pipeUnlimitedInstances,
+		winPipeBufferSize, winPipeBufferSize, 0, 0)
+	if h := windows.Handle(r); h != windows.InvalidHandle {
+		return h, nil
+	}
+	return windows.InvalidHandle, e
+}
+
+func dialWinPipe(path string, timeout time.Duration) (net.Conn, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		h, err := windows.CreateFile(name, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil,
+			windows.OPEN_EXISTING, windows.FILE_FLAG_OVERLAPPED, 0)
+		if err == nil {
+			return newWinPipeConn(h, path)
+		}
+		if err != windows.ERROR_PIPE_BUSY {
+			return nil, &net.OpError{Op: "dial", Net: "winpipe", Addr: winPipeAddr(path), Err: err}
+		}
+		// All the instances are busy, wait for one to be created by the next Accept.
+		wait := uintptr(winPipeDefaultDialWaitMs)
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return nil, &net.OpError{Op: "dial", Net: "winpipe", Addr: winPipeAddr(path), Err: winPipeTimeout{}}
+			}
+			wait = uintptr(left/time.Millisecond + 1)
+		}
+		_, _, _ = procWaitNamedPipeW.Call(uintptr(unsafe.Pointer(name)), wait)
+	}
+}
+
+// winPipeListener accepts the clients of a named pipe, every client is connected to an instance of the pipe
+// which is created ahead of it.
+type winPipeListener struct {
+	path   string
+	mu     sync.Mutex // serializes Accept and Close
+	next   windows.Handle
+	op     *winPipeIO
+	closed bool
+}
+
+func (l *winPipeListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for {
+		if l.closed {
+			return nil, errWinPipeClosed
+		}
+		if l.next == windows.InvalidHandle {
+			h, err := createWinPipe(l.path, false)
+			if err != nil {
+				return nil, &net.OpError{Op: "accept", Net: "winpipe", Addr: l.Addr(), Err: err}
+			}
+			l.next = h
+		}
+		h := l.next
+		_, err := l.op.do(h, func(o *windows.Overlapped) error {
+			r, _, e := procConnectNamedPipe.Call(uintptr(h), uintptr(unsafe.Pointer(o)))
+			if r != 0 {
+				return nil
+			}
+			return e
+		})
+		switch err {
+		case nil, windows.ERROR_PIPE_CONNECTED:
+			l.next, _ = createWinPipe(l.path, false)
+			return newWinPipeConn(h, l.path)
+		case errWinPipeClosed:
+			return nil, err
+		case windows.ERROR_NO_DATA:
+			// The client has gone before being accepted.
+			sniffError(windows.CloseHandle(h))
+			l.next = windows.InvalidHandle
+		default:
+			return nil, &net.OpError{Op: "accept", Net: "winpipe", Addr: l.Addr(), Err: err}
+		}
+	}
+}
+
+func (l *winPipeListener) Close() error {
+	l.op.close()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.closed = true
+		if l.next != windows.InvalidHandle {
+			sniffError(windows.CloseHandle(l.next))
+			l.next = windows.InvalidHandle
+		}
+	}
+	return nil
+}
+
+func (l *winPipeListener) Addr() net.Addr {
+	return winPipeAddr(l.path)
+}
+
+// winPipeConn is a connected instance of a named pipe. The pipe handles are not associated with the I/O
+// completion port of the runtime, every operation is overlapped and waited for by its goroutine so that it can
+// be canceled by a deadline or Close.
+type winPipeConn struct {
+	h     windows.Handle
+	path  string
+	rd    *winPipeIO
+	wr    *winPipeIO
+	close sync.Once
+}
+
+func newWinPipeConn(h windows.Handle, path string) (net.Conn, error) {
+	rd, err := newWinPipeIO()
+	if err != nil {
+		sniffError(windows.CloseHandle(h))
+		return nil, err
+	}
+	wr, err := newWinPipeIO()
+	if err != nil {
+		rd.close()
+		sniffError(windows.CloseHandle(h))
+		return nil, err
+	}
+	return &winPipeConn{h: h, path: path, rd: rd, wr: wr}, nil
+}
+
+func (c *winPipeConn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n, err := c.rd.do(c.h, func(o *windows.Overlapped) error {
+		return windows.ReadFile(c.h, b, nil, o)
+	})
+	switch err {
+	case nil:
+		return int(n), nil
+	case windows.ERROR_BROKEN_PIPE, windows.ERROR_PIPE_NOT_CONNECTED:
+		return 0, io.EOF
+	}
+	return 0, &net.OpError{Op: "read", Net: "winpipe", Addr: c.LocalAddr(), Err: err}
+}
+
+func (c *winPipeConn) Write(b []byte) (written int, err error) {
+	for written < len(b) {
+		p := b[written:]
+		n, err := c.wr.do(c.h, func(o *windows.Overlapped) error {
+			return windows.WriteFile(c.h, p, nil, o)
+		})
+		written += int(n)
+		if err != nil {
+			return written, &net.OpError{Op: "write", Net: "winpipe", Addr: c.LocalAddr(), Err: err}
+		}
+	}
+	return written, nil
+}
+
+func (c *winPipeConn) Close() error {
+	c.close.Do(func() {
+		c.rd.close()
+		c.wr.close()
+		sniffError(windows.CloseHandle(c.h))
+	})
+	return nil
+}
+
+func (c *winPipeConn) LocalAddr() net.Addr  { return winPipeAddr(c.path) }
+func (c *winPipeConn) RemoteAddr() net.Addr { return winPipeAddr(c.path) }
+
+func (c *winPipeConn) SetDeadline(t time.Time) error {
+	c.rd.setDeadline(t)
+	c.wr.setDeadline(t)
+	return nil
+}
+
+func (c *winPipeConn) SetReadDeadline(t time.Time) error {
+	c.rd.setDeadline(t)
+	return nil
+}
+
+func (c *winPipeConn) SetWriteDeadline(t time.Time) error {
+	c.wr.setDeadline(t)
+	return nil
+}
+
+// winPipeIO runs the overlapped operations of one kind on a pipe one at a time, the pending operation is
+// canceled when its deadline passes or the pipe is closed.
+type winPipeIO struct {
+	mu       sync.Mutex
+	o        windows.Overlapped
+	h        windows.Handle // the handle of the pending operation, 0 if there is none
+	deadline time.Time
+	timer    *time.Timer
+	closed   bool
+	inflight sync.WaitGroup
+}
+
+func newWinPipeIO() (*winPipeIO, error) {
+	ev, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	op := new(winPipeIO)
+	op.o.HEvent = ev
+	return op, nil
+}
+
+func (op *winPipeIO) expired() bool {
+	return !op.deadline.IsZero() && !time.Now().Before(op.deadline)
+}
+
+// do issues the operation and waits for it to complete, it fails with winPipeTimeout if the deadline passes
+// and with errWinPipeClosed if the pipe is closed in the meantime.
+func (op *winPipeIO) do(h windows.Handle, issue func(o *windows.Overlapped) error) (uint32, error) {
+	op.mu.Lock()
+	if op.closed {
+		op.mu.Unlock()
+		return 0, errWinPipeClosed
+	}
+	if op.expired() {
+		op.mu.Unlock()
+		return 0, winPipeTimeout{}
+	}
+	// The operation is issued under the lock so that a deadline or Close can't miss it.
+	if err := issue(&op.o); err != nil && err != windows.ERROR_IO_PENDING {
+		op.mu.Unlock()
+		return 0, err
+	}
+	op.h = h
+	op.inflight.Add(1)
+	op.mu.Unlock()
+	defer op.inflight.Done()
+
+	var n uint32
+	err := windows.GetOverlappedResult(h, &op.o, &n, true)
+	op.mu.Lock()
+	op.h = 0
+	closed, expired := op.closed, op.expired()
+	op.mu.Unlock()
+	if err == windows.ERROR_OPERATION_ABORTED {
+		switch {
+		case closed:
+			err = errWinPipeClosed
+		case expired:
+			err = winPipeTimeout{}
+		}
+	}
+	return n, err
+}
+
+// cancel cancels the pending operation, it must be invoked with the lock held.
+func (op *winPipeIO) cancel() {
+	if op.h != 0 {
+		_ = windows.CancelIoEx(op.h, &op.o)
+	}
+}
+
+func (op *winPipeIO) setDeadline(t time.Time) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.deadline = t
+	if op.timer != nil {
+		op.timer.Stop()
+		op.timer = nil
+	}
+	if t.IsZero() {
+		return
+	}
+	d := time.Until(t)
+	if d <= 0 {
+		op.cancel()
+		return
+	}
+	op.timer = time.AfterFunc(d, func() {
+		op.mu.Lock()
+		if op.expired() {
+			op.cancel()
+		}
+		op.mu.Unlock()
+	})
+}
+
+// close cancels the pending operation and waits for it before releasing the event.
+func (op *winPipeIO) close() {
+	op.mu.Lock()
+	if op.closed {
+		op.mu.Unlock()
+		return
+	}
+	op.closed = true
+	op.cancel()
+	if op.timer != nil {
+		op.timer.Stop()
+	}
+	op.mu.Unlock()
+	op.inflight.Wait()
+	sniffError(windows.CloseHandle(op.o.HEvent))
+}
@@ -0,0 +1,17 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"net"
+
+	"github.com/panlibin/gnet/internal/netpoll"
+)
+
+// NewLoopbackFastPathDialer returns a dialer whose sockets enable SIO_LOOPBACK_FAST_PATH, so that its loopback
+// connections to a server set up by WithLoopbackFastPath take the fast path, Windows only.
+func NewLoopbackFastPathDialer() *net.Dialer {
+	return &net.Dialer{Control: sockoptControl(netpoll.SetLoopbackFastPath)}
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 Andy Pan. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package gnet
+
+import (
+	"io"
+	"sync"
+)
+
+const (
+	defaultStreamHighWatermark = 1 << 20
+	defaultStreamChunkSize     = 64 * 1024
+)
+
+// StreamWriter sets up the writers returned by Conn.Writer.
+type StreamWriter struct {
+	// HighWatermark is the number of bytes pending on a connection above which writes are held back,
+	// defaults to 1MiB. The pending bytes include the data queued by the writer and the outbound buffer.
+	HighWatermark int
+
+	// ChunkSize is the maximum number of bytes handed to the event-loop at a time, defaults to 64KiB.
+	ChunkSize int
+
+	// Nonblocking makes writes fail with ErrWriterFull instead of blocking above the high watermark.
+	Nonblocking bool
+}
+
+// connWriter streams data to a connection from any goroutine, the chunks are handed to the event-loop
+// by submit which reports back how many bytes are still pending by settle.
+type connWriter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	conf     StreamWriter
+	queued   int   // bytes handed to the event-loop but not written or buffered yet
+	buffered int   // bytes in the outbound buffer of the connection
+	err      error // sticky error, the writer is broken once it is set
+	submit   func(chunk []byte) error
+}
+
+func newConnWriter(conf StreamWriter, submit func(chunk []byte) error) *connWriter {
+	if conf.HighWatermark <= 0 {
+		conf.HighWatermark = defaultStreamHighWatermark
+	}
+	if conf.ChunkSize <= 0 {
+		conf.ChunkSize = defaultStreamChunkSize
+	}
+	w := &connWriter{conf: conf, submit: submit}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+// Write implements io.Writer, the data bypasses the codec.
+func (w *connWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > w.conf.ChunkSize {
+			chunk = chunk[:w.conf.ChunkSize]
+		}
+
+		if err = w.reserve(len(chunk)); err != nil {
+			return
+		}
+		if err = w.submit(append([]byte(nil), chunk...)); err != nil {
+			w.fail(err)
+			return
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return
+}
+
+// reserve waits until the pending bytes are below the high watermark and queues size bytes.
+func (w *connWriter) reserve(size int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.err == nil && w.queued+w.buffered >= w.conf.HighWatermark {
+		if w.conf.Nonblocking {
+			return ErrWriterFull
+		}
+		w.cond.Wait()
+	}
+	if w.err != nil {
+		return w.err
+	}
+	w.queued += size
+	return nil
+}
+
+// drain waits until the event-loop has taken all the queued bytes.
+func (w *connWriter) drain() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.err == nil && w.queued > 0 {
+		w.cond.Wait()
+	}
+	return w.err
+}
+
+// settle is invoked by the event-loop after written bytes of the queued chunks have been written or buffered,
+// buffered is the current length of the outbound buffer.
+func (w *connWriter) settle(written, buffered int) {
+	w.mu.Lock()
+	w.queued -= written
+	w.buffered = buffered
+	w.mu.Unlock()
+	w.cond.Broadcast()
+}
+
+// fail breaks the writer and wakes up the blocked writes.
+func (w *connWriter) fail(err error) {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.mu.Unlock()
+	w.cond.Broadcast()
+}
+
+// writeInboundTo writes the inbound data buffered by the connection to w and discards what has been written.
+func writeInboundTo(c Conn, w io.Writer) (n int64, err error) {
+	buf := c.Read()
+	if len(buf) == 0 {
+		return
+	}
+	m, err := w.Write(buf)
+	if m > 0 {
+		c.ShiftN(m)
+	}
+	return int64(m), err
+}